@@ -0,0 +1,382 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+const (
+	// DefaultAckBatch is the default maximum number of data frames
+	// processed by a consumer before it acknowledges them.
+	DefaultAckBatch = 64
+
+	// DefaultAckTimeout is the default delay without acknowledgement
+	// after which a producer retransmits its unacknowledged data frames.
+	DefaultAckTimeout = 5 * time.Second
+)
+
+// ackHdrSize is the size of the header of an acknowledged frame payload:
+//   - sequence number of the frame (u64)
+//   - sequence number of the previous unacknowledged frame (u64, 0 if none)
+const ackHdrSize = 8 + 8
+
+// ackLogName returns the name of the log of unacknowledged data frames of
+// the named end-point.
+func ackLogName(dir, ep string) string {
+	return epFileName(dir, ep, ".unacked")
+}
+
+// decodeAcked returns the sequence numbers and the data frame carried by
+// the provided acknowledged frame.
+func decodeAcked(frame Frame) (seq, prev uint64, data Frame, err error) {
+	if len(frame.Body) < ackHdrSize {
+		return 0, 0, data, fmt.Errorf("invalid acked frame size (got=%d, want>=%d)", len(frame.Body), ackHdrSize)
+	}
+	seq = binary.LittleEndian.Uint64(frame.Body[0:8])
+	prev = binary.LittleEndian.Uint64(frame.Body[8:16])
+	data = Frame{Type: FrameData, Path: frame.Path, Body: frame.Body[ackHdrSize:]}
+	return seq, prev, data, nil
+}
+
+// acklog is the log of the data frames of an output end-point delivered in
+// acknowledged mode, that were not acknowledged yet by all its consumers.
+//
+// Each data frame is given a sequence number and is written to the log before
+// being sent. Consumers acknowledge the highest sequence number up to which
+// they processed all data frames, and acknowledged frames are removed from
+// the log.
+// Each sent frame also carries the sequence number of the frame preceding it
+// in the log, so consumers can detect missing frames.
+//
+// Unacknowledged frames are retransmitted, in order, when a consumer
+// (re)connects, when no acknowledgement was received within a timeout, and
+// after the producer restarted, since the log is kept on local disk.
+type acklog struct {
+	name    string
+	sp      *spool
+	msg     log.MsgStream
+	peers   *peers           // consumers connected to the output port
+	timeout time.Duration    // retransmission timeout
+	now     func() time.Time // current time
+
+	mu     sync.Mutex
+	seq    uint64            // sequence number of the last logged frame
+	seqs   []uint64          // sequence numbers of the logged frames, oldest first
+	acks   map[string]uint64 // last sequence number acknowledged by each consumer
+	gen    uint32            // consumer connections seen at the last retransmission
+	resend bool              // whether logged frames must be retransmitted
+	last   time.Time         // time of the last acknowledgement or retransmission
+}
+
+func newAckLog(dir, ep string, timeout time.Duration, msg log.MsgStream, now func() time.Time) (*acklog, error) {
+	sp, err := openSpool(dir, ackLogName(dir, ep), ep, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open log of unacknowledged frames: %w", err)
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultAckTimeout
+	}
+
+	l := &acklog{
+		name:    ep,
+		sp:      sp,
+		msg:     msg,
+		peers:   new(peers),
+		timeout: timeout,
+		now:     now,
+		acks:    make(map[string]uint64),
+		last:    now(),
+	}
+
+	err = sp.each(func(frame Frame) error {
+		seq, _, _, err := decodeAcked(frame)
+		if err != nil {
+			return err
+		}
+		l.seqs = append(l.seqs, seq)
+		return nil
+	})
+	if err != nil {
+		_ = sp.close()
+		return nil, fmt.Errorf("could not scan log of unacknowledged frames for %q: %w", ep, err)
+	}
+
+	// sequence numbers must keep increasing across restarts of the producer,
+	// even when the log was fully drained.
+	l.seq = uint64(time.Now().UnixNano())
+	if n := len(l.seqs); n > 0 {
+		if l.seqs[n-1] > l.seq {
+			l.seq = l.seqs[n-1]
+		}
+		l.resend = true
+	}
+
+	return l, nil
+}
+
+// pending returns the number of unacknowledged data frames.
+func (l *acklog) pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.seqs)
+}
+
+// send logs the provided data frame and sends it with deliver, after
+// the unacknowledged frames if those need to be retransmitted.
+// Frames that could not be sent are kept for a later retransmission.
+func (l *acklog) send(frame Frame, deliver func(frame Frame) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.mustResend() {
+		l.retransmit(deliver)
+	}
+
+	body := make([]byte, ackHdrSize+len(frame.Body))
+	binary.LittleEndian.PutUint64(body[0:8], l.seq+1)
+	copy(body[ackHdrSize:], frame.Body)
+	msg := Frame{Type: FrameAcked, Path: frame.Path, Body: body, EventID: frame.EventID, Trace: frame.Trace}
+
+	err := l.sp.push(msg)
+	if err != nil {
+		return fmt.Errorf("could not log data frame for %q: %w", l.name, err)
+	}
+	l.seq++
+
+	var prev uint64
+	if n := len(l.seqs); n > 0 {
+		prev = l.seqs[n-1]
+	}
+	l.seqs = append(l.seqs, l.seq)
+
+	if l.resend {
+		// keep frames in order: this frame will be sent with the others.
+		return nil
+	}
+
+	binary.LittleEndian.PutUint64(body[8:16], prev)
+	err = deliver(msg)
+	if err != nil {
+		l.resend = true
+		l.msg.Warnf("could not send data frame for %q, will retransmit: %+v", l.name, err)
+	}
+	return nil
+}
+
+// mustResend returns whether the unacknowledged frames must be retransmitted.
+func (l *acklog) mustResend() bool {
+	gen := l.peers.attached()
+	switch {
+	case len(l.seqs) == 0:
+		l.gen = gen
+		l.resend = false
+		return false
+	case gen != l.gen, l.resend:
+		return true
+	default:
+		return l.now().Sub(l.last) > l.timeout
+	}
+}
+
+// retransmit sends all the unacknowledged frames, in order, if a consumer
+// is connected.
+func (l *acklog) retransmit(deliver func(frame Frame) error) {
+	if !l.peers.connected() {
+		return
+	}
+
+	var (
+		gen  = l.peers.attached()
+		prev uint64
+		n    int
+	)
+	err := l.sp.each(func(frame Frame) error {
+		binary.LittleEndian.PutUint64(frame.Body[8:16], prev)
+		prev = binary.LittleEndian.Uint64(frame.Body[0:8])
+		n++
+		return deliver(frame)
+	})
+	l.last = l.now()
+	if err != nil {
+		l.resend = true
+		l.msg.Warnf("could not retransmit unacknowledged data frames for %q: %+v", l.name, err)
+		return
+	}
+	l.gen = gen
+	l.resend = false
+	l.msg.Debugf("retransmitted %d unacknowledged data frames for %q", n, l.name)
+}
+
+// ack records that the named consumer processed all the data frames up to
+// the provided sequence number, and removes from the log the frames
+// acknowledged by all consumers.
+func (l *acklog) ack(consumer string, seq uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if v, ok := l.acks[consumer]; ok && seq <= v {
+		return nil
+	}
+	l.acks[consumer] = seq
+
+	min := seq
+	for _, v := range l.acks {
+		if v < min {
+			min = v
+		}
+	}
+
+	n := sort.Search(len(l.seqs), func(i int) bool { return l.seqs[i] > min })
+	if n == 0 {
+		return nil
+	}
+	l.last = l.now()
+	l.seqs = append(l.seqs[:0], l.seqs[n:]...)
+	err := l.sp.skip(n)
+	if err != nil {
+		return fmt.Errorf("could not remove acknowledged frames for %q: %w", l.name, err)
+	}
+	return nil
+}
+
+func (l *acklog) close() error {
+	return l.sp.close()
+}
+
+// handle processes an acknowledgement frame received from a consumer.
+func (l *acklog) handle(frame Frame) error {
+	consumer, seq, err := decodeAck(frame)
+	if err != nil {
+		return fmt.Errorf("could not decode acknowledgement: %w", err)
+	}
+	return l.ack(consumer, seq)
+}
+
+// encodeAck returns the acknowledgement by the named consumer of all the
+// data frames of the named end-point up to the provided sequence number.
+func encodeAck(ep, consumer string, seq uint64) []byte {
+	eb := getEncBuffer()
+	defer eb.release()
+	enc := &eb.enc
+	enc.WriteStr(consumer)
+	enc.WriteU64(seq)
+	return Frame{Type: FrameOK, Path: ep, Body: eb.buf.Bytes()}.encode()
+}
+
+func decodeAck(frame Frame) (string, uint64, error) {
+	dec := NewDecoder(bytes.NewReader(frame.Body))
+	consumer := dec.ReadStr()
+	seq := dec.ReadU64()
+	return consumer, seq, dec.Err()
+}
+
+// acker acknowledges the data frames received by an input end-point
+// delivered in acknowledged mode, and discards duplicate frames.
+//
+// Processed frames are acknowledged in batches, or as soon as no more frames
+// are waiting to be processed.
+// Frames received after a missing frame are discarded until the producer
+// retransmits the missing one.
+type acker struct {
+	name  string // name of the consumer
+	ep    string // name of the input end-point
+	sck   Sender // socket connected to the ack socket of the producer
+	batch int
+	cnt   *epCounter // counters of the input end-point (may be nil)
+
+	mu   sync.Mutex
+	last uint64 // sequence number of the last processed frame
+	n    int    // number of processed frames not yet acknowledged
+}
+
+// recv returns the data frame carried by the provided acknowledged frame,
+// and whether it should be processed.
+// A nil acker processes all frames.
+func (a *acker) recv(frame Frame) (Frame, bool, error) {
+	seq, prev, data, err := decodeAcked(frame)
+	if err != nil {
+		return data, false, err
+	}
+	if a == nil {
+		return data, true, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case seq <= a.last:
+		// duplicate: acknowledge it again, so the producer may release it.
+		a.cnt.dup()
+		return data, false, a.send(a.last)
+	case prev != 0 && prev > a.last:
+		// missing frame: wait for its retransmission.
+		return data, false, nil
+	}
+	a.last = seq
+	return data, true, nil
+}
+
+// done records that a data frame was processed.
+// idle indicates whether no more frames are waiting to be processed.
+func (a *acker) done(idle bool) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.n++
+	if a.n < a.batch && !idle {
+		return nil
+	}
+	return a.flush()
+}
+
+// flush acknowledges all the processed data frames.
+func (a *acker) flush() error {
+	if a.n == 0 {
+		return nil
+	}
+	a.n = 0
+	return a.send(a.last)
+}
+
+func (a *acker) send(seq uint64) error {
+	err := a.sck.Send(encodeAck(a.ep, a.name, seq))
+	if err != nil {
+		return fmt.Errorf("could not acknowledge data frames for %q: %w", a.ep, err)
+	}
+	return nil
+}
+
+func (a *acker) close() error {
+	if c, ok := a.sck.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// end acknowledges the remaining processed data frames, at the end of
+// a stream.
+func (a *acker) end() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.flush()
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+	"go.nanomsg.org/mangos/v3"
+)
+
+type ackSender struct {
+	acks []uint64
+}
+
+func (s *ackSender) Send(msg []byte) error {
+	frame, err := RecvFrame(context.Background(), rawMsg(msg))
+	if err != nil {
+		return err
+	}
+	_, seq, err := decodeAck(frame)
+	if err != nil {
+		return err
+	}
+	s.acks = append(s.acks, seq)
+	return nil
+}
+
+func TestAckLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdaq-acks-")
+	if err != nil {
+		t.Fatalf("could not create ack log dir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	msg := log.NewMsgStream("acks", log.LvlError, ioutil.Discard)
+
+	open := func() *acklog {
+		l, err := newAckLog(dir, "/adc", time.Hour, msg, time.Now)
+		if err != nil {
+			t.Fatalf("could not open ack log: %+v", err)
+		}
+		l.peers.hook(mangos.PipeEventAttached, nil)
+		return l
+	}
+
+	var (
+		prod = open()
+		sck  = new(ackSender)
+		cons = &acker{name: "sink", ep: "/adc", sck: sck, batch: 2}
+		got  []string
+		lost = true // whether the transport loses the next frame
+	)
+	deliver := func(frame Frame) error {
+		if lost {
+			lost = false
+			return nil
+		}
+		data, ok, err := cons.recv(frame)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		got = append(got, string(data.Body))
+		return cons.done(false)
+	}
+
+	for _, v := range []string{"frame-1", "frame-2", "frame-3"} {
+		err = prod.send(Frame{Type: FrameData, Path: "/adc", Body: []byte(v)}, deliver)
+		if err != nil {
+			t.Fatalf("could not send %q: %+v", v, err)
+		}
+	}
+
+	// frame-1 was lost: the following ones are discarded until retransmission.
+	if len(got) != 0 {
+		t.Fatalf("frames after a missing frame should be discarded: %q", got)
+	}
+	if got, want := prod.pending(), 3; got != want {
+		t.Fatalf("invalid number of unacknowledged frames: got=%d, want=%d", got, want)
+	}
+
+	// unacknowledged frames survive a restart of the producer.
+	err = prod.close()
+	if err != nil {
+		t.Fatalf("could not close ack log: %+v", err)
+	}
+	prod = open()
+	defer prod.close()
+
+	err = prod.send(Frame{Type: FrameData, Path: "/adc", Body: []byte("frame-4")}, deliver)
+	if err != nil {
+		t.Fatalf("could not send frame-4: %+v", err)
+	}
+
+	want := []string{"frame-1", "frame-2", "frame-3", "frame-4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid received frames:\ngot = %q\nwant= %q\n", got, want)
+	}
+	if len(sck.acks) != 2 {
+		t.Fatalf("invalid number of acknowledgements: got=%d, want=2", len(sck.acks))
+	}
+	for _, seq := range sck.acks {
+		err = prod.ack("sink", seq)
+		if err != nil {
+			t.Fatalf("could not process acknowledgement: %+v", err)
+		}
+	}
+	if got, want := prod.pending(), 0; got != want {
+		t.Fatalf("invalid number of unacknowledged frames: got=%d, want=%d", got, want)
+	}
+
+	// duplicates are discarded, and acknowledged again.
+	_, ok, err := cons.recv(Frame{Type: FrameAcked, Path: "/adc", Body: make([]byte, ackHdrSize)})
+	if err != nil {
+		t.Fatalf("could not receive duplicate frame: %+v", err)
+	}
+	if ok {
+		t.Fatalf("duplicate frame should be discarded")
+	}
+	if got, want := len(sck.acks), 3; got != want {
+		t.Fatalf("invalid number of acknowledgements: got=%d, want=%d", got, want)
+	}
+}
@@ -0,0 +1,472 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+)
+
+// Severity is the severity of an alarm.
+type Severity uint8
+
+const (
+	SevWarning  Severity = iota + 1 // condition that needs attention
+	SevMinor                        // condition degrading data taking
+	SevMajor                        // condition compromising data taking
+	SevCritical                     // condition preventing data taking
+)
+
+func (sev Severity) String() string {
+	switch sev {
+	case SevWarning:
+		return "warning"
+	case SevMinor:
+		return "minor"
+	case SevMajor:
+		return "major"
+	case SevCritical:
+		return "critical"
+	default:
+		return fmt.Sprintf("Severity(%d)", uint8(sev))
+	}
+}
+
+func (sev Severity) MarshalText() ([]byte, error) {
+	return []byte(sev.String()), nil
+}
+
+func (sev *Severity) UnmarshalText(p []byte) error {
+	for _, v := range []Severity{SevWarning, SevMinor, SevMajor, SevCritical} {
+		if string(p) == v.String() {
+			*sev = v
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid alarm severity %q", p)
+}
+
+// AlarmFrame is the message sent by a TDAQ process to run-ctl to raise or
+// clear an alarm.
+type AlarmFrame struct {
+	Name     string   // name of the tdaq process
+	Alarm    string   // identifier of the alarm, unique within the process
+	Severity Severity // severity of the alarm
+	Active   bool     // whether the alarm is raised (true) or cleared (false)
+	Text     string   // description of the alarm condition
+	Halt     bool     // whether run-ctl should stop the current run
+}
+
+func (frame AlarmFrame) MarshalTDAQ() ([]byte, error) {
+	return marshalTDAQ(frame)
+}
+
+func (frame AlarmFrame) encodeTDAQ(enc *Encoder) {
+	enc.WriteStr(frame.Name)
+	enc.WriteStr(frame.Alarm)
+	enc.WriteU8(uint8(frame.Severity))
+	enc.WriteBool(frame.Active)
+	enc.WriteStr(frame.Text)
+	enc.WriteBool(frame.Halt)
+}
+
+func (frame *AlarmFrame) UnmarshalTDAQ(p []byte) error {
+	r := bytes.NewReader(p)
+	dec := NewDecoder(r)
+	frame.Name = dec.ReadStr()
+	frame.Alarm = dec.ReadStr()
+	frame.Severity = Severity(dec.ReadU8())
+	frame.Active = dec.ReadBool()
+	frame.Text = dec.ReadStr()
+	frame.Halt = false
+	if dec.err == nil && r.Len() > 0 {
+		frame.Halt = dec.ReadBool()
+	}
+	return dec.Err()
+}
+
+// Alarms raises and clears the alarms of a TDAQ process.
+//
+// Alarms are forwarded to run-ctl, where they are displayed until they are
+// both cleared by the process and acknowledged by an operator.
+// Alarms still active when run-ctl (re)configures the process are sent again.
+// A nil Alarms discards all alarms.
+type Alarms struct {
+	msg *msgstream
+
+	mu     sync.Mutex
+	active map[string]AlarmFrame
+}
+
+func newAlarms(msg *msgstream) *Alarms {
+	return &Alarms{msg: msg, active: make(map[string]AlarmFrame)}
+}
+
+// Raise raises (or updates) the named alarm with the provided severity and
+// description.
+func (a *Alarms) Raise(name string, sev Severity, format string, args ...interface{}) {
+	a.raise(name, sev, false, format, args...)
+}
+
+// Halt raises the named alarm as critical, and requests run-ctl to stop the
+// current run, for conditions corrupting the recorded data.
+func (a *Alarms) Halt(name string, format string, args ...interface{}) {
+	a.raise(name, SevCritical, true, format, args...)
+}
+
+func (a *Alarms) raise(name string, sev Severity, halt bool, format string, args ...interface{}) {
+	if a == nil {
+		return
+	}
+
+	frame := AlarmFrame{
+		Name:     strings.TrimSpace(a.msg.n),
+		Alarm:    name,
+		Severity: sev,
+		Active:   true,
+		Text:     fmt.Sprintf(format, args...),
+		Halt:     halt,
+	}
+
+	a.mu.Lock()
+	a.active[name] = frame
+	a.mu.Unlock()
+
+	a.msg.Warnf("alarm %q raised (%v): %s", name, sev, frame.Text)
+	a.msg.alarm(frame)
+}
+
+// Clear clears the named alarm.
+// Clearing an alarm that is not active is a no-op.
+func (a *Alarms) Clear(name string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	frame, ok := a.active[name]
+	delete(a.active, name)
+	a.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	frame.Active = false
+	frame.Halt = false
+	a.msg.Infof("alarm %q cleared", name)
+	a.msg.alarm(frame)
+}
+
+// resend sends all active alarms again.
+func (a *Alarms) resend() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	frames := make([]AlarmFrame, 0, len(a.active))
+	for _, frame := range a.active {
+		frames = append(frames, frame)
+	}
+	a.mu.Unlock()
+
+	for _, frame := range frames {
+		a.msg.alarm(frame)
+	}
+}
+
+func (msg *msgstream) alarm(frame AlarmFrame) {
+	msg.mu.Lock()
+	sck := msg.sck
+	msg.mu.Unlock()
+
+	if sck == nil {
+		return
+	}
+
+	_ = sendEncoded(context.Background(), sck, FrameMsg, pathAlarm, nil, frame)
+}
+
+// Alarm is an alarm, as tracked by run-ctl.
+type Alarm struct {
+	Proc     string    `json:"proc"`               // name of the process that raised the alarm
+	ID       string    `json:"id"`                 // identifier of the alarm, unique within the process
+	Severity Severity  `json:"severity"`           // severity of the alarm
+	Text     string    `json:"text"`               // description of the alarm condition
+	Active   bool      `json:"active"`             // whether the alarm condition is still present
+	Raised   time.Time `json:"raised"`             // time at which the alarm was (last) raised
+	Cleared  time.Time `json:"cleared,omitempty"`  // time at which the alarm was cleared
+	Acked    bool      `json:"acked"`              // whether the alarm was acknowledged by an operator
+	AckedBy  string    `json:"acked-by,omitempty"` // operator who acknowledged the alarm
+	AckedAt  time.Time `json:"acked-at,omitempty"` // time at which the alarm was acknowledged
+}
+
+// alarmDB holds the alarms tracked by run-ctl.
+//
+// An alarm is tracked until it is both cleared and acknowledged.
+// Raising an already active alarm with a higher severity requires a new
+// acknowledgment.
+// When a file name is provided, the alarms are persisted to that file
+// after each change, and restored from it at creation.
+type alarmDB struct {
+	fname string
+	feed  *feed
+	now   func() time.Time
+
+	mu     sync.RWMutex
+	alarms map[alarmID]*Alarm
+}
+
+type alarmID struct {
+	proc string
+	id   string
+}
+
+func newAlarmDB(fname string, feed *feed) (*alarmDB, error) {
+	db := &alarmDB{
+		fname:  fname,
+		feed:   feed,
+		now:    time.Now,
+		alarms: make(map[alarmID]*Alarm),
+	}
+	if fname == "" {
+		return db, nil
+	}
+
+	raw, err := ioutil.ReadFile(fname)
+	switch {
+	case err == nil:
+		var alarms []Alarm
+		err = json.Unmarshal(raw, &alarms)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode alarms file %q: %w", fname, err)
+		}
+		for i := range alarms {
+			alarm := alarms[i]
+			db.alarms[alarmID{alarm.Proc, alarm.ID}] = &alarm
+		}
+	case os.IsNotExist(err):
+		// ok.
+	default:
+		return nil, fmt.Errorf("could not read alarms file %q: %w", fname, err)
+	}
+
+	return db, nil
+}
+
+// update records an alarm raised or cleared by a process.
+func (db *alarmDB) update(frame AlarmFrame) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var (
+		now = db.now().UTC()
+		key = alarmID{frame.Name, frame.Alarm}
+	)
+
+	alarm, ok := db.alarms[key]
+	switch {
+	case frame.Active && !ok:
+		alarm = &Alarm{Proc: frame.Name, ID: frame.Alarm}
+		db.alarms[key] = alarm
+		fallthrough
+	case frame.Active && !alarm.Active:
+		alarm.Raised = now
+		alarm.Cleared = time.Time{}
+		alarm.Acked = false
+		alarm.AckedBy = ""
+		alarm.AckedAt = time.Time{}
+	case frame.Active && frame.Severity > alarm.Severity:
+		alarm.Acked = false
+	case !frame.Active && !ok:
+		return nil
+	}
+
+	switch {
+	case frame.Active:
+		alarm.Active = true
+		alarm.Severity = frame.Severity
+		alarm.Text = frame.Text
+	default:
+		alarm.Active = false
+		alarm.Cleared = now
+	}
+
+	db.publish(*alarm)
+	if !alarm.Active && alarm.Acked {
+		delete(db.alarms, key)
+	}
+
+	return db.save()
+}
+
+// ack acknowledges the alarm id raised by the process proc.
+func (db *alarmDB) ack(proc, id, user string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := alarmID{proc, id}
+	alarm, ok := db.alarms[key]
+	if !ok {
+		return fmt.Errorf("no alarm %q for %q", id, proc)
+	}
+
+	alarm.Acked = true
+	alarm.AckedBy = user
+	alarm.AckedAt = db.now().UTC()
+
+	db.publish(*alarm)
+	if !alarm.Active {
+		delete(db.alarms, key)
+	}
+
+	return db.save()
+}
+
+// list returns the tracked alarms, by decreasing severity.
+func (db *alarmDB) list() []Alarm {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	alarms := make([]Alarm, 0, len(db.alarms))
+	for _, alarm := range db.alarms {
+		alarms = append(alarms, *alarm)
+	}
+	sort.Slice(alarms, func(i, j int) bool {
+		ai := alarms[i]
+		aj := alarms[j]
+		switch {
+		case ai.Severity != aj.Severity:
+			return ai.Severity > aj.Severity
+		case ai.Proc != aj.Proc:
+			return ai.Proc < aj.Proc
+		default:
+			return ai.ID < aj.ID
+		}
+	})
+	return alarms
+}
+
+func (db *alarmDB) publish(alarm Alarm) {
+	if db.feed == nil {
+		return
+	}
+	db.feed.publish("alarm", alarm)
+}
+
+// save persists the alarms.
+// save must be called with db.mu held.
+func (db *alarmDB) save() error {
+	if db.fname == "" {
+		return nil
+	}
+
+	alarms := make([]Alarm, 0, len(db.alarms))
+	for _, alarm := range db.alarms {
+		alarms = append(alarms, *alarm)
+	}
+	sort.Slice(alarms, func(i, j int) bool {
+		if alarms[i].Proc != alarms[j].Proc {
+			return alarms[i].Proc < alarms[j].Proc
+		}
+		return alarms[i].ID < alarms[j].ID
+	})
+
+	raw, err := json.MarshalIndent(alarms, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode alarms: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(db.fname), ".tdaq-alarms-")
+	if err != nil {
+		return fmt.Errorf("could not create alarms file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(raw)
+	if err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("could not write alarms file: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("could not close alarms file: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), db.fname)
+	if err != nil {
+		return fmt.Errorf("could not save alarms file: %w", err)
+	}
+	return nil
+}
+
+// Alarms returns the alarms currently tracked by run-ctl, by decreasing
+// severity.
+func (rc *RunControl) Alarms() []Alarm {
+	return rc.alarms.list()
+}
+
+// AckAlarm acknowledges, on behalf of user, the alarm id raised by the
+// process proc.
+func (rc *RunControl) AckAlarm(proc, id, user string) error {
+	if user == "" {
+		user = rc.cfg.Name
+	}
+	beg := time.Now()
+	err := rc.alarms.ack(proc, id, user)
+	rc.audit(user, auditAck, []string{proc}, []string{id}, beg, err)
+	if err != nil {
+		return fmt.Errorf("could not acknowledge alarm: %w", err)
+	}
+	rc.msg.Infof("alarm %q of %q acknowledged by %q", id, proc, user)
+	return nil
+}
+
+// watchHalts stops the current run whenever a process raises an alarm
+// requesting it (see Alarms.Halt).
+func (rc *RunControl) watchHalts(ctx context.Context) {
+	for {
+		select {
+		case <-rc.quit:
+			return
+		case <-ctx.Done():
+			return
+		case frame := <-rc.halts:
+			if !rc.halting(frame) {
+				continue
+			}
+			err := rc.doStop(ctx)
+			if err != nil {
+				rc.msg.Errorf("could not stop run on alarm %q of %q: %+v", frame.Alarm, frame.Name, err)
+			}
+		}
+	}
+}
+
+// halting returns whether the provided halting alarm should stop the
+// current run.
+func (rc *RunControl) halting(frame AlarmFrame) bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if rc.status != fsm.Running {
+		return false
+	}
+	rc.msg.Errorf("process %q raised alarm %q (%s): stopping run %d", frame.Name, frame.Alarm, frame.Text, rc.runNbr)
+	rc.alerts.raise(AlertError, frame.Name, "alarm %q: run %d stopped: %s", frame.Alarm, rc.runNbr, frame.Text)
+	return true
+}
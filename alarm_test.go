@@ -0,0 +1,154 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/iomux"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestAlarmFrame(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []AlarmFrame{
+		{Name: "n1", Alarm: "hv-trip", Severity: SevCritical, Active: true, Text: "HV tripped on channel 3"},
+		{Name: "n2", Alarm: "hv-trip", Severity: SevCritical},
+		{Name: "n3", Alarm: "temperature", Severity: SevWarning, Active: true},
+		{Name: "n4", Alarm: "sync", Severity: SevCritical, Active: true, Text: "streams out of sync", Halt: true},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			buf := new(iomux.Socket)
+			raw, err := tt.MarshalTDAQ()
+			if err != nil {
+				t.Fatalf("could not marshal alarm-frame: %+v", err)
+			}
+			err = SendFrame(ctx, buf, Frame{Type: FrameMsg, Path: "/alarm", Body: raw})
+			if err != nil {
+				t.Fatalf("could not send alarm-frame: %+v", err)
+			}
+			frame, err := RecvFrame(ctx, buf)
+			if err != nil {
+				t.Fatalf("could not recv alarm-frame: %+v", err)
+			}
+			var got AlarmFrame
+			err = got.UnmarshalTDAQ(frame.Body)
+			if err != nil {
+				t.Fatalf("could not unmarshal alarm-frame: %+v", err)
+			}
+
+			if got, want := got, tt; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid r/w round-trip for alarm-frame:\ngot = %#v\nwant= %#v\n", got, want)
+			}
+		})
+	}
+}
+
+func TestAlarmDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdaq-alarms-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "alarms.json")
+	db, err := newAlarmDB(fname, nil)
+	if err != nil {
+		t.Fatalf("could not create alarm db: %+v", err)
+	}
+	now := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	db.now = func() time.Time { return now }
+
+	for _, frame := range []AlarmFrame{
+		{Name: "gen", Alarm: "hv", Severity: SevMinor, Active: true, Text: "HV low"},
+		{Name: "dump", Alarm: "disk", Severity: SevWarning, Active: true, Text: "disk 90% full"},
+		{Name: "dump", Alarm: "none"}, // clearing an unknown alarm is a no-op.
+	} {
+		err = db.update(frame)
+		if err != nil {
+			t.Fatalf("could not update alarm: %+v", err)
+		}
+	}
+
+	err = db.ack("gen", "hv", "bob")
+	if err != nil {
+		t.Fatalf("could not ack alarm: %+v", err)
+	}
+	err = db.ack("gen", "nope", "bob")
+	if err == nil {
+		t.Fatalf("expected an error acknowledging an unknown alarm")
+	}
+
+	// escalation requires a new acknowledgment.
+	now = now.Add(time.Minute)
+	err = db.update(AlarmFrame{Name: "gen", Alarm: "hv", Severity: SevMajor, Active: true, Text: "HV tripped"})
+	if err != nil {
+		t.Fatalf("could not update alarm: %+v", err)
+	}
+
+	// cleared alarms are kept until acknowledged.
+	err = db.update(AlarmFrame{Name: "dump", Alarm: "disk"})
+	if err != nil {
+		t.Fatalf("could not clear alarm: %+v", err)
+	}
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	want := []Alarm{
+		{
+			Proc: "gen", ID: "hv", Severity: SevMajor, Text: "HV tripped", Active: true,
+			Raised: t0, AckedBy: "bob", AckedAt: t0,
+		},
+		{
+			Proc: "dump", ID: "disk", Severity: SevWarning, Text: "disk 90% full",
+			Raised: t0, Cleared: t0.Add(time.Minute),
+		},
+	}
+	if got := db.list(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid alarms:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	reload, err := newAlarmDB(fname, nil)
+	if err != nil {
+		t.Fatalf("could not reload alarm db: %+v", err)
+	}
+	if got := reload.list(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid persisted alarms:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	err = db.ack("dump", "disk", "alice")
+	if err != nil {
+		t.Fatalf("could not ack alarm: %+v", err)
+	}
+	if got, want := len(db.list()), 1; got != want {
+		t.Fatalf("invalid number of alarms: got=%d, want=%d", got, want)
+	}
+}
+
+func TestHaltAlarm(t *testing.T) {
+	rc := &RunControl{
+		cfg:    config.RunCtl{Name: "run-ctl"},
+		msg:    log.NewMsgStream("run-ctl", log.LvlError+1, ioutil.Discard),
+		status: fsm.Running,
+		runNbr: 42,
+	}
+
+	frame := AlarmFrame{Name: "mon", Alarm: "sync", Severity: SevCritical, Active: true, Halt: true}
+	if !rc.halting(frame) {
+		t.Fatalf("halting alarm should stop a running run")
+	}
+
+	rc.status = fsm.Stopped
+	if rc.halting(frame) {
+		t.Fatalf("halting alarm should be ignored outside of a run")
+	}
+}
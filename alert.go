@@ -0,0 +1,229 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+const (
+	alertTimeout = 30 * time.Second // maximum duration allowed to send an alert
+	alertWindow  = 5 * time.Minute  // default throttling window of repeated alerts
+)
+
+// Notifier sends alerts to an operator notification channel
+// (webhook, chat, e-mail, ...).
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// AlertKind describes the condition that raised an alert.
+type AlertKind uint8
+
+const (
+	AlertError    AlertKind = iota // a process reported an error
+	AlertStall                     // a data link stalled
+	AlertDiskFull                  // the disk of run-ctl is (almost) full
+	AlertRunStop                   // a run was stopped
+	AlertLeak                      // a process is leaking goroutines or memory
+)
+
+func (k AlertKind) String() string {
+	switch k {
+	case AlertError:
+		return "error"
+	case AlertStall:
+		return "stall"
+	case AlertDiskFull:
+		return "disk-full"
+	case AlertRunStop:
+		return "run-stop"
+	case AlertLeak:
+		return "leak"
+	default:
+		return fmt.Sprintf("AlertKind(%d)", uint8(k))
+	}
+}
+
+func (k AlertKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *AlertKind) UnmarshalText(p []byte) error {
+	for _, v := range []AlertKind{AlertError, AlertStall, AlertDiskFull, AlertRunStop, AlertLeak} {
+		if string(p) == v.String() {
+			*k = v
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid alert kind %q", p)
+}
+
+// Alert is a notification sent to operators.
+type Alert struct {
+	Kind     AlertKind `json:"kind"`     // condition that raised the alert
+	Source   string    `json:"source"`   // name of the process (or run-ctl) the alert originates from
+	Text     string    `json:"text"`     // description of the alert
+	Time     time.Time `json:"time"`     // time of the alert
+	Repeated int       `json:"repeated"` // number of identical alerts suppressed since the last notification
+}
+
+func (a Alert) String() string {
+	msg := fmt.Sprintf("[tdaq] %v from %s: %s", a.Kind, a.Source, a.Text)
+	if a.Repeated > 0 {
+		msg += fmt.Sprintf(" (repeated %d times)", a.Repeated)
+	}
+	return msg
+}
+
+// alerter dispatches alerts to a set of notifiers.
+//
+// Identical alerts (same kind, source and text) are sent at most once per
+// throttling window; the number of suppressed alerts is reported with the
+// next notification.
+// A nil alerter drops all alerts.
+type alerter struct {
+	msg    log.MsgStream
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	ns   []Notifier
+	seen map[alertKey]*alertState
+	wg   sync.WaitGroup
+}
+
+type alertKey struct {
+	kind AlertKind
+	src  string
+	text string
+}
+
+type alertState struct {
+	last time.Time // time of the last notification
+	n    int       // number of alerts suppressed since then
+}
+
+func newAlerter(msg log.MsgStream, window time.Duration) *alerter {
+	if window <= 0 {
+		window = alertWindow
+	}
+	return &alerter{
+		msg:    msg,
+		window: window,
+		now:    time.Now,
+		seen:   make(map[alertKey]*alertState),
+	}
+}
+
+func (a *alerter) add(n Notifier) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ns = append(a.ns, n)
+}
+
+// raise sends the provided alert to all notifiers, in the background,
+// unless an identical alert was sent within the throttling window.
+func (a *alerter) raise(kind AlertKind, src, format string, args ...interface{}) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.ns) == 0 {
+		return
+	}
+
+	alert := Alert{
+		Kind:   kind,
+		Source: src,
+		Text:   fmt.Sprintf(format, args...),
+		Time:   a.now().UTC(),
+	}
+
+	for k, st := range a.seen {
+		if st.n == 0 && alert.Time.Sub(st.last) >= a.window {
+			delete(a.seen, k)
+		}
+	}
+
+	key := alertKey{kind, src, alert.Text}
+	st, ok := a.seen[key]
+	switch {
+	case !ok:
+		st = new(alertState)
+		a.seen[key] = st
+	case alert.Time.Sub(st.last) < a.window:
+		st.n++
+		return
+	}
+	alert.Repeated = st.n
+	st.last = alert.Time
+	st.n = 0
+
+	for _, n := range a.ns {
+		n := n
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+			defer cancel()
+			err := n.Notify(ctx, alert)
+			if err != nil {
+				a.msg.Errorf("could not send %v alert: %+v", alert.Kind, err)
+			}
+		}()
+	}
+}
+
+// wait waits for all pending alerts to be sent.
+func (a *alerter) wait() {
+	if a == nil {
+		return
+	}
+	a.wg.Wait()
+}
+
+// AddNotifier registers a notification channel run-ctl sends alerts to.
+func (rc *RunControl) AddNotifier(n Notifier) {
+	rc.alerts.add(n)
+}
+
+// watchDisk raises an alert whenever the free space of the disk holding
+// the run-ctl log file and run summaries falls below the configured limit.
+func (rc *RunControl) watchDisk(ctx context.Context) {
+	if rc.cfg.MinDiskFree <= 0 {
+		return
+	}
+
+	tck := rc.newTicker(rc.cfg.HBeatFreq)
+	defer tck.Stop()
+
+	for {
+		select {
+		case <-rc.quit:
+			return
+		case <-ctx.Done():
+			return
+		case <-tck.C():
+			free, err := diskFree(rc.cfg.SummaryDir)
+			if err != nil {
+				rc.msg.Warnf("could not retrieve free disk space: %+v", err)
+				return
+			}
+			if free < uint64(rc.cfg.MinDiskFree) {
+				rc.msg.Warnf("disk of %q almost full (free=%d bytes)", rc.cfg.SummaryDir, free)
+				rc.alerts.raise(AlertDiskFull, rc.cfg.Name, "disk of %q almost full", rc.cfg.SummaryDir)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+type testNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (n *testNotifier) Notify(ctx context.Context, alert Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func TestAlerter(t *testing.T) {
+	var (
+		n   testNotifier
+		now = time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+		a   = newAlerter(log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard), time.Minute)
+	)
+	a.now = func() time.Time { return now }
+
+	a.raise(AlertError, "gen", "dropped") // no notifier: discarded
+	a.add(&n)
+
+	a.raise(AlertStall, "dump", "link %d stalled", 1)
+	a.raise(AlertStall, "dump", "link %d stalled", 1) // throttled
+	a.raise(AlertStall, "dump", "link %d stalled", 2)
+	now = now.Add(30 * time.Second)
+	a.raise(AlertStall, "dump", "link %d stalled", 1) // throttled
+	now = now.Add(31 * time.Second)
+	a.raise(AlertStall, "dump", "link %d stalled", 1)
+	a.wait()
+
+	var nilAlerter *alerter
+	nilAlerter.raise(AlertError, "gen", "dropped")
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	sort.Slice(n.alerts, func(i, j int) bool {
+		ai := n.alerts[i]
+		aj := n.alerts[j]
+		if !ai.Time.Equal(aj.Time) {
+			return ai.Time.Before(aj.Time)
+		}
+		return ai.Text < aj.Text
+	})
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	want := []Alert{
+		{Kind: AlertStall, Source: "dump", Text: "link 1 stalled", Time: t0},
+		{Kind: AlertStall, Source: "dump", Text: "link 2 stalled", Time: t0},
+		{Kind: AlertStall, Source: "dump", Text: "link 1 stalled", Time: t0.Add(61 * time.Second), Repeated: 2},
+	}
+	if !reflect.DeepEqual(n.alerts, want) {
+		t.Fatalf("invalid alerts:\ngot = %#v\nwant= %#v", n.alerts, want)
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a control action of an operator of run-ctl, as recorded in
+// the audit log.
+//
+// Audited actions are the FSM commands (e.g. "/config", "/start"),
+// "/reconfig", "/debug", "/restore", "/kv-set", "/kv-del" and "/ack" (the
+// acknowledgement of an alarm).
+type AuditEntry struct {
+	Time     time.Time     `json:"time"`              // time at which the action was issued
+	Operator string        `json:"operator"`          // identity of the operator issuing the action
+	Cmd      string        `json:"cmd"`               // name of the action
+	Targets  []string      `json:"targets,omitempty"` // processes (or keys) targeted by the action
+	Args     []string      `json:"args,omitempty"`    // arguments of the action
+	Dur      time.Duration `json:"duration"`          // duration of the execution of the action
+	Err      string        `json:"error,omitempty"`   // error returned by the action, if any
+}
+
+// AuditQuery selects entries of the audit log.
+// Zero-valued fields select all entries.
+type AuditQuery struct {
+	Operator string    // operator issuing the actions
+	Cmd      string    // name of the actions
+	Target   string    // process (or key) targeted by the actions
+	Since    time.Time // earliest time of the actions
+	Until    time.Time // latest time of the actions
+	Limit    int       // maximum number of entries, keeping the most recent ones (0: unlimited)
+}
+
+func (q AuditQuery) match(e AuditEntry) bool {
+	switch {
+	case q.Operator != "" && q.Operator != e.Operator:
+		return false
+	case q.Cmd != "" && q.Cmd != e.Cmd:
+		return false
+	case !q.Since.IsZero() && e.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && e.Time.After(q.Until):
+		return false
+	}
+	if q.Target == "" {
+		return true
+	}
+	for _, target := range e.Targets {
+		if target == q.Target {
+			return true
+		}
+	}
+	return false
+}
+
+// auditAck is the command name of the acknowledgements of alarms, as
+// recorded in the audit log.
+const auditAck = "/ack"
+
+type operatorKey struct{}
+
+// WithOperator returns a copy of ctx carrying the identity of the operator
+// issuing the run-ctl commands with that context, as recorded in the audit
+// log.
+func WithOperator(ctx context.Context, operator string) context.Context {
+	return context.WithValue(ctx, operatorKey{}, operator)
+}
+
+// Operator returns the identity of the operator carried by ctx, if any.
+func Operator(ctx context.Context) string {
+	v, _ := ctx.Value(operatorKey{}).(string)
+	return v
+}
+
+// auditLog is the append-only log of the control actions of run-ctl.
+// Entries are kept in memory and, if the log is backed by a file, appended
+// to that file as a stream of JSON documents.
+//
+// A nil auditLog drops all entries.
+type auditLog struct {
+	mu      sync.RWMutex
+	f       *os.File
+	enc     *json.Encoder
+	entries []AuditEntry // oldest first
+}
+
+func newAuditLog(fname string) (*auditLog, error) {
+	al := &auditLog{}
+	if fname == "" {
+		return al, nil
+	}
+
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log file %q: %w", fname, err)
+	}
+
+	dec := json.NewDecoder(f)
+	for {
+		var e AuditEntry
+		err = dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not decode audit log file %q: %w", fname, err)
+		}
+		al.entries = append(al.entries, e)
+	}
+
+	al.f = f
+	al.enc = json.NewEncoder(f)
+	return al, nil
+}
+
+func (al *auditLog) append(e AuditEntry) error {
+	if al == nil {
+		return nil
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	al.entries = append(al.entries, e)
+	if al.enc == nil {
+		return nil
+	}
+	return al.enc.Encode(e)
+}
+
+func (al *auditLog) query(q AuditQuery) []AuditEntry {
+	if al == nil {
+		return nil
+	}
+	al.mu.RLock()
+	defer al.mu.RUnlock()
+
+	var entries []AuditEntry
+	for _, e := range al.entries {
+		if q.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries
+}
+
+func (al *auditLog) close() error {
+	if al == nil || al.f == nil {
+		return nil
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return al.f.Close()
+}
+
+// audit records, on behalf of operator, the action issued at beg with its
+// outcome to the audit log.
+// Actions without an operator are recorded on behalf of run-ctl.
+func (rc *RunControl) audit(operator, cmd string, targets, args []string, beg time.Time, err error) {
+	if operator == "" {
+		operator = rc.cfg.Name
+	}
+	e := AuditEntry{
+		Time:     beg.UTC(),
+		Operator: operator,
+		Cmd:      cmd,
+		Targets:  targets,
+		Args:     args,
+		Dur:      time.Since(beg),
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+
+	aerr := rc.audits.append(e)
+	if aerr != nil {
+		rc.msg.Errorf("could not append %s by %q to audit log: %+v", cmd, operator, aerr)
+	}
+}
+
+// targets returns the names of the processes targeted by a command sent to
+// procs or, if none is named, to all the connected processes.
+func (rc *RunControl) targets(procs []string) []string {
+	if len(procs) > 0 {
+		return append([]string(nil), procs...)
+	}
+	return rc.clients.names()
+}
+
+// Audit returns the entries of the audit log of run-ctl selected by the
+// provided query, oldest first.
+func (rc *RunControl) Audit(q AuditQuery) []AuditEntry {
+	return rc.audits.query(q)
+}
+
+// webAPIAudit replies with a JSON report of the entries of the audit log
+// selected by the "operator", "cmd", "target", "since", "until" (RFC 3339)
+// and "limit" form values.
+func (rc *RunControl) webAPIAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := AuditQuery{
+		Operator: r.FormValue("operator"),
+		Cmd:      r.FormValue("cmd"),
+		Target:   r.FormValue("target"),
+	}
+	for _, v := range []struct {
+		name string
+		ptr  *time.Time
+	}{
+		{"since", &q.Since},
+		{"until", &q.Until},
+	} {
+		s := r.FormValue(v.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid %q time: %v", v.name, err), http.StatusBadRequest)
+			return
+		}
+		*v.ptr = t
+	}
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid limit: %v", err), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	entries := rc.Audit(q)
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		rc.msg.Errorf("could not encode audit log: %+v", err)
+	}
+}
+
+// webOperator returns the identity of the operator issuing the request: the
+// "user" form value or, if none, the address of the remote host.
+func webOperator(r *http.Request) string {
+	if user := r.FormValue("user"); user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "web@" + host
+}
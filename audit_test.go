@@ -0,0 +1,91 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestAuditLog(t *testing.T) {
+	f, err := ioutil.TempFile("", "tdaq-audit-")
+	if err != nil {
+		t.Fatalf("could not create audit log file: %+v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	audits, err := newAuditLog(f.Name())
+	if err != nil {
+		t.Fatalf("could not create audit log: %+v", err)
+	}
+
+	rc := &RunControl{
+		cfg:     config.RunCtl{Name: "run-ctl"},
+		msg:     log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard),
+		clients: newClientDB(),
+		audits:  audits,
+	}
+
+	beg := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	rc.audit("alice", "/config", []string{"p1", "p2"}, nil, beg, nil)
+	rc.audit("bob", "/start", []string{"p1", "p2"}, nil, beg.Add(1*time.Minute), fmt.Errorf("boom"))
+	rc.audit("", "/stop", []string{"p1"}, nil, beg.Add(2*time.Minute), nil)
+	rc.audit("alice", recKVSet, []string{"adc/threshold"}, []string{"42"}, beg.Add(3*time.Minute), nil)
+
+	err = audits.close()
+	if err != nil {
+		t.Fatalf("could not close audit log: %+v", err)
+	}
+
+	// entries are persisted across run-ctl sessions.
+	rc.audits, err = newAuditLog(f.Name())
+	if err != nil {
+		t.Fatalf("could not reopen audit log: %+v", err)
+	}
+	defer rc.audits.close()
+	rc.audit("bob", "/reset", []string{"p2"}, nil, beg.Add(4*time.Minute), nil)
+
+	cmds := func(entries []AuditEntry) []string {
+		var cmds []string
+		for _, e := range entries {
+			cmds = append(cmds, e.Cmd)
+		}
+		return cmds
+	}
+
+	for _, tt := range []struct {
+		name string
+		q    AuditQuery
+		want []string
+	}{
+		{"all", AuditQuery{}, []string{"/config", "/start", "/stop", recKVSet, "/reset"}},
+		{"operator", AuditQuery{Operator: "alice"}, []string{"/config", recKVSet}},
+		{"default-operator", AuditQuery{Operator: "run-ctl"}, []string{"/stop"}},
+		{"cmd", AuditQuery{Cmd: "/start"}, []string{"/start"}},
+		{"target", AuditQuery{Target: "p2"}, []string{"/config", "/start", "/reset"}},
+		{"since", AuditQuery{Since: beg.Add(2 * time.Minute)}, []string{"/stop", recKVSet, "/reset"}},
+		{"until", AuditQuery{Until: beg.Add(1 * time.Minute)}, []string{"/config", "/start"}},
+		{"limit", AuditQuery{Limit: 2}, []string{recKVSet, "/reset"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cmds(rc.Audit(tt.q))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("invalid audit entries:\ngot= %q\nwant=%q", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := rc.Audit(AuditQuery{Cmd: "/start"})[0].Err, "boom"; got != want {
+		t.Fatalf("invalid outcome: got=%q, want=%q", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// DefaultByteOrder is the byte order of the payloads of the end-points
+// which did not declare one.
+var DefaultByteOrder binary.ByteOrder = binary.LittleEndian
+
+// ByteOrder declares the byte order of the payloads of the named end-point,
+// so producers and consumers running on hardware with a different
+// endianness interoperate.
+// run-ctl checks at /config that the producer and the consumers of an
+// end-point declared the same byte order.
+//
+// ByteOrder panics if the byte order is neither binary.LittleEndian nor
+// binary.BigEndian.
+func (srv *Server) ByteOrder(name string, order binary.ByteOrder) {
+	srv.orders.set(name, order)
+}
+
+// EndPointByteOrder returns the byte order of the payloads of the named
+// end-point: the declared one, or DefaultByteOrder.
+func (srv *Server) EndPointByteOrder(name string) binary.ByteOrder {
+	return srv.orders.get(name)
+}
+
+// byteOrders holds the byte orders declared by the end-points of a process.
+type byteOrders struct {
+	mu sync.RWMutex
+	db map[string]binary.ByteOrder
+}
+
+func (bo *byteOrders) set(name string, order binary.ByteOrder) {
+	if order == nil {
+		panic(fmt.Errorf("invalid nil byte order for end-point %q", name))
+	}
+	if _, err := byteOrderFrom(order.String()); err != nil {
+		panic(fmt.Errorf("invalid byte order for end-point %q: %w", name, err))
+	}
+
+	bo.mu.Lock()
+	defer bo.mu.Unlock()
+	if bo.db == nil {
+		bo.db = make(map[string]binary.ByteOrder)
+	}
+	bo.db[name] = order
+}
+
+func (bo *byteOrders) get(name string) binary.ByteOrder {
+	bo.mu.RLock()
+	defer bo.mu.RUnlock()
+	if order, ok := bo.db[name]; ok {
+		return order
+	}
+	return DefaultByteOrder
+}
+
+// names returns the declared byte orders, indexed by end-point name.
+func (bo *byteOrders) names() map[string]string {
+	bo.mu.RLock()
+	defer bo.mu.RUnlock()
+	if len(bo.db) == 0 {
+		return nil
+	}
+	names := make(map[string]string, len(bo.db))
+	for ep, order := range bo.db {
+		names[ep] = order.String()
+	}
+	return names
+}
+
+// byteOrderFrom returns the byte order with the provided name.
+func byteOrderFrom(name string) (binary.ByteOrder, error) {
+	switch name {
+	case binary.LittleEndian.String():
+		return binary.LittleEndian, nil
+	case binary.BigEndian.String():
+		return binary.BigEndian, nil
+	default:
+		return nil, fmt.Errorf("unknown byte order %q", name)
+	}
+}
+
+// byteOrderOf returns the name of the byte order of the named end-point,
+// from the byte orders declared by a process.
+func byteOrderOf(orders map[string]string, name string) string {
+	if order, ok := orders[name]; ok {
+		return order
+	}
+	return DefaultByteOrder.String()
+}
+
+// PutI16 encodes v into b with the provided byte order.
+func PutI16(order binary.ByteOrder, b []byte, v int16) { order.PutUint16(b, uint16(v)) }
+
+// PutI32 encodes v into b with the provided byte order.
+func PutI32(order binary.ByteOrder, b []byte, v int32) { order.PutUint32(b, uint32(v)) }
+
+// PutI64 encodes v into b with the provided byte order.
+func PutI64(order binary.ByteOrder, b []byte, v int64) { order.PutUint64(b, uint64(v)) }
+
+// PutU16 encodes v into b with the provided byte order.
+func PutU16(order binary.ByteOrder, b []byte, v uint16) { order.PutUint16(b, v) }
+
+// PutU32 encodes v into b with the provided byte order.
+func PutU32(order binary.ByteOrder, b []byte, v uint32) { order.PutUint32(b, v) }
+
+// PutU64 encodes v into b with the provided byte order.
+func PutU64(order binary.ByteOrder, b []byte, v uint64) { order.PutUint64(b, v) }
+
+// PutF32 encodes v into b with the provided byte order.
+func PutF32(order binary.ByteOrder, b []byte, v float32) { order.PutUint32(b, math.Float32bits(v)) }
+
+// PutF64 encodes v into b with the provided byte order.
+func PutF64(order binary.ByteOrder, b []byte, v float64) { order.PutUint64(b, math.Float64bits(v)) }
+
+// I16 decodes an int16 from b with the provided byte order.
+func I16(order binary.ByteOrder, b []byte) int16 { return int16(order.Uint16(b)) }
+
+// I32 decodes an int32 from b with the provided byte order.
+func I32(order binary.ByteOrder, b []byte) int32 { return int32(order.Uint32(b)) }
+
+// I64 decodes an int64 from b with the provided byte order.
+func I64(order binary.ByteOrder, b []byte) int64 { return int64(order.Uint64(b)) }
+
+// U16 decodes a uint16 from b with the provided byte order.
+func U16(order binary.ByteOrder, b []byte) uint16 { return order.Uint16(b) }
+
+// U32 decodes a uint32 from b with the provided byte order.
+func U32(order binary.ByteOrder, b []byte) uint32 { return order.Uint32(b) }
+
+// U64 decodes a uint64 from b with the provided byte order.
+func U64(order binary.ByteOrder, b []byte) uint64 { return order.Uint64(b) }
+
+// F32 decodes a float32 from b with the provided byte order.
+func F32(order binary.ByteOrder, b []byte) float32 { return math.Float32frombits(order.Uint32(b)) }
+
+// F64 decodes a float64 from b with the provided byte order.
+func F64(order binary.ByteOrder, b []byte) float64 { return math.Float64frombits(order.Uint64(b)) }
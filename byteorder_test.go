@@ -0,0 +1,136 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestPayloadByteOrder(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			b := make([]byte, 8)
+
+			PutI16(order, b, -2)
+			if got, want := I16(order, b), int16(-2); got != want {
+				t.Fatalf("invalid i16:\ngot = %v\nwant= %v", got, want)
+			}
+			PutI32(order, b, -3)
+			if got, want := I32(order, b), int32(-3); got != want {
+				t.Fatalf("invalid i32:\ngot = %v\nwant= %v", got, want)
+			}
+			PutI64(order, b, -4)
+			if got, want := I64(order, b), int64(-4); got != want {
+				t.Fatalf("invalid i64:\ngot = %v\nwant= %v", got, want)
+			}
+			PutU16(order, b, 0x0102)
+			if got, want := U16(order, b), uint16(0x0102); got != want {
+				t.Fatalf("invalid u16:\ngot = %v\nwant= %v", got, want)
+			}
+			PutU32(order, b, 0x01020304)
+			if got, want := U32(order, b), uint32(0x01020304); got != want {
+				t.Fatalf("invalid u32:\ngot = %v\nwant= %v", got, want)
+			}
+			PutU64(order, b, 0x0102030405060708)
+			if got, want := U64(order, b), uint64(0x0102030405060708); got != want {
+				t.Fatalf("invalid u64:\ngot = %v\nwant= %v", got, want)
+			}
+			want := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+			if order == binary.LittleEndian {
+				want = []byte{8, 7, 6, 5, 4, 3, 2, 1}
+			}
+			if !bytes.Equal(b, want) {
+				t.Fatalf("invalid encoding:\ngot = %v\nwant= %v", b, want)
+			}
+			PutF32(order, b, 1.5)
+			if got, want := F32(order, b), float32(1.5); got != want {
+				t.Fatalf("invalid f32:\ngot = %v\nwant= %v", got, want)
+			}
+			PutF64(order, b, -2.5)
+			if got, want := F64(order, b), -2.5; got != want {
+				t.Fatalf("invalid f64:\ngot = %v\nwant= %v", got, want)
+			}
+		})
+	}
+}
+
+func TestEndPointByteOrder(t *testing.T) {
+	var orders byteOrders
+	if got, want := orders.get("/adc"), DefaultByteOrder; got != want {
+		t.Fatalf("invalid default byte order:\ngot = %v\nwant= %v", got, want)
+	}
+	if got := orders.names(); got != nil {
+		t.Fatalf("invalid byte orders: got=%v, want=nil", got)
+	}
+
+	orders.set("/adc", binary.BigEndian)
+	if got, want := orders.get("/adc"), binary.ByteOrder(binary.BigEndian); got != want {
+		t.Fatalf("invalid byte order:\ngot = %v\nwant= %v", got, want)
+	}
+	if got, want := orders.names(), map[string]string{"/adc": "BigEndian"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid byte orders:\ngot = %v\nwant= %v", got, want)
+	}
+
+	func() {
+		defer func() {
+			if e := recover(); e == nil {
+				t.Fatalf("expected a panic for a nil byte order")
+			}
+		}()
+		orders.set("/tdc", nil)
+	}()
+
+	// the process "x" has no provider, so /config stops once the byte
+	// orders of "dump" have been checked.
+	errNoProvider := fmt.Errorf(`could not find a provider for input "/tdc" for "x"`)
+
+	for _, tc := range []struct {
+		name string
+		gen  map[string]string
+		dump map[string]string
+		err  error
+	}{
+		{name: "undeclared", err: errNoProvider},
+		{
+			name: "declared",
+			gen:  map[string]string{"/adc": "BigEndian"},
+			dump: map[string]string{"/adc": "BigEndian"},
+			err:  errNoProvider,
+		},
+		{
+			name: "default",
+			gen:  map[string]string{"/adc": "LittleEndian"},
+			err:  errNoProvider,
+		},
+		{
+			name: "mismatch",
+			gen:  map[string]string{"/adc": "BigEndian"},
+			err:  fmt.Errorf(`input "/adc" of "dump" expects LittleEndian payloads, but its provider sends BigEndian payloads`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := &RunControl{
+				msg: log.NewMsgStream("run-ctl", log.LvlError+1, ioutil.Discard),
+				clients: newClientDB(
+					&client{name: "gen", oeps: []EndPoint{{Name: "/adc"}}, orders: tc.gen},
+					&client{name: "dump", ieps: []EndPoint{{Name: "/adc"}}, orders: tc.dump},
+					&client{name: "x", ieps: []EndPoint{{Name: "/tdc"}}},
+				),
+			}
+			err := rc.doConfig(context.Background())
+			if err == nil || err.Error() != tc.err.Error() {
+				t.Fatalf("invalid error:\ngot = %v\nwant= %v", err, tc.err)
+			}
+		})
+	}
+}
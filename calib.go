@@ -0,0 +1,348 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.nanomsg.org/mangos/v3/protocol/req"
+)
+
+// Calibration constants are computed by a calibration device, typically
+// during a calibration run, and published to run-ctl with
+// Context.PublishCalib.
+// run-ctl assigns them a version and distributes them, with the /calib
+// command, to the processes which declared consuming them with
+// Server.CalibHandle.
+// The replies of the consumers acknowledge the distributed versions, as
+// reported by RunControl.Calibs.
+// Consumers which did not acknowledge the latest version of constants (e.g.
+// processes which joined after their publication) receive it at /config.
+
+// Calib is a set of calibration constants, distributed by run-ctl.
+type Calib struct {
+	Name    string // name of the set of constants (e.g. "calo/gains")
+	Version uint64 // version of the constants, assigned by run-ctl
+	Proc    string // name of the process which computed the constants
+	Data    []byte
+}
+
+// CalibHandler applies the calibration constants distributed by run-ctl.
+type CalibHandler func(ctx Context, calib Calib) error
+
+// calibmgr handles the calibration constants consumed and published by a
+// process.
+type calibmgr struct {
+	srv *Server
+
+	mu    sync.RWMutex
+	hdlrs map[string]CalibHandler // handlers of the consumed constants, indexed by name
+}
+
+func newCalibMgr(srv *Server) *calibmgr {
+	return &calibmgr{
+		srv:   srv,
+		hdlrs: make(map[string]CalibHandler),
+	}
+}
+
+// CalibHandle registers the handler applying the named calibration
+// constants, distributed by run-ctl.
+//
+// Handlers must be registered before the process is run.
+func (srv *Server) CalibHandle(name string, h CalibHandler) {
+	srv.calibs.mu.Lock()
+	defer srv.calibs.mu.Unlock()
+	srv.calibs.hdlrs[name] = h
+}
+
+// names returns the names of the constants consumed by the process.
+func (mgr *calibmgr) names() []string {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	if len(mgr.hdlrs) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(mgr.hdlrs))
+	for name := range mgr.hdlrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// onCalib applies the calibration constants distributed by run-ctl.
+func (srv *Server) onCalib(ctx Context, req Frame) error {
+	cmd, err := newCalibCmd(req)
+	if err != nil {
+		return fmt.Errorf("%s: could not decode /calib cmd: %w", srv.name, err)
+	}
+
+	srv.calibs.mu.RLock()
+	h, ok := srv.calibs.hdlrs[cmd.Name]
+	srv.calibs.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%s: no handler for calibration constants %q", srv.name, cmd.Name)
+	}
+
+	err = h(ctx, Calib{
+		Name:    cmd.Name,
+		Version: cmd.Version,
+		Proc:    cmd.Proc,
+		Data:    cmd.Data,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: could not apply calibration constants %q (version=%d): %w", srv.name, cmd.Name, cmd.Version, err)
+	}
+	ctx.Msg.Infof("applied calibration constants %q (version=%d, proc=%q)", cmd.Name, cmd.Version, cmd.Proc)
+	return nil
+}
+
+// PublishCalib publishes the named calibration constants to run-ctl, which
+// distributes them to the processes consuming them.
+// PublishCalib returns the version run-ctl assigned to the constants.
+func (ctx Context) PublishCalib(name string, data []byte) (uint64, error) {
+	if ctx.calibs == nil {
+		return 0, fmt.Errorf("could not publish calibration constants %q: no run-ctl", name)
+	}
+	return ctx.calibs.publish(ctx.Ctx, name, data)
+}
+
+func (mgr *calibmgr) publish(ctx context.Context, name string, data []byte) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	sck, err := req.NewSocket()
+	if err != nil {
+		return 0, fmt.Errorf("could not create /calib socket: %w", err)
+	}
+	defer sck.Close()
+
+	err = setMaxFrameSize(sck, mgr.srv.maxFrame)
+	if err != nil {
+		return 0, fmt.Errorf("could not set maximum frame size of /calib socket: %w", err)
+	}
+
+	err = sck.Dial(mgr.srv.rc)
+	if err != nil {
+		return 0, fmt.Errorf("could not dial /calib socket %q: %w", mgr.srv.rc, err)
+	}
+
+	err = SendCmd(ctx, sck, &CalibCmd{Name: name, Proc: mgr.srv.name, Data: data})
+	if err != nil {
+		return 0, fmt.Errorf("could not send /calib cmd to run-ctl: %w", err)
+	}
+
+	frame, err := RecvFrame(ctx, sck)
+	if err != nil {
+		return 0, fmt.Errorf("could not recv /calib-ack from run-ctl: %w", err)
+	}
+	switch frame.Type {
+	case FrameOK:
+		if len(frame.Body) < 8 {
+			return 0, fmt.Errorf("received invalid /calib-ack from run-ctl (size=%d)", len(frame.Body))
+		}
+		return binary.LittleEndian.Uint64(frame.Body), nil
+	case FrameErr:
+		return 0, fmt.Errorf("received error /calib-ack from run-ctl: %s", frame.Body)
+	default:
+		return 0, fmt.Errorf("received invalid /calib-ack frame from run-ctl (frame=%#v)", frame)
+	}
+}
+
+// CalibStatus describes the distribution of a set of calibration constants.
+type CalibStatus struct {
+	Name    string            `json:"name"`
+	Version uint64            `json:"version"` // latest version of the constants
+	Proc    string            `json:"proc"`    // process which computed the latest version
+	Time    time.Time         `json:"time"`    // publication time of the latest version
+	Size    int               `json:"size"`    // size in bytes of the latest version
+	Acks    map[string]uint64 `json:"acks"`    // latest version acknowledged by each consumer
+}
+
+// calibDB holds the latest version of the calibration constants published
+// to run-ctl, with their acknowledgements by their consumers.
+type calibDB struct {
+	feed *feed
+	now  func() time.Time
+
+	mu     sync.Mutex
+	consts map[string]*calibEntry
+}
+
+type calibEntry struct {
+	status CalibStatus
+	data   []byte
+}
+
+func newCalibDB(feed *feed) *calibDB {
+	return &calibDB{
+		feed:   feed,
+		now:    time.Now,
+		consts: make(map[string]*calibEntry),
+	}
+}
+
+// add records a new version of the named constants and returns that version.
+func (db *calibDB) add(name, proc string, data []byte) uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	e, ok := db.consts[name]
+	if !ok {
+		e = &calibEntry{status: CalibStatus{Name: name}}
+		db.consts[name] = e
+	}
+	e.status.Version++
+	e.status.Proc = proc
+	e.status.Time = db.now().UTC()
+	e.status.Size = len(data)
+	e.data = data
+	db.publish(e)
+
+	return e.status.Version
+}
+
+// pending returns the latest version of the named constants, if the
+// consumer did not acknowledge it yet.
+func (db *calibDB) pending(name, consumer string) (CalibCmd, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	e, ok := db.consts[name]
+	if !ok || e.status.Acks[consumer] >= e.status.Version {
+		return CalibCmd{}, false
+	}
+	return CalibCmd{
+		Name:    name,
+		Proc:    e.status.Proc,
+		Version: e.status.Version,
+		Data:    e.data,
+	}, true
+}
+
+// ack records the consumer acknowledged the provided version of the named
+// constants.
+func (db *calibDB) ack(name, consumer string, version uint64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	e, ok := db.consts[name]
+	if !ok {
+		return
+	}
+	if e.status.Acks == nil {
+		e.status.Acks = make(map[string]uint64)
+	}
+	e.status.Acks[consumer] = version
+	db.publish(e)
+}
+
+// publish publishes the status of the constants on the live feed.
+// publish must be called with db.mu held.
+func (db *calibDB) publish(e *calibEntry) {
+	if db.feed != nil {
+		db.feed.publish("calib", e.status.clone())
+	}
+}
+
+func (st CalibStatus) clone() CalibStatus {
+	acks := st.Acks
+	st.Acks = make(map[string]uint64, len(acks))
+	for k, v := range acks {
+		st.Acks[k] = v
+	}
+	return st
+}
+
+func (db *calibDB) list() []CalibStatus {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o := make([]CalibStatus, 0, len(db.consts))
+	for _, e := range db.consts {
+		o = append(o, e.status.clone())
+	}
+	sort.Slice(o, func(i, j int) bool {
+		return o[i].Name < o[j].Name
+	})
+	return o
+}
+
+// Calibs returns the status of the distribution of the calibration
+// constants published to run-ctl, sorted by name.
+func (rc *RunControl) Calibs() []CalibStatus {
+	return rc.calibs.list()
+}
+
+// handleCalib records the calibration constants published by a process,
+// acknowledges them with their version and distributes them to their
+// consumers.
+func (rc *RunControl) handleCalib(ctx context.Context, raw Frame) {
+	cmd, err := newCalibCmd(raw)
+	if err != nil {
+		rc.msg.Errorf("could not decode /calib cmd: %+v", err)
+		_ = SendFrame(ctx, rc.srv.join, Frame{Type: FrameErr, Body: []byte(err.Error())})
+		return
+	}
+
+	vers := rc.calibs.add(cmd.Name, cmd.Proc, cmd.Data)
+	rc.msg.Infof("received calibration constants %q from %q (version=%d, size=%d)", cmd.Name, cmd.Proc, vers, len(cmd.Data))
+
+	ack := make([]byte, 8)
+	binary.LittleEndian.PutUint64(ack, vers)
+	err = SendFrame(ctx, rc.srv.join, Frame{Type: FrameOK, Body: ack})
+	if err != nil {
+		rc.msg.Errorf("could not send /calib-ack to %q: %+v", cmd.Proc, err)
+	}
+
+	// the publisher may be blocking a transition run-ctl is driving:
+	// distribute the constants once the publisher has been acknowledged.
+	go func() {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		select {
+		case <-rc.quit:
+			return
+		default:
+		}
+		rc.syncCalibs(ctx)
+	}()
+}
+
+// syncCalibs distributes the latest version of the calibration constants
+// to the consumers which did not acknowledge it yet.
+// syncCalibs must be called with rc.mu held.
+func (rc *RunControl) syncCalibs(ctx context.Context) {
+	for _, name := range rc.deps {
+		cli := rc.clients.get(name)
+		if cli == nil {
+			continue
+		}
+		for _, cname := range cli.calibs {
+			cmd, ok := rc.calibs.pending(cname, cli.name)
+			if !ok {
+				continue
+			}
+			err := func() error {
+				ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				defer cancel()
+				return rc.requestCmd(ctx, cli, &cmd)
+			}()
+			if err != nil {
+				rc.msg.Warnf("could not distribute calibration constants %q (version=%d) to %q: %+v", cname, cmd.Version, cli.name, err)
+				continue
+			}
+			rc.calibs.ack(cname, cli.name, cmd.Version)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+)
+
+func TestCalibDB(t *testing.T) {
+	db := newCalibDB(nil)
+
+	if _, ok := db.pending("calo/gains", "calo"); ok {
+		t.Fatalf("unexpected pending constants")
+	}
+
+	if got, want := db.add("calo/gains", "calib", []byte("v1")), uint64(1); got != want {
+		t.Fatalf("invalid version: got=%d, want=%d", got, want)
+	}
+	if got, want := db.add("calo/gains", "calib", []byte("v2")), uint64(2); got != want {
+		t.Fatalf("invalid version: got=%d, want=%d", got, want)
+	}
+
+	cmd, ok := db.pending("calo/gains", "calo")
+	if !ok {
+		t.Fatalf("missing pending constants")
+	}
+	if got, want := cmd, (CalibCmd{Name: "calo/gains", Proc: "calib", Version: 2, Data: []byte("v2")}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid pending constants:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	db.ack("calo/gains", "calo", cmd.Version)
+	if _, ok := db.pending("calo/gains", "calo"); ok {
+		t.Fatalf("unexpected pending constants after ack")
+	}
+	if _, ok := db.pending("calo/gains", "monitor"); !ok {
+		t.Fatalf("missing pending constants for another consumer")
+	}
+
+	db.add("calo/gains", "calib", []byte("v3"))
+	if cmd, ok := db.pending("calo/gains", "calo"); !ok || cmd.Version != 3 {
+		t.Fatalf("invalid pending constants: got=%#v (ok=%v)", cmd, ok)
+	}
+
+	list := db.list()
+	if len(list) != 1 {
+		t.Fatalf("invalid number of constants: got=%d, want=1", len(list))
+	}
+	if got, want := list[0].Acks, map[string]uint64{"calo": 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid acks:\ngot = %v\nwant= %v", got, want)
+	}
+}
+
+func TestServerCalib(t *testing.T) {
+	srv := New(config.Process{Name: "calo"}, ioutil.Discard)
+
+	var got Calib
+	srv.CalibHandle("calo/gains", func(ctx Context, calib Calib) error {
+		got = calib
+		return nil
+	})
+	srv.cmgr.init()
+
+	if got, want := srv.calibs.names(), []string{"calo/gains"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid consumed constants: got=%q, want=%q", got, want)
+	}
+
+	calib := func(cmd CalibCmd) Frame {
+		t.Helper()
+		raw, err := cmd.MarshalTDAQ()
+		if err != nil {
+			t.Fatalf("could not marshal /calib: %+v", err)
+		}
+		req := localCmd(CmdCalib)
+		req.Body = append(req.Body, raw...)
+		resp, _ := srv.runCmd(context.Background(), req)
+		return resp
+	}
+
+	resp := calib(CalibCmd{Name: "calo/gains", Proc: "calib", Version: 2, Data: []byte{1, 2}})
+	if resp.Type != FrameOK {
+		t.Fatalf("could not apply /calib: %s", resp.Body)
+	}
+	if want := (Calib{Name: "calo/gains", Version: 2, Proc: "calib", Data: []byte{1, 2}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid constants:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	resp = calib(CalibCmd{Name: "tracker/t0", Proc: "calib", Version: 1})
+	if resp.Type != FrameErr {
+		t.Fatalf("expected an error for unknown constants")
+	}
+	if got, want := srv.getCurState(), fsm.UnConf; got != want {
+		t.Fatalf("invalid state:\ngot = %v\nwant= %v", got, want)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultCheckpointFreq is the default period of the checkpoints of the
+// state of TDAQ devices.
+const DefaultCheckpointFreq = 1 * time.Minute
+
+// ckptMagic identifies checkpoint files.
+const ckptMagic = "tdaq-ckpt"
+
+// Checkpointer is implemented by devices whose state is saved and restored
+// across restarts of their process.
+type Checkpointer interface {
+	// Checkpoint returns the state of the device.
+	Checkpoint() ([]byte, error)
+
+	// Restore restores the state of the device.
+	Restore(state []byte) error
+}
+
+// Checkpoint registers the function saving the state of the device (e.g.
+// its counters and calibration state).
+//
+// When the process is configured with a checkpoint file, the framework
+// calls f periodically and when the process shuts down, and stores the
+// state in that file. A restarted process passes that state to the
+// function registered with Restore.
+func (srv *Server) Checkpoint(f func() ([]byte, error)) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.ckpt.save = f
+}
+
+// Restore registers the function restoring the state of the device saved
+// by the function registered with Checkpoint.
+// f is called when the process starts, if a checkpoint file exists.
+func (srv *Server) Restore(f func(state []byte) error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.ckpt.load = f
+}
+
+// checkpoint saves the state of the device to the checkpoint file.
+func (srv *Server) checkpoint() error {
+	srv.mu.RLock()
+	save := srv.ckpt.save
+	srv.mu.RUnlock()
+
+	fname := srv.cfg.Checkpoint
+	if fname == "" || save == nil {
+		return nil
+	}
+
+	state, err := save()
+	if err != nil {
+		return fmt.Errorf("could not checkpoint device state: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.WriteStr(ckptMagic)
+	enc.WriteStr(srv.name)
+	enc.WriteTime(time.Now().UTC())
+	enc.WriteBytes(state)
+	if enc.err != nil {
+		return fmt.Errorf("could not encode device state: %w", enc.err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fname), ".tdaq-ckpt-")
+	if err != nil {
+		return fmt.Errorf("could not create checkpoint file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(buf.Bytes())
+	if err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("could not write checkpoint file: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("could not close checkpoint file: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), fname)
+	if err != nil {
+		return fmt.Errorf("could not save checkpoint file: %w", err)
+	}
+	return nil
+}
+
+// restore restores the state of the device from the checkpoint file.
+func (srv *Server) restore() error {
+	srv.mu.RLock()
+	load := srv.ckpt.load
+	srv.mu.RUnlock()
+
+	fname := srv.cfg.Checkpoint
+	if fname == "" || load == nil {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(fname)
+	switch {
+	case err == nil:
+		// ok.
+	case os.IsNotExist(err):
+		return nil
+	default:
+		return fmt.Errorf("could not read checkpoint file: %w", err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(raw))
+	var (
+		magic = dec.ReadStr()
+		name  = dec.ReadStr()
+		when  = dec.ReadTime()
+		state = dec.ReadBytes()
+	)
+	switch {
+	case dec.err != nil:
+		return fmt.Errorf("could not decode checkpoint file %q: %w", fname, dec.err)
+	case magic != ckptMagic:
+		return fmt.Errorf("invalid checkpoint file %q", fname)
+	case name != srv.name:
+		return fmt.Errorf("invalid checkpoint file %q: state of process %q", fname, name)
+	}
+
+	err = load(state)
+	if err != nil {
+		return fmt.Errorf("could not restore device state: %w", err)
+	}
+	srv.msg.Infof("restored device state from %q (checkpoint of %s)", fname, when.Format(time.RFC3339))
+	return nil
+}
+
+// ckptLoop periodically checkpoints the state of the device.
+func (srv *Server) ckptLoop(ctx context.Context) {
+	freq := srv.cfg.CheckpointFreq
+	if freq <= 0 {
+		freq = DefaultCheckpointFreq
+	}
+	ticks := srv.timeSource().NewTicker(freq)
+	defer ticks.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-srv.done:
+			return
+		case <-ticks.C():
+			err := srv.checkpoint()
+			if err != nil {
+				srv.msg.Warnf("%+v", err)
+			}
+		}
+	}
+}
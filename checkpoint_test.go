@@ -0,0 +1,64 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+func TestCheckpoint(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "tdaq-ckpt-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	cfg := config.Process{Name: "dev", Checkpoint: filepath.Join(tmp, "dev.ckpt")}
+
+	srv := New(cfg, ioutil.Discard)
+	err = srv.restore()
+	if err != nil {
+		t.Fatalf("could not restore without checkpoint: %+v", err)
+	}
+
+	n := 0
+	srv.Checkpoint(func() ([]byte, error) {
+		n++
+		return []byte(fmt.Sprintf("evts=%d", 42*n)), nil
+	})
+	for i := 0; i < 2; i++ {
+		err = srv.checkpoint()
+		if err != nil {
+			t.Fatalf("could not checkpoint: %+v", err)
+		}
+	}
+
+	var got string
+	restart := New(cfg, ioutil.Discard)
+	restart.Restore(func(state []byte) error {
+		got = string(state)
+		return nil
+	})
+	err = restart.restore()
+	if err != nil {
+		t.Fatalf("could not restore: %+v", err)
+	}
+	if want := "evts=84"; got != want {
+		t.Fatalf("invalid restored state:\ngot = %q\nwant= %q", got, want)
+	}
+
+	other := New(config.Process{Name: "other", Checkpoint: cfg.Checkpoint}, ioutil.Discard)
+	other.Restore(func(state []byte) error { return nil })
+	err = other.restore()
+	if err == nil {
+		t.Fatalf("expected an error restoring the state of another process")
+	}
+}
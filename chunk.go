@@ -0,0 +1,179 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DefaultChunkSize is the default maximum size of a data frame payload
+// before it is split into chunks.
+const DefaultChunkSize = 1 << 20
+
+// chunkHdrSize is the size of the header of a chunk frame payload:
+//   - sequence number of the chunked frame (u32)
+//   - index of the chunk (u32)
+//   - number of chunks (u32)
+//   - type of the chunked frame (u8)
+const chunkHdrSize = 4 + 4 + 4 + 1
+
+// chunkFrame splits the provided frame into a list of encoded chunk frames,
+// each carrying at most size bytes of the original payload.
+func chunkFrame(frame Frame, size int, seq uint32) [][]byte {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+
+	var (
+		n    = (len(frame.Body) + size - 1) / size
+		msgs = make([][]byte, 0, n)
+		path = []byte(frame.Path)
+	)
+
+	for i := 0; i < n; i++ {
+		beg := i * size
+		end := beg + size
+		if end > len(frame.Body) {
+			end = len(frame.Body)
+		}
+		part := frame.Body[beg:end]
+
+		msg := make([]byte, 2+len(path)+chunkHdrSize+len(part))
+		msg[0] = byte(FrameChunk)
+		msg[1] = byte(len(path))
+		copy(msg[2:], path)
+
+		hdr := msg[2+len(path):]
+		binary.LittleEndian.PutUint32(hdr[0:4], seq)
+		binary.LittleEndian.PutUint32(hdr[4:8], uint32(i))
+		binary.LittleEndian.PutUint32(hdr[8:12], uint32(n))
+		hdr[12] = byte(frame.Type)
+		copy(hdr[chunkHdrSize:], part)
+
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+// reassembler reassembles chunked frames.
+type reassembler struct {
+	max  int       // maximum size of reassembled frames (0: DefaultMaxFrameSize)
+	seq  uint32    // sequence number of the frame being reassembled
+	next uint32    // index of the next expected chunk
+	n    uint32    // number of chunks of the frame being reassembled
+	typ  FrameType // type of the frame being reassembled
+	buf  []byte
+
+	skip *uint32 // sequence number of a frame whose remaining chunks are dropped
+}
+
+// add adds a chunk to the frame being reassembled.
+// add returns the reassembled frame and true once the last chunk of that
+// frame has been received.
+// Incomplete frames (because of a missing chunk) are discarded and reported
+// with a non-nil error, possibly alongside a reassembled frame.
+// Frames larger than the maximum size of the reassembler are discarded as
+// well, as soon as their first chunk announces it.
+func (r *reassembler) add(chunk Frame) (Frame, bool, error) {
+	if len(chunk.Body) < chunkHdrSize {
+		return Frame{}, false, fmt.Errorf("invalid chunk frame size (got=%d, want>=%d)", len(chunk.Body), chunkHdrSize)
+	}
+
+	var (
+		hdr = chunk.Body[:chunkHdrSize]
+		seq = binary.LittleEndian.Uint32(hdr[0:4])
+		idx = binary.LittleEndian.Uint32(hdr[4:8])
+		n   = binary.LittleEndian.Uint32(hdr[8:12])
+		typ = FrameType(hdr[12])
+	)
+
+	if idx >= n {
+		return Frame{}, false, fmt.Errorf("invalid chunk index (idx=%d, n=%d)", idx, n)
+	}
+
+	if r.skip != nil {
+		if idx != 0 && seq == *r.skip {
+			return Frame{}, false, nil
+		}
+		r.skip = nil
+	}
+
+	var lost error
+	if idx == 0 {
+		if r.buf != nil {
+			lost = fmt.Errorf("discarding incomplete chunked frame (seq=%d, chunks=%d/%d)", r.seq, r.next, r.n)
+		}
+		r.reset()
+		// all chunks but the last one are as large as the first one.
+		size := uint64(len(chunk.Body) - chunkHdrSize)
+		if min := uint64(n-1)*size + 1; min > uint64(r.maxSize()) {
+			r.skip = &seq
+			err := fmt.Errorf(
+				"discarding chunked frame (seq=%d, chunks=%d, size>=%d, max=%d): %w",
+				seq, n, min, r.maxSize(), ErrFrameTooLarge,
+			)
+			if lost != nil {
+				err = fmt.Errorf("%v; %w", lost, err)
+			}
+			return Frame{}, false, err
+		}
+		r.seq = seq
+		r.next = 0
+		r.n = n
+		r.typ = typ
+		r.buf = make([]byte, 0, size)
+	}
+
+	if r.buf == nil || seq != r.seq || idx != r.next || n != r.n {
+		err := fmt.Errorf(
+			"discarding out of sequence chunk (seq=%d, idx=%d, n=%d) (want seq=%d, idx=%d, n=%d)",
+			seq, idx, n, r.seq, r.next, r.n,
+		)
+		r.reset()
+		r.skip = &seq
+		return Frame{}, false, err
+	}
+
+	if len(r.buf)+len(chunk.Body)-chunkHdrSize > r.maxSize() {
+		err := fmt.Errorf(
+			"discarding chunked frame (seq=%d, chunks=%d/%d, size>%d): %w",
+			seq, r.next, r.n, r.maxSize(), ErrFrameTooLarge,
+		)
+		r.reset()
+		r.skip = &seq
+		return Frame{}, false, err
+	}
+
+	r.append(chunk)
+	if r.next < r.n {
+		return Frame{}, false, lost
+	}
+
+	frame := Frame{Type: r.typ, Path: chunk.Path, Body: r.buf}
+	r.reset()
+	return frame, true, lost
+}
+
+func (r *reassembler) maxSize() int {
+	if r.max <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return r.max
+}
+
+func (r *reassembler) append(chunk Frame) {
+	r.buf = append(r.buf, chunk.Body[chunkHdrSize:]...)
+	r.next++
+}
+
+func (r *reassembler) reset() {
+	r.seq = 0
+	r.next = 0
+	r.n = 0
+	r.typ = FrameUnknown
+	r.buf = nil
+}
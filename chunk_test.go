@@ -0,0 +1,182 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestChunkFrame(t *testing.T) {
+	ctx := context.Background()
+	body := make([]byte, 1000)
+	for i := range body {
+		body[i] = byte(i)
+	}
+	want := Frame{Type: FrameData, Path: "/adc", Body: body}
+
+	decode := func(msgs [][]byte) []Frame {
+		frames := make([]Frame, len(msgs))
+		for i, msg := range msgs {
+			frame, err := RecvFrame(ctx, rawRecver(msg))
+			if err != nil {
+				t.Fatalf("could not decode chunk %d: %+v", i, err)
+			}
+			frames[i] = frame
+		}
+		return frames
+	}
+
+	for _, size := range []int{1, 7, 100, 999, 1000} {
+		chunks := decode(chunkFrame(want, size, 42))
+		if got, want := len(chunks), (len(body)+size-1)/size; got != want {
+			t.Fatalf("invalid number of chunks: got=%d, want=%d", got, want)
+		}
+
+		var r reassembler
+		for i, chunk := range chunks {
+			if chunk.Type != FrameChunk {
+				t.Fatalf("invalid chunk frame type: %v", chunk.Type)
+			}
+			got, ok, err := r.add(chunk)
+			if err != nil {
+				t.Fatalf("could not reassemble chunk %d: %+v", i, err)
+			}
+			if ok != (i == len(chunks)-1) {
+				t.Fatalf("invalid reassembly state for chunk %d/%d", i, len(chunks))
+			}
+			if !ok {
+				continue
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid reassembled frame (size=%d):\ngot = %#v\nwant= %#v\n", size, got, want)
+			}
+		}
+	}
+
+	// lose a chunk: the incomplete frame is discarded and
+	// the next frame is properly reassembled.
+	var (
+		r  reassembler
+		c1 = decode(chunkFrame(want, 300, 1))
+		c2 = decode(chunkFrame(want, 300, 2))
+	)
+	for i, chunk := range append(append(c1[:1], c1[2:]...), c2...) {
+		got, ok, err := r.add(chunk)
+		switch i {
+		case 1:
+			if err == nil {
+				t.Fatalf("expected an error for missing chunk")
+			}
+		default:
+			if err != nil {
+				t.Fatalf("unexpected error for chunk %d: %+v", i, err)
+			}
+		}
+		if i < 6 && ok {
+			t.Fatalf("unexpected reassembled frame for chunk %d", i)
+		}
+		if i == 6 {
+			if !ok {
+				t.Fatalf("expected a reassembled frame")
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid reassembled frame:\ngot = %#v\nwant= %#v\n", got, want)
+			}
+		}
+	}
+}
+
+func TestChunkFrameTooLarge(t *testing.T) {
+	ctx := context.Background()
+	frame := func(n int) Frame {
+		return Frame{Type: FrameData, Path: "/adc", Body: make([]byte, n)}
+	}
+	decode := func(msgs [][]byte) []Frame {
+		frames := make([]Frame, len(msgs))
+		for i, msg := range msgs {
+			frame, err := RecvFrame(ctx, rawRecver(msg))
+			if err != nil {
+				t.Fatalf("could not decode chunk %d: %+v", i, err)
+			}
+			frames[i] = frame
+		}
+		return frames
+	}
+
+	// forged chunk header announcing a huge frame: the frame is discarded
+	// with its first chunk, without allocating for it.
+	msgs := chunkFrame(frame(300), 100, 1)
+	for _, msg := range msgs {
+		hdr := msg[2+len("/adc"):]
+		binary.LittleEndian.PutUint32(hdr[8:12], math.MaxUint32)
+	}
+
+	var r reassembler
+	for i, chunk := range decode(msgs) {
+		_, ok, err := r.add(chunk)
+		switch i {
+		case 0:
+			if !errors.Is(err, ErrFrameTooLarge) {
+				t.Fatalf("invalid error for forged chunk header: %+v", err)
+			}
+		default:
+			if err != nil {
+				t.Fatalf("unexpected error for chunk %d: %+v", i, err)
+			}
+		}
+		if ok {
+			t.Fatalf("unexpected reassembled frame for chunk %d", i)
+		}
+		if r.buf != nil {
+			t.Fatalf("unexpected reassembly buffer for chunk %d", i)
+		}
+	}
+
+	for _, tc := range []struct {
+		size int
+		err  error
+	}{
+		{size: 1000},
+		{size: 1001, err: ErrFrameTooLarge},
+		{size: 2000, err: ErrFrameTooLarge},
+		{size: 999},
+	} {
+		r := reassembler{max: 1000}
+		want := frame(tc.size)
+		var (
+			got Frame
+			ok  bool
+			err error
+		)
+		for _, chunk := range decode(chunkFrame(want, 300, 2)) {
+			var e error
+			got, ok, e = r.add(chunk)
+			if e != nil {
+				err = e
+			}
+		}
+		switch {
+		case tc.err != nil:
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("size=%d: invalid error: got=%+v, want=%+v", tc.size, err, tc.err)
+			}
+			if ok {
+				t.Fatalf("size=%d: unexpected reassembled frame", tc.size)
+			}
+		default:
+			if err != nil {
+				t.Fatalf("size=%d: could not reassemble frame: %+v", tc.size, err)
+			}
+			if !ok || !reflect.DeepEqual(got, want) {
+				t.Fatalf("size=%d: invalid reassembled frame", tc.size)
+			}
+		}
+	}
+}
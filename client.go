@@ -7,11 +7,14 @@ package tdaq // import "github.com/go-daq/tdaq"
 import (
 	"context"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/go-daq/tdaq/fsm"
-	"github.com/go-daq/tdaq/internal/iomux"
+	"github.com/go-daq/tdaq/iomux"
 	"github.com/go-daq/tdaq/log"
 	"go.nanomsg.org/mangos/v3"
 )
@@ -21,32 +24,87 @@ type client struct {
 	name string
 	addr string
 	msg  log.MsgStream
+	ts   TimeSource // source of the time and tickers of run-ctl (may be nil)
 
-	quit chan int
+	quit   chan int
+	feed   *feed
+	alerts *alerter          // alert notifications (may be nil)
+	alarms *alarmDB          // alarms tracked by run-ctl (may be nil)
+	halts  chan<- AlarmFrame // alarms requesting to stop the current run (may be nil)
+	hooks  *hooks            // callbacks of embedders on run-ctl events (may be nil)
 
-	mu     sync.RWMutex
-	status fsm.Status
-	ieps   []EndPoint
-	oeps   []EndPoint
+	mu       sync.RWMutex
+	status   fsm.Status
+	links    []LinkStatus         // status of the incoming data links
+	hists    map[string]*linkHist // queue occupancy history of the incoming data links, by address
+	rtt      time.Duration        // round-trip time of the last heartbeat
+	clk      clockFilter          // clock exchanges with the process
+	clock    ClockOffset          // estimated clock offset of the process
+	stats    RunStats             // last reported run counters of the process
+	rt       RuntimeStats         // last reported statistics of the Go runtime of the process
+	leaks    leakHist             // history of the statistics of the Go runtime of the process
+	ieps     []EndPoint
+	oeps     []EndPoint
+	acks     map[string]string  // addresses of the ack sockets of output end-points in acknowledged mode
+	credits  map[string]string  // addresses of the credit sockets of output end-points under flow control
+	mons     map[string]float64 // last values of monitoring variables
+	proto    uint8              // negotiated version of the TDAQ wire protocol
+	maxFrame int                // negotiated maximum frame size
+	barrier  bool               // whether the process supports barrier-synchronized starts
+	twoPhase bool               // whether the process supports two-phase transitions
+	reconfig bool               // whether the process supports /reconfig
+	liveConf bool               // whether the process supports /reconfig while running
+	svcs     string             // address of the services socket of the process (empty if none)
+	topics   string             // address of the topics socket of the process (empty if none)
+	pubs     []string           // topics published by the process
+	subs     []string           // topic patterns subscribed to by the process
+	calibs   []string           // names of the calibration constants consumed by the process
+	orders   map[string]string  // byte orders declared by the end-points of the process
+	encs     map[string]string  // payload encodings declared by the output end-points of the process
+	codecs   []string           // payload encodings supported by the input end-points of the process
+	watch    watch              // liveness of the process, as seen by the run-ctl watchdog
+	lost     bool               // whether the process was reported lost
 
 	cmd   mangos.Socket
+	reqs  chan cmdReq // queue of the exchanges on the command socket
 	hbeat mangos.Socket
 	log   mangos.Socket
 }
 
-func newClient(ctx context.Context, msg log.MsgStream, freq time.Duration, join JoinCmd, ctl, hbeat, log mangos.Socket, msgs chan<- MsgFrame, flog *iomux.Writer) *client {
+func newClient(ctx context.Context, msg log.MsgStream, ts TimeSource, freq time.Duration, join JoinCmd, ctl, hbeat, log mangos.Socket, msgs chan<- MsgFrame, flog *iomux.Writer, feed *feed) *client {
 	cli := &client{
-		name:   join.Name,
-		addr:   join.Ctl,
-		msg:    msg,
-		quit:   make(chan int),
-		status: fsm.UnConf,
-		ieps:   join.InEndPoints,
-		oeps:   join.OutEndPoints,
-		cmd:    ctl,
-		hbeat:  hbeat,
-		log:    log,
+		name:     join.Name,
+		addr:     join.Ctl,
+		msg:      msg,
+		ts:       ts,
+		quit:     make(chan int),
+		feed:     feed,
+		status:   fsm.UnConf,
+		ieps:     join.InEndPoints,
+		oeps:     join.OutEndPoints,
+		acks:     join.Acks,
+		credits:  join.Credits,
+		mons:     make(map[string]float64),
+		hists:    make(map[string]*linkHist),
+		proto:    negotiateProto(join.Proto),
+		barrier:  join.Barrier,
+		twoPhase: join.TwoPhase,
+		reconfig: join.Reconfig,
+		liveConf: join.ReconfigRunning,
+		svcs:     join.Services,
+		topics:   join.Topics,
+		pubs:     join.Pubs,
+		subs:     join.Subs,
+		calibs:   join.Calibs,
+		orders:   join.Orders,
+		encs:     join.Encodings,
+		codecs:   join.Codecs,
+		cmd:      ctl,
+		reqs:     make(chan cmdReq, cmdQueueSize),
+		hbeat:    hbeat,
+		log:      log,
 	}
+	go cli.cmdLoop(ctx)
 	go cli.hbeatLoop(ctx, freq)
 	go cli.logLoop(ctx, flog, msgs)
 	return cli
@@ -64,8 +122,226 @@ func (cli *client) setStatus(status fsm.Status) {
 	cli.status = status
 }
 
+// updateStatus sets the status of the client and publishes it on the
+// live feed if it changed.
+func (cli *client) updateStatus(status fsm.Status) {
+	cli.mu.Lock()
+	old := cli.status
+	cli.status = status
+	slow := cli.slow()
+	cli.mu.Unlock()
+
+	if old == status {
+		return
+	}
+	cli.feed.publish("proc", procStatus{
+		Name:   cli.name,
+		Status: status.String(),
+		Slow:   slow,
+	})
+}
+
+// slow returns whether the client is a slow consumer.
+// slow must be called with cli.mu held.
+func (cli *client) slow() bool {
+	for _, h := range cli.hists {
+		if h.slow {
+			return true
+		}
+	}
+	return false
+}
+
+// getClock returns the estimated clock offset of the process.
+func (cli *client) getClock() ClockOffset {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return cli.clock
+}
+
+func (cli *client) getRTT() time.Duration {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return cli.rtt
+}
+
+// linkReport returns the status of the incoming data links of the client,
+// and whether the client is a slow consumer.
+func (cli *client) linkReport() ([]linkStatus, bool) {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+
+	var (
+		links []linkStatus
+		slow  bool
+	)
+	for _, link := range cli.links {
+		st := newLinkStatus(link)
+		if h, ok := cli.hists[link.Addr]; ok {
+			st.Occupancy = append([]uint32(nil), h.occ...)
+			st.Slow = h.slow
+			slow = slow || h.slow
+		}
+		links = append(links, st)
+	}
+	return links, slow
+}
+
+// setStats sets the last reported run counters of the client.
+func (cli *client) setStats(stats RunStats) {
+	cli.mu.Lock()
+	cli.stats = stats
+	cli.mu.Unlock()
+}
+
+// setRuntime sets the last reported statistics of the Go runtime of the
+// client.
+// Processes that start or stop leaking goroutines or memory are reported.
+func (cli *client) setRuntime(rt RuntimeStats) {
+	cli.mu.Lock()
+	cli.rt = rt
+	changed := cli.leaks.add(rt)
+	leak := cli.leaks.leak
+	cli.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	switch leak {
+	case true:
+		cli.msg.Warnf("process %q is leaking (goroutines=%d, heap-inuse=%d)", cli.name, rt.Goroutines, rt.HeapInuse)
+		cli.alerts.raise(AlertLeak, cli.name, "leaking goroutines or memory (goroutines=%d, heap-inuse=%d)", rt.Goroutines, rt.HeapInuse)
+	default:
+		cli.msg.Infof("process %q stopped leaking (goroutines=%d, heap-inuse=%d)", cli.name, rt.Goroutines, rt.HeapInuse)
+	}
+}
+
+// runtimeReport returns the last reported statistics of the Go runtime of
+// the client, or nil if none was reported.
+func (cli *client) runtimeReport() *runtimeStatus {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+
+	if cli.rt == (RuntimeStats{}) {
+		return nil
+	}
+	return &runtimeStatus{
+		Goroutines: cli.rt.Goroutines,
+		HeapInuse:  cli.rt.HeapInuse,
+		NumGC:      cli.rt.NumGC,
+		PauseTotal: cli.rt.PauseTotal.String(),
+		Leak:       cli.leaks.leak,
+	}
+}
+
+// getStats returns the last reported run counters of the client.
+func (cli *client) getStats() RunStats {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return cli.stats
+}
+
+// latencyReport returns the latency of the data frames of the input
+// end-points of the client.
+func (cli *client) latencyReport() []latencyStatus {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+
+	var lats []latencyStatus
+	for _, ep := range cli.stats.Inputs {
+		if ep.Latency == nil {
+			continue
+		}
+		lats = append(lats, newLatencyStatus(ep.Name, *ep.Latency))
+	}
+	return lats
+}
+
+// setLinks sets the status of the incoming data links of the client.
+// Links that dropped, stalled or could not keep up with their producer
+// since the last update are reported.
+func (cli *client) setLinks(links []LinkStatus) {
+	cli.mu.Lock()
+	old := make(map[string]LinkStatus, len(cli.links))
+	for _, link := range cli.links {
+		old[link.Addr] = link
+	}
+	now := cli.now()
+	cli.links = links
+	cli.watch.links(now, links)
+
+	var (
+		changed []LinkStatus
+		hists   = make(map[string]*linkHist, len(links))
+	)
+	for _, link := range links {
+		h, ok := cli.hists[link.Addr]
+		if !ok {
+			h = new(linkHist)
+		}
+		hists[link.Addr] = h
+		h.sample(now, link)
+		if h.add(link) {
+			changed = append(changed, link)
+		}
+	}
+	cli.hists = hists
+	status := cli.status
+	slow := cli.slow()
+	cli.mu.Unlock()
+
+	for _, link := range changed {
+		switch hists[link.Addr].slow {
+		case true:
+			cli.msg.Warnf("slow consumer: %q cannot keep up with data from %q for %v (queued=%d/%d)",
+				cli.name, link.Addr, link.EndPoints, link.Queued, link.Capacity,
+			)
+		default:
+			cli.msg.Infof("consumer %q caught up with data from %q for %v", cli.name, link.Addr, link.EndPoints)
+		}
+	}
+	if len(changed) > 0 {
+		cli.feed.publish("proc", procStatus{
+			Name:   cli.name,
+			Status: status.String(),
+			Slow:   slow,
+		})
+	}
+
+	for _, link := range links {
+		prev := old[link.Addr]
+		if link.Outages != prev.Outages {
+			cli.msg.Warnf("data link of %q to %q for %v dropped (outages=%d, up=%v)",
+				cli.name, link.Addr, link.EndPoints, link.Outages, link.Up,
+			)
+		}
+		if link.Stalled && !prev.Stalled {
+			cli.msg.Warnf("data link of %q to %q for %v stalled (last frame: %v)",
+				cli.name, link.Addr, link.EndPoints, link.LastFrame,
+			)
+			cli.alerts.raise(AlertStall, cli.name, "data link to %q for %v stalled", link.Addr, link.EndPoints)
+		}
+	}
+}
+
+func (cli *client) setMon(mon MonFrame) {
+	cli.mu.Lock()
+	cli.mons[mon.Var] = mon.Value
+	cli.mu.Unlock()
+
+	cli.feed.publish("mon", struct {
+		Name  string  `json:"name"`
+		Var   string  `json:"var"`
+		Value float64 `json:"value"`
+	}{cli.name, mon.Var, mon.Value})
+}
+
 func (cli *client) hbeatLoop(ctx context.Context, freq time.Duration) {
-	ticks := time.NewTicker(freq)
+	ts := cli.ts
+	if ts == nil {
+		ts = wallClock{}
+	}
+	ticks := ts.NewTicker(freq)
 	defer ticks.Stop()
 
 	for {
@@ -74,12 +350,77 @@ func (cli *client) hbeatLoop(ctx context.Context, freq time.Duration) {
 			return
 		case <-cli.quit:
 			return
-		case <-ticks.C:
+		case <-ticks.C():
 			cli.doHBeat(ctx)
 		}
 	}
 }
 
+// cmdQueueSize is the maximum number of exchanges queued on the command
+// socket of a process.
+const cmdQueueSize = 16
+
+// errCmdQueueFull is returned when the command queue of a process is full.
+var errCmdQueueFull = errors.New("command queue full")
+
+// cmdReq is an exchange queued on the command socket of a process.
+type cmdReq struct {
+	ctx  context.Context
+	send func(sck mangos.Socket) error // sends the command
+	resp chan cmdResp
+}
+
+type cmdResp struct {
+	ack Frame
+	err error
+}
+
+// exchange queues the sending of a command on the command socket of the
+// process and waits for its reply.
+//
+// Exchanges are performed, in order, by the writer goroutine of the
+// process, so commands to different processes never contend on a lock and
+// a stuck process only blocks its own queue.
+func (cli *client) exchange(ctx context.Context, send func(sck mangos.Socket) error) (Frame, error) {
+	req := cmdReq{ctx: ctx, send: send, resp: make(chan cmdResp, 1)}
+	select {
+	case cli.reqs <- req:
+	case <-cli.quit:
+		return Frame{}, fmt.Errorf("could not queue command for %q: %w", cli.name, mangos.ErrClosed)
+	default:
+		return Frame{}, fmt.Errorf("could not queue command for %q: %w", cli.name, errCmdQueueFull)
+	}
+
+	select {
+	case resp := <-req.resp:
+		return resp.ack, resp.err
+	case <-ctx.Done():
+		return Frame{}, ctx.Err()
+	case <-cli.quit:
+		return Frame{}, fmt.Errorf("could not exchange command with %q: %w", cli.name, mangos.ErrClosed)
+	}
+}
+
+// cmdLoop performs the exchanges queued on the command socket of the
+// process.
+func (cli *client) cmdLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cli.quit:
+			return
+		case req := <-cli.reqs:
+			var resp cmdResp
+			resp.err = req.send(cli.cmd)
+			if resp.err == nil {
+				resp.ack, resp.err = RecvFrame(req.ctx, cli.cmd)
+			}
+			req.resp <- resp
+		}
+	}
+}
+
 func (cli *client) logLoop(ctx context.Context, flog *iomux.Writer, msgs chan<- MsgFrame) {
 	for {
 		frame, err := RecvFrame(ctx, cli.log)
@@ -100,6 +441,42 @@ func (cli *client) logLoop(ctx context.Context, flog *iomux.Writer, msgs chan<-
 			}
 			continue
 		}
+
+		if frame.Path == "/mon" {
+			var mon MonFrame
+			err = mon.UnmarshalTDAQ(frame.Body)
+			if err != nil {
+				cli.msg.Errorf("could not unmarshal /mon frame from (%s, %s): %+v", cli.name, cli.addr, err)
+				continue
+			}
+			cli.setMon(mon)
+			continue
+		}
+
+		if frame.Path == "/alarm" {
+			var alarm AlarmFrame
+			err = alarm.UnmarshalTDAQ(frame.Body)
+			if err != nil {
+				cli.msg.Errorf("could not unmarshal /alarm frame from (%s, %s): %+v", cli.name, cli.addr, err)
+				continue
+			}
+			if cli.alarms == nil {
+				continue
+			}
+			err = cli.alarms.update(alarm)
+			if err != nil {
+				cli.msg.Errorf("could not record alarm %q from (%s, %s): %+v", alarm.Alarm, cli.name, cli.addr, err)
+			}
+			if alarm.Active && alarm.Halt && cli.halts != nil {
+				select {
+				case cli.halts <- alarm:
+				default:
+					// a stop of the run is already pending.
+				}
+			}
+			continue
+		}
+
 		var msg MsgFrame
 		err = msg.UnmarshalTDAQ(frame.Body)
 		if err != nil {
@@ -124,18 +501,27 @@ func (cli *client) logLoop(ctx context.Context, flog *iomux.Writer, msgs chan<-
 }
 
 func (cli *client) doHBeat(ctx context.Context) {
-	cmd := StatusCmd{Name: cli.name}
-	err := SendCmd(ctx, cli.hbeat, &cmd)
+	beg := cli.now()
+	cmd := StatusCmd{Name: cli.name, Sent: beg, Clock: cli.getClock()}
+	err := sendCmdProto(ctx, cli.hbeat, &cmd, cli.proto)
 	if err != nil {
 		cli.msg.Errorf("could not send /status heartbeat to %s: %+v", cli.name, err)
+		cli.setLost(fmt.Sprintf("could not send heartbeat: %v", err))
 		return
 	}
 
 	ack, err := RecvFrame(ctx, cli.hbeat)
 	if err != nil {
 		cli.msg.Errorf("could not receive ACK: %+v", err)
+		cli.setLost(fmt.Sprintf("could not receive heartbeat reply: %v", err))
 		return
 	}
+	end := cli.now()
+	cli.mu.Lock()
+	cli.rtt = end.Sub(beg)
+	cli.watch.beat = end
+	cli.lost = false
+	cli.mu.Unlock()
 	switch ack.Type {
 	case FrameCmd:
 		cmd, err := newStatusCmd(ack)
@@ -143,7 +529,15 @@ func (cli *client) doHBeat(ctx context.Context) {
 			cli.msg.Errorf("could not receive /status heartbeat reply for %q: %+v", cli.name, err)
 			return
 		}
-		cli.setStatus(cmd.Status)
+		if !cmd.Recv.IsZero() && !cmd.Sent.IsZero() {
+			cli.mu.Lock()
+			cli.clock = cli.clk.add(beg, cmd.Recv, cmd.Sent, end)
+			cli.mu.Unlock()
+		}
+		cli.updateStatus(cmd.Status)
+		cli.setLinks(cmd.Links)
+		cli.setStats(cmd.Stats)
+		cli.setRuntime(cmd.Runtime)
 
 	default:
 		cli.msg.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
@@ -151,6 +545,33 @@ func (cli *client) doHBeat(ctx context.Context) {
 	}
 }
 
+// setLost reports the process as lost, for the provided reason, unless it
+// was already reported lost since its last heartbeat reply or it is exiting.
+func (cli *client) setLost(why string) {
+	select {
+	case <-cli.quit:
+		return
+	default:
+	}
+
+	cli.mu.Lock()
+	lost := cli.lost
+	cli.lost = true
+	cli.mu.Unlock()
+
+	if !lost {
+		cli.hooks.deviceLost(cli.name, why)
+	}
+}
+
+// isLost returns whether the process was reported lost since its last
+// heartbeat reply.
+func (cli *client) isLost() bool {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return cli.lost
+}
+
 func (cli *client) kill() {
 	cli.mu.Lock()
 	defer cli.mu.Unlock()
@@ -181,3 +602,113 @@ func (cli *client) close() error {
 
 	return nil
 }
+
+// clientShards is the number of shards of the registry of processes.
+const clientShards = 32
+
+// clientDB is the registry of the TDAQ processes connected to run-ctl,
+// indexed by name.
+//
+// The registry is sharded, so lookups of processes (status reports,
+// heartbeats, joins and leaves, ...) do not contend on a single lock, nor
+// on the lock of run-ctl.
+type clientDB struct {
+	shards [clientShards]clientShard
+}
+
+type clientShard struct {
+	mu   sync.RWMutex
+	clis map[string]*client
+}
+
+func newClientDB(clis ...*client) *clientDB {
+	db := new(clientDB)
+	for i := range db.shards {
+		db.shards[i].clis = make(map[string]*client)
+	}
+	for _, cli := range clis {
+		db.add(cli)
+	}
+	return db
+}
+
+func (db *clientDB) shard(name string) *clientShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return &db.shards[h.Sum32()%clientShards]
+}
+
+// get returns the named process, or nil if it is not connected.
+func (db *clientDB) get(name string) *client {
+	if db == nil {
+		return nil
+	}
+	s := db.shard(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clis[name]
+}
+
+func (db *clientDB) add(cli *client) {
+	s := db.shard(cli.name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clis[cli.name] = cli
+}
+
+// del removes the named process from the registry and returns it, or nil
+// if it was not connected.
+func (db *clientDB) del(name string) *client {
+	if db == nil {
+		return nil
+	}
+	s := db.shard(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cli := s.clis[name]
+	delete(s.clis, name)
+	return cli
+}
+
+// len returns the number of connected processes.
+func (db *clientDB) len() int {
+	if db == nil {
+		return 0
+	}
+	n := 0
+	for i := range db.shards {
+		s := &db.shards[i]
+		s.mu.RLock()
+		n += len(s.clis)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// list returns the connected processes, sorted by name.
+func (db *clientDB) list() []*client {
+	if db == nil {
+		return nil
+	}
+	var clis []*client
+	for i := range db.shards {
+		s := &db.shards[i]
+		s.mu.RLock()
+		for _, cli := range s.clis {
+			clis = append(clis, cli)
+		}
+		s.mu.RUnlock()
+	}
+	sort.Slice(clis, func(i, j int) bool { return clis[i].name < clis[j].name })
+	return clis
+}
+
+// names returns the sorted names of the connected processes.
+func (db *clientDB) names() []string {
+	clis := db.list()
+	names := make([]string, len(clis))
+	for i, cli := range clis {
+		names[i] = cli.name
+	}
+	return names
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/log"
+	"go.nanomsg.org/mangos/v3"
+)
+
+func TestClientDB(t *testing.T) {
+	db := newClientDB()
+	const n = 100
+	for i := n - 1; i >= 0; i-- {
+		db.add(&client{name: fmt.Sprintf("proc-%03d", i)})
+	}
+	if got, want := db.len(), n; got != want {
+		t.Fatalf("invalid number of processes:\ngot = %d\nwant= %d", got, want)
+	}
+	names := db.names()
+	if !sort.StringsAreSorted(names) || len(names) != n {
+		t.Fatalf("invalid names: %q", names)
+	}
+	if cli := db.get("proc-042"); cli == nil || cli.name != "proc-042" {
+		t.Fatalf("could not find proc-042")
+	}
+	if cli := db.del("proc-042"); cli == nil {
+		t.Fatalf("could not remove proc-042")
+	}
+	if cli := db.get("proc-042"); cli != nil {
+		t.Fatalf("proc-042 still registered")
+	}
+	if got, want := db.len(), n-1; got != want {
+		t.Fatalf("invalid number of processes:\ngot = %d\nwant= %d", got, want)
+	}
+
+	var nilDB *clientDB
+	if nilDB.get("proc") != nil || nilDB.len() != 0 || nilDB.list() != nil {
+		t.Fatalf("invalid nil registry")
+	}
+
+	// no writer goroutine: the command queue is always full.
+	cli := &client{name: "stuck", quit: make(chan int), reqs: make(chan cmdReq)}
+	_, err := cli.exchange(context.Background(), func(mangos.Socket) error { return nil })
+	if !errors.Is(err, errCmdQueueFull) {
+		t.Fatalf("invalid error:\ngot = %+v\nwant= %+v", err, errCmdQueueFull)
+	}
+}
+
+func BenchmarkSendCmd(b *testing.B) {
+	ctx := context.Background()
+	cmd := StatusCmd{
+		Name:   "proc",
+		Status: fsm.Running,
+		Sent:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Links:  []LinkStatus{{Addr: "tcp://gen:1234", EndPoints: []string{"/adc"}, Up: true, Frames: 10}},
+	}
+	for _, bc := range []struct {
+		name string
+		send func() error
+	}{
+		{"status", func() error { return SendCmd(ctx, discardMsgSocket{}, &cmd) }},
+		{"start", func() error { return sendCmd(ctx, discardMsgSocket{}, CmdStart, nil) }},
+		{"log", func() error {
+			return SendMsg(ctx, discardMsgSocket{}, MsgFrame{Name: "proc", Level: log.LvlInfo, Msg: "hello"})
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := bc.send()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
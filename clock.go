@@ -0,0 +1,138 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"sync"
+	"time"
+)
+
+// clockSamples is the number of clock exchanges kept to estimate the clock
+// offset of a TDAQ process.
+const clockSamples = 8
+
+// ClockOffset describes the estimated offset of the clock of a TDAQ process
+// with respect to the clock of run-ctl.
+//
+// Offsets are measured with an NTP-style exchange over the heartbeat link:
+// the estimate comes from the exchange with the smallest round-trip delay
+// among the last few ones.
+type ClockOffset struct {
+	Offset time.Duration // clock of the process minus clock of run-ctl
+	Delay  time.Duration // round-trip delay of the exchange the offset was measured with
+	Skew   float64       // drift rate of the offset, in parts per million
+	Time   time.Time     // time of the last exchange, by the clock of run-ctl (zero if none)
+}
+
+// clockSample is a clock exchange, with:
+//   - t0: time at which run-ctl sent its request (run-ctl clock),
+//   - t1: time at which the process received the request (process clock),
+//   - t2: time at which the process sent its reply (process clock),
+//   - t3: time at which run-ctl received the reply (run-ctl clock).
+type clockSample struct {
+	t0     time.Time
+	offset time.Duration
+	delay  time.Duration
+}
+
+func newClockSample(t0, t1, t2, t3 time.Time) clockSample {
+	return clockSample{
+		t0:     t0,
+		offset: (t1.Sub(t0) + t2.Sub(t3)) / 2,
+		delay:  t3.Sub(t0) - t2.Sub(t1),
+	}
+}
+
+// clockFilter estimates the clock offset of a process from its last
+// clock exchanges.
+type clockFilter struct {
+	samples []clockSample // last clock exchanges, oldest first
+}
+
+func (f *clockFilter) add(t0, t1, t2, t3 time.Time) ClockOffset {
+	f.samples = append(f.samples, newClockSample(t0, t1, t2, t3))
+	if n := len(f.samples); n > clockSamples {
+		f.samples = append(f.samples[:0], f.samples[n-clockSamples:]...)
+	}
+	return f.estimate()
+}
+
+func (f *clockFilter) estimate() ClockOffset {
+	if len(f.samples) == 0 {
+		return ClockOffset{}
+	}
+
+	best := f.samples[0]
+	for _, s := range f.samples[1:] {
+		if s.delay < best.delay {
+			best = s
+		}
+	}
+
+	return ClockOffset{
+		Offset: best.offset,
+		Delay:  best.delay,
+		Skew:   f.skew(),
+		Time:   f.samples[len(f.samples)-1].t0.UTC(),
+	}
+}
+
+// skew returns the slope of the measured offsets over time, in parts per
+// million, from a least-squares fit.
+func (f *clockFilter) skew() float64 {
+	if len(f.samples) < 2 {
+		return 0
+	}
+
+	var (
+		ref = f.samples[0].t0
+		n   = float64(len(f.samples))
+
+		sx, sy, sxx, sxy float64
+	)
+	for _, s := range f.samples {
+		x := s.t0.Sub(ref).Seconds()
+		y := s.offset.Seconds()
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / den * 1e6
+}
+
+// Clock holds the offset of the clock of a TDAQ process with respect to
+// the clock of run-ctl, as last estimated and published by run-ctl.
+// It can be used to align the timestamps of different hosts.
+type Clock struct {
+	mu  sync.RWMutex
+	off ClockOffset
+}
+
+// Offset returns the last estimate of the clock offset.
+// Offset returns a zero value until run-ctl published a first estimate.
+func (clk *Clock) Offset() ClockOffset {
+	if clk == nil {
+		return ClockOffset{}
+	}
+	clk.mu.RLock()
+	defer clk.mu.RUnlock()
+	return clk.off
+}
+
+// RunCtlTime converts the provided local time to the clock of run-ctl.
+func (clk *Clock) RunCtlTime(t time.Time) time.Time {
+	return t.Add(-clk.Offset().Offset)
+}
+
+func (clk *Clock) set(off ClockOffset) {
+	clk.mu.Lock()
+	clk.off = off
+	clk.mu.Unlock()
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockFilter(t *testing.T) {
+	var (
+		f   clockFilter
+		t0  = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		off = 3 * time.Millisecond // clock of the process is ahead by 3ms
+		got ClockOffset
+	)
+
+	for i, delay := range []time.Duration{
+		4 * time.Millisecond,
+		1 * time.Millisecond,
+		6 * time.Millisecond,
+	} {
+		beg := t0.Add(time.Duration(i) * time.Second)
+		// asymmetric paths bias the offset of slow exchanges.
+		t1 := beg.Add(off + 3*delay/4)
+		t2 := t1.Add(100 * time.Microsecond)
+		t3 := t2.Add(-off + delay/4)
+		got = f.add(beg, t1, t2, t3)
+	}
+
+	want := ClockOffset{
+		Offset: off + 250*time.Microsecond,
+		Delay:  1 * time.Millisecond,
+		Skew:   got.Skew,
+		Time:   t0.Add(2 * time.Second),
+	}
+	if got != want {
+		t.Fatalf("invalid clock offset:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+
+	var clk *Clock
+	if got := clk.Offset(); got != (ClockOffset{}) {
+		t.Fatalf("invalid clock offset of a nil clock: %#v", got)
+	}
+	clk = new(Clock)
+	clk.set(want)
+	if got, want := clk.RunCtlTime(t0), t0.Add(-want.Offset); !got.Equal(want) {
+		t.Fatalf("invalid run-ctl time: got=%v, want=%v", got, want)
+	}
+}
@@ -6,29 +6,12 @@
 package main
 
 import (
-	"context"
-	"os"
-
 	"github.com/go-daq/tdaq"
-	"github.com/go-daq/tdaq/flags"
 	"github.com/go-daq/tdaq/log"
 )
 
 func main() {
-	cmd := flags.New()
-
-	dev := device{}
-	srv := tdaq.New(cmd, os.Stdout)
-	srv.CmdHandle("/config", dev.OnConfig)
-	srv.CmdHandle("/init", dev.OnInit)
-	srv.CmdHandle("/reset", dev.OnReset)
-	srv.CmdHandle("/start", dev.OnStart)
-	srv.CmdHandle("/stop", dev.OnStop)
-	srv.CmdHandle("/quit", dev.OnQuit)
-
-	srv.InputHandle("/adc", dev.adc)
-
-	err := srv.Run(context.Background())
+	err := tdaq.Serve(&device{})
 	if err != nil {
 		log.Panicf("error: %+v", err)
 	}
@@ -71,6 +54,12 @@ func (dev *device) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) er
 	return nil
 }
 
+func (dev *device) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{
+		"/adc": dev.adc,
+	}
+}
+
 func (dev *device) adc(ctx tdaq.Context, src tdaq.Frame) error {
 	ctx.Msg.Debugf("received: %q (%d) -> %v", src.Body[:imin(len(src.Body), 16)], len(src.Body), dev.n)
 	dev.n++
@@ -2,13 +2,36 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Command tdaq-datasrc is a simple program that generates data.
+// Command tdaq-datasrc generates data on one or more output end-points.
+//
+// Payloads are generated at a given rate, in bursts of back-to-back
+// payloads, and are filled with values drawn from a distribution:
+//   - uniform: random bytes,
+//   - gauss:   little-endian float64 values, of mean -mean and width -sigma,
+//   - poisson: little-endian uint64 counts, of mean -lambda.
+//
+// Payloads of the gauss and poisson distributions hold size/8 values.
+//
+// With -n > 1, the payloads are distributed over n parallel output
+// end-points, named after -o with a numerical suffix (e.g. /adc0, /adc1, ...).
+//
+// The generation parameters may be modified at /config, from the values of
+// the key-value configuration store of run-ctl under the name of the
+// process, e.g. "datasrc/rate" or "datasrc/dist".
+//
+// ex:
+//
+//	$> tdaq-datasrc -id datasrc -o /adc -n 4 -size 4096 -rate 1000 -burst 10 -dist gauss
 package main
 
 import (
 	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
 	"math/rand"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/go-daq/tdaq"
@@ -17,32 +40,155 @@ import (
 )
 
 func main() {
-	cmd := flags.New()
+	var (
+		p     params
+		oname = flag.String("o", "/adc", "name of the output data stream end-point(s)")
+		nouts = flag.Int("n", 1, "number of parallel output end-points")
+		seed  = flag.Int64("seed", 1234, "seed for the random number generator")
+	)
+	flag.IntVar(&p.size, "size", 1024, "size in bytes of the generated payloads")
+	flag.Float64Var(&p.rate, "rate", 10, "rate in Hz of the generated payloads (0: as fast as possible)")
+	flag.IntVar(&p.burst, "burst", 1, "number of payloads generated back-to-back in a burst")
+	flag.StringVar(&p.dist, "dist", "uniform", "distribution of the payload values (uniform, gauss, poisson)")
+	flag.Float64Var(&p.mean, "mean", 0, "mean of the gauss distribution")
+	flag.Float64Var(&p.sigma, "sigma", 1, "width of the gauss distribution")
+	flag.Float64Var(&p.lambda, "lambda", 1, "mean of the poisson distribution")
+
+	cmd := flags.New(flags.WithLinkFlags(), flags.WithDeliveryFlags(), flags.WithDebugFlags())
+
+	err := p.validate()
+	if err != nil {
+		log.Fatalf("invalid generation parameters: %+v", err)
+	}
+	if *nouts < 1 {
+		log.Fatalf("invalid number of output end-points (n=%d)", *nouts)
+	}
 
 	dev := datasrc{
-		seed: 1234,
+		seed: *seed,
+		def:  p,
 	}
 
-	srv := tdaq.New(cmd, os.Stdout)
-	srv.CmdHandle("/config", dev.OnConfig)
-	srv.CmdHandle("/init", dev.OnInit)
-	srv.CmdHandle("/reset", dev.OnReset)
-	srv.CmdHandle("/start", dev.OnStart)
-	srv.CmdHandle("/stop", dev.OnStop)
-	srv.CmdHandle("/quit", dev.OnQuit)
+	opts := []tdaq.ServeOption{tdaq.WithConfig(cmd)}
+	for i := 0; i < *nouts; i++ {
+		name := *oname
+		if *nouts > 1 {
+			name = fmt.Sprintf("%s%d", *oname, i)
+		}
+		opts = append(opts, tdaq.WithOutput(name, dev.output))
+	}
 
-	srv.OutputHandle("/adc", dev.adc)
+	err = tdaq.Serve(&dev, opts...)
+	if err != nil {
+		log.Panicf("error: %+v", err)
+	}
+}
+
+// params holds the generation parameters of the data source.
+type params struct {
+	size   int     // size of the payloads, in bytes
+	rate   float64 // rate of the payloads, in Hz
+	burst  int     // number of payloads per burst
+	dist   string  // distribution of the payload values
+	mean   float64 // mean of the gauss distribution
+	sigma  float64 // width of the gauss distribution
+	lambda float64 // mean of the poisson distribution
+}
 
-	srv.RunHandle(dev.run)
+// keys are the names of the generation parameters settable at /config.
+var keys = []string{"size", "rate", "burst", "dist", "mean", "sigma", "lambda"}
 
-	err := srv.Run(context.Background())
+func (p *params) set(key, v string) error {
+	var err error
+	switch key {
+	case "size":
+		p.size, err = strconv.Atoi(v)
+	case "rate":
+		p.rate, err = strconv.ParseFloat(v, 64)
+	case "burst":
+		p.burst, err = strconv.Atoi(v)
+	case "dist":
+		p.dist = v
+	case "mean":
+		p.mean, err = strconv.ParseFloat(v, 64)
+	case "sigma":
+		p.sigma, err = strconv.ParseFloat(v, 64)
+	case "lambda":
+		p.lambda, err = strconv.ParseFloat(v, 64)
+	default:
+		return fmt.Errorf("invalid parameter %q", key)
+	}
 	if err != nil {
-		log.Panicf("error: %+v", err)
+		return fmt.Errorf("could not parse parameter %q: %w", key, err)
+	}
+	return nil
+}
+
+func (p params) validate() error {
+	switch {
+	case p.size < 0:
+		return fmt.Errorf("invalid payload size (size=%d)", p.size)
+	case p.rate < 0:
+		return fmt.Errorf("invalid rate (rate=%g)", p.rate)
+	case p.burst < 1:
+		return fmt.Errorf("invalid burst size (burst=%d)", p.burst)
+	case p.sigma < 0:
+		return fmt.Errorf("invalid gauss width (sigma=%g)", p.sigma)
+	case p.lambda < 0:
+		return fmt.Errorf("invalid poisson mean (lambda=%g)", p.lambda)
 	}
+	switch p.dist {
+	case "uniform", "gauss", "poisson":
+	default:
+		return fmt.Errorf("invalid distribution %q", p.dist)
+	}
+	return nil
+}
+
+// fill fills the payload with values drawn from the distribution.
+func (p params) fill(rnd *rand.Rand, buf []byte) {
+	switch p.dist {
+	case "uniform":
+		rnd.Read(buf)
+	case "gauss":
+		for i := 0; i+8 <= len(buf); i += 8 {
+			v := p.mean + p.sigma*rnd.NormFloat64()
+			binary.LittleEndian.PutUint64(buf[i:], math.Float64bits(v))
+		}
+	case "poisson":
+		for i := 0; i+8 <= len(buf); i += 8 {
+			binary.LittleEndian.PutUint64(buf[i:], poisson(rnd, p.lambda))
+		}
+	}
+}
+
+// poisson draws a count from a Poisson distribution of mean lambda.
+func poisson(rnd *rand.Rand, lambda float64) uint64 {
+	if lambda > 30 {
+		// normal approximation.
+		v := math.Floor(lambda + math.Sqrt(lambda)*rnd.NormFloat64() + 0.5)
+		if v < 0 {
+			return 0
+		}
+		return uint64(v)
+	}
+
+	var (
+		lim = math.Exp(-lambda)
+		n   = uint64(0)
+		p   = rnd.Float64()
+	)
+	for p > lim {
+		n++
+		p *= rnd.Float64()
+	}
+	return n
 }
 
 type datasrc struct {
 	seed int64
+	def  params // generation parameters from the command line
+	cur  params // generation parameters of the current run
 	rnd  *rand.Rand
 
 	n    int
@@ -51,6 +197,25 @@ type datasrc struct {
 
 func (dev *datasrc) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
 	ctx.Msg.Debugf("received /config command...")
+	p := dev.def
+	for _, key := range keys {
+		v, ok := ctx.KV(key)
+		if !ok {
+			continue
+		}
+		err := p.set(key, v)
+		if err != nil {
+			return err
+		}
+	}
+	err := p.validate()
+	if err != nil {
+		return fmt.Errorf("invalid generation parameters: %w", err)
+	}
+	dev.cur = p
+	ctx.Msg.Infof("generating %d-byte payloads (dist=%s) at %g Hz, in bursts of %d",
+		p.size, p.dist, p.rate, p.burst,
+	)
 	return nil
 }
 
@@ -86,7 +251,7 @@ func (dev *datasrc) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) e
 	return nil
 }
 
-func (dev *datasrc) adc(ctx tdaq.Context, dst *tdaq.Frame) error {
+func (dev *datasrc) output(ctx tdaq.Context, dst *tdaq.Frame) error {
 	select {
 	case <-ctx.Ctx.Done():
 		dst.Body = nil
@@ -97,20 +262,43 @@ func (dev *datasrc) adc(ctx tdaq.Context, dst *tdaq.Frame) error {
 	return nil
 }
 
-func (dev *datasrc) run(ctx tdaq.Context) error {
+func (dev *datasrc) Run(ctx tdaq.Context) error {
+	p := dev.cur
+
+	var tick <-chan time.Time
+	if p.rate > 0 {
+		period := time.Duration(float64(p.burst) / p.rate * float64(time.Second))
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
 	for {
+		for i := 0; i < p.burst; i++ {
+			raw := make([]byte, p.size)
+			p.fill(dev.rnd, raw)
+			if !dev.send(ctx.Ctx, raw) {
+				return nil
+			}
+		}
+
+		if tick == nil {
+			continue
+		}
 		select {
 		case <-ctx.Ctx.Done():
 			return nil
-		default:
-			raw := make([]byte, 1024)
-			rand.Read(raw)
-			select {
-			case dev.data <- raw:
-				dev.n++
-			default:
-			}
+		case <-tick:
 		}
-		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (dev *datasrc) send(ctx context.Context, raw []byte) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case dev.data <- raw:
+		dev.n++
+		return true
 	}
 }
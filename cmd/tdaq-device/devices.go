@@ -0,0 +1,630 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/payload"
+	"github.com/go-daq/tdaq/xdaq"
+)
+
+func init() {
+	tdaq.RegisterDevice("i64-gen", newI64Gen)
+	tdaq.RegisterDevice("i64-dump", newI64Dump)
+	tdaq.RegisterDevice("i64-process", newI64Process)
+	tdaq.RegisterDevice("i64-adder", newI64Adder)
+	tdaq.RegisterDevice("file-src", newFileSrc)
+	tdaq.RegisterDevice("distributor", newDistributor)
+	tdaq.RegisterDevice("merger", newMerger)
+	tdaq.RegisterDevice("event-builder", newEventBuilder)
+	tdaq.RegisterDevice("sync-monitor", newSyncMonitor)
+	tdaq.RegisterDevice("filter", newFilter)
+	tdaq.RegisterDevice("downsampler", newDownsampler)
+	tdaq.RegisterDevice("histogrammer", newHistogrammer)
+	tdaq.RegisterDevice("dump", newDump)
+	tdaq.RegisterDevice("npy-sink", newNpySink)
+	tdaq.RegisterDevice("text-sink", newTextSink)
+}
+
+func param(params map[string]string, key, def string) string {
+	if v, ok := params[key]; ok {
+		return v
+	}
+	return def
+}
+
+type i64Gen struct {
+	xdaq.I64Gen
+	oname string
+}
+
+func newI64Gen(params map[string]string) (tdaq.Device, error) {
+	start, err := strconv.ParseInt(param(params, "start", "10"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse start parameter: %w", err)
+	}
+	freq, err := time.ParseDuration(param(params, "freq", "10ms"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse freq parameter: %w", err)
+	}
+	dev := &i64Gen{oname: param(params, "o", "/adc")}
+	dev.Start = start
+	dev.Freq = freq
+	return dev, nil
+}
+
+func (dev *i64Gen) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{dev.oname: dev.Output}
+}
+
+func (dev *i64Gen) Run(ctx tdaq.Context) error { return dev.Loop(ctx) }
+
+type i64Dump struct {
+	xdaq.I64Dumper
+	iname string
+}
+
+func newI64Dump(params map[string]string) (tdaq.Device, error) {
+	return &i64Dump{iname: param(params, "i", "/input")}, nil
+}
+
+func (dev *i64Dump) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{dev.iname: dev.Input}
+}
+
+type i64Process struct {
+	xdaq.I64Processor
+	iname string
+	oname string
+}
+
+func newI64Process(params map[string]string) (tdaq.Device, error) {
+	return &i64Process{
+		iname: param(params, "i", "/input"),
+		oname: param(params, "o", "/output"),
+	}, nil
+}
+
+func (dev *i64Process) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{dev.iname: dev.Input}
+}
+
+func (dev *i64Process) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{dev.oname: dev.Output}
+}
+
+type i64Adder struct {
+	xdaq.I64Adder
+	lname string
+	rname string
+	oname string
+}
+
+func newI64Adder(params map[string]string) (tdaq.Device, error) {
+	return &i64Adder{
+		lname: param(params, "il", "/left"),
+		rname: param(params, "ir", "/right"),
+		oname: param(params, "o", "/output"),
+	}, nil
+}
+
+func (dev *i64Adder) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{
+		dev.lname: dev.Left,
+		dev.rname: dev.Right,
+	}
+}
+
+func (dev *i64Adder) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{dev.oname: dev.Output}
+}
+
+type fileSrc struct {
+	xdaq.FileSrc
+	oname string
+}
+
+func newFileSrc(params map[string]string) (tdaq.Device, error) {
+	size, err := strconv.Atoi(param(params, "size", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse size parameter: %w", err)
+	}
+	repeat, err := strconv.ParseBool(param(params, "repeat", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse repeat parameter: %w", err)
+	}
+	rate, err := strconv.ParseFloat(param(params, "rate", "0"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse rate parameter: %w", err)
+	}
+	dev := &fileSrc{oname: param(params, "o", "/output")}
+	dev.Path = param(params, "path", ".")
+	dev.Size = size
+	dev.Repeat = repeat
+	dev.Rate = rate
+	return dev, nil
+}
+
+func (dev *fileSrc) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{dev.oname: dev.Output}
+}
+
+func (dev *fileSrc) Run(ctx tdaq.Context) error { return dev.Loop(ctx) }
+
+type distributor struct {
+	xdaq.Distributor
+	iname string
+	oname string
+}
+
+func newDistributor(params map[string]string) (tdaq.Device, error) {
+	n, err := strconv.Atoi(param(params, "n", "2"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse n parameter: %w", err)
+	}
+	dev := &distributor{
+		iname: param(params, "i", "/input"),
+		oname: param(params, "o", "/output"),
+	}
+	dev.N = n
+
+	// key=beg:end distributes frames by hash of the [beg, end) bytes of
+	// their payload.
+	if key := param(params, "key", ""); key != "" {
+		var beg, end int
+		_, err := fmt.Sscanf(key, "%d:%d", &beg, &end)
+		if err != nil || beg < 0 || end < beg {
+			return nil, fmt.Errorf("could not parse key parameter %q", key)
+		}
+		dev.Key = xdaq.KeyRange(beg, end)
+	}
+	return dev, nil
+}
+
+func (dev *distributor) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{dev.iname: dev.Input}
+}
+
+func (dev *distributor) Outputs() map[string]tdaq.OutputHandler {
+	oeps := make(map[string]tdaq.OutputHandler, dev.N)
+	for i := 0; i < dev.N; i++ {
+		oeps[fmt.Sprintf("%s%d", dev.oname, i)] = dev.Output(i)
+	}
+	return oeps
+}
+
+type merger struct {
+	xdaq.Merger
+	iname string
+	oname string
+	n     int
+}
+
+func newMerger(params map[string]string) (tdaq.Device, error) {
+	n, err := strconv.Atoi(param(params, "n", "2"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse n parameter: %w", err)
+	}
+	window, err := strconv.Atoi(param(params, "window", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse window parameter: %w", err)
+	}
+	delay, err := time.ParseDuration(param(params, "delay", "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse delay parameter: %w", err)
+	}
+	dev := &merger{
+		iname: param(params, "i", "/input"),
+		oname: param(params, "o", "/output"),
+		n:     n,
+	}
+	dev.Window = window
+	dev.MaxDelay = delay
+
+	// order=u64:off orders frames by the little-endian uint64 (sequence
+	// number, timestamp) at offset off of their payload.
+	if order := param(params, "order", ""); order != "" {
+		var off int
+		_, err := fmt.Sscanf(order, "u64:%d", &off)
+		if err != nil || off < 0 {
+			return nil, fmt.Errorf("could not parse order parameter %q", order)
+		}
+		dev.Order = xdaq.U64Key(off)
+	}
+	return dev, nil
+}
+
+func (dev *merger) Inputs() map[string]tdaq.InputHandler {
+	ieps := make(map[string]tdaq.InputHandler, dev.n)
+	for i := 0; i < dev.n; i++ {
+		ieps[fmt.Sprintf("%s%d", dev.iname, i)] = dev.Input
+	}
+	return ieps
+}
+
+func (dev *merger) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{dev.oname: dev.Output}
+}
+
+func (dev *merger) Run(ctx tdaq.Context) error { return dev.Loop(ctx) }
+
+type eventBuilder struct {
+	xdaq.EventBuilder
+	oname string
+}
+
+// newEventBuilder creates an event builder of n input streams, named
+// <i>0..<i>n-1, matching fragments by event ID or, with the time parameter,
+// by timestamp coincidence.
+func newEventBuilder(params map[string]string) (tdaq.Device, error) {
+	n, err := strconv.Atoi(param(params, "n", "2"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse n parameter: %w", err)
+	}
+	window, err := strconv.ParseUint(param(params, "window", "1"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse window parameter: %w", err)
+	}
+	lateness, err := strconv.ParseUint(param(params, "lateness", "0"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse lateness parameter: %w", err)
+	}
+	delay, err := time.ParseDuration(param(params, "delay", "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse delay parameter: %w", err)
+	}
+	drop, err := strconv.ParseBool(param(params, "drop-late", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse drop-late parameter: %w", err)
+	}
+
+	dev := &eventBuilder{oname: param(params, "o", "/output")}
+	iname := param(params, "i", "/input")
+	for i := 0; i < n; i++ {
+		dev.Streams = append(dev.Streams, fmt.Sprintf("%s%d", iname, i))
+	}
+	dev.Window = window
+	dev.Lateness = lateness
+	dev.MaxDelay = delay
+	dev.DropLate = drop
+
+	// time=u64:off builds events by coincidence of the little-endian uint64
+	// timestamps at offset off of the payloads.
+	if tm := param(params, "time", ""); tm != "" {
+		var off int
+		_, err := fmt.Sscanf(tm, "u64:%d", &off)
+		if err != nil || off < 0 {
+			return nil, fmt.Errorf("could not parse time parameter %q", tm)
+		}
+		dev.Time = xdaq.U64Key(off)
+	}
+	return dev, nil
+}
+
+func (dev *eventBuilder) Inputs() map[string]tdaq.InputHandler {
+	ieps := make(map[string]tdaq.InputHandler, len(dev.Streams))
+	for _, name := range dev.Streams {
+		ieps[name] = dev.Input
+	}
+	return ieps
+}
+
+func (dev *eventBuilder) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{dev.oname: dev.Output}
+}
+
+func (dev *eventBuilder) Run(ctx tdaq.Context) error { return dev.Loop(ctx) }
+
+type syncMonitor struct {
+	xdaq.SyncMonitor
+}
+
+// newSyncMonitor creates a monitor of the trigger numbers of n input
+// streams, named <i>0..<i>n-1.
+func newSyncMonitor(params map[string]string) (tdaq.Device, error) {
+	n, err := strconv.Atoi(param(params, "n", "2"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse n parameter: %w", err)
+	}
+	lag, err := strconv.Atoi(param(params, "max-lag", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse max-lag parameter: %w", err)
+	}
+	halt, err := strconv.ParseBool(param(params, "halt", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse halt parameter: %w", err)
+	}
+
+	dev := &syncMonitor{}
+	iname := param(params, "i", "/input")
+	for i := 0; i < n; i++ {
+		dev.Streams = append(dev.Streams, fmt.Sprintf("%s%d", iname, i))
+	}
+	dev.MaxLag = lag
+	dev.Halt = halt
+
+	// trigger=u64:off reads the trigger numbers from the little-endian
+	// uint64 at offset off of the payloads, instead of the event IDs.
+	if trig := param(params, "trigger", ""); trig != "" {
+		var off int
+		_, err := fmt.Sscanf(trig, "u64:%d", &off)
+		if err != nil || off < 0 {
+			return nil, fmt.Errorf("could not parse trigger parameter %q", trig)
+		}
+		dev.Trigger = xdaq.U64Key(off)
+	}
+	return dev, nil
+}
+
+func (dev *syncMonitor) Inputs() map[string]tdaq.InputHandler {
+	ieps := make(map[string]tdaq.InputHandler, len(dev.Streams))
+	for _, name := range dev.Streams {
+		ieps[name] = dev.Input
+	}
+	return ieps
+}
+
+type filter struct {
+	xdaq.Filter
+	iname string
+	oname string
+}
+
+// newFilter creates a filter of the payloads described by the schema
+// parameter (e.g. schema=evt:u64,energy:f64), or by the schema registered
+// for the type parameter.
+func newFilter(params map[string]string) (tdaq.Device, error) {
+	dev := &filter{
+		iname: param(params, "i", "/input"),
+		oname: param(params, "o", "/output"),
+	}
+	dev.Expr = param(params, "expr", "true")
+
+	switch typ := param(params, "type", ""); typ {
+	case "":
+		sch, err := payload.ParseSchema(param(params, "schema", ""))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse schema parameter: %w", err)
+		}
+		dev.Schema = sch
+	default:
+		sch, ok := payload.Lookup(typ)
+		if !ok {
+			return nil, fmt.Errorf("no schema registered for type %q", typ)
+		}
+		dev.Schema = sch
+	}
+	return dev, nil
+}
+
+func (dev *filter) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{dev.iname: dev.Input}
+}
+
+func (dev *filter) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{dev.oname: dev.Output}
+}
+
+type downsampler struct {
+	xdaq.Downsampler
+	iname string
+	oname string
+}
+
+func newDownsampler(params map[string]string) (tdaq.Device, error) {
+	every, err := strconv.Atoi(param(params, "every", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse every parameter: %w", err)
+	}
+	rate, err := strconv.ParseFloat(param(params, "rate", "0"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse rate parameter: %w", err)
+	}
+
+	dev := &downsampler{
+		iname: param(params, "i", "/input"),
+		oname: param(params, "o", "/output"),
+	}
+	dev.Every = every
+	dev.Rate = rate
+	return dev, nil
+}
+
+func (dev *downsampler) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{dev.iname: dev.Input}
+}
+
+func (dev *downsampler) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{dev.oname: dev.Output}
+}
+
+type histogrammer struct {
+	xdaq.Histogrammer
+	iname string
+	oname string
+}
+
+// newHistogrammer creates a histogrammer of the payloads described by the
+// schema parameter, or by the schema registered for the type parameter.
+// Histograms are defined by the hists parameter
+// (e.g. hists=energy:100:0:50;x:10:0:1:y:10:0:1).
+func newHistogrammer(params map[string]string) (tdaq.Device, error) {
+	period, err := time.ParseDuration(param(params, "period", "1s"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse period parameter: %w", err)
+	}
+
+	dev := &histogrammer{
+		iname: param(params, "i", "/input"),
+		oname: param(params, "o", "/histos"),
+	}
+	dev.Period = period
+
+	switch typ := param(params, "type", ""); typ {
+	case "":
+		sch, err := payload.ParseSchema(param(params, "schema", ""))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse schema parameter: %w", err)
+		}
+		dev.Schema = sch
+	default:
+		sch, ok := payload.Lookup(typ)
+		if !ok {
+			return nil, fmt.Errorf("no schema registered for type %q", typ)
+		}
+		dev.Schema = sch
+	}
+
+	if v := param(params, "hists", ""); v != "" {
+		dev.Hists, err = xdaq.ParseHistDefs(v)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse hists parameter: %w", err)
+		}
+	}
+	return dev, nil
+}
+
+func (dev *histogrammer) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{dev.iname: dev.Input}
+}
+
+func (dev *histogrammer) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{dev.oname: dev.Output}
+}
+
+func (dev *histogrammer) Run(ctx tdaq.Context) error { return dev.Loop(ctx) }
+
+type dump struct {
+	xdaq.Dumper
+	iname string
+}
+
+// newDump creates a dumper of the payloads described by the schema
+// parameter, or by the schema registered for the type parameter.
+// Payloads are dumped raw without a schema.
+func newDump(params map[string]string) (tdaq.Device, error) {
+	rate, err := strconv.ParseFloat(param(params, "rate", "0"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse rate parameter: %w", err)
+	}
+
+	dev := &dump{
+		iname: param(params, "i", "/input"),
+	}
+	dev.Format = param(params, "format", xdaq.DumpText)
+	dev.Rate = rate
+
+	switch typ, spec := param(params, "type", ""), param(params, "schema", ""); {
+	case spec != "":
+		dev.Schema, err = payload.ParseSchema(spec)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse schema parameter: %w", err)
+		}
+	case typ != "":
+		sch, ok := payload.Lookup(typ)
+		if !ok {
+			return nil, fmt.Errorf("no schema registered for type %q", typ)
+		}
+		dev.Schema = sch
+	}
+	return dev, nil
+}
+
+func (dev *dump) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{dev.iname: dev.Input}
+}
+
+type npySink struct {
+	xdaq.NpyWriter
+	iname string
+}
+
+// newNpySink creates a NumPy writer of the payloads described by the schema
+// parameter, or by the schema registered for the type parameter.
+// Files are written per run in the dir parameter, with the format parameter
+// (npy or npz).
+func newNpySink(params map[string]string) (tdaq.Device, error) {
+	dev := &npySink{
+		iname: param(params, "i", "/input"),
+	}
+	dev.Format = param(params, "format", xdaq.NpzFormat)
+	dev.Dir = param(params, "dir", "")
+	dev.Prefix = param(params, "prefix", "run")
+
+	switch typ, spec := param(params, "type", ""), param(params, "schema", ""); {
+	case spec != "":
+		sch, err := payload.ParseSchema(spec)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse schema parameter: %w", err)
+		}
+		dev.Schema = sch
+	case typ != "":
+		sch, ok := payload.Lookup(typ)
+		if !ok {
+			return nil, fmt.Errorf("no schema registered for type %q", typ)
+		}
+		dev.Schema = sch
+	default:
+		return nil, fmt.Errorf("missing schema or type parameter")
+	}
+	return dev, nil
+}
+
+func (dev *npySink) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{dev.iname: dev.Input}
+}
+
+type textSink struct {
+	xdaq.TextSink
+	iname string
+}
+
+// newTextSink creates a CSV or JSON-lines writer of the payloads described
+// by the schema parameter, or by the schema registered for the type
+// parameter.
+// Files are written in the dir parameter, and rotated with the size
+// (in bytes) and age parameters.
+func newTextSink(params map[string]string) (tdaq.Device, error) {
+	size, err := strconv.ParseInt(param(params, "size", "0"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse size parameter: %w", err)
+	}
+	age, err := time.ParseDuration(param(params, "age", "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse age parameter: %w", err)
+	}
+
+	dev := &textSink{
+		iname: param(params, "i", "/input"),
+	}
+	dev.Format = param(params, "format", xdaq.DumpCSV)
+	dev.Dir = param(params, "dir", "")
+	dev.Prefix = param(params, "prefix", "sink")
+	dev.MaxSize = size
+	dev.MaxAge = age
+
+	switch typ, spec := param(params, "type", ""), param(params, "schema", ""); {
+	case spec != "":
+		dev.Schema, err = payload.ParseSchema(spec)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse schema parameter: %w", err)
+		}
+	case typ != "":
+		sch, ok := payload.Lookup(typ)
+		if !ok {
+			return nil, fmt.Errorf("no schema registered for type %q", typ)
+		}
+		dev.Schema = sch
+	}
+	return dev, nil
+}
+
+func (dev *textSink) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{dev.iname: dev.Input}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command tdaq-device is a generic TDAQ process, running a device whose
+// implementation is selected at runtime.
+//
+// The device is created by the factory registered for its type, either by
+// tdaq-device itself (for the xdaq devices) or by a Go plugin.
+// The type of device, its plugin and its parameters are read from
+// the topology file, for the process named with -id, or from the command line.
+//
+// Plugins are Go packages built with -buildmode=plugin. They either register
+// their device factories with tdaq.RegisterDevice from an init function, or
+// export a factory under the name NewDevice:
+//
+//	func NewDevice(params map[string]string) (tdaq.Device, error)
+//
+// Usage: tdaq-device [options]
+//
+// ex:
+//
+//	$> tdaq-device -id gen -device i64-gen -params o=/adc,freq=10ms
+//	$> tdaq-device -id sel -device filter -params 'schema=evt:u64;energy:f64,expr=energy > 10'
+//	$> tdaq-device -id src -device file-src -params o=/raw,path=./run-42,repeat=true,rate=100
+//	$> tdaq-device -id sink -topo ./topo.json
+//	$> tdaq-device -id sink -device datasink -plugin ./datasink.so
+package main // import "github.com/go-daq/tdaq/cmd/tdaq-device"
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/flags"
+	"github.com/go-daq/tdaq/log"
+)
+
+func main() {
+	var (
+		typ    = flag.String("device", "", "type of the device to run (overrides the topology)")
+		plugin = flag.String("plugin", "", "path to a Go plugin providing the device (overrides the topology)")
+		params = flag.String("params", "", "comma-separated list of key=value device parameters (overrides the topology)")
+	)
+
+	cmd := flags.New(flags.WithAllFlags())
+
+	spec, err := deviceSpec(cmd.Device, *typ, *plugin, *params)
+	if err != nil {
+		log.Fatalf("could not configure device: %+v", err)
+	}
+
+	if spec.Plugin != "" {
+		err = loadPlugin(spec.Plugin, spec.Type)
+		if err != nil {
+			log.Fatalf("could not load device plugin: %+v", err)
+		}
+	}
+
+	dev, err := tdaq.NewDevice(spec.Type, spec.Params)
+	if err != nil {
+		log.Fatalf("could not create device: %+v", err)
+	}
+
+	err = tdaq.Serve(dev, tdaq.WithConfig(cmd))
+	if err != nil {
+		log.Panicf("error: %+v", err)
+	}
+}
+
+// deviceSpec returns the description of the device to run, from the
+// topology and the command line.
+func deviceSpec(topo *config.Device, typ, plugin, params string) (config.Device, error) {
+	var spec config.Device
+	if topo != nil {
+		spec = *topo
+	}
+	if typ != "" {
+		spec.Type = typ
+	}
+	if plugin != "" {
+		spec.Plugin = plugin
+	}
+	if params != "" {
+		if spec.Params == nil {
+			spec.Params = make(map[string]string)
+		}
+		for _, kv := range strings.Split(params, ",") {
+			i := strings.Index(kv, "=")
+			if i < 0 {
+				return spec, fmt.Errorf("invalid device parameter %q (want key=value)", kv)
+			}
+			spec.Params[kv[:i]] = kv[i+1:]
+		}
+	}
+	if spec.Type == "" {
+		return spec, fmt.Errorf("no device type (registered: %v)", tdaq.DeviceTypes())
+	}
+	return spec, nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build (linux && cgo) || (darwin && cgo)
+// +build linux,cgo darwin,cgo
+
+package main
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/go-daq/tdaq"
+)
+
+// loadPlugin loads the named Go plugin.
+// If the plugin exports a NewDevice factory, it is registered for
+// the provided type of device.
+func loadPlugin(fname, typ string) error {
+	p, err := plugin.Open(fname)
+	if err != nil {
+		return fmt.Errorf("could not open plugin %q: %w", fname, err)
+	}
+
+	sym, err := p.Lookup("NewDevice")
+	if err != nil {
+		// the plugin registered its factories from its init functions.
+		return nil
+	}
+
+	switch f := sym.(type) {
+	case func(map[string]string) (tdaq.Device, error):
+		tdaq.RegisterDevice(typ, f)
+	case *tdaq.DeviceFactory:
+		tdaq.RegisterDevice(typ, *f)
+	default:
+		return fmt.Errorf("invalid NewDevice symbol type %T in plugin %q", sym, fname)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build (!linux && !darwin) || !cgo
+// +build !linux,!darwin !cgo
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func loadPlugin(fname, typ string) error {
+	return fmt.Errorf("Go plugins not supported on %s/%s (cgo required)", runtime.GOOS, runtime.GOARCH)
+}
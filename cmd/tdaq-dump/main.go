@@ -0,0 +1,89 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command tdaq-dump consumes data frames from an input end-point and dumps
+// their decoded contents on screen, as text, JSON lines or CSV.
+//
+// Payloads are decoded with the schema given on the command line, or with
+// the schema registered for the type of the input end-point (as given on
+// the command line or declared in the topology). Payloads are dumped raw,
+// in hexadecimal, otherwise.
+//
+// Usage: tdaq-dump [options]
+//
+// ex:
+//
+//	$> tdaq-dump -i /adc -type i64
+//	$> tdaq-dump -i /hits -schema evt:u64,ch:u8,energy:f64 -format csv > hits.csv
+//	$> tdaq-dump -i /hits -topo ./topo.json -id dump -format jsonl -rate 2
+package main // import "github.com/go-daq/tdaq/cmd/tdaq-dump"
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/flags"
+	"github.com/go-daq/tdaq/log"
+	"github.com/go-daq/tdaq/payload"
+	"github.com/go-daq/tdaq/xdaq"
+)
+
+func main() {
+	var (
+		iname  = flag.String("i", "/input", "name of the input data stream end-point")
+		typ    = flag.String("type", "", "type of the input data frames, with a registered schema")
+		schema = flag.String("schema", "", "schema of the input payloads (e.g. evt:u64,ch:u8,energy:f64)")
+		format = flag.String("format", xdaq.DumpText, "dump format (text, jsonl, csv)")
+		rate   = flag.Float64("rate", 0, "maximum rate of dumped frames, in Hz (0: unlimited)")
+	)
+
+	cmd := flags.New(flags.WithLinkFlags(), flags.WithDebugFlags())
+
+	sch, err := inputSchema(cmd, *iname, *typ, *schema)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+
+	dev := xdaq.Dumper{
+		Schema: sch,
+		Format: *format,
+		Rate:   *rate,
+	}
+
+	err = tdaq.Serve(
+		&dev,
+		tdaq.WithConfig(cmd),
+		tdaq.WithInput(*iname, dev.Input),
+	)
+	if err != nil {
+		log.Panicf("error: %+v", err)
+	}
+}
+
+// inputSchema returns the schema of the payloads of the input end-point,
+// from the command line or the topology.
+func inputSchema(cmd config.Process, iname, typ, schema string) (payload.Schema, error) {
+	if schema != "" {
+		sch, err := payload.ParseSchema(schema)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse schema: %w", err)
+		}
+		return sch, nil
+	}
+
+	if typ == "" {
+		typ = cmd.Types[iname]
+	}
+	if typ == "" {
+		return nil, nil
+	}
+
+	sch, ok := payload.Lookup(typ)
+	if !ok {
+		return nil, fmt.Errorf("no schema registered for type %q (registered: %v)", typ, payload.Types())
+	}
+	return sch, nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command tdaq-gateway republishes data streams and status of a lower TDAQ
+// partition into an upper TDAQ partition.
+//
+// Usage: tdaq-gateway [options]
+//
+// ex:
+//
+//	$> tdaq-gateway -id det1-gw -lower-rc-addr det1:44000 -upper-rc-addr global:44000 \
+//	                -streams /adc=/det1/adc,/tdc=/det1/tdc \
+//	                -status-url http://det1:8080/api/status
+package main // import "github.com/go-daq/tdaq/cmd/tdaq-gateway"
+
+import (
+	"context"
+	"os"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/flags"
+	"github.com/go-daq/tdaq/log"
+)
+
+func main() {
+	cfg := flags.NewGateway()
+
+	gw, err := tdaq.NewGateway(cfg, os.Stdout)
+	if err != nil {
+		log.Fatalf("could not create gateway: %+v", err)
+	}
+
+	err = gw.Run(context.Background())
+	if err != nil {
+		log.Panicf("error: %+v", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:generate tdaq-gen -t Header
+
+// Package gentest holds types covering all the field kinds supported by
+// tdaq-gen, together with their generated MarshalTDAQ and UnmarshalTDAQ
+// methods.
+//
+// ztdaq.go is the golden output of tdaq-gen for this package.
+package gentest // import "github.com/go-daq/tdaq/cmd/tdaq-gen/internal/gentest"
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Mode is a named basic type.
+type Mode uint8
+
+// Count is a named basic type encoded with a conversion.
+type Count int
+
+// Header is a nested struct, selected with the -t flag.
+type Header struct {
+	ID   uint32
+	Name string
+}
+
+// Point implements tdaq.Marshaler and tdaq.Unmarshaler by hand.
+type Point struct {
+	X, Y float32
+}
+
+func (p Point) MarshalTDAQ() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := tdaq.NewEncoder(buf)
+	enc.WriteF32(p.X)
+	enc.WriteF32(p.Y)
+	return buf.Bytes(), enc.Err()
+}
+
+func (p *Point) UnmarshalTDAQ(b []byte) error {
+	dec := tdaq.NewDecoder(bytes.NewReader(b))
+	p.X = dec.ReadF32()
+	p.Y = dec.ReadF32()
+	return dec.Err()
+}
+
+// Event covers all the field kinds supported by tdaq-gen.
+//
+// tdaq:gen
+type Event struct {
+	B   bool
+	I8  int8
+	I16 int16
+	I32 int32
+	I64 int64
+	U8  uint8
+	U16 uint16
+	U32 uint32
+	U64 uint64
+	I   int
+	U   uint
+	F32 float32
+	F64 float64
+	Str string
+
+	Time  time.Time
+	Raw   []byte
+	Strs  []string
+	Attrs map[string]string
+
+	Mode  Mode
+	Count Count
+
+	I16s   []int16
+	Modes  []Mode
+	Counts []Count
+	Times  []time.Time
+	Blobs  [][]byte
+	Matrix [][]float64
+
+	Header  Header
+	Headers []Header
+	Point   Point
+	Points  []Point
+
+	Cache []byte `tdaq:"-"`
+	_     int
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gentest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name string
+		want Event
+	}{
+		{
+			name: "zero",
+		},
+		{
+			name: "full",
+			want: Event{
+				B:   true,
+				I8:  -8,
+				I16: -16,
+				I32: -32,
+				I64: -64,
+				U8:  8,
+				U16: 16,
+				U32: 32,
+				U64: 64,
+				I:   -1,
+				U:   1,
+				F32: 3.5,
+				F64: -2.25,
+				Str: "event",
+
+				Time:  now,
+				Raw:   []byte{0, 1, 2, 0xff},
+				Strs:  []string{"a", "", "c"},
+				Attrs: map[string]string{"run": "42", "mode": "calib"},
+
+				Mode:  Mode(3),
+				Count: Count(-42),
+
+				I16s:   []int16{-1, 0, 1},
+				Modes:  []Mode{1, 2},
+				Counts: []Count{-1, 1 << 40},
+				Times:  []time.Time{now, now.Add(time.Second)},
+				Blobs:  [][]byte{{1}, {2, 3}},
+				Matrix: [][]float64{{1, 2}, {3}},
+
+				Header:  Header{ID: 1, Name: "hdr"},
+				Headers: []Header{{ID: 2, Name: "h2"}, {ID: 3}},
+				Point:   Point{X: 1, Y: -1},
+				Points:  []Point{{X: 2, Y: 3}, {X: 4, Y: 5}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			want := tc.want
+			want.Cache = []byte("ignored")
+
+			raw, err := want.MarshalTDAQ()
+			if err != nil {
+				t.Fatalf("could not marshal event: %+v", err)
+			}
+
+			var got Event
+			err = got.UnmarshalTDAQ(raw)
+			if err != nil {
+				t.Fatalf("could not unmarshal event: %+v", err)
+			}
+
+			want.Cache = nil
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid round-trip:\ngot = %+v\nwant= %+v", got, want)
+			}
+
+			if len(raw) > 0 {
+				err = new(Event).UnmarshalTDAQ(raw[:len(raw)-1])
+				if err == nil {
+					t.Fatalf("expected an error unmarshaling a truncated event")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+// Code generated by tdaq-gen; DO NOT EDIT.
+
+package gentest
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+func (v Event) MarshalTDAQ() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := tdaq.NewEncoder(buf)
+	enc.WriteBool(v.B)
+	enc.WriteI8(v.I8)
+	enc.WriteI16(v.I16)
+	enc.WriteI32(v.I32)
+	enc.WriteI64(v.I64)
+	enc.WriteU8(v.U8)
+	enc.WriteU16(v.U16)
+	enc.WriteU32(v.U32)
+	enc.WriteU64(v.U64)
+	enc.WriteI64(int64(v.I))
+	enc.WriteU64(uint64(v.U))
+	enc.WriteF32(v.F32)
+	enc.WriteF64(v.F64)
+	enc.WriteStr(v.Str)
+	enc.WriteTime(v.Time)
+	enc.WriteBytes(v.Raw)
+	enc.WriteStrs(v.Strs)
+	enc.WriteStrMap(v.Attrs)
+	enc.WriteU8(uint8(v.Mode))
+	enc.WriteI64(int64(v.Count))
+	enc.WriteI32(int32(len(v.I16s)))
+	for _, v1 := range v.I16s {
+		enc.WriteI16(v1)
+	}
+	enc.WriteI32(int32(len(v.Modes)))
+	for _, v1 := range v.Modes {
+		enc.WriteU8(uint8(v1))
+	}
+	enc.WriteI32(int32(len(v.Counts)))
+	for _, v1 := range v.Counts {
+		enc.WriteI64(int64(v1))
+	}
+	enc.WriteI32(int32(len(v.Times)))
+	for _, v1 := range v.Times {
+		enc.WriteTime(v1)
+	}
+	enc.WriteI32(int32(len(v.Blobs)))
+	for _, v1 := range v.Blobs {
+		enc.WriteBytes(v1)
+	}
+	enc.WriteI32(int32(len(v.Matrix)))
+	for _, v1 := range v.Matrix {
+		enc.WriteI32(int32(len(v1)))
+		for _, v2 := range v1 {
+			enc.WriteF64(v2)
+		}
+	}
+	_ = enc.Encode(&v.Header)
+	enc.WriteI32(int32(len(v.Headers)))
+	for _, v1 := range v.Headers {
+		_ = enc.Encode(&v1)
+	}
+	_ = enc.Encode(&v.Point)
+	enc.WriteI32(int32(len(v.Points)))
+	for _, v1 := range v.Points {
+		_ = enc.Encode(&v1)
+	}
+	return buf.Bytes(), enc.Err()
+}
+
+func (v *Event) UnmarshalTDAQ(p []byte) error {
+	dec := tdaq.NewDecoder(bytes.NewReader(p))
+	v.B = dec.ReadBool()
+	v.I8 = dec.ReadI8()
+	v.I16 = dec.ReadI16()
+	v.I32 = dec.ReadI32()
+	v.I64 = dec.ReadI64()
+	v.U8 = dec.ReadU8()
+	v.U16 = dec.ReadU16()
+	v.U32 = dec.ReadU32()
+	v.U64 = dec.ReadU64()
+	v.I = int(dec.ReadI64())
+	v.U = uint(dec.ReadU64())
+	v.F32 = dec.ReadF32()
+	v.F64 = dec.ReadF64()
+	v.Str = dec.ReadStr()
+	v.Time = dec.ReadTime()
+	v.Raw = dec.ReadBytes()
+	v.Strs = dec.ReadStrs()
+	v.Attrs = dec.ReadStrMap()
+	v.Mode = Mode(dec.ReadU8())
+	v.Count = Count(dec.ReadI64())
+	if n1 := int(dec.ReadI32()); n1 > 0 && dec.Err() == nil {
+		v.I16s = make([]int16, n1)
+		for i1 := range v.I16s {
+			v.I16s[i1] = dec.ReadI16()
+		}
+	}
+	if n1 := int(dec.ReadI32()); n1 > 0 && dec.Err() == nil {
+		v.Modes = make([]Mode, n1)
+		for i1 := range v.Modes {
+			v.Modes[i1] = Mode(dec.ReadU8())
+		}
+	}
+	if n1 := int(dec.ReadI32()); n1 > 0 && dec.Err() == nil {
+		v.Counts = make([]Count, n1)
+		for i1 := range v.Counts {
+			v.Counts[i1] = Count(dec.ReadI64())
+		}
+	}
+	if n1 := int(dec.ReadI32()); n1 > 0 && dec.Err() == nil {
+		v.Times = make([]time.Time, n1)
+		for i1 := range v.Times {
+			v.Times[i1] = dec.ReadTime()
+		}
+	}
+	if n1 := int(dec.ReadI32()); n1 > 0 && dec.Err() == nil {
+		v.Blobs = make([][]byte, n1)
+		for i1 := range v.Blobs {
+			v.Blobs[i1] = dec.ReadBytes()
+		}
+	}
+	if n1 := int(dec.ReadI32()); n1 > 0 && dec.Err() == nil {
+		v.Matrix = make([][]float64, n1)
+		for i1 := range v.Matrix {
+			if n3 := int(dec.ReadI32()); n3 > 0 && dec.Err() == nil {
+				v.Matrix[i1] = make([]float64, n3)
+				for i3 := range v.Matrix[i1] {
+					v.Matrix[i1][i3] = dec.ReadF64()
+				}
+			}
+		}
+	}
+	_ = dec.Decode(&v.Header)
+	if n1 := int(dec.ReadI32()); n1 > 0 && dec.Err() == nil {
+		v.Headers = make([]Header, n1)
+		for i1 := range v.Headers {
+			_ = dec.Decode(&v.Headers[i1])
+		}
+	}
+	_ = dec.Decode(&v.Point)
+	if n1 := int(dec.ReadI32()); n1 > 0 && dec.Err() == nil {
+		v.Points = make([]Point, n1)
+		for i1 := range v.Points {
+			_ = dec.Decode(&v.Points[i1])
+		}
+	}
+	return dec.Err()
+}
+
+func (v Header) MarshalTDAQ() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := tdaq.NewEncoder(buf)
+	enc.WriteU32(v.ID)
+	enc.WriteStr(v.Name)
+	return buf.Bytes(), enc.Err()
+}
+
+func (v *Header) UnmarshalTDAQ(p []byte) error {
+	dec := tdaq.NewDecoder(bytes.NewReader(p))
+	v.ID = dec.ReadU32()
+	v.Name = dec.ReadStr()
+	return dec.Err()
+}
+
+var (
+	_ tdaq.Marshaler   = (*Event)(nil)
+	_ tdaq.Unmarshaler = (*Event)(nil)
+	_ tdaq.Marshaler   = (*Header)(nil)
+	_ tdaq.Unmarshaler = (*Header)(nil)
+)
@@ -0,0 +1,538 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command tdaq-gen generates MarshalTDAQ and UnmarshalTDAQ methods for structs.
+//
+// Usage: tdaq-gen [options] [dir]
+//
+// tdaq-gen scans the Go files of the package in dir (default: the current
+// directory) for struct types annotated with a "tdaq:gen" comment line, or
+// named with the -t flag, and generates MarshalTDAQ and UnmarshalTDAQ methods
+// for them.
+//
+// Fields are encoded in declaration order with the tdaq.Encoder primitives.
+// Supported field types are:
+//   - bool, (u)int8, (u)int16, (u)int32, (u)int64, int, uint, float32, float64, string,
+//   - time.Time, []byte, []string, map[string]string,
+//   - named types with one of the above underlying types,
+//   - slices of any of the above types,
+//   - types implementing tdaq.Marshaler and tdaq.Unmarshaler.
+//
+// Fields tagged with `tdaq:"-"` are ignored.
+//
+// tdaq-gen is meant to be used with go:generate:
+//
+//	//go:generate tdaq-gen -t MyCmd,MyData
+//
+//	// MyEvent is an event.
+//	// tdaq:gen
+//	type MyEvent struct { ... }
+package main // import "github.com/go-daq/tdaq/cmd/tdaq-gen"
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func main() {
+	var (
+		types = flag.String("t", "", "comma-separated list of types to generate (in addition to annotated types)")
+		oname = flag.String("o", "ztdaq.go", "name of the output file")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Usage: tdaq-gen [options] [dir]
+
+ex:
+ $> tdaq-gen -t MyCmd,MyData
+ $> tdaq-gen -o zcodec.go ./pkg
+
+options:
+`)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	var names []string
+	if *types != "" {
+		names = strings.Split(*types, ",")
+	}
+
+	out := *oname
+	if !filepath.IsAbs(out) {
+		out = filepath.Join(dir, out)
+	}
+
+	err := process(dir, out, names)
+	if err != nil {
+		log.Fatalf("could not generate code: %+v", err)
+	}
+}
+
+func process(dir, oname string, types []string) error {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		name := fi.Name()
+		return !strings.HasSuffix(name, "_test.go") && name != filepath.Base(oname)
+	}, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("could not parse package: %w", err)
+	}
+
+	if len(pkgs) != 1 {
+		return fmt.Errorf("could not find a unique package in %q (got %d)", dir, len(pkgs))
+	}
+
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+
+	gen := newGenerator(pkg)
+	for _, name := range types {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := gen.types[name]; !ok {
+			return fmt.Errorf("could not find type %q", name)
+		}
+		gen.want[name] = true
+	}
+
+	src, err := gen.generate()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(oname, src, 0644)
+}
+
+type generator struct {
+	pkg   string
+	types map[string]*ast.TypeSpec // all the types declared in the package
+	want  map[string]bool          // types to generate
+	tdaq  string                   // qualifier of the tdaq package
+	imps  map[string]bool          // imports needed by the generated code
+	pkgs  map[string]string        // import paths of the packages, by name
+
+	buf *bytes.Buffer
+}
+
+func newGenerator(pkg *ast.Package) *generator {
+	gen := &generator{
+		pkg:   pkg.Name,
+		types: make(map[string]*ast.TypeSpec),
+		want:  make(map[string]bool),
+		tdaq:  "tdaq.",
+		imps:  map[string]bool{"bytes": true},
+		pkgs:  make(map[string]string),
+		buf:   new(bytes.Buffer),
+	}
+	if pkg.Name == "tdaq" {
+		gen.tdaq = ""
+	} else {
+		gen.imps["github.com/go-daq/tdaq"] = true
+	}
+
+	for _, f := range pkg.Files {
+		for _, imp := range f.Imports {
+			path, err := strconv.Unquote(imp.Path.Value)
+			if err != nil {
+				continue
+			}
+			name := path[strings.LastIndex(path, "/")+1:]
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			gen.pkgs[name] = path
+		}
+		for _, decl := range f.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				gen.types[spec.Name.Name] = spec
+				if _, ok := spec.Type.(*ast.StructType); !ok {
+					continue
+				}
+				doc := spec.Doc
+				if doc == nil && len(decl.Specs) == 1 {
+					doc = decl.Doc
+				}
+				if hasAnnotation(doc) {
+					gen.want[spec.Name.Name] = true
+				}
+			}
+		}
+	}
+
+	return gen
+}
+
+func hasAnnotation(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		txt := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(c.Text, "//"), "/*"))
+		if txt == "tdaq:gen" {
+			return true
+		}
+	}
+	return false
+}
+
+func (gen *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(gen.buf, format, args...)
+}
+
+func (gen *generator) generate() ([]byte, error) {
+	if len(gen.want) == 0 {
+		return nil, fmt.Errorf("no type to generate")
+	}
+
+	names := make([]string, 0, len(gen.want))
+	for name := range gen.want {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	body := new(bytes.Buffer)
+	gen.buf = body
+	for _, name := range names {
+		err := gen.genType(gen.types[name])
+		if err != nil {
+			return nil, fmt.Errorf("could not generate code for type %q: %w", name, err)
+		}
+	}
+
+	gen.buf = new(bytes.Buffer)
+	gen.printf("// Code generated by tdaq-gen; DO NOT EDIT.\n\n")
+	gen.printf("package %s\n\nimport (\n", gen.pkg)
+	imps := make([]string, 0, len(gen.imps))
+	for imp := range gen.imps {
+		imps = append(imps, imp)
+	}
+	sort.Slice(imps, func(i, j int) bool {
+		// standard library packages come first.
+		si, sj := isStd(imps[i]), isStd(imps[j])
+		if si != sj {
+			return si
+		}
+		return imps[i] < imps[j]
+	})
+	for i, imp := range imps {
+		if i > 0 && !isStd(imp) && isStd(imps[i-1]) {
+			gen.printf("\n")
+		}
+		gen.printf("\t%q\n", imp)
+	}
+	gen.printf(")\n")
+	gen.buf.Write(body.Bytes())
+
+	gen.printf("\nvar (\n")
+	for _, name := range names {
+		gen.printf("\t_ %sMarshaler = (*%s)(nil)\n", gen.tdaq, name)
+		gen.printf("\t_ %sUnmarshaler = (*%s)(nil)\n", gen.tdaq, name)
+	}
+	gen.printf(")\n")
+
+	src, err := format.Source(gen.buf.Bytes())
+	if err != nil {
+		return gen.buf.Bytes(), fmt.Errorf("could not format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// isStd returns whether the import path is a standard library package.
+func isStd(path string) bool {
+	return !strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+}
+
+type field struct {
+	name string
+	typ  ast.Expr
+}
+
+func (gen *generator) fields(spec *ast.TypeSpec) ([]field, error) {
+	st := spec.Type.(*ast.StructType)
+	var fields []field
+	for _, f := range st.Fields.List {
+		if f.Tag != nil {
+			tag, err := strconv.Unquote(f.Tag.Value)
+			if err == nil && reflect.StructTag(tag).Get("tdaq") == "-" {
+				continue
+			}
+		}
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("embedded fields are not supported")
+		}
+		for _, n := range f.Names {
+			if n.Name == "_" {
+				continue
+			}
+			fields = append(fields, field{name: n.Name, typ: f.Type})
+		}
+	}
+	return fields, nil
+}
+
+func (gen *generator) genType(spec *ast.TypeSpec) error {
+	if _, ok := spec.Type.(*ast.StructType); !ok {
+		return fmt.Errorf("not a struct type")
+	}
+
+	fields, err := gen.fields(spec)
+	if err != nil {
+		return err
+	}
+
+	name := spec.Name.Name
+	gen.printf("\nfunc (v %s) MarshalTDAQ() ([]byte, error) {\n", name)
+	gen.printf("\tbuf := new(bytes.Buffer)\n")
+	gen.printf("\tenc := %sNewEncoder(buf)\n", gen.tdaq)
+	for _, f := range fields {
+		err := gen.genEncode("v."+f.name, f.typ, 1)
+		if err != nil {
+			return fmt.Errorf("field %q: %w", f.name, err)
+		}
+	}
+	gen.printf("\treturn buf.Bytes(), enc.Err()\n}\n")
+
+	gen.printf("\nfunc (v *%s) UnmarshalTDAQ(p []byte) error {\n", name)
+	gen.printf("\tdec := %sNewDecoder(bytes.NewReader(p))\n", gen.tdaq)
+	for _, f := range fields {
+		err := gen.genDecode("v."+f.name, f.typ, 1)
+		if err != nil {
+			return fmt.Errorf("field %q: %w", f.name, err)
+		}
+	}
+	gen.printf("\treturn dec.Err()\n}\n")
+
+	return nil
+}
+
+var basics = map[string]string{
+	"bool":    "Bool",
+	"int8":    "I8",
+	"int16":   "I16",
+	"int32":   "I32",
+	"int64":   "I64",
+	"uint8":   "U8",
+	"byte":    "U8",
+	"uint16":  "U16",
+	"uint32":  "U32",
+	"uint64":  "U64",
+	"float32": "F32",
+	"float64": "F64",
+	"string":  "Str",
+}
+
+// basic returns the name of the encoding primitive and the Go type
+// the value must be converted to for the provided basic type.
+func basic(name string) (prim, conv string, ok bool) {
+	switch name {
+	case "int":
+		return "I64", "int64", true
+	case "uint":
+		return "U64", "uint64", true
+	}
+	prim, ok = basics[name]
+	return prim, name, ok
+}
+
+// typeName returns the name of the type x as used in the generated code,
+// recording the imports of the packages it refers to.
+func (gen *generator) typeName(x ast.Expr) string {
+	ast.Inspect(x, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if id, ok := sel.X.(*ast.Ident); ok {
+			if path, ok := gen.pkgs[id.Name]; ok {
+				gen.imps[path] = true
+			}
+		}
+		return false
+	})
+	return exprString(x)
+}
+
+func exprString(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.SelectorExpr:
+		return exprString(x.X) + "." + x.Sel.Name
+	case *ast.StarExpr:
+		return "*" + exprString(x.X)
+	case *ast.ArrayType:
+		if x.Len != nil {
+			return "[...]" + exprString(x.Elt)
+		}
+		return "[]" + exprString(x.Elt)
+	case *ast.MapType:
+		return "map[" + exprString(x.Key) + "]" + exprString(x.Value)
+	default:
+		return fmt.Sprintf("%T", x)
+	}
+}
+
+func (gen *generator) genEncode(v string, typ ast.Expr, lvl int) error {
+	indent := strings.Repeat("\t", lvl)
+	switch t := typ.(type) {
+	case *ast.Ident:
+		if prim, conv, ok := basic(t.Name); ok {
+			if conv != t.Name {
+				v = conv + "(" + v + ")"
+			}
+			gen.printf("%senc.Write%s(%s)\n", indent, prim, v)
+			return nil
+		}
+		spec, ok := gen.types[t.Name]
+		if !ok {
+			return fmt.Errorf("unsupported type %q", t.Name)
+		}
+		if u, ok := spec.Type.(*ast.Ident); ok {
+			if prim, conv, ok := basic(u.Name); ok {
+				gen.printf("%senc.Write%s(%s(%s))\n", indent, prim, conv, v)
+				return nil
+			}
+		}
+		gen.printf("%s_ = enc.Encode(&%s)\n", indent, v)
+		return nil
+
+	case *ast.SelectorExpr:
+		switch exprString(t) {
+		case "time.Time":
+			gen.printf("%senc.WriteTime(%s)\n", indent, v)
+		default:
+			gen.printf("%s_ = enc.Encode(&%s)\n", indent, v)
+		}
+		return nil
+
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return fmt.Errorf("arrays are not supported")
+		}
+		switch exprString(t) {
+		case "[]byte", "[]uint8":
+			gen.printf("%senc.WriteBytes(%s)\n", indent, v)
+			return nil
+		case "[]string":
+			gen.printf("%senc.WriteStrs(%s)\n", indent, v)
+			return nil
+		}
+		elt := fmt.Sprintf("v%d", lvl)
+		gen.printf("%senc.WriteI32(int32(len(%s)))\n", indent, v)
+		gen.printf("%sfor _, %s := range %s {\n", indent, elt, v)
+		err := gen.genEncode(elt, t.Elt, lvl+1)
+		if err != nil {
+			return err
+		}
+		gen.printf("%s}\n", indent)
+		return nil
+
+	case *ast.MapType:
+		if exprString(t) == "map[string]string" {
+			gen.printf("%senc.WriteStrMap(%s)\n", indent, v)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported type %q", exprString(typ))
+}
+
+func (gen *generator) genDecode(v string, typ ast.Expr, lvl int) error {
+	indent := strings.Repeat("\t", lvl)
+	switch t := typ.(type) {
+	case *ast.Ident:
+		if prim, conv, ok := basic(t.Name); ok {
+			if conv != t.Name {
+				gen.printf("%s%s = %s(dec.Read%s())\n", indent, v, t.Name, prim)
+				return nil
+			}
+			gen.printf("%s%s = dec.Read%s()\n", indent, v, prim)
+			return nil
+		}
+		spec, ok := gen.types[t.Name]
+		if !ok {
+			return fmt.Errorf("unsupported type %q", t.Name)
+		}
+		if u, ok := spec.Type.(*ast.Ident); ok {
+			if prim, _, ok := basic(u.Name); ok {
+				gen.printf("%s%s = %s(dec.Read%s())\n", indent, v, t.Name, prim)
+				return nil
+			}
+		}
+		gen.printf("%s_ = dec.Decode(&%s)\n", indent, v)
+		return nil
+
+	case *ast.SelectorExpr:
+		switch exprString(t) {
+		case "time.Time":
+			gen.printf("%s%s = dec.ReadTime()\n", indent, v)
+		default:
+			gen.printf("%s_ = dec.Decode(&%s)\n", indent, v)
+		}
+		return nil
+
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return fmt.Errorf("arrays are not supported")
+		}
+		switch exprString(t) {
+		case "[]byte", "[]uint8":
+			gen.printf("%s%s = dec.ReadBytes()\n", indent, v)
+			return nil
+		case "[]string":
+			gen.printf("%s%s = dec.ReadStrs()\n", indent, v)
+			return nil
+		}
+		n := fmt.Sprintf("n%d", lvl)
+		i := fmt.Sprintf("i%d", lvl)
+		gen.printf("%sif %s := int(dec.ReadI32()); %s > 0 && dec.Err() == nil {\n", indent, n, n)
+		gen.printf("%s\t%s = make(%s, %s)\n", indent, v, gen.typeName(t), n)
+		gen.printf("%s\tfor %s := range %s {\n", indent, i, v)
+		err := gen.genDecode(v+"["+i+"]", t.Elt, lvl+2)
+		if err != nil {
+			return err
+		}
+		gen.printf("%s\t}\n", indent)
+		gen.printf("%s}\n", indent)
+		return nil
+
+	case *ast.MapType:
+		if exprString(t) == "map[string]string" {
+			gen.printf("%s%s = dec.ReadStrMap()\n", indent, v)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported type %q", exprString(typ))
+}
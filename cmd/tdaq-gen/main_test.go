@@ -0,0 +1,119 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	for _, tc := range []struct {
+		dir   string
+		types []string
+	}{
+		{
+			dir:   "./internal/gentest",
+			types: []string{"Header"},
+		},
+		{
+			dir: "../../internal/waveform",
+		},
+	} {
+		t.Run(tc.dir, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "tdaq-gen-")
+			if err != nil {
+				t.Fatalf("could not create tmp dir: %+v", err)
+			}
+			defer os.RemoveAll(tmp)
+
+			oname := filepath.Join(tmp, "ztdaq.go")
+			err = process(tc.dir, oname, tc.types)
+			if err != nil {
+				t.Fatalf("could not generate code: %+v", err)
+			}
+
+			got, err := ioutil.ReadFile(oname)
+			if err != nil {
+				t.Fatalf("could not read generated code: %+v", err)
+			}
+
+			want, err := ioutil.ReadFile(filepath.Join(tc.dir, "ztdaq.go"))
+			if err != nil {
+				t.Fatalf("could not read golden file: %+v", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Fatalf("generated code differs from golden file:\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		src   string
+		types []string
+		err   string
+	}{
+		{
+			name: "no-type",
+			src:  "type T struct { A int }\n",
+			err:  "no type to generate",
+		},
+		{
+			name:  "unknown-type",
+			src:   "type T struct { A int }\n",
+			types: []string{"U"},
+			err:   `could not find type "U"`,
+		},
+		{
+			name: "array",
+			src:  "// tdaq:gen\ntype T struct { A [4]int }\n",
+			err:  `field "A": arrays are not supported`,
+		},
+		{
+			name: "map",
+			src:  "// tdaq:gen\ntype T struct { A map[string]int }\n",
+			err:  `field "A": unsupported type "map[string]int"`,
+		},
+		{
+			name: "embedded",
+			src:  "type U struct{}\n\n// tdaq:gen\ntype T struct { U }\n",
+			err:  "embedded fields are not supported",
+		},
+		{
+			name: "pointer",
+			src:  "// tdaq:gen\ntype T struct { A *int }\n",
+			err:  `field "A": unsupported type "*int"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "tdaq-gen-")
+			if err != nil {
+				t.Fatalf("could not create tmp dir: %+v", err)
+			}
+			defer os.RemoveAll(tmp)
+
+			err = ioutil.WriteFile(filepath.Join(tmp, "t.go"), []byte("package p\n\n"+tc.src), 0644)
+			if err != nil {
+				t.Fatalf("could not write source file: %+v", err)
+			}
+
+			err = process(tmp, filepath.Join(tmp, "ztdaq.go"), tc.types)
+			switch {
+			case err == nil:
+				t.Fatalf("expected an error")
+			case !strings.Contains(err.Error(), tc.err):
+				t.Fatalf("invalid error:\ngot= %v\nwant=%s", err, tc.err)
+			}
+		})
+	}
+}
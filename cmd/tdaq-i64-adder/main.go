@@ -7,9 +7,7 @@
 package main
 
 import (
-	"context"
 	"flag"
-	"os"
 
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/flags"
@@ -28,19 +26,13 @@ func main() {
 	cmd := flags.New()
 
 	dev := xdaq.I64Adder{}
-	srv := tdaq.New(cmd, os.Stdout)
-	srv.CmdHandle("/config", dev.OnConfig)
-	srv.CmdHandle("/init", dev.OnInit)
-	srv.CmdHandle("/start", dev.OnStart)
-	srv.CmdHandle("/stop", dev.OnStop)
-	srv.CmdHandle("/reset", dev.OnReset)
-	srv.CmdHandle("/quit", dev.OnQuit)
-
-	srv.InputHandle(*lname, dev.Left)
-	srv.InputHandle(*rname, dev.Right)
-	srv.OutputHandle(*oname, dev.Output)
-
-	err := srv.Run(context.Background())
+	err := tdaq.Serve(
+		&dev,
+		tdaq.WithConfig(cmd),
+		tdaq.WithInput(*lname, dev.Left),
+		tdaq.WithInput(*rname, dev.Right),
+		tdaq.WithOutput(*oname, dev.Output),
+	)
 	if err != nil {
 		log.Panicf("error: %+v", err)
 	}
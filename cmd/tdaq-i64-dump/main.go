@@ -6,9 +6,7 @@
 package main
 
 import (
-	"context"
 	"flag"
-	"os"
 
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/flags"
@@ -25,17 +23,11 @@ func main() {
 	cmd := flags.New()
 
 	dev := xdaq.I64Dumper{}
-	srv := tdaq.New(cmd, os.Stdout)
-	srv.CmdHandle("/config", dev.OnConfig)
-	srv.CmdHandle("/init", dev.OnInit)
-	srv.CmdHandle("/start", dev.OnStart)
-	srv.CmdHandle("/stop", dev.OnStop)
-	srv.CmdHandle("/reset", dev.OnReset)
-	srv.CmdHandle("/quit", dev.OnQuit)
-
-	srv.InputHandle(*iname, dev.Input)
-
-	err := srv.Run(context.Background())
+	err := tdaq.Serve(
+		&dev,
+		tdaq.WithConfig(cmd),
+		tdaq.WithInput(*iname, dev.Input),
+	)
 	if err != nil {
 		log.Panicf("error: %+v", err)
 	}
@@ -6,9 +6,7 @@
 package main
 
 import (
-	"context"
 	"flag"
-	"os"
 	"time"
 
 	"github.com/go-daq/tdaq"
@@ -32,19 +30,12 @@ func main() {
 		Freq:  *freq,
 	}
 
-	srv := tdaq.New(cmd, os.Stdout)
-	srv.CmdHandle("/config", dev.OnConfig)
-	srv.CmdHandle("/init", dev.OnInit)
-	srv.CmdHandle("/start", dev.OnStart)
-	srv.CmdHandle("/stop", dev.OnStop)
-	srv.CmdHandle("/reset", dev.OnReset)
-	srv.CmdHandle("/quit", dev.OnQuit)
-
-	srv.OutputHandle(*oname, dev.Output)
-
-	srv.RunHandle(dev.Loop)
-
-	err := srv.Run(context.Background())
+	err := tdaq.Serve(
+		&dev,
+		tdaq.WithConfig(cmd),
+		tdaq.WithOutput(*oname, dev.Output),
+		tdaq.WithRun(dev.Loop),
+	)
 	if err != nil {
 		log.Panicf("error: %+v", err)
 	}
@@ -0,0 +1,43 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command tdaq-proxy relays the TDAQ processes of a network segment to
+// a run-ctl of another network segment.
+//
+// tdaq-proxy should run on a host reachable from both network segments.
+// The relayed TDAQ processes should use the address of tdaq-proxy as their
+// run-ctl address.
+//
+// Usage: tdaq-proxy [options]
+//
+// ex:
+//
+//	$> tdaq-proxy -addr tcp://:44000 -rc-addr tcp://daq.example.org:44000 \
+//	              -public-host gw.example.org -inner-host 10.0.0.1
+package main // import "github.com/go-daq/tdaq/cmd/tdaq-proxy"
+
+import (
+	"context"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/flags"
+	"github.com/go-daq/tdaq/log"
+)
+
+func main() {
+	cfg := flags.NewProxy()
+	if cfg.RunCtl == "" {
+		log.Fatalf("missing run-ctl address (-rc-addr)")
+	}
+
+	p, err := tdaq.NewProxy(cfg, nil)
+	if err != nil {
+		log.Fatalf("could not create proxy: %+v", err)
+	}
+
+	err = p.Run(context.Background())
+	if err != nil {
+		log.Fatalf("could not run proxy: %+v", err)
+	}
+}
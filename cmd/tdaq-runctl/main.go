@@ -6,31 +6,122 @@ package main // import "github.com/go-daq/tdaq/cmd/tdaq-runctl"
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/elog"
 	"github.com/go-daq/tdaq/flags"
 	"github.com/go-daq/tdaq/log"
+	"github.com/go-daq/tdaq/notify"
 	"github.com/peterh/liner"
 )
 
 func main() {
-	cmd := flags.NewRunControl()
+	cmds := flags.Commands{
+		Name: "tdaq-runctl",
+		Def:  "run",
+		Cmds: []*flags.Command{
+			{
+				Name:  "run",
+				Short: "run a run-control server",
+				Run: func(args []string) error {
+					cmd := flags.NewRunControl(flags.WithArgs(args))
+					run(cmd, os.Stdout)
+					return nil
+				},
+			},
+			{
+				Name:  "status",
+				Short: "display the status of a running run-control server",
+				Run: func(args []string) error {
+					return status(args, os.Stdout)
+				},
+			},
+			{
+				Name:  "graph",
+				Short: "export the dataflow graph of a running run-control server",
+				Run: func(args []string) error {
+					return graph(args, os.Stdout)
+				},
+			},
+			{
+				Name:  "kv",
+				Short: "read and edit the key-value configuration store of a running run-control server",
+				Run: func(args []string) error {
+					return kv(args, os.Stdout)
+				},
+			},
+			{
+				Name:  "log",
+				Short: "display the recent and live log messages of a process of a running run-control server",
+				Run: func(args []string) error {
+					return tail(args, os.Stdout)
+				},
+			},
+			{
+				Name:  "top",
+				Short: "display a live full-screen dashboard of a running run-control server",
+				Run: func(args []string) error {
+					return top(args, os.Stdout)
+				},
+			},
+		},
+	}
 
-	run(cmd, os.Stdout)
+	err := cmds.Dispatch(os.Args[1:])
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
 }
 
 func run(cfg config.RunCtl, stdout io.Writer) {
+	if cfg.Check {
+		err := check(cfg.Topology, stdout)
+		if err != nil {
+			log.Errorf("%+v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	rc, err := tdaq.NewRunControl(cfg, os.Stdout)
 	if err != nil {
 		log.Errorf("could not create run control: %+v", err)
 		os.Exit(1)
 	}
 
+	if cfg.Logbook != "" {
+		lb, err := elog.Open(cfg.Logbook)
+		if err != nil {
+			log.Errorf("could not open logbook: %+v", err)
+			os.Exit(1)
+		}
+		rc.AddLogbook(lb)
+	}
+
+	for _, addr := range cfg.Alerts {
+		n, err := notify.Open(addr)
+		if err != nil {
+			log.Errorf("could not open alert notifier: %+v", err)
+			os.Exit(1)
+		}
+		rc.AddNotifier(n)
+	}
+
 	if cfg.Interactive {
 		term := newShell(cfg, rc)
 		defer term.Close()
@@ -43,6 +134,467 @@ func run(cfg config.RunCtl, stdout io.Writer) {
 	}
 }
 
+func check(fname string, stdout io.Writer) error {
+	if fname == "" {
+		return fmt.Errorf("no topology file to validate (use -topo)")
+	}
+
+	topo, err := config.LoadTopology(fname)
+	if err != nil {
+		return err
+	}
+
+	err = tdaq.ValidateTopology(topo)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "topology %q: %d processes, ok\n", fname, len(topo.Procs))
+	return nil
+}
+
+// statusReport is the status of run-ctl, as reported by /api/status.
+type statusReport struct {
+	Status string `json:"status"`
+	Procs  []struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		RTT    string `json:"rtt,omitempty"`
+		Slow   bool   `json:"slow"`
+		Clock  *struct {
+			Offset string  `json:"offset"`
+			Delay  string  `json:"delay"`
+			Skew   float64 `json:"skew"`
+			Time   string  `json:"time"`
+		} `json:"clock,omitempty"`
+		Links []struct {
+			Addr      string   `json:"addr"`
+			EndPoints []string `json:"endpoints"`
+			Up        bool     `json:"up"`
+			Outages   uint32   `json:"outages"`
+			Since     string   `json:"since"`
+			Frames    uint64   `json:"frames"`
+			Bytes     uint64   `json:"bytes"`
+			LastFrame string   `json:"last-frame,omitempty"`
+			Queued    uint32   `json:"queued"`
+			Capacity  uint32   `json:"capacity"`
+			Occupancy []uint32 `json:"occupancy,omitempty"`
+			Stalled   bool     `json:"stalled"`
+			Slow      bool     `json:"slow"`
+		} `json:"links,omitempty"`
+		Runtime *struct {
+			Goroutines int    `json:"goroutines"`
+			HeapInuse  uint64 `json:"heap-inuse"`
+			NumGC      uint32 `json:"num-gc"`
+			PauseTotal string `json:"pause-total"`
+			Leak       bool   `json:"leak"`
+		} `json:"runtime,omitempty"`
+	} `json:"procs"`
+	Devices *struct {
+		Missing    []string `json:"missing"`
+		Unexpected []string `json:"unexpected"`
+	} `json:"devices,omitempty"`
+	Run       *tdaq.RunTotals `json:"run,omitempty"`
+	Timestamp string          `json:"timestamp"`
+}
+
+func status(args []string, stdout io.Writer) error {
+	fset := flag.NewFlagSet("status", flag.ContinueOnError)
+	var (
+		addr    = fset.String("web", ":8080", "[addr]:port of run-ctl web server")
+		raw     = fset.Bool("json", false, "display status as JSON")
+		timeout = fset.Duration("timeout", 5*time.Second, "timeout for the status request")
+	)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: tdaq-runctl status [options]\n\nex:\n $> tdaq-runctl status -web=:8080\n\noptions:\n")
+		fset.PrintDefaults()
+	}
+
+	err := flags.Parse(fset, args, flags.WithName("tdaq-runctl"))
+	if err != nil {
+		return err
+	}
+
+	cli := http.Client{Timeout: *timeout}
+	resp, err := cli.Get(webURL(*addr) + "/api/status")
+	if err != nil {
+		return fmt.Errorf("could not retrieve run-ctl status: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not retrieve run-ctl status: %s", resp.Status)
+	}
+
+	var report statusReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	if err != nil {
+		return fmt.Errorf("could not decode run-ctl status: %w", err)
+	}
+
+	if *raw {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	fmt.Fprintf(stdout, "run-ctl: %s (%s)\n", report.Status, report.Timestamp)
+	if devs := report.Devices; devs != nil && (len(devs.Missing) > 0 || len(devs.Unexpected) > 0) {
+		fmt.Fprintf(stdout, "processes: missing=%q, unexpected=%q\n", devs.Missing, devs.Unexpected)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	for _, proc := range report.Procs {
+		status := proc.Status
+		if proc.Slow {
+			status += " (slow consumer)"
+		}
+		extra := ""
+		if proc.Clock != nil {
+			extra = fmt.Sprintf("\tclock-offset=%s (skew=%.3gppm)", proc.Clock.Offset, proc.Clock.Skew)
+		}
+		if rt := proc.Runtime; rt != nil {
+			if rt.Leak {
+				status += " (leaking)"
+			}
+			extra += fmt.Sprintf("\tgoroutines=%d\theap=%d", rt.Goroutines, rt.HeapInuse)
+		}
+		fmt.Fprintf(w, "  - %s\t%s\trtt=%s%s\n", proc.Name, status, proc.RTT, extra)
+		for _, link := range proc.Links {
+			state := "up"
+			switch {
+			case !link.Up:
+				state = "down"
+			case link.Stalled:
+				state = "stalled"
+			case link.Slow:
+				state = "slow"
+			}
+			fmt.Fprintf(w, "    %s\t%s\t%v\toutages=%d\tframes=%d\tqueued=%d/%d\tsince=%s\n",
+				link.Addr, state, link.EndPoints, link.Outages, link.Frames, link.Queued, link.Capacity, link.Since,
+			)
+		}
+	}
+	if run := report.Run; run != nil {
+		fmt.Fprintf(w, "run:\tbytes=%d\tdropped=%d\terrors=%d\tdead-time=%v (%.2f%%)\n",
+			run.Bytes, run.Dropped, run.Errors, run.DeadTime.Round(time.Millisecond), 100*run.Dead,
+		)
+		for _, s := range run.Streams {
+			fmt.Fprintf(w, "    %s\tproduced=%d\tconsumed=%d\tbytes=%d\n", s.Name, s.Produced, s.Consumed, s.Bytes)
+		}
+	}
+	return w.Flush()
+}
+
+func graph(args []string, stdout io.Writer) error {
+	fset := flag.NewFlagSet("graph", flag.ContinueOnError)
+	var (
+		addr    = fset.String("web", ":8080", "[addr]:port of run-ctl web server")
+		format  = fset.String("format", "dot", "format of the dataflow graph (dot, json)")
+		timeout = fset.Duration("timeout", 5*time.Second, "timeout for the graph request")
+	)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: tdaq-runctl graph [options]\n\nex:\n $> tdaq-runctl graph -web=:8080 | dot -Tsvg -o tdaq.svg\n $> tdaq-runctl graph -web=:8080 -format=json > tdaq.json\n\noptions:\n")
+		fset.PrintDefaults()
+	}
+
+	err := flags.Parse(fset, args, flags.WithName("tdaq-runctl"))
+	if err != nil {
+		return err
+	}
+
+	cli := http.Client{Timeout: *timeout}
+	resp, err := cli.Get(webURL(*addr) + "/api/graph?format=" + url.QueryEscape(*format))
+	if err != nil {
+		return fmt.Errorf("could not retrieve dataflow graph: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not retrieve dataflow graph: %s", resp.Status)
+	}
+
+	_, err = io.Copy(stdout, resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not write dataflow graph: %w", err)
+	}
+	return nil
+}
+
+func kv(args []string, stdout io.Writer) error {
+	fset := flag.NewFlagSet("kv", flag.ContinueOnError)
+	var (
+		addr    = fset.String("web", ":8080", "[addr]:port of run-ctl web server")
+		user    = fset.String("user", os.Getenv("USER"), "name of the operator editing the store")
+		secret  = fset.String("control", os.Getenv("TDAQ_CONTROL"), "secret of the control session holding the control token, if any")
+		timeout = fset.Duration("timeout", 5*time.Second, "timeout for the key-value store requests")
+	)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), `Usage: tdaq-runctl kv [options] list [prefix] | get <key> | set <key> <value> | del <key> | history [key]
+
+ex:
+ $> tdaq-runctl kv set adc/threshold 42
+ $> tdaq-runctl kv list adc/
+ $> tdaq-runctl kv history adc/threshold
+
+options:
+`)
+		fset.PrintDefaults()
+	}
+
+	err := flags.Parse(fset, args, flags.WithName("tdaq-runctl"))
+	if err != nil {
+		return err
+	}
+	args = fset.Args()
+	if len(args) == 0 {
+		fset.Usage()
+		return fmt.Errorf("missing key-value store command")
+	}
+
+	var (
+		cli  = http.Client{Timeout: *timeout}
+		base = webURL(*addr) + "/api/kv"
+		form = make(url.Values)
+		req  *http.Request
+	)
+	form.Set("user", *user)
+
+	// number of arguments of the commands, [min, max].
+	nargs := map[string][2]int{
+		"list":    {1, 2},
+		"get":     {2, 2},
+		"set":     {3, 3},
+		"del":     {2, 2},
+		"history": {1, 2},
+	}
+	n, ok := nargs[args[0]]
+	switch {
+	case !ok:
+		fset.Usage()
+		return fmt.Errorf("invalid key-value store command %q", args[0])
+	case len(args) < n[0] || len(args) > n[1]:
+		fset.Usage()
+		return fmt.Errorf("invalid number of arguments for %q", args[0])
+	}
+	args = append(args, "", "")
+
+	switch args[0] {
+	case "list", "get":
+		form.Set("prefix", args[1])
+		req, err = http.NewRequest(http.MethodGet, base+"?"+form.Encode(), nil)
+	case "set":
+		form.Set("key", args[1])
+		form.Set("value", args[2])
+		req, err = http.NewRequest(http.MethodPost, base, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	case "del":
+		form.Set("key", args[1])
+		req, err = http.NewRequest(http.MethodDelete, base+"?"+form.Encode(), nil)
+	case "history":
+		form.Set("key", args[1])
+		req, err = http.NewRequest(http.MethodGet, base+"/history?"+form.Encode(), nil)
+	}
+	if err != nil {
+		return fmt.Errorf("could not create key-value store request: %w", err)
+	}
+	if *secret != "" {
+		req.AddCookie(&http.Cookie{Name: controlCookie, Value: *secret})
+	}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach run-ctl key-value store: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("could not %s key-value store: %s: %s", args[0], resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	switch args[0] {
+	case "list", "get":
+		var store struct {
+			Version uint64         `json:"version"`
+			Entries []tdaq.KVEntry `json:"entries"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&store)
+		if err != nil {
+			return fmt.Errorf("could not decode key-value store: %w", err)
+		}
+		if args[0] == "get" {
+			for _, e := range store.Entries {
+				if e.Key == args[1] {
+					fmt.Fprintln(stdout, e.Value)
+					return nil
+				}
+			}
+			return fmt.Errorf("no key %q", args[1])
+		}
+		fmt.Fprintf(stdout, "version: %d\n", store.Version)
+		w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+		for _, e := range store.Entries {
+			fmt.Fprintf(w, "  %s\t%s\tversion=%d\tby=%s\n", e.Key, e.Value, e.Version, e.User)
+		}
+		return w.Flush()
+	case "history":
+		var changes []tdaq.KVChange
+		err = json.NewDecoder(resp.Body).Decode(&changes)
+		if err != nil {
+			return fmt.Errorf("could not decode key-value store history: %w", err)
+		}
+		w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+		for _, c := range changes {
+			change := fmt.Sprintf("%q -> %q", c.Old, c.Value)
+			if c.Deleted {
+				change = fmt.Sprintf("%q deleted", c.Old)
+			}
+			fmt.Fprintf(w, "  %d\t%s\t%s\t%s\tby=%s\n", c.Version, c.Time.Format(time.RFC3339), c.Key, change, c.User)
+		}
+		return w.Flush()
+	default:
+		var vers struct {
+			Version uint64 `json:"version"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&vers)
+		if err != nil {
+			return fmt.Errorf("could not decode key-value store version: %w", err)
+		}
+		fmt.Fprintf(stdout, "version: %d\n", vers.Version)
+
+		// the write took the free control token: give it back.
+		for _, c := range resp.Cookies() {
+			if c.Name != controlCookie || c.Value == "" || *secret != "" {
+				continue
+			}
+			err = releaseControl(&cli, webURL(*addr), *user, c)
+			if err != nil {
+				return fmt.Errorf("could not release control token: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// controlCookie is the name of the HTTP cookie carrying the secret of the
+// control session of the clients of the run-ctl web server.
+const controlCookie = "tdaq-control"
+
+// releaseControl releases, on behalf of user, the control token of run-ctl
+// held by the control session of the provided cookie.
+func releaseControl(cli *http.Client, base, user string, cookie *http.Cookie) error {
+	form := url.Values{"release": {"1"}, "user": {user}}
+	req, err := http.NewRequest(http.MethodPost, base+"/api/control", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("could not create control request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach run-ctl control token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func tail(args []string, stdout io.Writer) error {
+	fset := flag.NewFlagSet("log", flag.ContinueOnError)
+	var (
+		addr    = fset.String("web", ":8080", "[addr]:port of run-ctl web server")
+		follow  = fset.Bool("f", false, "follow the live log messages of the process")
+		lvl     = fset.String("level", "dbg", "minimum verbosity level of the displayed log messages")
+		timeout = fset.Duration("timeout", 5*time.Second, "timeout for the log request (ignored with -f)")
+	)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), `Usage: tdaq-runctl log [options] <proc> [options]
+
+ex:
+ $> tdaq-runctl log data-src
+ $> tdaq-runctl log data-src -f -level=warn
+
+options:
+`)
+		fset.PrintDefaults()
+	}
+
+	err := flags.Parse(fset, args, flags.WithName("tdaq-runctl"))
+	if err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return fmt.Errorf("missing process name")
+	}
+	name := fset.Arg(0)
+	// options may also follow the process name.
+	err = fset.Parse(fset.Args()[1:])
+	if err != nil {
+		return err
+	}
+	if fset.NArg() != 0 {
+		fset.Usage()
+		return fmt.Errorf("invalid number of arguments")
+	}
+
+	form := make(url.Values)
+	form.Set("proc", name)
+	form.Set("level", *lvl)
+	cli := http.Client{Timeout: *timeout}
+	if *follow {
+		form.Set("follow", "1")
+		cli.Timeout = 0
+	}
+
+	resp, err := cli.Get(webURL(*addr) + "/api/log?" + form.Encode())
+	if err != nil {
+		return fmt.Errorf("could not retrieve log messages of %q: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("could not retrieve log messages of %q: %s: %s", name, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Msg string `json:"msg"`
+		}
+		err = dec.Decode(&msg)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("could not decode log message of %q: %w", name, err)
+		}
+		_, err = io.WriteString(stdout, msg.Msg)
+		if err != nil {
+			return fmt.Errorf("could not write log message of %q: %w", name, err)
+		}
+	}
+}
+
+// webURL returns the base URL of the run-ctl web server at addr.
+func webURL(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	return addr
+}
+
 func newShell(cfg config.RunCtl, rc *tdaq.RunControl) *liner.State {
 
 	fmt.Printf(`
@@ -54,8 +606,15 @@ func newShell(cfg config.RunCtl, rc *tdaq.RunControl) *liner.State {
 - /init   -> initialize tdaq processes
 - /run    -> start a new run
 - /stop   -> stop current run
+- /pause  -> pause current run (drain and hold data flow)
+- /resume -> resume paused run
 - /reset  -> reset tdaq processes
 - /status -> display status of all tdaq processes
+- /comment <text> -> post a comment to the logbook
+- /alarms -> display alarms of all tdaq processes
+- /log <proc> [-f] [-level=lvl] -> display (and follow) log messages of a tdaq process
+- /ack <proc> <alarm> -> acknowledge an alarm
+- /control [take [-force]|release] -> display, take or release the control token
 - /quit   -> terminate tdaq processes (and quit)
 
 `)
@@ -67,7 +626,7 @@ func newShell(cfg config.RunCtl, rc *tdaq.RunControl) *liner.State {
 
 	go func() {
 		quit := make(chan struct{})
-		ctx := context.Background()
+		ctx := tdaq.WithControl(tdaq.WithOperator(context.Background(), os.Getenv("USER")), "")
 		defer func() {
 			select {
 			case <-quit:
@@ -129,6 +688,20 @@ func newShell(cfg config.RunCtl, rc *tdaq.RunControl) *liner.State {
 					log.Errorf("could not run /stop: %+v", err)
 					continue
 				}
+			case "/pause":
+				term.AppendHistory(o)
+				err = rc.Do(ctx, tdaq.CmdPause)
+				if err != nil {
+					log.Errorf("could not run /pause: %+v", err)
+					continue
+				}
+			case "/resume":
+				term.AppendHistory(o)
+				err = rc.Do(ctx, tdaq.CmdResume)
+				if err != nil {
+					log.Errorf("could not run /resume: %+v", err)
+					continue
+				}
 			case "/quit":
 				term.AppendHistory(o)
 				err = rc.Do(ctx, tdaq.CmdQuit)
@@ -145,6 +718,131 @@ func newShell(cfg config.RunCtl, rc *tdaq.RunControl) *liner.State {
 					log.Errorf("could not run /status: %+v", err)
 					continue
 				}
+			case "/alarms":
+				term.AppendHistory(o)
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				for _, alarm := range rc.Alarms() {
+					state := "active"
+					if !alarm.Active {
+						state = "cleared"
+					}
+					if alarm.Acked {
+						state += ",acked"
+					}
+					fmt.Fprintf(w, "  - %s\t%s\t%v\t%s\t%s\n", alarm.Proc, alarm.ID, alarm.Severity, state, alarm.Text)
+				}
+				_ = w.Flush()
+			case "/control":
+				term.AppendHistory(o)
+				err = control(ctx, rc, words[1:])
+				if err != nil {
+					log.Errorf("could not run /control: %+v", err)
+					continue
+				}
+			case "/log":
+				term.AppendHistory(o)
+				err = tailLog(ctx, rc, words[1:])
+				if err != nil {
+					log.Errorf("could not run /log: %+v", err)
+					continue
+				}
+			case "/ack":
+				term.AppendHistory(o)
+				if len(words) != 3 {
+					log.Errorf("invalid /ack command %q (want: /ack <proc> <alarm>)", o)
+					continue
+				}
+				err = rc.AckAlarm(words[1], words[2], os.Getenv("USER"))
+				if err != nil {
+					log.Errorf("could not run /ack: %+v", err)
+					continue
+				}
+			case "/comment":
+				term.AppendHistory(o)
+				err = rc.Comment(os.Getenv("USER"), strings.Join(words[1:], " "))
+				if err != nil {
+					log.Errorf("could not run /comment: %+v", err)
+					continue
+				}
+			case "/reconfig":
+				term.AppendHistory(o)
+				err = rc.Reconfig(ctx, words[1:]...)
+				if err != nil {
+					log.Errorf("could not run /reconfig: %+v", err)
+					continue
+				}
+			case "/calibs":
+				term.AppendHistory(o)
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+				for _, c := range rc.Calibs() {
+					fmt.Fprintf(w, "  - %s\tv%d\t%s\t%d bytes\t%s\n", c.Name, c.Version, c.Proc, c.Size, c.Time.Format(time.RFC3339))
+					names := make([]string, 0, len(c.Acks))
+					for name := range c.Acks {
+						names = append(names, name)
+					}
+					sort.Strings(names)
+					for _, name := range names {
+						fmt.Fprintf(w, "    %s\tv%d\n", name, c.Acks[name])
+					}
+				}
+				_ = w.Flush()
+			case "/snapshot":
+				term.AppendHistory(o)
+				if len(words) != 2 {
+					log.Errorf("invalid /snapshot command %q (want: /snapshot <file>)", o)
+					continue
+				}
+				err = snapshot(ctx, rc, words[1])
+				if err != nil {
+					log.Errorf("could not run /snapshot: %+v", err)
+					continue
+				}
+			case "/restore":
+				term.AppendHistory(o)
+				if len(words) != 2 {
+					log.Errorf("invalid /restore command %q (want: /restore <file>)", o)
+					continue
+				}
+				err = restore(ctx, rc, words[1])
+				if err != nil {
+					log.Errorf("could not run /restore: %+v", err)
+					continue
+				}
+			case "/replay":
+				term.AppendHistory(o)
+				if len(words) < 2 || len(words) > 3 {
+					log.Errorf("invalid /replay command %q (want: /replay <file> [speed])", o)
+					continue
+				}
+				speed := 1.0
+				if len(words) == 3 {
+					speed, err = strconv.ParseFloat(words[2], 64)
+					if err != nil {
+						log.Errorf("invalid /replay speed %q: %+v", words[2], err)
+						continue
+					}
+				}
+				err = replay(ctx, rc, words[1], speed)
+				if err != nil {
+					log.Errorf("could not run /replay: %+v", err)
+					continue
+				}
+			case "/debug":
+				term.AppendHistory(o)
+				if len(words) < 3 || words[1] != "pprof" {
+					log.Errorf("invalid /debug command %q (want: /debug pprof on|off [procs...])", o)
+					continue
+				}
+				err = rc.Debug(ctx, strings.Join(words[1:3], " "), words[3:]...)
+				if err != nil {
+					log.Errorf("could not run /debug: %+v", err)
+				}
+				replies := rc.Replies(tdaq.CmdDebug)
+				for name, addr := range replies.Procs {
+					if len(addr) > 0 {
+						log.Infof("%s: pprof server listening on %q", name, addr)
+					}
+				}
 			default:
 				log.Errorf("invalid tdaq command %q", o)
 				continue
@@ -155,6 +853,139 @@ func newShell(cfg config.RunCtl, rc *tdaq.RunControl) *liner.State {
 	return term
 }
 
+// control displays, takes or releases the control token of run-ctl on behalf
+// of the operator and of the control session of the shell.
+func control(ctx context.Context, rc *tdaq.RunControl, args []string) error {
+	switch {
+	case len(args) == 0:
+		// ok.
+	case len(args) == 1 && args[0] == "take":
+		_, err := rc.TakeControl(ctx, false)
+		if err != nil {
+			return err
+		}
+	case len(args) == 2 && args[0] == "take" && args[1] == "-force":
+		_, err := rc.TakeControl(ctx, true)
+		if err != nil {
+			return err
+		}
+	case len(args) == 1 && args[0] == "release":
+		err := rc.ReleaseControl(ctx)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid /control arguments %q (want: /control [take [-force]|release])", args)
+	}
+
+	tok := rc.Control()
+	if tok.Operator == "" {
+		fmt.Printf("control token: free\n")
+		return nil
+	}
+	fmt.Printf("control token: held by %q since %s\n", tok.Operator, tok.Since.Format(time.RFC3339))
+	return nil
+}
+
+// tailLog displays the recent log messages of a tdaq process and, with -f,
+// its live log messages until interrupted.
+func tailLog(ctx context.Context, rc *tdaq.RunControl, args []string) error {
+	var (
+		name   string
+		lvl    = log.LvlDebug
+		follow bool
+		err    error
+	)
+	for _, arg := range args {
+		switch {
+		case arg == "":
+			continue
+		case arg == "-f":
+			follow = true
+		case strings.HasPrefix(arg, "-level="):
+			lvl, err = log.ParseLevel(strings.TrimPrefix(arg, "-level="))
+			if err != nil {
+				return err
+			}
+		case name == "" && !strings.HasPrefix(arg, "-"):
+			name = arg
+		default:
+			return fmt.Errorf("invalid /log argument %q (want: /log <proc> [-f] [-level=lvl])", arg)
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("missing process name (want: /log <proc> [-f] [-level=lvl])")
+	}
+
+	if follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, os.Interrupt)
+		defer signal.Stop(sigc)
+		go func() {
+			select {
+			case <-sigc:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		fmt.Printf("following log messages of %q (Ctrl-C to stop)...\n", name)
+	}
+
+	return rc.TailLog(ctx, os.Stdout, name, lvl, follow)
+}
+
+// snapshot writes a snapshot of the TDAQ partition to the named file.
+func snapshot(ctx context.Context, rc *tdaq.RunControl, fname string) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("could not create snapshot file: %w", err)
+	}
+	defer f.Close()
+
+	meta, err := rc.Snapshot(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close snapshot file: %w", err)
+	}
+	fmt.Printf("snapshot of run %d with %d processes written to %q\n", meta.RunNbr, len(meta.Procs), fname)
+	return nil
+}
+
+// restore restores the TDAQ partition from the named snapshot file.
+func restore(ctx context.Context, rc *tdaq.RunControl, fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("could not open snapshot file: %w", err)
+	}
+	defer f.Close()
+
+	meta, err := rc.Restore(ctx, f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored snapshot of run %d (%s)\n", meta.RunNbr, meta.Time.Format(time.RFC3339))
+	return nil
+}
+
+// replay re-issues the sequence of commands recorded in the named file.
+func replay(ctx context.Context, rc *tdaq.RunControl, fname string, speed float64) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("could not open command record file: %w", err)
+	}
+	defer f.Close()
+
+	return rc.Replay(ctx, f, speed)
+}
+
 func shellCompleter(line string, pos int) (prefix string, completions []string, suffix string) {
 	if pos != len(line) {
 		// TODO(sbinet): better mid-line matching...
@@ -167,10 +998,19 @@ func shellCompleter(line string, pos int) (prefix string, completions []string,
 	}
 
 	cmds := []string{
-		"/config", "/init", "/reset",
+		"/config", "/reconfig", "/init", "/reset",
 		"/run", "/stop",
+		"/pause", "/resume",
 		"/quit",
 		"/status",
+		"/comment",
+		"/alarms", "/ack",
+		"/log",
+		"/control",
+		"/calibs",
+		"/snapshot", "/restore",
+		"/replay",
+		"/debug",
 	}
 
 	for _, cmd := range cmds {
@@ -0,0 +1,354 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main // import "github.com/go-daq/tdaq/cmd/tdaq-runctl"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-daq/tdaq/flags"
+	"github.com/peterh/liner"
+	"golang.org/x/net/websocket"
+)
+
+// ANSI escape sequences driving the terminal.
+const (
+	ansiClear = "\x1b[H\x1b[2J"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiReset = "\x1b[0m"
+)
+
+// topKeys are the keybindings of the transitions of the dashboard.
+var topKeys = []struct {
+	key  byte
+	cmd  string
+	help string
+}{
+	{'c', "/config", "[c]onfig"},
+	{'i', "/init", "[i]nit"},
+	{'s', "/start", "[s]tart"},
+	{'t', "/stop", "s[t]op"},
+	{'p', "/pause", "[p]ause"},
+	{'u', "/resume", "res[u]me"},
+	{'r', "/reset", "[r]eset"},
+}
+
+func top(args []string, stdout io.Writer) error {
+	fset := flag.NewFlagSet("top", flag.ContinueOnError)
+	var (
+		addr    = fset.String("web", ":8080", "[addr]:port of run-ctl web server")
+		refresh = fset.Duration("refresh", time.Second, "refresh period of the dashboard")
+		nlogs   = fset.Int("logs", 10, "number of recent log lines displayed")
+		timeout = fset.Duration("timeout", 5*time.Second, "timeout for the run-ctl requests")
+	)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), `Usage: tdaq-runctl top [options]
+
+top displays a live full-screen dashboard of the processes of a running
+run-control server, their states, data rates and recent log lines.
+
+keybindings:
+ c: /config, i: /init, s: /start, t: /stop, r: /reset
+ p: /pause, u: /resume
+ q: exit the dashboard
+
+ex:
+ $> tdaq-runctl top -web=:8080
+
+options:
+`)
+		fset.PrintDefaults()
+	}
+
+	err := flags.Parse(fset, args, flags.WithName("tdaq-runctl"))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dash := newDashboard(webURL(*addr), *timeout, *nlogs)
+	go dash.tail(ctx)
+
+	// liner switches the terminal to raw mode, so keys are read as they
+	// are pressed.
+	term := liner.NewLiner()
+	defer term.Close()
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			_, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	tick := time.NewTicker(*refresh)
+	defer tick.Stop()
+
+	defer fmt.Fprint(stdout, ansiClear)
+	for {
+		dash.update()
+		dash.render(stdout)
+
+		select {
+		case <-tick.C:
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			switch key {
+			case 'q', 3, 4: // q, ctrl-c, ctrl-d
+				return nil
+			}
+			for _, k := range topKeys {
+				if k.key == key {
+					dash.command(k.cmd)
+				}
+			}
+		}
+	}
+}
+
+// dashboard holds the state of the terminal dashboard.
+type dashboard struct {
+	url   string
+	cli   http.Client
+	nlogs int
+
+	report statusReport
+	err    error              // error of the last status request
+	last   string             // outcome of the last transition
+	prev   map[string]counter // data counters of the previous refresh
+	rates  map[string]counter // data rates of the processes (per second)
+	beat   time.Time          // time of the previous refresh
+
+	mu   sync.Mutex
+	logs []string // recent log lines
+}
+
+// counter holds the data counters of the links of a process.
+type counter struct {
+	frames float64
+	bytes  float64
+}
+
+func newDashboard(url string, timeout time.Duration, nlogs int) *dashboard {
+	return &dashboard{
+		url:   url,
+		cli:   http.Client{Timeout: timeout},
+		nlogs: nlogs,
+		prev:  make(map[string]counter),
+		rates: make(map[string]counter),
+	}
+}
+
+// update retrieves the status of run-ctl and computes the data rates of
+// the processes since the previous update.
+func (dash *dashboard) update() {
+	var report statusReport
+	dash.err = func() error {
+		resp, err := dash.cli.Get(dash.url + "/api/status")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("could not retrieve run-ctl status: %s", resp.Status)
+		}
+		return json.NewDecoder(resp.Body).Decode(&report)
+	}()
+	if dash.err != nil {
+		return
+	}
+
+	var (
+		now = time.Now()
+		dt  = now.Sub(dash.beat).Seconds()
+		cur = make(map[string]counter, len(report.Procs))
+	)
+	for _, proc := range report.Procs {
+		var c counter
+		for _, link := range proc.Links {
+			c.frames += float64(link.Frames)
+			c.bytes += float64(link.Bytes)
+		}
+		cur[proc.Name] = c
+
+		prev, ok := dash.prev[proc.Name]
+		switch {
+		case !ok || dash.beat.IsZero() || c.frames < prev.frames:
+			// new process or counters reset at /start.
+			dash.rates[proc.Name] = counter{}
+		default:
+			dash.rates[proc.Name] = counter{
+				frames: (c.frames - prev.frames) / dt,
+				bytes:  (c.bytes - prev.bytes) / dt,
+			}
+		}
+	}
+
+	dash.report = report
+	dash.prev = cur
+	dash.beat = now
+}
+
+// command sends the transition to run-ctl.
+func (dash *dashboard) command(cmd string) {
+	dash.last = cmd + ": ok"
+
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	_ = form.WriteField("cmd", cmd)
+	_ = form.Close()
+
+	resp, err := dash.cli.Post(dash.url+"/cmd", form.FormDataContentType(), body)
+	if err != nil {
+		dash.last = fmt.Sprintf("%s: %v", cmd, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		dash.last = fmt.Sprintf("%s: %s", cmd, strings.TrimSpace(string(msg)))
+	}
+}
+
+// tail collects the log lines of the processes, until the context is
+// canceled.
+func (dash *dashboard) tail(ctx context.Context) {
+	var (
+		addr   = "ws" + strings.TrimPrefix(dash.url, "http") + "/msg"
+		origin = dash.url + "/"
+	)
+	for {
+		ws, err := websocket.Dial(addr, "", origin)
+		if err == nil {
+			go func() {
+				<-ctx.Done()
+				ws.Close()
+			}()
+			for {
+				var msg struct {
+					Name      string `json:"name"`
+					Level     string `json:"level"`
+					Msg       string `json:"msg"`
+					Timestamp string `json:"timestamp"`
+				}
+				err = websocket.JSON.Receive(ws, &msg)
+				if err != nil {
+					break
+				}
+				dash.log(fmt.Sprintf("%s %-8s %s", msg.Timestamp, msg.Level, strings.TrimRight(msg.Msg, "\n")))
+			}
+			ws.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (dash *dashboard) log(line string) {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+	dash.logs = append(dash.logs, line)
+	if n := len(dash.logs) - dash.nlogs; n > 0 {
+		dash.logs = append(dash.logs[:0], dash.logs[n:]...)
+	}
+}
+
+// render draws the dashboard on the terminal.
+func (dash *dashboard) render(stdout io.Writer) {
+	o := new(bytes.Buffer)
+
+	keys := make([]string, 0, len(topKeys)+1)
+	for _, k := range topKeys {
+		keys = append(keys, k.help)
+	}
+	keys = append(keys, "[q] exit")
+
+	fmt.Fprintf(o, "%stdaq-runctl top%s  %s\n", ansiBold, ansiReset, dash.url)
+	switch {
+	case dash.err != nil:
+		fmt.Fprintf(o, "run-ctl: %s%v%s\n", ansiRed, dash.err, ansiReset)
+	default:
+		fmt.Fprintf(o, "run-ctl: %s%s%s (%s)\n", ansiBold, dash.report.Status, ansiReset, dash.report.Timestamp)
+	}
+	fmt.Fprintf(o, "%s\n", strings.Join(keys, " "))
+	if dash.last != "" {
+		fmt.Fprintf(o, "last: %s\n", dash.last)
+	}
+	o.WriteString("\n")
+
+	w := tabwriter.NewWriter(o, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "PROCESS\tSTATE\tRTT\tFRAMES/s\tBYTES/s\tLINKS\n")
+	for _, proc := range dash.report.Procs {
+		var (
+			state = proc.Status
+			up    = 0
+			rate  = dash.rates[proc.Name]
+		)
+		if proc.Slow {
+			state += " (slow)"
+		}
+		for _, link := range proc.Links {
+			if link.Up {
+				up++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\t%s\t%d/%d\n",
+			proc.Name, state, proc.RTT, rate.frames, humanBytes(rate.bytes), up, len(proc.Links),
+		)
+	}
+	_ = w.Flush()
+
+	fmt.Fprintf(o, "\n%slog%s\n", ansiBold, ansiReset)
+	dash.mu.Lock()
+	for _, line := range dash.logs {
+		fmt.Fprintf(o, "%s\n", line)
+	}
+	dash.mu.Unlock()
+
+	// the terminal is in raw mode: lines need an explicit carriage return.
+	fmt.Fprint(stdout, ansiClear+strings.Replace(o.String(), "\n", "\r\n", -1))
+}
+
+// humanBytes formats a data rate with binary prefixes.
+func humanBytes(v float64) string {
+	const unit = 1024
+	if v < unit {
+		return fmt.Sprintf("%.0f", v)
+	}
+	div, exp := float64(unit), 0
+	for n := v / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ci", v/div, "KMGTPE"[exp])
+}
@@ -7,9 +7,7 @@
 package main
 
 import (
-	"context"
 	"flag"
-	"os"
 
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/flags"
@@ -27,7 +25,7 @@ func main() {
 		seed  = flag.Uint64("seed", 1234, "seed for the random number generator")
 	)
 
-	cmd := flags.New()
+	cmd := flags.New(flags.WithLinkFlags(), flags.WithDeliveryFlags())
 
 	fct := func(seed uint64, frac float64) func() bool {
 		rnd := rand.New(rand.NewSource(seed))
@@ -37,22 +35,17 @@ func main() {
 	}
 
 	dev := xdaq.Scaler{Accept: fct(*seed, *frac)}
-	srv := tdaq.New(cmd, os.Stdout)
-	srv.CmdHandle("/config", dev.OnConfig)
-	srv.CmdHandle("/init", dev.OnInit)
-	srv.CmdHandle("/start", dev.OnStart)
-	srv.CmdHandle("/stop", dev.OnStop)
-	srv.CmdHandle("/reset", func(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
-		// reset state of random generator as well
-		dev.Accept = fct(*seed, *frac)
-		return dev.OnReset(ctx, resp, req)
-	})
-	srv.CmdHandle("/quit", dev.OnQuit)
-
-	srv.InputHandle(*iname, dev.Input)
-	srv.OutputHandle(*oname, dev.Output)
-
-	err := srv.Run(context.Background())
+	err := tdaq.Serve(
+		&dev,
+		tdaq.WithConfig(cmd),
+		tdaq.WithCmd("/reset", func(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+			// reset state of random generator as well
+			dev.Accept = fct(*seed, *frac)
+			return dev.OnReset(ctx, resp, req)
+		}),
+		tdaq.WithInput(*iname, dev.Input),
+		tdaq.WithOutput(*oname, dev.Output),
+	)
 	if err != nil {
 		log.Panicf("error: %+v", err)
 	}
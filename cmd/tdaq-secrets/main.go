@@ -0,0 +1,164 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command tdaq-secrets manages the encrypted secrets stores of TDAQ
+// processes.
+//
+// Stores are encrypted with the key held by the $TDAQ_SECRETS_KEY
+// environment variable, as generated by the keygen subcommand.
+// TDAQ processes load their store (-secrets flag) at /config and resolve
+// "secret:store:NAME" references from it.
+//
+// Values of secrets are read from the standard input, so they do not show
+// up in the shell history.
+//
+// ex:
+//
+//	$> export TDAQ_SECRETS_KEY=$(tdaq-secrets keygen)
+//	$> echo -n "s3cr3t" | tdaq-secrets set -f ./secrets.db db-password
+//	$> tdaq-secrets list -f ./secrets.db
+//	db-password
+//	$> tdaq-secrets del -f ./secrets.db db-password
+package main // import "github.com/go-daq/tdaq/cmd/tdaq-secrets"
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/go-daq/tdaq/flags"
+	"github.com/go-daq/tdaq/internal/secrets"
+	"github.com/go-daq/tdaq/log"
+)
+
+func main() {
+	cmds := flags.Commands{
+		Name: "tdaq-secrets",
+		Cmds: []*flags.Command{
+			{
+				Name:  "keygen",
+				Short: "generate a new key for secrets stores",
+				Run: func(args []string) error {
+					return keygen(args, os.Stdout)
+				},
+			},
+			{
+				Name:  "list",
+				Short: "list the names of the secrets of a store",
+				Run: func(args []string) error {
+					return list(args, os.Stdout)
+				},
+			},
+			{
+				Name:  "set",
+				Short: "set a secret of a store, from the standard input",
+				Run: func(args []string) error {
+					return set(args, os.Stdin)
+				},
+			},
+			{
+				Name:  "del",
+				Short: "delete a secret of a store",
+				Run: func(args []string) error {
+					return del(args)
+				},
+			},
+		},
+	}
+
+	err := cmds.Dispatch(os.Args[1:])
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+func keygen(args []string, stdout io.Writer) error {
+	fset := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	err := fset.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	key, err := secrets.NewKey()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, key)
+	return nil
+}
+
+// storeFlags parses the flags and arguments of the subcommands editing a
+// store, and opens the store.
+func storeFlags(name, usage string, nargs int, args []string) (*secrets.Store, string, []byte, []string, error) {
+	fset := flag.NewFlagSet(name, flag.ContinueOnError)
+	fname := fset.String("f", "tdaq-secrets.db", "path to the secrets store")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: tdaq-secrets %s [options] %s\n\noptions:\n", name, usage)
+		fset.PrintDefaults()
+	}
+
+	err := flags.Parse(fset, args, flags.WithName("tdaq-secrets"))
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	args = fset.Args()
+	if len(args) != nargs {
+		fset.Usage()
+		return nil, "", nil, nil, fmt.Errorf("invalid number of arguments for %q", name)
+	}
+
+	key, err := secrets.KeyFromEnv()
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+
+	store, err := secrets.Open(*fname, key)
+	if err != nil {
+		return nil, "", nil, nil, err
+	}
+	return store, *fname, key, args, nil
+}
+
+func list(args []string, stdout io.Writer) error {
+	store, _, _, _, err := storeFlags("list", "", 0, args)
+	if err != nil {
+		return err
+	}
+	for _, name := range store.Names() {
+		fmt.Fprintln(stdout, name)
+	}
+	return nil
+}
+
+func set(args []string, stdin io.Reader) error {
+	store, fname, key, args, err := storeFlags("set", "<name>", 1, args)
+	if err != nil {
+		return err
+	}
+
+	raw, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("could not read secret value: %w", err)
+	}
+	store.Set(args[0], strings.TrimRight(string(raw), "\r\n"))
+
+	return store.Save(fname, key)
+}
+
+func del(args []string) error {
+	store, fname, key, args, err := storeFlags("del", "<name>", 1, args)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := store.Get(args[0]); !ok {
+		return fmt.Errorf("no such secret %q", args[0])
+	}
+	store.Delete(args[0])
+
+	return store.Save(fname, key)
+}
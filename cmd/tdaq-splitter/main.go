@@ -7,9 +7,7 @@
 package main
 
 import (
-	"context"
 	"flag"
-	"os"
 
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/flags"
@@ -28,7 +26,7 @@ func main() {
 		seed  = flag.Uint64("seed", 1234, "seed for the random number generator")
 	)
 
-	cmd := flags.New()
+	cmd := flags.New(flags.WithLinkFlags(), flags.WithDeliveryFlags())
 
 	fct := func(seed uint64, frac float64) func() int {
 		rnd := rand.New(rand.NewSource(seed))
@@ -43,23 +41,18 @@ func main() {
 	}
 
 	dev := xdaq.Splitter{Fct: fct(*seed, *frac)}
-	srv := tdaq.New(cmd, os.Stdout)
-	srv.CmdHandle("/config", dev.OnConfig)
-	srv.CmdHandle("/init", dev.OnInit)
-	srv.CmdHandle("/start", dev.OnStart)
-	srv.CmdHandle("/stop", dev.OnStop)
-	srv.CmdHandle("/reset", func(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
-		// reset state of random generator as well
-		dev.Fct = fct(*seed, *frac)
-		return dev.OnReset(ctx, resp, req)
-	})
-	srv.CmdHandle("/quit", dev.OnQuit)
-
-	srv.InputHandle(*iname, dev.Input)
-	srv.OutputHandle(*lname, dev.Left)
-	srv.OutputHandle(*rname, dev.Right)
-
-	err := srv.Run(context.Background())
+	err := tdaq.Serve(
+		&dev,
+		tdaq.WithConfig(cmd),
+		tdaq.WithCmd("/reset", func(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+			// reset state of random generator as well
+			dev.Fct = fct(*seed, *frac)
+			return dev.OnReset(ctx, resp, req)
+		}),
+		tdaq.WithInput(*iname, dev.Input),
+		tdaq.WithOutput(*lname, dev.Left),
+		tdaq.WithOutput(*rname, dev.Right),
+	)
 	if err != nil {
 		log.Panicf("error: %+v", err)
 	}
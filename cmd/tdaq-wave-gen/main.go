@@ -0,0 +1,190 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command tdaq-wave-gen is an example program that publishes simulated,
+// timestamped, waveforms of a set of readout channels.
+//
+// tdaq-wave-gen and tdaq-wave-mon are meant as templates for TDAQ processes
+// exchanging typed payloads (see package internal/waveform): the waveform
+// end-points are declared with the "waveform" type in the topology, so
+// generic devices can decode the frames with the registered schema.
+//
+// Usage: tdaq-wave-gen [options]
+//
+// ex:
+//
+//	$> tdaq-wave-gen -id wave-gen -o /waveforms -nch 4 -freq 10ms
+//	$> tdaq-wave-mon -id wave-mon -i /waveforms
+//	$> tdaq-dump -id wave-dump -i /waveforms -schema 'time:time,channel:u16,samples:[]i16' -format jsonl
+package main // import "github.com/go-daq/tdaq/cmd/tdaq-wave-gen"
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/flags"
+	"github.com/go-daq/tdaq/internal/waveform"
+	"github.com/go-daq/tdaq/log"
+)
+
+func main() {
+	var (
+		oname = flag.String("o", "/waveforms", "name of the output waveform data stream end-point")
+		nch   = flag.Int("nch", 4, "number of readout channels")
+		nsmp  = flag.Int("nsamples", 64, "number of samples per waveform")
+		freq  = flag.Duration("freq", 10*time.Millisecond, "period of waveform generation")
+		seed  = flag.Int64("seed", 1234, "seed of the random number generator")
+	)
+
+	cmd := flags.New()
+
+	if typ, ok := cmd.Types[*oname]; ok && typ != waveform.Type {
+		log.Fatalf("invalid type %q of end-point %q (want %q)", typ, *oname, waveform.Type)
+	}
+
+	dev := device{
+		nch:  *nch,
+		nsmp: *nsmp,
+		freq: *freq,
+		seed: *seed,
+	}
+
+	err := tdaq.Serve(
+		&dev,
+		tdaq.WithConfig(cmd),
+		tdaq.WithOutput(*oname, dev.Output),
+		tdaq.WithRun(dev.Loop),
+	)
+	if err != nil {
+		log.Panicf("error: %+v", err)
+	}
+}
+
+type device struct {
+	nch  int
+	nsmp int
+	freq time.Duration
+	seed int64
+
+	n   int64  // number of waveforms published since /init
+	evt uint64 // ID of the last published waveform event
+	rnd *rand.Rand
+	ch  chan waveform.Waveform
+}
+
+func (dev *device) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	switch {
+	case dev.nch <= 0 || dev.nch > math.MaxUint16:
+		return fmt.Errorf("invalid number of channels (nch=%d)", dev.nch)
+	case dev.nsmp <= 0:
+		return fmt.Errorf("invalid number of samples (nsamples=%d)", dev.nsmp)
+	case dev.freq <= 0:
+		return fmt.Errorf("invalid waveform generation period (freq=%v)", dev.freq)
+	}
+	return nil
+}
+
+func (dev *device) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *device) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *device) reset() {
+	dev.n = 0
+	dev.evt = 0
+	dev.rnd = rand.New(rand.NewSource(dev.seed))
+	dev.ch = make(chan waveform.Waveform)
+}
+
+func (dev *device) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *device) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> n=%d", dev.n)
+	return nil
+}
+
+func (dev *device) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+func (dev *device) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+		return nil
+	case wf := <-dev.ch:
+		raw, err := wf.MarshalTDAQ()
+		if err != nil {
+			return fmt.Errorf("could not marshal waveform: %w", err)
+		}
+		dst.Body = raw
+		dev.evt++
+		dst.EventID = dev.evt
+	}
+	return nil
+}
+
+func (dev *device) Loop(ctx tdaq.Context) error {
+	tick := time.NewTicker(dev.freq)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Ctx.Done():
+			return nil
+		case now := <-tick.C:
+			for ch := 0; ch < dev.nch; ch++ {
+				select {
+				case <-ctx.Ctx.Done():
+					return nil
+				case dev.ch <- dev.waveform(now, uint16(ch)):
+					dev.n++
+				}
+			}
+		}
+	}
+}
+
+// waveform simulates the waveform of a pulse over a noisy baseline.
+func (dev *device) waveform(t time.Time, ch uint16) waveform.Waveform {
+	const (
+		baseline = 100
+		noise    = 2
+		tau      = 4 // decay time of the pulse, in samples
+	)
+
+	var (
+		amp = 50 + 200*dev.rnd.Float64()
+		t0  = float64(dev.nsmp) / 4 * (1 + dev.rnd.Float64())
+		wf  = waveform.Waveform{
+			Time:    t.UTC(),
+			Channel: ch,
+			Samples: make([]int16, dev.nsmp),
+		}
+	)
+	for i := range wf.Samples {
+		v := baseline + noise*dev.rnd.NormFloat64()
+		if dt := float64(i) - t0; dt >= 0 {
+			v += amp * dt / tau * math.Exp(1-dt/tau)
+		}
+		wf.Samples[i] = int16(v)
+	}
+	return wf
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command tdaq-wave-mon is an example program that consumes waveforms and
+// monitors the pulses of each readout channel.
+//
+// See tdaq-wave-gen for the producer of the waveforms.
+//
+// Usage: tdaq-wave-mon [options]
+//
+// ex:
+//
+//	$> tdaq-wave-mon -id wave-mon -i /waveforms -threshold 150
+package main // import "github.com/go-daq/tdaq/cmd/tdaq-wave-mon"
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/flags"
+	"github.com/go-daq/tdaq/internal/waveform"
+	"github.com/go-daq/tdaq/log"
+)
+
+func main() {
+	var (
+		iname = flag.String("i", "/waveforms", "name of the input waveform data stream end-point")
+		thr   = flag.Int("threshold", 150, "threshold of the peak value of pulses")
+	)
+
+	cmd := flags.New()
+
+	if typ, ok := cmd.Types[*iname]; ok && typ != waveform.Type {
+		log.Fatalf("invalid type %q of end-point %q (want %q)", typ, *iname, waveform.Type)
+	}
+
+	dev := device{thr: int16(*thr)}
+
+	err := tdaq.Serve(
+		&dev,
+		tdaq.WithConfig(cmd),
+		tdaq.WithInput(*iname, dev.Input),
+	)
+	if err != nil {
+		log.Panicf("error: %+v", err)
+	}
+}
+
+type device struct {
+	thr int16 // threshold of the peak value of pulses
+
+	chans map[uint16]*stats // statistics of pulses, per channel
+}
+
+type stats struct {
+	n      int64   // number of waveforms
+	pulses int64   // number of pulses above threshold
+	sum    float64 // sum of the peak values of pulses
+}
+
+func (dev *device) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	return nil
+}
+
+func (dev *device) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.chans = make(map[uint16]*stats)
+	return nil
+}
+
+func (dev *device) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.chans = make(map[uint16]*stats)
+	return nil
+}
+
+func (dev *device) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *device) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> %s", dev.summary())
+	return nil
+}
+
+func (dev *device) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+// summary returns the statistics of pulses of each channel.
+func (dev *device) summary() string {
+	ids := make([]int, 0, len(dev.chans))
+	for id := range dev.chans {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	o := new(strings.Builder)
+	for i, id := range ids {
+		if i > 0 {
+			o.WriteString(", ")
+		}
+		st := dev.chans[uint16(id)]
+		mean := 0.0
+		if st.pulses > 0 {
+			mean = st.sum / float64(st.pulses)
+		}
+		fmt.Fprintf(o, "ch-%d: n=%d, pulses=%d, <peak>=%.1f", id, st.n, st.pulses, mean)
+	}
+	return o.String()
+}
+
+func (dev *device) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	var wf waveform.Waveform
+	err := wf.UnmarshalTDAQ(src.Body)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal waveform: %w", err)
+	}
+
+	st, ok := dev.chans[wf.Channel]
+	if !ok {
+		st = new(stats)
+		dev.chans[wf.Channel] = st
+	}
+	st.n++
+
+	i, peak := wf.Peak()
+	if peak < dev.thr {
+		return nil
+	}
+	st.pulses++
+	st.sum += float64(peak)
+	ctx.Msg.Debugf("ch-%d: pulse at %v (sample=%d, peak=%d)", wf.Channel, wf.Time, i, peak)
+	return nil
+}
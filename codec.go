@@ -0,0 +1,316 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Payload encodings of the data frames of an output end-point.
+//
+// Encodings apply to payloads made of 64-bit integers, in the byte order of
+// the end-point (see Server.ByteOrder), such as monotonic counters and
+// timestamps.
+// Payloads are encoded after the output handler and decoded before the
+// input handlers, so handlers always see the plain payloads.
+const (
+	EncodingDelta    = "delta"     // zigzag varint differences of consecutive values
+	EncodingRLE      = "rle"       // runs of identical values, as (count, zigzag varint value) pairs
+	EncodingDeltaRLE = "delta-rle" // runs of identical differences of consecutive values
+)
+
+// codec encodes and decodes the payloads of an end-point.
+type codec struct {
+	name   string
+	order  binary.ByteOrder
+	delta  bool // whether differences of consecutive values are encoded
+	rle    bool // whether runs of identical (differences of) values are encoded
+	maxLen int  // maximum size of decoded payloads (0: unlimited)
+}
+
+// codecs are the names of the supported payload encodings.
+var codecs = []string{EncodingDelta, EncodingDeltaRLE, EncodingRLE}
+
+func newCodec(name string, order binary.ByteOrder, maxLen int) (*codec, error) {
+	c := &codec{name: name, order: order, maxLen: maxLen}
+	switch name {
+	case EncodingDelta:
+		c.delta = true
+	case EncodingRLE:
+		c.rle = true
+	case EncodingDeltaRLE:
+		c.delta = true
+		c.rle = true
+	default:
+		return nil, fmt.Errorf("unknown payload encoding %q", name)
+	}
+	return c, nil
+}
+
+// OutputEncoding declares the encoding of the payloads of the named output
+// end-point.
+//
+// The encoding is negotiated by run-ctl at /config: it is only enabled when
+// all the consumers of the end-point support it, the payloads are sent
+// plain otherwise.
+//
+// OutputEncoding panics if the encoding is unknown.
+func (srv *Server) OutputEncoding(name, enc string) {
+	if _, err := newCodec(enc, DefaultByteOrder, 0); err != nil {
+		panic(fmt.Errorf("invalid encoding for output end-point %q: %w", name, err))
+	}
+	srv.omgr.setEncoding(name, enc)
+}
+
+func (c *codec) encode(src []byte) ([]byte, error) {
+	if len(src)%8 != 0 {
+		return nil, fmt.Errorf("%s payload size %d is not a multiple of 8", c.name, len(src))
+	}
+
+	var (
+		dst  = make([]byte, 0, len(src)/2)
+		buf  [2 * binary.MaxVarintLen64]byte
+		prev uint64
+		run  uint64
+		cur  uint64
+	)
+	flush := func() {
+		n := binary.PutUvarint(buf[:], run)
+		n += binary.PutVarint(buf[n:], int64(cur))
+		dst = append(dst, buf[:n]...)
+	}
+	for i := 0; i < len(src); i += 8 {
+		v := c.order.Uint64(src[i:])
+		if c.delta {
+			v, prev = v-prev, v
+		}
+		if !c.rle {
+			n := binary.PutVarint(buf[:], int64(v))
+			dst = append(dst, buf[:n]...)
+			continue
+		}
+		if run > 0 && v == cur {
+			run++
+			continue
+		}
+		if run > 0 {
+			flush()
+		}
+		cur, run = v, 1
+	}
+	if run > 0 {
+		flush()
+	}
+	return dst, nil
+}
+
+func (c *codec) decode(src []byte) ([]byte, error) {
+	var (
+		dst  = make([]byte, 0, 2*len(src))
+		b    [8]byte
+		prev uint64
+	)
+	for len(src) > 0 {
+		run := uint64(1)
+		if c.rle {
+			n, k := binary.Uvarint(src)
+			if k <= 0 || n == 0 {
+				return nil, fmt.Errorf("invalid %s payload run", c.name)
+			}
+			run, src = n, src[k:]
+		}
+		v, k := binary.Varint(src)
+		if k <= 0 {
+			return nil, fmt.Errorf("invalid %s payload value", c.name)
+		}
+		src = src[k:]
+
+		if c.maxLen > 0 && run > uint64(c.maxLen-len(dst))/8 {
+			return nil, fmt.Errorf("%s payload exceeds maximum size %d", c.name, c.maxLen)
+		}
+		for ; run > 0; run-- {
+			w := uint64(v)
+			if c.delta {
+				prev += w
+				w = prev
+			}
+			c.order.PutUint64(b[:], w)
+			dst = append(dst, b[:]...)
+		}
+	}
+	return dst, nil
+}
+
+// payloadCodecs returns the sorted names of the supported payload encodings.
+func payloadCodecs() []string {
+	names := make([]string, len(codecs))
+	copy(names, codecs)
+	return names
+}
+
+// output encodes the payload produced by an output handler.
+// Plain payloads must fit within the maximum frame size, so consumers can
+// bound the size of the decoded payloads.
+func (c *codec) output(body []byte) ([]byte, error) {
+	if c.maxLen > 0 && len(body) > c.maxLen {
+		return nil, fmt.Errorf("%s payload size %d exceeds maximum size %d", c.name, len(body), c.maxLen)
+	}
+	return c.encode(body)
+}
+
+// input returns the input handler decoding the payloads before handling
+// them with h.
+func (c *codec) input(h InputHandler) InputHandler {
+	return func(ctx Context, src Frame) error {
+		body, err := c.decode(src.Body)
+		if err != nil {
+			return fmt.Errorf("could not decode payload: %w", err)
+		}
+		src.Body = body
+		return h(ctx, src)
+	}
+}
+
+// newCodecs returns the codecs of the named end-points with a negotiated
+// encoding, indexed by end-point name.
+func newCodecs(srv *Server, encs map[string]string, eps []string, maxLen int) (map[string]*codec, error) {
+	var cdcs map[string]*codec
+	for _, ep := range eps {
+		enc, ok := encs[ep]
+		if !ok {
+			continue
+		}
+		c, err := newCodec(enc, srv.EndPointByteOrder(ep), maxLen)
+		if err != nil {
+			return nil, fmt.Errorf("could not setup payload encoding of end-point %q: %w", ep, err)
+		}
+		if cdcs == nil {
+			cdcs = make(map[string]*codec)
+		}
+		cdcs[ep] = c
+	}
+	return cdcs, nil
+}
+
+// encodings returns the negotiated encodings of the output end-points,
+// indexed by end-point name: the encodings declared by their producers and
+// supported by all their consumers.
+// encodings must be called with rc.mu held.
+func (rc *RunControl) encodings() map[string]string {
+	encs := make(map[string]string)
+	for _, cli := range rc.clients.list() {
+		for ep, enc := range cli.encs {
+			encs[ep] = enc
+		}
+	}
+	for _, cli := range rc.clients.list() {
+		for _, iport := range cli.ieps {
+			enc, ok := encs[iport.Name]
+			if !ok || hasCodec(cli.codecs, enc) {
+				continue
+			}
+			rc.msg.Warnf("disabling %s encoding of %q: not supported by %q", enc, iport.Name, cli.name)
+			delete(encs, iport.Name)
+		}
+	}
+	return encs
+}
+
+func hasCodec(codecs []string, enc string) bool {
+	for _, c := range codecs {
+		if c == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// epEncodings returns the negotiated encodings of the end-points of the
+// provided process.
+func epEncodings(cli *client, encs map[string]string) map[string]string {
+	eps := make([]EndPoint, 0, len(cli.ieps)+len(cli.oeps))
+	eps = append(eps, cli.ieps...)
+	eps = append(eps, cli.oeps...)
+	return feedbackAddrs(eps, encs)
+}
+
+// compactLinks returns the output end-points whose data links carry
+// payloads encoded in compact mode: the end-points whose producer and
+// consumers all negotiated ProtoV4 or later.
+// compactLinks must be called with rc.mu held.
+func (rc *RunControl) compactLinks() map[string]struct{} {
+	eps := make(map[string]struct{})
+	for _, cli := range rc.clients.list() {
+		if cli.proto < ProtoV4 {
+			continue
+		}
+		for _, oport := range cli.oeps {
+			eps[oport.Name] = struct{}{}
+		}
+	}
+	for _, cli := range rc.clients.list() {
+		if cli.proto >= ProtoV4 {
+			continue
+		}
+		for _, iport := range cli.ieps {
+			delete(eps, iport.Name)
+		}
+	}
+	return eps
+}
+
+// epCompact returns the end-points of the provided process whose data links
+// carry compact payloads.
+func epCompact(cli *client, links map[string]struct{}) []string {
+	var eps []string
+	for _, list := range [][]EndPoint{cli.ieps, cli.oeps} {
+		for _, ep := range list {
+			if _, ok := links[ep.Name]; ok {
+				eps = append(eps, ep.Name)
+			}
+		}
+	}
+	return eps
+}
+
+// Compact returns whether the data link of the named end-point of the process
+// carries payloads encoded in compact mode, as negotiated by run-ctl at
+// /config: handlers encoding or decoding their payloads with an Encoder or a
+// Decoder should then set them in compact mode (see Encoder.SetCompact).
+//
+// Data links are compact when their producer and all their consumers
+// support it.
+func (ctx Context) Compact(ep string) bool {
+	if ctx.compact == nil {
+		return false
+	}
+	return ctx.compact.has(ep)
+}
+
+// compactcache holds the end-points of a process with compact data links.
+type compactcache struct {
+	mu  sync.RWMutex
+	eps map[string]struct{}
+}
+
+func (c *compactcache) update(eps []string) {
+	set := make(map[string]struct{}, len(eps))
+	for _, ep := range eps {
+		set[ep] = struct{}{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.eps = set
+}
+
+func (c *compactcache) has(ep string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.eps[ep]
+	return ok
+}
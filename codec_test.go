@@ -0,0 +1,213 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestCodec(t *testing.T) {
+	ts := make([]byte, 8*100)
+	for i := 0; i < 100; i++ {
+		// monotonic timestamps, with a constant period and a gap.
+		v := int64(1e9 + 25*i)
+		if i >= 50 {
+			v += 1000
+		}
+		PutI64(binary.BigEndian, ts[8*i:], v)
+	}
+
+	for _, tc := range []struct {
+		enc  string
+		body []byte
+		max  int // maximum size of the encoded payload
+	}{
+		{enc: EncodingDelta, body: ts, max: 8 + 2*99},
+		{enc: EncodingRLE, body: make([]byte, 8*1000), max: 3},
+		{enc: EncodingDeltaRLE, body: ts, max: 16},
+		{enc: EncodingDeltaRLE, body: nil, max: 0},
+		{enc: EncodingDelta, body: []byte{1, 2, 3, 4, 5, 6, 7, 8}, max: 9},
+	} {
+		t.Run(tc.enc, func(t *testing.T) {
+			c, err := newCodec(tc.enc, binary.BigEndian, 1<<20)
+			if err != nil {
+				t.Fatalf("could not create codec: %+v", err)
+			}
+			raw, err := c.output(tc.body)
+			if err != nil {
+				t.Fatalf("could not encode payload: %+v", err)
+			}
+			if len(raw) > tc.max {
+				t.Fatalf("invalid encoded size: got=%d, want<=%d", len(raw), tc.max)
+			}
+			got, err := c.decode(raw)
+			if err != nil {
+				t.Fatalf("could not decode payload: %+v", err)
+			}
+			if !bytes.Equal(got, tc.body) {
+				t.Fatalf("invalid round-trip:\ngot = %v\nwant= %v", got, tc.body)
+			}
+		})
+	}
+
+	c, err := newCodec(EncodingRLE, binary.LittleEndian, 64)
+	if err != nil {
+		t.Fatalf("could not create codec: %+v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		f    func() error
+	}{
+		{"size", func() error { _, err := c.output([]byte{1, 2, 3}); return err }},
+		{"max-output", func() error { _, err := c.output(make([]byte, 72)); return err }},
+		{"max-input", func() error { _, err := c.decode([]byte{100, 0}); return err }},
+		{"run", func() error { _, err := c.decode([]byte{0, 0}); return err }},
+		{"value", func() error { _, err := c.decode([]byte{1}); return err }},
+	} {
+		if err := tc.f(); err == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+
+	_, err = newCodec("zip", binary.LittleEndian, 0)
+	if err == nil {
+		t.Fatalf("expected an error creating an unknown codec")
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	rc := &RunControl{
+		msg: log.NewMsgStream("run-ctl", log.LvlError+1, ioutil.Discard),
+		clients: newClientDB(
+			&client{
+				name: "gen",
+				oeps: []EndPoint{{Name: "/ts"}, {Name: "/cnt"}, {Name: "/adc"}},
+				encs: map[string]string{"/ts": EncodingDelta, "/cnt": EncodingRLE},
+			},
+			&client{name: "evb", ieps: []EndPoint{{Name: "/ts"}, {Name: "/cnt"}}, codecs: payloadCodecs()},
+			&client{name: "mon", ieps: []EndPoint{{Name: "/cnt"}, {Name: "/adc"}}},
+		),
+	}
+
+	encs := rc.encodings()
+	if got, want := encs, map[string]string{"/ts": EncodingDelta}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid negotiated encodings:\ngot = %v\nwant= %v", got, want)
+	}
+	for _, tc := range []struct {
+		name string
+		want map[string]string
+	}{
+		{"gen", map[string]string{"/ts": EncodingDelta}},
+		{"evb", map[string]string{"/ts": EncodingDelta}},
+		{"mon", nil},
+	} {
+		if got := epEncodings(rc.clients.get(tc.name), encs); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("invalid encodings of %q:\ngot = %v\nwant= %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCompactLinks(t *testing.T) {
+	rc := &RunControl{
+		clients: newClientDB(
+			&client{name: "gen", proto: ProtoV4, oeps: []EndPoint{{Name: "/ts"}, {Name: "/adc"}}},
+			&client{name: "old", proto: ProtoV3, oeps: []EndPoint{{Name: "/cnt"}}},
+			&client{name: "evb", proto: ProtoV4, ieps: []EndPoint{{Name: "/ts"}, {Name: "/adc"}, {Name: "/cnt"}}},
+			&client{name: "mon", proto: ProtoV2, ieps: []EndPoint{{Name: "/adc"}}},
+		),
+	}
+
+	links := rc.compactLinks()
+	if got, want := links, map[string]struct{}{"/ts": {}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid compact links:\ngot = %v\nwant= %v", got, want)
+	}
+	for _, tc := range []struct {
+		name string
+		want []string
+	}{
+		{"gen", []string{"/ts"}},
+		{"old", nil},
+		{"evb", []string{"/ts"}},
+		{"mon", nil},
+	} {
+		if got := epCompact(rc.clients.get(tc.name), links); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("invalid compact links of %q:\ngot = %v\nwant= %v", tc.name, got, tc.want)
+		}
+	}
+
+	var c compactcache
+	c.update(epCompact(rc.clients.get("evb"), links))
+	ctx := Context{compact: &c}
+	if !ctx.Compact("/ts") || ctx.Compact("/adc") || (Context{}).Compact("/ts") {
+		t.Fatalf("invalid compact end-points of context")
+	}
+}
+
+type cmdSender struct {
+	msgs [][]byte
+}
+
+func (s *cmdSender) Send(msg []byte) error {
+	s.msgs = append(s.msgs, append([]byte(nil), msg...))
+	return nil
+}
+
+func TestSendCmdCompact(t *testing.T) {
+	want := ConfigCmd{
+		Name: "evb",
+		InEndPoints: []EndPoint{
+			{Name: "/adc", Addr: "tcp://127.0.0.1:40001", Type: "adc"},
+			{Name: "/ts", Addr: "tcp://127.0.0.1:40002", Type: "ts"},
+		},
+		OutEndPoints: []EndPoint{
+			{Name: "/evt", Addr: "tcp://127.0.0.1:40003", Type: "evt"},
+		},
+		KVVersion: 2,
+		KV:        map[string]string{"threshold": "12"},
+		Version:   ConfigVersion,
+		Compact:   []string{"/adc"},
+	}
+
+	var (
+		ctx  = context.Background()
+		sck  cmdSender
+		size = make(map[uint8]int)
+	)
+	for _, proto := range []uint8{ProtoV1, ProtoV2} {
+		err := sendCmdProto(ctx, &sck, &want, proto)
+		if err != nil {
+			t.Fatalf("could not send /config (proto=%d): %+v", proto, err)
+		}
+		msg := sck.msgs[len(sck.msgs)-1]
+		size[proto] = len(msg)
+
+		frame, err := RecvFrame(ctx, rawRecver(msg))
+		if err != nil {
+			t.Fatalf("could not receive /config (proto=%d): %+v", proto, err)
+		}
+		if got, want := frame.Path, "/config"; got != want {
+			t.Fatalf("invalid /config path (proto=%d): got=%q, want=%q", proto, got, want)
+		}
+		got, err := newConfigCmd(frame)
+		if err != nil {
+			t.Fatalf("could not decode /config (proto=%d): %+v", proto, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid /config round-trip (proto=%d):\ngot = %#v\nwant= %#v", proto, got, want)
+		}
+	}
+
+	// 3 bytes are saved on the length of each short string, at least.
+	if got, max := size[ProtoV2], size[ProtoV1]-3*10; got > max {
+		t.Fatalf("invalid compact /config size: got=%d, want<=%d (fixed=%d)", got, max, size[ProtoV1])
+	}
+}
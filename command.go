@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-daq/tdaq/fsm"
 )
@@ -27,8 +28,24 @@ const (
 	CmdStop
 	CmdQuit
 	CmdStatus
+	CmdGo
+	CmdPrepare
+	CmdAbort
+	CmdDebug
+	CmdLeave
+	CmdReconfig
+	CmdCalib
+	CmdSnapshot
+	CmdRestore
+	CmdPause
+	CmdResume
 )
 
+// startArm is the body of the /start commands arming a process for a
+// barrier-synchronized start: armed processes only begin producing data
+// once they receive the /go command.
+const startArm = 1
+
 func (cmd CmdType) String() string {
 	switch cmd {
 	case CmdUnknown:
@@ -49,21 +66,54 @@ func (cmd CmdType) String() string {
 		return "/quit"
 	case CmdStatus:
 		return "/status"
+	case CmdGo:
+		return "/go"
+	case CmdPrepare:
+		return "/prepare"
+	case CmdAbort:
+		return "/abort"
+	case CmdDebug:
+		return "/debug"
+	case CmdLeave:
+		return "/leave"
+	case CmdReconfig:
+		return "/reconfig"
+	case CmdCalib:
+		return "/calib"
+	case CmdSnapshot:
+		return "/snapshot"
+	case CmdRestore:
+		return "/restore"
+	case CmdPause:
+		return "/pause"
+	case CmdResume:
+		return "/resume"
 	default:
 		panic(fmt.Errorf("invalid cmd-type %d", byte(cmd)))
 	}
 }
 
 var cmdNames = [...][]byte{
-	CmdUnknown: []byte(CmdUnknown.String()),
-	CmdJoin:    []byte(CmdJoin.String()),
-	CmdConfig:  []byte(CmdConfig.String()),
-	CmdInit:    []byte(CmdInit.String()),
-	CmdReset:   []byte(CmdReset.String()),
-	CmdStart:   []byte(CmdStart.String()),
-	CmdStop:    []byte(CmdStop.String()),
-	CmdQuit:    []byte(CmdQuit.String()),
-	CmdStatus:  []byte(CmdStatus.String()),
+	CmdUnknown:  []byte(CmdUnknown.String()),
+	CmdJoin:     []byte(CmdJoin.String()),
+	CmdConfig:   []byte(CmdConfig.String()),
+	CmdInit:     []byte(CmdInit.String()),
+	CmdReset:    []byte(CmdReset.String()),
+	CmdStart:    []byte(CmdStart.String()),
+	CmdStop:     []byte(CmdStop.String()),
+	CmdQuit:     []byte(CmdQuit.String()),
+	CmdStatus:   []byte(CmdStatus.String()),
+	CmdGo:       []byte(CmdGo.String()),
+	CmdPrepare:  []byte(CmdPrepare.String()),
+	CmdAbort:    []byte(CmdAbort.String()),
+	CmdDebug:    []byte(CmdDebug.String()),
+	CmdLeave:    []byte(CmdLeave.String()),
+	CmdReconfig: []byte(CmdReconfig.String()),
+	CmdCalib:    []byte(CmdCalib.String()),
+	CmdSnapshot: []byte(CmdSnapshot.String()),
+	CmdRestore:  []byte(CmdRestore.String()),
+	CmdPause:    []byte(CmdPause.String()),
+	CmdResume:   []byte(CmdResume.String()),
 }
 
 func cmdTypeToPath(cmd CmdType) []byte {
@@ -78,37 +128,87 @@ type Cmder interface {
 }
 
 type Cmd struct {
-	Type CmdType
-	Body []byte
+	Type    CmdType
+	Body    []byte
+	Compact bool // whether the body is encoded in compact mode (see Encoder.SetCompact)
 }
 
+// cmdCompact flags, in the command type prefixing the body of a command
+// frame, the bodies encoded in compact mode.
+// Compact bodies are only sent to peers negotiating ProtoV2 or later.
+const cmdCompact CmdType = 0x80
+
 func cmdFrom(frame Frame) (Cmd, error) {
 	if frame.Type != FrameCmd {
 		return Cmd{}, fmt.Errorf("invalid frame type %v", frame.Type)
 	}
 	cmd := Cmd{
-		Type: CmdType(frame.Body[0]),
-		Body: frame.Body[1:],
+		Type:    CmdType(frame.Body[0]) &^ cmdCompact,
+		Body:    frame.Body[1:],
+		Compact: CmdType(frame.Body[0])&cmdCompact != 0,
 	}
 	return cmd, nil
 }
 
 func SendCmd(ctx context.Context, sck Sender, cmd Cmder) error {
+	ctype := cmd.CmdType()
+	if v, ok := cmd.(tdaqEncoder); ok {
+		err := sendEncoded(ctx, sck, FrameCmd, cmdTypeToPath(ctype), cmdTypeBytes[ctype:ctype+1], v)
+		if err != nil {
+			return fmt.Errorf("could not send cmd: %w", err)
+		}
+		return nil
+	}
+
 	raw, err := cmd.MarshalTDAQ()
 	if err != nil {
 		return fmt.Errorf("could not marshal cmd: %w", err)
 	}
+	return sendCmd(ctx, sck, ctype, raw)
+}
+
+// sendCmdProto sends the provided command to a peer negotiating the provided
+// version of the TDAQ wire protocol: from ProtoV2, the body of the command is
+// encoded in compact mode.
+func sendCmdProto(ctx context.Context, sck Sender, cmd Cmder, proto uint8) error {
+	v, ok := cmd.(tdaqEncoder)
+	if !ok || proto < ProtoV2 {
+		return SendCmd(ctx, sck, cmd)
+	}
+
+	eb := getEncBuffer()
+	defer eb.release()
+
+	eb.enc.SetCompact(true)
+	v.encodeTDAQ(&eb.enc)
+	if err := eb.enc.err; err != nil {
+		return fmt.Errorf("could not send cmd: %w", err)
+	}
 
 	ctype := cmd.CmdType()
-	path := cmdTypeToPath(cmd.CmdType())
-	return sendFrame(ctx, sck, FrameCmd, path, append([]byte{byte(ctype)}, raw...))
+	flag := ctype | cmdCompact
+	err := sendFrame(ctx, sck, FrameCmd, cmdTypeToPath(ctype), cmdTypeBytes[flag:flag+1], eb.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("could not send cmd: %w", err)
+	}
+	return nil
 }
 
 func sendCmd(ctx context.Context, sck Sender, ctype CmdType, body []byte) error {
 	path := cmdTypeToPath(ctype)
-	return sendFrame(ctx, sck, FrameCmd, path, append([]byte{byte(ctype)}, body...))
+	return sendFrame(ctx, sck, FrameCmd, path, cmdTypeBytes[ctype:ctype+1], body)
 }
 
+// cmdTypeBytes holds the encoding of all the command types, so the command
+// type prefixing a command body can be sent without allocation.
+var cmdTypeBytes = func() []byte {
+	o := make([]byte, 256)
+	for i := range o {
+		o[i] = byte(i)
+	}
+	return o
+}()
+
 type JoinCmd struct {
 	Name         string // name of the process placing the /join command
 	Ctl          string // address of ctl-REP socket of the process
@@ -116,6 +216,38 @@ type JoinCmd struct {
 	Log          string // address of log-PUB socket of the process
 	InEndPoints  []EndPoint
 	OutEndPoints []EndPoint
+	Proto        uint8  // latest version of the TDAQ wire protocol supported by the process
+	MaxFrameSize uint32 // maximum frame size supported by the process (0: default)
+
+	// Acks holds the addresses of the acknowledgement sockets of the
+	// output end-points delivered in acknowledged mode, indexed by
+	// end-point name.
+	Acks map[string]string
+
+	// Credits holds the addresses of the credit sockets of the output
+	// end-points under flow control, indexed by end-point name.
+	Credits map[string]string
+
+	Barrier  bool     // whether the process supports barrier-synchronized starts (/start then /go)
+	TwoPhase bool     // whether the process supports two-phase transitions (/prepare then commit or /abort)
+	Services string   // address of the services-REP socket of the process (empty if none)
+	Topics   string   // address of the topics-PUB socket of the process (empty if none)
+	Pubs     []string // topics published by the process
+	Subs     []string // topic patterns subscribed to by the process
+
+	Reconfig        bool // whether the process supports /reconfig
+	ReconfigRunning bool // whether the process supports /reconfig while running
+
+	Calibs []string // names of the calibration constants consumed by the process
+
+	// Orders holds the byte orders of the payloads declared by the
+	// end-points of the process, indexed by end-point name.
+	Orders map[string]string
+
+	// Encodings holds the payload encodings declared by the output
+	// end-points of the process, indexed by end-point name.
+	Encodings map[string]string
+	Codecs    []string // payload encodings supported by the input end-points of the process
 }
 
 func newJoinCmd(frame Frame) (JoinCmd, error) {
@@ -140,8 +272,10 @@ func newJoinCmd(frame Frame) (JoinCmd, error) {
 func (cmd JoinCmd) CmdType() CmdType { return CmdJoin }
 
 func (cmd JoinCmd) MarshalTDAQ() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	enc := NewEncoder(buf)
+	return marshalTDAQ(cmd)
+}
+
+func (cmd JoinCmd) encodeTDAQ(enc *Encoder) {
 	enc.WriteStr(cmd.Name)
 	enc.WriteStr(cmd.Ctl)
 	enc.WriteStr(cmd.HBeat)
@@ -160,11 +294,27 @@ func (cmd JoinCmd) MarshalTDAQ() ([]byte, error) {
 		enc.WriteStr(ep.Addr)
 		enc.WriteStr(ep.Type)
 	}
-	return buf.Bytes(), enc.err
+	enc.WriteU8(cmd.Proto)
+	enc.WriteU32(cmd.MaxFrameSize)
+	enc.WriteStrMap(cmd.Acks)
+	enc.WriteStrMap(cmd.Credits)
+	enc.WriteBool(cmd.Barrier)
+	enc.WriteBool(cmd.TwoPhase)
+	enc.WriteStr(cmd.Services)
+	enc.WriteStr(cmd.Topics)
+	enc.WriteStrs(cmd.Pubs)
+	enc.WriteStrs(cmd.Subs)
+	enc.WriteBool(cmd.Reconfig)
+	enc.WriteBool(cmd.ReconfigRunning)
+	enc.WriteStrs(cmd.Calibs)
+	enc.WriteStrMap(cmd.Orders)
+	enc.WriteStrMap(cmd.Encodings)
+	enc.WriteStrs(cmd.Codecs)
 }
 
 func (cmd *JoinCmd) UnmarshalTDAQ(p []byte) error {
-	dec := NewDecoder(bytes.NewReader(p))
+	r := bytes.NewReader(p)
+	dec := NewDecoder(r)
 
 	cmd.Name = dec.ReadStr()
 	cmd.Ctl = dec.ReadStr()
@@ -188,6 +338,64 @@ func (cmd *JoinCmd) UnmarshalTDAQ(p []byte) error {
 		ep.Type = dec.ReadStr()
 	}
 
+	// processes predating protocol negotiation do not send their version.
+	cmd.Proto = ProtoV1
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Proto = dec.ReadU8()
+	}
+	cmd.MaxFrameSize = 0
+	if dec.err == nil && r.Len() > 0 {
+		cmd.MaxFrameSize = dec.ReadU32()
+	}
+	cmd.Acks = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Acks = dec.ReadStrMap()
+	}
+	cmd.Credits = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Credits = dec.ReadStrMap()
+	}
+	cmd.Barrier = false
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Barrier = dec.ReadBool()
+	}
+	cmd.TwoPhase = false
+	if dec.err == nil && r.Len() > 0 {
+		cmd.TwoPhase = dec.ReadBool()
+	}
+	cmd.Services = ""
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Services = dec.ReadStr()
+	}
+	cmd.Topics = ""
+	cmd.Pubs = nil
+	cmd.Subs = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Topics = dec.ReadStr()
+		cmd.Pubs = dec.ReadStrs()
+		cmd.Subs = dec.ReadStrs()
+	}
+	cmd.Reconfig = false
+	cmd.ReconfigRunning = false
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Reconfig = dec.ReadBool()
+		cmd.ReconfigRunning = dec.ReadBool()
+	}
+	cmd.Calibs = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Calibs = dec.ReadStrs()
+	}
+	cmd.Orders = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Orders = dec.ReadStrMap()
+	}
+	cmd.Encodings = nil
+	cmd.Codecs = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Encodings = dec.ReadStrMap()
+		cmd.Codecs = dec.ReadStrs()
+	}
+
 	return dec.err
 }
 
@@ -195,6 +403,47 @@ type ConfigCmd struct {
 	Name         string
 	InEndPoints  []EndPoint
 	OutEndPoints []EndPoint
+	MaxFrameSize uint32 // maximum size of data frames sent on output end-points (0: default)
+
+	// Acks holds the addresses of the acknowledgement sockets of the
+	// producers of the input end-points delivered in acknowledged mode,
+	// indexed by end-point name.
+	Acks map[string]string
+
+	// Credits holds the addresses of the credit sockets of the producers
+	// of the input end-points under flow control, indexed by end-point name.
+	Credits map[string]string
+
+	// Services holds the addresses of the services-REP sockets of the
+	// processes providing services, indexed by process name.
+	Services map[string]string
+
+	// Topics holds the addresses of the topics-PUB sockets of the
+	// processes publishing topics matching the subscriptions of the
+	// process, indexed by process name.
+	Topics map[string]string
+
+	KVVersion uint64            // version of the key-value configuration store of run-ctl
+	KV        map[string]string // values of the key-value configuration store for the process
+
+	// Version is the version of the layout of the command (0: no
+	// extension section).
+	Version uint8
+
+	// Ext holds the typed fields of the extension section of the command,
+	// indexed by name.
+	// Processes ignore the fields they do not know about.
+	Ext map[string]ConfigField
+
+	// Encodings holds the negotiated payload encodings of the input and
+	// output end-points of the process, indexed by end-point name
+	// (version 2 and later).
+	Encodings map[string]string
+
+	// Compact holds the input and output end-points of the process whose
+	// data links carry payloads encoded in compact mode (version 3 and
+	// later, see Context.Compact).
+	Compact []string
 }
 
 func newConfigCmd(frame Frame) (ConfigCmd, error) {
@@ -212,15 +461,17 @@ func newConfigCmd(frame Frame) (ConfigCmd, error) {
 		return cmd, fmt.Errorf("not a /config cmd")
 	}
 
-	err = cmd.UnmarshalTDAQ(raw.Body)
+	err = cmd.unmarshalTDAQ(raw.Body, raw.Compact)
 	return cmd, err
 }
 
 func (cmd ConfigCmd) CmdType() CmdType { return CmdConfig }
 
 func (cmd ConfigCmd) MarshalTDAQ() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	enc := NewEncoder(buf)
+	return marshalTDAQ(cmd)
+}
+
+func (cmd ConfigCmd) encodeTDAQ(enc *Encoder) {
 	enc.WriteStr(cmd.Name)
 
 	enc.WriteI32(int32(len(cmd.InEndPoints)))
@@ -236,11 +487,33 @@ func (cmd ConfigCmd) MarshalTDAQ() ([]byte, error) {
 		enc.WriteStr(ep.Addr)
 		enc.WriteStr(ep.Type)
 	}
-	return buf.Bytes(), enc.err
+	enc.WriteU32(cmd.MaxFrameSize)
+	enc.WriteStrMap(cmd.Acks)
+	enc.WriteStrMap(cmd.Credits)
+	enc.WriteStrMap(cmd.Services)
+	enc.WriteStrMap(cmd.Topics)
+	enc.WriteU64(cmd.KVVersion)
+	enc.WriteStrMap(cmd.KV)
+	if cmd.Version > 0 {
+		enc.WriteU8(cmd.Version)
+		writeConfigFields(enc, cmd.Ext)
+	}
+	if cmd.Version > 1 {
+		enc.WriteStrMap(cmd.Encodings)
+	}
+	if cmd.Version > 2 {
+		enc.WriteStrs(cmd.Compact)
+	}
 }
 
 func (cmd *ConfigCmd) UnmarshalTDAQ(p []byte) error {
-	dec := NewDecoder(bytes.NewReader(p))
+	return cmd.unmarshalTDAQ(p, false)
+}
+
+func (cmd *ConfigCmd) unmarshalTDAQ(p []byte, compact bool) error {
+	r := bytes.NewReader(p)
+	dec := NewDecoder(r)
+	dec.SetCompact(compact)
 
 	cmd.Name = dec.ReadStr()
 	n := int(dec.ReadI32())
@@ -261,12 +534,214 @@ func (cmd *ConfigCmd) UnmarshalTDAQ(p []byte) error {
 		ep.Type = dec.ReadStr()
 	}
 
+	cmd.MaxFrameSize = 0
+	if dec.err == nil && r.Len() > 0 {
+		cmd.MaxFrameSize = dec.ReadU32()
+	}
+	cmd.Acks = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Acks = dec.ReadStrMap()
+	}
+	cmd.Credits = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Credits = dec.ReadStrMap()
+	}
+	cmd.Services = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Services = dec.ReadStrMap()
+	}
+	cmd.Topics = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Topics = dec.ReadStrMap()
+	}
+	cmd.KVVersion = 0
+	cmd.KV = nil
+	if dec.err == nil && r.Len() > 0 {
+		cmd.KVVersion = dec.ReadU64()
+		cmd.KV = dec.ReadStrMap()
+	}
+	cmd.Version = 0
+	cmd.Ext = nil
+	if dec.err == nil && r.Len() > 0 {
+		// fields of later versions of the layout are appended to the
+		// extension section, so it can be decoded whatever the version.
+		cmd.Version = dec.ReadU8()
+		cmd.Ext = readConfigFields(dec)
+	}
+	cmd.Encodings = nil
+	if dec.err == nil && cmd.Version > 1 && r.Len() > 0 {
+		cmd.Encodings = dec.ReadStrMap()
+	}
+	cmd.Compact = nil
+	if dec.err == nil && cmd.Version > 2 && r.Len() > 0 {
+		cmd.Compact = dec.ReadStrs()
+	}
+
+	return dec.err
+}
+
+// ReconfigCmd pushes an updated configuration to a process, without a full
+// /config cycle.
+type ReconfigCmd struct {
+	KVVersion uint64            // version of the key-value configuration store of run-ctl
+	KV        map[string]string // values of the key-value configuration store for the process
+}
+
+func newReconfigCmd(frame Frame) (ReconfigCmd, error) {
+	var (
+		cmd ReconfigCmd
+		err error
+	)
+
+	raw, err := cmdFrom(frame)
+	if err != nil {
+		return cmd, fmt.Errorf("not a /reconfig cmd: %w", err)
+	}
+
+	if raw.Type != CmdReconfig {
+		return cmd, fmt.Errorf("not a /reconfig cmd")
+	}
+
+	err = cmd.unmarshalTDAQ(raw.Body, raw.Compact)
+	return cmd, err
+}
+
+func (cmd ReconfigCmd) CmdType() CmdType { return CmdReconfig }
+
+func (cmd ReconfigCmd) MarshalTDAQ() ([]byte, error) {
+	return marshalTDAQ(cmd)
+}
+
+func (cmd ReconfigCmd) encodeTDAQ(enc *Encoder) {
+	enc.WriteU64(cmd.KVVersion)
+	enc.WriteStrMap(cmd.KV)
+}
+
+func (cmd *ReconfigCmd) UnmarshalTDAQ(p []byte) error {
+	return cmd.unmarshalTDAQ(p, false)
+}
+
+func (cmd *ReconfigCmd) unmarshalTDAQ(p []byte, compact bool) error {
+	dec := NewDecoder(bytes.NewReader(p))
+	dec.SetCompact(compact)
+	cmd.KVVersion = dec.ReadU64()
+	cmd.KV = dec.ReadStrMap()
+	return dec.err
+}
+
+// CalibCmd carries a set of calibration constants: from the process which
+// computed them to run-ctl, and from run-ctl to the processes consuming them.
+type CalibCmd struct {
+	Name    string // name of the set of constants
+	Proc    string // name of the process which computed the constants
+	Version uint64 // version of the constants, assigned by run-ctl (0: not yet assigned)
+	Data    []byte
+}
+
+func newCalibCmd(frame Frame) (CalibCmd, error) {
+	var (
+		cmd CalibCmd
+		err error
+	)
+
+	raw, err := cmdFrom(frame)
+	if err != nil {
+		return cmd, fmt.Errorf("not a /calib cmd: %w", err)
+	}
+
+	if raw.Type != CmdCalib {
+		return cmd, fmt.Errorf("not a /calib cmd")
+	}
+
+	err = cmd.unmarshalTDAQ(raw.Body, raw.Compact)
+	return cmd, err
+}
+
+func (cmd CalibCmd) CmdType() CmdType { return CmdCalib }
+
+func (cmd CalibCmd) MarshalTDAQ() ([]byte, error) {
+	return marshalTDAQ(cmd)
+}
+
+func (cmd CalibCmd) encodeTDAQ(enc *Encoder) {
+	enc.WriteStr(cmd.Name)
+	enc.WriteStr(cmd.Proc)
+	enc.WriteU64(cmd.Version)
+	enc.WriteBytes(cmd.Data)
+}
+
+func (cmd *CalibCmd) UnmarshalTDAQ(p []byte) error {
+	return cmd.unmarshalTDAQ(p, false)
+}
+
+func (cmd *CalibCmd) unmarshalTDAQ(p []byte, compact bool) error {
+	dec := NewDecoder(bytes.NewReader(p))
+	dec.SetCompact(compact)
+	cmd.Name = dec.ReadStr()
+	cmd.Proc = dec.ReadStr()
+	cmd.Version = dec.ReadU64()
+	cmd.Data = dec.ReadBytes()
+	return dec.err
+}
+
+// RestoreCmd carries the state of a device, saved by a snapshot of the
+// partition, to be restored by its process.
+type RestoreCmd struct {
+	State []byte
+}
+
+func newRestoreCmd(frame Frame) (RestoreCmd, error) {
+	var (
+		cmd RestoreCmd
+		err error
+	)
+
+	raw, err := cmdFrom(frame)
+	if err != nil {
+		return cmd, fmt.Errorf("not a /restore cmd: %w", err)
+	}
+
+	if raw.Type != CmdRestore {
+		return cmd, fmt.Errorf("not a /restore cmd")
+	}
+
+	err = cmd.unmarshalTDAQ(raw.Body, raw.Compact)
+	return cmd, err
+}
+
+func (cmd RestoreCmd) CmdType() CmdType { return CmdRestore }
+
+func (cmd RestoreCmd) MarshalTDAQ() ([]byte, error) {
+	return marshalTDAQ(cmd)
+}
+
+func (cmd RestoreCmd) encodeTDAQ(enc *Encoder) {
+	enc.WriteBytes(cmd.State)
+}
+
+func (cmd *RestoreCmd) UnmarshalTDAQ(p []byte) error {
+	return cmd.unmarshalTDAQ(p, false)
+}
+
+func (cmd *RestoreCmd) unmarshalTDAQ(p []byte, compact bool) error {
+	dec := NewDecoder(bytes.NewReader(p))
+	dec.SetCompact(compact)
+	cmd.State = dec.ReadBytes()
 	return dec.err
 }
 
 type StatusCmd struct {
 	Name   string
 	Status fsm.Status
+	Links  []LinkStatus // status of the incoming data links of the process
+
+	// clock exchange between run-ctl and the process, over the heartbeat link.
+	Sent  time.Time   // time at which the command was sent, by the clock of its sender (zero if none)
+	Recv  time.Time   // time at which the request was received, by the clock of the process (replies only)
+	Clock ClockOffset // estimated clock offset of the process (requests only)
+
+	Stats   RunStats     // counters of the process since the start of the run (replies only)
+	Runtime RuntimeStats // statistics of the Go runtime of the process (replies only)
 }
 
 func newStatusCmd(frame Frame) (StatusCmd, error) {
@@ -284,24 +759,176 @@ func newStatusCmd(frame Frame) (StatusCmd, error) {
 		return cmd, fmt.Errorf("not a /status cmd")
 	}
 
-	err = cmd.UnmarshalTDAQ(raw.Body)
+	err = cmd.unmarshalTDAQ(raw.Body, raw.Compact)
 	return cmd, err
 }
 
 func (cmd StatusCmd) CmdType() CmdType { return CmdStatus }
 
 func (cmd StatusCmd) MarshalTDAQ() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	enc := NewEncoder(buf)
+	return marshalTDAQ(cmd)
+}
+
+func (cmd StatusCmd) encodeTDAQ(enc *Encoder) {
 	enc.WriteStr(cmd.Name)
 	enc.WriteI8(int8(cmd.Status))
-	return buf.Bytes(), enc.err
+	enc.WriteI32(int32(len(cmd.Links)))
+	for _, link := range cmd.Links {
+		enc.WriteStr(link.Addr)
+		enc.WriteStrs(link.EndPoints)
+		enc.WriteBool(link.Up)
+		enc.WriteU32(link.Outages)
+		enc.WriteTime(link.Since)
+		enc.WriteU64(link.Frames)
+		enc.WriteU64(link.Bytes)
+		enc.WriteTime(link.LastFrame)
+		enc.WriteU32(link.Queued)
+		enc.WriteU32(link.Capacity)
+		enc.WriteBool(link.Stalled)
+	}
+	enc.WriteTime(cmd.Sent)
+	enc.WriteTime(cmd.Recv)
+	enc.WriteI64(int64(cmd.Clock.Offset))
+	enc.WriteI64(int64(cmd.Clock.Delay))
+	enc.WriteF64(cmd.Clock.Skew)
+	enc.WriteTime(cmd.Clock.Time)
+	for _, eps := range [][]EndPointStats{cmd.Stats.Outputs, cmd.Stats.Inputs} {
+		enc.WriteI32(int32(len(eps)))
+		for _, ep := range eps {
+			enc.WriteStr(ep.Name)
+			enc.WriteU64(ep.Frames)
+			enc.WriteU64(ep.Bytes)
+		}
+	}
+	enc.WriteU64(cmd.Stats.Dropped)
+	enc.WriteU64(cmd.Stats.Errors)
+	for _, ep := range cmd.Stats.Inputs {
+		lat := ep.Latency
+		enc.WriteBool(lat != nil)
+		if lat == nil {
+			continue
+		}
+		enc.WriteU64(lat.Count)
+		enc.WriteI64(int64(lat.Sum))
+		enc.WriteI64(int64(lat.Max))
+		enc.WriteU64(lat.Skewed)
+		enc.WriteI32(int32(len(lat.Bins)))
+		for _, n := range lat.Bins {
+			enc.WriteU64(n)
+		}
+	}
+	enc.WriteI64(int64(cmd.Stats.DeadTime))
+	enc.WriteI64(int64(cmd.Runtime.Goroutines))
+	enc.WriteU64(cmd.Runtime.HeapInuse)
+	enc.WriteU32(cmd.Runtime.NumGC)
+	enc.WriteI64(int64(cmd.Runtime.PauseTotal))
+	for _, ep := range cmd.Stats.Inputs {
+		enc.WriteU64(ep.Dups)
+	}
 }
 
 func (cmd *StatusCmd) UnmarshalTDAQ(p []byte) error {
-	dec := NewDecoder(bytes.NewReader(p))
+	return cmd.unmarshalTDAQ(p, false)
+}
+
+func (cmd *StatusCmd) unmarshalTDAQ(p []byte, compact bool) error {
+	r := bytes.NewReader(p)
+	dec := NewDecoder(r)
+	dec.SetCompact(compact)
 	cmd.Name = dec.ReadStr()
 	cmd.Status = fsm.Status(dec.ReadI8())
+
+	cmd.Links = nil
+	if dec.err == nil && r.Len() > 0 {
+		n := int(dec.ReadI32())
+		if n > 0 {
+			cmd.Links = make([]LinkStatus, n)
+		}
+		for i := range cmd.Links {
+			link := &cmd.Links[i]
+			link.Addr = dec.ReadStr()
+			link.EndPoints = dec.ReadStrs()
+			link.Up = dec.ReadBool()
+			link.Outages = dec.ReadU32()
+			link.Since = dec.ReadTime()
+			link.Frames = dec.ReadU64()
+			link.Bytes = dec.ReadU64()
+			link.LastFrame = dec.ReadTime()
+			link.Queued = dec.ReadU32()
+			link.Capacity = dec.ReadU32()
+			link.Stalled = dec.ReadBool()
+		}
+	}
+
+	cmd.Sent = time.Time{}
+	cmd.Recv = time.Time{}
+	cmd.Clock = ClockOffset{}
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Sent = dec.ReadTime()
+		cmd.Recv = dec.ReadTime()
+		cmd.Clock.Offset = time.Duration(dec.ReadI64())
+		cmd.Clock.Delay = time.Duration(dec.ReadI64())
+		cmd.Clock.Skew = dec.ReadF64()
+		cmd.Clock.Time = dec.ReadTime()
+	}
+
+	cmd.Stats = RunStats{}
+	if dec.err == nil && r.Len() > 0 {
+		for _, eps := range []*[]EndPointStats{&cmd.Stats.Outputs, &cmd.Stats.Inputs} {
+			n := int(dec.ReadI32())
+			if n > 0 {
+				*eps = make([]EndPointStats, n)
+			}
+			for i := range *eps {
+				ep := &(*eps)[i]
+				ep.Name = dec.ReadStr()
+				ep.Frames = dec.ReadU64()
+				ep.Bytes = dec.ReadU64()
+			}
+		}
+		cmd.Stats.Dropped = dec.ReadU64()
+		cmd.Stats.Errors = dec.ReadU64()
+	}
+
+	if dec.err == nil && r.Len() > 0 {
+		for i := range cmd.Stats.Inputs {
+			if !dec.ReadBool() {
+				continue
+			}
+			lat := &Latency{
+				Count:  dec.ReadU64(),
+				Sum:    time.Duration(dec.ReadI64()),
+				Max:    time.Duration(dec.ReadI64()),
+				Skewed: dec.ReadU64(),
+			}
+			if n := int(dec.ReadI32()); n > 0 {
+				lat.Bins = make([]uint64, n)
+			}
+			for j := range lat.Bins {
+				lat.Bins[j] = dec.ReadU64()
+			}
+			cmd.Stats.Inputs[i].Latency = lat
+		}
+	}
+
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Stats.DeadTime = time.Duration(dec.ReadI64())
+	}
+
+	cmd.Runtime = RuntimeStats{}
+	if dec.err == nil && r.Len() > 0 {
+		cmd.Runtime.Goroutines = int(dec.ReadI64())
+		cmd.Runtime.HeapInuse = dec.ReadU64()
+		cmd.Runtime.NumGC = dec.ReadU32()
+		cmd.Runtime.PauseTotal = time.Duration(dec.ReadI64())
+	}
+
+	if dec.err == nil && r.Len() > 0 {
+		for i := range cmd.Stats.Inputs {
+			cmd.Stats.Inputs[i].Dups = dec.ReadU64()
+		}
+	}
+
 	return dec.err
 }
 
@@ -314,6 +941,18 @@ var (
 	_ Marshaler   = (*ConfigCmd)(nil)
 	_ Unmarshaler = (*ConfigCmd)(nil)
 
+	_ Cmder       = (*ReconfigCmd)(nil)
+	_ Marshaler   = (*ReconfigCmd)(nil)
+	_ Unmarshaler = (*ReconfigCmd)(nil)
+
+	_ Cmder       = (*CalibCmd)(nil)
+	_ Marshaler   = (*CalibCmd)(nil)
+	_ Unmarshaler = (*CalibCmd)(nil)
+
+	_ Cmder       = (*RestoreCmd)(nil)
+	_ Marshaler   = (*RestoreCmd)(nil)
+	_ Unmarshaler = (*RestoreCmd)(nil)
+
 	_ Cmder       = (*StatusCmd)(nil)
 	_ Marshaler   = (*StatusCmd)(nil)
 	_ Unmarshaler = (*StatusCmd)(nil)
@@ -5,13 +5,15 @@
 package tdaq_test // import "github.com/go-daq/tdaq"
 
 import (
+	"bytes"
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/fsm"
-	"github.com/go-daq/tdaq/internal/iomux"
+	"github.com/go-daq/tdaq/iomux"
 )
 
 func TestCommands(t *testing.T) {
@@ -34,6 +36,88 @@ func TestCommands(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "join-proto",
+			want: &tdaq.JoinCmd{
+				Name:  "n1",
+				Ctl:   "ctl",
+				HBeat: "hbeat",
+				Log:   "log",
+				InEndPoints: []tdaq.EndPoint{
+					{"n11", "addr11", "type11"},
+				},
+				OutEndPoints: []tdaq.EndPoint{},
+				Proto:        tdaq.ProtoVersion,
+				MaxFrameSize: 1 << 20,
+			},
+		},
+		{
+			name: "join-acks",
+			want: &tdaq.JoinCmd{
+				Name:        "n1",
+				InEndPoints: []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{
+					{"n11", "addr11", "type11"},
+				},
+				Proto:        tdaq.ProtoVersion,
+				MaxFrameSize: 1 << 20,
+				Acks:         map[string]string{"n11": "ack11"},
+			},
+		},
+		{
+			name: "join-barrier",
+			want: &tdaq.JoinCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Proto:        tdaq.ProtoVersion,
+				Barrier:      true,
+			},
+		},
+		{
+			name: "join-two-phase",
+			want: &tdaq.JoinCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Proto:        tdaq.ProtoVersion,
+				Barrier:      true,
+				TwoPhase:     true,
+			},
+		},
+		{
+			name: "join-services",
+			want: &tdaq.JoinCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Proto:        tdaq.ProtoVersion,
+				Services:     "tcp://127.0.0.1:4321",
+			},
+		},
+		{
+			name: "join-topics",
+			want: &tdaq.JoinCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Proto:        tdaq.ProtoVersion,
+				Topics:       "tcp://127.0.0.1:4322",
+				Pubs:         []string{"/tracker/layer1"},
+				Subs:         []string{"/calo/#", "/tracker/+/hits"},
+			},
+		},
+		{
+			name: "join-reconfig",
+			want: &tdaq.JoinCmd{
+				Name:            "n1",
+				InEndPoints:     []tdaq.EndPoint{},
+				OutEndPoints:    []tdaq.EndPoint{},
+				Proto:           tdaq.ProtoVersion,
+				Reconfig:        true,
+				ReconfigRunning: true,
+			},
+		},
 		{
 			name: "config",
 			want: &tdaq.ConfigCmd{
@@ -49,6 +133,137 @@ func TestCommands(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "config-acks",
+			want: &tdaq.ConfigCmd{
+				Name: "n1",
+				InEndPoints: []tdaq.EndPoint{
+					{"n11", "addr11", "type11"},
+				},
+				OutEndPoints: []tdaq.EndPoint{},
+				MaxFrameSize: 1 << 20,
+				Acks:         map[string]string{"n11": "ack11"},
+				Credits:      map[string]string{"n11": "credit11"},
+			},
+		},
+		{
+			name: "config-services",
+			want: &tdaq.ConfigCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Services:     map[string]string{"calib": "tcp://127.0.0.1:4321"},
+			},
+		},
+		{
+			name: "config-topics",
+			want: &tdaq.ConfigCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Topics:       map[string]string{"tracker": "tcp://127.0.0.1:4322"},
+			},
+		},
+		{
+			name: "config-kv",
+			want: &tdaq.ConfigCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				KVVersion:    42,
+				KV:           map[string]string{"threshold": "12"},
+			},
+		},
+		{
+			name: "config-ext",
+			want: &tdaq.ConfigCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				KVVersion:    42,
+				KV:           map[string]string{"threshold": "12"},
+				Version:      tdaq.ConfigVersion,
+				Ext: map[string]tdaq.ConfigField{
+					"gain":    tdaq.F64Field(1.5),
+					"mode":    tdaq.StrField("calib"),
+					"samples": tdaq.I64Field(-42),
+					"zs":      tdaq.BoolField(true),
+				},
+			},
+		},
+		{
+			name: "join-calibs",
+			want: &tdaq.JoinCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Proto:        tdaq.ProtoVersion,
+				Calibs:       []string{"calo/gains", "tracker/t0"},
+			},
+		},
+		{
+			name: "join-orders",
+			want: &tdaq.JoinCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Proto:        tdaq.ProtoVersion,
+				Orders:       map[string]string{"/adc": "BigEndian"},
+			},
+		},
+		{
+			name: "join-encodings",
+			want: &tdaq.JoinCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Proto:        tdaq.ProtoVersion,
+				Encodings:    map[string]string{"/ts": tdaq.EncodingDelta},
+				Codecs:       []string{tdaq.EncodingDelta, tdaq.EncodingRLE},
+			},
+		},
+		{
+			name: "config-encodings",
+			want: &tdaq.ConfigCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Version:      tdaq.ConfigVersion,
+				Encodings:    map[string]string{"/ts": tdaq.EncodingDeltaRLE},
+			},
+		},
+		{
+			name: "config-compact",
+			want: &tdaq.ConfigCmd{
+				Name:         "n1",
+				InEndPoints:  []tdaq.EndPoint{},
+				OutEndPoints: []tdaq.EndPoint{},
+				Version:      tdaq.ConfigVersion,
+				Compact:      []string{"/adc", "/ts"},
+			},
+		},
+		{
+			name: "calib",
+			want: &tdaq.CalibCmd{
+				Name:    "calo/gains",
+				Proc:    "calo-calib",
+				Version: 3,
+				Data:    []byte{1, 2, 3},
+			},
+		},
+		{
+			name: "restore",
+			want: &tdaq.RestoreCmd{
+				State: []byte("evts=42"),
+			},
+		},
+		{
+			name: "reconfig",
+			want: &tdaq.ReconfigCmd{
+				KVVersion: 43,
+				KV:        map[string]string{"threshold": "13"},
+			},
+		},
 		{
 			name: "status-unconf",
 			want: &tdaq.StatusCmd{Name: "n1", Status: fsm.UnConf},
@@ -77,6 +292,82 @@ func TestCommands(t *testing.T) {
 			name: "status-error",
 			want: &tdaq.StatusCmd{Name: "n1", Status: fsm.Error},
 		},
+		{
+			name: "status-links",
+			want: &tdaq.StatusCmd{
+				Name:   "n1",
+				Status: fsm.Running,
+				Links: []tdaq.LinkStatus{
+					{
+						Addr:      "tcp://127.0.0.1:4000",
+						EndPoints: []string{"/adc", "/tdc"},
+						Up:        true,
+						Since:     time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+						Frames:    42,
+						Bytes:     1024,
+						LastFrame: time.Date(2020, 1, 2, 3, 5, 5, 6, time.UTC),
+						Queued:    3,
+						Capacity:  64,
+					},
+					{
+						Addr:      "tcp://127.0.0.1:4001",
+						EndPoints: []string{"/trig"},
+						Outages:   2,
+						Since:     time.Date(2020, 1, 2, 3, 4, 5, 7, time.UTC),
+						Stalled:   true,
+					},
+				},
+			},
+		},
+		{
+			name: "status-clock",
+			want: &tdaq.StatusCmd{
+				Name:   "n1",
+				Status: fsm.Running,
+				Sent:   time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+				Recv:   time.Date(2020, 1, 2, 3, 4, 5, 3, time.UTC),
+				Clock: tdaq.ClockOffset{
+					Offset: -2 * time.Millisecond,
+					Delay:  150 * time.Microsecond,
+					Skew:   1.5,
+					Time:   time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			name: "status-stats",
+			want: &tdaq.StatusCmd{
+				Name:   "n1",
+				Status: fsm.Stopped,
+				Stats: tdaq.RunStats{
+					Outputs: []tdaq.EndPointStats{
+						{Name: "/adc", Frames: 42, Bytes: 336},
+					},
+					Inputs: []tdaq.EndPointStats{
+						{Name: "/left", Frames: 40, Bytes: 320, Dups: 2},
+						{
+							Name: "/right", Frames: 41, Bytes: 328,
+							Latency: &tdaq.Latency{
+								Count:  41,
+								Sum:    41 * time.Millisecond,
+								Max:    3 * time.Millisecond,
+								Skewed: 1,
+								Bins:   []uint64{0, 0, 0, 0, 1, 10, 20, 8, 2},
+							},
+						},
+					},
+					Dropped:  2,
+					Errors:   1,
+					DeadTime: 1500 * time.Millisecond,
+				},
+				Runtime: tdaq.RuntimeStats{
+					Goroutines: 42,
+					HeapInuse:  16 << 20,
+					NumGC:      3,
+					PauseTotal: 250 * time.Microsecond,
+				},
+			},
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			{
@@ -127,6 +418,58 @@ func TestCommands(t *testing.T) {
 	}
 }
 
+func TestJoinCmdNoProto(t *testing.T) {
+	join := tdaq.JoinCmd{
+		Name:         "n1",
+		InEndPoints:  []tdaq.EndPoint{},
+		OutEndPoints: []tdaq.EndPoint{},
+		Proto:        tdaq.ProtoVersion,
+	}
+	raw, err := join.MarshalTDAQ()
+	if err != nil {
+		t.Fatalf("could not marshal /join cmd: %+v", err)
+	}
+
+	// drop trailing protocol version, maximum frame size, (empty)
+	// ack and credit sockets, barrier and two-phase support, (empty)
+	// services and topics sockets, (empty) topics and subscriptions and
+	// /reconfig support, (empty) calibration constants, (empty) byte
+	// orders and (empty) payload encodings, as sent by older processes.
+	raw = raw[:len(raw)-1-4-4-4-1-1-4-4-4-4-1-1-4-4-4-4]
+
+	var got tdaq.JoinCmd
+	err = got.UnmarshalTDAQ(raw)
+	if err != nil {
+		t.Fatalf("could not unmarshal /join cmd: %+v", err)
+	}
+
+	if got, want := got.Proto, tdaq.ProtoV1; got != want {
+		t.Fatalf("invalid protocol version: got=%d, want=%d", got, want)
+	}
+	if got, want := got.MaxFrameSize, uint32(0); got != want {
+		t.Fatalf("invalid maximum frame size: got=%d, want=%d", got, want)
+	}
+}
+
+func TestStatusCmdNoLinks(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := tdaq.NewEncoder(buf)
+	enc.WriteStr("n1")
+	enc.WriteI8(int8(fsm.Running))
+
+	// status replies of older processes do not carry links.
+	var got tdaq.StatusCmd
+	err := got.UnmarshalTDAQ(buf.Bytes())
+	if err != nil {
+		t.Fatalf("could not unmarshal /status cmd: %+v", err)
+	}
+
+	want := tdaq.StatusCmd{Name: "n1", Status: fsm.Running}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid /status cmd:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+}
+
 func TestCmdType(t *testing.T) {
 	for _, tt := range []struct {
 		cmd    tdaq.CmdType
@@ -142,6 +485,14 @@ func TestCmdType(t *testing.T) {
 		{cmd: tdaq.CmdStop, want: "/stop"},
 		{cmd: tdaq.CmdQuit, want: "/quit"},
 		{cmd: tdaq.CmdStatus, want: "/status"},
+		{cmd: tdaq.CmdDebug, want: "/debug"},
+		{cmd: tdaq.CmdLeave, want: "/leave"},
+		{cmd: tdaq.CmdReconfig, want: "/reconfig"},
+		{cmd: tdaq.CmdCalib, want: "/calib"},
+		{cmd: tdaq.CmdSnapshot, want: "/snapshot"},
+		{cmd: tdaq.CmdRestore, want: "/restore"},
+		{cmd: tdaq.CmdPause, want: "/pause"},
+		{cmd: tdaq.CmdResume, want: "/resume"},
 		{cmd: tdaq.CmdType(255), panics: true},
 	} {
 		t.Run("", func(t *testing.T) {
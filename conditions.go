@@ -0,0 +1,29 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"github.com/go-daq/tdaq/conditions"
+)
+
+// UseConditions sets the conditions database of the process, available to
+// the command handlers (e.g. at /config) with Context.Conditions.
+func (srv *Server) UseConditions(db conditions.DB) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.conds = db
+}
+
+func (srv *Server) getConditions() conditions.DB {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.conds
+}
+
+// Conditions returns the conditions database of the process, or nil if
+// none was configured.
+func (ctx Context) Conditions() conditions.DB {
+	return ctx.conds
+}
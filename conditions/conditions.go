@@ -0,0 +1,155 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package conditions provides clients of conditions databases, holding the
+// run- and time-validity tagged conditions (pedestals, alignment, ...) of
+// the detectors read out by TDAQ devices.
+//
+// Two reference implementations are provided:
+//   - Client, fetching conditions from an HTTP server (see Handler),
+//   - SQL, fetching conditions from an SQL database (e.g. SQLite).
+package conditions // import "github.com/go-daq/tdaq/conditions"
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when no condition is valid for a query.
+var ErrNotFound = errors.New("conditions: no valid condition")
+
+// IOV is an interval of validity, in runs and in time.
+// Runs are valid in [RunBeg, RunEnd) and times in [Beg, End).
+// Zero bounds are open.
+type IOV struct {
+	RunBeg uint64    `json:"run-beg,omitempty"`
+	RunEnd uint64    `json:"run-end,omitempty"`
+	Beg    time.Time `json:"beg,omitempty"`
+	End    time.Time `json:"end,omitempty"`
+}
+
+// Contains returns whether the provided run and time are within the
+// interval of validity.
+// A zero run or time is always within the interval.
+func (iov IOV) Contains(run uint64, t time.Time) bool {
+	if run != 0 {
+		if iov.RunBeg != 0 && run < iov.RunBeg {
+			return false
+		}
+		if iov.RunEnd != 0 && run >= iov.RunEnd {
+			return false
+		}
+	}
+	if !t.IsZero() {
+		if !iov.Beg.IsZero() && t.Before(iov.Beg) {
+			return false
+		}
+		if !iov.End.IsZero() && !t.Before(iov.End) {
+			return false
+		}
+	}
+	return true
+}
+
+// Condition is a payload of conditions, valid over an interval of validity.
+type Condition struct {
+	Tag     string `json:"tag"`     // tag of the conditions (e.g. "calo/pedestals")
+	IOV     IOV    `json:"iov"`     // interval of validity of the payload
+	Version uint64 `json:"version"` // version of the payload, the most recent one prevails
+	Payload []byte `json:"payload"`
+}
+
+// Query selects the conditions with a tag, valid for a run and a time.
+// A zero run or time does not constrain the selection.
+type Query struct {
+	Tag  string
+	Run  uint64
+	Time time.Time
+}
+
+// DB is a conditions database.
+type DB interface {
+	// Fetch returns the most recent version of the conditions selected by
+	// the query, or ErrNotFound.
+	Fetch(ctx context.Context, q Query) (Condition, error)
+}
+
+// Open returns the conditions database described by the provided URL.
+//
+// Supported URLs are:
+//
+//	https://conditions.example.org/api/conditions   (Client)
+//	sqlite3:///path/to/conditions.db                (SQL, with the "sqlite3" database/sql driver)
+//	sqlite:///path/to/conditions.db                 (SQL, with the "sqlite" database/sql driver)
+//
+// SQL drivers are not provided by this package: they have to be registered
+// by the application, e.g. with a blank import.
+func Open(addr string) (DB, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("conditions: could not parse URL %q: %w", addr, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &Client{URL: addr}, nil
+	case "sqlite", "sqlite3":
+		db, err := sql.Open(u.Scheme, strings.TrimPrefix(addr, u.Scheme+"://"))
+		if err != nil {
+			return nil, fmt.Errorf("conditions: could not open %q: %w", addr, err)
+		}
+		return &SQL{DB: db}, nil
+	default:
+		return nil, fmt.Errorf("conditions: invalid URL scheme %q", u.Scheme)
+	}
+}
+
+// Mem is an in-memory conditions database.
+type Mem struct {
+	mu    sync.RWMutex
+	conds map[string][]Condition // conditions by tag, by increasing version
+}
+
+// Add adds the provided condition to the database.
+func (db *Mem) Add(c Condition) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.conds == nil {
+		db.conds = make(map[string][]Condition)
+	}
+	conds := append(db.conds[c.Tag], c)
+	sort.SliceStable(conds, func(i, j int) bool {
+		return conds[i].Version < conds[j].Version
+	})
+	db.conds[c.Tag] = conds
+}
+
+// Fetch returns the most recent version of the conditions selected by the
+// query, or ErrNotFound.
+func (db *Mem) Fetch(ctx context.Context, q Query) (Condition, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	conds := db.conds[q.Tag]
+	for i := len(conds) - 1; i >= 0; i-- {
+		if conds[i].IOV.Contains(q.Run, q.Time) {
+			return conds[i], nil
+		}
+	}
+	return Condition{}, fmt.Errorf("%w (tag=%q, run=%d, time=%v)", ErrNotFound, q.Tag, q.Run, q.Time)
+}
+
+var (
+	_ DB = (*Mem)(nil)
+	_ DB = (*Client)(nil)
+	_ DB = (*SQL)(nil)
+)
@@ -0,0 +1,111 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conditions // import "github.com/go-daq/tdaq/conditions"
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIOV(t *testing.T) {
+	var (
+		t0  = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		t1  = t0.Add(time.Hour)
+		iov = IOV{RunBeg: 10, RunEnd: 20, Beg: t0, End: t1}
+	)
+	for _, tc := range []struct {
+		run  uint64
+		t    time.Time
+		want bool
+	}{
+		{run: 0, want: true},
+		{run: 9, want: false},
+		{run: 10, want: true},
+		{run: 19, want: true},
+		{run: 20, want: false},
+		{t: t0.Add(-time.Second), want: false},
+		{t: t0, want: true},
+		{t: t1, want: false},
+		{run: 15, t: t0.Add(time.Minute), want: true},
+		{run: 25, t: t0.Add(time.Minute), want: false},
+	} {
+		if got := iov.Contains(tc.run, tc.t); got != tc.want {
+			t.Errorf("invalid contains(run=%d, t=%v): got=%v, want=%v", tc.run, tc.t, got, tc.want)
+		}
+	}
+}
+
+func newTestDB() *Mem {
+	db := new(Mem)
+	db.Add(Condition{Tag: "calo/pedestals", Version: 1, Payload: []byte("v1")})
+	db.Add(Condition{Tag: "calo/pedestals", Version: 3, IOV: IOV{RunBeg: 100}, Payload: []byte("v3")})
+	db.Add(Condition{Tag: "calo/pedestals", Version: 2, IOV: IOV{RunEnd: 50}, Payload: []byte("v2")})
+	return db
+}
+
+func testFetch(t *testing.T, db DB) {
+	t.Helper()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		q    Query
+		want string
+		err  error
+	}{
+		{q: Query{Tag: "calo/pedestals", Run: 10}, want: "v2"},
+		{q: Query{Tag: "calo/pedestals", Run: 60}, want: "v1"},
+		{q: Query{Tag: "calo/pedestals", Run: 100}, want: "v3"},
+		{q: Query{Tag: "calo/pedestals"}, want: "v3"},
+		{q: Query{Tag: "tracker/alignment"}, err: ErrNotFound},
+	} {
+		c, err := db.Fetch(ctx, tc.q)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("invalid error for %+v: got=%v, want=%v", tc.q, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("could not fetch %+v: %+v", tc.q, err)
+		}
+		if got := string(c.Payload); got != tc.want {
+			t.Fatalf("invalid payload for %+v:\ngot = %q\nwant= %q", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestMem(t *testing.T) {
+	testFetch(t, newTestDB())
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(Handler(newTestDB()))
+	defer srv.Close()
+
+	db, err := Open(srv.URL)
+	if err != nil {
+		t.Fatalf("could not open conditions database: %+v", err)
+	}
+	if got, want := db, (&Client{URL: srv.URL}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid database:\ngot = %#v\nwant= %#v", got, want)
+	}
+	testFetch(t, db)
+}
+
+func TestOpen(t *testing.T) {
+	for _, addr := range []string{
+		"ftp://example.org/conditions",
+		"::invalid",
+	} {
+		_, err := Open(addr)
+		if err == nil {
+			t.Fatalf("expected an error opening %q", addr)
+		}
+	}
+}
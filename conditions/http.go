@@ -0,0 +1,121 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conditions // import "github.com/go-daq/tdaq/conditions"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Client fetches conditions from an HTTP conditions server.
+//
+// Queries are sent as GET requests with "tag", "run" and "time" (RFC 3339)
+// form values, and conditions are received as JSON documents.
+// Servers reply with 404 when no condition is valid for the query.
+type Client struct {
+	URL    string       // URL of the conditions end-point
+	Client *http.Client // HTTP client (default: 10s timeout)
+}
+
+// Fetch returns the most recent version of the conditions selected by the
+// query, or ErrNotFound.
+func (cli *Client) Fetch(ctx context.Context, q Query) (Condition, error) {
+	var c Condition
+
+	u, err := url.Parse(cli.URL)
+	if err != nil {
+		return c, fmt.Errorf("conditions: could not parse URL %q: %w", cli.URL, err)
+	}
+	v := u.Query()
+	v.Set("tag", q.Tag)
+	if q.Run != 0 {
+		v.Set("run", strconv.FormatUint(q.Run, 10))
+	}
+	if !q.Time.IsZero() {
+		v.Set("time", q.Time.UTC().Format(time.RFC3339Nano))
+	}
+	u.RawQuery = v.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return c, fmt.Errorf("conditions: could not create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+
+	hcli := cli.Client
+	if hcli == nil {
+		hcli = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := hcli.Do(req)
+	if err != nil {
+		return c, fmt.Errorf("conditions: could not fetch %q: %w", q.Tag, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// ok.
+	case http.StatusNotFound:
+		return c, fmt.Errorf("%w (tag=%q, run=%d, time=%v)", ErrNotFound, q.Tag, q.Run, q.Time)
+	default:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return c, fmt.Errorf("conditions: could not fetch %q: %s (%s)", q.Tag, resp.Status, msg)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&c)
+	if err != nil {
+		return c, fmt.Errorf("conditions: could not decode %q: %w", q.Tag, err)
+	}
+	return c, nil
+}
+
+// Handler returns an HTTP handler serving the conditions of the provided
+// database to Clients.
+func Handler(db DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := Query{Tag: r.FormValue("tag")}
+		if v := r.FormValue("run"); v != "" {
+			run, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid run %q", v), http.StatusBadRequest)
+				return
+			}
+			q.Run = run
+		}
+		if v := r.FormValue("time"); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid time %q", v), http.StatusBadRequest)
+				return
+			}
+			q.Time = t
+		}
+
+		c, err := db.Fetch(r.Context(), q)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(c)
+	})
+}
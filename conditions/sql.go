@@ -0,0 +1,94 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package conditions // import "github.com/go-daq/tdaq/conditions"
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultTable is the default name of the table of SQL conditions databases.
+const DefaultTable = "conditions"
+
+// SQL fetches conditions from an SQL database, e.g. SQLite.
+//
+// Conditions are stored in a table with the following columns:
+//
+//	tag     TEXT     -- tag of the conditions
+//	version INTEGER  -- version of the payload
+//	run_beg INTEGER  -- first valid run (0: open)
+//	run_end INTEGER  -- first invalid run (0: open)
+//	beg     INTEGER  -- start of validity, in ns since the Unix epoch (0: open)
+//	end     INTEGER  -- end of validity, in ns since the Unix epoch (0: open)
+//	payload BLOB
+//
+// Queries use "?" placeholders, as supported by SQLite and MySQL drivers.
+type SQL struct {
+	DB    *sql.DB
+	Table string // name of the conditions table (default: DefaultTable)
+}
+
+// Fetch returns the most recent version of the conditions selected by the
+// query, or ErrNotFound.
+func (db *SQL) Fetch(ctx context.Context, q Query) (Condition, error) {
+	table := db.Table
+	if table == "" {
+		table = DefaultTable
+	}
+
+	var (
+		run = int64(q.Run)
+		now int64
+	)
+	if !q.Time.IsZero() {
+		now = q.Time.UnixNano()
+	}
+
+	query := fmt.Sprintf(`SELECT version, run_beg, run_end, beg, "end", payload FROM %s
+WHERE tag = ?
+  AND (? = 0 OR run_beg = 0 OR run_beg <= ?)
+  AND (? = 0 OR run_end = 0 OR ? < run_end)
+  AND (? = 0 OR beg = 0 OR beg <= ?)
+  AND (? = 0 OR "end" = 0 OR ? < "end")
+ORDER BY version DESC LIMIT 1`, table)
+
+	var (
+		c = Condition{Tag: q.Tag}
+
+		runBeg, runEnd int64
+		beg, end       int64
+	)
+	err := db.DB.QueryRowContext(
+		ctx, query, q.Tag,
+		run, run,
+		run, run,
+		now, now,
+		now, now,
+	).Scan(&c.Version, &runBeg, &runEnd, &beg, &end, &c.Payload)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return c, fmt.Errorf("%w (tag=%q, run=%d, time=%v)", ErrNotFound, q.Tag, q.Run, q.Time)
+	case err != nil:
+		return c, fmt.Errorf("conditions: could not fetch %q: %w", q.Tag, err)
+	}
+
+	c.IOV = IOV{
+		RunBeg: uint64(runBeg),
+		RunEnd: uint64(runEnd),
+		Beg:    fromNano(beg),
+		End:    fromNano(end),
+	}
+	return c, nil
+}
+
+func fromNano(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v).UTC()
+}
@@ -0,0 +1,37 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-daq/tdaq/conditions"
+	"github.com/go-daq/tdaq/config"
+)
+
+func TestServerConditions(t *testing.T) {
+	srv := New(config.Process{Name: "dev"}, ioutil.Discard)
+	if db := srv.context(context.Background()).Conditions(); db != nil {
+		t.Fatalf("unexpected conditions database: %#v", db)
+	}
+
+	db := new(conditions.Mem)
+	db.Add(conditions.Condition{Tag: "calo/pedestals", Version: 1, Payload: []byte("peds")})
+
+	var o serveOptions
+	WithConditions(db)(&o)
+	o.register(srv, new(testDevice))
+
+	ctx := srv.context(context.Background())
+	c, err := ctx.Conditions().Fetch(ctx.Ctx, conditions.Query{Tag: "calo/pedestals", Run: 42})
+	if err != nil {
+		t.Fatalf("could not fetch conditions: %+v", err)
+	}
+	if got, want := string(c.Payload), "peds"; got != want {
+		t.Fatalf("invalid conditions payload:\ngot = %q\nwant= %q", got, want)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// ConfigVersion is the latest version of the layout of the /config command
+// supported by this package.
+//
+// Version 1 adds the extension section of typed fields, sent to processes
+// negotiating ProtoV3 or later.
+// Version 2 adds the negotiated payload encodings of the end-points, after
+// the extension section.
+// Version 3 adds the end-points with compact data links, after the payload
+// encodings.
+const ConfigVersion = 3
+
+// ConfigType is the type of a field of the extension section of the /config
+// command.
+type ConfigType uint8
+
+// Types of the fields of the extension section of the /config command.
+const (
+	ConfigBytes ConfigType = iota
+	ConfigStr
+	ConfigI64
+	ConfigF64
+	ConfigBool
+)
+
+func (t ConfigType) String() string {
+	switch t {
+	case ConfigBytes:
+		return "bytes"
+	case ConfigStr:
+		return "str"
+	case ConfigI64:
+		return "i64"
+	case ConfigF64:
+		return "f64"
+	case ConfigBool:
+		return "bool"
+	default:
+		return fmt.Sprintf("ConfigType(%d)", uint8(t))
+	}
+}
+
+// ConfigField is a typed field of the extension section of the /config
+// command.
+//
+// Fields are length-prefixed on the wire: processes skip the fields they do
+// not know about, so new configuration fields can be added without breaking
+// older processes.
+type ConfigField struct {
+	Type  ConfigType
+	Value []byte // encoded value
+}
+
+// BytesField returns a configuration field holding raw bytes.
+func BytesField(v []byte) ConfigField {
+	return ConfigField{Type: ConfigBytes, Value: v}
+}
+
+// StrField returns a configuration field holding a string.
+func StrField(v string) ConfigField {
+	return ConfigField{Type: ConfigStr, Value: []byte(v)}
+}
+
+// I64Field returns a configuration field holding a signed integer.
+func I64Field(v int64) ConfigField {
+	raw := make([]byte, 8)
+	binary.LittleEndian.PutUint64(raw, uint64(v))
+	return ConfigField{Type: ConfigI64, Value: raw}
+}
+
+// F64Field returns a configuration field holding a floating point value.
+func F64Field(v float64) ConfigField {
+	raw := make([]byte, 8)
+	binary.LittleEndian.PutUint64(raw, math.Float64bits(v))
+	return ConfigField{Type: ConfigF64, Value: raw}
+}
+
+// BoolField returns a configuration field holding a boolean.
+func BoolField(v bool) ConfigField {
+	raw := []byte{0}
+	if v {
+		raw[0] = 1
+	}
+	return ConfigField{Type: ConfigBool, Value: raw}
+}
+
+func (f ConfigField) check(t ConfigType, n int) error {
+	if f.Type != t {
+		return fmt.Errorf("invalid config field type (got=%v, want=%v)", f.Type, t)
+	}
+	if n >= 0 && len(f.Value) != n {
+		return fmt.Errorf("invalid %v config field size (got=%d, want=%d)", t, len(f.Value), n)
+	}
+	return nil
+}
+
+// Bytes returns the raw bytes held by the field.
+func (f ConfigField) Bytes() ([]byte, error) {
+	err := f.check(ConfigBytes, -1)
+	if err != nil {
+		return nil, err
+	}
+	return f.Value, nil
+}
+
+// Str returns the string held by the field.
+func (f ConfigField) Str() (string, error) {
+	err := f.check(ConfigStr, -1)
+	if err != nil {
+		return "", err
+	}
+	return string(f.Value), nil
+}
+
+// I64 returns the signed integer held by the field.
+func (f ConfigField) I64() (int64, error) {
+	err := f.check(ConfigI64, 8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(f.Value)), nil
+}
+
+// F64 returns the floating point value held by the field.
+func (f ConfigField) F64() (float64, error) {
+	err := f.check(ConfigF64, 8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(f.Value)), nil
+}
+
+// Bool returns the boolean held by the field.
+func (f ConfigField) Bool() (bool, error) {
+	err := f.check(ConfigBool, 1)
+	if err != nil {
+		return false, err
+	}
+	return f.Value[0] != 0, nil
+}
+
+func writeConfigFields(enc *Encoder, fields map[string]ConfigField) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	enc.WriteI32(int32(len(keys)))
+	for _, k := range keys {
+		f := fields[k]
+		enc.WriteStr(k)
+		enc.WriteU8(uint8(f.Type))
+		enc.WriteBytes(f.Value)
+	}
+}
+
+func readConfigFields(dec *Decoder) map[string]ConfigField {
+	n := int(dec.ReadI32())
+	if n <= 0 {
+		return nil
+	}
+	fields := make(map[string]ConfigField, n)
+	for i := 0; i < n && dec.err == nil; i++ {
+		k := dec.ReadStr()
+		fields[k] = ConfigField{
+			Type:  ConfigType(dec.ReadU8()),
+			Value: dec.ReadBytes(),
+		}
+	}
+	return fields
+}
+
+// ConfigField returns the named field of the extension section of the
+// /config command received by the process.
+func (ctx Context) ConfigField(name string) (ConfigField, bool) {
+	if ctx.ext == nil {
+		return ConfigField{}, false
+	}
+	return ctx.ext.get(name)
+}
+
+// extcache holds the fields of the extension section of the /config command
+// received by a process.
+type extcache struct {
+	mu     sync.RWMutex
+	fields map[string]ConfigField
+}
+
+func (ext *extcache) update(fields map[string]ConfigField) {
+	ext.mu.Lock()
+	defer ext.mu.Unlock()
+	ext.fields = fields
+}
+
+func (ext *extcache) get(name string) (ConfigField, bool) {
+	ext.mu.RLock()
+	defer ext.mu.RUnlock()
+	f, ok := ext.fields[name]
+	return f, ok
+}
+
+// SetConfigField sets the named field of the extension section of the
+// /config commands sent to the TDAQ processes supporting it.
+// A zero-value field removes the named field.
+// Processes receive the new field at their next /config.
+func (rc *RunControl) SetConfigField(name string, f ConfigField) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if f.Value == nil && f.Type == 0 {
+		delete(rc.ext, name)
+		return
+	}
+	if rc.ext == nil {
+		rc.ext = make(map[string]ConfigField)
+	}
+	rc.ext[name] = f
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+func TestConfigField(t *testing.T) {
+	if v, err := StrField("calib").Str(); err != nil || v != "calib" {
+		t.Fatalf("invalid str field: got=%q, err=%v", v, err)
+	}
+	if v, err := I64Field(-42).I64(); err != nil || v != -42 {
+		t.Fatalf("invalid i64 field: got=%d, err=%v", v, err)
+	}
+	if v, err := F64Field(1.5).F64(); err != nil || v != 1.5 {
+		t.Fatalf("invalid f64 field: got=%v, err=%v", v, err)
+	}
+	if v, err := BoolField(true).Bool(); err != nil || !v {
+		t.Fatalf("invalid bool field: got=%v, err=%v", v, err)
+	}
+	if v, err := BytesField([]byte{1, 2}).Bytes(); err != nil || !reflect.DeepEqual(v, []byte{1, 2}) {
+		t.Fatalf("invalid bytes field: got=%v, err=%v", v, err)
+	}
+
+	_, err := StrField("calib").I64()
+	if err == nil {
+		t.Fatalf("expected an error decoding a str field as i64")
+	}
+	_, err = ConfigField{Type: ConfigI64, Value: []byte{1}}.I64()
+	if err == nil {
+		t.Fatalf("expected an error decoding a truncated i64 field")
+	}
+
+	srv := New(config.Process{Name: "dev"}, ioutil.Discard)
+	srv.ext.update(map[string]ConfigField{"mode": StrField("calib")})
+	ctx := srv.context(context.Background())
+	if _, ok := ctx.ConfigField("gain"); ok {
+		t.Fatalf("unexpected config field \"gain\"")
+	}
+	f, ok := ctx.ConfigField("mode")
+	if !ok {
+		t.Fatalf("missing config field \"mode\"")
+	}
+	if got, want := f, StrField("calib"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid config field:\ngot = %#v\nwant= %#v", got, want)
+	}
+}
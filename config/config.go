@@ -16,11 +16,93 @@ type Process struct {
 	Name   string    // name of the TDAQ process
 	Level  log.Level // verbosity level of the TDAQ process
 	Trans  string    // network used for the TDAQ network ("tcp", "ipc", ...)
-	RunCtl string    // address of the run-ctl of the flock of TDAQ processes
+	RunCtl string    // address of the run-ctl of the flock of TDAQ processes ("auto[:name]": discovered via mDNS)
+
+	ChunkSize    int // maximum size of data frame payloads before they are split into chunks (0: default)
+	MaxFrameSize int // maximum size of frames exchanged with other TDAQ processes, and of reassembled chunked data frames (0: default)
+
+	Sockets map[string]SockOpts // tuning options of the sockets of data end-points, indexed by end-point name
+	Types   map[string]string   // types of the data frames of end-points, indexed by end-point name
+	Mux     bool                // multiplex all output end-points over a single output port and data connection
+	Latency bool                // stamp the data frames of output end-points with their send time, to measure their latency
+
+	TraceRate int // trace the events whose ID is a multiple of TraceRate through the pipeline (0: disabled)
+
+	ReconnectTime    time.Duration // initial delay before redialing a dropped data link (0: default)
+	MaxReconnectTime time.Duration // maximum delay between attempts at redialing a dropped data link (0: default)
+	StallTimeout     time.Duration // duration without data after which a running data link is reported as stalled (0: default, <0: disabled)
+
+	DeadLetter DeadLetter // handling of data frames that could not be delivered
+	Spool      Spool      // spooling of data frames to local disk while no consumer is connected
+	Acked      Acked      // acknowledged delivery of data frames
+	Credit     Credit     // credit-based flow control of data links
+
+	Device *Device // implementation of the device, for generic device hosts (may be nil)
+
+	Secrets string // path to the encrypted secrets store of the process (empty: none)
+	PProf   string // address of the net/http/pprof server of the process, started at startup (empty: started with "/debug pprof on")
+	Health  string // address of the /healthz and /readyz probes server of the process (empty: disabled)
+
+	Conditions string // URL of the conditions database of the process (empty: none)
+
+	Checkpoint     string        // path to the checkpoint file of the device state (empty: disabled)
+	CheckpointFreq time.Duration // period of the checkpoints of the device state (0: default)
 
 	Args []string // additional flag arguments
 }
 
+// Dead-letter policies.
+const (
+	DeadLetterDrop     = "drop"     // undeliverable frames are dropped and counted
+	DeadLetterSpill    = "spill"    // undeliverable frames are written to a local spill file
+	DeadLetterRedirect = "redirect" // undeliverable frames are sent to a dedicated output end-point
+)
+
+// Required-process policies.
+const (
+	RequiredRefuse = "refuse" // /start is refused unless all required processes are ready
+	RequiredWarn   = "warn"   // /start proceeds, with a warning, when required processes are not ready
+)
+
+// DeadLetter describes how data frames that could not be delivered to any
+// consumer within a deadline are handled.
+// Dead-letter handling is disabled when Policy is empty.
+type DeadLetter struct {
+	Policy   string        // dead-letter policy (drop, spill or redirect)
+	Deadline time.Duration // maximum time to wait for a consumer to be connected
+	File     string        // path to the spill file (spill policy)
+	EndPoint string        // name of the output end-point receiving undeliverable frames (redirect policy)
+}
+
+// Spool describes how data frames of output end-points are spooled to local
+// disk while no consumer is connected.
+// Spooling is disabled when Dir is empty.
+type Spool struct {
+	Dir     string // directory holding the spool files
+	MaxSize int64  // maximum size in bytes of the spool file of each output end-point (0: unbounded)
+}
+
+// Acked describes the output end-points delivered in acknowledged
+// (at-least-once) mode.
+// Consumers acknowledge the data frames they processed and producers keep
+// the unacknowledged ones in a log on local disk, to retransmit them after
+// a consumer reconnected, even across a crash of the producer.
+type Acked struct {
+	EndPoints []string      // names of the output end-points delivered in acknowledged mode
+	Dir       string        // directory holding the logs of unacknowledged data frames
+	Batch     int           // number of data frames acknowledged at once by consumers (0: default)
+	Timeout   time.Duration // delay without acknowledgement after which data frames are retransmitted (0: default)
+}
+
+// Credit describes the credit-based flow control of data links.
+// Consumers grant their producers credit for as many data frames as their
+// input queues can still hold, and producers only send data frames within
+// that credit.
+type Credit struct {
+	EndPoints []string // names of the output end-points under flow control
+	Bytes     int64    // maximum number of queued payload bytes granted by each input end-point (0: unbounded)
+}
+
 // RunCtl describes how a TDAQ RunControl process should be configured.
 type RunCtl struct {
 	Name   string    // name of the run-ctl process
@@ -29,14 +111,77 @@ type RunCtl struct {
 	RunCtl string    // address of the run-ctl cmd server
 	Web    string    // address of the HTTP run-ctl web server
 
+	Observer string // address of the read-only HTTP observer web server (empty: none)
+	RPC      string // address of the JSON-RPC 2.0 TCP control server (empty: none)
+
 	Interactive bool // enable interactive shell commands for the run-ctl process
+	Check       bool // validate the topology file and exit, without running the run-ctl process
+	MDNS        bool // advertise the run-ctl address on the local network via mDNS
+
+	Topology string // path to the JSON topology file of the tdaq processes (optional)
 
 	LogFile   string        // path to logfile for run-ctl log server
 	HBeatFreq time.Duration // frequency for heartbeat server
 
+	SummaryDir string // directory of the end-of-run summary reports (empty: directory of the log file)
+	Logbook    string // URL of the electronic logbook receiving run entries (empty: none)
+
+	Alerts      []string      // URLs of the notification channels receiving alerts
+	AlertWindow time.Duration // throttling window of repeated alerts (0: default)
+	MinDiskFree int64         // free disk space, in bytes, below which a disk-full alert is raised (0: disabled)
+
+	AlarmFile string // path to the file persisting the alarms of the tdaq processes (empty: not persisted)
+	KVFile    string // path to the file persisting the key-value configuration store (empty: not persisted)
+	Record    string // path to the file recording the commands issued by run-ctl, for replay (empty: not recorded)
+	AuditFile string // path to the append-only audit log of the control actions of the operators (empty: not persisted)
+
+	Parallel int // maximum number of processes receiving a command concurrently (0: default)
+
+	Watchdog time.Duration // maximum duration a running process may miss heartbeats or data before the run is stopped (0: disabled)
+	Required string        // policy applied when required processes are not ready at /start (refuse or warn; empty: refuse)
+
+	Timeouts map[string]time.Duration // maximum duration of FSM transitions across all processes, by command (e.g. "/config")
+
+	MaxFrameSize int // maximum size of frames exchanged with TDAQ processes (0: default)
+
 	Args []string // additional flag arguments
 }
 
+// Proxy describes how a TDAQ proxy process should be configured.
+//
+// A proxy relays the control and data links of the TDAQ processes of one
+// network segment to a run-ctl of another network segment.
+type Proxy struct {
+	Name  string    // name of the proxy process
+	Level log.Level // verbosity level of the proxy process
+
+	Listen string // address of the /join socket served to the relayed TDAQ processes (e.g. "tcp://:44000")
+	RunCtl string // address of the run-ctl the TDAQ processes are relayed to (e.g. "tcp://daq.example.org:44000")
+
+	Public string // host under which relayed end-points are advertised to run-ctl and its other processes (empty: unchanged)
+	Inner  string // host under which relayed end-points are advertised to the relayed TDAQ processes (empty: unchanged)
+
+	MaxFrameSize int // maximum size of relayed control frames (0: default)
+}
+
+// Gateway describes how a TDAQ gateway between two partitions should be
+// configured.
+//
+// A gateway joins a lower partition (e.g. a sub-detector run-ctl) as a
+// consumer of selected data streams, and republishes those streams, with
+// the status of the lower partition, into an upper partition (e.g. a global
+// run-ctl).
+type Gateway struct {
+	Lower Process // configuration of the process joining the lower partition
+	Upper Process // configuration of the process joining the upper partition
+
+	Streams  map[string]string // upper output end-points, indexed by the lower input end-points they republish
+	QueueLen int               // number of data frames buffered per stream (0: default)
+
+	StatusURL  string        // URL of the status API of the lower run-ctl (empty: status not republished)
+	StatusFreq time.Duration // polling period of the status of the lower partition (0: default)
+}
+
 func (cfg RunCtl) Addr() string {
 	return cfg.Trans + "://" + cfg.RunCtl
 }
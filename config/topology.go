@@ -0,0 +1,164 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config // import "github.com/go-daq/tdaq/config"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Topology describes a flock of TDAQ processes and their end-points.
+//
+// A topology is usually loaded from a JSON file:
+//
+//	{
+//	  "procs": [
+//	    {
+//	      "name": "tdaq-datasrc",
+//	      "outputs": [
+//	        {"name": "/adc", "type": "adc", "sockets": {"nodelay": true, "write-qlen": 1024}}
+//	      ]
+//	    },
+//	    {
+//	      "name": "tdaq-datasink",
+//	      "required": true,
+//	      "device": {"type": "datasink", "plugin": "/opt/tdaq/datasink.so"},
+//	      "inputs": [
+//	        {"name": "/adc", "sockets": {"keepalive": true, "keepalive-time": "10s"}}
+//	      ]
+//	    }
+//	  ]
+//	}
+type Topology struct {
+	Procs []ProcTopology `json:"procs"`
+}
+
+// ProcTopology describes a TDAQ process of a topology.
+type ProcTopology struct {
+	Name     string             `json:"name"`
+	Required bool               `json:"required,omitempty"` // whether runs cannot start without the process
+	Device   *Device            `json:"device,omitempty"`
+	Inputs   []EndPointTopology `json:"inputs,omitempty"`
+	Outputs  []EndPointTopology `json:"outputs,omitempty"`
+}
+
+// Device describes the implementation of the device run by a generic
+// device host process (tdaq-device).
+type Device struct {
+	Type   string            `json:"type"`             // type of the device, as registered with its factory
+	Plugin string            `json:"plugin,omitempty"` // path to a Go plugin providing the device implementation
+	Params map[string]string `json:"params,omitempty"` // parameters of the device
+}
+
+// EndPointTopology describes a data end-point of a TDAQ process.
+type EndPointTopology struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type,omitempty"` // type of the data frames of the end-point (optional)
+	Sockets SockOpts `json:"sockets,omitempty"`
+}
+
+// SockOpts describes the tuning options of the sockets of a data end-point.
+// Zero values leave the corresponding option to its default.
+//
+// The kernel socket buffers are not exposed by the underlying transport:
+// buffering is tuned via the lengths of the send and receive message queues.
+type SockOpts struct {
+	NoDelay       *bool    `json:"nodelay,omitempty"`        // disable Nagle's algorithm (TCP_NODELAY)
+	KeepAlive     *bool    `json:"keepalive,omitempty"`      // enable TCP keep-alive probes
+	KeepAliveTime Duration `json:"keepalive-time,omitempty"` // interval between TCP keep-alive probes
+	WriteQLen     int      `json:"write-qlen,omitempty"`     // length of the send queue, in messages
+	ReadQLen      int      `json:"read-qlen,omitempty"`      // length of the receive queue, in messages
+}
+
+// Duration is a time.Duration encoded in JSON as a string (e.g. "1.5s").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(p []byte) error {
+	var str string
+	err := json.Unmarshal(p, &str)
+	if err != nil {
+		return fmt.Errorf("could not decode duration: %w", err)
+	}
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("could not parse duration %q: %w", str, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadTopology loads a topology from the named JSON file.
+func LoadTopology(fname string) (Topology, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return Topology{}, fmt.Errorf("could not open topology file: %w", err)
+	}
+	defer f.Close()
+
+	topo, err := ReadTopology(f)
+	if err != nil {
+		return topo, fmt.Errorf("could not read topology file %q: %w", fname, err)
+	}
+	return topo, nil
+}
+
+// ReadTopology reads a JSON topology from the provided reader.
+func ReadTopology(r io.Reader) (Topology, error) {
+	var topo Topology
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&topo)
+	if err != nil {
+		return topo, fmt.Errorf("could not decode topology: %w", err)
+	}
+	return topo, nil
+}
+
+// Proc returns the description of the named process.
+func (topo Topology) Proc(name string) (ProcTopology, bool) {
+	for _, p := range topo.Procs {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return ProcTopology{}, false
+}
+
+// Sockets returns the socket options of the end-points of the process,
+// indexed by end-point name.
+func (p ProcTopology) Sockets() map[string]SockOpts {
+	opts := make(map[string]SockOpts, len(p.Inputs)+len(p.Outputs))
+	for _, ep := range p.Inputs {
+		opts[ep.Name] = ep.Sockets
+	}
+	for _, ep := range p.Outputs {
+		opts[ep.Name] = ep.Sockets
+	}
+	return opts
+}
+
+// Types returns the types of the data frames of the typed end-points of
+// the process, indexed by end-point name.
+func (p ProcTopology) Types() map[string]string {
+	types := make(map[string]string)
+	for _, ep := range p.Inputs {
+		if ep.Type != "" {
+			types[ep.Name] = ep.Type
+		}
+	}
+	for _, ep := range p.Outputs {
+		if ep.Type != "" {
+			types[ep.Name] = ep.Type
+		}
+	}
+	return types
+}
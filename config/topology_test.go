@@ -0,0 +1,100 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTopology(t *testing.T) {
+	const src = `{
+	"procs": [
+		{
+			"name": "datasrc",
+			"outputs": [
+				{"name": "/adc", "type": "i64", "sockets": {"nodelay": true, "write-qlen": 1024}}
+			]
+		},
+		{
+			"name": "datasink",
+			"device": {"type": "i64-dump", "params": {"i": "/adc"}},
+			"inputs": [
+				{"name": "/adc", "sockets": {"keepalive": false, "keepalive-time": "1.5s", "read-qlen": 64}}
+			]
+		}
+	]
+}`
+
+	topo, err := ReadTopology(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("could not read topology: %+v", err)
+	}
+
+	var (
+		yes = true
+		no  = false
+	)
+
+	for _, tc := range []struct {
+		name string
+		want map[string]SockOpts
+	}{
+		{
+			name: "datasrc",
+			want: map[string]SockOpts{
+				"/adc": {NoDelay: &yes, WriteQLen: 1024},
+			},
+		},
+		{
+			name: "datasink",
+			want: map[string]SockOpts{
+				"/adc": {KeepAlive: &no, KeepAliveTime: Duration(1500 * time.Millisecond), ReadQLen: 64},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, ok := topo.Proc(tc.name)
+			if !ok {
+				t.Fatalf("could not find process %q", tc.name)
+			}
+			if got, want := p.Sockets(), tc.want; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid socket options:\ngot = %#v\nwant= %#v", got, want)
+			}
+		})
+	}
+
+	if _, ok := topo.Proc("not-there"); ok {
+		t.Fatalf("expected no process")
+	}
+
+	p, _ := topo.Proc("datasink")
+	want := &Device{Type: "i64-dump", Params: map[string]string{"i": "/adc"}}
+	if got := p.Device; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid device:\ngot = %#v\nwant= %#v", got, want)
+	}
+	if p, _ := topo.Proc("datasrc"); p.Device != nil {
+		t.Fatalf("invalid device: got=%#v, want=nil", p.Device)
+	}
+
+	p, _ = topo.Proc("datasrc")
+	if got, want := p.Types(), map[string]string{"/adc": "i64"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid end-point types:\ngot = %#v\nwant= %#v", got, want)
+	}
+}
+
+func TestReadTopologyInvalid(t *testing.T) {
+	for _, src := range []string{
+		`{"procs": [{"name": "p1", "unknown": 1}]}`,
+		`{"procs": [{"name": "p1", "inputs": [{"name": "/adc", "sockets": {"keepalive-time": "1 hour"}}]}]}`,
+	} {
+		_, err := ReadTopology(strings.NewReader(src))
+		if err == nil {
+			t.Fatalf("expected an error for %s", src)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ControlToken describes the operator holding the control of run-ctl.
+//
+// Only the operator holding the control token may issue control commands
+// (FSM commands other than /status, as well as /reconfig, /debug and
+// /restore), write to the key-value configuration store and acknowledge
+// alarms over the web API; other operators are observers until the token is
+// released or forcibly taken.
+// The token is bound to the control session (see WithControl) of the
+// operator that took it, through a secret returned by TakeControl: control
+// commands must be issued with a context carrying that secret, whatever the
+// name of their operator.
+// The first control command of a control session, while the token is free,
+// takes the token on behalf of that session.
+// Control commands issued by run-ctl itself, without an operator (see
+// WithOperator), are not subject to the control token.
+type ControlToken struct {
+	Operator string    `json:"operator,omitempty"` // operator holding the token (empty: free)
+	Since    time.Time `json:"since"`              // time at which the token was taken
+}
+
+// Command names of the changes of the control token, as recorded in the
+// audit log.
+const (
+	auditTake    = "/control-take"
+	auditRelease = "/control-release"
+)
+
+// errNoControl is returned for control commands of operators not holding
+// the control token.
+var errNoControl = errors.New("tdaq: operator does not hold the control token")
+
+// controlToken is the control token of run-ctl.
+type controlToken struct {
+	mu     sync.Mutex
+	tok    ControlToken
+	secret string // secret of the control session holding the token
+}
+
+// controlSession is the control session of an operator.
+type controlSession struct {
+	mu     sync.Mutex
+	secret string // secret of the control token taken by the session, if any
+}
+
+func (s *controlSession) get() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.secret
+}
+
+func (s *controlSession) set(secret string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secret = secret
+}
+
+type sessionKey struct{}
+
+// WithControl returns a copy of ctx carrying a control session, identified
+// by the secret of the control token previously taken by that session (empty
+// for a new session).
+// The control token taken with a context carrying a control session is
+// stored into that session, so all the commands issued with that context
+// present its secret.
+func WithControl(ctx context.Context, secret string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &controlSession{secret: secret})
+}
+
+// ControlSecret returns the secret of the control session carried by ctx,
+// if any.
+func ControlSecret(ctx context.Context) string {
+	return session(ctx).get()
+}
+
+func session(ctx context.Context) *controlSession {
+	s, _ := ctx.Value(sessionKey{}).(*controlSession)
+	return s
+}
+
+// newControlSecret returns a new random secret for a control token.
+func newControlSecret() (string, error) {
+	var buf [16]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return "", fmt.Errorf("tdaq: could not generate control secret: %w", err)
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// Control returns the current state of the control token of run-ctl.
+func (rc *RunControl) Control() ControlToken {
+	rc.ctl.mu.Lock()
+	defer rc.ctl.mu.Unlock()
+	return rc.ctl.tok
+}
+
+// TakeControl takes the control token of run-ctl on behalf of the operator
+// and of the control session carried by ctx, and returns the secret of the
+// token.
+// TakeControl fails if the token is held by another control session, unless
+// force is true: the token is then taken away from that session.
+func (rc *RunControl) TakeControl(ctx context.Context, force bool) (string, error) {
+	operator := Operator(ctx)
+	if operator == "" {
+		return "", fmt.Errorf("tdaq: control token requires an operator")
+	}
+
+	beg := time.Now()
+	rc.ctl.mu.Lock()
+	defer rc.ctl.mu.Unlock()
+
+	prev := rc.ctl.tok.Operator
+	switch {
+	case prev == "":
+		// ok.
+	case rc.ctl.secret == ControlSecret(ctx):
+		return rc.ctl.secret, nil
+	default:
+		if !force {
+			err := fmt.Errorf("%w (held by %q since %s)", errNoControl, prev, rc.ctl.tok.Since.UTC().Format(time.RFC3339))
+			rc.audit(operator, auditTake, []string{prev}, nil, beg, err)
+			return "", err
+		}
+		rc.msg.Warnf("control token forcibly taken from %q by %q", prev, operator)
+	}
+
+	secret, err := newControlSecret()
+	if err != nil {
+		rc.audit(operator, auditTake, nonEmpty(prev), nil, beg, err)
+		return "", err
+	}
+	rc.setControl(ControlToken{Operator: operator, Since: beg}, secret)
+	session(ctx).set(secret)
+
+	var args []string
+	if prev != "" {
+		args = []string{"force"}
+	}
+	rc.audit(operator, auditTake, nonEmpty(prev), args, beg, nil)
+	return secret, nil
+}
+
+// ReleaseControl releases the control token of run-ctl held by the control
+// session carried by ctx.
+func (rc *RunControl) ReleaseControl(ctx context.Context) error {
+	beg := time.Now()
+	rc.ctl.mu.Lock()
+	defer rc.ctl.mu.Unlock()
+
+	cur := rc.ctl.tok.Operator
+	if cur == "" {
+		return nil
+	}
+	if rc.ctl.secret != ControlSecret(ctx) {
+		err := fmt.Errorf("%w (held by %q)", errNoControl, cur)
+		rc.audit(Operator(ctx), auditRelease, nil, nil, beg, err)
+		return err
+	}
+
+	rc.setControl(ControlToken{}, "")
+	session(ctx).set("")
+	rc.audit(Operator(ctx), auditRelease, nil, nil, beg, nil)
+	return nil
+}
+
+// control checks the control session carried by ctx holds the control token
+// of run-ctl, taking it if it is free, before the operator carried by ctx
+// issues the provided command.
+// Operators without a control session may only issue commands while the
+// token is free.
+// Refused commands are recorded to the audit log.
+func (rc *RunControl) control(ctx context.Context, cmd string, targets, args []string) error {
+	operator := Operator(ctx)
+	if operator == "" {
+		// command issued by run-ctl itself.
+		return nil
+	}
+
+	beg := time.Now()
+	rc.ctl.mu.Lock()
+	defer rc.ctl.mu.Unlock()
+
+	sess := session(ctx)
+	switch cur := rc.ctl.tok.Operator; {
+	case cur == "" && sess == nil:
+		return nil
+	case cur == "":
+		secret, err := newControlSecret()
+		if err != nil {
+			return fmt.Errorf("could not run %s: %w", cmd, err)
+		}
+		rc.msg.Infof("control token taken by %q", operator)
+		rc.setControl(ControlToken{Operator: operator, Since: beg}, secret)
+		sess.set(secret)
+		rc.audit(operator, auditTake, nil, nil, beg, nil)
+		return nil
+	case rc.ctl.secret == sess.get():
+		return nil
+	default:
+		err := fmt.Errorf("could not run %s: %w (held by %q)", cmd, errNoControl, cur)
+		rc.audit(operator, cmd, targets, args, beg, err)
+		return err
+	}
+}
+
+// setControl sets the control token and its secret, and publishes the token
+// to the live feed.
+// setControl must be called with rc.ctl.mu held.
+func (rc *RunControl) setControl(tok ControlToken, secret string) {
+	rc.ctl.tok = tok
+	rc.ctl.secret = secret
+	rc.feed.publish("control", tok)
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// controlCookie is the name of the HTTP cookie carrying the secret of the
+// control session of web clients.
+const controlCookie = "tdaq-control"
+
+// webControl returns a copy of the context of the request carrying the
+// operator of the request and the control session of its control cookie.
+func webControl(r *http.Request) context.Context {
+	var secret string
+	if c, err := r.Cookie(controlCookie); err == nil {
+		secret = c.Value
+	}
+	return WithControl(WithOperator(r.Context(), webOperator(r)), secret)
+}
+
+// setWebControl updates the control cookie of the reply to the request when
+// the secret of the control session carried by ctx changed.
+// setWebControl must be called before the header of the reply is written.
+func setWebControl(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var (
+		prev   string
+		secret = ControlSecret(ctx)
+	)
+	if c, err := r.Cookie(controlCookie); err == nil {
+		prev = c.Value
+	}
+	if secret == prev {
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     controlCookie,
+		Value:    secret,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if secret == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// webAPIControl serves the control token of run-ctl.
+// GET replies with a JSON report of the state of the token.
+// POST takes the token or, with the "release" form value, releases it, on
+// behalf of the operator and of the control session of the request. The
+// token held by another control session is only taken with the "force" form
+// value.
+// The secret of the token taken by the request is stored in the control
+// cookie of the reply, and reported as its "secret" JSON field for clients
+// without cookies.
+func (rc *RunControl) webAPIControl(w http.ResponseWriter, r *http.Request) {
+	var secret string
+	switch r.Method {
+	case http.MethodGet:
+		// ok.
+	case http.MethodPost:
+		var (
+			ctx = webControl(r)
+			err error
+		)
+		switch {
+		case r.FormValue("release") != "":
+			err = rc.ReleaseControl(ctx)
+		default:
+			secret, err = rc.TakeControl(ctx, r.FormValue("force") != "")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), webControlStatus(err, http.StatusBadRequest))
+			return
+		}
+		setWebControl(ctx, w, r)
+	default:
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		ControlToken
+		Secret string `json:"secret,omitempty"`
+	}{rc.Control(), secret})
+	if err != nil {
+		rc.msg.Errorf("could not encode control token: %+v", err)
+	}
+}
+
+// webControlStatus returns the HTTP status code of a control command that
+// failed with err: http.StatusConflict if the operator does not hold the
+// control token, code otherwise.
+func webControlStatus(err error, code int) int {
+	if errors.Is(err, errNoControl) {
+		return http.StatusConflict
+	}
+	return code
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestControlToken(t *testing.T) {
+	rc := &RunControl{
+		cfg:     config.RunCtl{Name: "run-ctl"},
+		msg:     log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard),
+		clients: newClientDB(),
+		feed:    newFeed(),
+		audits:  &auditLog{},
+	}
+
+	if got := rc.Control().Operator; got != "" {
+		t.Fatalf("control token should be free, held by %q", got)
+	}
+
+	var (
+		alice = WithControl(WithOperator(context.Background(), "alice"), "")
+		bob   = WithControl(WithOperator(context.Background(), "bob"), "")
+		// an operator impersonating alice, without her control session.
+		mallory = WithControl(WithOperator(context.Background(), "alice"), "")
+	)
+
+	// run-ctl itself is not subject to the control token.
+	err := rc.control(context.Background(), "/start", nil, nil)
+	if err != nil {
+		t.Fatalf("could not run command without operator: %+v", err)
+	}
+
+	err = rc.control(alice, "/config", nil, nil)
+	if err != nil {
+		t.Fatalf("could not take free control token: %+v", err)
+	}
+	if got, want := rc.Control().Operator, "alice"; got != want {
+		t.Fatalf("invalid control token holder: got=%q, want=%q", got, want)
+	}
+	if ControlSecret(alice) == "" {
+		t.Fatalf("control session should hold the control token secret")
+	}
+
+	err = rc.control(mallory, "/stop", []string{"p1"}, nil)
+	if !errors.Is(err, errNoControl) {
+		t.Fatalf("invalid error for command of impersonator: %+v", err)
+	}
+	err = rc.control(WithOperator(context.Background(), "alice"), "/stop", nil, nil)
+	if !errors.Is(err, errNoControl) {
+		t.Fatalf("invalid error for command without control session: %+v", err)
+	}
+	err = rc.control(WithControl(WithOperator(context.Background(), "eve"), ControlSecret(alice)), "/init", nil, nil)
+	if err != nil {
+		t.Fatalf("could not run command presenting the control secret: %+v", err)
+	}
+
+	err = rc.control(bob, "/stop", []string{"p1"}, nil)
+	if !errors.Is(err, errNoControl) {
+		t.Fatalf("invalid error for command of observer: %+v", err)
+	}
+	_, err = rc.TakeControl(bob, false)
+	if !errors.Is(err, errNoControl) {
+		t.Fatalf("invalid error for take of held control token: %+v", err)
+	}
+	err = rc.ReleaseControl(bob)
+	if !errors.Is(err, errNoControl) {
+		t.Fatalf("invalid error for release of control token of another operator: %+v", err)
+	}
+
+	secret, err := rc.TakeControl(bob, true)
+	if err != nil {
+		t.Fatalf("could not forcibly take control token: %+v", err)
+	}
+	if got, want := ControlSecret(bob), secret; got != want {
+		t.Fatalf("invalid control session secret: got=%q, want=%q", got, want)
+	}
+	err = rc.control(alice, "/start", nil, nil)
+	if !errors.Is(err, errNoControl) {
+		t.Fatalf("invalid error for command of previous holder: %+v", err)
+	}
+	err = rc.control(bob, "/start", nil, nil)
+	if err != nil {
+		t.Fatalf("could not run command of holder: %+v", err)
+	}
+
+	err = rc.ReleaseControl(bob)
+	if err != nil {
+		t.Fatalf("could not release control token: %+v", err)
+	}
+	if got := rc.Control().Operator; got != "" {
+		t.Fatalf("control token should be free, held by %q", got)
+	}
+	if got := ControlSecret(bob); got != "" {
+		t.Fatalf("control session should not hold a secret, got %q", got)
+	}
+
+	// operators without a control session may run commands while the
+	// control token is free, without taking it.
+	err = rc.control(WithOperator(context.Background(), "eve"), "/stop", nil, nil)
+	if err != nil {
+		t.Fatalf("could not run command of operator without control session: %+v", err)
+	}
+	if got := rc.Control().Operator; got != "" {
+		t.Fatalf("control token should be free, held by %q", got)
+	}
+
+	type action struct {
+		Operator string
+		Cmd      string
+		Targets  []string
+		Args     []string
+		Failed   bool
+	}
+	var got []action
+	for _, e := range rc.Audit(AuditQuery{}) {
+		got = append(got, action{e.Operator, e.Cmd, e.Targets, e.Args, e.Err != ""})
+	}
+	want := []action{
+		{"alice", auditTake, nil, nil, false},
+		{"alice", "/stop", []string{"p1"}, nil, true},
+		{"alice", "/stop", nil, nil, true},
+		{"bob", "/stop", []string{"p1"}, nil, true},
+		{"bob", auditTake, []string{"alice"}, nil, true},
+		{"bob", auditRelease, nil, nil, true},
+		{"bob", auditTake, []string{"alice"}, []string{"force"}, false},
+		{"alice", "/start", nil, nil, true},
+		{"bob", auditRelease, nil, nil, false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid audit trail:\ngot= %+v\nwant=%+v", got, want)
+	}
+}
+
+func TestWebControlAck(t *testing.T) {
+	rc := &RunControl{
+		cfg:    config.RunCtl{Name: "run-ctl"},
+		msg:    log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard),
+		feed:   newFeed(),
+		audits: &auditLog{},
+	}
+	var err error
+	rc.alarms, err = newAlarmDB("", nil)
+	if err != nil {
+		t.Fatalf("could not create alarm db: %+v", err)
+	}
+	for _, id := range []string{"hv", "disk"} {
+		err = rc.alarms.update(AlarmFrame{Name: "gen", Alarm: id, Severity: SevMinor, Active: true})
+		if err != nil {
+			t.Fatalf("could not raise alarm %q: %+v", id, err)
+		}
+	}
+
+	alice := WithControl(WithOperator(context.Background(), "alice"), "")
+	secret, err := rc.TakeControl(alice, false)
+	if err != nil {
+		t.Fatalf("could not take control token: %+v", err)
+	}
+
+	ack := func(id string, cookie *http.Cookie) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/alarms/ack", strings.NewReader(url.Values{
+			"proc": {"gen"}, "id": {id}, "user": {"alice"},
+		}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		rc.webAPIAckAlarm(w, req)
+		return w.Code
+	}
+
+	if got, want := ack("hv", nil), http.StatusConflict; got != want {
+		t.Fatalf("invalid status code for ack without control token: got=%d, want=%d", got, want)
+	}
+	if got, want := ack("disk", &http.Cookie{Name: controlCookie, Value: secret}), http.StatusOK; got != want {
+		t.Fatalf("invalid status code for ack of control token holder: got=%d, want=%d", got, want)
+	}
+
+	acked := make(map[string]bool)
+	for _, alarm := range rc.Alarms() {
+		acked[alarm.ID] = alarm.Acked
+	}
+	if want := map[string]bool{"hv": false, "disk": true}; !reflect.DeepEqual(acked, want) {
+		t.Fatalf("invalid acknowledged alarms: got=%v, want=%v", acked, want)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	creditRefresh = 1 * time.Second // interval between two credit grants of an idle input end-point
+	creditExpiry  = 3 * creditRefresh
+)
+
+// credit is the credit granted to an output end-point by its consumers.
+//
+// Each consumer grants credit for the number of data frames (and payload
+// bytes) its input queue can still hold. The producer only sends a data frame
+// once all its consumers granted enough credit for it, so the memory used by
+// each stream is bounded by the size of the input queues.
+//
+// Consumers refresh their grants periodically: the grant of a consumer that
+// was not refreshed for a while is discarded, so a vanished consumer does not
+// block its producer.
+type credit struct {
+	ts TimeSource // source of the time and timers of the process
+
+	mu     sync.Mutex
+	grants map[string]*grant // credit granted by each consumer
+	wake   chan struct{}     // closed when new credit is granted
+}
+
+type grant struct {
+	frames int64     // number of data frames that may be sent
+	fwin   int64     // maximum number of data frames granted at once
+	bytes  int64     // number of payload bytes that may be sent
+	bwin   int64     // maximum number of payload bytes granted at once (0: unbounded)
+	last   time.Time // time of the last grant
+}
+
+func newCredit(ts TimeSource) *credit {
+	return &credit{
+		ts:     ts,
+		grants: make(map[string]*grant),
+		wake:   make(chan struct{}),
+	}
+}
+
+// handle processes a credit frame received from a consumer.
+func (c *credit) handle(frame Frame) error {
+	consumer, g, err := decodeCredit(frame)
+	if err != nil {
+		return fmt.Errorf("could not decode credit: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	g.last = c.ts.Now()
+	c.grants[consumer] = &g
+	close(c.wake)
+	c.wake = make(chan struct{})
+	return nil
+}
+
+// acquire waits until all consumers granted enough credit to send n
+// messages carrying size payload bytes, and consumes that credit.
+// Messages larger than the window of a consumer are sent once its whole
+// window was granted.
+func (c *credit) acquire(ctx context.Context, n, size int) error {
+	for {
+		c.mu.Lock()
+		if c.available(int64(n), int64(size)) {
+			for _, g := range c.grants {
+				g.frames -= int64(n)
+				g.bytes -= int64(size)
+			}
+			c.mu.Unlock()
+			return nil
+		}
+		wake := c.wake
+		c.mu.Unlock()
+
+		tmr := c.ts.NewTimer(creditRefresh)
+		select {
+		case <-ctx.Done():
+			tmr.Stop()
+			return ctx.Err()
+		case <-wake:
+			tmr.Stop()
+		case <-tmr.C():
+		}
+	}
+}
+
+// available returns whether all consumers granted credit for n messages
+// carrying size payload bytes.
+// Expired grants are discarded.
+func (c *credit) available(n, size int64) bool {
+	now := c.ts.Now()
+	for k, g := range c.grants {
+		if now.Sub(g.last) > creditExpiry {
+			delete(c.grants, k)
+			continue
+		}
+		if g.frames < min64(n, g.fwin) {
+			return false
+		}
+		if g.bwin > 0 && g.bytes < min64(size, g.bwin) {
+			return false
+		}
+	}
+	return true
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// crediter grants credit to the producer of an input end-point.
+type crediter struct {
+	name  string // name of the consumer
+	ep    string // name of the input end-point
+	sck   Sender // socket connected to the credit socket of the producer
+	bytes int64  // maximum number of queued payload bytes (0: unbounded)
+}
+
+// grant grants credit for the provided number of data frames, out of
+// a window of fwin frames, and payload bytes, given the number of
+// currently queued payload bytes.
+func (c *crediter) grant(frames, fwin int, queued int64) error {
+	var bytes int64
+	if c.bytes > 0 {
+		bytes = c.bytes - queued
+		if bytes < 0 {
+			bytes = 0
+		}
+	}
+	err := c.sck.Send(encodeCredit(c.ep, c.name, grant{
+		frames: int64(frames),
+		fwin:   int64(fwin),
+		bytes:  bytes,
+		bwin:   c.bytes,
+	}))
+	if err != nil {
+		return fmt.Errorf("could not grant credit for %q: %w", c.ep, err)
+	}
+	return nil
+}
+
+func (c *crediter) close() error {
+	if c, ok := c.sck.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func encodeCredit(ep, consumer string, g grant) []byte {
+	eb := getEncBuffer()
+	defer eb.release()
+	enc := &eb.enc
+	enc.WriteStr(consumer)
+	enc.WriteI64(g.frames)
+	enc.WriteI64(g.fwin)
+	enc.WriteI64(g.bytes)
+	enc.WriteI64(g.bwin)
+	return Frame{Type: FrameOK, Path: ep, Body: eb.buf.Bytes()}.encode()
+}
+
+func decodeCredit(frame Frame) (string, grant, error) {
+	var (
+		g   grant
+		dec = NewDecoder(bytes.NewReader(frame.Body))
+	)
+	consumer := dec.ReadStr()
+	g.frames = dec.ReadI64()
+	g.fwin = dec.ReadI64()
+	g.bytes = dec.ReadI64()
+	g.bwin = dec.ReadI64()
+	return consumer, g, dec.Err()
+}
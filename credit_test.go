@@ -0,0 +1,82 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type creditSender struct {
+	crd *credit
+}
+
+func (s creditSender) Send(msg []byte) error {
+	frame, err := RecvFrame(context.Background(), rawMsg(msg))
+	if err != nil {
+		return err
+	}
+	return s.crd.handle(frame)
+}
+
+func TestCredit(t *testing.T) {
+	var (
+		crd = newCredit(wallClock{})
+		cns = &crediter{name: "sink", ep: "/adc", sck: creditSender{crd}, bytes: 10}
+		ctx = context.Background()
+	)
+
+	// no consumer granted credit yet: frames flow freely.
+	err := crd.acquire(ctx, 1, 100)
+	if err != nil {
+		t.Fatalf("could not acquire credit: %+v", err)
+	}
+
+	err = cns.grant(2, 4, 0)
+	if err != nil {
+		t.Fatalf("could not grant credit: %+v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = crd.acquire(ctx, 1, 4)
+		if err != nil {
+			t.Fatalf("could not acquire credit %d: %+v", i, err)
+		}
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = crd.acquire(tctx, 1, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected credit exhaustion, got: %+v", err)
+	}
+
+	// frames larger than the byte window are sent once the whole window
+	// was granted.
+	err = cns.grant(4, 4, 0)
+	if err != nil {
+		t.Fatalf("could not grant credit: %+v", err)
+	}
+	err = crd.acquire(ctx, 1, 100)
+	if err != nil {
+		t.Fatalf("could not acquire credit: %+v", err)
+	}
+
+	tctx, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = crd.acquire(tctx, 1, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected byte credit exhaustion, got: %+v", err)
+	}
+
+	// grants of vanished consumers expire.
+	crd.grants["sink"].last = time.Now().Add(-2 * creditExpiry)
+	err = crd.acquire(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("could not acquire credit after expiry: %+v", err)
+	}
+}
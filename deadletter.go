@@ -0,0 +1,209 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/log"
+	"go.nanomsg.org/mangos/v3"
+)
+
+// ErrNoConsumer is returned when a data frame could not be delivered
+// because no consumer was connected to its output end-point.
+var ErrNoConsumer = errors.New("tdaq: no consumer connected")
+
+// dlq handles the data frames that could not be delivered, according to
+// a dead-letter policy.
+//
+// Whatever the policy, dead letters are counted and the counters are
+// published as monitoring variables.
+type dlq struct {
+	policy string
+	msg    log.MsgStream
+	mon    func(name string, v float64)
+
+	n uint64 // number of dead letters (atomic)
+
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	port *oport // output port of the redirect policy
+}
+
+func newDLQ(cfg config.DeadLetter, msg log.MsgStream, mon func(name string, v float64)) (*dlq, error) {
+	q := &dlq{policy: cfg.Policy, msg: msg, mon: mon}
+	switch cfg.Policy {
+	case config.DeadLetterDrop:
+	case config.DeadLetterSpill:
+		if cfg.File == "" {
+			return nil, fmt.Errorf("tdaq: dead-letter policy %q requires a spill file", cfg.Policy)
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open dead-letter spill file: %w", err)
+		}
+		q.f = f
+		q.w = bufio.NewWriter(f)
+	case config.DeadLetterRedirect:
+		if cfg.EndPoint == "" {
+			return nil, fmt.Errorf("tdaq: dead-letter policy %q requires an end-point", cfg.Policy)
+		}
+	default:
+		return nil, fmt.Errorf("tdaq: invalid dead-letter policy %q", cfg.Policy)
+	}
+	return q, nil
+}
+
+// handle handles the provided undeliverable frame of the named end-point.
+func (q *dlq) handle(ep string, frame Frame, cause error) error {
+	n := atomic.AddUint64(&q.n, 1)
+	if n == 1 {
+		q.msg.Warnf("could not deliver data frame for %q (policy=%s): %+v", ep, q.policy, cause)
+	}
+	q.mon("/dead-letters", float64(n))
+
+	switch q.policy {
+	case config.DeadLetterSpill:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return writeSpill(q.w, frame)
+
+	case config.DeadLetterRedirect:
+		q.mu.Lock()
+		port := q.port
+		q.mu.Unlock()
+		if port == nil {
+			return fmt.Errorf("could not redirect data frame for %q: no dead-letter end-point", ep)
+		}
+		err := port.sendFrame(Frame{Type: FrameData, Path: port.name, Body: frame.encode()})
+		if err != nil {
+			return fmt.Errorf("could not redirect data frame for %q: %w", ep, err)
+		}
+	}
+	return nil
+}
+
+// count returns the number of dead letters.
+func (q *dlq) count() uint64 {
+	return atomic.LoadUint64(&q.n)
+}
+
+func (q *dlq) setPort(port *oport) {
+	q.mu.Lock()
+	q.port = port
+	q.mu.Unlock()
+}
+
+// flush flushes the spill file, if any.
+func (q *dlq) flush() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.w == nil {
+		return nil
+	}
+	return q.w.Flush()
+}
+
+func (q *dlq) close() error {
+	err := q.flush()
+	if q.f == nil {
+		return err
+	}
+	if e := q.f.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// writeSpill appends the provided frame to a spill file.
+func writeSpill(w io.Writer, frame Frame) error {
+	enc := NewEncoder(w)
+	enc.WriteBytes(frame.encode())
+	if err := enc.Err(); err != nil {
+		return fmt.Errorf("could not write data frame to spill file: %w", err)
+	}
+	return nil
+}
+
+// SpillReader reads data frames from a spill file.
+type SpillReader struct {
+	r *bufio.Reader
+}
+
+// NewSpillReader returns a reader of the data frames of the provided spill file.
+func NewSpillReader(r io.Reader) *SpillReader {
+	return &SpillReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next data frame of the spill file.
+// Next returns io.EOF when no more frames are available.
+func (sr *SpillReader) Next() (Frame, error) {
+	if _, err := sr.r.Peek(1); err != nil {
+		return Frame{}, err
+	}
+
+	dec := NewDecoder(sr.r)
+	raw := dec.ReadBytes()
+	if err := dec.Err(); err != nil {
+		return Frame{}, fmt.Errorf("could not read data frame from spill file: %w", err)
+	}
+	return RecvFrame(context.Background(), rawMsg(raw))
+}
+
+// rawMsg is a Recver of a single, already received, message.
+type rawMsg []byte
+
+func (msg rawMsg) Recv() ([]byte, error) { return msg, nil }
+
+// peers tracks the number of consumers connected to an output port.
+type peers struct {
+	n   int32  // number of connected consumers (atomic)
+	gen uint32 // number of consumer connections so far (atomic)
+}
+
+func (p *peers) hook(evt mangos.PipeEvent, pipe mangos.Pipe) {
+	switch evt {
+	case mangos.PipeEventAttached:
+		atomic.AddUint32(&p.gen, 1)
+		atomic.AddInt32(&p.n, 1)
+	case mangos.PipeEventDetached:
+		atomic.AddInt32(&p.n, -1)
+	}
+}
+
+// connected returns whether at least one consumer is connected.
+func (p *peers) connected() bool {
+	return atomic.LoadInt32(&p.n) > 0
+}
+
+// attached returns the number of consumer connections so far.
+func (p *peers) attached() uint32 {
+	return atomic.LoadUint32(&p.gen)
+}
+
+// wait waits up to timeout for at least one consumer to be connected.
+func (p *peers) wait(timeout time.Duration) bool {
+	if atomic.LoadInt32(&p.n) > 0 {
+		return true
+	}
+	const poll = 10 * time.Millisecond
+	for beg := time.Now(); time.Since(beg) < timeout; {
+		time.Sleep(poll)
+		if atomic.LoadInt32(&p.n) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestDeadLetterSpill(t *testing.T) {
+	f, err := ioutil.TempFile("", "tdaq-spill-")
+	if err != nil {
+		t.Fatalf("could not create spill file: %+v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	var (
+		msg  = log.NewMsgStream("dlq", log.LvlError, ioutil.Discard)
+		mons []float64
+		mon  = func(name string, v float64) { mons = append(mons, v) }
+	)
+
+	q, err := newDLQ(config.DeadLetter{Policy: config.DeadLetterSpill, File: f.Name()}, msg, mon)
+	if err != nil {
+		t.Fatalf("could not create dead-letter queue: %+v", err)
+	}
+
+	want := []Frame{
+		{Type: FrameData, Path: "/adc", Body: []byte("adc-1")},
+		{Type: FrameData, Path: "/adc"},
+		{Type: FrameData, Path: "/tdc", Body: []byte("tdc-1")},
+	}
+	for _, frame := range want {
+		err = q.handle(frame.Path, frame, ErrNoConsumer)
+		if err != nil {
+			t.Fatalf("could not handle dead letter: %+v", err)
+		}
+	}
+	err = q.close()
+	if err != nil {
+		t.Fatalf("could not close dead-letter queue: %+v", err)
+	}
+
+	if got, want := q.count(), uint64(len(want)); got != want {
+		t.Fatalf("invalid dead-letter count: got=%d, want=%d", got, want)
+	}
+	if got, want := mons, []float64{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid dead-letter monitoring:\ngot = %v\nwant= %v", got, want)
+	}
+
+	r, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatalf("could not open spill file: %+v", err)
+	}
+	defer r.Close()
+
+	var (
+		got []Frame
+		sr  = NewSpillReader(r)
+	)
+	for {
+		frame, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read spill file: %+v", err)
+		}
+		got = append(got, frame)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid spilled frames:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+}
+
+func TestDeadLetterConfig(t *testing.T) {
+	msg := log.NewMsgStream("dlq", log.LvlError, ioutil.Discard)
+	mon := func(string, float64) {}
+	for _, tt := range []struct {
+		cfg config.DeadLetter
+		err string
+	}{
+		{cfg: config.DeadLetter{Policy: config.DeadLetterDrop}},
+		{cfg: config.DeadLetter{Policy: config.DeadLetterRedirect, EndPoint: "/errors"}},
+		{
+			cfg: config.DeadLetter{Policy: config.DeadLetterSpill},
+			err: `tdaq: dead-letter policy "spill" requires a spill file`,
+		},
+		{
+			cfg: config.DeadLetter{Policy: config.DeadLetterRedirect},
+			err: `tdaq: dead-letter policy "redirect" requires an end-point`,
+		},
+		{
+			cfg: config.DeadLetter{Policy: "ignore"},
+			err: `tdaq: invalid dead-letter policy "ignore"`,
+		},
+	} {
+		t.Run(tt.cfg.Policy, func(t *testing.T) {
+			q, err := newDLQ(tt.cfg, msg, mon)
+			switch {
+			case err != nil && tt.err == "":
+				t.Fatalf("unexpected error: %+v", err)
+			case err != nil:
+				if got, want := err.Error(), tt.err; got != want {
+					t.Fatalf("invalid error:\ngot = %v\nwant= %v", got, want)
+				}
+				return
+			case tt.err != "":
+				t.Fatalf("expected an error (%s)", tt.err)
+			}
+			defer q.close()
+
+			err = q.handle("/adc", Frame{Type: FrameData, Path: "/adc"}, ErrNoConsumer)
+			if tt.cfg.Policy == config.DeadLetterRedirect {
+				if err == nil {
+					t.Fatalf("expected an error for redirect without output port")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not handle dead letter: %+v", err)
+			}
+		})
+	}
+}
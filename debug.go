@@ -0,0 +1,171 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPProfAddr is the default address of the net/http/pprof server of
+// TDAQ processes, when enabled at runtime with "/debug pprof on".
+const DefaultPProfAddr = "localhost:0"
+
+// pprofSrv serves the net/http/pprof end-points of a TDAQ process.
+type pprofSrv struct {
+	mu  sync.Mutex
+	srv *http.Server
+	lis net.Listener
+}
+
+// start starts serving the profiles on the provided address, and returns
+// the address the server listens on.
+// start is a no-op if the server is already running.
+func (p *pprofSrv) start(addr string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.srv != nil {
+		return p.lis.Addr().String(), nil
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("could not listen for pprof server on %q: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	p.lis = lis
+	p.srv = &http.Server{Handler: mux}
+	go func(srv *http.Server) {
+		_ = srv.Serve(lis)
+	}(p.srv)
+
+	return lis.Addr().String(), nil
+}
+
+// stop stops serving the profiles.
+// stop is a no-op if the server is not running.
+func (p *pprofSrv) stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.srv == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := p.srv.Shutdown(ctx)
+	p.srv = nil
+	p.lis = nil
+	if err != nil {
+		return fmt.Errorf("could not stop pprof server: %w", err)
+	}
+	return nil
+}
+
+// onDebug handles the /debug command.
+// The payload of the command holds its arguments:
+//   - "pprof on [addr]": start serving net/http/pprof, on addr or on the
+//     configured pprof address,
+//   - "pprof off": stop serving net/http/pprof.
+//
+// The address of the pprof server is sent back in the body of the reply.
+func (srv *Server) onDebug(ctx Context, resp *Frame, req Frame) error {
+	var body []byte
+	if len(req.Body) > 0 {
+		body = req.Body[1:] // skip command type.
+	}
+	args := strings.Fields(string(body))
+	if len(args) < 2 || args[0] != "pprof" {
+		return fmt.Errorf("invalid /debug command %q (want: pprof on|off [addr])", body)
+	}
+
+	switch args[1] {
+	case "on":
+		addr := srv.cfg.PProf
+		switch {
+		case len(args) > 2:
+			addr = args[2]
+		case addr == "":
+			addr = DefaultPProfAddr
+		}
+		addr, err := srv.pprof.start(addr)
+		if err != nil {
+			return err
+		}
+		ctx.Msg.Infof("pprof server listening on %q", addr)
+		resp.Body = []byte(addr)
+		return nil
+
+	case "off":
+		err := srv.pprof.stop()
+		if err != nil {
+			return err
+		}
+		ctx.Msg.Infof("pprof server stopped")
+		return nil
+
+	default:
+		return fmt.Errorf("invalid /debug pprof argument %q (want: on|off)", args[1])
+	}
+}
+
+// Debug sends the /debug command, with the provided arguments (e.g.
+// "pprof on"), to the named TDAQ processes or, if none is named, to all the
+// connected TDAQ processes.
+// The replies of the processes are available from Replies(CmdDebug).
+func (rc *RunControl) Debug(ctx context.Context, args string, procs ...string) (err error) {
+	err = rc.control(ctx, CmdDebug.String(), rc.targets(procs), []string{args})
+	if err != nil {
+		return err
+	}
+
+	defer func(beg time.Time, recs, targets []string) {
+		rc.record(CmdDebug.String(), recs, beg, err)
+		rc.audit(Operator(ctx), CmdDebug.String(), targets, []string{args}, beg, err)
+	}(time.Now(), append([]string{args}, procs...), rc.targets(procs))
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if len(procs) == 0 {
+		procs = rc.clients.names()
+	}
+
+	rc.replies.reset(CmdDebug)
+
+	var errs []error
+	for _, name := range procs {
+		cli := rc.clients.get(name)
+		if cli == nil {
+			errs = append(errs, fmt.Errorf("unknown tdaq process %q", name))
+			continue
+		}
+		err := rc.request(ctx, cli, CmdDebug, []byte(args))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not run /debug on %q: %w", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
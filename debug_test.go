@@ -0,0 +1,67 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+func TestServerDebug(t *testing.T) {
+	srv := New(config.Process{Name: "dev", PProf: "localhost:0"}, ioutil.Discard)
+	defer srv.pprof.stop()
+
+	ctx := Context{Ctx: context.Background(), Msg: srv.msg}
+	debug := func(args string) (Frame, error) {
+		var resp Frame
+		err := srv.onDebug(ctx, &resp, Frame{Type: FrameCmd, Body: append([]byte{byte(CmdDebug)}, args...)})
+		return resp, err
+	}
+
+	resp, err := debug("pprof on")
+	if err != nil {
+		t.Fatalf("could not start pprof server: %+v", err)
+	}
+	addr := string(resp.Body)
+
+	cli := http.Client{Timeout: 5 * time.Second}
+	hresp, err := cli.Get("http://" + addr + "/debug/pprof/cmdline")
+	if err != nil {
+		t.Fatalf("could not get pprof cmdline: %+v", err)
+	}
+	hresp.Body.Close()
+	if got, want := hresp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("invalid pprof status code: got=%d, want=%d", got, want)
+	}
+
+	resp, err = debug("pprof on")
+	if err != nil {
+		t.Fatalf("could not restart pprof server: %+v", err)
+	}
+	if got, want := string(resp.Body), addr; got != want {
+		t.Fatalf("invalid pprof address: got=%q, want=%q", got, want)
+	}
+
+	_, err = debug("pprof off")
+	if err != nil {
+		t.Fatalf("could not stop pprof server: %+v", err)
+	}
+	_, err = cli.Get("http://" + addr + "/debug/pprof/cmdline")
+	if err == nil {
+		t.Fatalf("expected an error reaching a stopped pprof server")
+	}
+
+	for _, args := range []string{"", "pprof", "pprof maybe", "trace on"} {
+		_, err = debug(args)
+		if err == nil {
+			t.Fatalf("expected an error for /debug %q", args)
+		}
+	}
+}
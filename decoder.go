@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"time"
 )
 
 // Decoder decodes values from the underlying io.Reader, according to the TDAQ wire protocol.
@@ -16,6 +17,8 @@ type Decoder struct {
 	r   io.Reader
 	err error
 	buf []byte
+
+	compact bool // whether lengths are encoded as varints
 }
 
 // NewDecoder creates a new decoder connected to the provided io.Reader.
@@ -23,6 +26,10 @@ func NewDecoder(r io.Reader) *Decoder {
 	return &Decoder{r: r, buf: make([]byte, 8)}
 }
 
+// SetCompact sets whether the lengths of strings and of marshaled values
+// are decoded as varints (compact mode) or as fixed-width integers.
+func (dec *Decoder) SetCompact(v bool) { dec.compact = v }
+
 // Decode decodes a value from the underlying io.Reader into the provided pointer.
 func (dec *Decoder) Decode(ptr interface{}) error {
 	if dec.err != nil {
@@ -30,7 +37,12 @@ func (dec *Decoder) Decode(ptr interface{}) error {
 	}
 
 	if v, ok := ptr.(Unmarshaler); ok {
-		n := dec.ReadU64()
+		var n uint64
+		if dec.compact {
+			n = dec.ReadUvarint()
+		} else {
+			n = dec.ReadU64()
+		}
 		if dec.err != nil {
 			return dec.err
 		}
@@ -70,6 +82,14 @@ func (dec *Decoder) Decode(ptr interface{}) error {
 		*v = dec.ReadF64()
 	case *string:
 		*v = dec.ReadStr()
+	case *time.Time:
+		*v = dec.ReadTime()
+	case *[]byte:
+		*v = dec.ReadBytes()
+	case *[]string:
+		*v = dec.ReadStrs()
+	case *map[string]string:
+		*v = dec.ReadStrMap()
 	default:
 		return fmt.Errorf("invalid value-type=%T", v)
 	}
@@ -141,12 +161,105 @@ func (dec *Decoder) ReadF64() float64 {
 	return math.Float64frombits(binary.LittleEndian.Uint64(dec.buf[:8]))
 }
 
+// ReadUvarint reads a variable-length unsigned integer.
+func (dec *Decoder) ReadUvarint() uint64 {
+	if dec.err != nil {
+		return 0
+	}
+	var v uint64
+	v, dec.err = binary.ReadUvarint(dec)
+	return v
+}
+
+// ReadVarint reads a variable-length zig-zag encoded signed integer.
+func (dec *Decoder) ReadVarint() int64 {
+	if dec.err != nil {
+		return 0
+	}
+	var v int64
+	v, dec.err = binary.ReadVarint(dec)
+	return v
+}
+
+// ReadByte implements io.ByteReader.
+func (dec *Decoder) ReadByte() (byte, error) {
+	dec.load(1)
+	return dec.buf[0], dec.err
+}
+
+// readLen reads the length of a string, slice or map.
+// readLen returns -1 for invalid lengths.
+func (dec *Decoder) readLen() int {
+	var n int64
+	if dec.compact {
+		v := dec.ReadUvarint()
+		if v >= math.MaxInt32 {
+			return -1
+		}
+		n = int64(v)
+	} else {
+		n = int64(dec.ReadI32())
+	}
+	if n < 0 || dec.err != nil || n >= math.MaxInt32 {
+		return -1
+	}
+	return int(n)
+}
+
 func (dec *Decoder) ReadStr() string {
-	n := dec.ReadI32()
-	if n <= 0 || dec.err != nil || uint32(n) >= math.MaxInt32 {
+	n := dec.readLen()
+	if n <= 0 {
 		return ""
 	}
 	str := make([]byte, n)
 	_, dec.err = io.ReadFull(dec.r, str)
 	return string(str)
 }
+
+// ReadTime reads a time encoded as a number of nanoseconds since the Unix epoch.
+// The returned time is in UTC, or the zero time if math.MinInt64 was read.
+func (dec *Decoder) ReadTime() time.Time {
+	n := dec.ReadI64()
+	if dec.err != nil || n == math.MinInt64 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// ReadBytes reads a length-prefixed slice of bytes.
+func (dec *Decoder) ReadBytes() []byte {
+	n := dec.readLen()
+	if n <= 0 {
+		return nil
+	}
+	v := make([]byte, n)
+	_, dec.err = io.ReadFull(dec.r, v)
+	return v
+}
+
+// ReadStrs reads a length-prefixed slice of strings.
+func (dec *Decoder) ReadStrs() []string {
+	n := dec.readLen()
+	if n <= 0 {
+		return nil
+	}
+	var v []string
+	for i := 0; i < n && dec.err == nil; i++ {
+		v = append(v, dec.ReadStr())
+	}
+	return v
+}
+
+// ReadStrMap reads a length-prefixed map of strings.
+func (dec *Decoder) ReadStrMap() map[string]string {
+	n := dec.readLen()
+	if n <= 0 {
+		return nil
+	}
+	v := make(map[string]string)
+	for i := 0; i < n && dec.err == nil; i++ {
+		k := dec.ReadStr()
+		v[k] = dec.ReadStr()
+	}
+	return v
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"sync"
+)
+
+// Dedup enables the suppression of duplicate data frames on an input
+// end-point, such as the frames retransmitted by a producer after a
+// reconnection, when delivery upstream is at-least-once.
+//
+// Data frames are identified by their event ID: a frame whose ID was seen
+// among the last window IDs of the run is silently dropped, and counted in
+// the Dups counter of the end-point.
+// Data frames without an event ID are never dropped.
+// Acknowledged data frames are always deduplicated, by sequence number.
+func Dedup(window int) InputOption {
+	return func(w *inputWorkers) {
+		w.window = window
+	}
+}
+
+// dedup is a sliding window of the IDs of the last data frames received by
+// an input end-point.
+// It is shared by all the connections serving the end-point, so it survives
+// reconnections.
+type dedup struct {
+	mu   sync.Mutex
+	seen map[uint64]struct{}
+	ids  []uint64 // ring buffer of the IDs of the window
+	pos  int      // position of the next ID in the ring buffer
+}
+
+func newDedup(window int) *dedup {
+	return &dedup{
+		seen: make(map[uint64]struct{}, window),
+		ids:  make([]uint64, 0, window),
+	}
+}
+
+// dup records the ID of the provided data frame, and reports whether it
+// was already seen within the window.
+// A nil dedup reports no duplicates.
+func (d *dedup) dup(frame Frame) bool {
+	if d == nil || frame.EventID == 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := frame.EventID
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+	d.seen[id] = struct{}{}
+
+	if len(d.ids) < cap(d.ids) {
+		d.ids = append(d.ids, id)
+		return false
+	}
+	delete(d.seen, d.ids[d.pos])
+	d.ids[d.pos] = id
+	d.pos = (d.pos + 1) % len(d.ids)
+	return false
+}
+
+// reset forgets all the IDs of the window, at the start of a run.
+func (d *dedup) reset() {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id := range d.seen {
+		delete(d.seen, id)
+	}
+	d.ids = d.ids[:0]
+	d.pos = 0
+}
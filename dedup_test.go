@@ -0,0 +1,78 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestDedup(t *testing.T) {
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("dedup", log.LvlError, ioutil.Discard),
+	}
+
+	var (
+		got []uint64
+		hs  = map[string]InputHandler{
+			"/adc": func(ctx Context, src Frame) error {
+				got = append(got, src.EventID)
+				return nil
+			},
+		}
+		st = newRunStats()
+		w  = inputWorkers{}
+	)
+	Dedup(3)(&w)
+	err := w.resolve("/adc")
+	if err != nil {
+		t.Fatalf("could not resolve workers: %+v", err)
+	}
+
+	dispatch := func(ids ...uint64) {
+		// each connection has its own demux: the window survives reconnections.
+		mux := newDemux(ctx, []string{"/adc"}, hs, map[string]inputWorkers{"/adc": w}, func(string) int { return 1 }, 0, nil, nil, nil, st)
+		for _, id := range ids {
+			mux.dispatch(ctx, tagFrame(Frame{Type: FrameData, Path: "/adc", Body: []byte("data"), EventID: id}))
+		}
+		mux.dispatch(ctx, Frame{Type: FrameEOF})
+		mux.close()
+	}
+	dispatch(1, 2, 2, 0, 0)
+	dispatch(2, 3, 4, 5, 1)
+
+	if want := []uint64{1, 2, 0, 0, 3, 4, 5, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid received frames:\ngot = %v\nwant= %v", got, want)
+	}
+	stats := st.snapshot()
+	if got, want := stats.Inputs[0].Dups, uint64(2); got != want {
+		t.Fatalf("invalid number of duplicates: got=%d, want=%d", got, want)
+	}
+	if got, want := stats.Inputs[0].Frames, uint64(8); got != want {
+		t.Fatalf("invalid number of frames: got=%d, want=%d", got, want)
+	}
+
+	st.reset()
+	w.dups.reset()
+	got = nil
+	dispatch(5, 5)
+	if want := []uint64{5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid received frames after reset:\ngot = %v\nwant= %v", got, want)
+	}
+	if got, want := st.snapshot().Inputs[0].Dups, uint64(1); got != want {
+		t.Fatalf("invalid number of duplicates after reset: got=%d, want=%d", got, want)
+	}
+
+	w = inputWorkers{}
+	Dedup(-1)(&w)
+	if err := w.resolve("/adc"); err == nil {
+		t.Fatalf("expected an error for a negative window")
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/go-daq/tdaq/conditions"
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/flags"
+)
+
+// Device is a TDAQ device, handling the run-control commands.
+//
+// Devices may also implement the Inputer, Outputer, Runner, Servicer,
+// Preparer, Reconfigurer and Checkpointer interfaces, to declare their input
+// and output end-points, their run loop, their services, their votes on FSM
+// transitions, how they apply configuration updates and how their state is
+// saved and restored.
+type Device interface {
+	OnConfig(ctx Context, resp *Frame, req Frame) error
+	OnInit(ctx Context, resp *Frame, req Frame) error
+	OnReset(ctx Context, resp *Frame, req Frame) error
+	OnStart(ctx Context, resp *Frame, req Frame) error
+	OnStop(ctx Context, resp *Frame, req Frame) error
+	OnQuit(ctx Context, resp *Frame, req Frame) error
+}
+
+// Inputer is implemented by devices with input end-points.
+type Inputer interface {
+	// Inputs returns the handlers of the input end-points, indexed by
+	// end-point name.
+	Inputs() map[string]InputHandler
+}
+
+// Outputer is implemented by devices with output end-points.
+type Outputer interface {
+	// Outputs returns the handlers of the output end-points, indexed by
+	// end-point name.
+	Outputs() map[string]OutputHandler
+}
+
+// Runner is implemented by devices with a run loop.
+type Runner interface {
+	// Run is run for the duration of each run.
+	Run(ctx Context) error
+}
+
+// ServeOption configures how a device is served.
+type ServeOption func(o *serveOptions)
+
+type serveOptions struct {
+	cfg    *config.Process
+	stdout io.Writer
+	ctx    context.Context
+
+	cmds map[string]CmdHandler
+	ieps map[string]InputHandler
+	oeps map[string]OutputHandler
+	svcs map[string]ServiceHandler
+	runs []RunHandler
+
+	conds conditions.DB
+}
+
+// WithConfig sets the configuration of the TDAQ process.
+// The default is to parse the configuration from the command line
+// with flags.New, declaring all the optional flags of tdaq processes.
+func WithConfig(cfg config.Process) ServeOption {
+	return func(o *serveOptions) {
+		o.cfg = &cfg
+	}
+}
+
+// WithStdout sets where the messages of the TDAQ process are written.
+// The default is os.Stdout.
+func WithStdout(w io.Writer) ServeOption {
+	return func(o *serveOptions) {
+		o.stdout = w
+	}
+}
+
+// WithContext sets the context of the TDAQ process.
+func WithContext(ctx context.Context) ServeOption {
+	return func(o *serveOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithCmd sets the handler of the named run-control command,
+// overriding the one of the device.
+func WithCmd(name string, h CmdHandler) ServeOption {
+	return func(o *serveOptions) {
+		o.cmds[name] = h
+	}
+}
+
+// WithInput declares an input end-point, in addition to the ones
+// of the device.
+func WithInput(name string, h InputHandler) ServeOption {
+	return func(o *serveOptions) {
+		o.ieps[name] = h
+	}
+}
+
+// WithOutput declares an output end-point, in addition to the ones
+// of the device.
+func WithOutput(name string, h OutputHandler) ServeOption {
+	return func(o *serveOptions) {
+		o.oeps[name] = h
+	}
+}
+
+// WithService declares a service, in addition to the ones of the device.
+func WithService(name string, h ServiceHandler) ServeOption {
+	return func(o *serveOptions) {
+		o.svcs[name] = h
+	}
+}
+
+// WithConditions sets the conditions database of the TDAQ process,
+// overriding the one of its configuration.
+func WithConditions(db conditions.DB) ServeOption {
+	return func(o *serveOptions) {
+		o.conds = db
+	}
+}
+
+// WithRun adds a run loop, in addition to the one of the device.
+func WithRun(f RunHandler) ServeOption {
+	return func(o *serveOptions) {
+		o.runs = append(o.runs, f)
+	}
+}
+
+// Register registers the command handlers of the provided device, as well
+// as its input and output end-points and its run loop, if any.
+func (srv *Server) Register(dev Device) {
+	for name, h := range deviceCmds(dev) {
+		srv.CmdHandle(name, h)
+	}
+	registerEndPoints(srv, dev)
+}
+
+func deviceCmds(dev Device) map[string]CmdHandler {
+	return map[string]CmdHandler{
+		"/config": dev.OnConfig,
+		"/init":   dev.OnInit,
+		"/reset":  dev.OnReset,
+		"/start":  dev.OnStart,
+		"/stop":   dev.OnStop,
+		"/quit":   dev.OnQuit,
+	}
+}
+
+func registerEndPoints(srv *Server, dev Device) {
+	if dev, ok := dev.(Inputer); ok {
+		for name, h := range dev.Inputs() {
+			srv.InputHandle(name, h)
+		}
+	}
+	if dev, ok := dev.(Outputer); ok {
+		for name, h := range dev.Outputs() {
+			srv.OutputHandle(name, h)
+		}
+	}
+	if dev, ok := dev.(Runner); ok {
+		srv.RunHandle(dev.Run)
+	}
+	if dev, ok := dev.(Servicer); ok {
+		for name, h := range dev.Services() {
+			srv.Serve(name, h)
+		}
+	}
+	if dev, ok := dev.(Preparer); ok {
+		srv.PrepareHandle(dev.Prepare)
+		srv.AbortHandle(dev.Abort)
+	}
+	if dev, ok := dev.(Reconfigurer); ok {
+		srv.ReconfigHandle(dev.OnReconfig, dev.ReconfigRunning())
+	}
+	if dev, ok := dev.(Checkpointer); ok {
+		srv.Checkpoint(dev.Checkpoint)
+		srv.Restore(dev.Restore)
+	}
+}
+
+// Serve runs a TDAQ process serving the provided device, until run-control
+// terminates it.
+//
+// Serve registers the command handlers of the device, its end-points and
+// its run loop, as well as those provided with options.
+func Serve(dev Device, opts ...ServeOption) error {
+	o := serveOptions{
+		stdout: os.Stdout,
+		ctx:    context.Background(),
+		cmds:   make(map[string]CmdHandler),
+		ieps:   make(map[string]InputHandler),
+		oeps:   make(map[string]OutputHandler),
+		svcs:   make(map[string]ServiceHandler),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.cfg == nil {
+		cfg := flags.New(flags.WithAllFlags())
+		o.cfg = &cfg
+	}
+
+	srv := New(*o.cfg, o.stdout)
+	o.register(srv, dev)
+	return srv.Run(o.ctx)
+}
+
+func (o *serveOptions) register(srv *Server, dev Device) {
+	cmds := deviceCmds(dev)
+	for name, h := range o.cmds {
+		cmds[name] = h
+	}
+	for name, h := range cmds {
+		srv.CmdHandle(name, h)
+	}
+	registerEndPoints(srv, dev)
+	for name, h := range o.ieps {
+		srv.InputHandle(name, h)
+	}
+	for name, h := range o.oeps {
+		srv.OutputHandle(name, h)
+	}
+	for name, h := range o.svcs {
+		srv.Serve(name, h)
+	}
+	for _, f := range o.runs {
+		srv.RunHandle(f)
+	}
+	if o.conds != nil {
+		srv.UseConditions(o.conds)
+	}
+}
+
+// DeviceFactory creates a device from its parameters.
+type DeviceFactory func(params map[string]string) (Device, error)
+
+var devices = struct {
+	sync.RWMutex
+	db map[string]DeviceFactory
+}{
+	db: make(map[string]DeviceFactory),
+}
+
+// RegisterDevice registers the factory of the named type of device,
+// so devices of that type can be run by a generic device host.
+// RegisterDevice panics if a factory was already registered for that type.
+func RegisterDevice(typ string, f DeviceFactory) {
+	devices.Lock()
+	defer devices.Unlock()
+
+	if _, dup := devices.db[typ]; dup {
+		panic(fmt.Errorf("tdaq: duplicate device factory for %q", typ))
+	}
+	devices.db[typ] = f
+}
+
+// NewDevice creates a device of the named type, from its parameters.
+func NewDevice(typ string, params map[string]string) (Device, error) {
+	devices.RLock()
+	f, ok := devices.db[typ]
+	devices.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("tdaq: no device factory for %q (registered: %v)", typ, DeviceTypes())
+	}
+	dev, err := f(params)
+	if err != nil {
+		return nil, fmt.Errorf("could not create device %q: %w", typ, err)
+	}
+	return dev, nil
+}
+
+// DeviceTypes returns the sorted list of registered types of devices.
+func DeviceTypes() []string {
+	devices.RLock()
+	defer devices.RUnlock()
+
+	types := make([]string, 0, len(devices.db))
+	for k := range devices.db {
+		types = append(types, k)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+type testDevice struct{}
+
+func (dev *testDevice) OnConfig(ctx Context, resp *Frame, req Frame) error { return nil }
+func (dev *testDevice) OnInit(ctx Context, resp *Frame, req Frame) error   { return nil }
+func (dev *testDevice) OnReset(ctx Context, resp *Frame, req Frame) error  { return nil }
+func (dev *testDevice) OnStart(ctx Context, resp *Frame, req Frame) error  { return nil }
+func (dev *testDevice) OnStop(ctx Context, resp *Frame, req Frame) error   { return nil }
+func (dev *testDevice) OnQuit(ctx Context, resp *Frame, req Frame) error   { return nil }
+
+func (dev *testDevice) Inputs() map[string]InputHandler {
+	return map[string]InputHandler{"/in": dev.input}
+}
+
+func (dev *testDevice) Outputs() map[string]OutputHandler {
+	return map[string]OutputHandler{"/out": dev.output}
+}
+
+func (dev *testDevice) Run(ctx Context) error { return nil }
+
+func (dev *testDevice) input(ctx Context, src Frame) error   { return nil }
+func (dev *testDevice) output(ctx Context, dst *Frame) error { return nil }
+
+func TestServeRegister(t *testing.T) {
+	srv := New(config.Process{Name: "dev"}, ioutil.Discard)
+
+	o := serveOptions{
+		cmds: make(map[string]CmdHandler),
+		ieps: make(map[string]InputHandler),
+		oeps: make(map[string]OutputHandler),
+		svcs: make(map[string]ServiceHandler),
+	}
+	for _, opt := range []ServeOption{
+		WithCmd("/reset", func(ctx Context, resp *Frame, req Frame) error { return nil }),
+		WithInput("/in2", func(ctx Context, src Frame) error { return nil }),
+		WithService("/lookup", func(ctx Context, req []byte) ([]byte, error) { return req, nil }),
+		WithRun(func(ctx Context) error { return nil }),
+	} {
+		opt(&o)
+	}
+	o.register(srv, &testDevice{})
+
+	if got, want := len(srv.cmgr.ep), 6; got != want {
+		t.Fatalf("invalid number of cmd handlers: got=%d, want=%d", got, want)
+	}
+	for _, name := range []string{"/in", "/in2"} {
+		if _, ok := srv.imgr.ep[name]; !ok {
+			t.Fatalf("missing input handler %q", name)
+		}
+	}
+	if _, ok := srv.omgr.ep["/out"]; !ok {
+		t.Fatalf("missing output handler %q", "/out")
+	}
+	if got, want := len(srv.runfcts), 2; got != want {
+		t.Fatalf("invalid number of run handlers: got=%d, want=%d", got, want)
+	}
+	if _, ok := srv.svcs.hs["/lookup"]; !ok {
+		t.Fatalf("missing service handler %q", "/lookup")
+	}
+}
+
+func TestRegisterDevice(t *testing.T) {
+	RegisterDevice("test-device", func(params map[string]string) (Device, error) {
+		if params["fail"] != "" {
+			return nil, errors.New("boom")
+		}
+		return &testDevice{}, nil
+	})
+
+	dev, err := NewDevice("test-device", nil)
+	if err != nil {
+		t.Fatalf("could not create device: %+v", err)
+	}
+	if _, ok := dev.(*testDevice); !ok {
+		t.Fatalf("invalid device type %T", dev)
+	}
+
+	_, err = NewDevice("test-device", map[string]string{"fail": "1"})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	_, err = NewDevice("not-there", nil)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	func() {
+		defer func() {
+			if e := recover(); e == nil {
+				t.Fatalf("expected a panic")
+			}
+		}()
+		RegisterDevice("test-device", nil)
+	}()
+}
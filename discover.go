@@ -0,0 +1,137 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/tdaq/internal/mdns"
+)
+
+const (
+	// RunCtlAuto is the run-ctl address instructing TDAQ processes to
+	// discover run-ctl on the local network, via mDNS.
+	// "auto:<name>" selects the run-ctl advertised under that name.
+	RunCtlAuto = "auto"
+
+	mdnsService     = "_tdaq-runctl._tcp" // mDNS service type of run-ctl
+	discoverTimeout = 30 * time.Second    // maximum duration of run-ctl discovery
+)
+
+// autoRunCtl returns whether the run-ctl address requests the discovery of
+// run-ctl, and the name of the run-ctl to discover (if any).
+func autoRunCtl(addr string) (string, bool) {
+	switch {
+	case addr == RunCtlAuto:
+		return "", true
+	case strings.HasPrefix(addr, RunCtlAuto+":"):
+		name := strings.TrimPrefix(addr, RunCtlAuto+":")
+		if _, err := strconv.Atoi(name); err == nil {
+			// "auto:44000" is a plain address for host "auto".
+			return "", false
+		}
+		return name, true
+	default:
+		return "", false
+	}
+}
+
+// discoverRunCtl returns the [addr]:port address of the run-ctl advertised
+// under the provided name (or of any run-ctl if name is empty) on the local
+// network.
+func discoverRunCtl(ctx context.Context, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoverTimeout)
+	defer cancel()
+
+	for {
+		entries, err := mdns.Lookup(ctx, mdnsService)
+		if err != nil {
+			return "", fmt.Errorf("could not discover run-ctl: %w", err)
+		}
+		for _, e := range entries {
+			if name == "" || strings.EqualFold(e.Instance, name) {
+				return e.Addr(), nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("could not discover run-ctl %q: %w", name, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// advertise advertises the address of run-ctl on the local network, via
+// mDNS, until the context is done.
+func (rc *RunControl) advertise(ctx context.Context) {
+	if !rc.cfg.MDNS {
+		return
+	}
+
+	if rc.cfg.Trans != "tcp" {
+		rc.msg.Warnf("could not advertise run-ctl via mDNS: unsupported network %q", rc.cfg.Trans)
+		return
+	}
+
+	_, sport, err := net.SplitHostPort(rc.cfg.RunCtl)
+	if err != nil {
+		rc.msg.Warnf("could not advertise run-ctl via mDNS: invalid address %q: %+v", rc.cfg.RunCtl, err)
+		return
+	}
+	port, err := strconv.Atoi(sport)
+	if err != nil {
+		rc.msg.Warnf("could not advertise run-ctl via mDNS: invalid port %q: %+v", sport, err)
+		return
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = strings.SplitN(host, ".", 2)[0]
+
+	entry := mdns.Entry{
+		Instance: rc.cfg.Name,
+		Service:  mdnsService,
+		Host:     host,
+		Port:     port,
+		Addrs:    localAddrs(),
+		Text:     []string{"trans=" + rc.cfg.Trans},
+	}
+
+	rc.msg.Infof("advertising run-ctl %q via mDNS on port %d...", entry.Instance, port)
+	err = mdns.Serve(ctx, entry)
+	if err != nil {
+		rc.msg.Warnf("could not advertise run-ctl via mDNS: %+v", err)
+	}
+}
+
+// localAddrs returns the IPv4 addresses of the non-loopback network
+// interfaces of the host.
+func localAddrs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ip := ipnet.IP.To4(); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,30 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"testing"
+)
+
+func TestAutoRunCtl(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		name string
+		auto bool
+	}{
+		{addr: ":44000"},
+		{addr: "daq-01:44000"},
+		{addr: "auto:44000"},
+		{addr: "auto", auto: true},
+		{addr: "auto:run-ctl", name: "run-ctl", auto: true},
+	} {
+		t.Run(tc.addr, func(t *testing.T) {
+			name, auto := autoRunCtl(tc.addr)
+			if name != tc.name || auto != tc.auto {
+				t.Fatalf("invalid discovery: got=(%q, %v), want=(%q, %v)", name, auto, tc.name, tc.auto)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import "fmt"
+
+func diskFree(dir string) (uint64, error) {
+	return 0, fmt.Errorf("disk space monitoring not supported")
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultParallel is the default maximum number of TDAQ processes run-ctl
+// sends a command to concurrently.
+const DefaultParallel = 64
+
+// CmdError describes the failures of TDAQ processes to run a command.
+type CmdError struct {
+	Cmd   CmdType
+	Procs map[string]error // errors of the failing processes, indexed by process name
+}
+
+func (e *CmdError) add(name string, err error) {
+	if e.Procs == nil {
+		e.Procs = make(map[string]error)
+	}
+	e.Procs[name] = err
+}
+
+// names returns the sorted names of the failing processes.
+func (e *CmdError) names() []string {
+	names := make([]string, 0, len(e.Procs))
+	for name := range e.Procs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *CmdError) Error() string {
+	names := e.names()
+	if len(names) == 1 {
+		return fmt.Sprintf("could not run %v on %q: %v", e.Cmd, names[0], e.Procs[names[0]])
+	}
+	errs := make([]string, len(names))
+	for i, name := range names {
+		errs[i] = fmt.Sprintf("%q: %v", name, e.Procs[name])
+	}
+	return fmt.Sprintf("could not run %v on %d processes: %s", e.Cmd, len(names), strings.Join(errs, "; "))
+}
+
+// Unwrap returns the error of the first failing process, by name.
+func (e *CmdError) Unwrap() error {
+	names := e.names()
+	if len(names) == 0 {
+		return nil
+	}
+	return e.Procs[names[0]]
+}
+
+// err returns e if some processes failed, nil otherwise.
+func (e *CmdError) err() error {
+	if len(e.Procs) == 0 {
+		return nil
+	}
+	return e
+}
+
+// dispatch runs f concurrently on the provided processes, with at most
+// the configured number of concurrent runs, and records the failures in
+// cerr.
+func (rc *RunControl) dispatch(clis []*client, cerr *CmdError, f func(cli *client) error) {
+	n := rc.cfg.Parallel
+	if n <= 0 {
+		n = DefaultParallel
+	}
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, n)
+	)
+	for i := range clis {
+		cli := clis[i]
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := f(cli)
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			cerr.add(cli.name, err)
+		}()
+	}
+	wg.Wait()
+}
+
+// levels splits the dependency-ordered processes into consecutive levels
+// of processes which do not consume the outputs of processes of their own
+// level, so all the processes of a level may receive a command
+// concurrently while the order of the dependencies is preserved.
+// levels must be called with rc.mu held.
+func (rc *RunControl) levels() [][]*client {
+	var (
+		lvls [][]*client
+		cur  []*client
+		outs = make(map[string]struct{}) // outputs of the processes of the current level
+	)
+	for _, name := range rc.deps {
+		cli := rc.clients.get(name)
+		if cli == nil {
+			continue
+		}
+		for _, ep := range cli.ieps {
+			if _, dep := outs[ep.Name]; dep {
+				lvls = append(lvls, cur)
+				cur = nil
+				outs = make(map[string]struct{})
+				break
+			}
+		}
+		cur = append(cur, cli)
+		for _, ep := range cli.oeps {
+			outs[ep.Name] = struct{}{}
+		}
+	}
+	if len(cur) > 0 {
+		lvls = append(lvls, cur)
+	}
+	return lvls
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+func TestDispatch(t *testing.T) {
+	const n = 20
+	rc := &RunControl{
+		cfg:     config.RunCtl{Parallel: 4},
+		clients: newClientDB(),
+	}
+	var clis []*client
+	for i := 0; i < n; i++ {
+		cli := &client{name: fmt.Sprintf("proc-%02d", i)}
+		clis = append(clis, cli)
+	}
+
+	var (
+		mu   sync.Mutex
+		cur  int
+		peak int
+		cerr = &CmdError{Cmd: CmdConfig}
+	)
+	rc.dispatch(clis, cerr, func(cli *client) error {
+		mu.Lock()
+		cur++
+		if cur > peak {
+			peak = cur
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		cur--
+		mu.Unlock()
+
+		switch cli.name {
+		case "proc-03", "proc-11":
+			return fmt.Errorf("boom-%s", cli.name)
+		}
+		return nil
+	})
+
+	if peak > rc.cfg.Parallel {
+		t.Fatalf("invalid concurrency:\ngot = %d\nwant<= %d", peak, rc.cfg.Parallel)
+	}
+
+	err := cerr.err()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), `could not run /config on 2 processes: "proc-03": boom-proc-03; "proc-11": boom-proc-11`; got != want {
+		t.Fatalf("invalid error:\ngot = %q\nwant= %q", got, want)
+	}
+	if got, want := errors.Unwrap(err).Error(), "boom-proc-03"; got != want {
+		t.Fatalf("invalid unwrapped error:\ngot = %q\nwant= %q", got, want)
+	}
+
+	ok := &CmdError{Cmd: CmdConfig}
+	rc.dispatch(clis, ok, func(cli *client) error { return nil })
+	if err := ok.err(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+}
+
+func TestLevels(t *testing.T) {
+	rc := &RunControl{
+		clients: newClientDB(
+			&client{name: "gen1", oeps: []EndPoint{{Name: "/adc1"}}},
+			&client{name: "gen2", oeps: []EndPoint{{Name: "/adc2"}}},
+			&client{name: "evb", ieps: []EndPoint{{Name: "/adc1"}, {Name: "/adc2"}}, oeps: []EndPoint{{Name: "/evt"}}},
+			&client{name: "mon", ieps: []EndPoint{{Name: "/adc1"}}},
+			&client{name: "dump", ieps: []EndPoint{{Name: "/evt"}}},
+		),
+		deps: []string{"gen1", "gen2", "evb", "mon", "dump"},
+	}
+
+	var got [][]string
+	for _, lvl := range rc.levels() {
+		var names []string
+		for _, cli := range lvl {
+			names = append(names, cli.name)
+		}
+		got = append(got, names)
+	}
+	want := [][]string{{"gen1", "gen2"}, {"evb", "mon"}, {"dump"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid levels:\ngot = %q\nwant= %q", got, want)
+	}
+}
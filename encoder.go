@@ -5,10 +5,14 @@
 package tdaq // import "github.com/go-daq/tdaq"
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
+	"sort"
+	"sync"
+	"time"
 )
 
 // Encoder encodes values to the underlying io.Writer, according to the TDAQ wire protocol.
@@ -16,16 +20,23 @@ type Encoder struct {
 	w   io.Writer
 	err error
 
-	buf []byte
+	buf     []byte
+	compact bool // whether lengths are encoded as varints
 }
 
 // NewEncoder creates a new encoder, connected to the provided io.Writer.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w, buf: make([]byte, 8)}
+	return &Encoder{w: w, buf: make([]byte, binary.MaxVarintLen64)}
 }
 
 func (enc *Encoder) Err() error { return enc.err }
 
+// SetCompact sets whether the lengths of strings and of marshaled values
+// are encoded as varints (compact mode) or as fixed-width integers.
+// Compact mode requires ProtoV2 and a Decoder in compact mode: payloads of
+// data links are only encoded in compact mode when Context.Compact reports it.
+func (enc *Encoder) SetCompact(v bool) { enc.compact = v }
+
 // Encode encodes the provided value to the underlying io.Writer.
 func (enc *Encoder) Encode(v interface{}) error {
 	if enc.err != nil {
@@ -38,7 +49,11 @@ func (enc *Encoder) Encode(v interface{}) error {
 			enc.err = err
 			return err
 		}
-		enc.WriteU64(uint64(len(raw)))
+		if enc.compact {
+			enc.WriteUvarint(uint64(len(raw)))
+		} else {
+			enc.WriteU64(uint64(len(raw)))
+		}
 		_, enc.err = enc.w.Write(raw)
 		return enc.err
 	}
@@ -68,6 +83,14 @@ func (enc *Encoder) Encode(v interface{}) error {
 		enc.WriteF64(v)
 	case string:
 		enc.WriteStr(v)
+	case time.Time:
+		enc.WriteTime(v)
+	case []byte:
+		enc.WriteBytes(v)
+	case []string:
+		enc.WriteStrs(v)
+	case map[string]string:
+		enc.WriteStrMap(v)
 	default:
 		return fmt.Errorf("value type=%T not supported", v)
 	}
@@ -168,12 +191,138 @@ func (enc *Encoder) WriteF64(v float64) {
 	_, enc.err = enc.w.Write(enc.buf[:8])
 }
 
+// WriteUvarint writes v as a variable-length unsigned integer.
+func (enc *Encoder) WriteUvarint(v uint64) {
+	if enc.err != nil {
+		return
+	}
+	n := binary.PutUvarint(enc.buf, v)
+	_, enc.err = enc.w.Write(enc.buf[:n])
+}
+
+// WriteVarint writes v as a variable-length zig-zag encoded signed integer.
+func (enc *Encoder) WriteVarint(v int64) {
+	if enc.err != nil {
+		return
+	}
+	n := binary.PutVarint(enc.buf, v)
+	_, enc.err = enc.w.Write(enc.buf[:n])
+}
+
+// writeLen writes the length of a string, slice or map.
+func (enc *Encoder) writeLen(n int) {
+	if enc.compact {
+		enc.WriteUvarint(uint64(n))
+		return
+	}
+	enc.WriteI32(int32(n))
+}
+
 func (enc *Encoder) WriteStr(v string) {
-	n := int32(len(v))
-	enc.WriteI32(n)
+	enc.writeLen(len(v))
+
+	if enc.err != nil {
+		return
+	}
+	_, enc.err = io.WriteString(enc.w, v)
+}
+
+// WriteTime writes v as a number of nanoseconds since the Unix epoch.
+// The location and monotonic clock reading of v are not preserved.
+// The zero time is written as math.MinInt64.
+func (enc *Encoder) WriteTime(v time.Time) {
+	if v.IsZero() {
+		enc.WriteI64(math.MinInt64)
+		return
+	}
+	enc.WriteI64(v.UnixNano())
+}
+
+// WriteBytes writes a length-prefixed slice of bytes.
+func (enc *Encoder) WriteBytes(v []byte) {
+	enc.writeLen(len(v))
 
 	if enc.err != nil {
 		return
 	}
-	_, enc.err = enc.w.Write([]byte(v[:n]))
+	_, enc.err = enc.w.Write(v)
+}
+
+// WriteStrs writes a length-prefixed slice of strings.
+func (enc *Encoder) WriteStrs(v []string) {
+	enc.writeLen(len(v))
+	for _, str := range v {
+		enc.WriteStr(str)
+	}
+}
+
+// WriteStrMap writes a length-prefixed map of strings.
+// Entries are written in increasing key order.
+func (enc *Encoder) WriteStrMap(v map[string]string) {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	enc.writeLen(len(v))
+	for _, k := range keys {
+		enc.WriteStr(k)
+		enc.WriteStr(v[k])
+	}
+}
+
+// tdaqEncoder is implemented by values encoding themselves with an Encoder.
+type tdaqEncoder interface {
+	encodeTDAQ(enc *Encoder)
+}
+
+// maxPooledEncBuffer is the capacity above which encoding buffers are not
+// returned to the pool, so rare large values do not pin memory.
+const maxPooledEncBuffer = 64 << 10
+
+// encBuffer is an Encoder writing to an in-memory buffer.
+// encBuffers are pooled, so encoding values does not generate garbage.
+type encBuffer struct {
+	buf bytes.Buffer
+	enc Encoder
+}
+
+var encPool = sync.Pool{
+	New: func() interface{} {
+		eb := new(encBuffer)
+		eb.enc = Encoder{w: &eb.buf, buf: make([]byte, binary.MaxVarintLen64)}
+		return eb
+	},
+}
+
+// getEncBuffer returns an empty encoding buffer from the pool.
+func getEncBuffer() *encBuffer {
+	eb := encPool.Get().(*encBuffer)
+	eb.buf.Reset()
+	eb.enc.err = nil
+	eb.enc.compact = false
+	return eb
+}
+
+// release returns the encoding buffer to the pool.
+// The encoded bytes must not be used afterwards.
+func (eb *encBuffer) release() {
+	if eb.buf.Cap() > maxPooledEncBuffer {
+		return
+	}
+	encPool.Put(eb)
+}
+
+// marshalTDAQ encodes v with a pooled encoder and returns a copy of the
+// encoded bytes.
+func marshalTDAQ(v tdaqEncoder) ([]byte, error) {
+	eb := getEncBuffer()
+	defer eb.release()
+
+	v.encodeTDAQ(&eb.enc)
+	if err := eb.enc.err; err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), eb.buf.Bytes()...), nil
 }
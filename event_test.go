@@ -0,0 +1,61 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestEventFrame(t *testing.T) {
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("events", log.LvlError, ioutil.Discard),
+	}
+
+	var (
+		got []Frame
+		hs  = map[string]InputHandler{
+			"/adc": func(ctx Context, src Frame) error {
+				got = append(got, src)
+				return nil
+			},
+		}
+		frames = []Frame{
+			{Type: FrameData, Path: "/adc", Body: []byte("evt-1"), EventID: 1},
+			{Type: FrameData, Path: "/adc", Body: []byte("evt-2"), EventID: 2},
+			{Type: FrameData, Path: "/adc", Body: []byte("evt-3"), EventID: 3, Trace: true},
+			{Type: FrameData, Path: "/adc", Body: []byte("evt-4"), EventID: 4},
+		}
+	)
+
+	mux := newDemux(ctx, []string{"/adc"}, hs, nil, func(string) int { return 1 }, 0, nil, nil, nil, nil)
+	for _, frame := range frames {
+		sampleEvent(&frame, 2)
+		frame = tagFrame(frame)
+		if frame.EventID == 3 {
+			frame = stampFrame(frame, time.Now())
+		}
+		mux.dispatch(ctx, frame)
+	}
+	mux.dispatch(ctx, Frame{Type: FrameEvent, Path: "/adc", Body: []byte("bad")})
+	mux.dispatch(ctx, Frame{Type: FrameEOF})
+	mux.close()
+
+	want := []Frame{
+		{Type: FrameData, Path: "/adc", Body: []byte("evt-1"), EventID: 1},
+		{Type: FrameData, Path: "/adc", Body: []byte("evt-2"), EventID: 2, Trace: true},
+		{Type: FrameData, Path: "/adc", Body: []byte("evt-3"), EventID: 3, Trace: true},
+		{Type: FrameData, Path: "/adc", Body: []byte("evt-4"), EventID: 4, Trace: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid event frames:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+}
@@ -0,0 +1,691 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package expr implements a small expression language, to select data
+// frames from the values of their decoded fields.
+//
+// Expressions are made of:
+//   - literals: integers (42, 0x2a), floats (4.2, 1e-3), strings ("adc", 'adc')
+//     and booleans (true, false),
+//   - identifiers, naming the variables of the evaluation environment
+//     (e.g. the fields of a decoded payload), with '.' allowed after the
+//     first character (e.g. hdr.evt),
+//   - arithmetic operators: +, -, *, /, % (and + for strings concatenation),
+//   - comparison operators: ==, !=, <, <=, >, >=,
+//   - logical operators: &&, ||, !,
+//   - the builtin functions abs(x), len(s), min(x, y) and max(x, y),
+//   - parentheses.
+//
+// Operators have the same precedence as in Go.
+//
+// ex:
+//
+//	energy > 10.5 && (ch == 3 || ch == 4) && name != "noise"
+package expr // import "github.com/go-daq/tdaq/expr"
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile compiles the provided expression.
+func Compile(src string) (*Expr, error) {
+	p := parser{lex: lexer{src: src}}
+	p.next()
+	root, err := p.parse(0)
+	if err != nil {
+		return nil, fmt.Errorf("expr: could not compile %q: %w", src, err)
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("expr: could not compile %q: unexpected %q at offset %d", src, p.tok.text, p.tok.pos)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// MustCompile is like Compile but panics if the expression can not be
+// compiled.
+func MustCompile(src string) *Expr {
+	e, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string { return e.src }
+
+// Vars returns the sorted names of the variables of the expression.
+func (e *Expr) Vars() []string {
+	set := make(map[string]bool)
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case ident:
+			set[string(n)] = true
+		case *unary:
+			walk(n.x)
+		case *binary:
+			walk(n.lhs)
+			walk(n.rhs)
+		case *call:
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(e.root)
+
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Eval evaluates the expression with the provided variables.
+// Variables are bools, strings, signed or unsigned integers and floats.
+// Eval returns a bool, an int64, a float64 or a string.
+func (e *Expr) Eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := e.root.eval(vars)
+	if err != nil {
+		return nil, fmt.Errorf("expr: could not evaluate %q: %w", e.src, err)
+	}
+	return v, nil
+}
+
+// Match evaluates the boolean expression with the provided variables.
+func (e *Expr) Match(vars map[string]interface{}) (bool, error) {
+	v, err := e.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expr: %q is not a boolean expression (got %T)", e.src, v)
+	}
+	return b, nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokStr
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+var ops = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"+", "-", "*", "/", "%", "<", ">", "!", "(", ")", ",",
+}
+
+func (lex *lexer) next() (token, error) {
+	for lex.pos < len(lex.src) && unicode.IsSpace(rune(lex.src[lex.pos])) {
+		lex.pos++
+	}
+	if lex.pos >= len(lex.src) {
+		return token{kind: tokEOF, pos: lex.pos}, nil
+	}
+
+	var (
+		beg = lex.pos
+		c   = lex.src[beg]
+	)
+	switch {
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for lex.pos < len(lex.src) {
+			c := rune(lex.src[lex.pos])
+			if c != '_' && c != '.' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				break
+			}
+			lex.pos++
+		}
+		return token{kind: tokIdent, text: lex.src[beg:lex.pos], pos: beg}, nil
+
+	case unicode.IsDigit(rune(c)) || (c == '.' && beg+1 < len(lex.src) && unicode.IsDigit(rune(lex.src[beg+1]))):
+		kind := tokInt
+		for lex.pos < len(lex.src) {
+			c := lex.src[lex.pos]
+			switch {
+			case c == '.':
+				kind = tokFloat
+			case (c == 'e' || c == 'E') && !strings.HasPrefix(lex.src[beg:], "0x"):
+				kind = tokFloat
+				if lex.pos+1 < len(lex.src) && (lex.src[lex.pos+1] == '+' || lex.src[lex.pos+1] == '-') {
+					lex.pos++
+				}
+			case c == 'x' || c == 'X' || unicode.IsDigit(rune(c)) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F'):
+			default:
+				return token{kind: kind, text: lex.src[beg:lex.pos], pos: beg}, nil
+			}
+			lex.pos++
+		}
+		return token{kind: kind, text: lex.src[beg:lex.pos], pos: beg}, nil
+
+	case c == '"' || c == '\'':
+		lex.pos++
+		for lex.pos < len(lex.src) && lex.src[lex.pos] != c {
+			if lex.src[lex.pos] == '\\' {
+				lex.pos++
+			}
+			lex.pos++
+		}
+		if lex.pos >= len(lex.src) {
+			return token{}, fmt.Errorf("unterminated string at offset %d", beg)
+		}
+		lex.pos++
+		return token{kind: tokStr, text: lex.src[beg:lex.pos], pos: beg}, nil
+	}
+
+	for _, op := range ops {
+		if strings.HasPrefix(lex.src[beg:], op) {
+			lex.pos += len(op)
+			return token{kind: tokOp, text: op, pos: beg}, nil
+		}
+	}
+	return token{}, fmt.Errorf("invalid character %q at offset %d", c, beg)
+}
+
+// precedence of binary operators, as in Go.
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+type parser struct {
+	lex lexer
+	tok token
+	err error
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+// parse parses a binary expression with operators of precedence higher than
+// prec.
+func (p *parser) parse(prec int) (node, error) {
+	lhs, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if p.err != nil {
+			return nil, p.err
+		}
+		op := p.tok.text
+		lvl, ok := precedence[op]
+		if p.tok.kind != tokOp || !ok || lvl <= prec {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.parse(lvl)
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binary{op: op, lhs: lhs, rhs: rhs}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	tok := p.tok
+	switch tok.kind {
+	case tokOp:
+		switch tok.text {
+		case "-", "!", "+":
+			p.next()
+			x, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return &unary{op: tok.text, x: x}, nil
+		case "(":
+			p.next()
+			x, err := p.parse(0)
+			if err != nil {
+				return nil, err
+			}
+			if p.tok.text != ")" {
+				return nil, fmt.Errorf("missing ')' at offset %d", p.tok.pos)
+			}
+			p.next()
+			return x, nil
+		}
+
+	case tokInt:
+		p.next()
+		v, err := strconv.ParseInt(tok.text, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q: %w", tok.text, err)
+		}
+		return literal{v}, nil
+
+	case tokFloat:
+		p.next()
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q: %w", tok.text, err)
+		}
+		return literal{v}, nil
+
+	case tokStr:
+		p.next()
+		txt := tok.text
+		if txt[0] == '\'' {
+			txt = `"` + strings.Replace(txt[1:len(txt)-1], `"`, `\"`, -1) + `"`
+		}
+		v, err := strconv.Unquote(txt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s: %w", tok.text, err)
+		}
+		return literal{v}, nil
+
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		}
+		if p.tok.kind == tokOp && p.tok.text == "(" {
+			return p.call(tok)
+		}
+		return ident(tok.text), nil
+
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+func (p *parser) call(name token) (node, error) {
+	fct, ok := builtins[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at offset %d", name.text, name.pos)
+	}
+	p.next() // (
+
+	var args []node
+	for p.tok.text != ")" {
+		arg, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		switch p.tok.text {
+		case ",":
+			p.next()
+		case ")":
+		default:
+			return nil, fmt.Errorf("missing ')' at offset %d", p.tok.pos)
+		}
+	}
+	p.next() // )
+
+	if len(args) != fct.nargs {
+		return nil, fmt.Errorf("invalid number of arguments to %s (got=%d, want=%d)", name.text, len(args), fct.nargs)
+	}
+	return &call{name: name.text, fct: fct.fct, args: args}, p.err
+}
+
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literal struct{ v interface{} }
+
+func (lit literal) eval(vars map[string]interface{}) (interface{}, error) { return lit.v, nil }
+
+type ident string
+
+func (id ident) eval(vars map[string]interface{}) (interface{}, error) {
+	v, ok := vars[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("undefined variable %q", string(id))
+	}
+	return normalize(v)
+}
+
+// normalize converts the value to one of the types of the language:
+// bool, int64, float64 or string.
+func normalize(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case bool, int64, float64, string:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint:
+		return normalize(uint64(v))
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return float64(v), nil
+		}
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	case []byte:
+		return string(v), nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", v)
+}
+
+type unary struct {
+	op string
+	x  node
+}
+
+func (u *unary) eval(vars map[string]interface{}) (interface{}, error) {
+	x, err := u.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch u.op {
+	case "!":
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	case "-":
+		switch x := x.(type) {
+		case int64:
+			return -x, nil
+		case float64:
+			return -x, nil
+		}
+	case "+":
+		switch x.(type) {
+		case int64, float64:
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid operation %s%T", u.op, x)
+}
+
+type binary struct {
+	op       string
+	lhs, rhs node
+}
+
+func (b *binary) eval(vars map[string]interface{}) (interface{}, error) {
+	x, err := b.lhs.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// short-circuit logical operators.
+	switch b.op {
+	case "&&", "||":
+		xb, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid operation %T %s", x, b.op)
+		}
+		if (b.op == "&&" && !xb) || (b.op == "||" && xb) {
+			return xb, nil
+		}
+		y, err := b.rhs.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		yb, ok := y.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid operation %s %T", b.op, y)
+		}
+		return yb, nil
+	}
+
+	y, err := b.rhs.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x := x.(type) {
+	case bool:
+		if y, ok := y.(bool); ok {
+			switch b.op {
+			case "==":
+				return x == y, nil
+			case "!=":
+				return x != y, nil
+			}
+		}
+	case string:
+		if y, ok := y.(string); ok {
+			return strOp(b.op, x, y)
+		}
+	case int64:
+		switch y := y.(type) {
+		case int64:
+			return intOp(b.op, x, y)
+		case float64:
+			return floatOp(b.op, float64(x), y)
+		}
+	case float64:
+		switch y := y.(type) {
+		case int64:
+			return floatOp(b.op, x, float64(y))
+		case float64:
+			return floatOp(b.op, x, y)
+		}
+	}
+	return nil, fmt.Errorf("invalid operation %T %s %T", x, b.op, y)
+}
+
+func strOp(op string, x, y string) (interface{}, error) {
+	switch op {
+	case "+":
+		return x + y, nil
+	case "==":
+		return x == y, nil
+	case "!=":
+		return x != y, nil
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	case ">=":
+		return x >= y, nil
+	}
+	return nil, fmt.Errorf("invalid operation string %s string", op)
+}
+
+func intOp(op string, x, y int64) (interface{}, error) {
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return nil, fmt.Errorf("integer division by zero")
+		}
+		return x / y, nil
+	case "%":
+		if y == 0 {
+			return nil, fmt.Errorf("integer division by zero")
+		}
+		return x % y, nil
+	case "==":
+		return x == y, nil
+	case "!=":
+		return x != y, nil
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	case ">=":
+		return x >= y, nil
+	}
+	return nil, fmt.Errorf("invalid operation int %s int", op)
+}
+
+func floatOp(op string, x, y float64) (interface{}, error) {
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		return x / y, nil
+	case "%":
+		return math.Mod(x, y), nil
+	case "==":
+		return x == y, nil
+	case "!=":
+		return x != y, nil
+	case "<":
+		return x < y, nil
+	case "<=":
+		return x <= y, nil
+	case ">":
+		return x > y, nil
+	case ">=":
+		return x >= y, nil
+	}
+	return nil, fmt.Errorf("invalid operation float %s float", op)
+}
+
+type call struct {
+	name string
+	fct  func(args []interface{}) (interface{}, error)
+	args []node
+}
+
+func (c *call) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := c.fct(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.name, err)
+	}
+	return v, nil
+}
+
+var builtins = map[string]struct {
+	nargs int
+	fct   func(args []interface{}) (interface{}, error)
+}{
+	"abs": {1, func(args []interface{}) (interface{}, error) {
+		switch x := args[0].(type) {
+		case int64:
+			if x < 0 {
+				return -x, nil
+			}
+			return x, nil
+		case float64:
+			return math.Abs(x), nil
+		}
+		return nil, fmt.Errorf("invalid argument type %T", args[0])
+	}},
+	"len": {1, func(args []interface{}) (interface{}, error) {
+		if s, ok := args[0].(string); ok {
+			return int64(len(s)), nil
+		}
+		return nil, fmt.Errorf("invalid argument type %T", args[0])
+	}},
+	"min": {2, func(args []interface{}) (interface{}, error) {
+		return minmax(args[0], args[1], "<")
+	}},
+	"max": {2, func(args []interface{}) (interface{}, error) {
+		return minmax(args[0], args[1], ">")
+	}},
+}
+
+func minmax(x, y interface{}, op string) (interface{}, error) {
+	var (
+		v   interface{}
+		err error
+	)
+	switch xx := x.(type) {
+	case int64:
+		switch yy := y.(type) {
+		case int64:
+			v, err = intOp(op, xx, yy)
+		case float64:
+			x = float64(xx)
+			v, err = floatOp(op, float64(xx), yy)
+		default:
+			err = fmt.Errorf("invalid argument types %T, %T", x, y)
+		}
+	case float64:
+		switch yy := y.(type) {
+		case int64:
+			y = float64(yy)
+			v, err = floatOp(op, xx, float64(yy))
+		case float64:
+			v, err = floatOp(op, xx, yy)
+		default:
+			err = fmt.Errorf("invalid argument types %T, %T", x, y)
+		}
+	default:
+		err = fmt.Errorf("invalid argument types %T, %T", x, y)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v.(bool) {
+		return x, nil
+	}
+	return y, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[string]interface{}{
+		"evt":     uint64(42),
+		"ch":      uint8(3),
+		"adc":     int16(-12),
+		"energy":  12.5,
+		"gain":    float32(0.5),
+		"name":    "adc",
+		"raw":     []byte("raw"),
+		"valid":   true,
+		"hdr.evt": int32(7),
+	}
+
+	for _, tc := range []struct {
+		src  string
+		want interface{}
+	}{
+		{"42", int64(42)},
+		{"0x2a", int64(42)},
+		{"4.5", 4.5},
+		{"1e-1", 0.1},
+		{`"adc"`, "adc"},
+		{`'adc'`, "adc"},
+		{"true", true},
+		{"1 + 2 * 3", int64(7)},
+		{"(1 + 2) * 3", int64(9)},
+		{"7 / 2", int64(3)},
+		{"7 % 2", int64(1)},
+		{"7 / 2.0", 3.5},
+		{"-evt", int64(-42)},
+		{"evt + ch", int64(45)},
+		{"energy * gain", 6.25},
+		{"adc < 0", true},
+		{"hdr.evt == 7", true},
+		{`name + "-" + raw`, "adc-raw"},
+		{`name == "adc"`, true},
+		{`name < "b"`, true},
+		{"energy > 10 && (ch == 3 || ch == 4)", true},
+		{"!valid || energy < 10", false},
+		{"1 < 2 == true", true},
+		{"abs(adc)", int64(12)},
+		{"abs(-2.5)", 2.5},
+		{"len(name)", int64(3)},
+		{"min(evt, ch)", int64(3)},
+		{"max(energy, ch)", 12.5},
+		// short-circuit evaluation.
+		{"false && 1/0 == 0", false},
+		{"true || 1/0 == 0", true},
+	} {
+		t.Run(tc.src, func(t *testing.T) {
+			e, err := Compile(tc.src)
+			if err != nil {
+				t.Fatalf("could not compile: %+v", err)
+			}
+			got, err := e.Eval(vars)
+			if err != nil {
+				t.Fatalf("could not evaluate: %+v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid value:\ngot = %#v\nwant= %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	vars := map[string]interface{}{
+		"evt":  uint64(42),
+		"name": "adc",
+		"ptr":  &struct{}{},
+	}
+
+	for _, tc := range []struct {
+		src  string
+		comp bool // whether the error is a compilation error
+	}{
+		{"", true},
+		{"1 +", true},
+		{"(1 + 2", true},
+		{"1 2", true},
+		{`"adc`, true},
+		{"evt $ 2", true},
+		{"foo(1)", true},
+		{"abs(1, 2)", true},
+		{"1 / 0", false},
+		{"1 % 0", false},
+		{"nope > 2", false},
+		{"ptr == 1", false},
+		{`name > 2`, false},
+		{`name * 2`, false},
+		{"!evt", false},
+		{"evt && true", false},
+		{"len(evt)", false},
+	} {
+		t.Run(tc.src, func(t *testing.T) {
+			e, err := Compile(tc.src)
+			switch {
+			case tc.comp && err == nil:
+				t.Fatalf("expected a compilation error")
+			case tc.comp:
+				return
+			case err != nil:
+				t.Fatalf("could not compile: %+v", err)
+			}
+			_, err = e.Eval(vars)
+			if err == nil {
+				t.Fatalf("expected an evaluation error")
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	e := MustCompile("energy > 10")
+	for _, tc := range []struct {
+		energy float64
+		want   bool
+	}{
+		{5, false},
+		{10, false},
+		{10.5, true},
+	} {
+		got, err := e.Match(map[string]interface{}{"energy": tc.energy})
+		if err != nil {
+			t.Fatalf("could not match: %+v", err)
+		}
+		if got != tc.want {
+			t.Fatalf("invalid match for energy=%v: got=%v, want=%v", tc.energy, got, tc.want)
+		}
+	}
+
+	_, err := MustCompile("energy + 1").Match(map[string]interface{}{"energy": 1.0})
+	if err == nil {
+		t.Fatalf("expected an error for a non-boolean expression")
+	}
+}
+
+func TestVars(t *testing.T) {
+	e := MustCompile(`energy > 10 && (ch == 3 || hdr.evt > ch) && len(name) > 0`)
+	got := e.Vars()
+	want := []string{"ch", "energy", "hdr.evt", "name"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid vars:\ngot = %q\nwant= %q", got, want)
+	}
+}
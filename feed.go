@@ -0,0 +1,226 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+// feedEvent is an event pushed to the subscribers of the run-ctl live feed.
+type feedEvent struct {
+	Type      string      `json:"type"` // type of event ("status", "log", "mon")
+	Timestamp string      `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// feed dispatches live events to a set of subscribers.
+// Slow subscribers miss events rather than blocking the publisher.
+type feed struct {
+	mu   sync.RWMutex
+	subs map[chan feedEvent]struct{}
+}
+
+func newFeed() *feed {
+	return &feed{subs: make(map[chan feedEvent]struct{})}
+}
+
+func (f *feed) sub() chan feedEvent {
+	ch := make(chan feedEvent, 256)
+	f.mu.Lock()
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+	return ch
+}
+
+func (f *feed) unsub(ch chan feedEvent) {
+	f.mu.Lock()
+	delete(f.subs, ch)
+	f.mu.Unlock()
+}
+
+func (f *feed) publish(typ string, data interface{}) {
+	evt := feedEvent{
+		Type:      typ,
+		Timestamp: utcNow(),
+		Data:      data,
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for ch := range f.subs {
+		select {
+		case ch <- evt:
+		default:
+			// subscriber is too slow. drop event.
+		}
+	}
+}
+
+type procStatus struct {
+	Name   string       `json:"name"`
+	Status string       `json:"status"`
+	RTT    string       `json:"rtt,omitempty"` // round-trip time of the last heartbeat
+	Slow   bool         `json:"slow"`          // whether the process cannot keep up with its producers
+	Clock  *clockStatus `json:"clock,omitempty"`
+	Links  []linkStatus `json:"links,omitempty"`
+
+	Latency []latencyStatus `json:"latency,omitempty"` // latency of the data frames of input end-points
+	Runtime *runtimeStatus  `json:"runtime,omitempty"` // statistics of the Go runtime of the process
+}
+
+type runtimeStatus struct {
+	Goroutines int    `json:"goroutines"`
+	HeapInuse  uint64 `json:"heap-inuse"`
+	NumGC      uint32 `json:"num-gc"`
+	PauseTotal string `json:"pause-total"`
+	Leak       bool   `json:"leak"` // whether the process keeps growing its goroutines or heap
+}
+
+type latencyStatus struct {
+	EndPoint string   `json:"endpoint"`
+	Count    uint64   `json:"count"`
+	Mean     string   `json:"mean"`
+	P50      string   `json:"p50"` // upper bound of the median latency
+	P99      string   `json:"p99"` // upper bound of the 99th percentile of the latency
+	Max      string   `json:"max"`
+	Skewed   uint64   `json:"skewed"` // number of data frames received before they were sent
+	Bins     []uint64 `json:"bins"`   // number of data frames per bin of tdaq.LatencyBins, plus overflow
+}
+
+func newLatencyStatus(ep string, lat Latency) latencyStatus {
+	return latencyStatus{
+		EndPoint: ep,
+		Count:    lat.Count,
+		Mean:     lat.Mean().String(),
+		P50:      lat.Quantile(0.5).String(),
+		P99:      lat.Quantile(0.99).String(),
+		Max:      lat.Max.String(),
+		Skewed:   lat.Skewed,
+		Bins:     lat.Bins,
+	}
+}
+
+type clockStatus struct {
+	Offset string  `json:"offset"` // clock of the process minus clock of run-ctl
+	Delay  string  `json:"delay"`  // round-trip delay of the exchange the offset was measured with
+	Skew   float64 `json:"skew"`   // drift rate of the offset, in parts per million
+	Time   string  `json:"time"`
+}
+
+type linkStatus struct {
+	Addr      string   `json:"addr"`
+	EndPoints []string `json:"endpoints"`
+	Up        bool     `json:"up"`
+	Outages   uint32   `json:"outages"`
+	Since     string   `json:"since"`
+	Frames    uint64   `json:"frames"`
+	Bytes     uint64   `json:"bytes"`
+	LastFrame string   `json:"last-frame,omitempty"`
+	Queued    uint32   `json:"queued"`
+	Capacity  uint32   `json:"capacity"`
+	Occupancy []uint32 `json:"occupancy,omitempty"` // history of queued data frames, oldest first
+	Stalled   bool     `json:"stalled"`
+	Slow      bool     `json:"slow"`
+}
+
+func newLinkStatus(link LinkStatus) linkStatus {
+	st := linkStatus{
+		Addr:      link.Addr,
+		EndPoints: link.EndPoints,
+		Up:        link.Up,
+		Outages:   link.Outages,
+		Since:     utcFormat(link.Since),
+		Frames:    link.Frames,
+		Bytes:     link.Bytes,
+		Queued:    link.Queued,
+		Capacity:  link.Capacity,
+		Stalled:   link.Stalled,
+	}
+	if !link.LastFrame.IsZero() {
+		st.LastFrame = utcFormat(link.LastFrame)
+	}
+	return st
+}
+
+type statusReport struct {
+	Status    string       `json:"status"`
+	Procs     []procStatus `json:"procs"`
+	Devices   *Devices     `json:"devices,omitempty"` // reconciliation with the expected processes (if any)
+	Run       *RunTotals   `json:"run,omitempty"`     // run-wide statistics of the current (or last) run
+	Timestamp string       `json:"timestamp"`
+}
+
+// statusReport returns the current status of the run-ctl and of its processes.
+// statusReport must be called with rc.mu held.
+func (rc *RunControl) statusReport() statusReport {
+	report := statusReport{
+		Status:    rc.status.String(),
+		Timestamp: utcNow(),
+	}
+	for _, proc := range rc.clients.list() {
+		st := procStatus{
+			Name:   proc.name,
+			Status: proc.getStatus().String(),
+		}
+		if rtt := proc.getRTT(); rtt > 0 {
+			st.RTT = rtt.String()
+		}
+		if clk := proc.getClock(); !clk.Time.IsZero() {
+			st.Clock = &clockStatus{
+				Offset: clk.Offset.String(),
+				Delay:  clk.Delay.String(),
+				Skew:   clk.Skew,
+				Time:   utcFormat(clk.Time),
+			}
+		}
+		st.Links, st.Slow = proc.linkReport()
+		st.Latency = proc.latencyReport()
+		st.Runtime = proc.runtimeReport()
+		report.Procs = append(report.Procs, st)
+	}
+	sort.Slice(report.Procs, func(i, j int) bool {
+		return report.Procs[i].Name < report.Procs[j].Name
+	})
+	if rc.topo != nil {
+		devs := rc.devices()
+		report.Devices = &devs
+	}
+	report.Run = rc.runTotals()
+	return report
+}
+
+// serveFeed forwards log messages received from the tdaq processes to the
+// live feed and to the log tails.
+func (rc *RunControl) serveFeed(ctx context.Context) {
+	for {
+		select {
+		case <-rc.quit:
+			return
+		case <-ctx.Done():
+			return
+		case msg := <-rc.msgch:
+			e := newLogEntry(msg)
+			rc.logs.add(e)
+			rc.feed.publish("log", e)
+			if msg.Level >= log.LvlError {
+				rc.alerts.raise(AlertError, msg.Name, "%s", strings.TrimSpace(msg.Msg))
+			}
+		}
+	}
+}
+
+func utcNow() string {
+	return utcFormat(time.Now())
+}
+
+func utcFormat(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05") + " (UTC)"
+}
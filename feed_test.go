@@ -0,0 +1,54 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"testing"
+)
+
+func TestFeed(t *testing.T) {
+	f := newFeed()
+
+	sub1 := f.sub()
+	sub2 := f.sub()
+
+	f.publish("log", "hello")
+
+	for i, sub := range []chan feedEvent{sub1, sub2} {
+		evt := <-sub
+		if got, want := evt.Type, "log"; got != want {
+			t.Fatalf("sub-%d: invalid event type: got=%q, want=%q", i, got, want)
+		}
+		if got, want := evt.Data.(string), "hello"; got != want {
+			t.Fatalf("sub-%d: invalid event data: got=%q, want=%q", i, got, want)
+		}
+	}
+
+	f.unsub(sub2)
+	f.publish("mon", 42)
+
+	select {
+	case evt := <-sub1:
+		if got, want := evt.Type, "mon"; got != want {
+			t.Fatalf("invalid event type: got=%q, want=%q", got, want)
+		}
+	default:
+		t.Fatalf("sub-1 did not receive event")
+	}
+
+	select {
+	case evt := <-sub2:
+		t.Fatalf("unsubscribed sub-2 received event %#v", evt)
+	default:
+	}
+
+	// slow subscribers should not block the publisher.
+	for i := 0; i < 2*cap(sub1); i++ {
+		f.publish("status", i)
+	}
+	if got, want := len(sub1), cap(sub1); got != want {
+		t.Fatalf("invalid number of queued events: got=%d, want=%d", got, want)
+	}
+}
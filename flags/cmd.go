@@ -0,0 +1,106 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flags // import "github.com/go-daq/tdaq/flags"
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// Command is a subcommand of a tdaq tool.
+type Command struct {
+	Name  string // name of the subcommand (e.g. "run", "status")
+	Short string // short one-line description of the subcommand
+
+	// Run runs the subcommand with the command line arguments
+	// following the subcommand name.
+	Run func(args []string) error
+}
+
+// Commands is a set of subcommands of a tdaq tool.
+type Commands struct {
+	Name string     // name of the tdaq tool
+	Cmds []*Command // subcommands of the tdaq tool
+	Def  string     // name of the default subcommand
+
+	Stderr io.Writer // where usage is printed (default: os.Stderr)
+}
+
+// Lookup returns the named subcommand, or nil if it does not exist.
+func (cmds *Commands) Lookup(name string) *Command {
+	for _, cmd := range cmds.Cmds {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// Dispatch runs the subcommand named by the first argument of args.
+// The default subcommand is run when args is empty or when its first
+// element is a flag.
+func (cmds *Commands) Dispatch(args []string) error {
+	name := cmds.Def
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "help":
+		if len(args) > 0 {
+			cmd := cmds.Lookup(args[0])
+			if cmd == nil {
+				cmds.Usage()
+				return fmt.Errorf("unknown subcommand %q", args[0])
+			}
+			return cmd.Run([]string{"-h"})
+		}
+		cmds.Usage()
+		return nil
+	case "":
+		cmds.Usage()
+		return fmt.Errorf("missing subcommand")
+	}
+
+	cmd := cmds.Lookup(name)
+	if cmd == nil {
+		cmds.Usage()
+		return fmt.Errorf("unknown subcommand %q", name)
+	}
+
+	return cmd.Run(args)
+}
+
+// Usage prints the list of subcommands.
+func (cmds *Commands) Usage() {
+	var o io.Writer = os.Stderr
+	if cmds.Stderr != nil {
+		o = cmds.Stderr
+	}
+
+	fmt.Fprintf(o, "Usage: %s <subcommand> [arguments]\n\nSubcommands:\n\n", cmds.Name)
+	w := tabwriter.NewWriter(o, 0, 8, 2, ' ', 0)
+	for _, cmd := range cmds.Cmds {
+		def := ""
+		if cmd.Name == cmds.Def {
+			def = " (default)"
+		}
+		fmt.Fprintf(w, "\t%s\t%s%s\n", cmd.Name, cmd.Short, def)
+	}
+	w.Flush()
+	fmt.Fprintf(o, "\nUse \"%s help <subcommand>\" for more information about a subcommand.\n", cmds.Name)
+}
+
+// Parse parses the arguments of a subcommand with the provided flag set.
+// As for New, flags that were not given on the command line may be set
+// from the environment or from a configuration file.
+func Parse(fs *flag.FlagSet, args []string, opts ...Option) error {
+	o := newOptions(opts)
+	return parse(fs, args, o, os.LookupEnv)
+}
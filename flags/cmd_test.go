@@ -0,0 +1,68 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flags
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	var (
+		name string
+		args []string
+	)
+	mk := func(n string) *Command {
+		return &Command{
+			Name:  n,
+			Short: "command " + n,
+			Run: func(v []string) error {
+				name, args = n, v
+				return nil
+			},
+		}
+	}
+
+	cmds := Commands{
+		Name:   "tdaq-app",
+		Def:    "run",
+		Cmds:   []*Command{mk("run"), mk("status")},
+		Stderr: ioutil.Discard,
+	}
+
+	for _, tc := range []struct {
+		args []string
+		name string
+		want []string
+		err  bool
+	}{
+		{args: nil, name: "run", want: nil},
+		{args: []string{"-id=foo"}, name: "run", want: []string{"-id=foo"}},
+		{args: []string{"run", "-id=foo"}, name: "run", want: []string{"-id=foo"}},
+		{args: []string{"status", "-web=:8080"}, name: "status", want: []string{"-web=:8080"}},
+		{args: []string{"help", "status"}, name: "status", want: []string{"-h"}},
+		{args: []string{"not-there"}, err: true},
+	} {
+		t.Run("", func(t *testing.T) {
+			name, args = "", nil
+			err := cmds.Dispatch(tc.args)
+			switch {
+			case err != nil && !tc.err:
+				t.Fatalf("could not dispatch %q: %+v", tc.args, err)
+			case err == nil && tc.err:
+				t.Fatalf("expected an error")
+			case tc.err:
+				return
+			}
+			if name != tc.name {
+				t.Fatalf("invalid subcommand: got=%q, want=%q", name, tc.name)
+			}
+			if !reflect.DeepEqual(args, tc.want) {
+				t.Fatalf("invalid args:\ngot = %#v\nwant= %#v", args, tc.want)
+			}
+		})
+	}
+}
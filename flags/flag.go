@@ -3,11 +3,29 @@
 // license that can be found in the LICENSE file.
 
 // Package flags provides an easy creation of standard tdaq flag parameters for tdaq processes
+//
+// Flags may be set (by order of decreasing precedence):
+//   - on the command line,
+//   - via environment variables,
+//   - via a configuration file,
+//   - via the defaults provided to New or NewRunControl.
+//
+// The environment variable associated with a flag is the upper-cased name
+// of the flag, with dashes replaced by underscores, prefixed with the upper-cased
+// name of the application or with "TDAQ":
+//   - <APP>_RC_ADDR or TDAQ_RC_ADDR for the -rc-addr flag.
+//
+// The configuration file is selected with the -cfg flag (or the <APP>_CFG and
+// TDAQ_CFG environment variables) and contains one "name = value" flag
+// assignment per line.
+// Empty lines and lines starting with '#' are ignored.
 package flags // import "github.com/go-daq/tdaq/flags"
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strconv"
@@ -18,23 +36,206 @@ import (
 	"github.com/go-daq/tdaq/log"
 )
 
-func New() config.Process {
+// Option configures how flags are declared and parsed.
+type Option func(o *options)
+
+type options struct {
+	name  string            // name of the application
+	args  []string          // command line arguments to parse
+	defs  map[string]string // default values of flags
+	procs []procFlags       // optional groups of flags of tdaq processes
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		name: path.Base(os.Args[0]),
+		args: os.Args[1:],
+		defs: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithName sets the name of the application.
+// The application name is used as the default name of the tdaq process and
+// as the prefix of the environment variables associated with flags.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithArgs sets the command line arguments to parse.
+// The default is to parse os.Args[1:].
+func WithArgs(args []string) Option {
+	return func(o *options) {
+		o.args = args
+	}
+}
+
+// WithDefaults overrides the default values of flags, indexed by flag name.
+func WithDefaults(defs map[string]string) Option {
+	return func(o *options) {
+		for k, v := range defs {
+			o.defs[k] = v
+		}
+	}
+}
+
+// procFlags declares a group of optional flags of tdaq processes, filling
+// cmd, and returns the function completing cmd once the flags are parsed,
+// if any.
+type procFlags func(fs *flag.FlagSet, cmd *config.Process) func()
+
+// WithLinkFlags declares the flags tuning the data links of tdaq processes:
+// -chunk-size, -mux, -reconnect-time, -max-reconnect-time and -stall-timeout.
+func WithLinkFlags() Option {
+	return func(o *options) {
+		o.procs = append(o.procs, linkFlags)
+	}
+}
+
+// WithDeliveryFlags declares the flags of the delivery of data frames by
+// tdaq processes: the dead-letter policy (-dead-letter...), the spooling
+// (-spool...), the acknowledged mode (-acked, -ack...) and the credit-based
+// flow control (-credit...) of output end-points.
+func WithDeliveryFlags() Option {
+	return func(o *options) {
+		o.procs = append(o.procs, deliveryFlags)
+	}
+}
+
+// WithDebugFlags declares the flags debugging tdaq processes: -latency,
+// -trace-rate, -pprof and -health.
+func WithDebugFlags() Option {
+	return func(o *options) {
+		o.procs = append(o.procs, debugFlags)
+	}
+}
+
+// WithDeviceFlags declares the flags of the state of the device of tdaq
+// processes: -conditions, -checkpoint, -checkpoint-freq and -secrets.
+func WithDeviceFlags() Option {
+	return func(o *options) {
+		o.procs = append(o.procs, deviceFlags)
+	}
+}
+
+// WithAllFlags declares all the optional flags of tdaq processes, for
+// processes running arbitrary devices.
+func WithAllFlags() Option {
+	return func(o *options) {
+		o.procs = append(o.procs, linkFlags, deliveryFlags, debugFlags, deviceFlags)
+	}
+}
+
+func linkFlags(fs *flag.FlagSet, cmd *config.Process) func() {
+	fs.IntVar(&cmd.ChunkSize, "chunk-size", 0, "maximum size in bytes of data frame payloads before they are split into chunks (0: default)")
+	fs.BoolVar(&cmd.Mux, "mux", false, "multiplex all output end-points over a single data connection")
+	fs.DurationVar(&cmd.ReconnectTime, "reconnect-time", 0, "initial delay before redialing a dropped data link (0: default)")
+	fs.DurationVar(&cmd.MaxReconnectTime, "max-reconnect-time", 0, "maximum delay between attempts at redialing a dropped data link (0: default)")
+	fs.DurationVar(&cmd.StallTimeout, "stall-timeout", 0, "duration without data after which a running data link is reported as stalled (0: default, <0: disabled)")
+	return nil
+}
+
+func deliveryFlags(fs *flag.FlagSet, cmd *config.Process) func() {
+	var acks, crds string
+	fs.StringVar(&cmd.DeadLetter.Policy, "dead-letter", "", "policy for undeliverable data frames (drop, spill, redirect)")
+	fs.DurationVar(&cmd.DeadLetter.Deadline, "dead-letter-deadline", 0, "maximum time to wait for a consumer before a data frame is undeliverable")
+	fs.StringVar(&cmd.DeadLetter.File, "dead-letter-file", "", "path to the spill file of undeliverable data frames (spill policy)")
+	fs.StringVar(&cmd.DeadLetter.EndPoint, "dead-letter-ep", "", "name of the output end-point receiving undeliverable data frames (redirect policy)")
+	fs.StringVar(&cmd.Spool.Dir, "spool-dir", "", "directory where data frames are spooled while no consumer is connected")
+	fs.Int64Var(&cmd.Spool.MaxSize, "spool-max-size", 0, "maximum size in bytes of the spool file of each output end-point (0: unbounded)")
+	fs.StringVar(&acks, "acked", "", "comma-separated list of output end-points delivered in acknowledged mode")
+	fs.StringVar(&cmd.Acked.Dir, "acked-dir", "", "directory holding the logs of unacknowledged data frames")
+	fs.IntVar(&cmd.Acked.Batch, "ack-batch", 0, "number of data frames acknowledged at once (0: default)")
+	fs.DurationVar(&cmd.Acked.Timeout, "ack-timeout", 0, "delay without acknowledgement after which data frames are retransmitted (0: default)")
+	fs.StringVar(&crds, "credit", "", "comma-separated list of output end-points under credit-based flow control")
+	fs.Int64Var(&cmd.Credit.Bytes, "credit-bytes", 0, "maximum number of queued payload bytes granted by each input end-point (0: unbounded)")
+	return func() {
+		if acks != "" {
+			cmd.Acked.EndPoints = strings.Split(acks, ",")
+		}
+		if crds != "" {
+			cmd.Credit.EndPoints = strings.Split(crds, ",")
+		}
+	}
+}
+
+func debugFlags(fs *flag.FlagSet, cmd *config.Process) func() {
+	fs.BoolVar(&cmd.Latency, "latency", false, "stamp data frames with their send time, to measure their latency on input end-points")
+	fs.IntVar(&cmd.TraceRate, "trace-rate", 0, "trace the data frames of 1 in N events through the pipeline, by event ID (0: disabled)")
+	fs.StringVar(&cmd.PProf, "pprof", "", "[addr]:port of the net/http/pprof server of the tdaq process (empty: started with '/debug pprof on')")
+	fs.StringVar(&cmd.Health, "health", "", "[addr]:port of the /healthz and /readyz probes server of the tdaq process (empty: disabled)")
+	return nil
+}
+
+func deviceFlags(fs *flag.FlagSet, cmd *config.Process) func() {
+	fs.StringVar(&cmd.Conditions, "conditions", "", "URL of the conditions database of the tdaq process (e.g. https://host/conditions, sqlite3:///path/to/db)")
+	fs.StringVar(&cmd.Checkpoint, "checkpoint", "", "path to the checkpoint file of the device state (empty: disabled)")
+	fs.DurationVar(&cmd.CheckpointFreq, "checkpoint-freq", 0, "period of the checkpoints of the device state (0: default)")
+	fs.StringVar(&cmd.Secrets, "secrets", "", "path to the encrypted secrets store of the tdaq process (key: $TDAQ_SECRETS_KEY)")
+	return nil
+}
+
+// New declares and parses the flags of a tdaq process.
+//
+// Only the flags common to all tdaq processes are declared by default:
+// the flags tuning the data links, the delivery of data frames, the
+// debugging of the process or the state of its device are declared by
+// the WithLinkFlags, WithDeliveryFlags, WithDebugFlags and WithDeviceFlags
+// options, for the tdaq processes that support them.
+func New(opts ...Option) config.Process {
 	var (
-		cmd config.Process
-		lvl string
+		cmd  config.Process
+		lvl  string
+		cfg  string
+		topo string
+		o    = newOptions(opts)
 	)
 
 	flag.StringVar(&cmd.Name, "id", "", "name of the tdaq process")
 	flag.StringVar(&lvl, "lvl", "INFO", "msgstream level")
 	flag.StringVar(&cmd.Trans, "net", "tcp", "network medium to use (tcp, unix) for data transfer")
-	flag.StringVar(&cmd.RunCtl, "rc-addr", ":44000", "[addr]:port of run-control process")
+	flag.StringVar(&cmd.RunCtl, "rc-addr", ":44000", "[addr]:port of run-control process (auto[:name]: discover via mDNS)")
+	flag.IntVar(&cmd.MaxFrameSize, "max-frame-size", 0, "maximum size in bytes of frames exchanged with other tdaq processes (0: default)")
+	flag.StringVar(&cfg, "cfg", "", "path to a configuration file")
+	flag.StringVar(&topo, "topo", "", "path to a JSON topology file")
 
-	flag.Parse()
+	var done []func()
+	for _, procs := range o.procs {
+		if f := procs(flag.CommandLine, &cmd); f != nil {
+			done = append(done, f)
+		}
+	}
+
+	err := parse(flag.CommandLine, o.args, o, os.LookupEnv)
+	if err != nil {
+		log.Fatalf("could not parse flags: %+v", err)
+	}
 
 	cmd.Args = flag.Args()
 
+	for _, f := range done {
+		f()
+	}
+
 	if cmd.Name == "" {
-		cmd.Name = path.Base(os.Args[0])
+		cmd.Name = o.name
+	}
+
+	if topo != "" {
+		t, err := config.LoadTopology(topo)
+		if err != nil {
+			log.Fatalf("could not load topology: %+v", err)
+		}
+		if p, ok := t.Proc(cmd.Name); ok {
+			cmd.Sockets = p.Sockets()
+			cmd.Types = p.Types()
+			cmd.Device = p.Device
+		}
 	}
 
 	level, err := parseLevel(lvl)
@@ -46,10 +247,14 @@ func New() config.Process {
 	return cmd
 }
 
-func NewRunControl() config.RunCtl {
+func NewRunControl(opts ...Option) config.RunCtl {
 	var (
-		cmd config.RunCtl
-		lvl string
+		cmd    config.RunCtl
+		lvl    string
+		cfg    string
+		alerts string
+		tmos   string
+		o      = newOptions(opts)
 	)
 
 	flag.StringVar(&cmd.Name, "id", "", "name of the tdaq process")
@@ -57,17 +262,59 @@ func NewRunControl() config.RunCtl {
 	flag.StringVar(&cmd.RunCtl, "rc-addr", ":44000", "[addr]:port of run-ctl cmd server")
 	flag.StringVar(&cmd.Trans, "net", "tcp", "network medium to use (tcp, unix) for data transfer")
 	flag.StringVar(&cmd.Web, "web", "", "[addr]:port of run-ctl web server")
+	flag.StringVar(&cmd.Observer, "observer", "", "[addr]:port of read-only run-ctl observer web server (status, monitoring and log streams only)")
+	flag.StringVar(&cmd.RPC, "rpc", "", "[addr]:port of run-ctl JSON-RPC 2.0 control server (also served over websocket at /rpc of the web server)")
 	flag.BoolVar(&cmd.Interactive, "i", false, "enable interactive run-ctl shell")
+	flag.BoolVar(&cmd.Check, "check", false, "validate the topology file and exit")
+	flag.BoolVar(&cmd.MDNS, "mdns", false, "advertise run-ctl address on the local network via mDNS")
+	flag.StringVar(&cmd.Required, "required", config.RequiredRefuse, "policy when required processes are not ready at /start (refuse, warn)")
+	flag.StringVar(&cmd.Topology, "topo", "", "path to a JSON topology file")
 
 	flag.StringVar(&cmd.LogFile, "log-file", "", "path to log file for run-ctl log server")
+	flag.StringVar(&cmd.SummaryDir, "summary-dir", "", "directory of end-of-run summary reports (default: directory of log file)")
+	flag.StringVar(&cmd.Logbook, "elog", "", "URL of the electronic logbook receiving run entries (e.g. https://host/hook, elog+https://host/DAQ)")
+	flag.StringVar(&alerts, "alerts", "", "comma-separated list of URLs of alert notification channels (e.g. https://host/hook, slack+https://hooks.slack.com/..., smtp://host:25?to=a@b)")
+	flag.DurationVar(&cmd.AlertWindow, "alert-window", 5*time.Minute, "throttling window of repeated alerts")
+	flag.StringVar(&cmd.AlarmFile, "alarm-file", "tdaq-alarms.json", "path to the file persisting the alarms of the tdaq processes (empty: not persisted)")
+	flag.StringVar(&cmd.Record, "record", "", "path to the file recording the commands issued by run-ctl, for replay (empty: not recorded)")
+	flag.StringVar(&cmd.AuditFile, "audit-file", "tdaq-audit.json", "path to the append-only audit log of the control actions of the operators (empty: not persisted)")
+	flag.StringVar(&cmd.KVFile, "kv-file", "tdaq-kv.json", "path to the file persisting the key-value configuration store of the tdaq processes (empty: not persisted)")
+	flag.StringVar(&tmos, "timeouts", "", "comma-separated list of cmd=duration maximum durations of FSM transitions (e.g. /config=30s,/start=10s)")
+	flag.DurationVar(&cmd.Watchdog, "watchdog", 0, "maximum duration a running process may miss heartbeats or data before the run is stopped (0: disabled)")
+	flag.Int64Var(&cmd.MinDiskFree, "min-disk-free", 0, "free disk space in bytes below which a disk-full alert is raised (0: disabled)")
+	flag.IntVar(&cmd.Parallel, "parallel", 0, "maximum number of tdaq processes receiving a command concurrently (0: default)")
 	flag.DurationVar(&cmd.HBeatFreq, "hbeat", 5*time.Second, "frequency for the heartbeat server")
+	flag.IntVar(&cmd.MaxFrameSize, "max-frame-size", 0, "maximum size in bytes of frames exchanged with tdaq processes (0: default)")
+	flag.StringVar(&cfg, "cfg", "", "path to a configuration file")
 
-	flag.Parse()
+	err := parse(flag.CommandLine, o.args, o, os.LookupEnv)
+	if err != nil {
+		log.Fatalf("could not parse flags: %+v", err)
+	}
 
 	cmd.Args = flag.Args()
 
 	if cmd.Name == "" {
-		cmd.Name = path.Base(os.Args[0])
+		cmd.Name = o.name
+	}
+
+	if alerts != "" {
+		cmd.Alerts = strings.Split(alerts, ",")
+	}
+
+	if tmos != "" {
+		cmd.Timeouts = make(map[string]time.Duration)
+		for _, kv := range strings.Split(tmos, ",") {
+			i := strings.Index(kv, "=")
+			if i < 0 {
+				log.Fatalf("invalid transition timeout %q (want cmd=duration)", kv)
+			}
+			v, err := time.ParseDuration(kv[i+1:])
+			if err != nil {
+				log.Fatalf("invalid transition timeout %q: %+v", kv, err)
+			}
+			cmd.Timeouts[kv[:i]] = v
+		}
 	}
 
 	level, err := parseLevel(lvl)
@@ -79,22 +326,244 @@ func NewRunControl() config.RunCtl {
 	return cmd
 }
 
-func parseLevel(lvl string) (log.Level, error) {
-	lvl = strings.ToLower(lvl)
-	switch {
-	case strings.HasPrefix(lvl, "dbg"), strings.HasPrefix(lvl, "debug"):
-		return log.LvlDebug, nil
-	case strings.HasPrefix(lvl, "info"):
-		return log.LvlInfo, nil
-	case strings.HasPrefix(lvl, "warn"):
-		return log.LvlWarning, nil
-	case strings.HasPrefix(lvl, "err"):
-		return log.LvlError, nil
-	default:
-		v, err := strconv.Atoi(lvl)
+func NewProxy(opts ...Option) config.Proxy {
+	var (
+		cmd config.Proxy
+		lvl string
+		cfg string
+		o   = newOptions(opts)
+	)
+
+	flag.StringVar(&cmd.Name, "id", "", "name of the tdaq process")
+	flag.StringVar(&lvl, "lvl", "INFO", "msgstream level")
+	flag.StringVar(&cmd.Listen, "addr", "tcp://:44000", "address of the /join socket served to the relayed tdaq processes")
+	flag.StringVar(&cmd.RunCtl, "rc-addr", "", "address of the run-ctl the tdaq processes are relayed to")
+	flag.StringVar(&cmd.Public, "public-host", "", "host under which relayed end-points are advertised to run-ctl")
+	flag.StringVar(&cmd.Inner, "inner-host", "", "host under which relayed end-points are advertised to the relayed tdaq processes")
+	flag.IntVar(&cmd.MaxFrameSize, "max-frame-size", 0, "maximum size in bytes of relayed control frames (0: default)")
+	flag.StringVar(&cfg, "cfg", "", "path to a configuration file")
+
+	err := parse(flag.CommandLine, o.args, o, os.LookupEnv)
+	if err != nil {
+		log.Fatalf("could not parse flags: %+v", err)
+	}
+
+	if cmd.Name == "" {
+		cmd.Name = o.name
+	}
+
+	level, err := parseLevel(lvl)
+	if err != nil {
+		log.Fatalf("could not parse msg-level: %+v", err)
+	}
+	cmd.Level = level
+
+	return cmd
+}
+
+func NewGateway(opts ...Option) config.Gateway {
+	var (
+		cmd     config.Gateway
+		name    string
+		lvl     string
+		trans   string
+		upper   string
+		streams string
+		cfg     string
+		o       = newOptions(opts)
+	)
+
+	flag.StringVar(&name, "id", "", "name of the tdaq process in the lower partition")
+	flag.StringVar(&upper, "upper-id", "", "name of the tdaq process in the upper partition (default: same as -id)")
+	flag.StringVar(&lvl, "lvl", "INFO", "msgstream level")
+	flag.StringVar(&trans, "net", "tcp", "network medium to use (tcp, unix) for data transfer")
+	flag.StringVar(&cmd.Lower.RunCtl, "lower-rc-addr", ":44000", "[addr]:port of the run-control process of the lower partition")
+	flag.StringVar(&cmd.Upper.RunCtl, "upper-rc-addr", ":45000", "[addr]:port of the run-control process of the upper partition")
+	flag.StringVar(&streams, "streams", "", "comma-separated list of in=out pairs of lower input and upper output end-points to republish")
+	flag.IntVar(&cmd.QueueLen, "queue-len", 0, "number of data frames buffered per stream (0: default)")
+	flag.StringVar(&cmd.StatusURL, "status-url", "", "URL of the status API of the lower run-control (e.g. http://host:8080/api/status)")
+	flag.DurationVar(&cmd.StatusFreq, "status-freq", 0, "polling period of the status of the lower partition (0: default)")
+	flag.StringVar(&cfg, "cfg", "", "path to a configuration file")
+
+	err := parse(flag.CommandLine, o.args, o, os.LookupEnv)
+	if err != nil {
+		log.Fatalf("could not parse flags: %+v", err)
+	}
+
+	if name == "" {
+		name = o.name
+	}
+	if upper == "" {
+		upper = name
+	}
+
+	level, err := parseLevel(lvl)
+	if err != nil {
+		log.Fatalf("could not parse msg-level: %+v", err)
+	}
+
+	cmd.Lower.Name = name
+	cmd.Upper.Name = upper
+	for _, p := range []*config.Process{&cmd.Lower, &cmd.Upper} {
+		p.Level = level
+		p.Trans = trans
+		p.Args = flag.Args()
+	}
+
+	if streams != "" {
+		cmd.Streams = make(map[string]string)
+		for _, kv := range strings.Split(streams, ",") {
+			i := strings.Index(kv, "=")
+			if i < 0 {
+				log.Fatalf("invalid stream %q (want in=out)", kv)
+			}
+			cmd.Streams[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	return cmd
+}
+
+// parse parses the command line arguments and then sets all the flags
+// that were not explicitly given on the command line from the environment
+// or from the configuration file.
+func parse(fs *flag.FlagSet, args []string, o options, getenv func(string) (string, bool)) error {
+	for k, v := range o.defs {
+		f := fs.Lookup(k)
+		if f == nil {
+			return fmt.Errorf("no such flag -%s", k)
+		}
+		err := f.Value.Set(v)
 		if err != nil {
-			return 0, fmt.Errorf("unknown level value %q: %+v", lvl, err)
+			return fmt.Errorf("invalid default value %q for flag -%s: %w", v, k, err)
 		}
-		return log.Level(v), nil
+		f.DefValue = v
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
 	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	env := func(name string) (string, bool) {
+		key := strings.ToUpper(strings.Replace(name, "-", "_", -1))
+		for _, prefix := range []string{envPrefix(o.name), "TDAQ"} {
+			if prefix == "" {
+				continue
+			}
+			v, ok := getenv(prefix + "_" + key)
+			if ok {
+				return v, true
+			}
+		}
+		return "", false
+	}
+
+	// a configuration file selected from the environment may be shared
+	// among tools with different sets of flags: only a configuration file
+	// given on the command line is required to match the flag set.
+	var cfg map[string]string
+	fname, ok := env("cfg")
+	strict := false
+	if f := fs.Lookup("cfg"); f != nil && set["cfg"] {
+		fname, ok, strict = f.Value.String(), true, true
+	}
+	if ok && fname != "" {
+		f, err := os.Open(fname)
+		if err != nil {
+			return fmt.Errorf("could not open configuration file: %w", err)
+		}
+		defer f.Close()
+
+		cfg, err = parseConfig(f)
+		if err != nil {
+			return fmt.Errorf("could not parse configuration file %q: %w", fname, err)
+		}
+
+		for k := range cfg {
+			if strict && fs.Lookup(k) == nil {
+				return fmt.Errorf("configuration file %q: no such flag -%s", fname, k)
+			}
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		if v, ok := env(f.Name); ok {
+			if e := f.Value.Set(v); e != nil {
+				err = fmt.Errorf("invalid value %q for flag -%s from environment: %w", v, f.Name, e)
+			}
+			return
+		}
+		if v, ok := cfg[f.Name]; ok {
+			if e := f.Value.Set(v); e != nil {
+				err = fmt.Errorf("invalid value %q for flag -%s from configuration file: %w", v, f.Name, e)
+			}
+			return
+		}
+	})
+
+	return err
+}
+
+// envPrefix returns the environment variables prefix for the named application.
+func envPrefix(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	return strings.Trim(name, "_")
+}
+
+// parseConfig parses a configuration file made of "name = value" lines.
+func parseConfig(r io.Reader) (map[string]string, error) {
+	var (
+		cfg = make(map[string]string)
+		sc  = bufio.NewScanner(r)
+		n   = 0
+	)
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing '=' in %q", n, line)
+		}
+		k := strings.TrimLeft(strings.TrimSpace(line[:i]), "-")
+		v := strings.TrimSpace(line[i+1:])
+		if k == "" {
+			return nil, fmt.Errorf("line %d: missing flag name in %q", n, line)
+		}
+		if uq, err := strconv.Unquote(v); err == nil {
+			v = uq
+		}
+		cfg[k] = v
+	}
+
+	err := sc.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func parseLevel(lvl string) (log.Level, error) {
+	return log.ParseLevel(lvl)
 }
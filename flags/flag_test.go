@@ -0,0 +1,210 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flags
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+func TestParse(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "tdaq-flags-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	fname := filepath.Join(tmp, "app.cfg")
+	err = ioutil.WriteFile(fname, []byte(`# config file
+id  = cfg-id
+net = "unix"
+lvl = warn
+`), 0644)
+	if err != nil {
+		t.Fatalf("could not create config file: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		env  map[string]string
+		defs map[string]string
+		want map[string]string
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{"id": "", "net": "tcp", "lvl": "INFO", "rc-addr": ":44000"},
+		},
+		{
+			name: "with-defaults",
+			defs: map[string]string{"rc-addr": ":8080"},
+			want: map[string]string{"id": "", "net": "tcp", "lvl": "INFO", "rc-addr": ":8080"},
+		},
+		{
+			name: "cfg-file",
+			args: []string{"-cfg", fname},
+			want: map[string]string{"id": "cfg-id", "net": "unix", "lvl": "warn", "rc-addr": ":44000"},
+		},
+		{
+			name: "env-cfg-file",
+			env:  map[string]string{"TDAQ_CFG": fname, "TDAQ_RC_ADDR": ":1234"},
+			want: map[string]string{"id": "cfg-id", "net": "unix", "lvl": "warn", "rc-addr": ":1234"},
+		},
+		{
+			name: "precedence",
+			args: []string{"-cfg", fname, "-id", "cmd-id"},
+			env: map[string]string{
+				"TDAQ_ID":     "env-id",
+				"TDAQ_NET":    "tcp",
+				"MY_APP_NET":  "udp",
+				"TDAQ_LVL":    "debug",
+				"OTHER_LVL":   "error",
+				"MY_APP_HBIT": "1",
+			},
+			want: map[string]string{"id": "cmd-id", "net": "udp", "lvl": "debug", "rc-addr": ":44000"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := flag.NewFlagSet(tc.name, flag.ContinueOnError)
+			fs.String("id", "", "")
+			fs.String("lvl", "INFO", "")
+			fs.String("net", "tcp", "")
+			fs.String("rc-addr", ":44000", "")
+			fs.String("cfg", "", "")
+
+			o := options{name: "my-app", defs: tc.defs}
+			getenv := func(k string) (string, bool) {
+				v, ok := tc.env[k]
+				return v, ok
+			}
+
+			err := parse(fs, tc.args, o, getenv)
+			if err != nil {
+				t.Fatalf("could not parse flags: %+v", err)
+			}
+
+			for k, want := range tc.want {
+				got := fs.Lookup(k).Value.String()
+				if got != want {
+					t.Fatalf("invalid value for -%s:\ngot = %q\nwant= %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseInvalidConfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "tdaq-flags-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	fname := filepath.Join(tmp, "app.cfg")
+	err = ioutil.WriteFile(fname, []byte("no-such-flag = 1\n"), 0644)
+	if err != nil {
+		t.Fatalf("could not create config file: %+v", err)
+	}
+
+	fs := flag.NewFlagSet("invalid", flag.ContinueOnError)
+	fs.String("cfg", "", "")
+
+	err = parse(fs, []string{"-cfg", fname}, options{name: "app"}, func(string) (string, bool) { return "", false })
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestProcFlags(t *testing.T) {
+	var (
+		link     = []string{"chunk-size", "mux", "reconnect-time", "max-reconnect-time", "stall-timeout"}
+		delivery = []string{"dead-letter", "dead-letter-deadline", "dead-letter-file", "dead-letter-ep", "spool-dir", "spool-max-size", "acked", "acked-dir", "ack-batch", "ack-timeout", "credit", "credit-bytes"}
+		debug    = []string{"latency", "trace-rate", "pprof", "health"}
+		device   = []string{"conditions", "checkpoint", "checkpoint-freq", "secrets"}
+		all      = [][]string{link, delivery, debug, device}
+	)
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		want [][]string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name: "link",
+			opts: []Option{WithLinkFlags()},
+			want: [][]string{link},
+		},
+		{
+			name: "delivery-debug",
+			opts: []Option{WithDeliveryFlags(), WithDebugFlags()},
+			want: [][]string{delivery, debug},
+		},
+		{
+			name: "device",
+			opts: []Option{WithDeviceFlags()},
+			want: [][]string{device},
+		},
+		{
+			name: "all",
+			opts: []Option{WithAllFlags()},
+			want: all,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				o   = newOptions(tc.opts)
+				fs  = flag.NewFlagSet(tc.name, flag.ContinueOnError)
+				cmd config.Process
+			)
+			for _, procs := range o.procs {
+				procs(fs, &cmd)
+			}
+
+			want := make(map[string]bool)
+			for _, names := range tc.want {
+				for _, name := range names {
+					want[name] = true
+				}
+			}
+			for _, names := range all {
+				for _, name := range names {
+					if got := fs.Lookup(name) != nil; got != want[name] {
+						t.Fatalf("invalid declaration of -%s: got=%v, want=%v", name, got, want[name])
+					}
+				}
+			}
+		})
+	}
+
+	var (
+		fs   = flag.NewFlagSet("delivery", flag.ContinueOnError)
+		cmd  config.Process
+		done = deliveryFlags(fs, &cmd)
+	)
+	err := fs.Parse([]string{"-acked", "/adc,/tdc", "-credit", "/adc", "-spool-dir", "/tmp/spool"})
+	if err != nil {
+		t.Fatalf("could not parse flags: %+v", err)
+	}
+	done()
+
+	if got, want := cmd.Acked.EndPoints, []string{"/adc", "/tdc"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid acked end-points: got=%q, want=%q", got, want)
+	}
+	if got, want := cmd.Credit.EndPoints, []string{"/adc"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid credit end-points: got=%q, want=%q", got, want)
+	}
+	if got, want := cmd.Spool.Dir, "/tmp/spool"; got != want {
+		t.Fatalf("invalid spool dir: got=%q, want=%q", got, want)
+	}
+}
@@ -19,6 +19,7 @@ const (
 	Running
 	Exiting
 	Error
+	Paused
 )
 
 func (st Status) String() string {
@@ -37,6 +38,8 @@ func (st Status) String() string {
 		return "exiting"
 	case Error:
 		return "error"
+	case Paused:
+		return "paused"
 	default:
 		panic(fmt.Errorf("invalid status value %d", uint8(st)))
 	}
@@ -19,6 +19,7 @@ func TestStatus(t *testing.T) {
 		{status: Stopped, want: "stopped"},
 		{status: Exiting, want: "exiting"},
 		{status: Error, want: "error"},
+		{status: Paused, want: "paused"},
 		{status: Status(255), panics: true},
 	} {
 		t.Run("", func(t *testing.T) {
@@ -0,0 +1,237 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultGatewayQueueLen   = 1024
+	defaultGatewayStatusFreq = 5 * time.Second
+)
+
+// Gateway republishes data streams and status of a lower TDAQ partition
+// into an upper TDAQ partition, enabling hierarchical TDAQ systems where
+// sub-detector run-ctls feed a global one.
+//
+// The gateway appears as a consumer device in the lower partition and as
+// a producer device in the upper partition.
+// Data frames received from the lower partition are buffered and dropped
+// (and counted) when the upper partition does not consume them, so the
+// upper partition never stalls the lower one.
+//
+// The gateway publishes, as monitoring variables of the upper partition,
+// the number of republished and dropped frames of each stream and, when
+// configured, the status of the lower partition.
+type Gateway struct {
+	cfg     config.Gateway
+	lower   *Server
+	upper   *Server
+	streams []*gwStream
+	client  *http.Client
+}
+
+// gwStream is a data stream republished by a gateway.
+type gwStream struct {
+	in  string // input end-point in the lower partition
+	out string // output end-point in the upper partition
+	ch  chan []byte
+
+	n    uint64 // number of republished frames (atomic)
+	drop uint64 // number of dropped frames (atomic)
+}
+
+// NewGateway creates a new gateway between two TDAQ partitions.
+func NewGateway(cfg config.Gateway, stdout io.Writer) (*Gateway, error) {
+	if len(cfg.Streams) == 0 {
+		return nil, fmt.Errorf("tdaq: gateway without streams")
+	}
+	if cfg.QueueLen <= 0 {
+		cfg.QueueLen = defaultGatewayQueueLen
+	}
+	if cfg.StatusFreq <= 0 {
+		cfg.StatusFreq = defaultGatewayStatusFreq
+	}
+
+	gw := &Gateway{
+		cfg:    cfg,
+		lower:  New(cfg.Lower, stdout),
+		upper:  New(cfg.Upper, stdout),
+		client: &http.Client{Timeout: cfg.StatusFreq},
+	}
+
+	for _, srv := range []*Server{gw.lower, gw.upper} {
+		for _, name := range []string{"/config", "/init", "/reset", "/start", "/stop", "/quit"} {
+			srv.CmdHandle(name, gw.onCmd)
+		}
+	}
+
+	ins := make([]string, 0, len(cfg.Streams))
+	for in := range cfg.Streams {
+		ins = append(ins, in)
+	}
+	sort.Strings(ins)
+
+	for _, in := range ins {
+		s := &gwStream{
+			in:  in,
+			out: cfg.Streams[in],
+			ch:  make(chan []byte, cfg.QueueLen),
+		}
+		gw.streams = append(gw.streams, s)
+		gw.lower.InputHandle(s.in, s.recv)
+		gw.upper.OutputHandle(s.out, s.send)
+	}
+	gw.upper.RunHandle(gw.publish)
+
+	return gw, nil
+}
+
+// Run runs the gateway until either partition terminates it.
+func (gw *Gateway) Run(ctx context.Context) error {
+	grp, ctx := errgroup.WithContext(ctx)
+	grp.Go(func() error {
+		err := gw.lower.Run(ctx)
+		if err != nil {
+			return fmt.Errorf("could not run lower partition process: %w", err)
+		}
+		return nil
+	})
+	grp.Go(func() error {
+		err := gw.upper.Run(ctx)
+		if err != nil {
+			return fmt.Errorf("could not run upper partition process: %w", err)
+		}
+		return nil
+	})
+	return grp.Wait()
+}
+
+func (gw *Gateway) onCmd(ctx Context, resp *Frame, req Frame) error {
+	ctx.Msg.Debugf("received %s command...", req.Path)
+	return nil
+}
+
+func (s *gwStream) recv(ctx Context, src Frame) error {
+	body := make([]byte, len(src.Body))
+	copy(body, src.Body)
+	select {
+	case s.ch <- body:
+	default:
+		if atomic.AddUint64(&s.drop, 1) == 1 {
+			ctx.Msg.Warnf("upper partition not consuming %q: dropping data frames", s.out)
+		}
+	}
+	return nil
+}
+
+func (s *gwStream) send(ctx Context, dst *Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+	case dst.Body = <-s.ch:
+		atomic.AddUint64(&s.n, 1)
+	}
+	return nil
+}
+
+// publish periodically publishes the counters of the streams and the status
+// of the lower partition, while the upper partition is running.
+func (gw *Gateway) publish(ctx Context) error {
+	tick := time.NewTicker(gw.cfg.StatusFreq)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Ctx.Done():
+			return nil
+		case <-tick.C:
+			for _, s := range gw.streams {
+				gw.upper.Monitor(s.out+"/frames", float64(atomic.LoadUint64(&s.n)))
+				gw.upper.Monitor(s.out+"/dropped", float64(atomic.LoadUint64(&s.drop)))
+			}
+			if gw.cfg.StatusURL == "" {
+				continue
+			}
+			report, err := gw.lowerStatus(ctx.Ctx)
+			if err != nil {
+				ctx.Msg.Warnf("could not retrieve status of lower partition: %+v", err)
+				continue
+			}
+			for name, v := range gatewayStatusVars(report) {
+				gw.upper.Monitor(name, v)
+			}
+		}
+	}
+}
+
+func (gw *Gateway) lowerStatus(ctx context.Context) (statusReport, error) {
+	var report statusReport
+	req, err := http.NewRequest(http.MethodGet, gw.cfg.StatusURL, nil)
+	if err != nil {
+		return report, fmt.Errorf("could not create status request: %w", err)
+	}
+	resp, err := gw.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return report, fmt.Errorf("could not send status request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return report, fmt.Errorf("invalid status response: %s", resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	if err != nil {
+		return report, fmt.Errorf("could not decode status report: %w", err)
+	}
+	return report, nil
+}
+
+// gatewayStatusVars returns the monitoring variables describing the
+// status of a lower partition.
+// States are published with their fsm.Status values.
+func gatewayStatusVars(report statusReport) map[string]float64 {
+	vars := map[string]float64{
+		"/lower/procs": float64(len(report.Procs)),
+	}
+	if st, ok := parseStatus(report.Status); ok {
+		vars["/lower/status"] = float64(st)
+	}
+	var running int
+	for _, proc := range report.Procs {
+		st, ok := parseStatus(proc.Status)
+		if !ok {
+			continue
+		}
+		vars["/lower/"+proc.Name+"/status"] = float64(st)
+		if st == fsm.Running {
+			running++
+		}
+	}
+	vars["/lower/running"] = float64(running)
+	return vars
+}
+
+func parseStatus(v string) (fsm.Status, bool) {
+	for st := fsm.UnConf; st <= fsm.Paused; st++ {
+		if st.String() == v {
+			return st, true
+		}
+	}
+	return 0, false
+}
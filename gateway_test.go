@@ -0,0 +1,69 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestGatewayStatusVars(t *testing.T) {
+	report := statusReport{
+		Status: "running",
+		Procs: []procStatus{
+			{Name: "adc", Status: "running"},
+			{Name: "tdc", Status: "stopped"},
+			{Name: "bad", Status: "n/a"},
+		},
+	}
+	got := gatewayStatusVars(report)
+	want := map[string]float64{
+		"/lower/procs":      3,
+		"/lower/status":     float64(fsm.Running),
+		"/lower/adc/status": float64(fsm.Running),
+		"/lower/tdc/status": float64(fsm.Stopped),
+		"/lower/running":    1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid status variables:\ngot = %#v\nwant= %#v", got, want)
+	}
+}
+
+func TestGatewayStream(t *testing.T) {
+	s := &gwStream{in: "/adc", out: "/det/adc", ch: make(chan []byte, 2)}
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("gw", log.LvlError, ioutil.Discard),
+	}
+
+	for _, v := range []string{"a", "b", "c"} {
+		err := s.recv(ctx, Frame{Type: FrameData, Path: s.in, Body: []byte(v)})
+		if err != nil {
+			t.Fatalf("could not receive frame: %+v", err)
+		}
+	}
+	if got, want := s.drop, uint64(1); got != want {
+		t.Fatalf("invalid number of dropped frames: got=%d, want=%d", got, want)
+	}
+
+	for _, want := range []string{"a", "b"} {
+		var dst Frame
+		err := s.send(ctx, &dst)
+		if err != nil {
+			t.Fatalf("could not send frame: %+v", err)
+		}
+		if got := string(dst.Body); got != want {
+			t.Fatalf("invalid frame: got=%q, want=%q", got, want)
+		}
+	}
+	if got, want := s.n, uint64(2); got != want {
+		t.Fatalf("invalid number of republished frames: got=%d, want=%d", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-daq/tdaq/graphql"
+)
+
+// runHistorySize is the number of summaries of stopped runs kept by run-ctl.
+const runHistorySize = 64
+
+// runInfo describes the current (or last) run of run-ctl.
+type runInfo struct {
+	Number uint64     `json:"number"`           // run number
+	Start  time.Time  `json:"start"`            // start time of the run
+	Totals *RunTotals `json:"totals,omitempty"` // run-wide statistics of the run
+}
+
+// Query executes the provided GraphQL query against the state of run-ctl.
+//
+// The root fields of the queries are:
+//   - state: the status of run-ctl,
+//   - run: the number, start time and run-wide statistics of the current
+//     (or last) run,
+//   - devices(name: String): the status of the connected TDAQ processes
+//     (or of the named one), with their data links, latencies and runtimes,
+//   - topology: the reconciliation of the expected and connected processes,
+//   - graph: the dataflow graph of the processes,
+//   - endpoints(name: String): the data end-points connecting the processes
+//     (or the named one), with their rates,
+//   - runs(last: Int): the summaries of the last stopped runs, oldest first.
+//
+// Fields are named after their JSON names, in camel case (e.g. byteRate).
+//
+// ex:
+//
+//	{
+//	    state
+//	    run { number totals { bytes dead } }
+//	    devices { name status links { addr up frames } }
+//	    endpoints(name: "/adc") { source target rate byteRate }
+//	}
+func (rc *RunControl) Query(req graphql.Request) graphql.Response {
+	return rc.schema().Do(req)
+}
+
+// schema returns the GraphQL schema of the state of run-ctl.
+func (rc *RunControl) schema() graphql.Schema {
+	return graphql.Schema{
+		"state": {
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				rc.mu.RLock()
+				defer rc.mu.RUnlock()
+				return rc.status.String(), nil
+			},
+		},
+		"run": {
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				rc.mu.RLock()
+				defer rc.mu.RUnlock()
+				return runInfo{
+					Number: rc.runNbr,
+					Start:  rc.runStart,
+					Totals: rc.runTotals(),
+				}, nil
+			},
+		},
+		"devices": {
+			Args: []string{"name"},
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				name, err := stringArg(args, "name")
+				if err != nil {
+					return nil, err
+				}
+				rc.mu.RLock()
+				procs := rc.statusReport().Procs
+				rc.mu.RUnlock()
+
+				devs := make([]procStatus, 0, len(procs))
+				for _, proc := range procs {
+					if name != "" && proc.Name != name {
+						continue
+					}
+					devs = append(devs, proc)
+				}
+				return devs, nil
+			},
+		},
+		"topology": {
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				return rc.Devices(), nil
+			},
+		},
+		"graph": {
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				return rc.Graph(), nil
+			},
+		},
+		"endpoints": {
+			Args: []string{"name"},
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				name, err := stringArg(args, "name")
+				if err != nil {
+					return nil, err
+				}
+				links := rc.Graph().Links
+				eps := make([]GraphLink, 0, len(links))
+				for _, link := range links {
+					if name != "" && link.EndPoint != name {
+						continue
+					}
+					eps = append(eps, link)
+				}
+				return eps, nil
+			},
+		},
+		"runs": {
+			Args: []string{"last"},
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				last, err := intArg(args, "last")
+				if err != nil {
+					return nil, err
+				}
+				if last < 0 {
+					return nil, fmt.Errorf("invalid negative number of runs %d", last)
+				}
+				rc.mu.RLock()
+				defer rc.mu.RUnlock()
+				runs := rc.runs
+				if last > 0 && len(runs) > last {
+					runs = runs[len(runs)-last:]
+				}
+				return append([]RunSummary{}, runs...), nil
+			},
+		},
+	}
+}
+
+// stringArg returns the value of the named string argument, if any.
+func stringArg(args map[string]interface{}, name string) (string, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("invalid argument %q of type %T (want a string)", name, v)
+	}
+}
+
+// intArg returns the value of the named integer argument, if any.
+// Integers decoded from JSON variables are accepted as float64.
+func intArg(args map[string]interface{}, name string) (int, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("invalid argument %q value %v (want an integer)", name, v)
+		}
+		return int(v), nil
+	default:
+		return 0, fmt.Errorf("invalid argument %q of type %T (want an integer)", name, v)
+	}
+}
+
+// webAPIGraphQL replies with the JSON response of the GraphQL query of the
+// request.
+// GET requests carry the query in the "query", "operationName" and
+// "variables" (a JSON object) form values; POST requests carry it as a JSON
+// document in their body.
+func (rc *RunControl) webAPIGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.FormValue("query")
+		req.OperationName = r.FormValue("operationName")
+		if v := r.FormValue("variables"); v != "" {
+			err := json.Unmarshal([]byte(v), &req.Variables)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid variables: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+	case http.MethodPost:
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not decode GraphQL request: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if req.Query == "" {
+		http.Error(w, "missing query", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(rc.Query(req))
+	if err != nil {
+		rc.msg.Errorf("could not encode GraphQL response: %+v", err)
+		return
+	}
+}
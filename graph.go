@@ -0,0 +1,187 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/go-daq/tdaq/fsm"
+)
+
+// Graph is the dataflow graph of the TDAQ processes managed by run-ctl.
+//
+// Graph encodes to JSON as a nodes/links document directly usable by d3
+// force layouts, and can be written in the Graphviz DOT format.
+type Graph struct {
+	Status string      `json:"status"` // status of run-ctl
+	Nodes  []GraphNode `json:"nodes"`
+	Links  []GraphLink `json:"links"`
+}
+
+// GraphNode is a TDAQ process of a dataflow graph.
+type GraphNode struct {
+	ID     string `json:"id"`     // name of the process
+	Status string `json:"status"` // FSM status of the process
+	Slow   bool   `json:"slow"`   // whether the process cannot keep up with its producers
+}
+
+// GraphLink is a data end-point connecting two TDAQ processes of a
+// dataflow graph.
+type GraphLink struct {
+	Source   string  `json:"source"`         // name of the producer process
+	Target   string  `json:"target"`         // name of the consumer process
+	EndPoint string  `json:"endpoint"`       // name of the data end-point
+	Type     string  `json:"type,omitempty"` // type of the data frames of the end-point
+	Up       bool    `json:"up"`             // whether the data link is connected
+	Stalled  bool    `json:"stalled"`        // whether the data link stalled
+	Frames   uint64  `json:"frames"`         // number of data frames received on the data link
+	Bytes    uint64  `json:"bytes"`          // number of payload bytes received on the data link
+	Rate     float64 `json:"rate"`           // rate of data frames, in frames/s
+	ByteRate float64 `json:"byte-rate"`      // rate of payload bytes, in bytes/s
+}
+
+// Graph returns the current dataflow graph of the TDAQ processes, with
+// their states and the rates of their data links.
+func (rc *RunControl) Graph() Graph {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	graph := Graph{
+		Status: rc.status.String(),
+		Nodes:  make([]GraphNode, 0, rc.clients.len()),
+		Links:  make([]GraphLink, 0),
+	}
+
+	clis := rc.clients.list()
+	prods := make(map[string]string) // output end-point -> producer
+	for _, cli := range clis {
+		for _, ep := range cli.oeps {
+			prods[ep.Name] = cli.name
+		}
+	}
+
+	for _, cli := range clis {
+		name := cli.name
+		cli.mu.RLock()
+		graph.Nodes = append(graph.Nodes, GraphNode{
+			ID:     name,
+			Status: cli.status.String(),
+			Slow:   cli.slow(),
+		})
+		for _, ep := range cli.ieps {
+			prod, ok := prods[ep.Name]
+			if !ok {
+				continue
+			}
+			link := GraphLink{
+				Source:   prod,
+				Target:   name,
+				EndPoint: ep.Name,
+				Type:     ep.Type,
+			}
+			for _, st := range cli.links {
+				if !hasEndPoint(st, ep.Name) {
+					continue
+				}
+				link.Up = st.Up
+				link.Stalled = st.Stalled
+				link.Frames = st.Frames
+				link.Bytes = st.Bytes
+				if h, ok := cli.hists[st.Addr]; ok {
+					link.Rate = h.rate
+					link.ByteRate = h.brate
+				}
+				break
+			}
+			graph.Links = append(graph.Links, link)
+		}
+		cli.mu.RUnlock()
+	}
+
+	return graph
+}
+
+func hasEndPoint(link LinkStatus, name string) bool {
+	for _, ep := range link.EndPoints {
+		if ep == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteDOT writes the dataflow graph in the Graphviz DOT format.
+func (g Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph tdaq {\n")
+	fmt.Fprintf(bw, "\tlabel=%s;\n", strconv.Quote("run-ctl: "+g.Status))
+	fmt.Fprintf(bw, "\trankdir=LR;\n")
+	fmt.Fprintf(bw, "\tnode [shape=box, style=\"rounded,filled\"];\n\n")
+
+	for _, n := range g.Nodes {
+		label := n.ID + "\n" + n.Status
+		if n.Slow {
+			label += " (slow)"
+		}
+		fmt.Fprintf(bw, "\t%s [label=%s, fillcolor=%q];\n",
+			strconv.Quote(n.ID), strconv.Quote(label), dotColor(n.Status),
+		)
+	}
+	if len(g.Links) > 0 {
+		fmt.Fprintf(bw, "\n")
+	}
+	for _, l := range g.Links {
+		label := l.EndPoint
+		if l.Type != "" {
+			label += " [" + l.Type + "]"
+		}
+		label += fmt.Sprintf("\n%.1f Hz, %s/s", l.Rate, byteSize(l.ByteRate))
+		color := "black"
+		switch {
+		case l.Stalled:
+			color = "orange"
+		case !l.Up && l.Frames > 0:
+			color = "red"
+		}
+		fmt.Fprintf(bw, "\t%s -> %s [label=%s, color=%q];\n",
+			strconv.Quote(l.Source), strconv.Quote(l.Target), strconv.Quote(label), color,
+		)
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+func dotColor(status string) string {
+	switch status {
+	case fsm.Running.String():
+		return "palegreen"
+	case fsm.Paused.String():
+		return "khaki"
+	case fsm.Stopped.String(), fsm.Init.String(), fsm.Conf.String():
+		return "lightblue"
+	case fsm.Error.String():
+		return "tomato"
+	case fsm.Exiting.String():
+		return "gray"
+	default:
+		return "white"
+	}
+}
+
+func byteSize(v float64) string {
+	const unit = 1024
+	if v < unit {
+		return fmt.Sprintf("%.0f B", v)
+	}
+	div, exp := float64(unit), 0
+	for n := v / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", v/div, "KMGTP"[exp])
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+)
+
+func TestGraph(t *testing.T) {
+	t0 := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+
+	link := LinkStatus{Addr: "tcp://gen:1234", EndPoints: []string{"/adc"}, Up: true, Frames: 10, Bytes: 1000}
+	hist := new(linkHist)
+	hist.sample(t0, link)
+	link.Frames += 20
+	link.Bytes += 4096
+	hist.sample(t0.Add(2*time.Second), link)
+
+	rc := &RunControl{
+		status: fsm.Running,
+		clients: newClientDB(
+			&client{
+				name:   "gen",
+				status: fsm.Running,
+				oeps:   []EndPoint{{Name: "/adc", Addr: "tcp://gen:1234", Type: "adc"}},
+			},
+			&client{
+				name:   "dump",
+				status: fsm.Running,
+				ieps:   []EndPoint{{Name: "/adc", Addr: "tcp://gen:1234", Type: "adc"}, {Name: "/tdc"}},
+				links:  []LinkStatus{link},
+				hists:  map[string]*linkHist{link.Addr: hist},
+			},
+		),
+	}
+
+	graph := rc.Graph()
+	want := Graph{
+		Status: "running",
+		Nodes: []GraphNode{
+			{ID: "dump", Status: "running"},
+			{ID: "gen", Status: "running"},
+		},
+		Links: []GraphLink{
+			{
+				Source: "gen", Target: "dump", EndPoint: "/adc", Type: "adc",
+				Up: true, Frames: 30, Bytes: 5096, Rate: 10, ByteRate: 2048,
+			},
+		},
+	}
+	if !reflect.DeepEqual(graph, want) {
+		t.Fatalf("invalid graph:\ngot = %#v\nwant= %#v", graph, want)
+	}
+
+	dot := new(strings.Builder)
+	err := graph.WriteDOT(dot)
+	if err != nil {
+		t.Fatalf("could not write DOT graph: %+v", err)
+	}
+	if got, want := dot.String(), `digraph tdaq {
+	label="run-ctl: running";
+	rankdir=LR;
+	node [shape=box, style="rounded,filled"];
+
+	"dump" [label="dump\nrunning", fillcolor="palegreen"];
+	"gen" [label="gen\nrunning", fillcolor="palegreen"];
+
+	"gen" -> "dump" [label="/adc [adc]\n10.0 Hz, 2.0 KiB/s", color="black"];
+}
+`; got != want {
+		t.Fatalf("invalid DOT graph:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
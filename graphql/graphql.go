@@ -0,0 +1,439 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package graphql implements a small subset of the GraphQL query language,
+// to select the fields of Go values.
+//
+// Documents are made of queries, possibly named and declaring variables
+// (with default values), with:
+//   - fields, possibly aliased (alias: field),
+//   - arguments of the root fields: integers, floats, strings, booleans,
+//     null, enum values (as strings), lists, input objects and variables,
+//   - nested selection sets.
+//
+// Mutations, subscriptions, fragments and directives are not supported.
+//
+// The root fields of a query are resolved by a Schema. Fields of the
+// resolved values are then selected from structs (by the name of their
+// JSON tags), maps with string keys, slices and arrays, and pointers to
+// those. JSON names are exposed with hyphens converted to camel case
+// (e.g. "byte-rate" is selected as byteRate). Values implementing
+// json.Marshaler (e.g. time.Time) are scalars.
+//
+// ex:
+//
+//	query Devices($name: String) {
+//	    state
+//	    devices(name: $name) { name status links { addr up rate: frames } }
+//	}
+package graphql // import "github.com/go-daq/tdaq/graphql"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Field is a root field of a schema.
+type Field struct {
+	Args    []string                                               // names of the arguments of the field
+	Resolve func(args map[string]interface{}) (interface{}, error) // resolves the value of the field
+}
+
+// Schema describes the root fields of the queries, by name.
+type Schema map[string]Field
+
+// Request is a GraphQL request, as sent by clients.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the response to a GraphQL request.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []Error     `json:"errors,omitempty"`
+}
+
+// Error is an error of a GraphQL response.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"` // path of the field in error, if any
+}
+
+// Do parses and executes the request against the schema.
+// Errors of the root fields are reported in the response, along with the
+// data of the other fields.
+func (s Schema) Do(req Request) Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	return doc.Exec(s, req.OperationName, req.Variables)
+}
+
+// Document is a parsed GraphQL document.
+type Document struct {
+	ops []*operation
+}
+
+type operation struct {
+	name string
+	vars map[string]interface{} // default values of the declared variables
+	sel  []*selection
+}
+
+type selection struct {
+	alias string
+	name  string
+	args  []argument
+	sel   []*selection
+}
+
+type argument struct {
+	name string
+	val  interface{}
+}
+
+// variable is a reference to a variable of a query.
+type variable string
+
+// key returns the key of the field in the response.
+func (sel *selection) key() string {
+	if sel.alias != "" {
+		return sel.alias
+	}
+	return sel.name
+}
+
+// Parse parses the provided GraphQL document.
+func Parse(src string) (*Document, error) {
+	p := parser{lex: lexer{src: src}}
+	err := p.next()
+	if err != nil {
+		return nil, fmt.Errorf("graphql: could not parse document: %w", err)
+	}
+
+	var doc Document
+	for p.tok.kind != tokEOF {
+		op, err := p.operation()
+		if err != nil {
+			return nil, fmt.Errorf("graphql: could not parse document: %w", err)
+		}
+		doc.ops = append(doc.ops, op)
+	}
+	if len(doc.ops) == 0 {
+		return nil, fmt.Errorf("graphql: empty document")
+	}
+	return &doc, nil
+}
+
+// Exec executes the named operation of the document (or its only operation
+// if name is empty) against the schema, with the provided variables.
+func (doc *Document) Exec(s Schema, name string, vars map[string]interface{}) Response {
+	var op *operation
+	switch {
+	case name == "" && len(doc.ops) == 1:
+		op = doc.ops[0]
+	case name == "":
+		return Response{Errors: []Error{{Message: "graphql: operation name required for documents with multiple operations"}}}
+	default:
+		for _, o := range doc.ops {
+			if o.name == name {
+				op = o
+				break
+			}
+		}
+		if op == nil {
+			return Response{Errors: []Error{{Message: fmt.Sprintf("graphql: unknown operation %q", name)}}}
+		}
+	}
+
+	env := make(map[string]interface{}, len(op.vars)+len(vars))
+	for k, v := range op.vars {
+		env[k] = v
+	}
+	for k, v := range vars {
+		env[k] = v
+	}
+
+	var (
+		resp Response
+		data object
+	)
+	for _, sel := range op.sel {
+		v, err := s.resolve(sel, env)
+		if err != nil {
+			resp.Errors = append(resp.Errors, Error{
+				Message: err.Error(),
+				Path:    []interface{}{sel.key()},
+			})
+		}
+		data = append(data, member{sel.key(), v})
+	}
+	resp.Data = data
+	return resp
+}
+
+func (s Schema) resolve(sel *selection, env map[string]interface{}) (interface{}, error) {
+	field, ok := s[sel.name]
+	if !ok {
+		return nil, fmt.Errorf("graphql: unknown field %q", sel.name)
+	}
+
+	args := make(map[string]interface{}, len(sel.args))
+	for _, arg := range sel.args {
+		if !contains(field.Args, arg.name) {
+			return nil, fmt.Errorf("graphql: unknown argument %q of field %q", arg.name, sel.name)
+		}
+		v, err := eval(arg.val, env)
+		if err != nil {
+			return nil, fmt.Errorf("graphql: invalid argument %q of field %q: %w", arg.name, sel.name, err)
+		}
+		args[arg.name] = v
+	}
+
+	v, err := field.Resolve(args)
+	if err != nil {
+		return nil, fmt.Errorf("graphql: could not resolve field %q: %w", sel.name, err)
+	}
+
+	v, err = project(reflect.ValueOf(v), sel)
+	if err != nil {
+		return nil, fmt.Errorf("graphql: %w", err)
+	}
+	return v, nil
+}
+
+// eval substitutes the variables of the provided argument value.
+func eval(v interface{}, env map[string]interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case variable:
+		val, ok := env[string(v)]
+		if !ok {
+			return nil, fmt.Errorf("undefined variable $%s", v)
+		}
+		return val, nil
+	case []interface{}:
+		o := make([]interface{}, len(v))
+		for i := range v {
+			var err error
+			o[i], err = eval(v[i], env)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return o, nil
+	case map[string]interface{}:
+		o := make(map[string]interface{}, len(v))
+		for k := range v {
+			var err error
+			o[k], err = eval(v[k], env)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return o, nil
+	default:
+		return v, nil
+	}
+}
+
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// project selects the fields of sel from the provided value.
+func project(rv reflect.Value, sel *selection) (interface{}, error) {
+	for rv.IsValid() && (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		if rv.Type().Implements(jsonMarshaler) {
+			break
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil, nil
+	}
+
+	if rv.Type().Implements(jsonMarshaler) {
+		return scalar(rv, sel)
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return scalar(rv, sel)
+		}
+		o := make([]interface{}, rv.Len())
+		for i := range o {
+			v, err := project(rv.Index(i), sel)
+			if err != nil {
+				return nil, err
+			}
+			o[i] = v
+		}
+		return o, nil
+
+	case reflect.Struct:
+		if len(sel.sel) == 0 {
+			return nil, fmt.Errorf("field %q of object type requires a selection of subfields", sel.name)
+		}
+		fields := structFields(rv.Type())
+		o := make(object, 0, len(sel.sel))
+		for _, sub := range sel.sel {
+			if len(sub.args) > 0 {
+				return nil, fmt.Errorf("field %q does not take arguments", sub.name)
+			}
+			i, ok := fields[sub.name]
+			if !ok {
+				return nil, fmt.Errorf("unknown field %q of %q", sub.name, sel.name)
+			}
+			v, err := project(rv.Field(i), sub)
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, member{sub.key(), v})
+		}
+		return o, nil
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return scalar(rv, sel)
+		}
+		if len(sel.sel) == 0 {
+			return nil, fmt.Errorf("field %q of object type requires a selection of subfields", sel.name)
+		}
+		keys := make(map[string]reflect.Value, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys[Name(k.String())] = k
+		}
+		o := make(object, 0, len(sel.sel))
+		for _, sub := range sel.sel {
+			if len(sub.args) > 0 {
+				return nil, fmt.Errorf("field %q does not take arguments", sub.name)
+			}
+			var v interface{}
+			if k, ok := keys[sub.name]; ok {
+				var err error
+				v, err = project(rv.MapIndex(k), sub)
+				if err != nil {
+					return nil, err
+				}
+			}
+			o = append(o, member{sub.key(), v})
+		}
+		return o, nil
+
+	default:
+		return scalar(rv, sel)
+	}
+}
+
+// scalar returns the value of a leaf field.
+func scalar(rv reflect.Value, sel *selection) (interface{}, error) {
+	if len(sel.sel) > 0 {
+		return nil, fmt.Errorf("field %q of scalar type has no subfields", sel.name)
+	}
+	if !rv.CanInterface() {
+		return nil, fmt.Errorf("field %q is not exported", sel.name)
+	}
+	return rv.Interface(), nil
+}
+
+// structFields returns the indices of the fields of a struct type, by
+// GraphQL name.
+func structFields(rt reflect.Type) map[string]int {
+	fields := make(map[string]int, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tag = strings.Split(tag, ",")[0]
+			switch tag {
+			case "-":
+				continue
+			case "":
+			default:
+				name = tag
+			}
+		}
+		fields[Name(name)] = i
+	}
+	return fields
+}
+
+// Name returns the GraphQL name of the provided JSON name: hyphens and
+// dots are removed and the following letters upper-cased
+// (e.g. "byte-rate" is byteRate).
+func Name(s string) string {
+	if !strings.ContainsAny(s, "-.") {
+		return s
+	}
+	var (
+		o  strings.Builder
+		up bool
+	)
+	for _, c := range s {
+		switch {
+		case c == '-' || c == '.':
+			up = true
+		case up:
+			o.WriteString(strings.ToUpper(string(c)))
+			up = false
+		default:
+			o.WriteRune(c)
+		}
+	}
+	return o.String()
+}
+
+func contains(set []string, v string) bool {
+	for _, s := range set {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// object is a JSON object whose members keep the order of the selection.
+type object []member
+
+type member struct {
+	key string
+	val interface{}
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(m.key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		raw, err := json.Marshal(m.val)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(raw)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
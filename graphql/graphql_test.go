@@ -0,0 +1,243 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type link struct {
+	Addr     string  `json:"addr"`
+	Up       bool    `json:"up"`
+	ByteRate float64 `json:"byte-rate"`
+	hidden   int
+}
+
+type device struct {
+	Name  string            `json:"name"`
+	Links []link            `json:"links,omitempty"`
+	Since time.Time         `json:"since"`
+	Attrs map[string]string `json:"attrs"`
+	Skip  int               `json:"-"`
+	Raw   []byte
+}
+
+func TestDo(t *testing.T) {
+	devs := []*device{
+		{
+			Name:  "adc",
+			Links: []link{{Addr: "tcp://h1", Up: true, ByteRate: 1.5}},
+			Since: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Attrs: map[string]string{"hw-id": "42"},
+			Raw:   []byte("raw"),
+		},
+		{Name: "tdc"},
+	}
+
+	schema := Schema{
+		"state": {
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				return "running", nil
+			},
+		},
+		"devices": {
+			Args: []string{"name", "first"},
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				if n, ok := args["first"].(int64); ok {
+					return devs[:n], nil
+				}
+				name, _ := args["name"].(string)
+				if name == "" {
+					return devs, nil
+				}
+				for _, dev := range devs {
+					if dev.Name == name {
+						return dev, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+		"echo": {
+			Args: []string{"v"},
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				return args["v"], nil
+			},
+		},
+		"fail": {
+			Resolve: func(args map[string]interface{}) (interface{}, error) {
+				return nil, fmt.Errorf("boom")
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		req   Request
+		want  string
+		error string
+	}{
+		{
+			name: "simple",
+			req:  Request{Query: "{ state }"},
+			want: `{"data":{"state":"running"}}`,
+		},
+		{
+			name: "nested",
+			req: Request{Query: `# devices.
+				query {
+					state,
+					devices { name links { addr up byteRate } }
+				}`},
+			want: `{"data":{"state":"running","devices":[{"name":"adc","links":[{"addr":"tcp://h1","up":true,"byteRate":1.5}]},{"name":"tdc","links":null}]}}`,
+		},
+		{
+			name: "alias-args",
+			req:  Request{Query: `{ a: devices(name: "adc") { id: name since attrs { hwId none } Raw } b: devices(first: 1) { name } }`},
+			want: `{"data":{"a":{"id":"adc","since":"2020-01-02T03:04:05Z","attrs":{"hwId":"42","none":null},"Raw":"cmF3"},"b":[{"name":"adc"}]}}`,
+		},
+		{
+			name: "variables",
+			req: Request{
+				Query:         `query A { state } query B($name: String = "tdc", $v: [Int!]! = [1, 2]) { devices(name: $name) { name } echo(v: {x: $v, y: -2.5e1, z: null, e: FOO}) { e x y z } }`,
+				OperationName: "B",
+			},
+			want: `{"data":{"devices":{"name":"tdc"},"echo":{"e":"FOO","x":[1,2],"y":-25,"z":null}}}`,
+		},
+		{
+			name: "variables-override",
+			req: Request{
+				Query:     `query ($name: String = "tdc") { devices(name: $name) { name } }`,
+				Variables: map[string]interface{}{"name": "adc"},
+			},
+			want: `{"data":{"devices":{"name":"adc"}}}`,
+		},
+		{
+			name: "partial-error",
+			req:  Request{Query: `{ state fail }`},
+			want: `{"data":{"state":"running","fail":null},"errors":[{"message":"graphql: could not resolve field \"fail\": boom","path":["fail"]}]}`,
+		},
+		{
+			name:  "unknown-field",
+			req:   Request{Query: `{ nope }`},
+			error: `graphql: unknown field "nope"`,
+		},
+		{
+			name:  "unknown-subfield",
+			req:   Request{Query: `{ devices { hidden } }`},
+			error: `graphql: unknown field "hidden" of "devices"`,
+		},
+		{
+			name:  "skipped-subfield",
+			req:   Request{Query: `{ devices { Skip } }`},
+			error: `graphql: unknown field "Skip" of "devices"`,
+		},
+		{
+			name:  "unknown-arg",
+			req:   Request{Query: `{ state(x: 1) }`},
+			error: `graphql: unknown argument "x" of field "state"`,
+		},
+		{
+			name:  "undefined-var",
+			req:   Request{Query: `{ echo(v: $x) }`},
+			error: `graphql: invalid argument "v" of field "echo": undefined variable $x`,
+		},
+		{
+			name:  "missing-selection",
+			req:   Request{Query: `{ devices }`},
+			error: `graphql: field "devices" of object type requires a selection of subfields`,
+		},
+		{
+			name:  "scalar-selection",
+			req:   Request{Query: `{ state { name } }`},
+			error: `graphql: field "state" of scalar type has no subfields`,
+		},
+		{
+			name:  "nested-args",
+			req:   Request{Query: `{ devices { name(x: 1) } }`},
+			error: `graphql: field "name" does not take arguments`,
+		},
+		{
+			name:  "mutation",
+			req:   Request{Query: `mutation { state }`},
+			error: `graphql: could not parse document: mutations are not supported, got "mutation" at offset 0`,
+		},
+		{
+			name:  "fragment",
+			req:   Request{Query: `{ devices { ...dev } }`},
+			error: `graphql: could not parse document: fragments are not supported, got "..." at offset 12`,
+		},
+		{
+			name:  "syntax",
+			req:   Request{Query: `{ state`},
+			error: `graphql: could not parse document: expected a name, got "EOF" at offset 7`,
+		},
+		{
+			name:  "string",
+			req:   Request{Query: `{ echo(v: "abc) }`},
+			error: `graphql: could not parse document: unterminated string at offset 10`,
+		},
+		{
+			name:  "empty",
+			req:   Request{Query: ` # nothing`},
+			error: `graphql: empty document`,
+		},
+		{
+			name:  "operation-name",
+			req:   Request{Query: `query A { state } query B { state }`},
+			error: `graphql: operation name required for documents with multiple operations`,
+		},
+		{
+			name:  "unknown-operation",
+			req:   Request{Query: `query A { state }`, OperationName: "B"},
+			error: `graphql: unknown operation "B"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := schema.Do(tc.req)
+			if tc.error != "" {
+				if len(resp.Errors) == 0 {
+					t.Fatalf("expected an error")
+				}
+				if got, want := resp.Errors[0].Message, tc.error; got != want {
+					t.Fatalf("invalid error:\ngot= %s\nwant=%s", got, want)
+				}
+				return
+			}
+
+			raw, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatalf("could not marshal response: %+v", err)
+			}
+			if got, want := string(raw), tc.want; got != want {
+				t.Fatalf("invalid response:\ngot= %s\nwant=%s", got, want)
+			}
+		})
+	}
+}
+
+func TestName(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"name", "name"},
+		{"byte-rate", "byteRate"},
+		{"heap-inuse", "heapInuse"},
+		{"hdr.evt", "hdrEvt"},
+		{"last-frame", "lastFrame"},
+	} {
+		if got := Name(tc.name); got != tc.want {
+			t.Fatalf("invalid name %q: got=%q, want=%q", tc.name, got, tc.want)
+		}
+	}
+
+	if strings.Contains(Name("a--b"), "-") {
+		t.Fatalf("invalid name: %q", Name("a--b"))
+	}
+}
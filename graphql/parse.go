@@ -0,0 +1,456 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package graphql // import "github.com/go-daq/tdaq/graphql"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokName
+	tokInt
+	tokFloat
+	tokStr
+	tokPunct
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (lex *lexer) next() (token, error) {
+	// skip white spaces, commas and comments.
+	for lex.pos < len(lex.src) {
+		c := lex.src[lex.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			lex.pos++
+		case c == '#':
+			for lex.pos < len(lex.src) && lex.src[lex.pos] != '\n' {
+				lex.pos++
+			}
+		default:
+			goto tok
+		}
+	}
+	return token{kind: tokEOF, pos: lex.pos}, nil
+
+tok:
+	var (
+		beg = lex.pos
+		c   = lex.src[beg]
+	)
+	switch {
+	case isNameStart(c):
+		for lex.pos < len(lex.src) && isNameChar(lex.src[lex.pos]) {
+			lex.pos++
+		}
+		return token{kind: tokName, text: lex.src[beg:lex.pos], pos: beg}, nil
+
+	case c == '-' || isDigit(c):
+		kind := tokInt
+		lex.pos++
+		for lex.pos < len(lex.src) {
+			c := lex.src[lex.pos]
+			switch {
+			case isDigit(c):
+			case c == '.':
+				kind = tokFloat
+			case c == 'e' || c == 'E':
+				kind = tokFloat
+				if lex.pos+1 < len(lex.src) && (lex.src[lex.pos+1] == '+' || lex.src[lex.pos+1] == '-') {
+					lex.pos++
+				}
+			default:
+				return token{kind: kind, text: lex.src[beg:lex.pos], pos: beg}, nil
+			}
+			lex.pos++
+		}
+		return token{kind: kind, text: lex.src[beg:lex.pos], pos: beg}, nil
+
+	case c == '"':
+		if strings.HasPrefix(lex.src[beg:], `"""`) {
+			return token{}, fmt.Errorf("block strings are not supported (offset %d)", beg)
+		}
+		lex.pos++
+		for lex.pos < len(lex.src) && lex.src[lex.pos] != '"' && lex.src[lex.pos] != '\n' {
+			if lex.src[lex.pos] == '\\' {
+				lex.pos++
+			}
+			lex.pos++
+		}
+		if lex.pos >= len(lex.src) || lex.src[lex.pos] != '"' {
+			return token{}, fmt.Errorf("unterminated string at offset %d", beg)
+		}
+		lex.pos++
+		return token{kind: tokStr, text: lex.src[beg:lex.pos], pos: beg}, nil
+
+	case strings.HasPrefix(lex.src[beg:], "..."):
+		lex.pos += 3
+		return token{kind: tokPunct, text: "...", pos: beg}, nil
+
+	case strings.IndexByte("!$():=@[]{|}", c) >= 0:
+		lex.pos++
+		return token{kind: tokPunct, text: string(c), pos: beg}, nil
+	}
+	return token{}, fmt.Errorf("unexpected character %q at offset %d", c, beg)
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+type parser struct {
+	lex lexer
+	tok token
+}
+
+func (p *parser) next() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) is(text string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.is(text) {
+		return p.errorf("expected %q", text)
+	}
+	return p.next()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	got := p.tok.text
+	if p.tok.kind == tokEOF {
+		got = "EOF"
+	}
+	return fmt.Errorf("%s, got %q at offset %d", fmt.Sprintf(format, args...), got, p.tok.pos)
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.errorf("expected a name")
+	}
+	name := p.tok.text
+	return name, p.next()
+}
+
+// operation parses a query operation:
+//
+//	query Name($var: Type = default, ...) { selection-set }
+//	{ selection-set }
+func (p *parser) operation() (*operation, error) {
+	op := &operation{vars: make(map[string]interface{})}
+	if p.is("{") {
+		sel, err := p.selectionSet()
+		op.sel = sel
+		return op, err
+	}
+
+	if p.tok.kind != tokName {
+		return nil, p.errorf("expected an operation")
+	}
+	switch p.tok.text {
+	case "query":
+		// ok.
+	case "fragment":
+		return nil, p.errorf("fragments are not supported")
+	case "mutation", "subscription":
+		return nil, p.errorf("%ss are not supported", p.tok.text)
+	default:
+		return nil, p.errorf("expected an operation")
+	}
+	err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokName {
+		op.name = p.tok.text
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.is("(") {
+		err = p.varDefs(op)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.is("@") {
+		return nil, p.errorf("directives are not supported")
+	}
+
+	op.sel, err = p.selectionSet()
+	return op, err
+}
+
+// varDefs parses the variable definitions of an operation.
+// Types are checked for their syntax only.
+func (p *parser) varDefs(op *operation) error {
+	err := p.expect("(")
+	if err != nil {
+		return err
+	}
+	for !p.is(")") {
+		err = p.expect("$")
+		if err != nil {
+			return err
+		}
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		err = p.expect(":")
+		if err != nil {
+			return err
+		}
+		err = p.typ()
+		if err != nil {
+			return err
+		}
+		if p.is("=") {
+			err = p.next()
+			if err != nil {
+				return err
+			}
+			v, err := p.value(true)
+			if err != nil {
+				return err
+			}
+			op.vars[name] = v
+		}
+	}
+	return p.next()
+}
+
+// typ parses a type reference: Name, [Type], Type!.
+func (p *parser) typ() error {
+	var err error
+	switch {
+	case p.is("["):
+		err = p.next()
+		if err != nil {
+			return err
+		}
+		err = p.typ()
+		if err != nil {
+			return err
+		}
+		err = p.expect("]")
+	default:
+		_, err = p.name()
+	}
+	if err != nil {
+		return err
+	}
+	if p.is("!") {
+		return p.next()
+	}
+	return nil
+}
+
+func (p *parser) selectionSet() ([]*selection, error) {
+	err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+
+	var set []*selection
+	for !p.is("}") {
+		if p.is("...") {
+			return nil, p.errorf("fragments are not supported")
+		}
+		sel, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, sel)
+	}
+	if len(set) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return set, p.next()
+}
+
+// field parses a field: alias: name(args) { selection-set }
+func (p *parser) field() (*selection, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	sel := &selection{name: name}
+	if p.is(":") {
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		sel.alias = name
+		sel.name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.is("(") {
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		for !p.is(")") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect(":")
+			if err != nil {
+				return nil, err
+			}
+			v, err := p.value(false)
+			if err != nil {
+				return nil, err
+			}
+			sel.args = append(sel.args, argument{name: name, val: v})
+		}
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.is("@") {
+		return nil, p.errorf("directives are not supported")
+	}
+
+	if p.is("{") {
+		sel.sel, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// value parses an input value.
+// Constant values may not reference variables.
+func (p *parser) value(constant bool) (interface{}, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokInt:
+		v, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer")
+		}
+		return v, p.next()
+
+	case tok.kind == tokFloat:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid float")
+		}
+		return v, p.next()
+
+	case tok.kind == tokStr:
+		v, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid string")
+		}
+		return v, p.next()
+
+	case tok.kind == tokName:
+		var v interface{}
+		switch tok.text {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = tok.text // enum value.
+		}
+		return v, p.next()
+
+	case p.is("$"):
+		if constant {
+			return nil, p.errorf("unexpected variable in constant value")
+		}
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		return variable(name), nil
+
+	case p.is("["):
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for !p.is("]") {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, p.next()
+
+	case p.is("{"):
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		obj := make(map[string]interface{})
+		for !p.is("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			err = p.expect(":")
+			if err != nil {
+				return nil, err
+			}
+			obj[name], err = p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.next()
+	}
+	return nil, p.errorf("expected a value")
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+)
+
+// healthSrv serves the /healthz and /readyz probes of a TDAQ process, for
+// container orchestrators' health checks and restart policies.
+type healthSrv struct {
+	mu  sync.Mutex
+	srv *http.Server
+	lis net.Listener
+}
+
+// start starts serving the probes of the provided server on the provided
+// address, and returns the address the probes server listens on.
+func (h *healthSrv) start(addr string, srv *Server) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.srv != nil {
+		return h.lis.Addr().String(), nil
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("could not listen for health server on %q: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", srv.healthz)
+	mux.HandleFunc("/readyz", srv.readyz)
+
+	h.lis = lis
+	h.srv = &http.Server{Handler: mux}
+	go func(srv *http.Server) {
+		_ = srv.Serve(lis)
+	}(h.srv)
+
+	return lis.Addr().String(), nil
+}
+
+// stop stops serving the probes.
+func (h *healthSrv) stop() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.srv == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := h.srv.Shutdown(ctx)
+	h.srv = nil
+	h.lis = nil
+	if err != nil {
+		return fmt.Errorf("could not stop health server: %w", err)
+	}
+	return nil
+}
+
+// healthz reports whether the process is alive, i.e. not exiting.
+func (srv *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-srv.done:
+		http.Error(w, "exiting", http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintf(w, "ok\n")
+	}
+}
+
+// readyz reports whether the process is ready, i.e. it joined run-ctl and
+// its FSM is not in the error state.
+func (srv *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	srv.mu.RLock()
+	joined := srv.joined
+	state := srv.state.cur
+	srv.mu.RUnlock()
+
+	select {
+	case <-srv.done:
+		http.Error(w, "exiting", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	switch {
+	case !joined:
+		http.Error(w, "not joined", http.StatusServiceUnavailable)
+	case state == fsm.Error || state == fsm.Exiting:
+		http.Error(w, state.String(), http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintf(w, "%s\n", state)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+)
+
+func TestServerHealth(t *testing.T) {
+	srv := New(config.Process{Name: "dev"}, ioutil.Discard)
+	addr, err := srv.health.start("localhost:0", srv)
+	if err != nil {
+		t.Fatalf("could not start health server: %+v", err)
+	}
+	defer srv.health.stop()
+
+	cli := http.Client{Timeout: 5 * time.Second}
+	probe := func(path string) int {
+		t.Helper()
+		resp, err := cli.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("could not get %s: %+v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, tc := range []struct {
+		name    string
+		setup   func()
+		healthz int
+		readyz  int
+	}{
+		{
+			name:    "not-joined",
+			setup:   func() {},
+			healthz: http.StatusOK,
+			readyz:  http.StatusServiceUnavailable,
+		},
+		{
+			name: "joined",
+			setup: func() {
+				srv.mu.Lock()
+				srv.joined = true
+				srv.mu.Unlock()
+				srv.setCurState(fsm.Running)
+			},
+			healthz: http.StatusOK,
+			readyz:  http.StatusOK,
+		},
+		{
+			name:    "error",
+			setup:   func() { srv.setCurState(fsm.Error) },
+			healthz: http.StatusOK,
+			readyz:  http.StatusServiceUnavailable,
+		},
+		{
+			name:    "exiting",
+			setup:   func() { close(srv.done) },
+			healthz: http.StatusServiceUnavailable,
+			readyz:  http.StatusServiceUnavailable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.setup()
+			if got, want := probe("/healthz"), tc.healthz; got != want {
+				t.Fatalf("invalid /healthz status:\ngot = %d\nwant= %d", got, want)
+			}
+			if got, want := probe("/readyz"), tc.readyz; got != want {
+				t.Fatalf("invalid /readyz status:\ngot = %d\nwant= %d", got, want)
+			}
+		})
+	}
+}
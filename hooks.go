@@ -0,0 +1,201 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/log"
+)
+
+// hooks dispatches the events of run-ctl to the callbacks registered by
+// embedders (bookkeeping, GUIs, notifications, ...).
+//
+// Events are queued without blocking and callbacks are run sequentially, in
+// the order of the events, by a dedicated goroutine: callbacks never run
+// with the locks of run-ctl held, and may thus call back into run-ctl.
+// Slow callbacks delay the following events, but not run-ctl.
+//
+// A nil hooks drops all events.
+type hooks struct {
+	msg log.MsgStream
+
+	mu    sync.Mutex
+	join  []func(name string)
+	lost  []func(name, why string)
+	state []func(old, cur fsm.Status)
+	start []func(run uint64, t time.Time)
+	stop  []func(sum RunSummary)
+
+	queue []func()      // events waiting to be dispatched, oldest first
+	wake  chan struct{} // signaled when an event is queued
+}
+
+func newHooks(msg log.MsgStream) *hooks {
+	return &hooks{
+		msg:  msg,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// OnDeviceJoin registers a callback invoked with the name of each TDAQ
+// process joining run-ctl.
+func (rc *RunControl) OnDeviceJoin(f func(name string)) {
+	rc.hooks.mu.Lock()
+	defer rc.hooks.mu.Unlock()
+	rc.hooks.join = append(rc.hooks.join, f)
+}
+
+// OnDeviceLost registers a callback invoked with the name of each TDAQ
+// process lost by run-ctl, and the reason why: the process left run-ctl,
+// stopped replying to heartbeats or was deemed unresponsive by the
+// watchdog.
+// Processes exiting on a /quit command are not reported.
+func (rc *RunControl) OnDeviceLost(f func(name, why string)) {
+	rc.hooks.mu.Lock()
+	defer rc.hooks.mu.Unlock()
+	rc.hooks.lost = append(rc.hooks.lost, f)
+}
+
+// OnStateChange registers a callback invoked with the previous and the new
+// state of run-ctl, whenever it changes.
+func (rc *RunControl) OnStateChange(f func(old, cur fsm.Status)) {
+	rc.hooks.mu.Lock()
+	defer rc.hooks.mu.Unlock()
+	rc.hooks.state = append(rc.hooks.state, f)
+}
+
+// OnRunStart registers a callback invoked with the run number and the start
+// time of each started run.
+func (rc *RunControl) OnRunStart(f func(run uint64, start time.Time)) {
+	rc.hooks.mu.Lock()
+	defer rc.hooks.mu.Unlock()
+	rc.hooks.start = append(rc.hooks.start, f)
+}
+
+// OnRunStop registers a callback invoked with the summary of each stopped
+// run.
+func (rc *RunControl) OnRunStop(f func(sum RunSummary)) {
+	rc.hooks.mu.Lock()
+	defer rc.hooks.mu.Unlock()
+	rc.hooks.stop = append(rc.hooks.stop, f)
+}
+
+func (h *hooks) deviceJoin(name string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, f := range h.join {
+		f := f
+		h.push(func() { f(name) })
+	}
+}
+
+func (h *hooks) deviceLost(name, why string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, f := range h.lost {
+		f := f
+		h.push(func() { f(name, why) })
+	}
+}
+
+func (h *hooks) stateChange(old, cur fsm.Status) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, f := range h.state {
+		f := f
+		h.push(func() { f(old, cur) })
+	}
+}
+
+func (h *hooks) runStart(run uint64, t time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, f := range h.start {
+		f := f
+		h.push(func() { f(run, t) })
+	}
+}
+
+func (h *hooks) runStop(sum RunSummary) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, f := range h.stop {
+		f := f
+		h.push(func() { f(sum) })
+	}
+}
+
+// push queues the provided callback.
+// push must be called with h.mu held.
+func (h *hooks) push(f func()) {
+	h.queue = append(h.queue, f)
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop dispatches the queued events until ctx is done or quit is closed.
+// Events queued by then are still dispatched.
+func (h *hooks) loop(ctx context.Context, quit <-chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			h.flush()
+			return
+		case <-quit:
+			h.flush()
+			return
+		case <-h.wake:
+			h.flush()
+		}
+	}
+}
+
+// flush runs the callbacks of all the queued events.
+func (h *hooks) flush() {
+	for {
+		h.mu.Lock()
+		queue := h.queue
+		h.queue = nil
+		h.mu.Unlock()
+
+		if len(queue) == 0 {
+			return
+		}
+		for _, f := range queue {
+			h.run(f)
+		}
+	}
+}
+
+// run runs the provided callback, recovering from its panics.
+func (h *hooks) run(f func()) {
+	defer func() {
+		if e := recover(); e != nil {
+			h.msg.Errorf("panic in run-ctl hook: %v", e)
+		}
+	}()
+	f()
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestHooks(t *testing.T) {
+	var (
+		t0  = time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+		msg = log.NewMsgStream("run-ctl", log.LvlError+1, ioutil.Discard)
+		rc  = &RunControl{
+			msg:     msg,
+			status:  fsm.UnConf,
+			clients: newClientDB(),
+			feed:    newFeed(),
+			hooks:   newHooks(msg),
+		}
+		got []string
+	)
+
+	rc.OnDeviceJoin(func(name string) { got = append(got, "join:"+name) })
+	rc.OnDeviceLost(func(name, why string) { got = append(got, "lost:"+name+":"+why) })
+	rc.OnStateChange(func(old, cur fsm.Status) {
+		got = append(got, fmt.Sprintf("state:%v->%v", old, cur))
+	})
+	rc.OnStateChange(func(old, cur fsm.Status) {
+		if cur == fsm.Init {
+			panic("boom")
+		}
+	})
+	rc.OnRunStart(func(run uint64, start time.Time) {
+		got = append(got, fmt.Sprintf("start:%d@%v", run, start.Sub(t0)))
+	})
+	rc.OnRunStop(func(sum RunSummary) {
+		got = append(got, fmt.Sprintf("stop:%d", sum.Run))
+	})
+
+	cli := &client{name: "dump", quit: make(chan int), hooks: rc.hooks}
+	rc.hooks.deviceJoin("dump")
+	rc.setStatus(fsm.Conf)
+	rc.setStatus(fsm.Conf)
+	rc.setStatus(fsm.Init)
+	rc.hooks.runStart(42, t0.Add(time.Second))
+	cli.setLost("no heartbeat")
+	cli.setLost("no heartbeat, again")
+	rc.hooks.runStop(RunSummary{Run: 42})
+	close(cli.quit)
+	cli.setLost("exiting")
+
+	quit := make(chan struct{})
+	close(quit)
+	rc.hooks.loop(context.Background(), quit)
+
+	want := []string{
+		"join:dump",
+		"state:unconfigured->configured",
+		"state:configured->initialized",
+		"start:42@1s",
+		"lost:dump:no heartbeat",
+		"stop:42",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid hook events:\ngot = %q\nwant= %q", got, want)
+	}
+
+	var nilh *hooks
+	nilh.deviceJoin("dump")
+}
@@ -0,0 +1,373 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mdns implements a minimal multicast DNS (RFC 6762) responder and
+// resolver for DNS-based service discovery (RFC 6763) on the local network.
+package mdns // import "github.com/go-daq/tdaq/internal/mdns"
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	ttl      = 120         // time-to-live of the advertised records, in seconds
+	interval = time.Second // interval between repeated queries
+	maxSize  = 9000        // maximum size of mDNS messages
+)
+
+// Entry describes an instance of a service advertised on the local network.
+type Entry struct {
+	Instance string   // name of the service instance (e.g. "run-ctl")
+	Service  string   // type of the service (e.g. "_tdaq-runctl._tcp")
+	Host     string   // host name of the service instance, without the ".local" domain
+	Port     int      // port of the service instance
+	Addrs    []net.IP // IPv4 addresses of the host
+	Text     []string // "key=value" attributes of the service instance
+}
+
+// Addr returns the host:port address of the service instance.
+func (e Entry) Addr() string {
+	host := e.Host
+	if len(e.Addrs) > 0 {
+		host = e.Addrs[0].String()
+	}
+	return net.JoinHostPort(host, fmt.Sprint(e.Port))
+}
+
+func serviceName(service string) string {
+	return service + ".local."
+}
+
+func (e Entry) service() string  { return serviceName(e.Service) }
+func (e Entry) instance() string { return e.Instance + "." + e.service() }
+func (e Entry) host() string     { return e.Host + ".local." }
+
+// Serve advertises the provided entry on the local network, answering the
+// mDNS queries about it until the context is done.
+func Serve(ctx context.Context, e Entry) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("could not listen to mDNS group: %w", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// announce the service instance.
+	msg, err := e.response(dnsmessage.Header{Response: true, Authoritative: true}, nil)
+	if err != nil {
+		return fmt.Errorf("could not build mDNS announcement: %w", err)
+	}
+	_, _ = conn.WriteToUDP(msg, group)
+
+	buf := make([]byte, maxSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("could not read mDNS query: %w", err)
+		}
+
+		// queries sent from a port other than the mDNS one are one-shot
+		// (legacy unicast) queries, answered directly to their sender.
+		legacy := src.Port != group.Port
+		msg, ok := e.answer(buf[:n], legacy)
+		if !ok {
+			continue
+		}
+
+		dst := group
+		if legacy {
+			dst = src
+		}
+		_, _ = conn.WriteToUDP(msg, dst)
+	}
+}
+
+// answer returns the response to the provided query, if the query is about
+// the entry.
+func (e Entry) answer(query []byte, legacy bool) ([]byte, bool) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil || hdr.Response {
+		return nil, false
+	}
+
+	qs, err := p.AllQuestions()
+	if err != nil {
+		return nil, false
+	}
+
+	match := false
+	for _, q := range qs {
+		name := q.Name.String()
+		switch {
+		case strings.EqualFold(name, e.service()) && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL),
+			strings.EqualFold(name, e.instance()) && (q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeTXT || q.Type == dnsmessage.TypeALL),
+			strings.EqualFold(name, e.host()) && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL):
+			match = true
+		}
+	}
+	if !match {
+		return nil, false
+	}
+
+	resp := dnsmessage.Header{Response: true, Authoritative: true}
+	if !legacy {
+		qs = nil
+	} else {
+		resp.ID = hdr.ID
+	}
+	msg, err := e.response(resp, qs)
+	if err != nil {
+		return nil, false
+	}
+	return msg, true
+}
+
+// response builds a response message with all the records of the entry.
+func (e Entry) response(hdr dnsmessage.Header, qs []dnsmessage.Question) ([]byte, error) {
+	svc, err := dnsmessage.NewName(e.service())
+	if err != nil {
+		return nil, err
+	}
+	inst, err := dnsmessage.NewName(e.instance())
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(e.host())
+	if err != nil {
+		return nil, err
+	}
+	rhdr := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: ttl}
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), hdr)
+	b.EnableCompression()
+
+	err = b.StartQuestions()
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range qs {
+		err = b.Question(q)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = b.StartAnswers()
+	if err != nil {
+		return nil, err
+	}
+	err = b.PTRResource(rhdr(svc), dnsmessage.PTRResource{PTR: inst})
+	if err != nil {
+		return nil, err
+	}
+	err = b.SRVResource(rhdr(inst), dnsmessage.SRVResource{Target: host, Port: uint16(e.Port)})
+	if err != nil {
+		return nil, err
+	}
+	txt := e.Text
+	if len(txt) == 0 {
+		txt = []string{""}
+	}
+	err = b.TXTResource(rhdr(inst), dnsmessage.TXTResource{TXT: txt})
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range e.Addrs {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			continue
+		}
+		var a dnsmessage.AResource
+		copy(a.A[:], ip4)
+		err = b.AResource(rhdr(host), a)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.Finish()
+}
+
+// Lookup queries the local network for the instances of the provided
+// service, until at least one instance is found or the context is done.
+func Lookup(ctx context.Context, service string) ([]Entry, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("could not create mDNS socket: %w", err)
+	}
+	defer conn.Close()
+
+	query, err := newQuery(service)
+	if err != nil {
+		return nil, fmt.Errorf("could not build mDNS query: %w", err)
+	}
+
+	var (
+		recs = newRecords(service)
+		buf  = make([]byte, maxSize)
+		next time.Time
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("could not find any %q instance: %w", service, err)
+		}
+
+		now := time.Now()
+		if !now.Before(next) {
+			_, err = conn.WriteToUDP(query, group)
+			if err != nil {
+				return nil, fmt.Errorf("could not send mDNS query: %w", err)
+			}
+			next = now.Add(interval)
+		}
+
+		_ = conn.SetReadDeadline(next)
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return nil, fmt.Errorf("could not read mDNS response: %w", err)
+		}
+
+		recs.add(buf[:n], src.IP)
+		if entries := recs.entries(); len(entries) > 0 {
+			return entries, nil
+		}
+	}
+}
+
+func newQuery(service string) ([]byte, error) {
+	name, err := dnsmessage.NewName(serviceName(service))
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{})
+	err = b.StartQuestions()
+	if err != nil {
+		return nil, err
+	}
+	err = b.Question(dnsmessage.Question{
+		Name:  name,
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// records collects the records of the responses to a service query.
+type records struct {
+	service string
+	insts   []string                          // names of the service instances, in discovery order
+	srvs    map[string]dnsmessage.SRVResource // SRV records, by lower-cased instance name
+	txts    map[string][]string               // TXT records, by lower-cased instance name
+	addrs   map[string][]net.IP               // A records, by lower-cased host name
+	srcs    map[string]net.IP                 // addresses of the responders, by lower-cased instance name
+}
+
+func newRecords(service string) *records {
+	return &records{
+		service: serviceName(service),
+		srvs:    make(map[string]dnsmessage.SRVResource),
+		txts:    make(map[string][]string),
+		addrs:   make(map[string][]net.IP),
+		srcs:    make(map[string]net.IP),
+	}
+}
+
+func (recs *records) add(msg []byte, src net.IP) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil || !hdr.Response {
+		return
+	}
+	err = p.SkipAllQuestions()
+	if err != nil {
+		return
+	}
+
+	var rs []dnsmessage.Resource
+	for _, f := range []func() ([]dnsmessage.Resource, error){p.AllAnswers, p.AllAuthorities, p.AllAdditionals} {
+		v, err := f()
+		if err != nil {
+			break
+		}
+		rs = append(rs, v...)
+	}
+
+	for _, r := range rs {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name != strings.ToLower(recs.service) {
+				continue
+			}
+			inst := body.PTR.String()
+			key := strings.ToLower(inst)
+			if _, dup := recs.srcs[key]; !dup {
+				recs.insts = append(recs.insts, inst)
+			}
+			recs.srcs[key] = src
+		case *dnsmessage.SRVResource:
+			recs.srvs[name] = *body
+		case *dnsmessage.TXTResource:
+			recs.txts[name] = body.TXT
+		case *dnsmessage.AResource:
+			ip := net.IP(append([]byte(nil), body.A[:]...))
+			recs.addrs[name] = append(recs.addrs[name], ip)
+		}
+	}
+}
+
+// entries returns the service instances whose location is known.
+func (recs *records) entries() []Entry {
+	var entries []Entry
+	for _, inst := range recs.insts {
+		key := strings.ToLower(inst)
+		srv, ok := recs.srvs[key]
+		if !ok {
+			continue
+		}
+		host := srv.Target.String()
+		e := Entry{
+			Instance: inst[:len(inst)-len(recs.service)-1],
+			Service:  strings.TrimSuffix(recs.service, ".local."),
+			Host:     host[:len(host)-len(".local.")],
+			Port:     int(srv.Port),
+			Addrs:    recs.addrs[strings.ToLower(host)],
+		}
+		if len(e.Addrs) == 0 {
+			e.Addrs = []net.IP{recs.srcs[key]}
+		}
+		for _, txt := range recs.txts[key] {
+			if txt != "" {
+				e.Text = append(e.Text, txt)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
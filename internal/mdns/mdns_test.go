@@ -0,0 +1,71 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestAnswer(t *testing.T) {
+	const service = "_tdaq-runctl._tcp"
+
+	entry := Entry{
+		Instance: "Run-Ctl",
+		Service:  service,
+		Host:     "daq-01",
+		Port:     44000,
+		Addrs:    []net.IP{net.IPv4(192, 168, 1, 10).To4()},
+		Text:     []string{"trans=tcp"},
+	}
+
+	query, err := newQuery(service)
+	if err != nil {
+		t.Fatalf("could not build query: %+v", err)
+	}
+
+	for _, legacy := range []bool{false, true} {
+		resp, ok := entry.answer(query, legacy)
+		if !ok {
+			t.Fatalf("query not answered (legacy=%v)", legacy)
+		}
+
+		recs := newRecords(service)
+		recs.add(resp, net.IPv4(10, 0, 0, 1))
+		got := recs.entries()
+		want := []Entry{entry}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid entries (legacy=%v):\ngot = %#v\nwant= %#v", legacy, got, want)
+		}
+		if got, want := got[0].Addr(), "192.168.1.10:44000"; got != want {
+			t.Fatalf("invalid address: got=%q, want=%q", got, want)
+		}
+	}
+
+	// responses are not answered.
+	resp, _ := entry.answer(query, false)
+	if _, ok := entry.answer(resp, false); ok {
+		t.Fatalf("response answered")
+	}
+
+	// queries for other services are not answered.
+	other, err := newQuery("_http._tcp")
+	if err != nil {
+		t.Fatalf("could not build query: %+v", err)
+	}
+	if _, ok := entry.answer(other, false); ok {
+		t.Fatalf("query for another service answered")
+	}
+
+	// responders without A records are located by their source address.
+	entry.Addrs = nil
+	resp, _ = entry.answer(query, false)
+	recs := newRecords(service)
+	recs.add(resp, net.IPv4(10, 0, 0, 1))
+	if got, want := recs.entries()[0].Addr(), "10.0.0.1:44000"; got != want {
+		t.Fatalf("invalid address: got=%q, want=%q", got, want)
+	}
+}
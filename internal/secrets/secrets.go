@@ -0,0 +1,187 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package secrets implements an encrypted store of named secrets.
+//
+// Stores are JSON documents sealed with AES-256-GCM, under a 32-byte key
+// provided as 64 hexadecimal digits.
+package secrets // import "github.com/go-daq/tdaq/internal/secrets"
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KeyEnv is the environment variable holding the key of secrets stores.
+const KeyEnv = "TDAQ_SECRETS_KEY"
+
+const keySize = 32
+
+// NewKey returns a new random key, as hexadecimal digits.
+func NewKey() (string, error) {
+	key := make([]byte, keySize)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return "", fmt.Errorf("could not generate key: %w", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// ParseKey decodes a key from its hexadecimal digits.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode key: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key size (got=%d, want=%d)", len(key), keySize)
+	}
+	return key, nil
+}
+
+// KeyFromEnv returns the key held by the KeyEnv environment variable.
+func KeyFromEnv() ([]byte, error) {
+	v, ok := os.LookupEnv(KeyEnv)
+	if !ok {
+		return nil, fmt.Errorf("missing $%s key of secrets store", KeyEnv)
+	}
+	return ParseKey(v)
+}
+
+// Store is a set of named secrets.
+type Store struct {
+	values map[string]string
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{values: make(map[string]string)}
+}
+
+// Open opens the store sealed in the named file.
+// A missing file is an empty store.
+func Open(fname string, key []byte) (*Store, error) {
+	raw, err := ioutil.ReadFile(fname)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return New(), nil
+	default:
+		return nil, fmt.Errorf("could not read secrets store %q: %w", fname, err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid secrets store %q", fname)
+	}
+
+	nonce, data := raw[:aead.NonceSize()], raw[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt secrets store %q: %w", fname, err)
+	}
+
+	s := New()
+	err = json.Unmarshal(plain, &s.values)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode secrets store %q: %w", fname, err)
+	}
+	return s, nil
+}
+
+// Save seals the store in the named file, readable only by its owner.
+func (s *Store) Save(fname string, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	plain, err := json.Marshal(s.values)
+	if err != nil {
+		return fmt.Errorf("could not encode secrets store: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return fmt.Errorf("could not generate nonce: %w", err)
+	}
+	raw := aead.Seal(nonce, nonce, plain, nil)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fname), ".tdaq-secrets-")
+	if err != nil {
+		return fmt.Errorf("could not create secrets store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(raw)
+	if err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("could not write secrets store: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("could not close secrets store: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), fname)
+	if err != nil {
+		return fmt.Errorf("could not save secrets store: %w", err)
+	}
+	return nil
+}
+
+// Get returns the named secret.
+func (s *Store) Get(name string) (string, bool) {
+	v, ok := s.values[name]
+	return v, ok
+}
+
+// Set sets the named secret.
+func (s *Store) Set(name, value string) {
+	s.values[name] = value
+}
+
+// Delete deletes the named secret.
+func (s *Store) Delete(name string) {
+	delete(s.values, name)
+}
+
+// Names returns the sorted names of the secrets.
+func (s *Store) Names() []string {
+	names := make([]string, 0, len(s.values))
+	for name := range s.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key size (got=%d, want=%d)", len(key), keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create AEAD: %w", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secrets // import "github.com/go-daq/tdaq/internal/secrets"
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "tdaq-secrets-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	hkey, err := NewKey()
+	if err != nil {
+		t.Fatalf("could not generate key: %+v", err)
+	}
+	key, err := ParseKey(hkey)
+	if err != nil {
+		t.Fatalf("could not parse key: %+v", err)
+	}
+
+	fname := filepath.Join(tmp, "secrets.db")
+	store, err := Open(fname, key)
+	if err != nil {
+		t.Fatalf("could not open missing store: %+v", err)
+	}
+	if got := store.Names(); len(got) != 0 {
+		t.Fatalf("invalid empty store: %q", got)
+	}
+
+	store.Set("db-password", "s3cr3t")
+	store.Set("s3-key", "AKIA")
+	store.Set("pin", "1234")
+	store.Delete("pin")
+
+	err = store.Save(fname, key)
+	if err != nil {
+		t.Fatalf("could not save store: %+v", err)
+	}
+
+	raw, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatalf("could not read store: %+v", err)
+	}
+	if bytes.Contains(raw, []byte("s3cr3t")) || bytes.Contains(raw, []byte("db-password")) {
+		t.Fatalf("store is not encrypted")
+	}
+
+	fi, err := os.Stat(fname)
+	if err != nil {
+		t.Fatalf("could not stat store: %+v", err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0600); got != want {
+		t.Fatalf("invalid store permissions: got=%v, want=%v", got, want)
+	}
+
+	store, err = Open(fname, key)
+	if err != nil {
+		t.Fatalf("could not reopen store: %+v", err)
+	}
+	if got, want := store.Names(), []string{"db-password", "s3-key"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid names:\ngot = %q\nwant= %q", got, want)
+	}
+	if got, ok := store.Get("db-password"); !ok || got != "s3cr3t" {
+		t.Fatalf("invalid secret: got=%q (ok=%v)", got, ok)
+	}
+
+	bad := make([]byte, len(key))
+	_, err = Open(fname, bad)
+	if err == nil {
+		t.Fatalf("expected an error opening store with invalid key")
+	}
+
+	for _, v := range []string{"", "0123", "zz"} {
+		_, err = ParseKey(v)
+		if err == nil {
+			t.Fatalf("expected an error parsing key %q", v)
+		}
+	}
+}
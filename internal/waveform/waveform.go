@@ -0,0 +1,61 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:generate tdaq-gen
+
+// Package waveform holds the payload type exchanged by the tdaq-wave-gen and
+// tdaq-wave-mon example commands.
+//
+// It shows how to declare a typed payload: a struct with MarshalTDAQ and
+// UnmarshalTDAQ methods generated by tdaq-gen, and the schema of its
+// encoding, registered under the type name used by the end-points of the
+// topology, so generic devices (tdaq-dump, filters, histogrammers...) can
+// decode it.
+package waveform // import "github.com/go-daq/tdaq/internal/waveform"
+
+import (
+	"time"
+
+	"github.com/go-daq/tdaq/payload"
+)
+
+// Type is the name of the type of waveform data frames, as declared by
+// the end-points of the topology.
+const Type = "waveform"
+
+// Schema is the schema of the encoding of waveforms.
+var Schema = payload.Schema{
+	{Name: "time", Type: "time"},
+	{Name: "channel", Type: "u16"},
+	{Name: "samples", Type: "[]i16"},
+}
+
+func init() {
+	payload.Register(Type, Schema)
+}
+
+// Waveform is a digitized waveform of a readout channel.
+//
+// tdaq:gen
+type Waveform struct {
+	Time    time.Time // trigger time of the waveform
+	Channel uint16    // readout channel
+	Samples []int16   // ADC samples
+}
+
+// Peak returns the index and the value of the maximum sample of the
+// waveform.
+func (wf Waveform) Peak() (int, int16) {
+	var (
+		idx = -1
+		max int16
+	)
+	for i, v := range wf.Samples {
+		if idx < 0 || v > max {
+			idx = i
+			max = v
+		}
+	}
+	return idx, max
+}
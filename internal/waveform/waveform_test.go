@@ -0,0 +1,56 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package waveform
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/payload"
+)
+
+func TestSchema(t *testing.T) {
+	sch, ok := payload.Lookup(Type)
+	if !ok {
+		t.Fatalf("no schema registered for %q", Type)
+	}
+
+	wf := Waveform{
+		Time:    time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC),
+		Channel: 3,
+		Samples: []int16{-1, 2, 40, 7},
+	}
+	raw, err := wf.MarshalTDAQ()
+	if err != nil {
+		t.Fatalf("could not marshal waveform: %+v", err)
+	}
+
+	got, err := sch.JSON(raw)
+	if err != nil {
+		t.Fatalf("could not convert waveform to JSON: %+v", err)
+	}
+	want := `{"time":"2020-04-01T12:00:00Z","channel":3,"samples":[-1,2,40,7]}`
+	if string(got) != want {
+		t.Fatalf("invalid JSON:\ngot = %s\nwant= %s", got, want)
+	}
+
+	back, err := sch.FromJSON(got)
+	if err != nil {
+		t.Fatalf("could not convert waveform from JSON: %+v", err)
+	}
+	var wf2 Waveform
+	err = wf2.UnmarshalTDAQ(back)
+	if err != nil {
+		t.Fatalf("could not unmarshal waveform: %+v", err)
+	}
+	if !reflect.DeepEqual(wf2, wf) {
+		t.Fatalf("invalid round-trip:\ngot = %+v\nwant= %+v", wf2, wf)
+	}
+
+	if i, v := wf.Peak(); i != 2 || v != 40 {
+		t.Fatalf("invalid peak: got=(%d, %d), want=(2, 40)", i, v)
+	}
+}
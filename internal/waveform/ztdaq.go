@@ -0,0 +1,39 @@
+// Code generated by tdaq-gen; DO NOT EDIT.
+
+package waveform
+
+import (
+	"bytes"
+
+	"github.com/go-daq/tdaq"
+)
+
+func (v Waveform) MarshalTDAQ() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := tdaq.NewEncoder(buf)
+	enc.WriteTime(v.Time)
+	enc.WriteU16(v.Channel)
+	enc.WriteI32(int32(len(v.Samples)))
+	for _, v1 := range v.Samples {
+		enc.WriteI16(v1)
+	}
+	return buf.Bytes(), enc.Err()
+}
+
+func (v *Waveform) UnmarshalTDAQ(p []byte) error {
+	dec := tdaq.NewDecoder(bytes.NewReader(p))
+	v.Time = dec.ReadTime()
+	v.Channel = dec.ReadU16()
+	if n1 := int(dec.ReadI32()); n1 > 0 && dec.Err() == nil {
+		v.Samples = make([]int16, n1)
+		for i1 := range v.Samples {
+			v.Samples[i1] = dec.ReadI16()
+		}
+	}
+	return dec.Err()
+}
+
+var (
+	_ tdaq.Marshaler   = (*Waveform)(nil)
+	_ tdaq.Unmarshaler = (*Waveform)(nil)
+)
@@ -11,8 +11,11 @@ import (
 	"net"
 	"sort"
 	"sync"
+	"time"
 
+	"github.com/go-daq/tdaq/config"
 	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/log"
 	"go.nanomsg.org/mangos/v3"
 	"go.nanomsg.org/mangos/v3/protocol/pub"
 	"go.nanomsg.org/mangos/v3/protocol/xsub"
@@ -27,19 +30,29 @@ type OutputHandler func(ctx Context, dst *Frame) error
 type imgr struct {
 	srv *Server
 	mu  sync.RWMutex
-	ps  map[string]mangos.Socket
+	ps  map[string]mangos.Socket // data connections, indexed by address
+	eps map[string][]string      // names of input end-points, indexed by address
+	lks map[string]*link         // status of data links, indexed by address
+	rds map[string]*reader       // readers of data connections, indexed by address
+	aks map[string]*acker        // acknowledgement of data frames, indexed by input end-point
+	crs map[string]*crediter     // credit-based flow control, indexed by input end-point
 	ep  map[string]InputHandler
+	wks map[string]inputWorkers // workers of the input end-points, indexed by end-point
+	cdc map[string]*codec       // negotiated payload codecs of the input end-points, indexed by end-point
 	cfg ConfigCmd
-
-	grp  *errgroup.Group
-	done chan error
 }
 
 func newIMgr(srv *Server) *imgr {
 	return &imgr{
 		srv: srv,
 		ps:  make(map[string]mangos.Socket),
+		eps: make(map[string][]string),
+		lks: make(map[string]*link),
+		rds: make(map[string]*reader),
+		aks: make(map[string]*acker),
+		crs: make(map[string]*crediter),
 		ep:  make(map[string]InputHandler),
+		wks: make(map[string]inputWorkers),
 	}
 }
 
@@ -47,8 +60,24 @@ func (mgr *imgr) close() {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
-	for _, conn := range mgr.ps {
+	for k, conn := range mgr.ps {
+		if l, ok := mgr.lks[k]; ok {
+			l.close()
+		}
+		r := mgr.rds[k]
+		if r != nil {
+			r.close()
+		}
 		_ = conn.Close()
+		if r != nil {
+			<-r.quit
+		}
+	}
+	for _, a := range mgr.aks {
+		_ = a.close()
+	}
+	for _, c := range mgr.crs {
+		_ = c.close()
 	}
 }
 
@@ -64,6 +93,25 @@ func (mgr *imgr) Handle(name string, h InputHandler) {
 	mgr.ep[name] = h
 }
 
+// HandleN registers the handler of the named input end-point, run by n
+// workers (n<=0: chosen from the ordering of the end-point).
+func (mgr *imgr) HandleN(name string, n int, h InputHandler, opts ...InputOption) {
+	w := inputWorkers{n: n}
+	for _, opt := range opts {
+		opt(&w)
+	}
+	err := w.resolve(name)
+	if err != nil {
+		panic(err)
+	}
+
+	mgr.Handle(name, h)
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.wks[name] = w
+}
+
 func (mgr *imgr) endpoints() []EndPoint {
 	mgr.mu.RLock()
 	defer mgr.mu.RUnlock()
@@ -88,32 +136,143 @@ func (mgr *imgr) onConfig(ctx Context, src Frame) error {
 	}
 	mgr.cfg = cmd
 
+	// input end-points provided by the same (multiplexed) output port
+	// share a single data connection.
+	var (
+		eps   = make(map[string][]string)
+		names = make([]string, 0, len(cmd.InEndPoints))
+	)
 	for _, ep := range cmd.InEndPoints {
-		err = mgr.dial(ep)
+		eps[ep.Addr] = append(eps[ep.Addr], ep.Name)
+		names = append(names, ep.Name)
+	}
+
+	mgr.cdc, err = newCodecs(mgr.srv, cmd.Encodings, names, negotiateMaxFrameSize(
+		mgr.srv.maxFrame, int(cmd.MaxFrameSize),
+	))
+	if err != nil {
+		return err
+	}
+
+	for addr, names := range eps {
+		sort.Strings(names)
+		err = mgr.dial(addr, names)
 		if err != nil {
 			return err
 		}
 	}
 
+	for ep, addr := range cmd.Acks {
+		sck, err := mgr.dialFeedback(ep, addr)
+		if err != nil {
+			return fmt.Errorf("could not setup ack socket: %w", err)
+		}
+		batch := mgr.srv.cfg.Acked.Batch
+		if batch <= 0 {
+			batch = DefaultAckBatch
+		}
+		mgr.aks[ep] = &acker{name: mgr.srv.name, ep: ep, sck: sck, batch: batch, cnt: mgr.srv.stats.input(ep)}
+	}
+
+	for ep, addr := range cmd.Credits {
+		sck, err := mgr.dialFeedback(ep, addr)
+		if err != nil {
+			return fmt.Errorf("could not setup credit socket: %w", err)
+		}
+		mgr.crs[ep] = &crediter{name: mgr.srv.name, ep: ep, sck: sck, bytes: mgr.srv.cfg.Credit.Bytes}
+	}
+
 	return nil
 }
 
-func (mgr *imgr) dial(ep EndPoint) error {
+// dialFeedback connects to the feedback socket at addr of the producer of
+// the named input end-point.
+func (mgr *imgr) dialFeedback(ep, addr string) (mangos.Socket, error) {
+	sck, err := pub.NewSocket()
+	if err != nil {
+		return nil, fmt.Errorf("could not create feedback socket for ep=%q: %w", ep, err)
+	}
+	err = setReconnect(sck, mgr.srv.cfg.ReconnectTime, mgr.srv.cfg.MaxReconnectTime)
+	if err != nil {
+		return nil, fmt.Errorf("could not set reconnect options of feedback socket for ep=%q: %w", ep, err)
+	}
+	err = sck.Dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not dial feedback socket %q (ep=%q): %w", addr, ep, err)
+	}
+	return sck, nil
+}
+
+// dial connects to the output port at addr, serving the named input end-points.
+// The socket options of the connection are the ones of the first end-point.
+func (mgr *imgr) dial(addr string, eps []string) error {
+	ep := eps[0]
 	sck, err := xsub.NewSocket()
 	if err != nil {
 		return fmt.Errorf("could not create XSUB socket for ep=%q: %w",
-			ep.Name, err,
+			ep, err,
 		)
 	}
-	err = sck.Dial(ep.Addr)
+	err = setMaxFrameSize(sck, mgr.srv.maxFrame)
+	if err != nil {
+		return fmt.Errorf("could not set maximum frame size for ep=%q: %w", ep, err)
+	}
+	opts := mgr.srv.cfg.Sockets[ep]
+	err = setSockOptions(sck, opts)
+	if err != nil {
+		return fmt.Errorf("could not set socket options for ep=%q: %w", ep, err)
+	}
+	err = setReconnect(sck, mgr.srv.cfg.ReconnectTime, mgr.srv.cfg.MaxReconnectTime)
+	if err != nil {
+		return fmt.Errorf("could not set reconnect options for ep=%q: %w", ep, err)
+	}
+
+	lnk := newLink(mgr.srv.msg, addr, eps, mgr.srv.cfg.StallTimeout, mgr.srv.now)
+	sck.SetPipeEventHook(lnk.hook)
+
+	err = sck.DialOptions(addr, transportOptions(addr, opts))
 	if err != nil {
-		return fmt.Errorf("could not dial %q end-point (ep=%q): %w", ep.Addr, ep.Name, err)
+		return fmt.Errorf("could not dial %q end-point (ep=%q): %w", addr, ep, err)
 	}
-	mgr.ps[ep.Name] = sck
+	busy := false
+	for _, ep := range eps {
+		busy = busy || mgr.wks[ep].busy
+	}
+	r := newReader(addr, sck, eps, maxFrameSize(sck), busy)
+	go r.run(mgr.srv)
+
+	mgr.ps[addr] = sck
+	mgr.eps[addr] = eps
+	mgr.lks[addr] = lnk
+	mgr.rds[addr] = r
 
 	return nil
 }
 
+// links returns the status of the data links, sorted by address.
+func (mgr *imgr) links() []LinkStatus {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	if len(mgr.lks) == 0 {
+		return nil
+	}
+
+	links := make([]LinkStatus, 0, len(mgr.lks))
+	for _, l := range mgr.lks {
+		links = append(links, l.status())
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Addr < links[j].Addr
+	})
+	return links
+}
+
+// qlen returns the length of the queue of data frames of the named input end-point.
+func (mgr *imgr) qlen(ep string) int {
+	return mgr.srv.cfg.Sockets[ep].ReadQLen
+}
+
 func (mgr *imgr) onReset(ctx Context) error {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
@@ -121,11 +280,24 @@ func (mgr *imgr) onReset(ctx Context) error {
 	var err error
 
 	for k, conn := range mgr.ps {
-		mgr.ps[k] = nil
+		if l, ok := mgr.lks[k]; ok {
+			l.close()
+		}
+		r := mgr.rds[k]
+		if r != nil {
+			r.close()
+		}
+		delete(mgr.ps, k)
+		delete(mgr.eps, k)
+		delete(mgr.lks, k)
+		delete(mgr.rds, k)
 		if conn == nil {
 			continue
 		}
 		e := conn.Close()
+		if r != nil {
+			<-r.quit
+		}
 		if e != nil {
 			err = e
 			ctx.Msg.Errorf("could not close incoming end-point %q: %+v", k, err)
@@ -133,84 +305,85 @@ func (mgr *imgr) onReset(ctx Context) error {
 		}
 	}
 
+	for k, a := range mgr.aks {
+		delete(mgr.aks, k)
+		e := a.close()
+		if e != nil {
+			err = e
+			ctx.Msg.Errorf("could not close ack socket of incoming end-point %q: %+v", k, err)
+		}
+	}
+
+	for k, c := range mgr.crs {
+		delete(mgr.crs, k)
+		e := c.close()
+		if e != nil {
+			err = e
+			ctx.Msg.Errorf("could not close credit socket of incoming end-point %q: %+v", k, err)
+		}
+	}
+
 	return err
 }
 
+// onStart attaches the streams of the new run to the readers of the data
+// connections, dialed at /config.
 func (mgr *imgr) onStart(ctx Context) error {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
-	mgr.done = make(chan error)
+	for _, w := range mgr.wks {
+		w.dups.reset()
+	}
 
-	if len(mgr.ps) == 0 {
-		close(mgr.done)
+	if len(mgr.rds) == 0 {
 		return nil
 	}
 
-	mgr.grp = new(errgroup.Group)
-	for k := range mgr.ps {
-		ept := k
-		src := mgr.ps[k]
-		fct := mgr.ep[k]
-		mgr.grp.Go(func() error {
-			return mgr.run(ctx, ept, src, fct)
-		})
+	hs := mgr.ep
+	if len(mgr.cdc) > 0 {
+		hs = make(map[string]InputHandler, len(mgr.ep))
+		for ep, h := range mgr.ep {
+			if c, ok := mgr.cdc[ep]; ok {
+				h = c.input(h)
+			}
+			hs[ep] = h
+		}
 	}
 
-	go func() {
-		err := mgr.grp.Wait()
-		if err != nil {
-			ctx.Msg.Errorf("error during run: %+v", err)
-		}
-		mgr.done <- err
-	}()
+	for addr, r := range mgr.rds {
+		lnk := mgr.lks[addr]
+		lnk.setRunning(true)
+		mux := newDemux(ctx, mgr.eps[addr], hs, mgr.wks, mgr.qlen, mgr.srv.cfg.MaxFrameSize, lnk, mgr.aks, mgr.crs, mgr.srv.stats)
+		r.attach(ctx, mux)
+	}
 
 	return nil
 }
 
+// onStop waits for the streams of the run to receive their end-of-stream
+// frames and process their queued data frames.
+// The data connections are kept open for the next run.
 func (mgr *imgr) onStop(ctx Context) error {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
-	select {
-	case <-mgr.done:
-		return nil
-
-	case <-ctx.Ctx.Done():
-		return fmt.Errorf("on-stop failed: %w", ctx.Ctx.Err())
+	for _, l := range mgr.lks {
+		l.setRunning(false)
 	}
-}
 
-func (mgr *imgr) run(ctx Context, ep string, sck mangos.Socket, f InputHandler) error {
-	for {
+	for _, r := range mgr.rds {
 		select {
+		case <-r.stopped():
 		case <-ctx.Ctx.Done():
-			return nil
-		default:
-			frame, err := RecvFrame(ctx.Ctx, sck)
-			switch {
-			default:
-				switch state := mgr.srv.getNextState(); state {
-				case fsm.Stopped:
-					// ok.
-				default:
-					ctx.Msg.Errorf("could not retrieve data frame for %q (state=%v): %+v", ep, state, err)
-				}
-				return nil
-			case err == nil:
-				if frame.Type == FrameEOF {
-					// no more data
-					return nil
-				}
-
-				err = f(ctx, frame)
-				if err != nil {
-					ctx.Msg.Errorf("could not process data frame for %q: %+v", ep, err)
-					continue
-				}
+			for _, r := range mgr.rds {
+				r.detach()
 			}
+			return fmt.Errorf("on-stop failed: %w", ctx.Ctx.Err())
 		}
 	}
+
+	return nil
 }
 
 type omgr struct {
@@ -218,6 +391,20 @@ type omgr struct {
 	mu  sync.RWMutex
 	ps  map[string]*oport
 	ep  map[string]OutputHandler
+	max int    // maximum size of data frames
+	mux *oport // output port shared by all end-points, in multiplexed mode
+	dlq *dlq   // handling of undeliverable data frames (may be nil)
+	dlp *oport // output port receiving undeliverable data frames (may be nil)
+
+	spools map[string]*spool  // spools of the output end-points, when spooling is enabled
+	acks   map[string]*acklog // logs of unacknowledged data frames of the output end-points in acknowledged mode
+	ackps  map[string]*rport  // acknowledgement sockets of the output end-points in acknowledged mode
+	crds   map[string]*credit // credit granted to the output end-points under flow control
+	crdps  map[string]*rport  // credit sockets of the output end-points under flow control
+	encs   map[string]string  // payload encodings declared by the output end-points
+	cdcs   map[string]*codec  // negotiated payload codecs of the output end-points
+
+	hold holder // holds the output loops while the run is paused
 
 	grp  *errgroup.Group
 	done chan error
@@ -236,8 +423,38 @@ func (mgr *omgr) close() {
 	defer mgr.mu.Unlock()
 
 	for _, op := range mgr.ps {
+		if op.shared {
+			continue
+		}
 		op.close()
 	}
+	if mgr.mux != nil {
+		mgr.mux.close()
+	}
+	if mgr.dlp != nil {
+		mgr.dlp.close()
+	}
+	if mgr.dlq != nil {
+		_ = mgr.dlq.close()
+	}
+	for ep, sp := range mgr.spools {
+		err := sp.close()
+		if err != nil {
+			mgr.srv.msg.Errorf("could not close spool file of %q: %+v", ep, err)
+		}
+	}
+	for _, p := range mgr.ackps {
+		_ = p.close()
+	}
+	for _, p := range mgr.crdps {
+		_ = p.close()
+	}
+	for ep, l := range mgr.acks {
+		err := l.close()
+		if err != nil {
+			mgr.srv.msg.Errorf("could not close log of unacknowledged frames of %q: %+v", ep, err)
+		}
+	}
 }
 
 func (mgr *omgr) Handle(name string, h OutputHandler) {
@@ -256,29 +473,313 @@ func (mgr *omgr) init(srv *Server) error {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
+	if cfg := srv.cfg.DeadLetter; cfg.Policy != "" {
+		if _, dup := mgr.ep[cfg.EndPoint]; dup && cfg.Policy == config.DeadLetterRedirect {
+			return fmt.Errorf("dead-letter end-point %q is already an output end-point", cfg.EndPoint)
+		}
+		q, err := newDLQ(cfg, srv.msg, srv.msg.mon)
+		if err != nil {
+			return fmt.Errorf("could not setup dead-letter handling: %w", err)
+		}
+		mgr.dlq = q
+	}
+
+	if dir := srv.cfg.Spool.Dir; dir != "" {
+		mgr.spools = make(map[string]*spool, len(mgr.ep))
+		for ep := range mgr.ep {
+			sp, err := newSpool(dir, ep, srv.cfg.Spool.MaxSize)
+			if err != nil {
+				return fmt.Errorf("could not setup spooling: %w", err)
+			}
+			if n := sp.pending(); n > 0 {
+				srv.msg.Infof("found %d spooled data frames for %q", n, ep)
+			}
+			mgr.spools[ep] = sp
+		}
+	}
+
+	if cfg := srv.cfg.Acked; len(cfg.EndPoints) > 0 {
+		if cfg.Dir == "" {
+			return fmt.Errorf("tdaq: acknowledged delivery requires a log directory")
+		}
+		mgr.acks = make(map[string]*acklog, len(cfg.EndPoints))
+		mgr.ackps = make(map[string]*rport, len(cfg.EndPoints))
+		for _, ep := range cfg.EndPoints {
+			if _, ok := mgr.ep[ep]; !ok {
+				return fmt.Errorf("tdaq: acknowledged end-point %q is not an output end-point", ep)
+			}
+			l, err := newAckLog(cfg.Dir, ep, cfg.Timeout, srv.msg, srv.now)
+			if err != nil {
+				return fmt.Errorf("could not setup acknowledged delivery: %w", err)
+			}
+			if n := l.pending(); n > 0 {
+				srv.msg.Infof("found %d unacknowledged data frames for %q", n, ep)
+			}
+			mgr.acks[ep] = l
+		}
+	}
+
+	if eps := srv.cfg.Credit.EndPoints; len(eps) > 0 {
+		mgr.crds = make(map[string]*credit, len(eps))
+		mgr.crdps = make(map[string]*rport, len(eps))
+		for _, ep := range eps {
+			if _, ok := mgr.ep[ep]; !ok {
+				return fmt.Errorf("tdaq: flow-controlled end-point %q is not an output end-point", ep)
+			}
+			mgr.crds[ep] = newCredit(srv.timeSource())
+		}
+	}
+
 	return mgr.makeListeners(srv)
 }
 
 func (mgr *omgr) makeListeners(srv *Server) error {
+	err := mgr.makeDeadLetterListener(srv)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case mgr.srv.cfg.Mux:
+		err = mgr.makeMuxListener(srv)
+	default:
+		err = mgr.makePortListeners(srv)
+	}
+	if err != nil {
+		return err
+	}
+
+	for ep, o := range mgr.ps {
+		o.spool = mgr.spools[ep]
+		if l, ok := mgr.acks[ep]; ok {
+			l.mu.Lock()
+			l.peers = o.peers
+			l.mu.Unlock()
+			o.acks = l
+		}
+		o.credit = mgr.crds[ep]
+	}
+
+	return mgr.makeFeedbackListeners(srv)
+}
+
+// makeFeedbackListeners creates the sockets receiving the acknowledgements
+// of the consumers of the output end-points delivered in acknowledged mode,
+// and the credit granted by the consumers of the output end-points under
+// flow control.
+func (mgr *omgr) makeFeedbackListeners(srv *Server) error {
+	for ep, l := range mgr.acks {
+		p, err := mgr.makeFeedbackListener(srv, ep, mgr.ackps[ep], l.handle)
+		if err != nil {
+			return fmt.Errorf("could not setup ack socket of output port %q: %w", ep, err)
+		}
+		mgr.ackps[ep] = p
+	}
+
+	for ep, c := range mgr.crds {
+		p, err := mgr.makeFeedbackListener(srv, ep, mgr.crdps[ep], c.handle)
+		if err != nil {
+			return fmt.Errorf("could not setup credit socket of output port %q: %w", ep, err)
+		}
+		mgr.crdps[ep] = p
+	}
+
+	return nil
+}
+
+// makeFeedbackListener creates a socket receiving feedback frames from the
+// consumers of the named output end-point, processed with handle.
+// The address of the previous socket, if any, is re-used.
+func (mgr *omgr) makeFeedbackListener(srv *Server, ep string, prev *rport, handle func(frame Frame) error) (*rport, error) {
+	addr := makeAddr(mgr.srv.cfg)
+	if prev != nil {
+		addr = prev.l.Address() // re-use previous run's address
+	}
+
+	sck, lis, err := makeListenerOptions(xsub.NewSocket, addr, mgr.srv.cfg.Sockets[ep])
+	if err != nil {
+		return nil, err
+	}
+	p := &rport{name: ep, l: lis, sck: sck, handle: handle}
+	go p.serve(srv.msg)
+	return p, nil
+}
+
+// ackAddrs returns the addresses of the acknowledgement sockets of the
+// output end-points delivered in acknowledged mode, indexed by end-point name.
+func (mgr *omgr) ackAddrs() map[string]string {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	return rportAddrs(mgr.ackps)
+}
+
+// creditAddrs returns the addresses of the credit sockets of the output
+// end-points under flow control, indexed by end-point name.
+func (mgr *omgr) creditAddrs() map[string]string {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	return rportAddrs(mgr.crdps)
+}
+
+func rportAddrs(ps map[string]*rport) map[string]string {
+	if len(ps) == 0 {
+		return nil
+	}
+
+	addrs := make(map[string]string, len(ps))
+	for ep, p := range ps {
+		addrs[ep] = p.l.Address()
+	}
+	return addrs
+}
+
+// makePortListeners creates one output port per output end-point.
+func (mgr *omgr) makePortListeners(srv *Server) error {
 	for ep := range mgr.ep {
-		sck, lis, err := makeListener(pub.NewSocket, func() string {
+		sck, lis, err := makeListenerOptions(pub.NewSocket, func() string {
 			switch p, ok := mgr.ps[ep]; {
 			case ok:
 				return p.addr // re-use previous run's address
 			default:
 				return makeAddr(mgr.srv.cfg)
 			}
-		}())
+		}(), mgr.srv.cfg.Sockets[ep])
 		if err != nil {
 			return fmt.Errorf("could not setup output port %q: %w", ep, err)
 		}
-		o := &oport{name: ep, addr: lis.Address(), srv: srv, l: lis, pub: sck}
+		o := &oport{name: ep, addr: lis.Address(), srv: srv, l: lis, pub: sck, max: mgr.max, peers: new(peers)}
+		sck.SetPipeEventHook(o.peers.hook)
 		mgr.ps[ep] = o
 	}
 
 	return nil
 }
 
+// makeDeadLetterListener creates the output port receiving undeliverable
+// data frames, if the dead-letter policy requires one.
+// Data frames sent on that port carry the encoded undeliverable frame.
+func (mgr *omgr) makeDeadLetterListener(srv *Server) error {
+	cfg := mgr.srv.cfg.DeadLetter
+	if mgr.dlq == nil || cfg.Policy != config.DeadLetterRedirect {
+		return nil
+	}
+
+	addr := makeAddr(mgr.srv.cfg)
+	if mgr.dlp != nil {
+		addr = mgr.dlp.addr // re-use previous run's address
+	}
+
+	sck, lis, err := makeListenerOptions(pub.NewSocket, addr, mgr.srv.cfg.Sockets[cfg.EndPoint])
+	if err != nil {
+		return fmt.Errorf("could not setup dead-letter output port %q: %w", cfg.EndPoint, err)
+	}
+	mgr.dlp = &oport{name: cfg.EndPoint, addr: lis.Address(), srv: srv, l: lis, pub: sck, max: mgr.max, peers: new(peers)}
+	sck.SetPipeEventHook(mgr.dlp.peers.hook)
+	mgr.dlq.setPort(mgr.dlp)
+
+	return nil
+}
+
+// makeMuxListener creates a single output port, shared by all output
+// end-points. Data frames are routed to input end-points using their path.
+// The socket options of the shared port are the ones of the first end-point.
+func (mgr *omgr) makeMuxListener(srv *Server) error {
+	if len(mgr.ep) == 0 {
+		return nil
+	}
+
+	eps := make([]string, 0, len(mgr.ep))
+	for ep := range mgr.ep {
+		eps = append(eps, ep)
+	}
+	sort.Strings(eps)
+
+	addr := makeAddr(mgr.srv.cfg)
+	if mgr.mux != nil {
+		addr = mgr.mux.addr // re-use previous run's address
+	}
+
+	sck, lis, err := makeListenerOptions(pub.NewSocket, addr, mgr.srv.cfg.Sockets[eps[0]])
+	if err != nil {
+		return fmt.Errorf("could not setup multiplexed output port: %w", err)
+	}
+	mgr.mux = &oport{addr: lis.Address(), srv: srv, l: lis, pub: sck, max: mgr.max, peers: new(peers)}
+	sck.SetPipeEventHook(mgr.mux.peers.hook)
+
+	for _, ep := range eps {
+		mgr.ps[ep] = &oport{
+			name:   ep,
+			addr:   lis.Address(),
+			srv:    srv,
+			l:      lis,
+			pub:    sck,
+			max:    mgr.max,
+			peers:  mgr.mux.peers,
+			shared: true,
+		}
+	}
+
+	return nil
+}
+
+// setMaxFrameSize sets the maximum size of the data frames sent on
+// the output ports.
+func (mgr *omgr) setMaxFrameSize(n int) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	mgr.max = n
+	for _, o := range mgr.ps {
+		o.max = n
+	}
+	if mgr.dlp != nil {
+		mgr.dlp.max = n
+	}
+}
+
+func (mgr *omgr) setEncoding(name, enc string) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	if mgr.encs == nil {
+		mgr.encs = make(map[string]string)
+	}
+	mgr.encs[name] = enc
+}
+
+// encodings returns the payload encodings declared by the output
+// end-points, indexed by end-point name.
+func (mgr *omgr) encodings() map[string]string {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	if len(mgr.encs) == 0 {
+		return nil
+	}
+	encs := make(map[string]string, len(mgr.encs))
+	for ep, enc := range mgr.encs {
+		encs[ep] = enc
+	}
+	return encs
+}
+
+// setCodecs sets up the negotiated payload codecs of the output end-points.
+func (mgr *omgr) setCodecs(encs map[string]string) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	eps := make([]string, 0, len(mgr.ep))
+	for ep := range mgr.ep {
+		eps = append(eps, ep)
+	}
+	cdcs, err := newCodecs(mgr.srv, encs, eps, mgr.max)
+	if err != nil {
+		return err
+	}
+	mgr.cdcs = cdcs
+	return nil
+}
+
 func (mgr *omgr) endpoints() []EndPoint {
 	mgr.mu.RLock()
 	defer mgr.mu.RUnlock()
@@ -297,6 +798,13 @@ func (mgr *omgr) endpoints() []EndPoint {
 		})
 	}
 
+	if mgr.dlp != nil {
+		eps = append(eps, EndPoint{
+			Name: mgr.dlp.name,
+			Addr: mgr.dlp.l.Address(),
+		})
+	}
+
 	return eps
 }
 
@@ -307,6 +815,9 @@ func (mgr *omgr) onReset(ctx Context) error {
 	var err error
 
 	for k, op := range mgr.ps {
+		if op.shared {
+			continue
+		}
 		e := op.onReset()
 		if e != nil {
 			err = e
@@ -314,6 +825,38 @@ func (mgr *omgr) onReset(ctx Context) error {
 		}
 	}
 
+	if mgr.mux != nil {
+		e := mgr.mux.onReset()
+		if e != nil {
+			err = e
+			ctx.Msg.Errorf("could not /reset multiplexed outgoing end-point: %+v", err)
+		}
+	}
+
+	if mgr.dlp != nil {
+		e := mgr.dlp.onReset()
+		if e != nil {
+			err = e
+			ctx.Msg.Errorf("could not /reset dead-letter end-point %q: %+v", mgr.dlp.name, err)
+		}
+	}
+
+	for k, p := range mgr.ackps {
+		e := p.close()
+		if e != nil {
+			err = e
+			ctx.Msg.Errorf("could not /reset ack socket of outgoing end-point %q: %+v", k, err)
+		}
+	}
+
+	for k, p := range mgr.crdps {
+		e := p.close()
+		if e != nil {
+			err = e
+			ctx.Msg.Errorf("could not /reset credit socket of outgoing end-point %q: %+v", k, err)
+		}
+	}
+
 	if err != nil {
 		return fmt.Errorf("could not /reset outgoing end-points: %w", err)
 	}
@@ -327,6 +870,11 @@ func (mgr *omgr) onStart(ctx Context) error {
 
 	mgr.done = make(chan error)
 
+	// a run stopped while paused leaves its output loops held.
+	mgr.hold.mu.Lock()
+	mgr.hold.release()
+	mgr.hold.mu.Unlock()
+
 	if len(mgr.ps) == 0 {
 		close(mgr.done)
 		return nil
@@ -337,8 +885,9 @@ func (mgr *omgr) onStart(ctx Context) error {
 		ept := k
 		out := mgr.ps[k]
 		fct := mgr.ep[k]
+		cdc := mgr.cdcs[k]
 		mgr.grp.Go(func() error {
-			return mgr.run(ctx, ept, out, fct)
+			return mgr.run(ctx, ept, out, fct, cdc)
 		})
 	}
 
@@ -359,50 +908,117 @@ func (mgr *omgr) onStop(ctx Context) error {
 
 	select {
 	case <-mgr.done:
-		return nil
 	case <-ctx.Ctx.Done():
 		return fmt.Errorf("on-stop failed: %w", ctx.Ctx.Err())
 	}
+
+	if mgr.dlq != nil {
+		if n := mgr.dlq.count(); n > 0 {
+			ctx.Msg.Warnf("undeliverable data frames so far: %d (policy=%s)", n, mgr.dlq.policy)
+		}
+		err := mgr.dlq.flush()
+		if err != nil {
+			return fmt.Errorf("could not flush dead-letter spill file: %w", err)
+		}
+	}
+
+	return nil
 }
 
-func (mgr *omgr) run(ctx Context, ep string, op *oport, f OutputHandler) error {
+func (mgr *omgr) run(ctx Context, ep string, op *oport, f OutputHandler, cdc *codec) error {
+	cnt := mgr.srv.stats.output(ep)
 	for {
 		select {
 		case <-ctx.Ctx.Done():
+			if op.spool != nil {
+				op.replay()
+			}
 			// send downstream clients the eof-frame poison pill
-			err := op.send(eofFrame)
+			err := op.send(Frame{Type: FrameEOF, Path: ep}.encode())
 			if err != nil {
 				ctx.Msg.Errorf("could not send eof-frame for %q (state=%v->%v): %+v", ep, mgr.srv.getCurState(), mgr.srv.getNextState(), err)
 
 			}
 			return nil
 		default:
+			if !mgr.hold.wait(ctx) {
+				continue
+			}
 			resp := Frame{Type: FrameData, Path: ep}
 			err := f(ctx, &resp)
 			if err != nil {
+				mgr.srv.stats.fail()
 				ctx.Msg.Errorf("could not process data frame for %q: %+v", ep, err)
 				continue
 			}
 			if err := ctx.Ctx.Err(); err != nil && errors.Is(err, context.Canceled) {
 				continue
 			}
-
-			msg := resp.encode()
-			err = op.send(msg)
-			if err != nil {
-				switch state := mgr.srv.getNextState(); state {
-				case fsm.Stopped:
-					// ok
-				default:
-					ctx.Msg.Errorf("could not send data frame for %q (state=%v): %+v", ep, state, err)
-				}
-				if err, ok := err.(net.Error); ok && !err.Temporary() {
-					return fmt.Errorf("could not send data frame for %q: %w", ep, err)
+			if cdc != nil {
+				resp.Body, err = cdc.output(resp.Body)
+				if err != nil {
+					mgr.srv.stats.fail()
+					ctx.Msg.Errorf("could not encode data frame for %q: %+v", ep, err)
+					continue
 				}
+			}
+
+			sampleEvent(&resp, mgr.srv.cfg.TraceRate)
+
+			// data frames produced while pausing are sent after the run
+			// is resumed, following the hold-frame.
+			if !mgr.hold.enter(ctx) {
 				continue
 			}
+			err = mgr.send(ctx, ep, op, cnt, resp)
+			mgr.hold.leave()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// send sends a data frame produced by the named output end-point.
+// send only returns an error when the output end-point cannot send data
+// frames anymore.
+func (mgr *omgr) send(ctx Context, ep string, op *oport, cnt *epCounter, resp Frame) error {
+	beg := time.Now()
+
+	if op.credit != nil {
+		err := op.credit.acquire(ctx.Ctx, op.messages(resp), len(resp.Body))
+		if err != nil {
+			mgr.srv.stats.block(time.Since(beg))
+			return nil
+		}
+	}
+
+	err := op.sendFrame(resp)
+	mgr.srv.stats.block(time.Since(beg))
+	if err != nil {
+		switch state := mgr.srv.getNextState(); {
+		case state == fsm.Stopped:
+			// ok
+		case mgr.dlq != nil:
+			mgr.srv.stats.drop()
+			e := mgr.dlq.handle(ep, resp, err)
+			if e != nil {
+				ctx.Msg.Errorf("could not handle undeliverable data frame for %q: %+v", ep, e)
+			}
+		default:
+			mgr.srv.stats.drop()
+			ctx.Msg.Errorf("could not send data frame for %q (state=%v): %+v", ep, state, err)
+		}
+		if err, ok := err.(net.Error); ok && !err.Temporary() {
+			return fmt.Errorf("could not send data frame for %q: %w", ep, err)
 		}
+		return nil
 	}
+	cnt.add(resp)
+	if resp.Trace {
+		traceEvent(ctx.Msg, "sent", ep, resp, beg)
+	}
+	return nil
 }
 
 type cmdmgr struct {
@@ -473,12 +1089,54 @@ func (mgr *cmdmgr) endpoint(name string) (CmdHandler, bool) {
 	return h, ok
 }
 
+// rport is a socket receiving feedback frames (acknowledgements, credits)
+// from the consumers of an output end-point.
+type rport struct {
+	name   string
+	l      mangos.Listener
+	sck    mangos.Socket
+	handle func(frame Frame) error
+}
+
+// serve processes the received feedback frames, until the socket is closed.
+func (p *rport) serve(msg log.MsgStream) {
+	for {
+		frame, err := RecvFrame(context.Background(), p.sck)
+		if err != nil {
+			return
+		}
+		err = p.handle(frame)
+		if err != nil {
+			msg.Warnf("could not process feedback frame for %q: %+v", p.name, err)
+		}
+	}
+}
+
+func (p *rport) close() error {
+	e1 := p.l.Close()
+	e2 := p.sck.Close()
+	if e1 != nil {
+		return e1
+	}
+	return e2
+}
+
 type oport struct {
 	name string
 	addr string
 	srv  *Server
 	l    mangos.Listener
 	pub  mangos.Socket
+	seq  uint32 // sequence number of chunked frames
+	max  int    // maximum size of data frames
+
+	peers   *peers  // consumers connected to the port
+	spool   *spool  // spool of data frames while no consumer is connected (may be nil)
+	spooled int     // last published number of spooled data frames
+	acks    *acklog // log of unacknowledged data frames, in acknowledged mode (may be nil)
+	credit  *credit // credit granted by consumers, under flow control (may be nil)
+
+	shared bool // whether the socket is shared with other end-points
 }
 
 func (o *oport) close() {
@@ -501,3 +1159,139 @@ func (o *oport) onReset() error {
 func (o *oport) send(data []byte) error {
 	return o.pub.Send(data)
 }
+
+// sendFrame sends the provided frame.
+//
+// In acknowledged mode, the frame is logged until all its consumers
+// acknowledged it, and it is retransmitted as needed.
+// Otherwise, when spooling is enabled, the frame is spooled to local disk if no
+// consumer is connected, or if previously spooled frames could not be
+// replayed yet.
+// Otherwise, when dead-letter handling is enabled, sendFrame waits up to the
+// dead-letter deadline for a consumer to be connected and returns
+// ErrNoConsumer if none showed up.
+func (o *oport) sendFrame(frame Frame) error {
+	switch dl := o.srv.cfg.DeadLetter; {
+	case o.acks != nil:
+		return o.acks.send(frame, o.deliver)
+	case o.spool != nil:
+		return o.sendSpool(frame)
+	case dl.Policy != "" && o.peers != nil:
+		if !o.peers.wait(dl.Deadline) {
+			return fmt.Errorf("could not send data frame for %q: %w", o.name, ErrNoConsumer)
+		}
+	}
+	return o.deliver(frame)
+}
+
+// sendSpool sends the provided frame after the spooled ones, or spools it.
+func (o *oport) sendSpool(frame Frame) error {
+	defer o.monSpool()
+
+	if o.peers.connected() && o.spool.pending() > 0 {
+		o.replay()
+	}
+
+	if !o.peers.connected() || o.spool.pending() > 0 {
+		err := o.spool.push(frame)
+		if err != nil {
+			return fmt.Errorf("could not spool data frame for %q: %w", o.name, err)
+		}
+		return nil
+	}
+
+	return o.deliver(frame)
+}
+
+// replay sends the spooled frames, if a consumer is connected.
+func (o *oport) replay() {
+	if !o.peers.connected() {
+		return
+	}
+	n := o.spool.pending()
+	if n == 0 {
+		return
+	}
+	err := o.spool.replay(o.deliver)
+	if err != nil {
+		o.srv.msg.Warnf("could not replay spooled data frames for %q: %+v", o.name, err)
+		return
+	}
+	o.srv.msg.Infof("replayed %d spooled data frames for %q", n, o.name)
+}
+
+// monSpool publishes the number of spooled data frames, when it changed.
+func (o *oport) monSpool() {
+	n := o.spool.pending()
+	if n == o.spooled {
+		return
+	}
+	o.spooled = n
+	o.srv.msg.mon(o.name+"/spooled", float64(n))
+}
+
+// deliver sends the provided frame, splitting it into chunks if
+// its payload is larger than the configured chunk size or if the frame
+// would exceed the maximum frame size.
+// Frames with event tags are wrapped into event frames and, when latency
+// measurement is enabled, frames are stamped with their send time.
+func (o *oport) deliver(frame Frame) error {
+	if tagged(frame) {
+		frame = tagFrame(frame)
+	}
+	if o.srv.cfg.Latency {
+		frame = stampFrame(frame, time.Now())
+	}
+
+	size, err := o.chunkSize(frame)
+	if err != nil {
+		return err
+	}
+
+	if len(frame.Body) <= size {
+		return o.send(frame.encode())
+	}
+
+	o.seq++
+	for _, msg := range chunkFrame(frame, size, o.seq) {
+		err := o.send(msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkSize returns the maximum size of the payload of the chunks of
+// the provided frame.
+func (o *oport) chunkSize(frame Frame) (int, error) {
+	size := o.srv.cfg.ChunkSize
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if o.max > 0 {
+		if max := o.max - (2 + len(frame.Path) + chunkHdrSize); max < size {
+			size = max
+		}
+		if size <= 0 {
+			return 0, fmt.Errorf("could not send data frame: maximum frame size too small (max=%d)", o.max)
+		}
+	}
+	return size, nil
+}
+
+// messages returns the number of messages needed to send the provided frame.
+func (o *oport) messages(frame Frame) int {
+	size, err := o.chunkSize(frame)
+	n := len(frame.Body)
+	if tagged(frame) {
+		n += eventHdrSize
+	}
+	if o.srv.cfg.Latency {
+		n += stampHdrSize
+	}
+	if err != nil || n <= size {
+		return 1
+	}
+	return (n + size - 1) / size
+}
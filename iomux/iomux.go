@@ -0,0 +1,257 @@
+// Copyright 2019 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package iomux provides simple goroutine safe I/O primitives.
+//
+// Socket and Pipe are in-memory stand-ins for the sockets of TDAQ
+// processes: they implement the tdaq.Sender and tdaq.Recver interfaces,
+// so device authors can unit-test their frame I/O without a network.
+package iomux // import "github.com/go-daq/tdaq/iomux"
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.nanomsg.org/mangos/v3"
+)
+
+// Writer is a goroutine-safe io.Writer.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	n, err := w.w.Write(p)
+	w.mu.Unlock()
+	return n, err
+}
+
+func (w *Writer) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	o := new(strings.Builder)
+	fmt.Fprintf(o, "%v", w.w)
+	return o.String()
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	if wc, ok := w.w.(io.Closer); ok {
+		err = wc.Close()
+	}
+	return err
+}
+
+// Socket is a goroutine-safe in-memory queue of messages.
+//
+// Messages are received in the order they were sent, with Recv, or read as
+// a stream of bytes, with Read.
+// Send never blocks, while Recv blocks until a message is available, the
+// socket is closed or the receive deadline expires.
+// Receive deadlines are set like the ones of mangos sockets, with the
+// mangos.OptionRecvDeadline option.
+//
+// The zero value is an empty socket ready to use.
+type Socket struct {
+	mu     sync.Mutex
+	buf    net.Buffers
+	ready  chan struct{} // closed when a message is sent, or the socket closed
+	closed bool
+	dl     time.Duration // receive deadline (0: none)
+}
+
+// Send queues a copy of the provided message.
+// Send returns mangos.ErrClosed if the socket is closed.
+func (sck *Socket) Send(p []byte) error {
+	sck.mu.Lock()
+	defer sck.mu.Unlock()
+
+	if sck.closed {
+		return mangos.ErrClosed
+	}
+	sck.buf = append(sck.buf, append([]byte(nil), p...))
+	sck.notify()
+	return nil
+}
+
+// Write queues a copy of the provided bytes, as a message.
+func (sck *Socket) Write(p []byte) (int, error) {
+	err := sck.Send(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Recv returns the oldest queued message.
+// Recv blocks until a message is available, and returns mangos.ErrClosed
+// once the socket is closed and drained, or mangos.ErrRecvTimeout when the
+// receive deadline expires.
+func (sck *Socket) Recv() ([]byte, error) {
+	var timeout <-chan time.Time
+	for {
+		sck.mu.Lock()
+		if len(sck.buf) > 0 {
+			msg := sck.buf[0]
+			sck.buf = sck.buf[1:]
+			sck.mu.Unlock()
+			return msg, nil
+		}
+		if sck.closed {
+			sck.mu.Unlock()
+			return nil, mangos.ErrClosed
+		}
+		if sck.ready == nil {
+			sck.ready = make(chan struct{})
+		}
+		ready := sck.ready
+		if timeout == nil && sck.dl > 0 {
+			tmr := time.NewTimer(sck.dl)
+			defer tmr.Stop()
+			timeout = tmr.C
+		}
+		sck.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-timeout:
+			return nil, mangos.ErrRecvTimeout
+		}
+	}
+}
+
+// Read reads the queued messages as a stream of bytes.
+// Read does not block, and returns io.EOF when no bytes are queued.
+func (sck *Socket) Read(p []byte) (int, error) {
+	sck.mu.Lock()
+	defer sck.mu.Unlock()
+	return sck.buf.Read(p)
+}
+
+// Len returns the number of queued messages.
+func (sck *Socket) Len() int {
+	sck.mu.Lock()
+	defer sck.mu.Unlock()
+	return len(sck.buf)
+}
+
+// Close closes the socket.
+// Messages already queued can still be received.
+func (sck *Socket) Close() error {
+	sck.mu.Lock()
+	defer sck.mu.Unlock()
+
+	if sck.closed {
+		return mangos.ErrClosed
+	}
+	sck.closed = true
+	sck.notify()
+	return nil
+}
+
+// SetOption sets the receive deadline of the socket, with the
+// mangos.OptionRecvDeadline option (0: none).
+// The mangos.OptionSendDeadline option is accepted, and ignored as Send
+// never blocks.
+func (sck *Socket) SetOption(name string, v interface{}) error {
+	switch name {
+	case mangos.OptionRecvDeadline, mangos.OptionSendDeadline:
+		d, ok := v.(time.Duration)
+		if !ok {
+			return mangos.ErrBadValue
+		}
+		if name == mangos.OptionRecvDeadline {
+			sck.mu.Lock()
+			sck.dl = d
+			sck.mu.Unlock()
+		}
+		return nil
+	default:
+		return mangos.ErrBadOption
+	}
+}
+
+// GetOption returns the receive deadline of the socket, with the
+// mangos.OptionRecvDeadline option.
+func (sck *Socket) GetOption(name string) (interface{}, error) {
+	switch name {
+	case mangos.OptionRecvDeadline:
+		sck.mu.Lock()
+		defer sck.mu.Unlock()
+		return sck.dl, nil
+	default:
+		return nil, mangos.ErrBadOption
+	}
+}
+
+// notify wakes up the goroutines waiting for a message.
+// notify must be called with sck.mu held.
+func (sck *Socket) notify() {
+	if sck.ready == nil {
+		return
+	}
+	close(sck.ready)
+	sck.ready = nil
+}
+
+// Conn is one end of an in-memory duplex pipe, created with Pipe.
+type Conn struct {
+	rx *Socket // messages received from the other end
+	tx *Socket // messages sent to the other end
+
+	once sync.Once
+}
+
+// Pipe creates an in-memory duplex pipe: the messages sent on one end are
+// received on the other one, with the semantics of Socket.
+// Closing either end closes the pipe in both directions, once the queued
+// messages are received.
+func Pipe() (*Conn, *Conn) {
+	var (
+		a2b = new(Socket)
+		b2a = new(Socket)
+	)
+	return &Conn{rx: b2a, tx: a2b}, &Conn{rx: a2b, tx: b2a}
+}
+
+// Send sends a copy of the provided message to the other end of the pipe.
+func (c *Conn) Send(p []byte) error { return c.tx.Send(p) }
+
+// Recv receives a message from the other end of the pipe.
+func (c *Conn) Recv() ([]byte, error) { return c.rx.Recv() }
+
+// SetOption sets the receive deadline of this end of the pipe.
+func (c *Conn) SetOption(name string, v interface{}) error { return c.rx.SetOption(name, v) }
+
+// GetOption returns the receive deadline of this end of the pipe.
+func (c *Conn) GetOption(name string) (interface{}, error) { return c.rx.GetOption(name) }
+
+// Close closes the pipe.
+// Close returns mangos.ErrClosed if this end was already closed.
+func (c *Conn) Close() error {
+	var err error = mangos.ErrClosed
+	c.once.Do(func() {
+		_ = c.tx.Close()
+		_ = c.rx.Close()
+		err = nil
+	})
+	return err
+}
+
+var (
+	_ io.Writer     = (*Writer)(nil)
+	_ io.ReadWriter = (*Socket)(nil)
+)
@@ -0,0 +1,127 @@
+// Copyright 2019 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package iomux // import "github.com/go-daq/tdaq/iomux"
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"go.nanomsg.org/mangos/v3"
+)
+
+func TestStringer(t *testing.T) {
+	want := "hello"
+
+	o := NewWriter(new(bytes.Buffer))
+	_, err := o.Write([]byte(want))
+	if err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+
+	got1 := o.String()
+	if got1 != want {
+		t.Fatalf("invalid stringer: got1=%q, want=%q", got1, want)
+	}
+
+	got2 := o.String()
+	if got2 != want {
+		t.Fatalf("invalid stringer: got2=%q, want=%q", got2, want)
+	}
+}
+
+func TestSocket(t *testing.T) {
+	sck := new(Socket)
+	for _, msg := range []string{"msg-1", "msg-2"} {
+		err := sck.Send([]byte(msg))
+		if err != nil {
+			t.Fatalf("could not send %q: %+v", msg, err)
+		}
+	}
+	if got, want := sck.Len(), 2; got != want {
+		t.Fatalf("invalid number of queued messages: got=%d, want=%d", got, want)
+	}
+
+	for _, want := range []string{"msg-1", "msg-2"} {
+		got, err := sck.Recv()
+		if err != nil {
+			t.Fatalf("could not recv %q: %+v", want, err)
+		}
+		if string(got) != want {
+			t.Fatalf("invalid message:\ngot = %q\nwant= %q\n", got, want)
+		}
+	}
+
+	done := make(chan []byte)
+	go func() {
+		msg, _ := sck.Recv()
+		done <- msg
+	}()
+	_ = sck.Send([]byte("msg-3"))
+	if got, want := string(<-done), "msg-3"; got != want {
+		t.Fatalf("invalid blocking recv:\ngot = %q\nwant= %q\n", got, want)
+	}
+
+	err := sck.SetOption(mangos.OptionRecvDeadline, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not set recv deadline: %+v", err)
+	}
+	_, err = sck.Recv()
+	if !errors.Is(err, mangos.ErrRecvTimeout) {
+		t.Fatalf("invalid error: got=%v, want=%v", err, mangos.ErrRecvTimeout)
+	}
+
+	_ = sck.Send([]byte("msg-4"))
+	err = sck.Close()
+	if err != nil {
+		t.Fatalf("could not close socket: %+v", err)
+	}
+	if err := sck.Send([]byte("msg-5")); !errors.Is(err, mangos.ErrClosed) {
+		t.Fatalf("invalid send error: got=%v, want=%v", err, mangos.ErrClosed)
+	}
+	if got, err := sck.Recv(); err != nil || string(got) != "msg-4" {
+		t.Fatalf("could not drain closed socket: msg=%q, err=%v", got, err)
+	}
+	if _, err := sck.Recv(); !errors.Is(err, mangos.ErrClosed) {
+		t.Fatalf("invalid recv error: got=%v, want=%v", err, mangos.ErrClosed)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe()
+
+	_ = a.Send([]byte("ping"))
+	msg, err := b.Recv()
+	if err != nil || string(msg) != "ping" {
+		t.Fatalf("could not recv ping: msg=%q, err=%v", msg, err)
+	}
+
+	_ = b.Send([]byte("pong"))
+	msg, err = a.Recv()
+	if err != nil || string(msg) != "pong" {
+		t.Fatalf("could not recv pong: msg=%q, err=%v", msg, err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := a.Recv()
+		done <- err
+	}()
+
+	err = b.Close()
+	if err != nil {
+		t.Fatalf("could not close pipe: %+v", err)
+	}
+	if err := <-done; !errors.Is(err, mangos.ErrClosed) {
+		t.Fatalf("invalid recv error: got=%v, want=%v", err, mangos.ErrClosed)
+	}
+	if err := a.Send([]byte("ping")); !errors.Is(err, mangos.ErrClosed) {
+		t.Fatalf("invalid send error: got=%v, want=%v", err, mangos.ErrClosed)
+	}
+	if err := b.Close(); !errors.Is(err, mangos.ErrClosed) {
+		t.Fatalf("invalid close error: got=%v, want=%v", err, mangos.ErrClosed)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Ordering describes the order in which the data frames of an input
+// end-point must be processed.
+//
+// The framework picks the processing strategy of an input end-point from
+// its ordering, and rejects the registration of handlers with workers that
+// could not honor it.
+type Ordering uint8
+
+const (
+	// OrderStrict processes the data frames one at a time, in the order
+	// they were received, with a single worker.
+	OrderStrict Ordering = iota + 1
+
+	// OrderPerProducer processes the data frames of a given producer in
+	// order, the data frames of different producers concurrently.
+	// Producers are identified by the ShardBy key of the end-point, if
+	// any, or by their data link otherwise: each data link is then
+	// processed by a single worker.
+	OrderPerProducer
+
+	// OrderNone processes the data frames concurrently, in any order.
+	OrderNone
+)
+
+func (ord Ordering) String() string {
+	switch ord {
+	case 0:
+		return "default"
+	case OrderStrict:
+		return "strict"
+	case OrderPerProducer:
+		return "per-producer"
+	case OrderNone:
+		return "none"
+	default:
+		return fmt.Sprintf("Ordering(%d)", uint8(ord))
+	}
+}
+
+// InputOption configures how the data frames of an input end-point are
+// processed by its workers.
+type InputOption func(w *inputWorkers)
+
+// Order declares the ordering required by the handler of an input
+// end-point.
+// Without it, the ordering follows from the workers of the end-point:
+// strict for a single worker, per-producer with ShardBy, none otherwise.
+func Order(ord Ordering) InputOption {
+	return func(w *inputWorkers) {
+		w.order = ord
+	}
+}
+
+// BusyPoll enables the low-latency mode of an input end-point, for
+// trigger-path streams, trading CPU for latency:
+//   - the goroutines receiving and dispatching its data frames are pinned
+//     to their OS threads,
+//   - the dispatching goroutine spins on its queue instead of sleeping,
+//   - the paths of the received frames are pre-allocated.
+func BusyPoll() InputOption {
+	return func(w *inputWorkers) {
+		w.busy = true
+	}
+}
+
+// ShardBy shards the data frames of an input end-point among its workers
+// with the provided key: data frames with the same key (e.g. the same
+// source) are all processed, in order, by the same worker.
+func ShardBy(key func(frame Frame) uint64) InputOption {
+	return func(w *inputWorkers) {
+		w.key = key
+	}
+}
+
+// inputWorkers describes the workers of an input end-point.
+type inputWorkers struct {
+	n     int                      // number of workers
+	key   func(frame Frame) uint64 // sharding key of the data frames (may be nil)
+	order Ordering                 // ordering of the data frames
+	busy  bool                     // whether the end-point is in low-latency mode
+
+	window int    // size of the window of duplicate suppression (0: disabled)
+	dups   *dedup // duplicate suppression of the data frames (may be nil)
+
+	seq    func(frame Frame) uint64 // sequence number of the reordered data frames (may be nil)
+	rwin   int                      // maximum number of data frames held for reordering
+	rdelay time.Duration            // maximum time a data frame is held for reordering (0: unbounded)
+	gap    GapHandler               // handler of the skipped data frames (may be nil)
+}
+
+// resolve checks the workers of the named input end-point honor its
+// ordering, and picks the number of workers when left to the framework
+// (n<=0).
+func (w *inputWorkers) resolve(name string) error {
+	switch {
+	case w.window < 0:
+		return fmt.Errorf("invalid duplicate suppression window %d for input end-point %q", w.window, name)
+	case w.window > 0:
+		w.dups = newDedup(w.window)
+	}
+
+	if w.order == 0 {
+		switch {
+		case w.key != nil:
+			w.order = OrderPerProducer
+		case w.n > 1:
+			w.order = OrderNone
+		default:
+			w.order = OrderStrict
+		}
+	}
+
+	switch w.order {
+	case OrderStrict:
+		if w.n > 1 || w.key != nil {
+			return fmt.Errorf("strict ordering of input end-point %q requires a single unsharded worker (workers=%d)", name, w.n)
+		}
+		w.n = 1
+	case OrderPerProducer:
+		switch {
+		case w.key != nil && w.n <= 0:
+			w.n = runtime.GOMAXPROCS(0)
+		case w.key == nil && w.n > 1:
+			return fmt.Errorf("per-producer ordering of input end-point %q with %d workers requires a ShardBy key", name, w.n)
+		case w.key == nil:
+			w.n = 1
+		}
+	case OrderNone:
+		if w.key != nil {
+			return fmt.Errorf("unordered input end-point %q can not be sharded", name)
+		}
+		if w.n <= 0 {
+			w.n = runtime.GOMAXPROCS(0)
+		}
+	default:
+		return fmt.Errorf("invalid ordering %v for input end-point %q", w.order, name)
+	}
+	return w.resolveReorder(name)
+}
+
+// ipool processes the data frames of an input end-point with several
+// worker goroutines.
+//
+// Without a sharding key, all workers consume a shared queue, so data frames
+// are processed as soon as a worker is available, possibly out of order.
+// With a sharding key, each worker consumes its own queue.
+type ipool struct {
+	key  func(frame Frame) uint64
+	qs   []chan Frame   // queues of the workers
+	wg   sync.WaitGroup // running workers
+	busy sync.WaitGroup // data frames submitted and not yet processed
+}
+
+// newIPool starts the workers processing data frames with f.
+func newIPool(ctx Context, w inputWorkers, f func(ctx Context, frame Frame)) *ipool {
+	n := w.n
+	if n < 1 {
+		n = 1
+	}
+	p := &ipool{key: w.key}
+	switch p.key {
+	case nil:
+		p.qs = []chan Frame{make(chan Frame, n)}
+	default:
+		p.qs = make([]chan Frame, n)
+		for i := range p.qs {
+			p.qs[i] = make(chan Frame, 1)
+		}
+	}
+
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		q := p.qs[i%len(p.qs)]
+		go func() {
+			defer p.wg.Done()
+			for frame := range q {
+				f(ctx, frame)
+				p.busy.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues the provided data frame for processing, blocking while the
+// queue of its worker is full.
+func (p *ipool) submit(frame Frame) {
+	q := p.qs[0]
+	if p.key != nil {
+		q = p.qs[p.key(frame)%uint64(len(p.qs))]
+	}
+	p.busy.Add(1)
+	q <- frame
+}
+
+// wait waits for all the submitted data frames to be processed.
+func (p *ipool) wait() {
+	p.busy.Wait()
+}
+
+// close processes the remaining data frames and terminates the workers.
+func (p *ipool) close() {
+	for _, q := range p.qs {
+		close(q)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,235 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestDemuxWorkers(t *testing.T) {
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("demux", log.LvlError, ioutil.Discard),
+	}
+
+	const (
+		nsrcs   = 4
+		nframes = 100
+	)
+	frames := make([]Frame, nframes)
+	for i := range frames {
+		frames[i] = Frame{Type: FrameData, Path: "/adc", Body: []byte{byte(i % nsrcs), byte(i)}}
+	}
+
+	t.Run("unordered", func(t *testing.T) {
+		var (
+			mu    sync.Mutex
+			got   []int
+			cur   int
+			peak  int
+			start = make(chan struct{})
+			once  sync.Once
+		)
+		hs := map[string]InputHandler{
+			"/adc": func(ctx Context, src Frame) error {
+				mu.Lock()
+				cur++
+				if cur > peak {
+					peak = cur
+				}
+				if cur == 2 {
+					once.Do(func() { close(start) })
+				}
+				mu.Unlock()
+
+				// wait for a second worker to process a frame concurrently.
+				select {
+				case <-start:
+				case <-time.After(2 * time.Second):
+				}
+
+				mu.Lock()
+				cur--
+				got = append(got, int(src.Body[1]))
+				mu.Unlock()
+				return nil
+			},
+		}
+		wks := map[string]inputWorkers{"/adc": {n: 4}}
+		mux := newDemux(ctx, []string{"/adc"}, hs, wks, func(string) int { return 8 }, 0, nil, nil, nil, nil)
+		for _, frame := range frames {
+			mux.dispatch(ctx, frame)
+		}
+		mux.close()
+
+		if peak < 2 {
+			t.Fatalf("data frames not processed concurrently (peak=%d)", peak)
+		}
+		sort.Ints(got)
+		for i, v := range got {
+			if v != i {
+				t.Fatalf("invalid processed frames: %v", got)
+			}
+		}
+		if len(got) != nframes {
+			t.Fatalf("invalid number of processed frames: got=%d, want=%d", len(got), nframes)
+		}
+	})
+
+	t.Run("sharded", func(t *testing.T) {
+		var (
+			mu  sync.Mutex
+			got = make(map[byte][]int)
+		)
+		hs := map[string]InputHandler{
+			"/adc": func(ctx Context, src Frame) error {
+				mu.Lock()
+				defer mu.Unlock()
+				got[src.Body[0]] = append(got[src.Body[0]], int(src.Body[1]))
+				return nil
+			},
+		}
+		var w inputWorkers
+		w.n = 3
+		ShardBy(func(frame Frame) uint64 { return uint64(frame.Body[0]) })(&w)
+
+		mux := newDemux(ctx, []string{"/adc"}, hs, map[string]inputWorkers{"/adc": w}, func(string) int { return 8 }, 0, nil, nil, nil, nil)
+		for _, frame := range frames {
+			mux.dispatch(ctx, frame)
+		}
+		mux.close()
+
+		if got, want := len(got), nsrcs; got != want {
+			t.Fatalf("invalid number of sources: got=%d, want=%d", got, want)
+		}
+		for src, vs := range got {
+			if len(vs) != nframes/nsrcs || !sort.IntsAreSorted(vs) {
+				t.Fatalf("invalid frames for source %d: %v", src, vs)
+			}
+		}
+	})
+}
+
+func TestBusyPoll(t *testing.T) {
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("demux", log.LvlError, ioutil.Discard),
+	}
+
+	var w inputWorkers
+	BusyPoll()(&w)
+	if err := w.resolve("/trig"); err != nil {
+		t.Fatalf("could not resolve workers: %+v", err)
+	}
+
+	var (
+		mu  sync.Mutex
+		got []int
+		hs  = map[string]InputHandler{
+			"/trig": func(ctx Context, src Frame) error {
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, int(src.Body[0]))
+				return nil
+			},
+		}
+	)
+	mux := newDemux(ctx, []string{"/trig"}, hs, map[string]inputWorkers{"/trig": w}, func(string) int { return 4 }, 0, nil, nil, nil, nil)
+	for i := 0; i < 10; i++ {
+		mux.dispatch(ctx, Frame{Type: FrameData, Path: "/trig", Body: []byte{byte(i)}})
+	}
+	mux.close()
+
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frames:\ngot = %v\nwant= %v", got, want)
+	}
+
+	// paths of frames received in low-latency mode are pre-allocated.
+	var (
+		sck   Recver = rawRecver([]byte{byte(FrameData), 5, '/', 't', 'r', 'i', 'g', 42})
+		paths        = map[string]string{"/trig": "/trig"}
+	)
+	allocs := testing.AllocsPerRun(100, func() {
+		frame, err := recvFrame(sck, 0, paths)
+		if err != nil || frame.Path != "/trig" {
+			t.Fatalf("invalid frame: %#v (err=%+v)", frame, err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("invalid number of allocations: got=%v, want=0", allocs)
+	}
+}
+
+func TestInputOrdering(t *testing.T) {
+	key := func(frame Frame) uint64 { return 0 }
+	ncpu := runtime.GOMAXPROCS(0)
+	for _, tc := range []struct {
+		name  string
+		n     int
+		opts  []InputOption
+		order Ordering
+		want  int
+		err   string
+	}{
+		{name: "default", n: 0, order: OrderStrict, want: 1},
+		{name: "default-workers", n: 4, order: OrderNone, want: 4},
+		{name: "default-sharded", n: 4, opts: []InputOption{ShardBy(key)}, order: OrderPerProducer, want: 4},
+		{name: "strict", n: 0, opts: []InputOption{Order(OrderStrict)}, order: OrderStrict, want: 1},
+		{
+			name: "strict-workers", n: 2, opts: []InputOption{Order(OrderStrict)},
+			err: `strict ordering of input end-point "/adc" requires a single unsharded worker (workers=2)`,
+		},
+		{
+			name: "strict-sharded", n: 1, opts: []InputOption{Order(OrderStrict), ShardBy(key)},
+			err: `strict ordering of input end-point "/adc" requires a single unsharded worker (workers=1)`,
+		},
+		{name: "per-producer-links", n: 0, opts: []InputOption{Order(OrderPerProducer)}, order: OrderPerProducer, want: 1},
+		{name: "per-producer-sharded", n: 0, opts: []InputOption{Order(OrderPerProducer), ShardBy(key)}, order: OrderPerProducer, want: ncpu},
+		{
+			name: "per-producer-workers", n: 4, opts: []InputOption{Order(OrderPerProducer)},
+			err: `per-producer ordering of input end-point "/adc" with 4 workers requires a ShardBy key`,
+		},
+		{name: "none", n: 0, opts: []InputOption{Order(OrderNone)}, order: OrderNone, want: ncpu},
+		{
+			name: "none-sharded", n: 4, opts: []InputOption{Order(OrderNone), ShardBy(key)},
+			err: `unordered input end-point "/adc" can not be sharded`,
+		},
+		{
+			name: "invalid", n: 1, opts: []InputOption{Order(42)},
+			err: `invalid ordering Ordering(42) for input end-point "/adc"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := inputWorkers{n: tc.n}
+			for _, opt := range tc.opts {
+				opt(&w)
+			}
+			err := w.resolve("/adc")
+			switch {
+			case err != nil && tc.err == "":
+				t.Fatalf("could not resolve workers: %+v", err)
+			case err == nil && tc.err != "":
+				t.Fatalf("expected an error")
+			case err != nil:
+				if got, want := err.Error(), tc.err; got != want {
+					t.Fatalf("invalid error:\ngot = %q\nwant= %q", got, want)
+				}
+				return
+			}
+			if w.order != tc.order || w.n != tc.want {
+				t.Fatalf("invalid workers:\ngot = %v/%d\nwant= %v/%d", w.order, w.n, tc.order, tc.want)
+			}
+		})
+	}
+}
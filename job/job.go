@@ -17,7 +17,7 @@ import (
 
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/config"
-	"github.com/go-daq/tdaq/internal/iomux"
+	"github.com/go-daq/tdaq/iomux"
 	"github.com/go-daq/tdaq/log"
 	"golang.org/x/sync/errgroup"
 )
@@ -0,0 +1,477 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KVEntry is a value of the key-value configuration store of run-ctl.
+//
+// Keys are "<process>/<key>" paths: at /config, each TDAQ process receives
+// the values under its name, available with Context.KV.
+type KVEntry struct {
+	Key     string    `json:"key"`
+	Value   string    `json:"value"`
+	Version uint64    `json:"version"`        // version of the store at the last change of the value
+	Time    time.Time `json:"time"`           // time of the last change of the value
+	User    string    `json:"user,omitempty"` // operator who last changed the value
+}
+
+// KVChange is a change of the key-value configuration store of run-ctl.
+type KVChange struct {
+	Version uint64    `json:"version"` // version of the store after the change
+	Key     string    `json:"key"`
+	Value   string    `json:"value,omitempty"` // new value (empty if deleted)
+	Old     string    `json:"old,omitempty"`   // previous value (empty if created)
+	Deleted bool      `json:"deleted,omitempty"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user,omitempty"`
+}
+
+// kvDB holds the key-value configuration store of run-ctl, with the
+// history of its changes.
+// When a file name is provided, the store is persisted to that file after
+// each change, and restored from it at creation.
+type kvDB struct {
+	fname string
+	feed  *feed
+	now   func() time.Time
+
+	mu      sync.RWMutex
+	version uint64
+	entries map[string]KVEntry
+	history []KVChange
+}
+
+// kvState is the persisted state of the key-value store.
+type kvState struct {
+	Version uint64     `json:"version"`
+	Entries []KVEntry  `json:"entries"`
+	History []KVChange `json:"history"`
+}
+
+func newKVDB(fname string, feed *feed) (*kvDB, error) {
+	db := &kvDB{
+		fname:   fname,
+		feed:    feed,
+		now:     time.Now,
+		entries: make(map[string]KVEntry),
+	}
+	if fname == "" {
+		return db, nil
+	}
+
+	raw, err := ioutil.ReadFile(fname)
+	switch {
+	case err == nil:
+		var state kvState
+		err = json.Unmarshal(raw, &state)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode key-value store %q: %w", fname, err)
+		}
+		db.version = state.Version
+		db.history = state.History
+		for _, e := range state.Entries {
+			db.entries[e.Key] = e
+		}
+	case os.IsNotExist(err):
+		// ok.
+	default:
+		return nil, fmt.Errorf("could not read key-value store %q: %w", fname, err)
+	}
+
+	return db, nil
+}
+
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	return nil
+}
+
+// set sets the value of the key and returns the new version of the store.
+func (db *kvDB) set(key, value, user string) (uint64, error) {
+	err := validKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, ok := db.entries[key]
+	if ok && old.Value == value {
+		return db.version, nil
+	}
+
+	db.version++
+	now := db.now().UTC()
+	db.entries[key] = KVEntry{
+		Key:     key,
+		Value:   value,
+		Version: db.version,
+		Time:    now,
+		User:    user,
+	}
+	db.record(KVChange{
+		Version: db.version,
+		Key:     key,
+		Value:   value,
+		Old:     old.Value,
+		Time:    now,
+		User:    user,
+	})
+
+	return db.version, db.save()
+}
+
+// del deletes the key and returns the new version of the store.
+func (db *kvDB) del(key, user string) (uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, ok := db.entries[key]
+	if !ok {
+		return db.version, fmt.Errorf("no key %q", key)
+	}
+
+	db.version++
+	delete(db.entries, key)
+	db.record(KVChange{
+		Version: db.version,
+		Key:     key,
+		Old:     old.Value,
+		Deleted: true,
+		Time:    db.now().UTC(),
+		User:    user,
+	})
+
+	return db.version, db.save()
+}
+
+// record appends the change to the history of the store.
+// record must be called with db.mu held.
+func (db *kvDB) record(change KVChange) {
+	db.history = append(db.history, change)
+	if db.feed != nil {
+		db.feed.publish("kv", change)
+	}
+}
+
+func (db *kvDB) get(key string) (KVEntry, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	e, ok := db.entries[key]
+	return e, ok
+}
+
+// list returns the version of the store and its entries under the provided
+// prefix, sorted by key.
+func (db *kvDB) list(prefix string) (uint64, []KVEntry) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	entries := make([]KVEntry, 0, len(db.entries))
+	for key, e := range db.entries {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return db.version, entries
+}
+
+// scope returns the version of the store and the values under the name of
+// the provided process, indexed by key relative to that name.
+func (db *kvDB) scope(proc string) (uint64, map[string]string) {
+	var (
+		prefix       = proc + "/"
+		vers, values = db.list(prefix)
+	)
+	if len(values) == 0 {
+		return vers, nil
+	}
+	kv := make(map[string]string, len(values))
+	for _, e := range values {
+		kv[strings.TrimPrefix(e.Key, prefix)] = e.Value
+	}
+	return vers, kv
+}
+
+// changes returns the history of the changes of the key, or of all keys if
+// key is empty, from the oldest to the most recent.
+func (db *kvDB) changes(key string) []KVChange {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	changes := make([]KVChange, 0, len(db.history))
+	for _, c := range db.history {
+		if key == "" || c.Key == key {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// state returns the state of the store.
+// state must be called with db.mu held.
+func (db *kvDB) state() kvState {
+	state := kvState{
+		Version: db.version,
+		Entries: make([]KVEntry, 0, len(db.entries)),
+		History: db.history,
+	}
+	for _, e := range db.entries {
+		state.Entries = append(state.Entries, e)
+	}
+	sort.Slice(state.Entries, func(i, j int) bool {
+		return state.Entries[i].Key < state.Entries[j].Key
+	})
+	return state
+}
+
+// snapshot returns a copy of the state of the store.
+func (db *kvDB) snapshot() kvState {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	state := db.state()
+	state.History = append([]KVChange(nil), state.History...)
+	return state
+}
+
+// load replaces the content of the store with the provided state.
+func (db *kvDB) load(state kvState) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.version = state.Version
+	db.history = append([]KVChange(nil), state.History...)
+	db.entries = make(map[string]KVEntry, len(state.Entries))
+	for _, e := range state.Entries {
+		db.entries[e.Key] = e
+	}
+	return db.save()
+}
+
+// save persists the store.
+// save must be called with db.mu held.
+func (db *kvDB) save() error {
+	if db.fname == "" {
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(db.state(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode key-value store: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(db.fname), ".tdaq-kv-")
+	if err != nil {
+		return fmt.Errorf("could not create key-value store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(raw)
+	if err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("could not write key-value store file: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("could not close key-value store file: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), db.fname)
+	if err != nil {
+		return fmt.Errorf("could not save key-value store file: %w", err)
+	}
+	return nil
+}
+
+// KV returns the version of the key-value configuration store of run-ctl
+// and its entries under the provided prefix, sorted by key.
+func (rc *RunControl) KV(prefix string) (uint64, []KVEntry) {
+	return rc.kv.list(prefix)
+}
+
+// KVGet returns the entry of the key-value configuration store of run-ctl
+// for the provided key.
+func (rc *RunControl) KVGet(key string) (KVEntry, bool) {
+	return rc.kv.get(key)
+}
+
+// KVSet sets, on behalf of user, the value of the key in the key-value
+// configuration store of run-ctl, and returns the new version of the store.
+// TDAQ processes receive the new value at their next /config.
+func (rc *RunControl) KVSet(key, value, user string) (vers uint64, err error) {
+	if user == "" {
+		user = rc.cfg.Name
+	}
+	defer func(beg time.Time) {
+		rc.record(recKVSet, []string{key, value, user}, beg, err)
+		rc.audit(user, recKVSet, []string{key}, []string{value}, beg, err)
+	}(time.Now())
+
+	vers, err = rc.kv.set(key, value, user)
+	if err != nil {
+		return vers, fmt.Errorf("could not set key %q: %w", key, err)
+	}
+	rc.msg.Infof("key %q set by %q (version=%d)", key, user, vers)
+	return vers, nil
+}
+
+// KVDelete deletes, on behalf of user, the key from the key-value
+// configuration store of run-ctl, and returns the new version of the store.
+func (rc *RunControl) KVDelete(key, user string) (vers uint64, err error) {
+	if user == "" {
+		user = rc.cfg.Name
+	}
+	defer func(beg time.Time) {
+		rc.record(recKVDel, []string{key, user}, beg, err)
+		rc.audit(user, recKVDel, []string{key}, nil, beg, err)
+	}(time.Now())
+
+	vers, err = rc.kv.del(key, user)
+	if err != nil {
+		return vers, fmt.Errorf("could not delete key %q: %w", key, err)
+	}
+	rc.msg.Infof("key %q deleted by %q (version=%d)", key, user, vers)
+	return vers, nil
+}
+
+// KVHistory returns the history of the changes of the key in the key-value
+// configuration store of run-ctl, or of all keys if key is empty, from the
+// oldest to the most recent.
+func (rc *RunControl) KVHistory(key string) []KVChange {
+	return rc.kv.changes(key)
+}
+
+// KV returns the value of the key in the key-value configuration store of
+// run-ctl, relative to the name of the process, as received at /config.
+func (ctx Context) KV(key string) (string, bool) {
+	if ctx.kv == nil {
+		return "", false
+	}
+	return ctx.kv.get(key)
+}
+
+// kvcache holds the values of the key-value configuration store of run-ctl
+// received by a process at /config.
+type kvcache struct {
+	mu      sync.RWMutex
+	version uint64
+	values  map[string]string
+}
+
+// KVVersion returns the version of the key-value configuration store of
+// run-ctl received by the process at /config.
+func (ctx Context) KVVersion() uint64 {
+	if ctx.kv == nil {
+		return 0
+	}
+	ctx.kv.mu.RLock()
+	defer ctx.kv.mu.RUnlock()
+	return ctx.kv.version
+}
+
+func (kv *kvcache) update(version uint64, values map[string]string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.version = version
+	kv.values = values
+}
+
+func (kv *kvcache) get(key string) (string, bool) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	v, ok := kv.values[key]
+	return v, ok
+}
+
+// webAPIKV serves the key-value configuration store of run-ctl.
+// GET replies with a JSON report of the version of the store and of its
+// entries under the "prefix" form value.
+// POST sets the key of the "key" form value to the "value" form value and
+// DELETE deletes it, on behalf of the "user" form value.
+// Like control commands, writes are only allowed to the control session
+// holding the control token (see ControlToken).
+func (rc *RunControl) webAPIKV(w http.ResponseWriter, r *http.Request) {
+	var (
+		vers uint64
+		err  error
+		ctx  = webControl(r)
+		key  = r.FormValue("key")
+	)
+	switch r.Method {
+	case http.MethodGet:
+		vers, entries := rc.KV(r.FormValue("prefix"))
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(struct {
+			Version uint64    `json:"version"`
+			Entries []KVEntry `json:"entries"`
+		}{vers, entries})
+		if err != nil {
+			rc.msg.Errorf("could not encode key-value store: %+v", err)
+		}
+		return
+	case http.MethodPost:
+		value := r.FormValue("value")
+		err = rc.control(ctx, recKVSet, []string{key}, []string{value})
+		if err == nil {
+			vers, err = rc.KVSet(key, value, r.FormValue("user"))
+		}
+	case http.MethodDelete:
+		err = rc.control(ctx, recKVDel, []string{key}, nil)
+		if err == nil {
+			vers, err = rc.KVDelete(key, r.FormValue("user"))
+		}
+	default:
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	setWebControl(ctx, w, r)
+	if err != nil {
+		http.Error(w, err.Error(), webControlStatus(err, http.StatusBadRequest))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Version uint64 `json:"version"`
+	}{vers})
+	if err != nil {
+		rc.msg.Errorf("could not encode key-value store version: %+v", err)
+	}
+}
+
+// webAPIKVHistory replies with a JSON report of the history of the changes
+// of the key-value configuration store of run-ctl, restricted to the key of
+// the "key" form value, if any.
+func (rc *RunControl) webAPIKVHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(rc.KVHistory(r.FormValue("key")))
+	if err != nil {
+		rc.msg.Errorf("could not encode key-value store history: %+v", err)
+		return
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestKVStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdaq-kv-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		fname = filepath.Join(dir, "kv.json")
+		t0    = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+
+	db, err := newKVDB(fname, nil)
+	if err != nil {
+		t.Fatalf("could not create key-value store: %+v", err)
+	}
+	db.now = func() time.Time { return t0 }
+
+	rc := &RunControl{
+		msg:  log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard),
+		kv:   db,
+		feed: newFeed(),
+	}
+
+	for _, kv := range [][2]string{
+		{"adc/threshold", "10"},
+		{"adc/gain", "2"},
+		{"adc/threshold", "12"},
+		{"tdc/window", "25ns"},
+	} {
+		_, err := rc.KVSet(kv[0], kv[1], "bob")
+		if err != nil {
+			t.Fatalf("could not set %q: %+v", kv[0], err)
+		}
+	}
+	vers, err := rc.KVSet("adc/threshold", "12", "bob")
+	if err != nil {
+		t.Fatalf("could not set unchanged value: %+v", err)
+	}
+	if got, want := vers, uint64(4); got != want {
+		t.Fatalf("invalid version: got=%d, want=%d", got, want)
+	}
+
+	_, err = rc.KVSet("/adc", "1", "bob")
+	if err == nil {
+		t.Fatalf("expected an error for an invalid key")
+	}
+
+	vers, err = rc.KVDelete("tdc/window", "")
+	if err != nil {
+		t.Fatalf("could not delete key: %+v", err)
+	}
+	if got, want := vers, uint64(5); got != want {
+		t.Fatalf("invalid version: got=%d, want=%d", got, want)
+	}
+	_, err = rc.KVDelete("tdc/window", "")
+	if err == nil {
+		t.Fatalf("expected an error deleting a missing key")
+	}
+
+	want := []KVChange{
+		{Version: 1, Key: "adc/threshold", Value: "10", Time: t0, User: "bob"},
+		{Version: 3, Key: "adc/threshold", Value: "12", Old: "10", Time: t0, User: "bob"},
+	}
+	if got := rc.KVHistory("adc/threshold"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid history:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	// reload the store from its file.
+	db, err = newKVDB(fname, nil)
+	if err != nil {
+		t.Fatalf("could not reload key-value store: %+v", err)
+	}
+	rc.kv = db
+
+	vers, entries := rc.KV("adc/")
+	if got, want := vers, uint64(5); got != want {
+		t.Fatalf("invalid reloaded version: got=%d, want=%d", got, want)
+	}
+	if got, want := entries, []KVEntry{
+		{Key: "adc/gain", Value: "2", Version: 2, Time: t0, User: "bob"},
+		{Key: "adc/threshold", Value: "12", Version: 3, Time: t0, User: "bob"},
+	}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid entries:\ngot = %#v\nwant= %#v", got, want)
+	}
+	if got, want := len(rc.KVHistory("")), 5; got != want {
+		t.Fatalf("invalid reloaded history length: got=%d, want=%d", got, want)
+	}
+
+	vers, kv := db.scope("adc")
+	if got, want := kv, map[string]string{"gain": "2", "threshold": "12"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid process values:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	ctx := Context{kv: new(kvcache)}
+	ctx.kv.update(vers, kv)
+	if v, ok := ctx.KV("threshold"); !ok || v != "12" {
+		t.Fatalf("invalid process value: got=(%q, %v), want=(%q, true)", v, ok, "12")
+	}
+	if got, want := ctx.KVVersion(), uint64(5); got != want {
+		t.Fatalf("invalid process version: got=%d, want=%d", got, want)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(rc.webAPIKV))
+	defer srv.Close()
+
+	resp, err := http.PostForm(srv.URL, url.Values{"key": {"tdc/window"}, "value": {"30ns"}, "user": {"alice"}})
+	if err != nil {
+		t.Fatalf("could not set key via REST: %+v", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("invalid status code: got=%d, want=%d", got, want)
+	}
+	if e, ok := rc.KVGet("tdc/window"); !ok || e.Value != "30ns" || e.User != "alice" || e.Version != 6 {
+		t.Fatalf("invalid entry: %#v", e)
+	}
+
+	// the write took the free control token on behalf of alice.
+	if got, want := rc.Control().Operator, "alice"; got != want {
+		t.Fatalf("invalid control token holder: got=%q, want=%q", got, want)
+	}
+	var secret *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == controlCookie {
+			secret = c
+		}
+	}
+	if secret == nil || secret.Value == "" {
+		t.Fatalf("missing control cookie")
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, srv.URL+"?"+url.Values{"key": {"tdc/window"}, "user": {"alice"}}.Encode(), nil)
+	if err != nil {
+		t.Fatalf("could not create REST request: %+v", err)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not delete key via REST: %+v", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusConflict; got != want {
+		t.Fatalf("invalid status code for write without control token: got=%d, want=%d", got, want)
+	}
+	if _, ok := rc.KVGet("tdc/window"); !ok {
+		t.Fatalf("key deleted without control token")
+	}
+
+	req.AddCookie(secret)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not delete key via REST: %+v", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("invalid status code: got=%d, want=%d", got, want)
+	}
+	if _, ok := rc.KVGet("tdc/window"); ok {
+		t.Fatalf("key not deleted")
+	}
+}
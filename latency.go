@@ -0,0 +1,185 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// stampHdrSize is the size of the header of a stamped frame payload:
+//   - send time of the frame, in nanoseconds since the Unix epoch (i64)
+//   - type of the stamped frame (u8)
+const stampHdrSize = 8 + 1
+
+// stampFrame wraps the provided frame into a stamped frame, carrying the
+// provided send time.
+func stampFrame(frame Frame, now time.Time) Frame {
+	body := make([]byte, stampHdrSize+len(frame.Body))
+	binary.LittleEndian.PutUint64(body[0:8], uint64(now.UnixNano()))
+	body[8] = byte(frame.Type)
+	copy(body[stampHdrSize:], frame.Body)
+	return Frame{Type: FrameStamped, Path: frame.Path, Body: body}
+}
+
+// decodeStamped decodes the send time and the frame carried by the provided
+// stamped frame.
+func decodeStamped(frame Frame) (time.Time, Frame, error) {
+	if len(frame.Body) < stampHdrSize {
+		return time.Time{}, Frame{}, fmt.Errorf("invalid stamped frame size (got=%d, want>=%d)", len(frame.Body), stampHdrSize)
+	}
+	var (
+		sent = time.Unix(0, int64(binary.LittleEndian.Uint64(frame.Body[0:8])))
+		data = Frame{
+			Type: FrameType(frame.Body[8]),
+			Path: frame.Path,
+			Body: frame.Body[stampHdrSize:],
+		}
+	)
+	return sent, data, nil
+}
+
+// LatencyBins are the upper edges of the bins of the latency histograms
+// of input end-points.
+// Latencies larger than the last edge are accumulated in an overflow bin.
+var LatencyBins = [...]time.Duration{
+	10 * time.Microsecond,
+	20 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	200 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Latency is the histogram of the latencies of the data frames received
+// on an input end-point: the time the frames were dequeued for processing
+// minus the time they were sent by their producer.
+//
+// Latencies are measured across processes and are only meaningful if the
+// clocks of the producer and consumer hosts are synchronized (see the clock
+// offsets reported by run-ctl).
+type Latency struct {
+	Count  uint64        `json:"count"`  // number of measured data frames
+	Sum    time.Duration `json:"sum"`    // sum of the latencies
+	Max    time.Duration `json:"max"`    // maximum latency
+	Skewed uint64        `json:"skewed"` // number of data frames received before they were sent, according to the local clock
+	Bins   []uint64      `json:"bins"`   // number of data frames per bin of LatencyBins, plus overflow
+}
+
+// Mean returns the mean latency.
+func (lat Latency) Mean() time.Duration {
+	if lat.Count == 0 {
+		return 0
+	}
+	return lat.Sum / time.Duration(lat.Count)
+}
+
+// Quantile returns an upper bound of the q-quantile of the latencies:
+// the upper edge of the bin holding that quantile, or the maximum latency
+// for the overflow bin.
+func (lat Latency) Quantile(q float64) time.Duration {
+	if lat.Count == 0 {
+		return 0
+	}
+	var (
+		want = uint64(q*float64(lat.Count) + 0.5)
+		sum  uint64
+	)
+	if want < 1 {
+		want = 1
+	}
+	for i, n := range lat.Bins {
+		sum += n
+		if sum < want {
+			continue
+		}
+		if i < len(LatencyBins) && LatencyBins[i] < lat.Max {
+			return LatencyBins[i]
+		}
+		break
+	}
+	return lat.Max
+}
+
+func (lat Latency) String() string {
+	return fmt.Sprintf(
+		"n=%d mean=%v p50<=%v p99<=%v max=%v",
+		lat.Count, lat.Mean(), lat.Quantile(0.5), lat.Quantile(0.99), lat.Max,
+	)
+}
+
+// latCounter collects the latency histogram of an input end-point.
+type latCounter struct {
+	count  uint64 // atomic
+	sum    int64  // atomic
+	max    int64  // atomic
+	skewed uint64 // atomic
+	bins   [len(LatencyBins) + 1]uint64
+}
+
+func (c *latCounter) add(dt time.Duration) {
+	if dt < 0 {
+		atomic.AddUint64(&c.skewed, 1)
+		dt = 0
+	}
+	atomic.AddUint64(&c.count, 1)
+	atomic.AddInt64(&c.sum, int64(dt))
+	for {
+		max := atomic.LoadInt64(&c.max)
+		if int64(dt) <= max || atomic.CompareAndSwapInt64(&c.max, max, int64(dt)) {
+			break
+		}
+	}
+
+	i := 0
+	for i < len(LatencyBins) && dt > LatencyBins[i] {
+		i++
+	}
+	atomic.AddUint64(&c.bins[i], 1)
+}
+
+func (c *latCounter) reset() {
+	atomic.StoreUint64(&c.count, 0)
+	atomic.StoreInt64(&c.sum, 0)
+	atomic.StoreInt64(&c.max, 0)
+	atomic.StoreUint64(&c.skewed, 0)
+	for i := range c.bins {
+		atomic.StoreUint64(&c.bins[i], 0)
+	}
+}
+
+// snapshot returns the latency histogram, or nil if no latency was measured.
+func (c *latCounter) snapshot() *Latency {
+	n := atomic.LoadUint64(&c.count)
+	if n == 0 {
+		return nil
+	}
+	lat := &Latency{
+		Count:  n,
+		Sum:    time.Duration(atomic.LoadInt64(&c.sum)),
+		Max:    time.Duration(atomic.LoadInt64(&c.max)),
+		Skewed: atomic.LoadUint64(&c.skewed),
+		Bins:   make([]uint64, len(c.bins)),
+	}
+	for i := range c.bins {
+		lat.Bins[i] = atomic.LoadUint64(&c.bins[i])
+	}
+	return lat
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestLatency(t *testing.T) {
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("latency", log.LvlError, ioutil.Discard),
+	}
+
+	var (
+		got []Frame
+		hs  = map[string]InputHandler{
+			"/adc": func(ctx Context, src Frame) error {
+				got = append(got, src)
+				return nil
+			},
+		}
+		st   = newRunStats()
+		now  = time.Now()
+		data = Frame{Type: FrameData, Path: "/adc", Body: []byte("0123456789")}
+	)
+
+	var frames []Frame
+	for _, sent := range []time.Time{now.Add(-time.Hour), now.Add(time.Hour)} {
+		frames = append(frames, stampFrame(data, sent))
+	}
+	for _, msg := range chunkFrame(stampFrame(data, now.Add(-time.Hour)), 4, 1) {
+		chunk, err := RecvFrame(ctx.Ctx, rawRecver(msg))
+		if err != nil {
+			t.Fatalf("could not decode chunk: %+v", err)
+		}
+		frames = append(frames, chunk)
+	}
+	frames = append(frames, Frame{Type: FrameStamped, Path: "/adc", Body: []byte("bad")})
+
+	mux := newDemux(ctx, []string{"/adc"}, hs, nil, func(string) int { return 1 }, 0, nil, nil, nil, st)
+	for _, frame := range frames {
+		mux.dispatch(ctx, frame)
+	}
+	mux.dispatch(ctx, Frame{Type: FrameEOF})
+	mux.close()
+
+	if want := []Frame{data, data, data}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid unstamped frames:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+
+	stats := st.snapshot()
+	if len(stats.Inputs) != 1 || stats.Inputs[0].Latency == nil {
+		t.Fatalf("missing latency histogram: %+v", stats.Inputs)
+	}
+	lat := stats.Inputs[0].Latency
+	if got, want := lat.Count, uint64(3); got != want {
+		t.Fatalf("invalid number of measured frames: got=%d, want=%d", got, want)
+	}
+	if got, want := lat.Skewed, uint64(1); got != want {
+		t.Fatalf("invalid number of skewed frames: got=%d, want=%d", got, want)
+	}
+	if got, want := lat.Bins, []uint64{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid latency histogram:\ngot = %v\nwant= %v", got, want)
+	}
+	if lat.Max < time.Hour || lat.Quantile(1) != lat.Max || lat.Quantile(0.1) != LatencyBins[0] {
+		t.Fatalf("invalid latency quantiles: %v", lat)
+	}
+
+	st.reset()
+	if lat := st.snapshot().Inputs[0].Latency; lat != nil {
+		t.Fatalf("latency histogram not reset: %v", lat)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+	"go.nanomsg.org/mangos/v3"
+)
+
+const (
+	// DefaultReconnectTime is the default initial delay before redialing
+	// a dropped data link.
+	DefaultReconnectTime = 100 * time.Millisecond
+
+	// DefaultMaxReconnectTime is the default maximum delay between two
+	// attempts at redialing a dropped data link.
+	// The delay between attempts is doubled after each failed attempt,
+	// up to that maximum.
+	DefaultMaxReconnectTime = 10 * time.Second
+
+	// DefaultStallTimeout is the default duration without any data frame
+	// received after which a running data link is reported as stalled.
+	DefaultStallTimeout = 30 * time.Second
+)
+
+// LinkStatus describes the status of an incoming data link, ie: the
+// connection of input end-points to the output port of a TDAQ process.
+type LinkStatus struct {
+	Addr      string    // address of the output port
+	EndPoints []string  // names of the input end-points served by the link
+	Up        bool      // whether the link is connected
+	Outages   uint32    // number of times the link dropped while running
+	Since     time.Time // time of the last connection or disconnection
+
+	Frames    uint64    // number of data frames received on the link
+	Bytes     uint64    // number of payload bytes received on the link
+	LastFrame time.Time // time of the last data frame received (zero if none)
+	Queued    uint32    // number of data frames waiting to be processed
+	Capacity  uint32    // maximum number of data frames waiting to be processed
+	Stalled   bool      // whether no data frame was received for longer than the stall timeout
+}
+
+// link tracks the status of an incoming data link.
+//
+// Dropped links are redialed by the underlying socket, with an exponential
+// backoff. Chunked frames interrupted by an outage are discarded by the
+// input end-points, which then resume with the next complete frame.
+//
+// Stalled links are detected when the status of the link is polled,
+// ie: at each heartbeat of the run-ctl.
+type link struct {
+	frames uint64 // number of received data frames (atomic)
+	bytes  uint64 // number of received payload bytes (atomic)
+	last   int64  // time of the last received data frame, in ns since epoch (atomic)
+	queued int64  // number of queued data frames (atomic)
+	cap    int64  // capacity of the queues of data frames (atomic)
+
+	msg   log.MsgStream
+	stall time.Duration    // stall timeout
+	now   func() time.Time // current time
+
+	mu      sync.Mutex
+	st      LinkStatus
+	start   time.Time // start of the current run
+	running bool      // whether data is expected to flow on the link
+	closed  bool      // whether the link has been closed on purpose
+}
+
+func newLink(msg log.MsgStream, addr string, eps []string, stall time.Duration, now func() time.Time) *link {
+	if stall == 0 {
+		stall = DefaultStallTimeout
+	}
+	return &link{
+		msg:   msg,
+		stall: stall,
+		now:   now,
+		st: LinkStatus{
+			Addr:      addr,
+			EndPoints: eps,
+			Since:     now().UTC(),
+		},
+	}
+}
+
+// hook is the pipe event hook of the socket of the link.
+func (l *link) hook(evt mangos.PipeEvent, p mangos.Pipe) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	switch evt {
+	case mangos.PipeEventAttached:
+		if l.st.Up {
+			return
+		}
+		l.st.Up = true
+		l.st.Since = l.now().UTC()
+		if l.st.Outages > 0 {
+			l.msg.Infof("data link to %q for %v re-established", l.st.Addr, l.st.EndPoints)
+		}
+
+	case mangos.PipeEventDetached:
+		if !l.st.Up {
+			return
+		}
+		l.st.Up = false
+		l.st.Since = l.now().UTC()
+		if !l.running {
+			return
+		}
+		l.st.Outages++
+		l.msg.Warnf("data link to %q for %v dropped, reconnecting...", l.st.Addr, l.st.EndPoints)
+	}
+}
+
+func (l *link) setRunning(v bool) {
+	l.mu.Lock()
+	l.running = v
+	l.start = l.now()
+	l.st.Stalled = false
+	atomic.StoreInt64(&l.queued, 0)
+	l.mu.Unlock()
+}
+
+// recv records the reception of the provided data frame.
+func (l *link) recv(frame Frame) {
+	atomic.AddUint64(&l.frames, 1)
+	atomic.AddUint64(&l.bytes, uint64(len(frame.Body)))
+	atomic.StoreInt64(&l.last, l.now().UnixNano())
+}
+
+// setCapacity sets the total capacity of the queues of the input
+// end-points of the link.
+func (l *link) setCapacity(n int) {
+	atomic.StoreInt64(&l.cap, int64(n))
+}
+
+// enqueue records the addition (n>0) or removal (n<0) of data frames
+// to the queues of the input end-points of the link.
+func (l *link) enqueue(n int64) {
+	atomic.AddInt64(&l.queued, n)
+}
+
+// close marks the link as closed on purpose, so its disconnection is
+// not reported as an outage.
+func (l *link) close() {
+	l.mu.Lock()
+	l.closed = true
+	l.st.Up = false
+	l.mu.Unlock()
+}
+
+func (l *link) status() LinkStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		now  = l.now()
+		last time.Time
+	)
+	if ns := atomic.LoadInt64(&l.last); ns != 0 {
+		last = time.Unix(0, ns).UTC()
+	}
+
+	stalled := false
+	if l.running && l.stall > 0 {
+		ref := l.start
+		if last.After(ref) {
+			ref = last
+		}
+		stalled = now.Sub(ref) > l.stall
+	}
+	if stalled && !l.st.Stalled {
+		l.msg.Warnf("data link to %q for %v stalled: no data frame received for %v", l.st.Addr, l.st.EndPoints, l.stall)
+	}
+	l.st.Stalled = stalled
+
+	st := l.st
+	st.EndPoints = append([]string(nil), l.st.EndPoints...)
+	st.Frames = atomic.LoadUint64(&l.frames)
+	st.Bytes = atomic.LoadUint64(&l.bytes)
+	st.LastFrame = last
+	if n := atomic.LoadInt64(&l.queued); n > 0 {
+		st.Queued = uint32(n)
+	}
+	st.Capacity = uint32(atomic.LoadInt64(&l.cap))
+	return st
+}
+
+const (
+	occHistLen    = 60   // number of queue occupancy samples kept per data link
+	slowSamples   = 3    // number of consecutive samples above threshold flagging a slow consumer
+	slowThreshold = 0.75 // queue occupancy above which a sample counts towards a slow consumer
+)
+
+// linkHist is the history of the queue occupancy of a data link,
+// sampled by run-ctl at each heartbeat.
+//
+// A consumer is deemed slow when the queues of one of its data links were
+// almost full for a few consecutive samples: it cannot keep up with the
+// rate of its producer.
+type linkHist struct {
+	occ  []uint32 // queue occupancy samples, oldest first
+	slow bool
+
+	last  LinkStatus // last sample of the link
+	at    time.Time  // time of the last sample
+	rate  float64    // rate of data frames, in frames/s
+	brate float64    // rate of payload bytes, in bytes/s
+}
+
+// add adds a sample of the queue occupancy of the provided link and
+// returns whether the slow status of the link changed.
+func (h *linkHist) add(link LinkStatus) bool {
+	h.occ = append(h.occ, link.Queued)
+	if n := len(h.occ); n > occHistLen {
+		h.occ = append(h.occ[:0], h.occ[n-occHistLen:]...)
+	}
+
+	slow := link.Capacity > 0 && len(h.occ) >= slowSamples
+	if slow {
+		limit := slowThreshold * float64(link.Capacity)
+		for _, v := range h.occ[len(h.occ)-slowSamples:] {
+			if float64(v) < limit {
+				slow = false
+				break
+			}
+		}
+	}
+
+	changed := slow != h.slow
+	h.slow = slow
+	return changed
+}
+
+// sample updates the data rates of the link from a new sample taken at now.
+func (h *linkHist) sample(now time.Time, link LinkStatus) {
+	if dt := now.Sub(h.at).Seconds(); !h.at.IsZero() && dt > 0 && link.Frames >= h.last.Frames {
+		h.rate = float64(link.Frames-h.last.Frames) / dt
+		h.brate = float64(link.Bytes-h.last.Bytes) / dt
+	}
+	h.last = link
+	h.at = now
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+	"go.nanomsg.org/mangos/v3"
+)
+
+func TestLink(t *testing.T) {
+	msg := log.NewMsgStream("link", log.LvlError, ioutil.Discard)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	lnk := newLink(msg, "tcp://127.0.0.1:4000", []string{"/adc"}, 0, func() time.Time { return now })
+
+	for _, tt := range []struct {
+		name    string
+		evt     mangos.PipeEvent
+		running bool
+		up      bool
+		outages uint32
+	}{
+		{"attach", mangos.PipeEventAttached, false, true, 0},
+		{"detach-idle", mangos.PipeEventDetached, false, false, 0},
+		{"reattach", mangos.PipeEventAttached, true, true, 0},
+		{"detach-running", mangos.PipeEventDetached, true, false, 1},
+		{"detach-again", mangos.PipeEventDetached, true, false, 1},
+		{"reattach-running", mangos.PipeEventAttached, true, true, 1},
+		{"detach-running-2", mangos.PipeEventDetached, true, false, 2},
+	} {
+		lnk.setRunning(tt.running)
+		lnk.hook(tt.evt, nil)
+		st := lnk.status()
+		if st.Up != tt.up || st.Outages != tt.outages {
+			t.Fatalf("%s: invalid link status: up=%v, outages=%d (want up=%v, outages=%d)",
+				tt.name, st.Up, st.Outages, tt.up, tt.outages,
+			)
+		}
+	}
+
+	lnk.stall = 50 * time.Millisecond
+	lnk.setRunning(true)
+	now = now.Add(100 * time.Millisecond)
+	if st := lnk.status(); !st.Stalled {
+		t.Fatalf("link should be stalled")
+	}
+	lnk.recv(Frame{Body: []byte("data")})
+	if st := lnk.status(); st.Stalled || st.Frames != 1 || st.Bytes != 4 {
+		t.Fatalf("invalid link status: stalled=%v, frames=%d, bytes=%d", st.Stalled, st.Frames, st.Bytes)
+	}
+	lnk.setRunning(false)
+	now = now.Add(100 * time.Millisecond)
+	if st := lnk.status(); st.Stalled {
+		t.Fatalf("idle link should not be stalled")
+	}
+
+	lnk.hook(mangos.PipeEventAttached, nil)
+	lnk.close()
+	lnk.hook(mangos.PipeEventDetached, nil)
+	if st := lnk.status(); st.Up || st.Outages != 2 {
+		t.Fatalf("closed link should not report outages: up=%v, outages=%d", st.Up, st.Outages)
+	}
+}
+
+func TestLinkHist(t *testing.T) {
+	var h linkHist
+	for i, tt := range []struct {
+		queued  uint32
+		slow    bool
+		changed bool
+	}{
+		{10, false, false},
+		{60, false, false},
+		{60, false, false},
+		{50, true, true},
+		{64, true, false},
+		{10, false, true},
+		{64, false, false},
+	} {
+		changed := h.add(LinkStatus{Queued: tt.queued, Capacity: 64})
+		if changed != tt.changed || h.slow != tt.slow {
+			t.Fatalf("sample %d: invalid slow status: slow=%v, changed=%v (want slow=%v, changed=%v)",
+				i, h.slow, changed, tt.slow, tt.changed,
+			)
+		}
+	}
+
+	for i := 0; i < 2*occHistLen; i++ {
+		h.add(LinkStatus{Queued: uint32(i), Capacity: 64})
+	}
+	if got, want := len(h.occ), occHistLen; got != want {
+		t.Fatalf("invalid history length: got=%d, want=%d", got, want)
+	}
+	if got, want := h.occ[len(h.occ)-1], uint32(2*occHistLen-1); got != want {
+		t.Fatalf("invalid last sample: got=%d, want=%d", got, want)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -52,6 +53,28 @@ func (lvl Level) String() string {
 	panic(fmt.Errorf("log: invalid log.Level value [%d]", int(lvl)))
 }
 
+// ParseLevel parses the human-readable representation of a Level value
+// ("dbg", "debug", "info", "warn", "err", ...) or its integer value.
+func ParseLevel(lvl string) (Level, error) {
+	lvl = strings.ToLower(lvl)
+	switch {
+	case strings.HasPrefix(lvl, "dbg"), strings.HasPrefix(lvl, "debug"):
+		return LvlDebug, nil
+	case strings.HasPrefix(lvl, "info"):
+		return LvlInfo, nil
+	case strings.HasPrefix(lvl, "warn"):
+		return LvlWarning, nil
+	case strings.HasPrefix(lvl, "err"):
+		return LvlError, nil
+	default:
+		v, err := strconv.Atoi(lvl)
+		if err != nil {
+			return 0, fmt.Errorf("unknown level value %q: %+v", lvl, err)
+		}
+		return Level(v), nil
+	}
+}
+
 // MsgStream provides access to verbosity-defined formated messages, a la fmt.Printf.
 type MsgStream interface {
 	Debugf(format string, a ...interface{})
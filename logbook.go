@@ -0,0 +1,173 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+// logbookTimeout is the maximum duration allowed to post an entry to an
+// electronic logbook.
+const logbookTimeout = 30 * time.Second
+
+// Logbook is an electronic logbook run-ctl posts entries to.
+//
+// Entries are posted asynchronously: a slow or unavailable logbook never
+// delays the FSM transitions of run-ctl.
+type Logbook interface {
+	Post(ctx context.Context, entry LogEntry) error
+}
+
+// LogKind describes the kind of a logbook entry.
+type LogKind uint8
+
+const (
+	LogRunStart LogKind = iota // start of a run
+	LogRunStop                 // stop of a run
+	LogComment                 // comment of an operator
+)
+
+func (k LogKind) String() string {
+	switch k {
+	case LogRunStart:
+		return "run-start"
+	case LogRunStop:
+		return "run-stop"
+	case LogComment:
+		return "comment"
+	default:
+		return fmt.Sprintf("LogKind(%d)", uint8(k))
+	}
+}
+
+func (k LogKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *LogKind) UnmarshalText(p []byte) error {
+	for _, v := range []LogKind{LogRunStart, LogRunStop, LogComment} {
+		if string(p) == v.String() {
+			*k = v
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid logbook entry kind %q", p)
+}
+
+// LogEntry is an entry of an electronic logbook.
+type LogEntry struct {
+	Kind    LogKind     `json:"kind"`              // kind of entry
+	Run     uint64      `json:"run"`               // run number
+	Time    time.Time   `json:"time"`              // time of the entry
+	Author  string      `json:"author"`            // author of the entry
+	Subject string      `json:"subject"`           // one-line subject of the entry
+	Text    string      `json:"text"`              // body of the entry
+	Summary *RunSummary `json:"summary,omitempty"` // summary of the run (run-stop entries only)
+}
+
+// logbooks posts entries to a set of electronic logbooks.
+type logbooks struct {
+	mu    sync.RWMutex
+	books []Logbook
+	wg    sync.WaitGroup
+}
+
+func (lbs *logbooks) add(lb Logbook) {
+	lbs.mu.Lock()
+	defer lbs.mu.Unlock()
+	lbs.books = append(lbs.books, lb)
+}
+
+// post posts the entry to all logbooks, in the background.
+func (lbs *logbooks) post(msg log.MsgStream, entry LogEntry) {
+	lbs.mu.RLock()
+	defer lbs.mu.RUnlock()
+
+	for _, lb := range lbs.books {
+		lb := lb
+		lbs.wg.Add(1)
+		go func() {
+			defer lbs.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), logbookTimeout)
+			defer cancel()
+			err := lb.Post(ctx, entry)
+			if err != nil {
+				msg.Errorf("could not post %v entry to logbook: %+v", entry.Kind, err)
+			}
+		}()
+	}
+}
+
+// wait waits for all pending entries to be posted.
+func (lbs *logbooks) wait() {
+	lbs.wg.Wait()
+}
+
+// AddLogbook registers an electronic logbook run-ctl posts run start/stop
+// entries and operator comments to.
+func (rc *RunControl) AddLogbook(lb Logbook) {
+	rc.elog.add(lb)
+}
+
+// Comment posts an operator comment about the current run to the
+// electronic logbooks.
+func (rc *RunControl) Comment(author, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("empty logbook comment")
+	}
+	if author == "" {
+		author = rc.cfg.Name
+	}
+
+	rc.mu.RLock()
+	run := rc.runNbr
+	rc.mu.RUnlock()
+
+	rc.elog.post(rc.msg, LogEntry{
+		Kind:    LogComment,
+		Run:     run,
+		Time:    rc.now().UTC(),
+		Author:  author,
+		Subject: fmt.Sprintf("comment on run %d", run),
+		Text:    text,
+	})
+	return nil
+}
+
+func (rc *RunControl) logRunStart() {
+	rc.elog.post(rc.msg, LogEntry{
+		Kind:    LogRunStart,
+		Run:     rc.runNbr,
+		Time:    rc.runStart,
+		Author:  rc.cfg.Name,
+		Subject: fmt.Sprintf("run %d started", rc.runNbr),
+		Text:    fmt.Sprintf("run %d started at %s with %d processes.", rc.runNbr, rc.runStart.Format(time.RFC3339), len(rc.deps)),
+	})
+}
+
+func (rc *RunControl) logRunStop(sum RunSummary) {
+	text := new(strings.Builder)
+	err := sum.WriteText(text)
+	if err != nil {
+		rc.msg.Warnf("could not format run summary: %+v", err)
+	}
+
+	rc.elog.post(rc.msg, LogEntry{
+		Kind:    LogRunStop,
+		Run:     sum.Run,
+		Time:    sum.Stop,
+		Author:  rc.cfg.Name,
+		Subject: fmt.Sprintf("run %d stopped", sum.Run),
+		Text:    text.String(),
+		Summary: &sum,
+	})
+}
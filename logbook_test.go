@@ -0,0 +1,80 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/log"
+)
+
+type testLogbook struct {
+	entries chan LogEntry
+}
+
+func (lb *testLogbook) Post(ctx context.Context, entry LogEntry) error {
+	lb.entries <- entry
+	return nil
+}
+
+func TestLogbook(t *testing.T) {
+	lb := &testLogbook{entries: make(chan LogEntry, 3)}
+	rc := &RunControl{
+		cfg:    config.RunCtl{Name: "run-ctl"},
+		msg:    log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard),
+		runNbr: 42,
+	}
+	rc.AddLogbook(lb)
+
+	rc.runStart = time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	rc.logRunStart()
+
+	err := rc.Comment("bob", "  beam lost ")
+	if err != nil {
+		t.Fatalf("could not post comment: %+v", err)
+	}
+
+	err = rc.Comment("bob", "")
+	if err == nil {
+		t.Fatalf("expected an error for an empty comment")
+	}
+
+	sum := RunSummary{
+		Run:   42,
+		Start: rc.runStart,
+		Stop:  rc.runStart.Add(time.Minute),
+		Procs: []ProcSummary{{Name: "gen", Status: "stopped"}},
+	}
+	rc.logRunStop(sum)
+	rc.elog.wait()
+	close(lb.entries)
+
+	got := make(map[LogKind]LogEntry)
+	for entry := range lb.entries {
+		got[entry.Kind] = entry
+	}
+
+	if got, want := got[LogRunStart].Subject, "run 42 started"; got != want {
+		t.Fatalf("invalid run-start subject: got=%q, want=%q", got, want)
+	}
+
+	if got, want := got[LogComment], (LogEntry{Kind: LogComment, Run: 42, Author: "bob", Subject: "comment on run 42", Text: "beam lost"}); got.Author != want.Author || got.Subject != want.Subject || got.Text != want.Text {
+		t.Fatalf("invalid comment:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	stop := got[LogRunStop]
+	if stop.Summary == nil || !reflect.DeepEqual(*stop.Summary, sum) {
+		t.Fatalf("invalid run-stop summary:\ngot = %#v\nwant= %#v", stop.Summary, sum)
+	}
+	if !strings.Contains(stop.Text, "duration:  1m0s") {
+		t.Fatalf("invalid run-stop text:\n%s", stop.Text)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+// logTailSize is the number of recent log messages kept by run-ctl for
+// each TDAQ process.
+const logTailSize = 256
+
+// logEntry is a log message of a TDAQ process, as received by run-ctl.
+type logEntry struct {
+	Name      string    `json:"name"`
+	Level     string    `json:"level"`
+	Msg       string    `json:"msg"`
+	Timestamp string    `json:"timestamp"`
+	lvl       log.Level // verbosity level of the message
+}
+
+func newLogEntry(msg MsgFrame) logEntry {
+	return logEntry{
+		Name:      msg.Name,
+		Level:     msg.Level.String(),
+		Msg:       msg.Msg,
+		Timestamp: utcNow(),
+		lvl:       msg.Level,
+	}
+}
+
+// logTail keeps the recent log messages of each TDAQ process, and forwards
+// the live ones to the subscribers of a process.
+// Slow subscribers miss messages rather than blocking the log server.
+type logTail struct {
+	mu    sync.Mutex
+	procs map[string][]logEntry // recent messages, by process, oldest first
+	subs  map[chan logEntry]logSub
+}
+
+// logSub describes the messages a subscriber is interested in.
+type logSub struct {
+	name string
+	lvl  log.Level
+}
+
+func newLogTail() *logTail {
+	return &logTail{
+		procs: make(map[string][]logEntry),
+		subs:  make(map[chan logEntry]logSub),
+	}
+}
+
+func (lt *logTail) add(e logEntry) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	msgs := append(lt.procs[e.Name], e)
+	if n := len(msgs); n > logTailSize {
+		msgs = append(msgs[:0], msgs[n-logTailSize:]...)
+	}
+	lt.procs[e.Name] = msgs
+
+	for ch, sub := range lt.subs {
+		if sub.name != e.Name || e.lvl < sub.lvl {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			// subscriber is too slow. drop message.
+		}
+	}
+}
+
+// recent returns the recent messages of the named process, at or above
+// the provided verbosity level.
+// recent must be called with lt.mu held.
+func (lt *logTail) recent(name string, lvl log.Level) []logEntry {
+	var msgs []logEntry
+	for _, e := range lt.procs[name] {
+		if e.lvl >= lvl {
+			msgs = append(msgs, e)
+		}
+	}
+	return msgs
+}
+
+// tail returns the recent messages of the named process at or above the
+// provided verbosity level.
+func (lt *logTail) tail(name string, lvl log.Level) []logEntry {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	return lt.recent(name, lvl)
+}
+
+// sub returns the recent messages of the named process at or above the
+// provided verbosity level, and a channel receiving the live ones.
+// No message is missed nor duplicated between the two.
+func (lt *logTail) sub(name string, lvl log.Level) ([]logEntry, chan logEntry) {
+	ch := make(chan logEntry, logTailSize)
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.subs[ch] = logSub{name: name, lvl: lvl}
+	return lt.recent(name, lvl), ch
+}
+
+func (lt *logTail) unsub(ch chan logEntry) {
+	lt.mu.Lock()
+	delete(lt.subs, ch)
+	lt.mu.Unlock()
+}
+
+// known returns whether the named process is connected to run-ctl or has
+// sent log messages to run-ctl.
+func (rc *RunControl) known(name string) bool {
+	if rc.clients.get(name) != nil {
+		return true
+	}
+	rc.logs.mu.Lock()
+	defer rc.logs.mu.Unlock()
+	_, ok := rc.logs.procs[name]
+	return ok
+}
+
+// TailLog writes the recent log messages of the named TDAQ process, at or
+// above the provided verbosity level, to w.
+// If follow is true, TailLog then writes the live log messages of the
+// process until ctx is done or run-ctl quits.
+// Messages are filtered by run-ctl, as they are received from the process.
+func (rc *RunControl) TailLog(ctx context.Context, w io.Writer, name string, lvl log.Level, follow bool) error {
+	return rc.tailLog(ctx, name, lvl, follow, func(e logEntry) error {
+		_, err := io.WriteString(w, e.Msg)
+		return err
+	})
+}
+
+func (rc *RunControl) tailLog(ctx context.Context, name string, lvl log.Level, follow bool, f func(e logEntry) error) error {
+	if !rc.known(name) {
+		return fmt.Errorf("tdaq: unknown process %q", name)
+	}
+
+	if !follow {
+		for _, e := range rc.logs.tail(name, lvl) {
+			err := f(e)
+			if err != nil {
+				return fmt.Errorf("could not write log message of %q: %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	msgs, ch := rc.logs.sub(name, lvl)
+	defer rc.logs.unsub(ch)
+
+	for _, e := range msgs {
+		err := f(e)
+		if err != nil {
+			return fmt.Errorf("could not write log message of %q: %w", name, err)
+		}
+	}
+
+	for {
+		select {
+		case <-rc.quit:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case e := <-ch:
+			err := f(e)
+			if err != nil {
+				return fmt.Errorf("could not write log message of %q: %w", name, err)
+			}
+		}
+	}
+}
+
+// webAPILog replies with the recent log messages of the process named by the
+// "proc" form value, at or above the "level" form value (default: debug),
+// as a stream of JSON objects.
+// If the "follow" form value is set, the live log messages of the process
+// are then streamed until the client disconnects.
+func (rc *RunControl) webAPILog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var (
+		name   = r.FormValue("proc")
+		lvl    = log.LvlDebug
+		follow = r.FormValue("follow") != ""
+	)
+	if name == "" {
+		http.Error(w, "missing process name", http.StatusBadRequest)
+		return
+	}
+	if v := r.FormValue("level"); v != "" {
+		var err error
+		lvl, err = log.ParseLevel(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !rc.known(name) {
+		http.Error(w, fmt.Sprintf("unknown process %q", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	enc := json.NewEncoder(w)
+	err := rc.tailLog(r.Context(), name, lvl, follow, func(e logEntry) error {
+		err := enc.Encode(e)
+		if err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		rc.msg.Errorf("could not stream log messages of %q: %+v", name, err)
+		return
+	}
+}
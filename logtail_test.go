@@ -0,0 +1,100 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestLogTail(t *testing.T) {
+	rc := &RunControl{
+		quit:    make(chan struct{}),
+		clients: newClientDB(),
+		logs:    newLogTail(),
+	}
+
+	for i := 0; i < logTailSize+10; i++ {
+		lvl := log.LvlDebug
+		if i%2 == 0 {
+			lvl = log.LvlWarning
+		}
+		rc.logs.add(newLogEntry(MsgFrame{Name: "proc", Level: lvl, Msg: fmt.Sprintf("msg-%03d\n", i)}))
+	}
+	rc.logs.add(newLogEntry(MsgFrame{Name: "other", Level: log.LvlError, Msg: "other\n"}))
+
+	if got, want := len(rc.logs.tail("proc", log.LvlDebug)), logTailSize; got != want {
+		t.Fatalf("invalid number of recent messages: got=%d, want=%d", got, want)
+	}
+
+	buf := new(bytes.Buffer)
+	err := rc.TailLog(context.Background(), buf, "proc", log.LvlWarning, false)
+	if err != nil {
+		t.Fatalf("could not tail log: %+v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if got, want := len(lines), logTailSize/2; got != want {
+		t.Fatalf("invalid number of warning messages: got=%d, want=%d", got, want)
+	}
+	if got, want := lines[0], "msg-010"; got != want {
+		t.Fatalf("invalid oldest message: got=%q, want=%q", got, want)
+	}
+
+	err = rc.TailLog(context.Background(), buf, "unknown", log.LvlDebug, false)
+	if err == nil {
+		t.Fatalf("expected an error for an unknown process")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu   sync.Mutex
+		live []string
+		done = make(chan error)
+	)
+	go func() {
+		done <- rc.tailLog(ctx, "other", log.LvlInfo, true, func(e logEntry) error {
+			mu.Lock()
+			defer mu.Unlock()
+			live = append(live, e.Msg)
+			if len(live) == 3 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+
+	for {
+		rc.logs.mu.Lock()
+		n := len(rc.logs.subs)
+		rc.logs.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rc.logs.add(newLogEntry(MsgFrame{Name: "other", Level: log.LvlDebug, Msg: "filtered\n"}))
+	rc.logs.add(newLogEntry(MsgFrame{Name: "proc", Level: log.LvlError, Msg: "proc\n"}))
+	rc.logs.add(newLogEntry(MsgFrame{Name: "other", Level: log.LvlInfo, Msg: "live-1\n"}))
+	rc.logs.add(newLogEntry(MsgFrame{Name: "other", Level: log.LvlWarning, Msg: "live-2\n"}))
+
+	err = <-done
+	if err != nil {
+		t.Fatalf("could not follow log: %+v", err)
+	}
+
+	if got, want := live, []string{"other\n", "live-1\n", "live-2\n"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid followed messages:\ngot= %q\nwant=%q", got, want)
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultStreamQueueLen is the default number of data frames buffered for
+// each input end-point of a multiplexed connection.
+const defaultStreamQueueLen = 64
+
+const (
+	// busyPollSpin is the maximum duration a stream in low-latency mode
+	// spins on its empty queue, before sleeping until the next frame.
+	busyPollSpin = 10 * time.Millisecond
+
+	// busyPollCheck is the number of spins between checks of the spin
+	// duration.
+	busyPollCheck = 1024
+)
+
+// istream is an input end-point served over a (possibly multiplexed)
+// data connection.
+//
+// Each stream processes its data frames in its own goroutine, from a
+// bounded queue: a slow stream only exerts back-pressure on the shared
+// connection once its queue is full.
+type istream struct {
+	name string
+	h    InputHandler
+	q    chan Frame
+	lnk  *link     // data link of the stream (may be nil)
+	ack  *acker    // acknowledgement of data frames, in acknowledged mode (may be nil)
+	crd  *crediter // credit-based flow control (may be nil)
+	st   *runStats // run counters of the process (may be nil)
+	cnt  *epCounter
+	wks  inputWorkers // workers processing the data frames (n<=1: the stream itself)
+	ro   *reorder     // reordering stage of the data frames (may be nil)
+	max  int          // maximum size of reassembled chunked data frames (0: default)
+
+	holds chan struct{} // signaled when the producer paused and all the received data frames were processed
+	done  chan struct{} // closed when the stream is terminated
+
+	qbytes  int64 // number of queued payload bytes (atomic)
+	used    int   // number of data frames processed since the last credit grant
+	pending int   // number of acked data frames submitted to the workers and not yet acknowledged
+}
+
+func (s *istream) run(ctx Context) {
+	defer func() {
+		err := s.ack.end()
+		if err != nil {
+			ctx.Msg.Warnf("%+v", err)
+		}
+	}()
+
+	if s.wks.busy {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	var pool *ipool
+	if s.wks.n > 1 {
+		pool = newIPool(ctx, s.wks, s.process)
+		defer pool.close()
+	}
+
+	ts := ctx.timeSource()
+
+	var refresh <-chan time.Time
+	if s.crd != nil {
+		tck := ts.NewTicker(creditRefresh)
+		defer tck.Stop()
+		refresh = tck.C()
+		s.grant(ctx)
+	}
+
+	var expire <-chan time.Time
+	if s.ro != nil && s.wks.rdelay > 0 {
+		tck := ts.NewTicker(s.wks.rdelay / 2)
+		defer tck.Stop()
+		expire = tck.C()
+	}
+
+	deliver := func(frame Frame, acked bool) {
+		s.deliver(ctx, pool, frame, acked)
+	}
+
+	chunks := reassembler{max: s.max}
+	for {
+		if s.wks.busy {
+			s.spin()
+		}
+		select {
+		case <-ctx.Ctx.Done():
+			return
+		case <-refresh:
+			s.grant(ctx)
+		case now := <-expire:
+			s.ro.expire(ctx, now.Add(-s.wks.rdelay), deliver)
+		case frame, ok := <-s.q:
+			if !ok {
+				if s.ro != nil {
+					s.ro.flush(ctx, deliver)
+				}
+				return
+			}
+			if frame.Type == FrameHold {
+				if pool != nil {
+					pool.wait()
+					s.ackPool(ctx, pool)
+				}
+				s.hold()
+				continue
+			}
+			if s.lnk != nil {
+				s.lnk.enqueue(-1)
+			}
+			atomic.AddInt64(&s.qbytes, -int64(len(frame.Body)))
+			if s.crd != nil {
+				s.used++
+				if s.used >= (cap(s.q)+1)/2 {
+					s.grant(ctx)
+				}
+			}
+
+			if frame.Type == FrameChunk {
+				full, ok, err := chunks.add(frame)
+				if err != nil {
+					ctx.Msg.Warnf("could not reassemble data frame for %q: %+v", s.name, err)
+				}
+				if !ok {
+					continue
+				}
+				frame = full
+			}
+
+			if frame.Type == FrameStamped {
+				sent, data, err := decodeStamped(frame)
+				if err != nil {
+					ctx.Msg.Warnf("could not receive stamped data frame for %q: %+v", s.name, err)
+					continue
+				}
+				s.cnt.latency(time.Since(sent))
+				frame = data
+			}
+
+			if frame.Type == FrameEvent {
+				data, err := decodeEvent(frame)
+				if err != nil {
+					ctx.Msg.Warnf("could not receive event data frame for %q: %+v", s.name, err)
+					continue
+				}
+				frame = data
+			}
+
+			acked := frame.Type == FrameAcked
+			if acked {
+				data, ok, err := s.ack.recv(frame)
+				if err != nil {
+					ctx.Msg.Warnf("could not receive acked data frame for %q: %+v", s.name, err)
+				}
+				if !ok {
+					continue
+				}
+				data.EventID = frame.EventID
+				data.Trace = frame.Trace
+				frame = data
+			}
+
+			if s.wks.dups.dup(frame) {
+				// duplicates are dropped, but still acknowledged.
+				s.cnt.dup()
+				s.acked(ctx, pool, acked)
+				continue
+			}
+
+			if s.ro != nil {
+				if !s.ro.push(ctx, frame, acked, deliver) {
+					ctx.Msg.Debugf("dropped late data frame for %q", s.name)
+					s.st.drop()
+					s.acked(ctx, pool, acked)
+				}
+				continue
+			}
+
+			s.deliver(ctx, pool, frame, acked)
+		}
+	}
+}
+
+// deliver processes a data frame, with the workers of the stream if any.
+func (s *istream) deliver(ctx Context, pool *ipool, frame Frame, acked bool) {
+	if pool != nil {
+		pool.submit(frame)
+		s.acked(ctx, pool, acked)
+		return
+	}
+
+	s.process(ctx, frame)
+	s.acked(ctx, pool, acked)
+}
+
+// acked records that an acked data frame was processed (or dropped).
+func (s *istream) acked(ctx Context, pool *ipool, acked bool) {
+	switch {
+	case !acked:
+	case pool != nil:
+		s.pending++
+		s.ackPool(ctx, pool)
+	default:
+		err := s.ack.done(len(s.q) == 0)
+		if err != nil {
+			ctx.Msg.Warnf("%+v", err)
+		}
+	}
+}
+
+// spin busy-polls the queue of the stream until a data frame is queued, or
+// for at most busyPollSpin.
+func (s *istream) spin() {
+	var deadline time.Time
+	for i := 1; len(s.q) == 0; i++ {
+		if i%busyPollCheck == 0 {
+			now := time.Now()
+			if deadline.IsZero() {
+				deadline = now.Add(busyPollSpin)
+			}
+			if now.After(deadline) {
+				return
+			}
+		}
+		runtime.Gosched()
+	}
+}
+
+// process processes a data frame with the handler of the stream.
+func (s *istream) process(ctx Context, frame Frame) {
+	beg := time.Now()
+	err := s.h(ctx, frame)
+	if err != nil {
+		s.st.fail()
+		ctx.Msg.Errorf("could not process data frame for %q: %+v", s.name, err)
+	} else {
+		s.cnt.add(frame)
+	}
+	if frame.Trace {
+		traceEvent(ctx.Msg, "processed", s.name, frame, beg)
+	}
+}
+
+// ackPool acknowledges the acked data frames submitted to the workers of
+// the stream, once a batch of them is complete or no more frames are
+// waiting.
+// Acknowledgements cover all the frames received so far, so the workers
+// must first be done with all of them.
+func (s *istream) ackPool(ctx Context, pool *ipool) {
+	if s.ack == nil {
+		s.pending = 0
+		return
+	}
+	if s.pending < s.ack.batch && len(s.q) > 0 {
+		return
+	}
+	pool.wait()
+	for ; s.pending > 0; s.pending-- {
+		err := s.ack.done(s.pending == 1)
+		if err != nil {
+			ctx.Msg.Warnf("%+v", err)
+		}
+	}
+}
+
+// grant grants the producer of the stream credit for the free space of
+// its queue.
+func (s *istream) grant(ctx Context) {
+	s.used = 0
+	err := s.crd.grant(cap(s.q)-len(s.q), cap(s.q), atomic.LoadInt64(&s.qbytes))
+	if err != nil {
+		ctx.Msg.Warnf("%+v", err)
+	}
+}
+
+// demux dispatches the data frames received from a data connection to the
+// input end-points served by that connection, using the path of the frames.
+type demux struct {
+	streams map[string]*istream
+	lnk     *link // data link of the connection (may be nil)
+	wg      sync.WaitGroup
+}
+
+// newDemux starts the streams of the named input end-points.
+// The data frames of end-points with workers in wks are processed by these
+// workers.
+// qlen returns the length of the queue of a given end-point.
+// Chunked data frames are reassembled up to max bytes (0: default maximum
+// frame size).
+// Statistics about the received data frames are recorded on lnk, if any.
+// Data frames of end-points delivered in acknowledged mode are
+// acknowledged with the ackers of acks, and credit is granted to the
+// producers of end-points under flow control with the crediters of crds.
+// The consumed data frames are counted on st, if any.
+func newDemux(ctx Context, eps []string, hs map[string]InputHandler, wks map[string]inputWorkers, qlen func(ep string) int, max int, lnk *link, acks map[string]*acker, crds map[string]*crediter, st *runStats) *demux {
+	mux := &demux{streams: make(map[string]*istream, len(eps)), lnk: lnk}
+	capacity := 0
+	for _, ep := range eps {
+		n := qlen(ep)
+		if n <= 0 {
+			n = defaultStreamQueueLen
+		}
+		capacity += n
+		s := &istream{
+			name: ep, h: hs[ep], q: make(chan Frame, n),
+			lnk: lnk, ack: acks[ep], crd: crds[ep], st: st, cnt: st.input(ep),
+			wks: wks[ep], ro: newReorder(wks[ep]), max: max,
+			holds: make(chan struct{}, 1),
+			done:  make(chan struct{}),
+		}
+		mux.streams[ep] = s
+		mux.wg.Add(1)
+		go func() {
+			defer mux.wg.Done()
+			defer close(s.done)
+			s.run(ctx)
+		}()
+	}
+	if lnk != nil {
+		lnk.setCapacity(capacity)
+	}
+	return mux
+}
+
+// active returns whether some streams still expect data frames.
+func (mux *demux) active() bool {
+	return len(mux.streams) > 0
+}
+
+// dispatch sends the provided frame to the stream it belongs to.
+// End-of-stream frames without a path terminate all streams.
+// Frames for end-points not served by this process are discarded.
+func (mux *demux) dispatch(ctx Context, frame Frame) {
+	if frame.Type == FrameEOF {
+		if frame.Path == "" {
+			mux.eof()
+			return
+		}
+		if s, ok := mux.streams[frame.Path]; ok {
+			close(s.q)
+			delete(mux.streams, frame.Path)
+		}
+		return
+	}
+
+	s, ok := mux.streams[frame.Path]
+	if !ok {
+		return
+	}
+
+	if frame.Type == FrameHold {
+		select {
+		case s.q <- frame:
+		case <-ctx.Ctx.Done():
+		}
+		return
+	}
+
+	if mux.lnk != nil {
+		mux.lnk.recv(frame)
+		mux.lnk.enqueue(+1)
+	}
+	atomic.AddInt64(&s.qbytes, int64(len(frame.Body)))
+
+	select {
+	case s.q <- frame:
+	case <-ctx.Ctx.Done():
+		if mux.lnk != nil {
+			mux.lnk.enqueue(-1)
+		}
+		atomic.AddInt64(&s.qbytes, -int64(len(frame.Body)))
+	}
+}
+
+// eof terminates all remaining streams.
+func (mux *demux) eof() {
+	for k, s := range mux.streams {
+		close(s.q)
+		delete(mux.streams, k)
+	}
+}
+
+// close terminates all remaining streams and waits for them to finish
+// processing their queued data frames.
+func (mux *demux) close() {
+	mux.eof()
+	mux.wg.Wait()
+}
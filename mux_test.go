@@ -0,0 +1,125 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestDemux(t *testing.T) {
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("demux", log.LvlError, ioutil.Discard),
+	}
+
+	var (
+		mu  sync.Mutex
+		got = make(map[string][]Frame)
+		hs  = make(map[string]InputHandler)
+		eps = []string{"/adc", "/tdc"}
+	)
+	for _, ep := range eps {
+		hs[ep] = func(ctx Context, src Frame) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got[src.Path] = append(got[src.Path], src)
+			return nil
+		}
+	}
+
+	big := Frame{Type: FrameData, Path: "/tdc", Body: []byte("0123456789")}
+	frames := []Frame{
+		{Type: FrameData, Path: "/adc", Body: []byte("adc-1")},
+		{Type: FrameData, Path: "/xxx", Body: []byte("not consumed")},
+		{Type: FrameData, Path: "/tdc", Body: []byte("tdc-1")},
+	}
+	for _, msg := range chunkFrame(big, 4, 1) {
+		chunk, err := RecvFrame(ctx.Ctx, rawRecver(msg))
+		if err != nil {
+			t.Fatalf("could not decode chunk: %+v", err)
+		}
+		frames = append(frames, chunk)
+	}
+	frames = append(frames,
+		Frame{Type: FrameEOF, Path: "/adc"},
+		Frame{Type: FrameData, Path: "/adc", Body: []byte("after eof")},
+	)
+
+	lnk := newLink(ctx.Msg, "tcp://127.0.0.1:4000", eps, 0, time.Now)
+	mux := newDemux(ctx, eps, hs, nil, func(string) int { return 1 }, 0, lnk, nil, nil, nil)
+	for _, frame := range frames {
+		mux.dispatch(ctx, frame)
+	}
+	if got, want := len(mux.streams), 1; got != want {
+		t.Fatalf("invalid number of active streams: got=%d, want=%d", got, want)
+	}
+
+	mux.dispatch(ctx, Frame{Type: FrameEOF})
+	if mux.active() {
+		t.Fatalf("demux should not be active anymore")
+	}
+	mux.close()
+
+	if st := lnk.status(); st.Frames != 5 || st.Queued != 0 || st.LastFrame.IsZero() {
+		t.Fatalf("invalid link statistics: frames=%d, queued=%d, last=%v", st.Frames, st.Queued, st.LastFrame)
+	}
+
+	want := map[string][]Frame{
+		"/adc": {{Type: FrameData, Path: "/adc", Body: []byte("adc-1")}},
+		"/tdc": {{Type: FrameData, Path: "/tdc", Body: []byte("tdc-1")}, big},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid demuxed frames:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+}
+
+func TestDemuxHold(t *testing.T) {
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("demux", log.LvlError, ioutil.Discard),
+	}
+
+	var (
+		n  int64
+		hs = map[string]InputHandler{
+			"/adc": func(ctx Context, src Frame) error {
+				time.Sleep(time.Millisecond)
+				atomic.AddInt64(&n, 1)
+				return nil
+			},
+		}
+		w = inputWorkers{n: 4, order: OrderNone}
+	)
+
+	lnk := newLink(ctx.Msg, "tcp://127.0.0.1:4000", []string{"/adc"}, 0, time.Now)
+	mux := newDemux(ctx, []string{"/adc"}, hs, map[string]inputWorkers{"/adc": w}, func(string) int { return 16 }, 0, lnk, nil, nil, nil)
+	defer mux.close()
+
+	for i := 0; i < 10; i++ {
+		mux.dispatch(ctx, Frame{Type: FrameData, Path: "/adc"})
+	}
+	mux.dispatch(ctx, Frame{Type: FrameHold, Path: "/adc"})
+
+	s := mux.streams["/adc"]
+	select {
+	case <-s.holds:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream did not hold")
+	}
+	if got, want := atomic.LoadInt64(&n), int64(10); got != want {
+		t.Fatalf("invalid number of processed frames: got=%d, want=%d", got, want)
+	}
+	if st := lnk.status(); st.Frames != 10 || st.Queued != 0 {
+		t.Fatalf("invalid link statistics: frames=%d, queued=%d", st.Frames, st.Queued)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+)
+
+// holder holds the output loops of a process at a frame boundary, while
+// the run is paused.
+type holder struct {
+	mu     sync.RWMutex
+	resume chan struct{} // closed when the run is resumed (nil: not paused)
+}
+
+// wait blocks while the run is paused, and reports whether the run is
+// still going on.
+func (h *holder) wait(ctx Context) bool {
+	h.mu.RLock()
+	c := h.resume
+	h.mu.RUnlock()
+	if c == nil {
+		return true
+	}
+	select {
+	case <-c:
+		return true
+	case <-ctx.Ctx.Done():
+		return false
+	}
+}
+
+// enter waits until the run is not paused, and prevents it from being
+// paused until leave is called.
+// enter reports whether the run is still going on: leave must not be
+// called otherwise.
+func (h *holder) enter(ctx Context) bool {
+	for {
+		h.mu.RLock()
+		c := h.resume
+		if c == nil {
+			return true
+		}
+		h.mu.RUnlock()
+		select {
+		case <-c:
+		case <-ctx.Ctx.Done():
+			return false
+		}
+	}
+}
+
+func (h *holder) leave() {
+	h.mu.RUnlock()
+}
+
+// release resumes the held output loops, if any.
+// release must be called with h.mu held.
+func (h *holder) release() {
+	if h.resume == nil {
+		return
+	}
+	close(h.resume)
+	h.resume = nil
+}
+
+// onPause holds the output loops at their next frame boundary, and sends a
+// hold-frame on all the output end-points.
+func (mgr *omgr) onPause(ctx Context) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	// wait for the data frames being sent.
+	mgr.hold.mu.Lock()
+	defer mgr.hold.mu.Unlock()
+
+	if mgr.hold.resume != nil {
+		return nil
+	}
+	mgr.hold.resume = make(chan struct{})
+
+	for ep, op := range mgr.ps {
+		err := op.send(Frame{Type: FrameHold, Path: ep}.encode())
+		if err != nil {
+			return fmt.Errorf("could not send hold-frame for %q: %w", ep, err)
+		}
+	}
+	return nil
+}
+
+// onResume releases the output loops.
+func (mgr *omgr) onResume(ctx Context) error {
+	mgr.hold.mu.Lock()
+	defer mgr.hold.mu.Unlock()
+	mgr.hold.release()
+	return nil
+}
+
+// onPause waits for all the input end-points to receive the hold-frames of
+// their producers, and to process the data frames received before.
+// Data frames held by a reordering stage, waiting for a missing frame,
+// are only processed after the run is resumed.
+func (mgr *imgr) onPause(ctx Context) error {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	for _, r := range mgr.rds {
+		for _, s := range r.streams() {
+			select {
+			case <-s.holds:
+			case <-s.done:
+				// end-point already reached its end of stream.
+			case <-ctx.Ctx.Done():
+				return fmt.Errorf("on-pause failed: could not drain %q: %w", s.name, ctx.Ctx.Err())
+			}
+		}
+	}
+	return nil
+}
+
+// streams returns the input end-points of the current run, still expecting
+// data frames.
+func (r *reader) streams() []*istream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mux == nil {
+		return nil
+	}
+	streams := make([]*istream, 0, len(r.mux.streams))
+	for _, s := range r.mux.streams {
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+// hold records that the producer of the stream paused, once all the data
+// frames received before were processed.
+func (s *istream) hold() {
+	select {
+	case s.holds <- struct{}{}:
+	default:
+	}
+}
+
+// onPause drains and holds the data flow of the process, without ending
+// the run.
+// The data frames received on the input end-points before the hold-frames
+// of their producers are first processed.
+// The output loops then stop at a frame boundary, and send a hold-frame on
+// each output end-point, after their last data frame.
+//
+// Run-ctl broadcasts /pause in dependency order, so the data frames in
+// flight are flushed down the whole chain of processes.
+// Resuming the run simply releases the output loops: sequence numbers,
+// event IDs, counters and data connections carry over, and the paused
+// period is accounted as dead time, not as lost data.
+func (srv *Server) onPause(ctx Context, req Frame) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	err := srv.checkTransition(CmdPause)
+	if err != nil {
+		return err
+	}
+
+	// inputs are drained before outputs are held, so the data frames
+	// derived from the drained ones precede the hold-frames.
+	err = srv.imgr.onPause(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = srv.omgr.onPause(ctx)
+	if err != nil {
+		return err
+	}
+
+	srv.paused = srv.now()
+	return nil
+}
+
+func (srv *Server) onResume(ctx Context, req Frame) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	err := srv.checkTransition(CmdResume)
+	if err != nil {
+		return err
+	}
+
+	srv.unpause()
+	return srv.omgr.onResume(ctx)
+}
+
+// unpause accounts the time spent paused, if any, as dead time.
+// unpause must be called with srv.mu held.
+func (srv *Server) unpause() {
+	if srv.paused.IsZero() {
+		return
+	}
+	srv.stats.block(srv.now().Sub(srv.paused))
+	srv.paused = time.Time{}
+}
+
+// doPause drains and holds the data flow of the current run.
+func (rc *RunControl) doPause(ctx context.Context) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.msg.Infof("/pause processes...")
+
+	err := rc.prepare(ctx, CmdPause)
+	if err != nil {
+		return err
+	}
+
+	err = rc.broadcast(ctx, CmdPause)
+	if err != nil {
+		rc.failed(err)
+		return err
+	}
+
+	for _, cli := range rc.clients.list() {
+		cli.setStatus(fsm.Paused)
+	}
+	rc.setStatus(fsm.Paused)
+	rc.msg.Infof("run %d paused", rc.runNbr)
+
+	return nil
+}
+
+// doResume resumes the data flow of the paused run.
+func (rc *RunControl) doResume(ctx context.Context) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.msg.Infof("/resume processes...")
+
+	err := rc.prepare(ctx, CmdResume)
+	if err != nil {
+		return err
+	}
+
+	err = rc.broadcast(ctx, CmdResume)
+	if err != nil {
+		rc.failed(err)
+		return err
+	}
+
+	for _, cli := range rc.clients.list() {
+		cli.setStatus(fsm.Running)
+	}
+	rc.setStatus(fsm.Running)
+	rc.msg.Infof("run %d resumed", rc.runNbr)
+
+	return nil
+}
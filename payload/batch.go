@@ -0,0 +1,144 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package payload // import "github.com/go-daq/tdaq/payload"
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Batch is a columnar record batch of payloads: the values of the fields of
+// a schema, stored column by column, as in Apache Arrow record batches.
+//
+// A batch is sent as a single data frame, so analysis tools consume whole
+// columns without converting payloads one at a time.
+type Batch struct {
+	sch  Schema
+	rows int
+	cols [][]interface{} // values of the fields, indexed by field
+}
+
+// NewBatch returns an empty batch of payloads with the provided schema.
+func NewBatch(sch Schema) *Batch {
+	return &Batch{
+		sch:  sch,
+		cols: make([][]interface{}, len(sch)),
+	}
+}
+
+// Schema returns the schema of the payloads of the batch.
+func (b *Batch) Schema() Schema { return b.sch }
+
+// Len returns the number of payloads of the batch.
+func (b *Batch) Len() int { return b.rows }
+
+// Reset empties the batch, keeping its schema.
+func (b *Batch) Reset() {
+	b.rows = 0
+	for i := range b.cols {
+		b.cols[i] = b.cols[i][:0]
+	}
+}
+
+// Append decodes the provided payload and appends its fields to the
+// columns of the batch.
+func (b *Batch) Append(body []byte) error {
+	vals, err := b.sch.values(body)
+	if err != nil {
+		return fmt.Errorf("could not append payload to batch: %w", err)
+	}
+	b.append(vals)
+	return nil
+}
+
+func (b *Batch) append(vals []interface{}) {
+	for i, v := range vals {
+		b.cols[i] = append(b.cols[i], v)
+	}
+	b.rows++
+}
+
+// Column returns the values of the named field, with the types of Decode
+// (time fields excepted, returned as time.Time values).
+func (b *Batch) Column(name string) ([]interface{}, bool) {
+	for i, f := range b.sch {
+		if f.Name == name {
+			return b.cols[i], true
+		}
+	}
+	return nil, false
+}
+
+// Payload returns the i-th payload of the batch.
+func (b *Batch) Payload(i int) ([]byte, error) {
+	if i < 0 || i >= b.rows {
+		return nil, fmt.Errorf("payload index %d out of range [0, %d)", i, b.rows)
+	}
+	vals := make([]interface{}, len(b.cols))
+	for j, col := range b.cols {
+		vals[j] = col[i]
+	}
+	return b.sch.encode(vals)
+}
+
+func (b *Batch) MarshalTDAQ() ([]byte, error) {
+	var (
+		buf = new(bytes.Buffer)
+		enc = tdaq.NewEncoder(buf)
+	)
+	enc.WriteStr(b.sch.String())
+	enc.WriteI32(int32(b.rows))
+	for i, f := range b.sch {
+		typ, _ := lookupType(f.Type)
+		for _, v := range b.cols[i] {
+			typ.write(enc, v)
+		}
+	}
+	if err := enc.Err(); err != nil {
+		return nil, fmt.Errorf("could not encode batch: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (b *Batch) UnmarshalTDAQ(p []byte) error {
+	dec := tdaq.NewDecoder(bytes.NewReader(p))
+	spec := dec.ReadStr()
+	rows := int(dec.ReadI32())
+	if err := dec.Err(); err != nil {
+		return fmt.Errorf("could not decode batch header: %w", err)
+	}
+	sch, err := ParseSchema(spec)
+	if err != nil {
+		return fmt.Errorf("could not decode batch schema: %w", err)
+	}
+	if rows < 0 || rows > len(p) {
+		return fmt.Errorf("invalid number of batch payloads %d", rows)
+	}
+
+	*b = *NewBatch(sch)
+	b.rows = rows
+	for i, f := range sch {
+		typ, _ := lookupType(f.Type)
+		col := make([]interface{}, rows)
+		for j := range col {
+			col[j] = typ.read(dec)
+			if err := dec.Err(); err != nil {
+				return fmt.Errorf("could not decode column %q: %w", f.Name, err)
+			}
+			if typ.elem != nil && col[j] == nil {
+				return fmt.Errorf("could not decode column %q: invalid slice length", f.Name)
+			}
+		}
+		b.cols[i] = col
+	}
+	return nil
+}
+
+var (
+	_ tdaq.Marshaler   = (*Batch)(nil)
+	_ tdaq.Unmarshaler = (*Batch)(nil)
+)
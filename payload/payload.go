@@ -0,0 +1,533 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package payload describes the layout of typed data frame payloads, and
+// converts them to and from JSON objects and CSV rows.
+//
+// Payloads are described by a Schema: the sequence of their fields, encoded
+// in order with the tdaq.Encoder primitives, e.g. by the MarshalTDAQ methods
+// generated by tdaq-gen.
+// Schemas are registered under the names of the types of data frames
+// declared by the end-points of the topology.
+//
+// Payloads serialized with zero-copy formats, such as FlatBuffers or
+// Cap'n Proto, are described by a ZeroCopy instead, and read in place.
+package payload // import "github.com/go-daq/tdaq/payload"
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Field describes a field of a typed payload.
+type Field struct {
+	Name string // name of the field
+	Type string // type of the field (bool, i8, i16, i32, i64, u8, u16, u32, u64, f32, f64, varint, uvarint, str, bytes, time, or []T for slices of T)
+}
+
+// Schema describes the layout of typed payloads.
+type Schema []Field
+
+// ParseSchema parses a schema from its comma-separated list of name:type
+// fields, e.g. "evt:u64,ch:u8,energy:f64".
+// Fields may also be separated by semicolons, e.g. on command lines where
+// commas already separate parameters.
+func ParseSchema(s string) (Schema, error) {
+	var (
+		sch Schema
+		sep = func(r rune) bool { return r == ',' || r == ';' }
+	)
+	for _, f := range strings.FieldsFunc(s, sep) {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		i := strings.Index(f, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid schema field %q", f)
+		}
+		fld := Field{Name: f[:i], Type: f[i+1:]}
+		if _, ok := lookupType(fld.Type); !ok {
+			return nil, fmt.Errorf("invalid type %q of schema field %q", fld.Type, fld.Name)
+		}
+		sch = append(sch, fld)
+	}
+	if len(sch) == 0 {
+		return nil, fmt.Errorf("empty schema")
+	}
+	return sch, nil
+}
+
+// String returns the comma-separated list of name:type fields of the schema.
+func (sch Schema) String() string {
+	fs := make([]string, len(sch))
+	for i, f := range sch {
+		fs[i] = f.Name + ":" + f.Type
+	}
+	return strings.Join(fs, ",")
+}
+
+// values decodes the fields of the payload, in order.
+func (sch Schema) values(body []byte) ([]interface{}, error) {
+	var (
+		dec  = tdaq.NewDecoder(bytes.NewReader(body))
+		vals = make([]interface{}, len(sch))
+	)
+	for i, f := range sch {
+		typ, ok := lookupType(f.Type)
+		if !ok {
+			return nil, fmt.Errorf("invalid type %q of schema field %q", f.Type, f.Name)
+		}
+		vals[i] = typ.read(dec)
+		if err := dec.Err(); err != nil {
+			return nil, fmt.Errorf("could not decode field %q: %w", f.Name, err)
+		}
+		if typ.elem != nil && vals[i] == nil {
+			return nil, fmt.Errorf("could not decode field %q: invalid slice length", f.Name)
+		}
+	}
+	return vals, nil
+}
+
+// encode encodes the provided values of the fields of the payload.
+func (sch Schema) encode(vals []interface{}) ([]byte, error) {
+	var (
+		buf = new(bytes.Buffer)
+		enc = tdaq.NewEncoder(buf)
+	)
+	for i, f := range sch {
+		typ, _ := lookupType(f.Type)
+		typ.write(enc, vals[i])
+	}
+	if err := enc.Err(); err != nil {
+		return nil, fmt.Errorf("could not encode payload: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes the fields of the payload, indexed by name.
+// Time fields are decoded as nanoseconds since the Unix epoch.
+func (sch Schema) Decode(body []byte) (map[string]interface{}, error) {
+	vals, err := sch.values(body)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]interface{}, len(sch))
+	for i, f := range sch {
+		v := vals[i]
+		if t, ok := v.(time.Time); ok {
+			v = t.UnixNano()
+		}
+		vars[f.Name] = v
+	}
+	return vars, nil
+}
+
+// JSON converts the payload to a JSON object, with the fields in the order
+// of the schema.
+// Bytes fields are converted to hexadecimal strings, time fields to
+// RFC 3339 strings, non-finite floats to "NaN", "+Inf" or "-Inf", and
+// slices to arrays.
+func (sch Schema) JSON(body []byte) ([]byte, error) {
+	vals, err := sch.values(body)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("{")
+	for i, f := range sch {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		k, _ := json.Marshal(f.Name)
+		buf.Write(k)
+		buf.WriteString(":")
+		typ, _ := lookupType(f.Type)
+		writeJSON(buf, typ, vals[i])
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, typ fieldType, v interface{}) {
+	if typ.elem != nil {
+		buf.WriteString("[")
+		for i, x := range v.([]interface{}) {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeJSON(buf, *typ.elem, x)
+		}
+		buf.WriteString("]")
+		return
+	}
+
+	s := format(v)
+	switch x := toF64(v); {
+	case typ.quote:
+		raw, _ := json.Marshal(s)
+		buf.Write(raw)
+	case math.IsNaN(x) || math.IsInf(x, 0):
+		buf.WriteString(strconv.Quote(s))
+	default:
+		buf.WriteString(s)
+	}
+}
+
+// FromJSON converts a JSON object, as returned by JSON, back to a payload.
+func (sch Schema) FromJSON(p []byte) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(p, &obj)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode JSON payload: %w", err)
+	}
+
+	vals := make([]interface{}, len(sch))
+	for i, f := range sch {
+		raw, ok := obj[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing field %q in JSON payload", f.Name)
+		}
+		typ, _ := lookupType(f.Type)
+		vals[i], err = parseJSON(typ, raw)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse field %q: %w", f.Name, err)
+		}
+	}
+	return sch.encode(vals)
+}
+
+func parseJSON(typ fieldType, raw json.RawMessage) (interface{}, error) {
+	if typ.elem != nil {
+		var elems []json.RawMessage
+		err := json.Unmarshal(raw, &elems)
+		if err != nil {
+			return nil, err
+		}
+		vs := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			vs[i], err = parseJSON(*typ.elem, elem)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return vs, nil
+	}
+
+	s := string(raw)
+	switch {
+	case strings.HasPrefix(s, `"`):
+		err := json.Unmarshal(raw, &s)
+		if err != nil {
+			return nil, err
+		}
+	case typ.quote:
+		return nil, fmt.Errorf("invalid JSON value %s (want a string)", raw)
+	}
+	return typ.parse(s)
+}
+
+// Header returns the header row of the CSV conversion of payloads: the
+// names of the fields of the schema.
+func (sch Schema) Header() []string {
+	names := make([]string, len(sch))
+	for i, f := range sch {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// CSV converts the payload to a CSV row, with the fields in the order of
+// the schema.
+// Bytes fields are converted to hexadecimal strings and time fields to
+// RFC 3339 strings.
+func (sch Schema) CSV(body []byte) ([]string, error) {
+	vals, err := sch.values(body)
+	if err != nil {
+		return nil, err
+	}
+	row := make([]string, len(vals))
+	for i, v := range vals {
+		row[i] = format(v)
+	}
+	return row, nil
+}
+
+// FromCSV converts a CSV row, as returned by CSV, back to a payload.
+// Slices are converted to space-separated lists of values.
+func (sch Schema) FromCSV(row []string) ([]byte, error) {
+	if len(row) != len(sch) {
+		return nil, fmt.Errorf("invalid number of CSV fields (got=%d, want=%d)", len(row), len(sch))
+	}
+	var (
+		err  error
+		vals = make([]interface{}, len(sch))
+	)
+	for i, f := range sch {
+		typ, _ := lookupType(f.Type)
+		vals[i], err = typ.parse(row[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse field %q: %w", f.Name, err)
+		}
+	}
+	return sch.encode(vals)
+}
+
+// format formats a decoded field value.
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	case []byte:
+		return hex.EncodeToString(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case []interface{}:
+		vs := make([]string, len(v))
+		for i, x := range v {
+			vs[i] = format(x)
+		}
+		return strings.Join(vs, " ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toF64(v interface{}) float64 {
+	switch v := v.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+type fieldType struct {
+	read  func(dec *tdaq.Decoder) interface{}
+	write func(enc *tdaq.Encoder, v interface{})
+	parse func(s string) (interface{}, error)
+	quote bool       // whether values are converted to JSON strings
+	elem  *fieldType // type of the elements of slices
+}
+
+// lookupType returns the named field type.
+func lookupType(name string) (fieldType, bool) {
+	if typ, ok := fieldTypes[name]; ok {
+		return typ, true
+	}
+	if !strings.HasPrefix(name, "[]") {
+		return fieldType{}, false
+	}
+	elem, ok := fieldTypes[name[2:]]
+	if !ok || elem.quote {
+		// slices of strings, bytes or times are not supported.
+		return fieldType{}, false
+	}
+	return sliceType(elem), true
+}
+
+// sliceType returns the type of slices of elem, encoded as their
+// number of elements followed by the elements, as by tdaq-gen.
+// Slices are decoded as []interface{} values.
+func sliceType(elem fieldType) fieldType {
+	return fieldType{
+		read: func(dec *tdaq.Decoder) interface{} {
+			n := dec.ReadI32()
+			if n < 0 {
+				return nil
+			}
+			vs := make([]interface{}, 0)
+			for i := int32(0); i < n && dec.Err() == nil; i++ {
+				vs = append(vs, elem.read(dec))
+			}
+			return vs
+		},
+		write: func(enc *tdaq.Encoder, v interface{}) {
+			vs := v.([]interface{})
+			enc.WriteI32(int32(len(vs)))
+			for _, x := range vs {
+				elem.write(enc, x)
+			}
+		},
+		parse: func(s string) (interface{}, error) {
+			toks := strings.Fields(s)
+			vs := make([]interface{}, len(toks))
+			for i, tok := range toks {
+				v, err := elem.parse(tok)
+				if err != nil {
+					return nil, err
+				}
+				vs[i] = v
+			}
+			return vs, nil
+		},
+		elem: &elem,
+	}
+}
+
+func parseInt(bits int, conv func(v int64) interface{}) func(s string) (interface{}, error) {
+	return func(s string) (interface{}, error) {
+		v, err := strconv.ParseInt(s, 10, bits)
+		if err != nil {
+			return nil, err
+		}
+		return conv(v), nil
+	}
+}
+
+func parseUint(bits int, conv func(v uint64) interface{}) func(s string) (interface{}, error) {
+	return func(s string) (interface{}, error) {
+		v, err := strconv.ParseUint(s, 10, bits)
+		if err != nil {
+			return nil, err
+		}
+		return conv(v), nil
+	}
+}
+
+var fieldTypes = map[string]fieldType{
+	"bool": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadBool() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteBool(v.(bool)) },
+		parse: func(s string) (interface{}, error) { return strconv.ParseBool(s) },
+	},
+	"i8": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadI8() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteI8(v.(int8)) },
+		parse: parseInt(8, func(v int64) interface{} { return int8(v) }),
+	},
+	"i16": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadI16() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteI16(v.(int16)) },
+		parse: parseInt(16, func(v int64) interface{} { return int16(v) }),
+	},
+	"i32": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadI32() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteI32(v.(int32)) },
+		parse: parseInt(32, func(v int64) interface{} { return int32(v) }),
+	},
+	"i64": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadI64() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteI64(v.(int64)) },
+		parse: parseInt(64, func(v int64) interface{} { return v }),
+	},
+	"u8": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadU8() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteU8(v.(uint8)) },
+		parse: parseUint(8, func(v uint64) interface{} { return uint8(v) }),
+	},
+	"u16": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadU16() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteU16(v.(uint16)) },
+		parse: parseUint(16, func(v uint64) interface{} { return uint16(v) }),
+	},
+	"u32": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadU32() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteU32(v.(uint32)) },
+		parse: parseUint(32, func(v uint64) interface{} { return uint32(v) }),
+	},
+	"u64": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadU64() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteU64(v.(uint64)) },
+		parse: parseUint(64, func(v uint64) interface{} { return v }),
+	},
+	"f32": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadF32() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteF32(v.(float32)) },
+		parse: func(s string) (interface{}, error) {
+			v, err := strconv.ParseFloat(s, 32)
+			return float32(v), err
+		},
+	},
+	"f64": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadF64() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteF64(v.(float64)) },
+		parse: func(s string) (interface{}, error) { return strconv.ParseFloat(s, 64) },
+	},
+	"varint": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadVarint() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteVarint(v.(int64)) },
+		parse: parseInt(64, func(v int64) interface{} { return v }),
+	},
+	"uvarint": {
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadUvarint() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteUvarint(v.(uint64)) },
+		parse: parseUint(64, func(v uint64) interface{} { return v }),
+	},
+	"str": {
+		quote: true,
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadStr() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteStr(v.(string)) },
+		parse: func(s string) (interface{}, error) { return s, nil },
+	},
+	"bytes": {
+		quote: true,
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadBytes() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteBytes(v.([]byte)) },
+		parse: func(s string) (interface{}, error) { return hex.DecodeString(s) },
+	},
+	"time": {
+		quote: true,
+		read:  func(dec *tdaq.Decoder) interface{} { return dec.ReadTime() },
+		write: func(enc *tdaq.Encoder, v interface{}) { enc.WriteTime(v.(time.Time)) },
+		parse: func(s string) (interface{}, error) { return time.Parse(time.RFC3339Nano, s) },
+	},
+}
+
+var schemas = struct {
+	sync.RWMutex
+	db map[string]Schema
+}{
+	db: map[string]Schema{
+		// int64 values, as produced by xdaq.I64Gen.
+		"i64": {{Name: "v", Type: "i64"}},
+	},
+}
+
+// Register registers the schema of the named type of data frames,
+// as declared by the end-points of the topology.
+func Register(typ string, sch Schema) {
+	schemas.Lock()
+	defer schemas.Unlock()
+	schemas.db[typ] = sch
+}
+
+// Lookup returns the schema of the named type of data frames.
+func Lookup(typ string) (Schema, bool) {
+	schemas.RLock()
+	defer schemas.RUnlock()
+	sch, ok := schemas.db[typ]
+	return sch, ok
+}
+
+// Types returns the sorted names of the types of data frames with a
+// registered schema.
+func Types() []string {
+	schemas.RLock()
+	defer schemas.RUnlock()
+	names := make([]string, 0, len(schemas.db))
+	for name := range schemas.db {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
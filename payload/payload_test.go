@@ -0,0 +1,386 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package payload
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+func TestParseSchema(t *testing.T) {
+	sch, err := ParseSchema("evt:u64, ch:u8;name:str,t:time")
+	if err != nil {
+		t.Fatalf("could not parse schema: %+v", err)
+	}
+	if got, want := sch.String(), "evt:u64,ch:u8,name:str,t:time"; got != want {
+		t.Fatalf("invalid schema:\ngot = %q\nwant= %q", got, want)
+	}
+
+	for _, spec := range []string{"", "evt", ":u64", "evt:u128", "names:[]str", "m:[][]u8"} {
+		_, err := ParseSchema(spec)
+		if err == nil {
+			t.Fatalf("expected an error parsing schema %q", spec)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	sch := Schema{{Name: "evt", Type: "u64"}}
+	Register("payload-test-evt", sch)
+	got, ok := Lookup("payload-test-evt")
+	if !ok || !reflect.DeepEqual(got, sch) {
+		t.Fatalf("could not lookup registered schema: got=%v, ok=%v", got, ok)
+	}
+
+	if _, ok := Lookup("i64"); !ok {
+		t.Fatalf("missing builtin i64 schema")
+	}
+
+	types := Types()
+	for i := 1; i < len(types); i++ {
+		if types[i-1] >= types[i] {
+			t.Fatalf("types not sorted: %q", types)
+		}
+	}
+}
+
+// hitsView is a minimal accessor over a table of u32 hits, as generated
+// for zero-copy formats.
+type hitsView struct{ buf []byte }
+
+func (v hitsView) Len() int        { return len(v.buf) / 4 }
+func (v hitsView) At(i int) uint32 { return tdaq.U32(tdaq.DefaultByteOrder, v.buf[4*i:]) }
+
+func TestZeroCopy(t *testing.T) {
+	RegisterZeroCopy("payload-test-hits", ZeroCopy{
+		Format: FlatBuffers,
+		Access: func(body []byte) (interface{}, error) {
+			if len(body)%4 != 0 {
+				return nil, fmt.Errorf("invalid hits payload size %d", len(body))
+			}
+			return hitsView{body}, nil
+		},
+	})
+	if zc, ok := LookupZeroCopy("payload-test-hits"); !ok || zc.Format != FlatBuffers {
+		t.Fatalf("could not lookup registered zero-copy serialization: got=%v, ok=%v", zc.Format, ok)
+	}
+	if got, want := ZeroCopyTypes(), []string{"payload-test-hits"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid zero-copy types:\ngot = %q\nwant= %q", got, want)
+	}
+
+	body := make([]byte, 8)
+	tdaq.PutU32(tdaq.DefaultByteOrder, body[0:], 42)
+	tdaq.PutU32(tdaq.DefaultByteOrder, body[4:], 43)
+
+	var sum uint32
+	h := Handler("payload-test-hits", func(ctx tdaq.Context, src tdaq.Frame, v interface{}) error {
+		hits := v.(hitsView)
+		if &hits.buf[0] != &src.Body[0] {
+			t.Fatalf("payload was copied")
+		}
+		for i := 0; i < hits.Len(); i++ {
+			sum += hits.At(i)
+		}
+		return nil
+	})
+	err := h(tdaq.Context{}, tdaq.Frame{Body: body})
+	if err != nil {
+		t.Fatalf("could not handle payload: %+v", err)
+	}
+	if got, want := sum, uint32(85); got != want {
+		t.Fatalf("invalid sum: got=%d, want=%d", got, want)
+	}
+
+	err = h(tdaq.Context{}, tdaq.Frame{Body: body[:3]})
+	if err == nil {
+		t.Fatalf("expected an error accessing a truncated payload")
+	}
+
+	for _, zc := range []ZeroCopy{
+		{Format: "protobuf", Access: func([]byte) (interface{}, error) { return nil, nil }},
+		{Format: CapnProto},
+	} {
+		func() {
+			defer func() {
+				if e := recover(); e == nil {
+					t.Fatalf("expected a panic registering %q", zc.Format)
+				}
+			}()
+			RegisterZeroCopy("payload-test-invalid", zc)
+		}()
+	}
+}
+
+func TestBatch(t *testing.T) {
+	sch := Schema{
+		{Name: "evt", Type: "u64"},
+		{Name: "name", Type: "str"},
+		{Name: "adc", Type: "[]u16"},
+	}
+	b := NewBatch(sch)
+	var want [][]byte
+	for i := 0; i < 3; i++ {
+		buf := new(bytes.Buffer)
+		enc := tdaq.NewEncoder(buf)
+		enc.WriteU64(uint64(i))
+		enc.WriteStr(fmt.Sprintf("evt-%d", i))
+		enc.WriteI32(int32(i))
+		for j := 0; j < i; j++ {
+			enc.WriteU16(uint16(10*i + j))
+		}
+		err := b.Append(buf.Bytes())
+		if err != nil {
+			t.Fatalf("could not append payload %d: %+v", i, err)
+		}
+		want = append(want, buf.Bytes())
+	}
+	if err := b.Append([]byte{1}); err == nil {
+		t.Fatalf("expected an error appending a truncated payload")
+	}
+
+	raw, err := b.MarshalTDAQ()
+	if err != nil {
+		t.Fatalf("could not marshal batch: %+v", err)
+	}
+	var got Batch
+	err = got.UnmarshalTDAQ(raw)
+	if err != nil {
+		t.Fatalf("could not unmarshal batch: %+v", err)
+	}
+	if got, want := got.Len(), 3; got != want {
+		t.Fatalf("invalid batch length: got=%d, want=%d", got, want)
+	}
+	if got, want := got.Schema().String(), sch.String(); got != want {
+		t.Fatalf("invalid batch schema:\ngot = %q\nwant= %q", got, want)
+	}
+	evts, ok := got.Column("evt")
+	if !ok || !reflect.DeepEqual(evts, []interface{}{uint64(0), uint64(1), uint64(2)}) {
+		t.Fatalf("invalid evt column: got=%v, ok=%v", evts, ok)
+	}
+	if _, ok := got.Column("tdc"); ok {
+		t.Fatalf("unexpected tdc column")
+	}
+	for i := range want {
+		p, err := got.Payload(i)
+		if err != nil {
+			t.Fatalf("could not retrieve payload %d: %+v", i, err)
+		}
+		if !bytes.Equal(p, want[i]) {
+			t.Fatalf("invalid payload %d:\ngot = %v\nwant= %v", i, p, want[i])
+		}
+	}
+	if _, err := got.Payload(3); err == nil {
+		t.Fatalf("expected an error retrieving an out of range payload")
+	}
+
+	err = got.UnmarshalTDAQ(raw[:len(raw)-1])
+	if err == nil {
+		t.Fatalf("expected an error unmarshaling a truncated batch")
+	}
+
+	b.Reset()
+	if got, want := b.Len(), 0; got != want {
+		t.Fatalf("invalid reset batch length: got=%d, want=%d", got, want)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	sch := Schema{
+		{Name: "evt", Type: "u64"},
+		{Name: "ch", Type: "u8"},
+		{Name: "name", Type: "str"},
+		{Name: "t", Type: "time"},
+	}
+
+	var (
+		buf = new(bytes.Buffer)
+		enc = tdaq.NewEncoder(buf)
+		now = time.Unix(0, 1234567890)
+	)
+	enc.WriteU64(42)
+	enc.WriteU8(3)
+	enc.WriteStr("adc")
+	enc.WriteTime(now)
+	if err := enc.Err(); err != nil {
+		t.Fatalf("could not encode payload: %+v", err)
+	}
+
+	got, err := sch.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("could not decode payload: %+v", err)
+	}
+	want := map[string]interface{}{
+		"evt":  uint64(42),
+		"ch":   uint8(3),
+		"name": "adc",
+		"t":    now.UnixNano(),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid decoded payload:\ngot = %v\nwant= %v", got, want)
+	}
+
+	_, err = sch.Decode(buf.Bytes()[:4])
+	if err == nil {
+		t.Fatalf("expected an error decoding a truncated payload")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	sch, err := ParseSchema("ok:bool,a:i8,b:i16,c:i32,d:i64,e:u8,f:u16,g:u32,h:u64,x:f32,y:f64,v:varint,u:uvarint,s:str,raw:bytes,t:time")
+	if err != nil {
+		t.Fatalf("could not parse schema: %+v", err)
+	}
+
+	var (
+		buf = new(bytes.Buffer)
+		enc = tdaq.NewEncoder(buf)
+	)
+	enc.WriteBool(true)
+	enc.WriteI8(-8)
+	enc.WriteI16(-16)
+	enc.WriteI32(-32)
+	enc.WriteI64(-64)
+	enc.WriteU8(8)
+	enc.WriteU16(16)
+	enc.WriteU32(32)
+	enc.WriteU64(math.MaxUint64)
+	enc.WriteF32(1.5)
+	enc.WriteF64(math.Inf(-1))
+	enc.WriteVarint(-300)
+	enc.WriteUvarint(300)
+	enc.WriteStr(`a "b", c`)
+	enc.WriteBytes([]byte{0xca, 0xfe})
+	enc.WriteTime(time.Date(2020, 4, 1, 12, 30, 0, 42, time.UTC))
+	if err := enc.Err(); err != nil {
+		t.Fatalf("could not encode payload: %+v", err)
+	}
+	body := buf.Bytes()
+
+	t.Run("json", func(t *testing.T) {
+		got, err := sch.JSON(body)
+		if err != nil {
+			t.Fatalf("could not convert to JSON: %+v", err)
+		}
+		want := `{"ok":true,"a":-8,"b":-16,"c":-32,"d":-64,"e":8,"f":16,"g":32,"h":18446744073709551615,"x":1.5,"y":"-Inf","v":-300,"u":300,"s":"a \"b\", c","raw":"cafe","t":"2020-04-01T12:30:00.000000042Z"}`
+		if string(got) != want {
+			t.Fatalf("invalid JSON:\ngot = %s\nwant= %s", got, want)
+		}
+
+		back, err := sch.FromJSON(got)
+		if err != nil {
+			t.Fatalf("could not convert from JSON: %+v", err)
+		}
+		if !bytes.Equal(back, body) {
+			t.Fatalf("invalid JSON round-trip:\ngot = %x\nwant= %x", back, body)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		if got, want := sch.Header(), []string{"ok", "a", "b", "c", "d", "e", "f", "g", "h", "x", "y", "v", "u", "s", "raw", "t"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid header:\ngot = %q\nwant= %q", got, want)
+		}
+
+		got, err := sch.CSV(body)
+		if err != nil {
+			t.Fatalf("could not convert to CSV: %+v", err)
+		}
+		want := []string{"true", "-8", "-16", "-32", "-64", "8", "16", "32", "18446744073709551615", "1.5", "-Inf", "-300", "300", `a "b", c`, "cafe", "2020-04-01T12:30:00.000000042Z"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid CSV row:\ngot = %q\nwant= %q", got, want)
+		}
+
+		back, err := sch.FromCSV(got)
+		if err != nil {
+			t.Fatalf("could not convert from CSV: %+v", err)
+		}
+		if !bytes.Equal(back, body) {
+			t.Fatalf("invalid CSV round-trip:\ngot = %x\nwant= %x", back, body)
+		}
+	})
+
+	t.Run("slices", func(t *testing.T) {
+		sch := Schema{{Name: "xs", Type: "[]f64"}, {Name: "ns", Type: "[]u8"}}
+
+		buf := new(bytes.Buffer)
+		enc := tdaq.NewEncoder(buf)
+		enc.WriteI32(2)
+		enc.WriteF64(1.5)
+		enc.WriteF64(math.NaN())
+		enc.WriteI32(0)
+		body := buf.Bytes()
+
+		js, err := sch.JSON(body)
+		if err != nil {
+			t.Fatalf("could not convert to JSON: %+v", err)
+		}
+		if got, want := string(js), `{"xs":[1.5,"NaN"],"ns":[]}`; got != want {
+			t.Fatalf("invalid JSON:\ngot = %s\nwant= %s", got, want)
+		}
+
+		row, err := sch.CSV(body)
+		if err != nil {
+			t.Fatalf("could not convert to CSV: %+v", err)
+		}
+		if got, want := row, []string{"1.5 NaN", ""}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("invalid CSV row:\ngot = %q\nwant= %q", got, want)
+		}
+
+		for _, back := range []func() ([]byte, error){
+			func() ([]byte, error) { return sch.FromJSON(js) },
+			func() ([]byte, error) { return sch.FromCSV(row) },
+		} {
+			got, err := back()
+			if err != nil {
+				t.Fatalf("could not convert back: %+v", err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Fatalf("invalid round-trip:\ngot = %x\nwant= %x", got, body)
+			}
+		}
+
+		buf.Reset()
+		enc.WriteI32(-1)
+		_, err = sch.JSON(buf.Bytes())
+		if err == nil {
+			t.Fatalf("expected an error decoding a negative slice length")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		sch := Schema{{Name: "n", Type: "u8"}, {Name: "s", Type: "str"}}
+		for _, p := range []string{
+			``,
+			`{"n":1}`,
+			`{"n":256,"s":"x"}`,
+			`{"n":-1,"s":"x"}`,
+			`{"n":1,"s":2}`,
+		} {
+			_, err := sch.FromJSON([]byte(p))
+			if err == nil {
+				t.Fatalf("expected an error converting %q", p)
+			}
+		}
+		for _, row := range [][]string{
+			{"1"},
+			{"x", "s"},
+			{"1", "s", "2"},
+		} {
+			_, err := sch.FromCSV(row)
+			if err == nil {
+				t.Fatalf("expected an error converting %q", row)
+			}
+		}
+		_, err := sch.JSON([]byte{1})
+		if err == nil {
+			t.Fatalf("expected an error converting a truncated payload")
+		}
+	})
+}
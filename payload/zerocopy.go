@@ -0,0 +1,112 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package payload // import "github.com/go-daq/tdaq/payload"
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Zero-copy serialization formats of payloads.
+const (
+	FlatBuffers = "flatbuffers"
+	CapnProto   = "capnp"
+)
+
+// ZeroCopy describes the zero-copy serialization of typed payloads, such as
+// FlatBuffers or Cap'n Proto: the fields of the payloads are read in place,
+// through an accessor over the raw frame body, without a decode copy.
+//
+// The package does not depend on the runtimes of these formats, nor does it
+// parse or check their schemas: the accessors are provided by the code
+// generated for the schema by the tools of the format, e.g.
+//
+//	payload.RegisterZeroCopy("hits", payload.ZeroCopy{
+//		Format: payload.FlatBuffers,
+//		Access: func(body []byte) (interface{}, error) {
+//			return hits.GetRootAsHits(body, 0), nil
+//		},
+//	})
+type ZeroCopy struct {
+	Format string // serialization format of the payloads (FlatBuffers, CapnProto)
+
+	// Access returns the accessor over the provided payload.
+	// Access must not copy the payload: the accessor is only valid during
+	// the execution of the input handler receiving it.
+	Access func(body []byte) (interface{}, error)
+}
+
+var zerocopies = struct {
+	sync.RWMutex
+	db map[string]ZeroCopy
+}{
+	db: make(map[string]ZeroCopy),
+}
+
+// RegisterZeroCopy registers the zero-copy serialization of the named type
+// of data frames, as declared by the end-points of the topology.
+//
+// RegisterZeroCopy panics if the format is unknown or the accessor is nil.
+func RegisterZeroCopy(typ string, zc ZeroCopy) {
+	switch zc.Format {
+	case FlatBuffers, CapnProto:
+	default:
+		panic(fmt.Errorf("payload: unknown zero-copy format %q for type %q", zc.Format, typ))
+	}
+	if zc.Access == nil {
+		panic(fmt.Errorf("payload: nil zero-copy accessor for type %q", typ))
+	}
+
+	zerocopies.Lock()
+	defer zerocopies.Unlock()
+	zerocopies.db[typ] = zc
+}
+
+// LookupZeroCopy returns the zero-copy serialization of the named type of
+// data frames.
+func LookupZeroCopy(typ string) (ZeroCopy, bool) {
+	zerocopies.RLock()
+	defer zerocopies.RUnlock()
+	zc, ok := zerocopies.db[typ]
+	return zc, ok
+}
+
+// ZeroCopyTypes returns the sorted names of the types of data frames with a
+// registered zero-copy serialization.
+func ZeroCopyTypes() []string {
+	zerocopies.RLock()
+	defer zerocopies.RUnlock()
+	names := make([]string, 0, len(zerocopies.db))
+	for name := range zerocopies.db {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AccessHandler handles the data frames of an input end-point through the
+// accessors over their payloads.
+type AccessHandler func(ctx tdaq.Context, src tdaq.Frame, v interface{}) error
+
+// Handler returns the input handler passing the accessors over the payloads
+// of the named type of data frames to h.
+//
+// Handler panics if the type has no registered zero-copy serialization.
+func Handler(typ string, h AccessHandler) tdaq.InputHandler {
+	zc, ok := LookupZeroCopy(typ)
+	if !ok {
+		panic(fmt.Errorf("payload: no zero-copy serialization for type %q", typ))
+	}
+	return func(ctx tdaq.Context, src tdaq.Frame) error {
+		v, err := zc.Access(src.Body)
+		if err != nil {
+			return fmt.Errorf("could not access %s payload of type %q: %w", zc.Format, typ, err)
+		}
+		return h(ctx, src, v)
+	}
+}
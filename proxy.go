@@ -0,0 +1,472 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/log"
+	"go.nanomsg.org/mangos/v3"
+	"go.nanomsg.org/mangos/v3/protocol/rep"
+	"go.nanomsg.org/mangos/v3/protocol/req"
+)
+
+// Proxy relays the TDAQ processes of a network segment to a run-ctl of
+// another network segment, that can not reach them directly (e.g. across
+// NAT or firewalls).
+//
+// Relayed processes /join the proxy as if it were their run-ctl.
+// The proxy opens relays for all the addresses advertised by the process,
+// and forwards the /join command, with the addresses of the relays, to
+// the run-ctl.
+// Likewise, the addresses of the producers sent by run-ctl with the /config
+// command are rewritten to the addresses of relays to those producers.
+//
+// Control links are relayed frame by frame, all other links are relayed at
+// the TCP level.
+type Proxy struct {
+	cfg  config.Proxy
+	msg  log.MsgStream
+	join mangos.Socket // /join socket served to the relayed processes
+	max  int           // maximum frame size
+
+	mu    sync.Mutex
+	outer map[string]*relay // relays to the end-points of relayed processes, indexed by target address
+	inner map[string]*relay // relays to the end-points of other processes, indexed by target address
+	ctls  []*ctlRelay
+}
+
+// NewProxy creates a new proxy, listening for TDAQ processes.
+func NewProxy(cfg config.Proxy, stdout io.Writer) (*Proxy, error) {
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+
+	p := &Proxy{
+		cfg:   cfg,
+		msg:   log.NewMsgStream(cfg.Name, cfg.Level, stdout),
+		max:   negotiateMaxFrameSize(cfg.MaxFrameSize, 0),
+		outer: make(map[string]*relay),
+		inner: make(map[string]*relay),
+	}
+
+	sck, _, err := makeListener(rep.NewSocket, cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("could not create /join socket: %w", err)
+	}
+	p.join = sck
+
+	err = setMaxFrameSize(sck, p.max)
+	if err != nil {
+		_ = sck.Close()
+		return nil, fmt.Errorf("could not set maximum frame size of /join socket: %w", err)
+	}
+
+	return p, nil
+}
+
+// Run relays TDAQ processes until the provided context is canceled.
+func (p *Proxy) Run(ctx context.Context) error {
+	p.msg.Infof("relaying processes from %q to run-ctl %q...", p.cfg.Listen, p.cfg.RunCtl)
+	defer p.close()
+
+	go func() {
+		<-ctx.Done()
+		_ = p.join.Close()
+	}()
+
+	for {
+		raw, err := RecvFrame(ctx, p.join)
+		if err != nil {
+			if errors.Is(err, mangos.ErrClosed) || ctx.Err() != nil {
+				return ctx.Err()
+			}
+			p.msg.Errorf("could not receive /join cmd: %+v", err)
+			continue
+		}
+
+		ack, err := p.handleJoin(ctx, raw)
+		if err != nil {
+			p.msg.Errorf("could not relay /join cmd: %+v", err)
+			ack = Frame{Type: FrameErr, Body: []byte(err.Error())}
+		}
+
+		err = SendFrame(ctx, p.join, ack)
+		if err != nil {
+			p.msg.Errorf("could not send /join-ack: %+v", err)
+		}
+	}
+}
+
+func (p *Proxy) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_ = p.join.Close()
+	for _, c := range p.ctls {
+		c.close()
+	}
+	for _, r := range p.outer {
+		_ = r.close()
+	}
+	for _, r := range p.inner {
+		_ = r.close()
+	}
+}
+
+// handleJoin relays the /join command of a process to run-ctl, and returns
+// the /join-ack of run-ctl.
+func (p *Proxy) handleJoin(ctx context.Context, raw Frame) (Frame, error) {
+	join, err := newJoinCmd(raw)
+	if err != nil {
+		return Frame{}, fmt.Errorf("could not decode /join cmd: %w", err)
+	}
+	p.msg.Infof("relaying process %q", join.Name)
+
+	err = p.rewriteJoin(&join)
+	if err != nil {
+		return Frame{}, fmt.Errorf("could not relay process %q: %w", join.Name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	sck, err := req.NewSocket()
+	if err != nil {
+		return Frame{}, fmt.Errorf("could not create /join socket: %w", err)
+	}
+	defer sck.Close()
+
+	err = setMaxFrameSize(sck, p.max)
+	if err != nil {
+		return Frame{}, fmt.Errorf("could not set maximum frame size of /join socket: %w", err)
+	}
+
+	err = sck.Dial(p.cfg.RunCtl)
+	if err != nil {
+		return Frame{}, fmt.Errorf("could not dial run-ctl %q: %w", p.cfg.RunCtl, err)
+	}
+
+	err = SendCmd(ctx, sck, &join)
+	if err != nil {
+		return Frame{}, fmt.Errorf("could not send /join cmd to run-ctl: %w", err)
+	}
+
+	ack, err := RecvFrame(ctx, sck)
+	if err != nil {
+		return Frame{}, fmt.Errorf("could not recv /join-ack from run-ctl: %w", err)
+	}
+	return ack, nil
+}
+
+// rewriteJoin replaces the addresses advertised by a relayed process with
+// the addresses of relays to them.
+func (p *Proxy) rewriteJoin(join *JoinCmd) error {
+	ctl, err := p.newCtlRelay(join.Name, join.Ctl)
+	if err != nil {
+		return fmt.Errorf("could not relay ctl socket: %w", err)
+	}
+	join.Ctl = ctl
+
+	for _, addr := range []*string{&join.HBeat, &join.Log} {
+		*addr, err = p.relay(p.outer, *addr, p.cfg.Public)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range join.OutEndPoints {
+		ep := &join.OutEndPoints[i]
+		ep.Addr, err = p.relay(p.outer, ep.Addr, p.cfg.Public)
+		if err != nil {
+			return fmt.Errorf("could not relay output end-point %q: %w", ep.Name, err)
+		}
+	}
+
+	for _, addrs := range []map[string]string{join.Acks, join.Credits} {
+		err = p.relayAll(p.outer, addrs, p.cfg.Public)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteConfig replaces the addresses of the producers of a relayed
+// process with the addresses of relays to them.
+func (p *Proxy) rewriteConfig(cmd *ConfigCmd) error {
+	var err error
+	for i := range cmd.InEndPoints {
+		ep := &cmd.InEndPoints[i]
+		ep.Addr, err = p.relay(p.inner, ep.Addr, p.cfg.Inner)
+		if err != nil {
+			return fmt.Errorf("could not relay input end-point %q: %w", ep.Name, err)
+		}
+	}
+
+	for _, addrs := range []map[string]string{cmd.Acks, cmd.Credits} {
+		err = p.relayAll(p.inner, addrs, p.cfg.Inner)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) relayAll(relays map[string]*relay, addrs map[string]string, host string) error {
+	for k, addr := range addrs {
+		v, err := p.relay(relays, addr, host)
+		if err != nil {
+			return fmt.Errorf("could not relay %q: %w", k, err)
+		}
+		addrs[k] = v
+	}
+	return nil
+}
+
+// relay returns the address of a TCP relay to the provided address,
+// advertised under the provided host.
+// Relays are shared among all the users of an address.
+func (p *Proxy) relay(relays map[string]*relay, addr, host string) (string, error) {
+	if addr == "" {
+		return "", nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if r, ok := relays[addr]; ok {
+		return rehost(r.addr, host)
+	}
+
+	target, err := tcpHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := newRelay(target)
+	if err != nil {
+		return "", fmt.Errorf("could not create relay to %q: %w", addr, err)
+	}
+	relays[addr] = r
+	go r.serve()
+
+	p.msg.Debugf("relaying %q via %q", addr, r.addr)
+	return rehost(r.addr, host)
+}
+
+// ctlRelay relays the control socket of a process, frame by frame, so
+// the /config commands can be rewritten.
+type ctlRelay struct {
+	name string
+	p    *Proxy
+	rep  mangos.Socket // socket served to run-ctl
+	req  mangos.Socket // socket connected to the process
+}
+
+func (p *Proxy) newCtlRelay(name, addr string) (string, error) {
+	sck, lis, err := makeListener(rep.NewSocket, "tcp://:0")
+	if err != nil {
+		return "", fmt.Errorf("could not create ctl relay socket: %w", err)
+	}
+
+	dst, err := req.NewSocket()
+	if err != nil {
+		_ = sck.Close()
+		return "", fmt.Errorf("could not create ctl socket: %w", err)
+	}
+
+	for _, s := range []mangos.Socket{sck, dst} {
+		err = setMaxFrameSize(s, p.max)
+		if err != nil {
+			_ = sck.Close()
+			_ = dst.Close()
+			return "", fmt.Errorf("could not set maximum frame size of ctl relay: %w", err)
+		}
+	}
+
+	err = dst.Dial(addr)
+	if err != nil {
+		_ = sck.Close()
+		_ = dst.Close()
+		return "", fmt.Errorf("could not dial ctl socket %q: %w", addr, err)
+	}
+
+	c := &ctlRelay{name: name, p: p, rep: sck, req: dst}
+	go c.serve()
+
+	p.mu.Lock()
+	p.ctls = append(p.ctls, c)
+	p.mu.Unlock()
+
+	return rehost(lis.Address(), p.cfg.Public)
+}
+
+func (c *ctlRelay) serve() {
+	for {
+		raw, err := c.rep.Recv()
+		if err != nil {
+			if errors.Is(err, mangos.ErrClosed) {
+				return
+			}
+			c.p.msg.Errorf("could not receive ctl frame for %q: %+v", c.name, err)
+			continue
+		}
+
+		reply, err := c.forward(raw)
+		if err != nil {
+			c.p.msg.Errorf("could not relay ctl frame to %q: %+v", c.name, err)
+			reply = Frame{Type: FrameErr, Body: []byte(err.Error())}.encode()
+		}
+
+		err = c.rep.Send(reply)
+		if err != nil {
+			if errors.Is(err, mangos.ErrClosed) {
+				return
+			}
+			c.p.msg.Errorf("could not send ctl reply from %q: %+v", c.name, err)
+		}
+	}
+}
+
+func (c *ctlRelay) forward(raw []byte) ([]byte, error) {
+	raw, err := c.rewrite(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.req.Send(raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not send ctl frame: %w", err)
+	}
+
+	reply, err := c.req.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("could not receive ctl reply: %w", err)
+	}
+	return reply, nil
+}
+
+// rewrite rewrites the addresses carried by /config commands.
+func (c *ctlRelay) rewrite(raw []byte) ([]byte, error) {
+	frame, err := RecvFrame(context.Background(), rawMsg(raw))
+	if err != nil {
+		return nil, err
+	}
+	if frame.Type != FrameCmd || len(frame.Body) == 0 || CmdType(frame.Body[0])&^cmdCompact != CmdConfig {
+		return raw, nil
+	}
+
+	cmd, err := newConfigCmd(frame)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode /config cmd: %w", err)
+	}
+
+	err = c.p.rewriteConfig(&cmd)
+	if err != nil {
+		return nil, fmt.Errorf("could not relay producers of %q: %w", c.name, err)
+	}
+
+	// the command is relayed in the encoding mode it was received in.
+	eb := getEncBuffer()
+	defer eb.release()
+	eb.enc.SetCompact(CmdType(frame.Body[0])&cmdCompact != 0)
+	cmd.encodeTDAQ(&eb.enc)
+	if err := eb.enc.err; err != nil {
+		return nil, fmt.Errorf("could not encode /config cmd: %w", err)
+	}
+	frame.Body = append([]byte{frame.Body[0]}, eb.buf.Bytes()...)
+	return frame.encode(), nil
+}
+
+func (c *ctlRelay) close() {
+	_ = c.rep.Close()
+	_ = c.req.Close()
+}
+
+// relay relays TCP connections to a target address.
+type relay struct {
+	l      net.Listener
+	target string // host:port of the relayed end-point
+	addr   string // address of the relay
+}
+
+func newRelay(target string) (*relay, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("could not listen: %w", err)
+	}
+	return &relay{l: l, target: target, addr: "tcp://" + l.Addr().String()}, nil
+}
+
+func (r *relay) serve() {
+	for {
+		conn, err := r.l.Accept()
+		if err != nil {
+			return
+		}
+		go r.pipe(conn)
+	}
+}
+
+func (r *relay) pipe(conn net.Conn) {
+	defer conn.Close()
+
+	dst, err := net.Dial("tcp", r.target)
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(dst, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, dst)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (r *relay) close() error {
+	return r.l.Close()
+}
+
+// tcpHostPort returns the host:port part of a TCP address.
+func tcpHostPort(addr string) (string, error) {
+	const scheme = "tcp://"
+	if !strings.HasPrefix(addr, scheme) {
+		return "", fmt.Errorf("could not relay %q: only tcp addresses can be relayed", addr)
+	}
+	return addr[len(scheme):], nil
+}
+
+// rehost returns the provided TCP address with its host replaced by host.
+// The address is returned unchanged if host is empty.
+func rehost(addr, host string) (string, error) {
+	hp, err := tcpHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return addr, nil
+	}
+	_, port, err := net.SplitHostPort(hp)
+	if err != nil {
+		return "", fmt.Errorf("could not parse address %q: %w", addr, err)
+	}
+	return "tcp://" + net.JoinHostPort(host, port), nil
+}
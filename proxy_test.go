@@ -0,0 +1,123 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestRehost(t *testing.T) {
+	for _, tc := range []struct {
+		addr, host string
+		want       string
+		err        bool
+	}{
+		{addr: "tcp://[::]:1234", host: "gw.example.org", want: "tcp://gw.example.org:1234"},
+		{addr: "tcp://127.0.0.1:1234", host: "::1", want: "tcp://[::1]:1234"},
+		{addr: "tcp://127.0.0.1:1234", host: "", want: "tcp://127.0.0.1:1234"},
+		{addr: "ipc:///tmp/sck", host: "gw", err: true},
+		{addr: "tcp://nope", host: "gw", err: true},
+	} {
+		t.Run(tc.addr, func(t *testing.T) {
+			got, err := rehost(tc.addr, tc.host)
+			switch {
+			case err != nil && !tc.err:
+				t.Fatalf("could not rehost address: %+v", err)
+			case err == nil && tc.err:
+				t.Fatalf("expected an error")
+			}
+			if got != tc.want {
+				t.Fatalf("invalid address: got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	srv, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not create echo server: %+v", err)
+	}
+	defer srv.Close()
+	go func() {
+		for {
+			conn, err := srv.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				_, _ = conn.Write([]byte(strings.ToUpper(line)))
+			}()
+		}
+	}()
+
+	p := &Proxy{
+		cfg:   config.Proxy{Public: "gw.example.org", Inner: "10.0.0.1"},
+		msg:   log.NewMsgStream("proxy", log.LvlError, ioutil.Discard),
+		outer: make(map[string]*relay),
+		inner: make(map[string]*relay),
+	}
+	defer func() {
+		for _, r := range p.inner {
+			_ = r.close()
+		}
+	}()
+
+	addr := "tcp://" + srv.Addr().String()
+	cmd := ConfigCmd{
+		Name: "sink",
+		InEndPoints: []EndPoint{
+			{Name: "/adc", Addr: addr},
+			{Name: "/other", Addr: addr},
+		},
+		Acks: map[string]string{"/adc": addr},
+	}
+
+	err = p.rewriteConfig(&cmd)
+	if err != nil {
+		t.Fatalf("could not rewrite /config cmd: %+v", err)
+	}
+
+	if got, want := len(p.inner), 1; got != want {
+		t.Fatalf("invalid number of relays: got=%d, want=%d", got, want)
+	}
+	r := p.inner[addr]
+	want, err := rehost(r.addr, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("could not rehost relay address: %+v", err)
+	}
+	for _, got := range []string{cmd.InEndPoints[0].Addr, cmd.InEndPoints[1].Addr, cmd.Acks["/adc"]} {
+		if got != want {
+			t.Fatalf("invalid relayed address: got=%q, want=%q", got, want)
+		}
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(r.addr, "tcp://"))
+	if err != nil {
+		t.Fatalf("could not dial relay: %+v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello\n"))
+	if err != nil {
+		t.Fatalf("could not write to relay: %+v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("could not read from relay: %+v", err)
+	}
+	if got, want := line, "HELLO\n"; got != want {
+		t.Fatalf("invalid relayed reply: got=%q, want=%q", got, want)
+	}
+}
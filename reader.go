@@ -0,0 +1,181 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/go-daq/tdaq/fsm"
+	"go.nanomsg.org/mangos/v3"
+)
+
+// reader receives the data frames of a data connection, from /config to
+// /reset.
+//
+// The connection is kept open across /stop and /start cycles, and served by
+// a single goroutine: the streams of each run are attached to the reader at
+// /start, and detached at /stop once all the producers sent their
+// end-of-stream frames.
+// While no streams are attached, the connection is quiesced: data frames
+// are left queued on the socket, until the next /start, and stale
+// end-of-stream frames of the previous run are discarded.
+type reader struct {
+	addr  string
+	sck   Recver
+	eps   []string
+	max   int               // maximum size of data frames
+	paths map[string]string // pre-allocated paths of the frames, in low-latency mode (may be nil)
+	quit  chan struct{}     // closed when the reader goroutine exits
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	ctx    Context       // context of the current run
+	mux    *demux        // streams of the current run (nil: quiesced)
+	done   chan struct{} // closed once the streams of the current run are detached
+	held   *Frame        // data frame received while quiesced, for the next run
+	closed bool
+}
+
+// newReader creates the reader of the connection to addr, serving the named
+// input end-points.
+func newReader(addr string, sck Recver, eps []string, max int, busy bool) *reader {
+	r := &reader{
+		addr: addr,
+		sck:  sck,
+		eps:  eps,
+		max:  max,
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.mu)
+	close(r.done)
+	if busy {
+		r.paths = make(map[string]string, len(eps))
+		for _, ep := range eps {
+			r.paths[ep] = ep
+		}
+	}
+	return r
+}
+
+// run receives the data frames of the connection until it is closed.
+func (r *reader) run(srv *Server) {
+	defer close(r.quit)
+
+	if r.paths != nil {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	for r.wait() {
+		frame, err := recvFrame(r.sck, r.max, r.paths)
+		if err != nil {
+			if errors.Is(err, mangos.ErrClosed) {
+				r.detach()
+				return
+			}
+			switch state := srv.getNextState(); state {
+			case fsm.Stopped:
+				// ok.
+			default:
+				srv.msg.Errorf("could not retrieve data frame for %v from %q (state=%v): %+v", r.eps, r.addr, state, err)
+			}
+			r.detach()
+			continue
+		}
+		r.dispatch(frame)
+	}
+}
+
+// wait waits for streams to be attached, and reports whether the
+// connection is still open.
+func (r *reader) wait() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.mux == nil && !r.closed {
+		r.cond.Wait()
+	}
+	if r.closed {
+		return false
+	}
+	if r.held != nil {
+		frame := *r.held
+		r.held = nil
+		r.dispatchLocked(frame)
+	}
+	return true
+}
+
+func (r *reader) dispatch(frame Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dispatchLocked(frame)
+}
+
+// dispatchLocked dispatches a data frame to the attached streams, or holds
+// it for the next run.
+// dispatchLocked must be called with r.mu held.
+func (r *reader) dispatchLocked(frame Frame) {
+	if r.mux == nil {
+		if frame.Type != FrameEOF {
+			r.held = &frame
+		}
+		return
+	}
+	r.mux.dispatch(r.ctx, frame)
+	if !r.mux.active() {
+		r.finish()
+	}
+}
+
+// attach attaches the streams of a new run.
+func (r *reader) attach(ctx Context, mux *demux) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ctx = ctx
+	r.mux = mux
+	r.done = make(chan struct{})
+	r.cond.Broadcast()
+}
+
+// detach detaches the streams of the current run, if any, once they
+// processed their queued data frames.
+func (r *reader) detach() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finish()
+}
+
+// finish must be called with r.mu held.
+func (r *reader) finish() {
+	if r.mux == nil {
+		return
+	}
+	r.mux.close()
+	r.mux = nil
+	close(r.done)
+}
+
+// stopped returns a channel closed once the streams of the current run are
+// detached.
+func (r *reader) stopped() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.done
+}
+
+// close terminates the reader goroutine.
+// The socket of the connection must be closed afterwards.
+func (r *reader) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+	r.detach()
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestReader(t *testing.T) {
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("reader", log.LvlError, ioutil.Discard),
+	}
+
+	var (
+		mu  sync.Mutex
+		got []string
+		hs  = map[string]InputHandler{
+			"/adc": func(ctx Context, src Frame) error {
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, string(src.Body))
+				return nil
+			},
+		}
+		eps = []string{"/adc"}
+	)
+
+	newMux := func() *demux {
+		return newDemux(ctx, eps, hs, nil, func(string) int { return 4 }, 0, nil, nil, nil, nil)
+	}
+	stopped := func(r *reader) bool {
+		select {
+		case <-r.stopped():
+			return true
+		default:
+			return false
+		}
+	}
+
+	r := newReader("tcp://127.0.0.1:4000", nil, eps, 0, false)
+	if !stopped(r) {
+		t.Fatalf("reader should be quiesced before the first run")
+	}
+
+	// frames of the previous run, and of the next run sent before /start.
+	r.dispatch(Frame{Type: FrameEOF, Path: "/adc"})
+	r.dispatch(Frame{Type: FrameData, Path: "/adc", Body: []byte("run-1")})
+
+	r.attach(ctx, newMux())
+	if stopped(r) {
+		t.Fatalf("reader should be running")
+	}
+	if !r.wait() {
+		t.Fatalf("reader should be open")
+	}
+	r.dispatch(Frame{Type: FrameData, Path: "/adc", Body: []byte("run-1-bis")})
+	r.dispatch(Frame{Type: FrameEOF, Path: "/adc"})
+	if !stopped(r) {
+		t.Fatalf("reader should be quiesced after end-of-stream")
+	}
+
+	r.attach(ctx, newMux())
+	r.dispatch(Frame{Type: FrameData, Path: "/adc", Body: []byte("run-2")})
+	r.detach()
+	if !stopped(r) {
+		t.Fatalf("reader should be quiesced after detach")
+	}
+
+	r.close()
+	if r.wait() {
+		t.Fatalf("reader should be closed")
+	}
+
+	want := []string{"run-1", "run-1-bis", "run-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid frames:\ngot = %q\nwant= %q\n", got, want)
+	}
+}
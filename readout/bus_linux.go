@@ -0,0 +1,92 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package readout // import "github.com/go-daq/tdaq/readout"
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// i2cSlave is the ioctl request selecting the address of an I2C device.
+const i2cSlave = 0x0703
+
+// I2C is an I2C device hardware source, read through the i2c-dev interface.
+type I2C struct {
+	f   *os.File
+	reg []byte // register to read from (empty: no register)
+	n   int    // number of bytes per sample
+}
+
+// NewI2C returns a hardware source reading n bytes from the provided
+// register of the I2C device at addr on the named bus (e.g. /dev/i2c-1).
+// A negative register reads the device without selecting a register.
+func NewI2C(bus string, addr uint16, reg int, n int) (*I2C, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("readout: invalid I2C sample size %d", n)
+	}
+	f, err := os.OpenFile(bus, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open I2C bus: %w", err)
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(addr))
+	if errno != 0 {
+		_ = f.Close()
+		return nil, fmt.Errorf("could not select I2C device 0x%x: %w", addr, errno)
+	}
+	dev := &I2C{f: f, n: n}
+	if reg >= 0 {
+		dev.reg = []byte{byte(reg)}
+	}
+	return dev, nil
+}
+
+func (dev *I2C) Sample(buf []byte) (int, error) {
+	if len(buf) < dev.n {
+		return 0, fmt.Errorf("readout: sample buffer too small (got=%d, want=%d)", len(buf), dev.n)
+	}
+	if len(dev.reg) > 0 {
+		_, err := dev.f.Write(dev.reg)
+		if err != nil {
+			return 0, fmt.Errorf("could not select I2C register: %w", err)
+		}
+	}
+	return dev.f.Read(buf[:dev.n])
+}
+
+func (dev *I2C) Close() error {
+	return dev.f.Close()
+}
+
+// SPI is an SPI device hardware source, read through the spidev interface.
+// The SPI mode and clock speed should be configured beforehand.
+type SPI struct {
+	f *os.File
+	n int // number of bytes per sample
+}
+
+// NewSPI returns a hardware source reading n bytes per sample from
+// the named SPI device (e.g. /dev/spidev0.0), with half-duplex transfers.
+func NewSPI(dev string, n int) (*SPI, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("readout: invalid SPI sample size %d", n)
+	}
+	f, err := os.OpenFile(dev, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open SPI device: %w", err)
+	}
+	return &SPI{f: f, n: n}, nil
+}
+
+func (dev *SPI) Sample(buf []byte) (int, error) {
+	if len(buf) < dev.n {
+		return 0, fmt.Errorf("readout: sample buffer too small (got=%d, want=%d)", len(buf), dev.n)
+	}
+	return dev.f.Read(buf[:dev.n])
+}
+
+func (dev *SPI) Close() error {
+	return dev.f.Close()
+}
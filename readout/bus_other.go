@@ -0,0 +1,45 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package readout // import "github.com/go-daq/tdaq/readout"
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// I2C is an I2C device hardware source.
+// I2C hardware sources are only supported on linux.
+type I2C struct{}
+
+// NewI2C returns a hardware source reading n bytes from the provided
+// register of the I2C device at addr on the named bus.
+func NewI2C(bus string, addr uint16, reg int, n int) (*I2C, error) {
+	return nil, fmt.Errorf("readout: I2C hardware sources not supported on %s", runtime.GOOS)
+}
+
+func (dev *I2C) Sample(buf []byte) (int, error) {
+	return 0, fmt.Errorf("readout: I2C hardware sources not supported on %s", runtime.GOOS)
+}
+
+func (dev *I2C) Close() error { return nil }
+
+// SPI is an SPI device hardware source.
+// SPI hardware sources are only supported on linux.
+type SPI struct{}
+
+// NewSPI returns a hardware source reading n bytes per sample from
+// the named SPI device.
+func NewSPI(dev string, n int) (*SPI, error) {
+	return nil, fmt.Errorf("readout: SPI hardware sources not supported on %s", runtime.GOOS)
+}
+
+func (dev *SPI) Sample(buf []byte) (int, error) {
+	return 0, fmt.Errorf("readout: SPI hardware sources not supported on %s", runtime.GOOS)
+}
+
+func (dev *SPI) Close() error { return nil }
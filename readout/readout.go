@@ -0,0 +1,220 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package readout provides helpers to build TDAQ devices reading out
+// embedded hardware (serial lines, I2C or SPI devices) on Raspberry-Pi
+// class hosts.
+//
+// A Loop device polls a Sampler at a fixed rate, timestamps the samples,
+// frames them in batches and publishes the frames on an output end-point.
+package readout // import "github.com/go-daq/tdaq/readout"
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/times"
+)
+
+const (
+	// DefaultMaxErrors is the default number of consecutive sampling
+	// errors after which a readout loop fails.
+	DefaultMaxErrors = 10
+
+	// errBackoff is the delay before sampling again after a sampling error.
+	errBackoff = 10 * time.Millisecond
+)
+
+// Sampler reads samples from a hardware source.
+type Sampler interface {
+	// Sample reads a sample into buf and returns the size of the sample.
+	Sample(buf []byte) (int, error)
+	// Close releases the hardware source.
+	Close() error
+}
+
+// Sample is a timestamped sample read from a hardware source.
+type Sample struct {
+	Time times.Stamp
+	Data []byte
+}
+
+// Encode encodes a batch of samples into the payload of a data frame.
+func Encode(samples []Sample) []byte {
+	buf := new(bytes.Buffer)
+	enc := tdaq.NewEncoder(buf)
+	enc.WriteU32(uint32(len(samples)))
+	for _, s := range samples {
+		enc.WriteU8(uint8(s.Time.Source))
+		enc.WriteI64(s.Time.Nanos)
+		enc.WriteBytes(s.Data)
+	}
+	return buf.Bytes()
+}
+
+// Decode decodes a batch of samples from the payload of a data frame.
+func Decode(p []byte) ([]Sample, error) {
+	dec := tdaq.NewDecoder(bytes.NewReader(p))
+	n := int(dec.ReadU32())
+	if err := dec.Err(); err != nil {
+		return nil, fmt.Errorf("could not decode number of samples: %w", err)
+	}
+	samples := make([]Sample, 0, n)
+	for i := 0; i < n; i++ {
+		var s Sample
+		s.Time.Source = times.Source(dec.ReadU8())
+		s.Time.Nanos = dec.ReadI64()
+		s.Data = dec.ReadBytes()
+		if err := dec.Err(); err != nil {
+			return nil, fmt.Errorf("could not decode sample %d: %w", i, err)
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// Loop is a TDAQ device polling a hardware source and publishing its
+// samples on an output end-point.
+//
+// Sampling errors are logged and counted: the readout loop fails after
+// MaxErrors consecutive sampling errors.
+type Loop struct {
+	Src       Sampler       // hardware source
+	Size      int           // maximum size of a sample, in bytes
+	Batch     int           // number of samples per data frame (default: 1)
+	Period    time.Duration // polling period of the hardware source (0: as fast as possible)
+	MaxErrors int           // number of consecutive sampling errors after which the loop fails (default: DefaultMaxErrors)
+	Clock     times.Clock   // clock used to timestamp samples (default: realtime)
+
+	N    uint64 // number of samples read since /init
+	Errs uint64 // number of sampling errors since /init
+
+	ch chan []byte
+}
+
+func (dev *Loop) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	return nil
+}
+
+func (dev *Loop) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	if dev.Src == nil {
+		return fmt.Errorf("readout: no hardware source")
+	}
+	if dev.Size <= 0 {
+		return fmt.Errorf("readout: invalid sample size %d", dev.Size)
+	}
+	if dev.Batch <= 0 {
+		dev.Batch = 1
+	}
+	if dev.MaxErrors <= 0 {
+		dev.MaxErrors = DefaultMaxErrors
+	}
+	if dev.Clock == nil {
+		dev.Clock = times.NewRealtime()
+	}
+	dev.N = 0
+	dev.Errs = 0
+	dev.ch = make(chan []byte)
+	return nil
+}
+
+func (dev *Loop) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.N = 0
+	dev.Errs = 0
+	dev.ch = make(chan []byte)
+	return nil
+}
+
+func (dev *Loop) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *Loop) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> n=%d, errs=%d", dev.N, dev.Errs)
+	return nil
+}
+
+func (dev *Loop) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return dev.Src.Close()
+}
+
+// Output publishes the next batch of samples.
+func (dev *Loop) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+	case dst.Body = <-dev.ch:
+	}
+	return nil
+}
+
+// Loop polls the hardware source until the end of the run.
+func (dev *Loop) Loop(ctx tdaq.Context) error {
+	var (
+		tick  <-chan time.Time
+		errs  int
+		batch = make([]Sample, 0, dev.Batch)
+	)
+	if dev.Period > 0 {
+		ticker := time.NewTicker(dev.Period)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		if tick != nil {
+			select {
+			case <-ctx.Ctx.Done():
+				return nil
+			case <-tick:
+			}
+		}
+
+		select {
+		case <-ctx.Ctx.Done():
+			return nil
+		default:
+		}
+
+		buf := make([]byte, dev.Size)
+		n, err := dev.Src.Sample(buf)
+		if err != nil {
+			dev.Errs++
+			errs++
+			if errs >= dev.MaxErrors {
+				return fmt.Errorf("readout: %d consecutive sampling errors: %w", errs, err)
+			}
+			ctx.Msg.Warnf("could not read sample: %+v", err)
+			time.Sleep(errBackoff)
+			continue
+		}
+		errs = 0
+		dev.N++
+
+		batch = append(batch, Sample{Time: dev.Clock.Now(), Data: buf[:n]})
+		if len(batch) < dev.Batch {
+			continue
+		}
+
+		select {
+		case <-ctx.Ctx.Done():
+			return nil
+		case dev.ch <- Encode(batch):
+		}
+		batch = batch[:0]
+	}
+}
+
+var (
+	_ Sampler = (*Serial)(nil)
+	_ Sampler = (*I2C)(nil)
+	_ Sampler = (*SPI)(nil)
+)
@@ -0,0 +1,146 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package readout
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/log"
+)
+
+type fakeSampler struct {
+	n    byte
+	errs int // number of errors to return before each sample
+	cur  int
+}
+
+func (s *fakeSampler) Sample(buf []byte) (int, error) {
+	if s.cur < s.errs {
+		s.cur++
+		return 0, errors.New("boom")
+	}
+	s.cur = 0
+	s.n++
+	buf[0] = s.n
+	return 1, nil
+}
+
+func (s *fakeSampler) Close() error { return nil }
+
+func TestLoop(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		errs int
+		fail bool
+	}{
+		{name: "ok"},
+		{name: "recover", errs: 2},
+		{name: "fail", errs: 3, fail: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := Loop{
+				Src:       &fakeSampler{errs: tt.errs},
+				Size:      4,
+				Batch:     3,
+				MaxErrors: 3,
+			}
+
+			ctx := tdaq.Context{
+				Ctx: context.Background(),
+				Msg: log.NewMsgStream("readout", log.LvlError, ioutil.Discard),
+			}
+			err := dev.OnInit(ctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not init device: %+v", err)
+			}
+
+			rctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ctx.Ctx = rctx
+
+			errc := make(chan error, 1)
+			go func() { errc <- dev.Loop(ctx) }()
+
+			if tt.fail {
+				select {
+				case err := <-errc:
+					if err == nil {
+						t.Fatalf("expected an error")
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timeout")
+				}
+				return
+			}
+
+			var want byte
+			for i := 0; i < 2; i++ {
+				var dst tdaq.Frame
+				err := dev.Output(ctx, &dst)
+				if err != nil {
+					t.Fatalf("could not publish samples: %+v", err)
+				}
+				samples, err := Decode(dst.Body)
+				if err != nil {
+					t.Fatalf("could not decode samples: %+v", err)
+				}
+				if got, want := len(samples), 3; got != want {
+					t.Fatalf("invalid batch size: got=%d, want=%d", got, want)
+				}
+				for _, s := range samples {
+					want++
+					if !reflect.DeepEqual(s.Data, []byte{want}) {
+						t.Fatalf("invalid sample: got=%v, want=%v", s.Data, []byte{want})
+					}
+				}
+			}
+
+			cancel()
+			err = <-errc
+			if err != nil {
+				t.Fatalf("could not run readout loop: %+v", err)
+			}
+		})
+	}
+}
+
+func TestSerialLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdaq-readout-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "tty")
+	err = ioutil.WriteFile(fname, []byte("12.5\n13.0\n"), 0644)
+	if err != nil {
+		t.Fatalf("could not create fake serial device: %+v", err)
+	}
+
+	src, err := NewSerialLines(fname, '\n')
+	if err != nil {
+		t.Fatalf("could not open serial device: %+v", err)
+	}
+	defer src.Close()
+
+	buf := make([]byte, 8)
+	for _, want := range []string{"12.5", "13.0"} {
+		n, err := src.Sample(buf)
+		if err != nil {
+			t.Fatalf("could not read sample: %+v", err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Fatalf("invalid sample: got=%q, want=%q", got, want)
+		}
+	}
+}
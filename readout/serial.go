@@ -0,0 +1,74 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package readout // import "github.com/go-daq/tdaq/readout"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Serial is a serial line hardware source.
+//
+// Samples are either fixed-size records or delimiter-terminated lines.
+// The serial line (baud rate, parity, ...) should be configured beforehand,
+// e.g. with stty(1).
+type Serial struct {
+	f     io.ReadCloser
+	r     *bufio.Reader
+	size  int  // size of fixed-size records (0: delimited records)
+	delim byte // record delimiter
+}
+
+// NewSerial returns a hardware source reading fixed-size records of size
+// bytes from the provided serial device.
+func NewSerial(dev string, size int) (*Serial, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("readout: invalid serial record size %d", size)
+	}
+	f, err := os.Open(dev)
+	if err != nil {
+		return nil, fmt.Errorf("could not open serial device: %w", err)
+	}
+	return &Serial{f: f, r: bufio.NewReader(f), size: size}, nil
+}
+
+// NewSerialLines returns a hardware source reading delim-terminated records
+// from the provided serial device.
+// The delimiter is stripped from the samples.
+func NewSerialLines(dev string, delim byte) (*Serial, error) {
+	f, err := os.Open(dev)
+	if err != nil {
+		return nil, fmt.Errorf("could not open serial device: %w", err)
+	}
+	return &Serial{f: f, r: bufio.NewReader(f), delim: delim}, nil
+}
+
+func (s *Serial) Sample(buf []byte) (int, error) {
+	if s.size > 0 {
+		if len(buf) < s.size {
+			return 0, fmt.Errorf("readout: sample buffer too small (got=%d, want=%d)", len(buf), s.size)
+		}
+		return io.ReadFull(s.r, buf[:s.size])
+	}
+
+	line, err := s.r.ReadSlice(s.delim)
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			err = fmt.Errorf("readout: serial record too long")
+		}
+		return 0, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > len(buf) {
+		return 0, fmt.Errorf("readout: sample buffer too small (got=%d, want=%d)", len(buf), len(line))
+	}
+	return copy(buf, line), nil
+}
+
+func (s *Serial) Close() error {
+	return s.f.Close()
+}
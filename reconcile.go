@@ -0,0 +1,156 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+)
+
+// Devices reconciles the TDAQ processes expected from the topology of
+// run-ctl with the TDAQ processes connected to run-ctl.
+type Devices struct {
+	Expected   []string `json:"expected"`   // processes declared in the topology
+	Connected  []string `json:"connected"`  // processes connected to run-ctl
+	Missing    []string `json:"missing"`    // expected processes that are not connected
+	Unexpected []string `json:"unexpected"` // connected processes that are not expected
+}
+
+// Devices returns the reconciliation of the expected and connected TDAQ
+// processes.
+// Without a topology, no process is expected and none is unexpected.
+func (rc *RunControl) Devices() Devices {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return rc.devices()
+}
+
+// devices must be called with rc.mu held.
+func (rc *RunControl) devices() Devices {
+	devs := Devices{
+		Expected:   []string{},
+		Connected:  []string{},
+		Missing:    []string{},
+		Unexpected: []string{},
+	}
+
+	for _, cli := range rc.clients.list() {
+		if cli.getStatus() == fsm.Exiting {
+			continue
+		}
+		devs.Connected = append(devs.Connected, cli.name)
+	}
+
+	if rc.topo == nil {
+		return devs
+	}
+
+	expected := make(map[string]bool, len(rc.topo.Procs))
+	for _, p := range rc.topo.Procs {
+		expected[p.Name] = true
+		devs.Expected = append(devs.Expected, p.Name)
+	}
+	sort.Strings(devs.Expected)
+
+	connected := make(map[string]bool, len(devs.Connected))
+	for _, name := range devs.Connected {
+		connected[name] = true
+		if !expected[name] {
+			devs.Unexpected = append(devs.Unexpected, name)
+		}
+	}
+	for _, name := range devs.Expected {
+		if !connected[name] {
+			devs.Missing = append(devs.Missing, name)
+		}
+	}
+
+	return devs
+}
+
+// reconcile periodically reconciles the expected and connected TDAQ
+// processes, reporting every change of the missing or unexpected ones.
+func (rc *RunControl) reconcile(ctx context.Context) {
+	if rc.topo == nil {
+		return
+	}
+
+	tck := rc.newTicker(rc.cfg.HBeatFreq)
+	defer tck.Stop()
+
+	var old Devices
+	for {
+		devs := rc.Devices()
+		if !reflect.DeepEqual(devs.Missing, old.Missing) || !reflect.DeepEqual(devs.Unexpected, old.Unexpected) {
+			switch {
+			case len(devs.Missing) == 0 && len(devs.Unexpected) == 0:
+				rc.msg.Infof("all %d expected processes connected", len(devs.Expected))
+			default:
+				rc.msg.Warnf("processes: missing=%q, unexpected=%q", devs.Missing, devs.Unexpected)
+			}
+			rc.feed.publish("devices", devs)
+			old = devs
+		}
+
+		select {
+		case <-rc.quit:
+			return
+		case <-ctx.Done():
+			return
+		case <-tck.C():
+		}
+	}
+}
+
+// ErrRequiredDevices is returned when a run is started while some required
+// TDAQ processes are not ready.
+var ErrRequiredDevices = errors.New("tdaq: required processes not ready")
+
+// checkRequired checks that all the processes required by the topology are
+// connected and ready to start a run.
+// Per the required-process policy, runs are refused (the default) or
+// started with a warning otherwise.
+// checkRequired must be called with rc.mu held.
+func (rc *RunControl) checkRequired() error {
+	if rc.topo == nil {
+		return nil
+	}
+
+	var probs []string
+	for _, p := range rc.topo.Procs {
+		if !p.Required {
+			continue
+		}
+		cli := rc.clients.get(p.Name)
+		if cli == nil {
+			probs = append(probs, fmt.Sprintf("%q not connected", p.Name))
+			continue
+		}
+		switch st := cli.getStatus(); st {
+		case fsm.Init, fsm.Stopped:
+			// ok.
+		default:
+			probs = append(probs, fmt.Sprintf("%q %v", p.Name, st))
+		}
+	}
+	if len(probs) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: %s", ErrRequiredDevices, strings.Join(probs, ", "))
+	if rc.cfg.Required == config.RequiredWarn {
+		rc.msg.Warnf("starting run despite required processes not ready: %s", strings.Join(probs, ", "))
+		return nil
+	}
+	rc.msg.Errorf("could not start run: %+v", err)
+	return err
+}
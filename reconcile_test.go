@@ -0,0 +1,98 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestDevices(t *testing.T) {
+	rc := &RunControl{
+		clients: newClientDB(
+			&client{name: "gen", status: fsm.Running},
+			&client{name: "extra", status: fsm.Running},
+			&client{name: "gone", status: fsm.Exiting},
+		),
+	}
+
+	want := Devices{
+		Expected:   []string{},
+		Connected:  []string{"extra", "gen"},
+		Missing:    []string{},
+		Unexpected: []string{},
+	}
+	if got := rc.Devices(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid devices without topology:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	rc.topo = &config.Topology{
+		Procs: []config.ProcTopology{{Name: "gen"}, {Name: "gone"}, {Name: "dump"}},
+	}
+	want = Devices{
+		Expected:   []string{"dump", "gen", "gone"},
+		Connected:  []string{"extra", "gen"},
+		Missing:    []string{"dump", "gone"},
+		Unexpected: []string{"extra"},
+	}
+	if got := rc.Devices(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid devices:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	report := rc.statusReport()
+	if report.Devices == nil || !reflect.DeepEqual(*report.Devices, want) {
+		t.Fatalf("invalid status report devices: %#v", report.Devices)
+	}
+}
+
+func TestRequiredDevices(t *testing.T) {
+	rc := &RunControl{
+		msg: log.NewMsgStream("run-ctl", log.LvlError+1, ioutil.Discard),
+		clients: newClientDB(
+			&client{name: "gen", status: fsm.Init},
+			&client{name: "dump", status: fsm.Stopped},
+		),
+		topo: &config.Topology{
+			Procs: []config.ProcTopology{
+				{Name: "gen", Required: true},
+				{Name: "dump"},
+				{Name: "evb", Required: true},
+			},
+		},
+	}
+
+	err := rc.checkRequired()
+	if !errors.Is(err, ErrRequiredDevices) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrRequiredDevices)
+	}
+	if got, want := err.Error(), `tdaq: required processes not ready: "evb" not connected`; got != want {
+		t.Fatalf("invalid error:\ngot = %q\nwant= %q", got, want)
+	}
+
+	rc.clients.add(&client{name: "evb", status: fsm.Error})
+	err = rc.checkRequired()
+	if got, want := err.Error(), `tdaq: required processes not ready: "evb" error`; got != want {
+		t.Fatalf("invalid error:\ngot = %q\nwant= %q", got, want)
+	}
+
+	rc.cfg.Required = config.RequiredWarn
+	err = rc.checkRequired()
+	if err != nil {
+		t.Fatalf("required processes not ready should only warn: %+v", err)
+	}
+
+	rc.cfg.Required = config.RequiredRefuse
+	rc.clients.get("evb").status = fsm.Stopped
+	err = rc.checkRequired()
+	if err != nil {
+		t.Fatalf("could not check required processes: %+v", err)
+	}
+}
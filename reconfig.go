@@ -0,0 +1,137 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+)
+
+// Reconfigurer is implemented by devices whose configuration may be updated
+// by run-ctl with /reconfig, without a full /config cycle.
+type Reconfigurer interface {
+	// OnReconfig applies the updated configuration of the device,
+	// available with Context.KV.
+	OnReconfig(ctx Context, resp *Frame, req Frame) error
+
+	// ReconfigRunning returns whether the device may be reconfigured while
+	// running.
+	ReconfigRunning() bool
+}
+
+// ReconfigHandle registers the handler of the /reconfig command, applying
+// the configuration updates pushed by run-ctl.
+// Processes may be reconfigured while configured, initialized or stopped,
+// and also while running if running is true.
+func (srv *Server) ReconfigHandle(h CmdHandler, running bool) {
+	srv.cmgr.Handle("/reconfig", h)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.reconf.ok = true
+	srv.reconf.running = running
+}
+
+// onReconfig updates the values of the key-value configuration store
+// received by the process.
+func (srv *Server) onReconfig(ctx Context, req Frame) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if !srv.reconf.ok {
+		return fmt.Errorf("%s: /reconfig not supported", srv.name)
+	}
+
+	switch srv.state.cur {
+	case fsm.Conf, fsm.Init, fsm.Stopped:
+		// ok.
+	case fsm.Running:
+		if !srv.reconf.running {
+			return fmt.Errorf("%s: /reconfig not supported while running", srv.name)
+		}
+	default:
+		return fmt.Errorf("%s: invalid /reconfig command (state=%v)", srv.name, srv.state.cur)
+	}
+
+	cmd, err := newReconfigCmd(req)
+	if err != nil {
+		return fmt.Errorf("%s: could not decode /reconfig cmd: %w", srv.name, err)
+	}
+	srv.kv.update(cmd.KVVersion, cmd.KV)
+
+	return nil
+}
+
+// Reconfig pushes the current values of the key-value configuration store
+// to the named TDAQ processes or, if none is named, to all the connected
+// TDAQ processes supporting /reconfig, without a full /config cycle.
+//
+// Processes must be configured, initialized or stopped, or running for
+// processes supporting being reconfigured while running.
+func (rc *RunControl) Reconfig(ctx context.Context, procs ...string) (err error) {
+	err = rc.control(ctx, CmdReconfig.String(), rc.targets(procs), nil)
+	if err != nil {
+		return err
+	}
+
+	defer func(beg time.Time, args, targets []string) {
+		rc.record(CmdReconfig.String(), args, beg, err)
+		rc.audit(Operator(ctx), CmdReconfig.String(), targets, nil, beg, err)
+	}(time.Now(), append([]string(nil), procs...), rc.targets(procs))
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	switch rc.status {
+	case fsm.Conf, fsm.Init, fsm.Stopped, fsm.Running:
+		// ok.
+	default:
+		return fmt.Errorf("could not /reconfig processes (state=%v)", rc.status)
+	}
+
+	if len(procs) == 0 {
+		for _, cli := range rc.clients.list() {
+			if !cli.reconfig || (rc.status == fsm.Running && !cli.liveConf) {
+				continue
+			}
+			procs = append(procs, cli.name)
+		}
+	}
+
+	rc.replies.reset(CmdReconfig)
+
+	var errs []error
+	for _, name := range procs {
+		cli := rc.clients.get(name)
+		switch {
+		case cli == nil:
+			errs = append(errs, fmt.Errorf("unknown tdaq process %q", name))
+			continue
+		case !cli.reconfig:
+			errs = append(errs, fmt.Errorf("tdaq process %q does not support /reconfig", name))
+			continue
+		case rc.status == fsm.Running && !cli.liveConf:
+			errs = append(errs, fmt.Errorf("tdaq process %q does not support /reconfig while running", name))
+			continue
+		}
+
+		var cmd ReconfigCmd
+		cmd.KVVersion, cmd.KV = rc.kv.scope(name)
+		err := rc.requestCmd(ctx, cli, &cmd)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not run /reconfig on %q: %w", name, err))
+			continue
+		}
+		rc.msg.Infof("reconfigured %q (kv-version=%d)", name, cmd.KVVersion)
+	}
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+)
+
+func TestServerReconfig(t *testing.T) {
+	srv := New(config.Process{Name: "dev"}, ioutil.Discard)
+
+	var got string
+	srv.ReconfigHandle(func(ctx Context, resp *Frame, req Frame) error {
+		got, _ = ctx.KV("threshold")
+		return nil
+	}, false)
+	srv.cmgr.init()
+
+	reconfig := func(v string) Frame {
+		t.Helper()
+		raw, err := ReconfigCmd{KVVersion: 2, KV: map[string]string{"threshold": v}}.MarshalTDAQ()
+		if err != nil {
+			t.Fatalf("could not marshal /reconfig: %+v", err)
+		}
+		req := localCmd(CmdReconfig)
+		req.Body = append(req.Body, raw...)
+		resp, _ := srv.runCmd(context.Background(), req)
+		return resp
+	}
+
+	srv.setCurState(fsm.Stopped)
+	resp := reconfig("12")
+	if resp.Type != FrameOK {
+		t.Fatalf("could not /reconfig: %s", resp.Body)
+	}
+	if want := "12"; got != want {
+		t.Fatalf("invalid reconfigured value:\ngot = %q\nwant= %q", got, want)
+	}
+	if got, want := srv.getCurState(), fsm.Stopped; got != want {
+		t.Fatalf("invalid state:\ngot = %v\nwant= %v", got, want)
+	}
+
+	srv.setCurState(fsm.Running)
+	resp = reconfig("13")
+	if resp.Type != FrameErr {
+		t.Fatalf("expected an error reconfiguring a running process")
+	}
+	if got, want := srv.getCurState(), fsm.Running; got != want {
+		t.Fatalf("invalid state:\ngot = %v\nwant= %v", got, want)
+	}
+	if v, _ := srv.kv.get("threshold"); v != "12" {
+		t.Fatalf("invalid value after rejected /reconfig: got=%q, want=%q", v, "12")
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// CmdRecord is a command issued by run-ctl, as recorded for replay.
+//
+// Besides the FSM commands (e.g. "/config", "/start"), run-ctl records
+// "/reconfig" (arguments: processes), "/debug" (arguments: debug arguments,
+// then processes), "/kv-set" (arguments: key, value, user) and "/kv-del"
+// (arguments: key, user).
+type CmdRecord struct {
+	Time time.Time     `json:"time"`            // time at which the command was issued
+	Dur  time.Duration `json:"duration"`        // duration of the execution of the command
+	Cmd  string        `json:"cmd"`             // name of the command
+	Args []string      `json:"args,omitempty"`  // arguments of the command
+	Err  string        `json:"error,omitempty"` // error returned by the command, if any
+}
+
+// Command names of the recorded changes of the key-value store.
+const (
+	recKVSet = "/kv-set"
+	recKVDel = "/kv-del"
+)
+
+// recorder records the commands issued by run-ctl to a file, as a stream of
+// JSON documents.
+type recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newRecorder(fname string) (*recorder, error) {
+	if fname == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open command record file %q: %w", fname, err)
+	}
+	return &recorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record records the command issued at beg, with its outcome.
+// record is a no-op for nil recorders.
+func (rec *recorder) record(cmd string, args []string, beg time.Time, err error) error {
+	if rec == nil {
+		return nil
+	}
+
+	r := CmdRecord{
+		Time: beg.UTC(),
+		Dur:  time.Since(beg),
+		Cmd:  cmd,
+		Args: args,
+	}
+	if err != nil {
+		r.Err = err.Error()
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.enc.Encode(r)
+}
+
+func (rec *recorder) close() error {
+	if rec == nil {
+		return nil
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.f.Close()
+}
+
+// record records the command issued by run-ctl at beg, if run-ctl was
+// configured with a command record file.
+func (rc *RunControl) record(cmd string, args []string, beg time.Time, err error) {
+	rerr := rc.rec.record(cmd, args, beg, err)
+	if rerr != nil {
+		rc.msg.Warnf("could not record command %s: %+v", cmd, rerr)
+	}
+}
+
+// Replay re-issues the sequence of commands recorded in r, against the
+// connected TDAQ processes.
+//
+// Commands are issued with the delays between them in the recording,
+// divided by speed, or one after the other if speed is not positive.
+// Replay stops at the first command whose outcome (success or failure)
+// differs from the recorded one.
+func (rc *RunControl) Replay(ctx context.Context, r io.Reader, speed float64) error {
+	var (
+		dec  = json.NewDecoder(r)
+		prev time.Time
+	)
+	for i := 0; ; i++ {
+		var rec CmdRecord
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not decode command record #%d: %w", i, err)
+		}
+
+		if speed > 0 && !prev.IsZero() {
+			delay := time.Duration(float64(rec.Time.Sub(prev)) / speed)
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		prev = rec.Time
+
+		rc.msg.Infof("replaying command #%d: %s %q", i, rec.Cmd, rec.Args)
+		err = rc.replay(ctx, rec)
+		switch {
+		case err == nil && rec.Err != "":
+			return fmt.Errorf("replayed command #%d %s succeeded (recorded error: %s)", i, rec.Cmd, rec.Err)
+		case err != nil && rec.Err == "":
+			return fmt.Errorf("replayed command #%d %s failed: %w", i, rec.Cmd, err)
+		}
+	}
+}
+
+func (rc *RunControl) replay(ctx context.Context, rec CmdRecord) error {
+	args := func(n int) error {
+		if len(rec.Args) < n {
+			return fmt.Errorf("invalid command record %s: got %d arguments, want %d", rec.Cmd, len(rec.Args), n)
+		}
+		return nil
+	}
+
+	switch rec.Cmd {
+	case "/reconfig":
+		return rc.Reconfig(ctx, rec.Args...)
+	case "/debug":
+		err := args(1)
+		if err != nil {
+			return err
+		}
+		return rc.Debug(ctx, rec.Args[0], rec.Args[1:]...)
+	case recKVSet:
+		err := args(3)
+		if err != nil {
+			return err
+		}
+		_, err = rc.KVSet(rec.Args[0], rec.Args[1], rec.Args[2])
+		return err
+	case recKVDel:
+		err := args(2)
+		if err != nil {
+			return err
+		}
+		_, err = rc.KVDelete(rec.Args[0], rec.Args[1])
+		return err
+	}
+
+	cmd, err := cmdTypeFrom(rec.Cmd)
+	if err != nil {
+		return fmt.Errorf("invalid command record: %w", err)
+	}
+	return rc.Do(ctx, cmd)
+}
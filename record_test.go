@@ -0,0 +1,102 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdaq-record-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "cmds.json")
+	newRC := func(fname string) *RunControl {
+		t.Helper()
+		kv, err := newKVDB("", nil)
+		if err != nil {
+			t.Fatalf("could not create key-value store: %+v", err)
+		}
+		rec, err := newRecorder(fname)
+		if err != nil {
+			t.Fatalf("could not create recorder: %+v", err)
+		}
+		return &RunControl{
+			msg: log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard),
+			kv:  kv,
+			rec: rec,
+		}
+	}
+
+	rc := newRC(fname)
+	for _, kv := range [][2]string{
+		{"adc/threshold", "10"},
+		{"adc/gain", "2"},
+		{"adc/threshold", "12"},
+	} {
+		_, err := rc.KVSet(kv[0], kv[1], "bob")
+		if err != nil {
+			t.Fatalf("could not set %q: %+v", kv[0], err)
+		}
+	}
+	_, err = rc.KVDelete("adc/gain", "alice")
+	if err != nil {
+		t.Fatalf("could not delete key: %+v", err)
+	}
+	_, err = rc.KVDelete("tdc/window", "alice")
+	if err == nil {
+		t.Fatalf("expected an error deleting an unknown key")
+	}
+	err = rc.rec.close()
+	if err != nil {
+		t.Fatalf("could not close recorder: %+v", err)
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatalf("could not open record file: %+v", err)
+	}
+	defer f.Close()
+
+	clone := newRC("")
+	err = clone.Replay(context.Background(), f, 0)
+	if err != nil {
+		t.Fatalf("could not replay commands: %+v", err)
+	}
+
+	_, want := rc.KV("")
+	_, got := clone.KV("")
+	for i := range got {
+		got[i].Time = want[i].Time
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid replayed store:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatalf("could not rewind record file: %+v", err)
+	}
+	other := newRC("")
+	_, err = other.KVSet("tdc/window", "25ns", "bob")
+	if err != nil {
+		t.Fatalf("could not set key: %+v", err)
+	}
+	err = other.Replay(context.Background(), f, 0)
+	if err == nil {
+		t.Fatalf("expected a replay mismatch")
+	}
+}
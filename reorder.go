@@ -0,0 +1,164 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"fmt"
+	"time"
+)
+
+// GapHandler is notified of the range [first, last] of sequence numbers
+// skipped by the reordering stage of an input end-point.
+type GapHandler func(ctx Context, first, last uint64)
+
+// Reorder delivers the data frames of an input end-point to its handler in
+// increasing order of their sequence number, as returned by seq, for
+// consumers needing ordered frames over unordered transports.
+//
+// Frames received ahead of a missing frame are held until it arrives, for
+// at most window frames and maxDelay (0: unbounded).
+// The missing frames are then skipped, and reported to the handler set
+// with OnGap.
+// Frames received after their sequence number was delivered or skipped
+// are dropped.
+func Reorder(seq func(frame Frame) uint64, window int, maxDelay time.Duration) InputOption {
+	return func(w *inputWorkers) {
+		w.seq = seq
+		w.rwin = window
+		w.rdelay = maxDelay
+	}
+}
+
+// OnGap sets the handler notified of the frames skipped by the reordering
+// stage of an input end-point.
+func OnGap(h GapHandler) InputOption {
+	return func(w *inputWorkers) {
+		w.gap = h
+	}
+}
+
+// resolveReorder validates the reordering stage of the named input
+// end-point.
+func (w *inputWorkers) resolveReorder(name string) error {
+	switch {
+	case w.seq == nil && (w.rwin != 0 || w.rdelay != 0 || w.gap != nil):
+		return fmt.Errorf("reordering of input end-point %q requires a sequence number", name)
+	case w.seq == nil:
+		return nil
+	case w.rwin <= 0:
+		return fmt.Errorf("invalid reordering window %d for input end-point %q", w.rwin, name)
+	case w.rdelay < 0:
+		return fmt.Errorf("invalid reordering delay %v for input end-point %q", w.rdelay, name)
+	case w.order == OrderNone:
+		return fmt.Errorf("reordered input end-point %q requires ordered workers", name)
+	}
+	return nil
+}
+
+// reorder is the reordering stage of an input end-point.
+type reorder struct {
+	seq    func(frame Frame) uint64
+	window int
+	gap    GapHandler
+
+	started bool   // whether a frame was delivered
+	next    uint64 // sequence number of the next frame to deliver
+	pending map[uint64]reorderItem
+}
+
+type reorderItem struct {
+	frame Frame
+	acked bool // whether the frame was delivered in acknowledged mode
+	recv  time.Time
+}
+
+func newReorder(w inputWorkers) *reorder {
+	if w.seq == nil {
+		return nil
+	}
+	return &reorder{
+		seq:     w.seq,
+		window:  w.rwin,
+		gap:     w.gap,
+		pending: make(map[uint64]reorderItem, w.rwin),
+	}
+}
+
+// push holds the provided data frame, and delivers all the frames now in
+// order.
+// push returns false if the frame was received too late, and dropped.
+func (ro *reorder) push(ctx Context, frame Frame, acked bool, deliver func(frame Frame, acked bool)) bool {
+	seq := ro.seq(frame)
+	if !ro.started {
+		ro.started = true
+		ro.next = seq
+	}
+	if _, dup := ro.pending[seq]; dup || seq < ro.next {
+		return false
+	}
+
+	ro.pending[seq] = reorderItem{frame: frame, acked: acked, recv: ctx.timeSource().Now()}
+	ro.release(deliver)
+	for len(ro.pending) > ro.window {
+		ro.skip(ctx, deliver)
+	}
+	return true
+}
+
+// expire skips the missing frames of the frames held since before the
+// provided deadline.
+func (ro *reorder) expire(ctx Context, deadline time.Time, deliver func(frame Frame, acked bool)) {
+	for len(ro.pending) > 0 {
+		expired := false
+		for _, item := range ro.pending {
+			if item.recv.Before(deadline) {
+				expired = true
+				break
+			}
+		}
+		if !expired {
+			return
+		}
+		ro.skip(ctx, deliver)
+	}
+}
+
+// flush delivers all the held frames, skipping the missing ones.
+func (ro *reorder) flush(ctx Context, deliver func(frame Frame, acked bool)) {
+	for len(ro.pending) > 0 {
+		ro.skip(ctx, deliver)
+	}
+}
+
+// skip skips the missing frames up to the first held one, and delivers
+// all the frames now in order.
+func (ro *reorder) skip(ctx Context, deliver func(frame Frame, acked bool)) {
+	first := true
+	min := ro.next
+	for seq := range ro.pending {
+		if first || seq < min {
+			min = seq
+			first = false
+		}
+	}
+	if min > ro.next && ro.gap != nil {
+		ro.gap(ctx, ro.next, min-1)
+	}
+	ro.next = min
+	ro.release(deliver)
+}
+
+// release delivers the held frames following the last delivered one.
+func (ro *reorder) release(deliver func(frame Frame, acked bool)) {
+	for {
+		item, ok := ro.pending[ro.next]
+		if !ok {
+			return
+		}
+		delete(ro.pending, ro.next)
+		ro.next++
+		deliver(item.frame, item.acked)
+	}
+}
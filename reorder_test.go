@@ -0,0 +1,142 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestReorder(t *testing.T) {
+	ctx := Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("reorder", log.LvlError, ioutil.Discard),
+	}
+	seq := func(frame Frame) uint64 { return frame.EventID }
+
+	for _, tc := range []struct {
+		name   string
+		window int
+		delay  time.Duration
+		ids    []uint64
+		after  []uint64 // frames sent after the reordering delay
+		want   []uint64
+		gaps   [][2]uint64
+		late   uint64
+	}{
+		{
+			name:   "in-order",
+			window: 4,
+			ids:    []uint64{1, 2, 3, 4},
+			want:   []uint64{1, 2, 3, 4},
+		},
+		{
+			name:   "reordered",
+			window: 4,
+			ids:    []uint64{1, 3, 2, 5, 4, 6},
+			want:   []uint64{1, 2, 3, 4, 5, 6},
+		},
+		{
+			name:   "window",
+			window: 2,
+			ids:    []uint64{1, 3, 4, 5, 2, 6},
+			want:   []uint64{1, 3, 4, 5, 6},
+			gaps:   [][2]uint64{{2, 2}},
+			late:   1,
+		},
+		{
+			name:   "flush",
+			window: 8,
+			ids:    []uint64{10, 12, 15, 13},
+			want:   []uint64{10, 12, 13, 15},
+			gaps:   [][2]uint64{{11, 11}, {14, 14}},
+		},
+		{
+			name:   "delay",
+			window: 8,
+			delay:  time.Millisecond,
+			ids:    []uint64{1, 3},
+			after:  []uint64{2, 4},
+			want:   []uint64{1, 3, 4},
+			gaps:   [][2]uint64{{2, 2}},
+			late:   1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				got  []uint64
+				gaps [][2]uint64
+				hs   = map[string]InputHandler{
+					"/adc": func(ctx Context, src Frame) error {
+						got = append(got, src.EventID)
+						return nil
+					},
+				}
+				st = newRunStats()
+				w  = inputWorkers{}
+			)
+			for _, opt := range []InputOption{
+				Reorder(seq, tc.window, tc.delay),
+				OnGap(func(ctx Context, first, last uint64) {
+					gaps = append(gaps, [2]uint64{first, last})
+				}),
+			} {
+				opt(&w)
+			}
+			err := w.resolve("/adc")
+			if err != nil {
+				t.Fatalf("could not resolve workers: %+v", err)
+			}
+
+			mux := newDemux(ctx, []string{"/adc"}, hs, map[string]inputWorkers{"/adc": w}, func(string) int { return 8 }, 0, nil, nil, nil, st)
+			for _, id := range tc.ids {
+				mux.dispatch(ctx, Frame{Type: FrameData, Path: "/adc", Body: []byte("data"), EventID: id})
+			}
+			if tc.delay > 0 {
+				time.Sleep(20 * tc.delay)
+			}
+			for _, id := range tc.after {
+				mux.dispatch(ctx, Frame{Type: FrameData, Path: "/adc", Body: []byte("data"), EventID: id})
+			}
+			mux.dispatch(ctx, Frame{Type: FrameEOF})
+			mux.close()
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid delivered frames:\ngot = %v\nwant= %v", got, tc.want)
+			}
+			if !reflect.DeepEqual(gaps, tc.gaps) {
+				t.Fatalf("invalid gaps:\ngot = %v\nwant= %v", gaps, tc.gaps)
+			}
+			if got, want := st.snapshot().Dropped, tc.late; got != want {
+				t.Fatalf("invalid number of late frames: got=%d, want=%d", got, want)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []InputOption
+	}{
+		{"no-seq", []InputOption{OnGap(func(Context, uint64, uint64) {})}},
+		{"no-window", []InputOption{Reorder(seq, 0, 0)}},
+		{"negative-delay", []InputOption{Reorder(seq, 2, -time.Second)}},
+		{"unordered", []InputOption{Reorder(seq, 2, 0), Order(OrderNone)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := inputWorkers{}
+			for _, opt := range tc.opts {
+				opt(&w)
+			}
+			if err := w.resolve("/adc"); err == nil {
+				t.Fatalf("expected an error")
+			}
+		})
+	}
+}
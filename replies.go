@@ -0,0 +1,125 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Replies holds the payloads the TDAQ processes attached to their ACK of
+// the last execution of a command, indexed by process name.
+//
+// Command handlers attach a payload to their ACK by filling the body of
+// the response frame.
+type Replies struct {
+	Cmd   string            `json:"cmd"`
+	Procs map[string][]byte `json:"procs"`
+}
+
+// MarshalJSON implements json.Marshaler.
+// Payloads holding valid JSON are embedded as is, other payloads as
+// strings.
+func (rs Replies) MarshalJSON() ([]byte, error) {
+	procs := make(map[string]json.RawMessage, len(rs.Procs))
+	for name, body := range rs.Procs {
+		if len(body) > 0 && json.Valid(body) {
+			procs[name] = json.RawMessage(body)
+			continue
+		}
+		raw, err := json.Marshal(string(body))
+		if err != nil {
+			return nil, err
+		}
+		procs[name] = raw
+	}
+	return json.Marshal(struct {
+		Cmd   string                     `json:"cmd"`
+		Procs map[string]json.RawMessage `json:"procs"`
+	}{rs.Cmd, procs})
+}
+
+// replyDB collects the replies of the TDAQ processes to the commands sent
+// by run-ctl.
+type replyDB struct {
+	mu   sync.RWMutex
+	cmds map[CmdType]map[string][]byte
+}
+
+func newReplyDB() *replyDB {
+	return &replyDB{cmds: make(map[CmdType]map[string][]byte)}
+}
+
+// reset discards the replies to the previous execution of the command.
+func (db *replyDB) reset(cmd CmdType) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.cmds[cmd] = make(map[string][]byte)
+}
+
+func (db *replyDB) add(cmd CmdType, name string, body []byte) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	procs, ok := db.cmds[cmd]
+	if !ok {
+		procs = make(map[string][]byte)
+		db.cmds[cmd] = procs
+	}
+	procs[name] = append([]byte(nil), body...)
+}
+
+func (db *replyDB) get(cmd CmdType) Replies {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	rs := Replies{
+		Cmd:   cmd.String(),
+		Procs: make(map[string][]byte, len(db.cmds[cmd])),
+	}
+	for name, body := range db.cmds[cmd] {
+		rs.Procs[name] = append([]byte(nil), body...)
+	}
+	return rs
+}
+
+// Replies returns the replies of the TDAQ processes to the last execution
+// of the provided command.
+func (rc *RunControl) Replies(cmd CmdType) Replies {
+	return rc.replies.get(cmd)
+}
+
+// cmdTypeFrom returns the command type of the provided command path
+// (e.g. "/config").
+func cmdTypeFrom(path string) (CmdType, error) {
+	for i, name := range cmdNames {
+		if string(name) == path {
+			return CmdType(i), nil
+		}
+	}
+	return CmdUnknown, fmt.Errorf("invalid command %q", path)
+}
+
+// webAPIReplies replies with a JSON report of the replies of the processes
+// to the last execution of the command of the "cmd" form value.
+func (rc *RunControl) webAPIReplies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cmd, err := cmdTypeFrom(r.FormValue("cmd"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(rc.Replies(cmd))
+	if err != nil {
+		rc.msg.Errorf("could not encode %v replies: %+v", cmd, err)
+		return
+	}
+}
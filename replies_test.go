@@ -0,0 +1,68 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestReplies(t *testing.T) {
+	rc := &RunControl{
+		msg:     log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard),
+		replies: newReplyDB(),
+	}
+
+	rc.replies.add(CmdConfig, "old", []byte("stale"))
+	rc.newTransition(CmdConfig)
+	rc.replies.add(CmdConfig, "adc", []byte(`{"hash":"f00"}`))
+	rc.replies.add(CmdConfig, "tdc", []byte("b4r"))
+	rc.replies.add(CmdConfig, "daq", nil)
+	rc.replies.add(CmdInit, "adc", []byte("init"))
+
+	got := rc.Replies(CmdConfig)
+	want := Replies{
+		Cmd: "/config",
+		Procs: map[string][]byte{
+			"adc": []byte(`{"hash":"f00"}`),
+			"tdc": []byte("b4r"),
+			"daq": nil,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid replies:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(rc.webAPIReplies))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?cmd=/config")
+	if err != nil {
+		t.Fatalf("could not get replies: %+v", err)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("could not read replies: %+v", err)
+	}
+	if got, want := strings.TrimSpace(string(raw)), `{"cmd":"/config","procs":{"adc":{"hash":"f00"},"daq":"","tdc":"b4r"}}`; got != want {
+		t.Fatalf("invalid JSON replies:\ngot = %s\nwant= %s", got, want)
+	}
+
+	resp, err = http.Get(srv.URL + "?cmd=/nope")
+	if err != nil {
+		t.Fatalf("could not get replies: %+v", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusBadRequest; got != want {
+		t.Fatalf("invalid status code: got=%d, want=%d", got, want)
+	}
+}
@@ -0,0 +1,436 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/go-daq/tdaq/graphql"
+	"golang.org/x/net/websocket"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700 // invalid JSON
+	rpcInvalidRequest = -32600 // JSON is not a valid request object
+	rpcNoMethod       = -32601 // method does not exist
+	rpcInvalidParams  = -32602 // invalid method parameters
+	rpcInternalError  = -32603 // internal JSON-RPC error
+
+	rpcCmdError  = -32000 // command failed
+	rpcNoControl = -32001 // operator does not hold the control token
+)
+
+// rpcRequest is a JSON-RPC 2.0 request.
+// Requests without an ID are notifications, and are not replied to.
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response.
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcError is a JSON-RPC 2.0 error object.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *rpcError) Error() string {
+	return fmt.Sprintf("json-rpc error %d: %s", err.Code, err.Message)
+}
+
+// serveRPC serves the JSON-RPC 2.0 control protocol of run-ctl over TCP.
+//
+// Clients send a stream of JSON-RPC requests (or batches of requests),
+// possibly separated by white spaces, and receive the responses as a
+// stream of newline-delimited JSON documents.
+// Requests of a connection are handled in order.
+//
+// The methods of the protocol are:
+//   - config, init, reset, start, stop, pause, resume, quit: the FSM
+//     commands, sent to all the connected TDAQ processes,
+//   - status: the /status command, replying with the status report of
+//     run-ctl and of its processes,
+//   - reconfig {procs}: the /reconfig command,
+//   - debug {args, procs}: the /debug command,
+//   - state: the status of run-ctl,
+//   - graph: the dataflow graph of the processes,
+//   - devices: the reconciliation of the expected and connected processes,
+//   - alarms: the active alarms of the processes,
+//   - audit {operator, cmd, target, since, until, limit}: the audit log,
+//   - control, control.take {force}, control.release: the control token,
+//   - query {query, operationName, variables}: a GraphQL query (see Query).
+//
+// Parameters are passed by name.
+// Requests are issued on behalf of the operator of the connection: "rpc@"
+// followed by the remote host.
+// Each connection is a control session (see ControlToken): the control
+// token taken by a connection is only held by that connection.
+func (rc *RunControl) serveRPC(ctx context.Context) {
+	if rc.rpc == nil {
+		return
+	}
+
+	rc.msg.Infof("starting JSON-RPC run-ctl server on %q...", rc.rpc.Addr())
+	for {
+		conn, err := rc.rpc.Accept()
+		if err != nil {
+			select {
+			case <-rc.quit:
+				// ok, we are shutting down.
+				return
+			case <-ctx.Done():
+				// ok, we are shutting down.
+				return
+			default:
+			}
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Temporary() {
+				rc.msg.Warnf("could not accept JSON-RPC connection: %+v", err)
+				continue
+			}
+			rc.msg.Errorf("error running JSON-RPC run-ctl server: %+v", err)
+			return
+		}
+		go rc.serveRPCConn(ctx, conn)
+	}
+}
+
+func (rc *RunControl) serveRPCConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(WithControl(ctx, ""))
+	defer cancel()
+
+	go func() {
+		select {
+		case <-rc.quit:
+		case <-ctx.Done():
+		}
+		conn.Close()
+	}()
+
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		host = conn.RemoteAddr().String()
+	}
+	operator := "rpc@" + host
+
+	var (
+		dec = json.NewDecoder(conn)
+		enc = json.NewEncoder(conn)
+	)
+	for {
+		var (
+			raw  json.RawMessage
+			resp interface{}
+		)
+		err := dec.Decode(&raw)
+		switch {
+		case err == nil:
+			resp = rc.rpcHandle(ctx, operator, raw)
+		case errors.Is(err, io.EOF):
+			return
+		default:
+			var serr *json.SyntaxError
+			if !errors.As(err, &serr) {
+				// connection closed or broken.
+				return
+			}
+			// the stream can not be resynchronized after a parse error.
+			_ = enc.Encode(rpcErrorResponse(nil, rpcParseError, err.Error()))
+			return
+		}
+		if resp == nil {
+			continue
+		}
+		err = enc.Encode(resp)
+		if err != nil {
+			rc.msg.Errorf("could not send JSON-RPC response to %q: %+v", operator, err)
+			return
+		}
+	}
+}
+
+// webRPC serves the JSON-RPC 2.0 control protocol of run-ctl over a
+// websocket: each message carries a request (or a batch of requests), and
+// is replied to with a message carrying the response(s), if any.
+// The operator and the control session of the requests are the ones of the
+// web request opening the websocket.
+func (rc *RunControl) webRPC(ws *websocket.Conn) {
+	defer ws.Close()
+
+	ctx, cancel := context.WithCancel(webControl(ws.Request()))
+	defer cancel()
+
+	go func() {
+		select {
+		case <-rc.quit:
+		case <-ctx.Done():
+		}
+		ws.Close()
+	}()
+
+	operator := webOperator(ws.Request())
+	for {
+		var raw []byte
+		err := websocket.Message.Receive(ws, &raw)
+		if err != nil {
+			return
+		}
+
+		resp := rc.rpcHandle(ctx, operator, raw)
+		if resp == nil {
+			continue
+		}
+		err = websocket.JSON.Send(ws, resp)
+		if err != nil {
+			rc.msg.Errorf("could not send JSON-RPC response to websocket client: %+v", err)
+			return
+		}
+	}
+}
+
+// rpcHandle handles the provided JSON-RPC request, or batch of requests,
+// issued by operator.
+// rpcHandle returns nil if nothing should be replied.
+func (rc *RunControl) rpcHandle(ctx context.Context, operator string, raw []byte) interface{} {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '[' {
+		resp := rc.rpcCall(ctx, operator, raw)
+		if resp == nil {
+			return nil
+		}
+		return resp
+	}
+
+	var batch []json.RawMessage
+	err := json.Unmarshal(raw, &batch)
+	if err != nil {
+		return rpcErrorResponse(nil, rpcParseError, err.Error())
+	}
+	if len(batch) == 0 {
+		return rpcErrorResponse(nil, rpcInvalidRequest, "empty batch")
+	}
+
+	var resps []*rpcResponse
+	for _, req := range batch {
+		resp := rc.rpcCall(ctx, operator, req)
+		if resp == nil {
+			continue
+		}
+		resps = append(resps, resp)
+	}
+	if len(resps) == 0 {
+		return nil
+	}
+	return resps
+}
+
+// rpcCall executes the provided JSON-RPC request.
+// rpcCall returns nil for notifications.
+func (rc *RunControl) rpcCall(ctx context.Context, operator string, raw []byte) *rpcResponse {
+	var req rpcRequest
+	err := json.Unmarshal(raw, &req)
+	if err != nil {
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			return rpcErrorResponse(nil, rpcParseError, err.Error())
+		}
+		return rpcErrorResponse(nil, rpcInvalidRequest, err.Error())
+	}
+	if req.Version != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, rpcInvalidRequest, "invalid JSON-RPC 2.0 request")
+	}
+
+	v, err := rc.rpcDo(WithOperator(ctx, operator), req.Method, req.Params)
+	if req.ID == nil {
+		if err != nil {
+			rc.msg.Errorf("could not run JSON-RPC notification %q of %q: %+v", req.Method, operator, err)
+		}
+		return nil
+	}
+
+	if err != nil {
+		var rerr *rpcError
+		switch {
+		case errors.As(err, &rerr):
+			return rpcErrorResponse(req.ID, rerr.Code, rerr.Message)
+		case errors.Is(err, errNoControl):
+			return rpcErrorResponse(req.ID, rpcNoControl, err.Error())
+		default:
+			return rpcErrorResponse(req.ID, rpcCmdError, err.Error())
+		}
+	}
+
+	res, err := json.Marshal(v)
+	if err != nil {
+		return rpcErrorResponse(req.ID, rpcInternalError, err.Error())
+	}
+	return &rpcResponse{Version: "2.0", Result: res, ID: req.ID}
+}
+
+// rpcDo runs the named JSON-RPC method with the provided parameters.
+func (rc *RunControl) rpcDo(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "config":
+		return nil, rc.Do(ctx, CmdConfig)
+	case "init":
+		return nil, rc.Do(ctx, CmdInit)
+	case "reset":
+		return nil, rc.Do(ctx, CmdReset)
+	case "start":
+		return nil, rc.Do(ctx, CmdStart)
+	case "stop":
+		return nil, rc.Do(ctx, CmdStop)
+	case "pause":
+		return nil, rc.Do(ctx, CmdPause)
+	case "resume":
+		return nil, rc.Do(ctx, CmdResume)
+	case "quit":
+		return nil, rc.Do(ctx, CmdQuit)
+
+	case "status":
+		err := rc.Do(ctx, CmdStatus)
+		if err != nil {
+			return nil, err
+		}
+		rc.mu.RLock()
+		defer rc.mu.RUnlock()
+		return rc.statusReport(), nil
+
+	case "reconfig":
+		var args struct {
+			Procs []string `json:"procs"`
+		}
+		err := rpcDecode(params, &args)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		return nil, rc.Reconfig(ctx, args.Procs...)
+
+	case "debug":
+		var args struct {
+			Args  string   `json:"args"`
+			Procs []string `json:"procs"`
+		}
+		err := rpcDecode(params, &args)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		return nil, rc.Debug(ctx, args.Args, args.Procs...)
+
+	case "state":
+		rc.mu.RLock()
+		defer rc.mu.RUnlock()
+		return rc.status.String(), nil
+
+	case "graph":
+		return rc.Graph(), nil
+
+	case "devices":
+		return rc.Devices(), nil
+
+	case "alarms":
+		return rc.Alarms(), nil
+
+	case "audit":
+		var args struct {
+			Operator string    `json:"operator"`
+			Cmd      string    `json:"cmd"`
+			Target   string    `json:"target"`
+			Since    time.Time `json:"since"`
+			Until    time.Time `json:"until"`
+			Limit    int       `json:"limit"`
+		}
+		err := rpcDecode(params, &args)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		entries := rc.Audit(AuditQuery(args))
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+		return entries, nil
+
+	case "control":
+		return rc.Control(), nil
+
+	case "control.take":
+		var args struct {
+			Force bool `json:"force"`
+		}
+		err := rpcDecode(params, &args)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		_, err = rc.TakeControl(ctx, args.Force)
+		if err != nil {
+			return nil, err
+		}
+		return rc.Control(), nil
+
+	case "control.release":
+		err := rc.ReleaseControl(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return rc.Control(), nil
+
+	case "query":
+		var req graphql.Request
+		err := rpcDecode(params, &req)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		if req.Query == "" {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "missing query"}
+		}
+		return rc.Query(req), nil
+
+	default:
+		return nil, &rpcError{Code: rpcNoMethod, Message: fmt.Sprintf("unknown method %q", method)}
+	}
+}
+
+// rpcDecode decodes the by-name parameters of a JSON-RPC request into v.
+// Missing parameters leave v untouched.
+func rpcDecode(params json.RawMessage, v interface{}) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	if params[0] != '{' {
+		return fmt.Errorf("parameters must be passed by name")
+	}
+	return json.Unmarshal(params, v)
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, msg string) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{
+		Version: "2.0",
+		Error:   &rpcError{Code: code, Message: msg},
+		ID:      id,
+	}
+}
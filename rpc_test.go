@@ -0,0 +1,180 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/log"
+)
+
+func newRPCTestRunControl() *RunControl {
+	return &RunControl{
+		quit:    make(chan struct{}),
+		cfg:     config.RunCtl{Name: "run-ctl"},
+		status:  fsm.Conf,
+		msg:     log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard),
+		clients: newClientDB(),
+		feed:    newFeed(),
+		audits:  &auditLog{},
+	}
+}
+
+func TestRPCHandle(t *testing.T) {
+	rc := newRPCTestRunControl()
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		req  string
+		want string
+	}{
+		{
+			name: "state",
+			req:  `{"jsonrpc": "2.0", "method": "state", "id": 1}`,
+			want: `{"jsonrpc":"2.0","result":"configured","id":1}`,
+		},
+		{
+			name: "null-id",
+			req:  `{"jsonrpc": "2.0", "method": "state", "id": null}`,
+			want: `{"jsonrpc":"2.0","result":"configured","id":null}`,
+		},
+		{
+			name: "notification",
+			req:  `{"jsonrpc": "2.0", "method": "state"}`,
+			want: `null`,
+		},
+		{
+			name: "query",
+			req:  `{"jsonrpc": "2.0", "method": "query", "params": {"query": "{ state }"}, "id": "q"}`,
+			want: `{"jsonrpc":"2.0","result":{"data":{"state":"configured"}},"id":"q"}`,
+		},
+		{
+			name: "batch",
+			req:  `[{"jsonrpc": "2.0", "method": "state", "id": 1}, {"jsonrpc": "2.0", "method": "state"}, {"jsonrpc": "2.0", "method": "nope", "id": 2}]`,
+			want: `[{"jsonrpc":"2.0","result":"configured","id":1},{"jsonrpc":"2.0","error":{"code":-32601,"message":"unknown method \"nope\""},"id":2}]`,
+		},
+		{
+			name: "batch-notifications",
+			req:  `[{"jsonrpc": "2.0", "method": "state"}]`,
+			want: `null`,
+		},
+		{
+			name: "empty-batch",
+			req:  `[]`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"empty batch"},"id":null}`,
+		},
+		{
+			name: "parse-error",
+			req:  `{"jsonrpc": "2.0", "method"`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32700,"message":"unexpected end of JSON input"},"id":null}`,
+		},
+		{
+			name: "invalid-version",
+			req:  `{"jsonrpc": "1.0", "method": "state", "id": 1}`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid JSON-RPC 2.0 request"},"id":1}`,
+		},
+		{
+			name: "by-position",
+			req:  `{"jsonrpc": "2.0", "method": "reconfig", "params": ["p1"], "id": 1}`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32602,"message":"parameters must be passed by name"},"id":1}`,
+		},
+		{
+			name: "missing-query",
+			req:  `{"jsonrpc": "2.0", "method": "query", "params": {}, "id": 1}`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32602,"message":"missing query"},"id":1}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := json.Marshal(rc.rpcHandle(ctx, "rpc@host", []byte(tc.req)))
+			if err != nil {
+				t.Fatalf("could not marshal response: %+v", err)
+			}
+			if got, want := string(raw), tc.want; got != want {
+				t.Fatalf("invalid response:\ngot= %s\nwant=%s", got, want)
+			}
+		})
+	}
+}
+
+func TestRPCConn(t *testing.T) {
+	rc := newRPCTestRunControl()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cli, srv := net.Pipe()
+	defer cli.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rc.serveRPCConn(ctx, srv)
+	}()
+
+	var (
+		enc = json.NewEncoder(cli)
+		dec = json.NewDecoder(bufio.NewReader(cli))
+	)
+	call := func(method, params string) rpcResponse {
+		t.Helper()
+		req := rpcRequest{Version: "2.0", Method: method, ID: json.RawMessage("1")}
+		if params != "" {
+			req.Params = json.RawMessage(params)
+		}
+		err := enc.Encode(req)
+		if err != nil {
+			t.Fatalf("could not send %q request: %+v", method, err)
+		}
+		var resp rpcResponse
+		err = dec.Decode(&resp)
+		if err != nil {
+			t.Fatalf("could not receive %q response: %+v", method, err)
+		}
+		return resp
+	}
+
+	// the operator of the requests is the one of the connection.
+	resp := call("control.take", `{"operator": "alice"}`)
+	if resp.Error != nil {
+		t.Fatalf("could not take control token: %+v", resp.Error)
+	}
+	var tok ControlToken
+	err := json.Unmarshal(resp.Result, &tok)
+	if err != nil {
+		t.Fatalf("could not decode control token: %+v", err)
+	}
+	if got, want := tok.Operator, "rpc@pipe"; got != want {
+		t.Fatalf("invalid control token holder: got=%q, want=%q", got, want)
+	}
+
+	_, err = rc.TakeControl(WithControl(WithOperator(ctx, "bob"), ""), true)
+	if err != nil {
+		t.Fatalf("could not forcibly take control token: %+v", err)
+	}
+	resp = call("control.release", "")
+	if resp.Error == nil || resp.Error.Code != rpcNoControl {
+		t.Fatalf("invalid error for release of control token of another operator: %+v", resp.Error)
+	}
+
+	resp = call("audit", `{"cmd": "/control-take", "limit": 1}`)
+	var entries []AuditEntry
+	err = json.Unmarshal(resp.Result, &entries)
+	if err != nil {
+		t.Fatalf("could not decode audit log: %+v", err)
+	}
+	if len(entries) != 1 || entries[0].Operator != "bob" {
+		t.Fatalf("invalid audit log: %+v", entries)
+	}
+
+	close(rc.quit)
+	<-done
+}
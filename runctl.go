@@ -6,24 +6,26 @@ package tdaq // import "github.com/go-daq/tdaq"
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/go-daq/tdaq/config"
 	"github.com/go-daq/tdaq/fsm"
 	"github.com/go-daq/tdaq/internal/dflow"
-	"github.com/go-daq/tdaq/internal/iomux"
+	"github.com/go-daq/tdaq/iomux"
 	"github.com/go-daq/tdaq/log"
 	"go.nanomsg.org/mangos/v3"
 	"go.nanomsg.org/mangos/v3/protocol/rep"
 	"go.nanomsg.org/mangos/v3/protocol/req"
 	"go.nanomsg.org/mangos/v3/protocol/xsub"
-	"golang.org/x/net/websocket"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -31,29 +33,57 @@ type RunControl struct {
 	quit chan struct{}
 	cfg  config.RunCtl
 
-	srv *ctlsrv // ctl server
-	web websrv  // web server
+	srv *ctlsrv      // ctl server
+	web websrv       // web server
+	obs websrv       // read-only observer web server
+	rpc net.Listener // JSON-RPC control server (may be nil)
 
 	stdout io.Writer
 
 	mu        sync.RWMutex
 	status    fsm.Status
 	msg       log.MsgStream
-	clients   map[string]*client
-	dag       *dflow.Graph // DAG of data dependencies b/w processes
-	deps      []string     // dep-ordered list of tdaq processes
+	clients   *clientDB        // connected tdaq processes
+	dag       *dflow.Graph     // DAG of data dependencies b/w processes
+	deps      []string         // dep-ordered list of tdaq processes
+	topo      *config.Topology // expected topology of the tdaq processes (may be nil)
 	listening bool
 
-	msgch chan MsgFrame // messages from log server
-	flog  *iomux.Writer
-
-	runNbr uint64
+	msgch   chan MsgFrame // messages from log server
+	logs    *logTail      // recent log messages of the tdaq processes
+	flog    *iomux.Writer
+	feed    *feed // live feed of status, log and monitoring events
+	elog    logbooks
+	alerts  *alerter               // alert notifications
+	alarms  *alarmDB               // alarms raised by the tdaq processes
+	halts   chan AlarmFrame        // alarms requesting to stop the current run
+	replies *replyDB               // replies of the tdaq processes to the commands
+	kv      *kvDB                  // key-value configuration store of the tdaq processes
+	ext     map[string]ConfigField // extension fields of the /config commands
+	calibs  *calibDB               // calibration constants published by the tdaq processes
+	rec     *recorder              // record of the issued commands (may be nil)
+	audits  *auditLog              // audit log of the control actions of the operators
+	hooks   *hooks                 // callbacks of embedders on run-ctl events
+	ctl     controlToken           // control token of the operators
+	snapmu  sync.Mutex             // serializes the snapshots of the partition
+	clock   TimeSource             // source of the time and tickers of run-ctl
+
+	runNbr   uint64
+	runStart time.Time    // start time of the current run
+	totals   *RunTotals   // run-wide statistics of the last stopped run
+	runs     []RunSummary // summaries of the last stopped runs, oldest first
 }
 
 func NewRunControl(cfg config.RunCtl, stdout io.Writer) (*RunControl, error) {
 	if stdout == nil {
 		stdout = os.Stdout
 	}
+	switch cfg.Required {
+	case "", config.RequiredRefuse, config.RequiredWarn:
+	default:
+		return nil, fmt.Errorf("tdaq: invalid required-process policy %q", cfg.Required)
+	}
+
 	fname := cfg.LogFile
 	if fname == "" {
 		fname = fmt.Sprintf("log-tdaq-runctl-%v.txt", time.Now().UTC().Format("2006-01-150405"))
@@ -70,6 +100,9 @@ func NewRunControl(cfg config.RunCtl, stdout io.Writer) (*RunControl, error) {
 	if cfg.HBeatFreq <= 0 {
 		cfg.HBeatFreq = 5 * time.Second
 	}
+	if cfg.SummaryDir == "" {
+		cfg.SummaryDir = filepath.Dir(fname)
+	}
 
 	rc := &RunControl{
 		quit:      make(chan struct{}),
@@ -77,12 +110,44 @@ func NewRunControl(cfg config.RunCtl, stdout io.Writer) (*RunControl, error) {
 		stdout:    out,
 		status:    fsm.UnConf,
 		msg:       log.NewMsgStream(cfg.Name, cfg.Level, out),
-		clients:   make(map[string]*client),
+		clients:   newClientDB(),
 		dag:       dflow.New(),
 		listening: true,
 		runNbr:    uint64(time.Now().UTC().Unix()),
 		flog:      iomux.NewWriter(flog),
 		msgch:     make(chan MsgFrame, 1024),
+		feed:      newFeed(),
+		logs:      newLogTail(),
+		replies:   newReplyDB(),
+		halts:     make(chan AlarmFrame, 1),
+		clock:     wallClock{},
+	}
+	rc.calibs = newCalibDB(rc.feed)
+	rc.hooks = newHooks(rc.msg)
+	rc.alerts = newAlerter(rc.msg, cfg.AlertWindow)
+	rc.alarms, err = newAlarmDB(cfg.AlarmFile, rc.feed)
+	if err != nil {
+		return nil, fmt.Errorf("could not create alarms: %w", err)
+	}
+	rc.kv, err = newKVDB(cfg.KVFile, rc.feed)
+	if err != nil {
+		return nil, fmt.Errorf("could not create key-value store: %w", err)
+	}
+	rc.rec, err = newRecorder(cfg.Record)
+	if err != nil {
+		return nil, fmt.Errorf("could not create command recorder: %w", err)
+	}
+	rc.audits, err = newAuditLog(cfg.AuditFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not create audit log: %w", err)
+	}
+
+	if cfg.Topology != "" {
+		topo, err := config.LoadTopology(cfg.Topology)
+		if err != nil {
+			return nil, fmt.Errorf("could not load topology: %w", err)
+		}
+		rc.topo = &topo
 	}
 
 	rc.msg.Infof("listening on %q...", cfg.RunCtl)
@@ -91,15 +156,29 @@ func NewRunControl(cfg config.RunCtl, stdout io.Writer) (*RunControl, error) {
 		return nil, fmt.Errorf("could not start ctl-srv: %w", err)
 	}
 
+	err = setMaxFrameSize(rc.srv.join, negotiateMaxFrameSize(cfg.MaxFrameSize, 0))
+	if err != nil {
+		return nil, fmt.Errorf("could not set maximum frame size of ctl-srv: %w", err)
+	}
+
 	if cfg.Web != "" {
-		mux := http.NewServeMux()
-		mux.HandleFunc("/", rc.webHome)
-		mux.HandleFunc("/cmd", rc.webCmd)
-		mux.Handle("/status", websocket.Handler(rc.webStatus))
-		mux.Handle("/msg", websocket.Handler(rc.webMsg))
 		rc.web = &http.Server{
 			Addr:    cfg.Web,
-			Handler: mux,
+			Handler: rc.webMux(false),
+		}
+	}
+
+	if cfg.Observer != "" {
+		rc.obs = &http.Server{
+			Addr:    cfg.Observer,
+			Handler: rc.webMux(true),
+		}
+	}
+
+	if cfg.RPC != "" {
+		rc.rpc, err = net.Listen("tcp", cfg.RPC)
+		if err != nil {
+			return nil, fmt.Errorf("could not start JSON-RPC server: %w", err)
 		}
 	}
 
@@ -108,10 +187,7 @@ func NewRunControl(cfg config.RunCtl, stdout io.Writer) (*RunControl, error) {
 
 // NumClients returns the number of TDAQ processes connected to this run control.
 func (rc *RunControl) NumClients() int {
-	rc.mu.RLock()
-	n := len(rc.clients)
-	rc.mu.RUnlock()
-	return n
+	return rc.clients.len()
 }
 
 func (rc *RunControl) Run(ctx context.Context) error {
@@ -121,6 +197,15 @@ func (rc *RunControl) Run(ctx context.Context) error {
 
 	go rc.serveCtl(ctx)
 	go rc.serveWeb(ctx)
+	go rc.serveObserver(ctx)
+	go rc.serveRPC(ctx)
+	go rc.serveFeed(ctx)
+	go rc.watchDisk(ctx)
+	go rc.watchdog(ctx)
+	go rc.watchHalts(ctx)
+	go rc.advertise(ctx)
+	go rc.reconcile(ctx)
+	go rc.hooks.loop(ctx, rc.quit)
 
 	var err error
 
@@ -158,25 +243,44 @@ func (rc *RunControl) close() {
 	defer rc.mu.Unlock()
 	rc.msg.Infof("closing...")
 
-	for _, c := range rc.clients {
+	for _, c := range rc.clients.list() {
 		err := c.close()
 		if err != nil {
 			rc.msg.Errorf("could not close proc to %q: %+v", c.name, err)
 		}
-		delete(rc.clients, c.name)
+		rc.clients.del(c.name)
 	}
-	rc.clients = nil
 
 	err := rc.srv.Close()
 	if err != nil {
 		rc.msg.Errorf("could not close run-ctl cmd server: %+v", err)
 	}
 
+	rc.elog.wait()
+	rc.alerts.wait()
+
 	err = rc.flog.Close()
 	if err != nil {
 		rc.msg.Errorf("could not close run-ctl log file: %+v", err)
 	}
 
+	err = rc.rec.close()
+	if err != nil {
+		rc.msg.Errorf("could not close run-ctl command record file: %+v", err)
+	}
+
+	err = rc.audits.close()
+	if err != nil {
+		rc.msg.Errorf("could not close run-ctl audit log file: %+v", err)
+	}
+
+	if rc.rpc != nil {
+		err := rc.rpc.Close()
+		if err != nil {
+			rc.msg.Errorf("could not close run-ctl JSON-RPC server: %+v", err)
+		}
+	}
+
 	if rc.web != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -185,6 +289,15 @@ func (rc *RunControl) close() {
 			rc.msg.Errorf("could not close run-ctl web server: %+v", err)
 		}
 	}
+
+	if rc.obs != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := rc.obs.Shutdown(ctx)
+		if err != nil {
+			rc.msg.Errorf("could not close run-ctl observer web server: %+v", err)
+		}
+	}
 }
 
 func (rc *RunControl) serveCtl(ctx context.Context) {
@@ -224,6 +337,17 @@ func (rc *RunControl) handleCtlConn(ctx context.Context) {
 		return
 	}
 
+	if raw.Type == FrameCmd && len(raw.Body) > 0 {
+		switch CmdType(raw.Body[0]) {
+		case CmdLeave:
+			rc.handleLeave(ctx, string(raw.Body[1:]))
+			return
+		case CmdCalib:
+			rc.handleCalib(ctx, raw)
+			return
+		}
+	}
+
 	join, err := newJoinCmd(raw)
 	if err != nil {
 		rc.msg.Errorf("could not decode /join cmd: %+v", err)
@@ -233,6 +357,10 @@ func (rc *RunControl) handleCtlConn(ctx context.Context) {
 
 	rc.msg.Infof("received /join cmd")
 	rc.msg.Infof("  proc: %q", join.Name)
+	proto := negotiateProto(join.Proto)
+	rc.msg.Infof("   - proto: v%d", proto)
+	maxFrame := negotiateMaxFrameSize(rc.cfg.MaxFrameSize, int(join.MaxFrameSize))
+	rc.msg.Infof("   - max-frame-size: %d", maxFrame)
 	if len(join.InEndPoints) > 0 {
 		rc.msg.Infof("   - inputs:")
 		for _, p := range join.InEndPoints {
@@ -259,11 +387,8 @@ func (rc *RunControl) handleCtlConn(ctx context.Context) {
 		}
 	}
 
-	rc.mu.Lock()
-	defer rc.mu.Unlock()
-
-	err = rc.checkDAG(ctx, join)
-	if err != nil {
+	if rc.clients.get(join.Name) != nil {
+		err = fmt.Errorf("duplicate tdaq process with name %q", join.Name)
 		rc.msg.Errorf("could not validate /join from %q: %+v", join.Name, err)
 		err = SendFrame(ctx, rc.srv.join, Frame{Type: FrameErr, Body: []byte(err.Error())})
 		if err != nil {
@@ -272,6 +397,8 @@ func (rc *RunControl) handleCtlConn(ctx context.Context) {
 		return
 	}
 
+	// the sockets to the process are dialed without holding the run-ctl
+	// lock, so joining processes do not stall transitions nor status reports.
 	ctl, err := req.NewSocket()
 	if err != nil {
 		rc.msg.Errorf("could not create /cmd socket for %q: %+v", join.Name, err)
@@ -282,6 +409,16 @@ func (rc *RunControl) handleCtlConn(ctx context.Context) {
 		return
 	}
 
+	err = setMaxFrameSize(ctl, maxFrame)
+	if err != nil {
+		rc.msg.Errorf("could not set maximum frame size of /cmd socket for %q: %+v", join.Name, err)
+		err = SendFrame(ctx, rc.srv.join, Frame{Type: FrameErr, Body: []byte(err.Error())})
+		if err != nil {
+			rc.msg.Errorf("could not send /join-ack err to %q: %+v", join.Name, err)
+		}
+		return
+	}
+
 	err = ctl.Dial(join.Ctl)
 	if err != nil {
 		rc.msg.Errorf("could not dial /cmd socket (%s) for %q: %+v", join.Ctl, join.Name, err)
@@ -292,34 +429,125 @@ func (rc *RunControl) handleCtlConn(ctx context.Context) {
 		return
 	}
 
-	log, err := rc.setupLog(join.Name, join.Log)
+	log, err := rc.setupLog(join.Name, join.Log, maxFrame)
 	if err != nil {
 		rc.msg.Errorf("could not setup /log cmd: %+v", err)
 		_ = SendFrame(ctx, rc.srv.join, Frame{Type: FrameErr, Body: []byte(err.Error())})
 		return
 	}
 
-	hbeat, err := rc.setupHBeat(join.Name, join.HBeat)
+	hbeat, err := rc.setupHBeat(join.Name, join.HBeat, maxFrame)
 	if err != nil {
 		rc.msg.Errorf("could not setup /hbeat cmd: %+v", err)
 		_ = SendFrame(ctx, rc.srv.join, Frame{Type: FrameErr, Body: []byte(err.Error())})
 		return
 	}
 
-	rc.clients[join.Name] = newClient(
-		ctx, rc.msg, rc.cfg.HBeatFreq,
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	err = rc.checkDAG(ctx, join)
+	if err != nil {
+		rc.msg.Errorf("could not validate /join from %q: %+v", join.Name, err)
+		_ = ctl.Close()
+		_ = hbeat.Close()
+		_ = log.Close()
+		err = SendFrame(ctx, rc.srv.join, Frame{Type: FrameErr, Body: []byte(err.Error())})
+		if err != nil {
+			rc.msg.Errorf("could not send /join-ack err to %q: %+v", join.Name, err)
+		}
+		return
+	}
+
+	cli := newClient(
+		ctx, rc.msg, rc.clock, rc.cfg.HBeatFreq,
 		join,
 		ctl, hbeat, log,
-		rc.msgch, rc.flog,
+		rc.msgch, rc.flog, rc.feed,
 	)
+	cli.maxFrame = maxFrame
+	cli.alerts = rc.alerts
+	cli.alarms = rc.alarms
+	cli.halts = rc.halts
+	cli.hooks = rc.hooks
+	rc.clients.add(cli)
 	rc.deps = append(rc.deps, join.Name)
 
-	ackOK := Frame{Type: FrameOK}
+	ack := make([]byte, 5)
+	ack[0] = proto
+	binary.LittleEndian.PutUint32(ack[1:], uint32(maxFrame))
+	ackOK := Frame{Type: FrameOK, Body: ack}
 	err = SendFrame(ctx, rc.srv.join, ackOK)
 	if err != nil {
 		rc.msg.Errorf("could not send /join-ack to %q: %+v", join.Name, err)
 		return
 	}
+	rc.hooks.deviceJoin(join.Name)
+}
+
+// handleLeave deregisters the named process, which is exiting.
+func (rc *RunControl) handleLeave(ctx context.Context, name string) {
+	rc.msg.Infof("received /leave cmd from %q", name)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	cli := rc.clients.get(name)
+	if cli == nil {
+		err := fmt.Errorf("unknown tdaq process %q", name)
+		rc.msg.Errorf("could not handle /leave: %+v", err)
+		_ = SendFrame(ctx, rc.srv.join, Frame{Type: FrameErr, Body: []byte(err.Error())})
+		return
+	}
+
+	cli.kill()
+	err := cli.close()
+	if err != nil {
+		rc.msg.Warnf("could not close proc %q: %+v", name, err)
+	}
+	rc.clients.del(name)
+
+	deps := rc.deps[:0]
+	for _, v := range rc.deps {
+		if v != name {
+			deps = append(deps, v)
+		}
+	}
+	rc.deps = deps
+	rc.hooks.deviceLost(name, "left run-ctl")
+
+	err = SendFrame(ctx, rc.srv.join, Frame{Type: FrameOK})
+	if err != nil {
+		rc.msg.Errorf("could not send /leave-ack to %q: %+v", name, err)
+	}
+}
+
+// setStatus sets the status of the run-ctl and publishes it on the live feed.
+// setStatus must be called with rc.mu held.
+func (rc *RunControl) setStatus(status fsm.Status) {
+	old := rc.status
+	rc.status = status
+	rc.feed.publish("status", rc.statusReport())
+	if old != status {
+		rc.hooks.stateChange(old, status)
+	}
+}
+
+// feedbackAddrs returns the addresses of the feedback sockets of the
+// producers of the provided input end-points, indexed by end-point name.
+func feedbackAddrs(ieps []EndPoint, addrs map[string]string) map[string]string {
+	var o map[string]string
+	for _, ep := range ieps {
+		addr, ok := addrs[ep.Name]
+		if !ok {
+			continue
+		}
+		if o == nil {
+			o = make(map[string]string)
+		}
+		o[ep.Name] = addr
+	}
+	return o
 }
 
 func (rc *RunControl) checkDAG(ctx context.Context, cmd JoinCmd) error {
@@ -348,7 +576,7 @@ func (rc *RunControl) checkDAG(ctx context.Context, cmd JoinCmd) error {
 	return nil
 }
 
-func (rc *RunControl) setupLog(name, client string) (mangos.Socket, error) {
+func (rc *RunControl) setupLog(name, client string, maxFrame int) (mangos.Socket, error) {
 	sck, err := xsub.NewSocket()
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -357,6 +585,14 @@ func (rc *RunControl) setupLog(name, client string) (mangos.Socket, error) {
 		)
 	}
 
+	err = setMaxFrameSize(sck, maxFrame)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"could not set maximum frame size of log-srv socket for client %s: %w",
+			name, err,
+		)
+	}
+
 	err = sck.Dial(client)
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -368,7 +604,7 @@ func (rc *RunControl) setupLog(name, client string) (mangos.Socket, error) {
 	return sck, nil
 }
 
-func (rc *RunControl) setupHBeat(name, client string) (mangos.Socket, error) {
+func (rc *RunControl) setupHBeat(name, client string, maxFrame int) (mangos.Socket, error) {
 	sck, err := req.NewSocket()
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -377,6 +613,14 @@ func (rc *RunControl) setupHBeat(name, client string) (mangos.Socket, error) {
 		)
 	}
 
+	err = setMaxFrameSize(sck, maxFrame)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"could not set maximum frame size of hbeat-srv socket for client %s: %w",
+			name, err,
+		)
+	}
+
 	err = sck.Dial(client)
 	if err != nil {
 		return nil, fmt.Errorf(
@@ -389,43 +633,90 @@ func (rc *RunControl) setupHBeat(name, client string) (mangos.Socket, error) {
 }
 
 func (rc *RunControl) broadcast(ctx context.Context, cmd CmdType) error {
-	var berr []error
+	var (
+		cerr = &CmdError{Cmd: cmd}
+		tr   = rc.newTransition(cmd)
+	)
 
-	for _, name := range rc.deps {
-		cli := rc.clients[name]
-		err := sendCmd(ctx, cli.cmd, cmd, nil)
-		if err != nil {
-			rc.msg.Errorf("could not send cmd %v to %q: %+v", cmd, cli.name, err)
-			berr = append(berr, err)
-			continue
-		}
-		ack, err := RecvFrame(ctx, cli.cmd)
-		if err != nil {
-			rc.msg.Errorf("could not receive %v ACK from %q: %+v", cmd, cli.name, err)
-			berr = append(berr, err)
-			continue
-		}
-		switch ack.Type {
-		case FrameOK:
+	// processes of a dependency level receive the command concurrently,
+	// after the processes of the previous levels.
+	for _, lvl := range rc.levels() {
+		rc.dispatch(lvl, cerr, func(cli *client) error {
+			err := tr.do(cli, func() error {
+				return rc.command(ctx, cli, cmd)
+			})
+			if err != nil {
+				return err
+			}
 			if cmd == CmdQuit {
 				cli.kill()
 			}
-		case FrameErr:
-			rc.msg.Errorf("received ERR ACK from %q: %v", cli.name, string(ack.Body))
-			berr = append(berr, fmt.Errorf(string(ack.Body)))
-		default:
-			rc.msg.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
-			berr = append(berr, fmt.Errorf("received invalid frame type %v from %q", ack.Type, cli.name))
-		}
-		rc.msg.Debugf("sending cmd %v to %q... [ok]", cmd, cli.name)
+			rc.msg.Debugf("sending cmd %v to %q... [ok]", cmd, cli.name)
+			return nil
+		})
 	}
 
-	// FIXME(sbinet): better handling
-	if len(berr) > 0 {
-		return berr[0]
+	if tr.timedOut() {
+		return rc.abort(ctx, tr)
 	}
 
-	return nil
+	return cerr.err()
+}
+
+// command sends the provided command to a process and waits for its ACK.
+func (rc *RunControl) command(ctx context.Context, cli *client, cmd CmdType) error {
+	var body []byte
+	if cmd == CmdStart && cli.barrier {
+		body = []byte{startArm}
+	}
+	return rc.request(ctx, cli, cmd, body)
+}
+
+// request sends the provided command and body to a process and waits for
+// its ACK.
+func (rc *RunControl) request(ctx context.Context, cli *client, cmd CmdType, body []byte) error {
+	return rc.send(ctx, cli, cmd, func(ctx context.Context, sck mangos.Socket) error {
+		return sendCmd(ctx, sck, cmd, body)
+	})
+}
+
+// requestCmd sends the provided command to a process and waits for its ACK.
+// The command is encoded according to the version of the TDAQ wire protocol
+// negotiated with the process.
+func (rc *RunControl) requestCmd(ctx context.Context, cli *client, cmd Cmder) error {
+	return rc.send(ctx, cli, cmd.CmdType(), func(ctx context.Context, sck mangos.Socket) error {
+		return sendCmdProto(ctx, sck, cmd, cli.proto)
+	})
+}
+
+// send sends a command to a process with the provided function and waits
+// for its ACK.
+// Processes reported lost are not waited for longer than lostTimeout, so an
+// unresponsive process can not block run-ctl.
+func (rc *RunControl) send(ctx context.Context, cli *client, cmd CmdType, f func(ctx context.Context, sck mangos.Socket) error) error {
+	if d := rc.lostTimeout(); d > 0 && cli.isLost() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	ack, err := cli.exchange(ctx, func(sck mangos.Socket) error {
+		return f(ctx, sck)
+	})
+	if err != nil {
+		rc.msg.Errorf("could not exchange cmd %v with %q: %+v", cmd, cli.name, err)
+		return err
+	}
+	switch ack.Type {
+	case FrameOK:
+		rc.replies.add(cmd, cli.name, ack.Body)
+		return nil
+	case FrameErr:
+		rc.msg.Errorf("received ERR ACK from %q: %v", cli.name, string(ack.Body))
+		return fmt.Errorf(string(ack.Body))
+	default:
+		rc.msg.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
+		return fmt.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
+	}
 }
 
 // Do sends the provided command to all connected TDAQ processes.
@@ -442,6 +733,10 @@ func (rc *RunControl) Do(ctx context.Context, cmd CmdType) error {
 		fct = rc.doStart
 	case CmdStop:
 		fct = rc.doStop
+	case CmdPause:
+		fct = rc.doPause
+	case CmdResume:
+		fct = rc.doResume
 	case CmdQuit:
 		fct = rc.doQuit
 	case CmdStatus:
@@ -450,7 +745,20 @@ func (rc *RunControl) Do(ctx context.Context, cmd CmdType) error {
 		return fmt.Errorf("unknown command %#v", cmd)
 	}
 
-	return fct(ctx)
+	var (
+		beg     = time.Now()
+		targets = rc.targets(nil)
+	)
+	if cmd != CmdStatus {
+		err := rc.control(ctx, cmd.String(), targets, nil)
+		if err != nil {
+			return err
+		}
+	}
+	err := fct(ctx)
+	rc.record(cmd.String(), nil, beg, err)
+	rc.audit(Operator(ctx), cmd.String(), targets, nil, beg, err)
+	return err
 }
 
 func (rc *RunControl) doConfig(ctx context.Context) error {
@@ -458,15 +766,34 @@ func (rc *RunControl) doConfig(ctx context.Context) error {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 
-	providers := make(map[string]string)
-	for _, cli := range rc.clients {
+	var (
+		providers = make(map[string]string)
+		orders    = make(map[string]string) // byte orders of the payloads of output end-points
+		acks      = make(map[string]string) // ack sockets of output end-points in acknowledged mode
+		credits   = make(map[string]string) // credit sockets of output end-points under flow control
+		services  = make(map[string]string) // services sockets of processes
+	)
+	for _, cli := range rc.clients.list() {
 		for _, oport := range cli.oeps {
 			providers[oport.Name] = oport.Addr
+			orders[oport.Name] = byteOrderOf(cli.orders, oport.Name)
+		}
+		for ep, addr := range cli.acks {
+			acks[ep] = addr
+		}
+		for ep, addr := range cli.credits {
+			credits[ep] = addr
+		}
+		if cli.svcs != "" {
+			services[cli.name] = cli.svcs
 		}
 	}
 
-	clients := make([]string, 0, len(rc.clients))
-	for _, cli := range rc.clients {
+	encs := rc.encodings()
+	links := rc.compactLinks()
+
+	clients := make([]string, 0, rc.clients.len())
+	for _, cli := range rc.clients.list() {
 		for i := range cli.ieps {
 			iport := &cli.ieps[i]
 			provider, ok := providers[iport.Name]
@@ -474,6 +801,10 @@ func (rc *RunControl) doConfig(ctx context.Context) error {
 				rc.msg.Errorf("could not find a provider for input %q for %q", iport.Name, cli.name)
 				return fmt.Errorf("could not find a provider for input %q for %q", iport.Name, cli.name)
 			}
+			if got, want := byteOrderOf(cli.orders, iport.Name), orders[iport.Name]; got != want {
+				rc.msg.Errorf("input %q of %q expects %s payloads, but its provider sends %s payloads", iport.Name, cli.name, got, want)
+				return fmt.Errorf("input %q of %q expects %s payloads, but its provider sends %s payloads", iport.Name, cli.name, got, want)
+			}
 			cli.mu.Lock()
 			iport.Addr = provider
 			cli.mu.Unlock()
@@ -481,53 +812,118 @@ func (rc *RunControl) doConfig(ctx context.Context) error {
 		clients = append(clients, cli.name)
 	}
 
-	var grp errgroup.Group
+	// data frames sent by a process must fit within the maximum frame size
+	// of all the consumers of its outputs.
+	consumers := make(map[string][]*client)
+	for _, cli := range rc.clients.list() {
+		for _, iport := range cli.ieps {
+			consumers[iport.Name] = append(consumers[iport.Name], cli)
+		}
+	}
+
+	err := rc.prepare(ctx, CmdConfig)
+	if err != nil {
+		return err
+	}
+
+	var (
+		clis = make([]*client, 0, len(clients))
+		cmds = make(map[string]ConfigCmd, len(clients))
+		cerr = &CmdError{Cmd: CmdConfig}
+		tr   = rc.newTransition(CmdConfig)
+	)
 	for i := range clients {
-		cli := rc.clients[clients[i]]
+		cli := rc.clients.get(clients[i])
+		maxFrame := cli.maxFrame
+		for _, oport := range cli.oeps {
+			for _, c := range consumers[oport.Name] {
+				maxFrame = negotiateMaxFrameSize(maxFrame, c.maxFrame)
+			}
+		}
 		cmd := ConfigCmd{
 			Name:         cli.name,
 			InEndPoints:  cli.ieps,
 			OutEndPoints: cli.oeps,
+			MaxFrameSize: uint32(maxFrame),
 		}
-		grp.Go(func() error {
-			rc.msg.Debugf("sending /config to %q...", cli.name)
-			err := SendCmd(ctx, cli.cmd, &cmd)
-			if err != nil {
-				rc.msg.Errorf("could not send /config to %q: %v+", cli.name, err)
-				return err
-			}
+		cmd.Acks = feedbackAddrs(cli.ieps, acks)
+		cmd.Credits = feedbackAddrs(cli.ieps, credits)
+		cmd.Services = services
+		cmd.Topics = rc.publishers(cli)
+		cmd.KVVersion, cmd.KV = rc.kv.scope(cli.name)
+		if cli.proto >= ProtoV3 {
+			cmd.Version = ConfigVersion
+			cmd.Ext = rc.ext
+			cmd.Encodings = epEncodings(cli, encs)
+			cmd.Compact = epCompact(cli, links)
+		}
+		clis = append(clis, cli)
+		cmds[cli.name] = cmd
+	}
 
-			ack, err := RecvFrame(ctx, cli.cmd)
-			if err != nil {
-				rc.msg.Errorf("could not receive ACK from %q: %+v", cli.name, err)
-				return err
-			}
-			switch ack.Type {
-			case FrameOK:
-				// ok
-			case FrameErr:
-				rc.msg.Errorf("received ERR ACK from %q: %v", cli.name, string(ack.Body))
-				return fmt.Errorf("received ERR ACK from %q: %v", cli.name, string(ack.Body))
-			default:
-				rc.msg.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
-				return fmt.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
-			}
-			rc.msg.Debugf("sending /config to %q... [ok]", cli.name)
-			return nil
+	rc.dispatch(clis, cerr, func(cli *client) error {
+		return tr.do(cli, func() error {
+			return rc.config(ctx, cli, cmds[cli.name])
 		})
+	})
+	if tr.timedOut() {
+		return rc.abort(ctx, tr)
+	}
+	if err := cerr.err(); err != nil {
+		rc.setStatus(fsm.Error)
+		return err
 	}
 
-	err := grp.Wait()
-	if err != nil {
-		rc.status = fsm.Error
-		return fmt.Errorf("failed to run errgroup: %w", err)
+	for _, cli := range rc.clients.list() {
+		cli.setStatus(fsm.Conf)
 	}
+	rc.setStatus(fsm.Conf)
+
+	rc.syncCalibs(ctx)
 
-	rc.status = fsm.Conf
-	for _, cli := range rc.clients {
-		cli.setStatus(rc.status)
+	return nil
+}
+
+// publishers returns the addresses of the topics sockets of the processes
+// publishing topics matching the subscriptions of the provided process,
+// indexed by process name.
+// publishers must be called with rc.mu held.
+func (rc *RunControl) publishers(cli *client) map[string]string {
+	if len(cli.subs) == 0 {
+		return nil
+	}
+	addrs := make(map[string]string)
+	for _, p := range rc.clients.list() {
+		if p.topics == "" || !matchTopics(cli.subs, p.pubs) {
+			continue
+		}
+		addrs[p.name] = p.topics
 	}
+	return addrs
+}
 
+// config sends the provided /config command to a process and waits for
+// its ACK.
+func (rc *RunControl) config(ctx context.Context, cli *client, cmd ConfigCmd) error {
+	rc.msg.Debugf("sending /config to %q...", cli.name)
+	ack, err := cli.exchange(ctx, func(sck mangos.Socket) error {
+		return sendCmdProto(ctx, sck, &cmd, cli.proto)
+	})
+	if err != nil {
+		rc.msg.Errorf("could not exchange /config with %q: %+v", cli.name, err)
+		return err
+	}
+	switch ack.Type {
+	case FrameOK:
+		rc.replies.add(CmdConfig, cli.name, ack.Body)
+	case FrameErr:
+		rc.msg.Errorf("received ERR ACK from %q: %v", cli.name, string(ack.Body))
+		return fmt.Errorf("received ERR ACK from %q: %v", cli.name, string(ack.Body))
+	default:
+		rc.msg.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
+		return fmt.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
+	}
+	rc.msg.Debugf("sending /config to %q... [ok]", cli.name)
 	return nil
 }
 
@@ -544,16 +940,21 @@ func (rc *RunControl) doInit(ctx context.Context) error {
 
 	rc.buildDeps()
 
+	err = rc.prepare(ctx, CmdInit)
+	if err != nil {
+		return err
+	}
+
 	err = rc.broadcast(ctx, CmdInit)
 	if err != nil {
-		rc.status = fsm.Error
+		rc.failed(err)
 		return err
 	}
 
-	rc.status = fsm.Init
-	for _, cli := range rc.clients {
-		cli.setStatus(rc.status)
+	for _, cli := range rc.clients.list() {
+		cli.setStatus(fsm.Init)
 	}
+	rc.setStatus(fsm.Init)
 
 	return nil
 }
@@ -563,16 +964,21 @@ func (rc *RunControl) doReset(ctx context.Context) error {
 	defer rc.mu.Unlock()
 	rc.msg.Infof("/reset processes...")
 
-	err := rc.broadcast(ctx, CmdReset)
+	err := rc.prepare(ctx, CmdReset)
 	if err != nil {
-		rc.status = fsm.Error
 		return err
 	}
 
-	rc.status = fsm.UnConf
-	for _, cli := range rc.clients {
-		cli.setStatus(rc.status)
+	err = rc.broadcast(ctx, CmdReset)
+	if err != nil {
+		rc.failed(err)
+		return err
+	}
+
+	for _, cli := range rc.clients.list() {
+		cli.setStatus(fsm.UnConf)
 	}
+	rc.setStatus(fsm.UnConf)
 
 	return nil
 }
@@ -582,16 +988,36 @@ func (rc *RunControl) doStart(ctx context.Context) error {
 	defer rc.mu.Unlock()
 	rc.msg.Infof("/start processes...")
 
-	err := rc.broadcast(ctx, CmdStart)
+	err := rc.checkRequired()
+	if err != nil {
+		return err
+	}
+
+	err = rc.prepare(ctx, CmdStart)
+	if err != nil {
+		return err
+	}
+
+	rc.runStart = rc.now().UTC()
+	rc.resetWatchdog(rc.runStart)
+	err = rc.broadcast(ctx, CmdStart)
+	if err != nil {
+		rc.failed(err)
+		return err
+	}
+
+	err = rc.release(ctx)
 	if err != nil {
-		rc.status = fsm.Error
+		rc.failed(err)
 		return err
 	}
 
-	rc.status = fsm.Running
-	for _, cli := range rc.clients {
-		cli.setStatus(rc.status)
+	for _, cli := range rc.clients.list() {
+		cli.setStatus(fsm.Running)
 	}
+	rc.setStatus(fsm.Running)
+	rc.logRunStart()
+	rc.hooks.runStart(rc.runNbr, rc.runStart)
 
 	return nil
 }
@@ -601,16 +1027,36 @@ func (rc *RunControl) doStop(ctx context.Context) error {
 	defer rc.mu.Unlock()
 	rc.msg.Infof("/stop processes...")
 
-	err := rc.broadcast(ctx, CmdStop)
+	err := rc.prepare(ctx, CmdStop)
 	if err != nil {
-		rc.status = fsm.Error
 		return err
 	}
 
-	rc.status = fsm.Stopped
-	for _, cli := range rc.clients {
-		cli.setStatus(rc.status)
+	err = rc.broadcast(ctx, CmdStop)
+	if err != nil {
+		rc.failed(err)
+		return err
+	}
+
+	for _, cli := range rc.clients.list() {
+		cli.setStatus(fsm.Stopped)
+	}
+	rc.setStatus(fsm.Stopped)
+
+	rc.alerts.raise(AlertRunStop, rc.cfg.Name, "run %d stopped", rc.runNbr)
+
+	sum := rc.summarize(ctx)
+	rc.totals = &sum.Totals
+	rc.runs = append(rc.runs, sum)
+	if n := len(rc.runs); n > runHistorySize {
+		rc.runs = append(rc.runs[:0], rc.runs[n-runHistorySize:]...)
+	}
+	err = rc.writeSummary(sum)
+	if err != nil {
+		rc.msg.Warnf("could not write run summary: %+v", err)
 	}
+	rc.logRunStop(sum)
+	rc.hooks.runStop(sum)
 
 	return nil
 }
@@ -623,56 +1069,30 @@ func (rc *RunControl) doQuit(ctx context.Context) error {
 
 	err := rc.broadcast(ctx, CmdQuit)
 	if err != nil {
-		rc.status = fsm.Error
+		rc.failed(err)
 		return err
 	}
 
-	rc.status = fsm.Exiting
+	rc.setStatus(fsm.Exiting)
 	return nil
 }
 
 func (rc *RunControl) doStatus(ctx context.Context) error {
 	rc.msg.Infof("/status processes...")
 
-	rc.mu.RLock()
-	clients := make([]string, 0, len(rc.clients))
-	for name := range rc.clients {
-		clients = append(clients, name)
-	}
-	rc.mu.RUnlock()
-
 	var grp errgroup.Group
-	for i := range clients {
-		rc.mu.RLock()
-		cli := rc.clients[clients[i]]
-		rc.mu.RUnlock()
-		cmd := StatusCmd{Name: cli.name}
+	for _, cli := range rc.clients.list() {
+		cli := cli
 		grp.Go(func() error {
-			err := SendCmd(ctx, cli.cmd, &cmd)
-			if err != nil {
-				rc.msg.Errorf("could not send /status to %q: %+v", cli.name, err)
-				return err
-			}
-
-			ack, err := RecvFrame(ctx, cli.cmd)
+			cmd, err := rc.queryStatus(ctx, cli)
 			if err != nil {
-				rc.msg.Errorf("could not receive /status ACK from %q: %+v", cli.name, err)
 				return err
 			}
-			switch ack.Type {
-			case FrameCmd:
-				cmd, err := newStatusCmd(ack)
-				if err != nil {
-					rc.msg.Errorf("could not receive /status reply for %q: %+v", cli.name, err)
-					return fmt.Errorf("could not receive /status reply for %q: %w", cli.name, err)
-				}
-				cli.setStatus(cmd.Status)
-				rc.msg.Infof("received /status = %v for %q", cmd.Status, cli.name)
-
-			default:
-				rc.msg.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
-				return fmt.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
-			}
+			cli.updateStatus(cmd.Status)
+			cli.setLinks(cmd.Links)
+			cli.setStats(cmd.Stats)
+			cli.setRuntime(cmd.Runtime)
+			rc.msg.Infof("received /status = %v for %q", cmd.Status, cli.name)
 			return nil
 		})
 	}
@@ -682,19 +1102,49 @@ func (rc *RunControl) doStatus(ctx context.Context) error {
 		return fmt.Errorf("failed to run /status errgroup: %w", err)
 	}
 
+	if devs := rc.Devices(); len(devs.Missing) > 0 || len(devs.Unexpected) > 0 {
+		rc.msg.Warnf("processes: missing=%q, unexpected=%q", devs.Missing, devs.Unexpected)
+	}
+
 	return nil
 }
 
+// queryStatus sends a /status command to the provided client and returns
+// its reply.
+func (rc *RunControl) queryStatus(ctx context.Context, cli *client) (StatusCmd, error) {
+	cmd := StatusCmd{Name: cli.name}
+	ack, err := cli.exchange(ctx, func(sck mangos.Socket) error {
+		return sendCmdProto(ctx, sck, &cmd, cli.proto)
+	})
+	if err != nil {
+		rc.msg.Errorf("could not exchange /status with %q: %+v", cli.name, err)
+		return cmd, err
+	}
+	switch ack.Type {
+	case FrameCmd:
+		cmd, err = newStatusCmd(ack)
+		if err != nil {
+			rc.msg.Errorf("could not receive /status reply for %q: %+v", cli.name, err)
+			return cmd, fmt.Errorf("could not receive /status reply for %q: %w", cli.name, err)
+		}
+		return cmd, nil
+
+	default:
+		rc.msg.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
+		return cmd, fmt.Errorf("received invalid frame type %v from %q", ack.Type, cli.name)
+	}
+}
+
 func (rc *RunControl) buildDeps() {
-	epts := make(map[string]struct{}, len(rc.clients))
-	done := make([]string, 0, len(rc.clients))
+	epts := make(map[string]struct{}, rc.clients.len())
+	done := make([]string, 0, rc.clients.len())
 	todo := make(map[string]struct{})
-	for name := range rc.clients {
+	for _, name := range rc.clients.names() {
 		todo[name] = struct{}{}
 	}
 
 	depsOf := func(name string) int {
-		cli := rc.clients[name]
+		cli := rc.clients.get(name)
 		n := len(cli.ieps)
 		for _, ep := range cli.ieps {
 			if _, ok := epts[ep.Name]; ok {
@@ -714,7 +1164,7 @@ loop:
 			if inputs == 0 {
 				done = append(done, name)
 				delete(todo, name)
-				for _, ep := range rc.clients[name].oeps {
+				for _, ep := range rc.clients.get(name).oeps {
 					epts[ep.Name] = struct{}{}
 				}
 			}
@@ -17,8 +17,8 @@ import (
 
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/config"
-	"github.com/go-daq/tdaq/internal/iomux"
 	"github.com/go-daq/tdaq/internal/tcputil"
+	"github.com/go-daq/tdaq/iomux"
 	"github.com/go-daq/tdaq/job"
 	"github.com/go-daq/tdaq/log"
 	"github.com/go-daq/tdaq/xdaq"
@@ -115,6 +115,11 @@ func TestRunControlAPI(t *testing.T) {
 		{"stop", tdaq.CmdStop},
 		{"status", tdaq.CmdStatus},
 		{"start", tdaq.CmdStart},
+		{"pause", tdaq.CmdPause},
+		{"resume", tdaq.CmdResume},
+		{"pause", tdaq.CmdPause},
+		{"stop", tdaq.CmdStop},
+		{"start", tdaq.CmdStart},
 		{"stop", tdaq.CmdStop},
 		{"quit", tdaq.CmdQuit},
 	} {
@@ -545,3 +550,129 @@ loop:
 	}
 
 }
+
+func TestRunControlTransitionTimeout(t *testing.T) {
+	t.Parallel()
+
+	const (
+		rclvl   = log.LvlError
+		proclvl = log.LvlError
+	)
+
+	port, err := tcputil.GetTCPPort()
+	if err != nil {
+		t.Fatalf("could not find a tcp port for run-ctl: %+v", err)
+	}
+
+	rcAddr := ":" + port
+
+	stdout := iomux.NewWriter(new(bytes.Buffer))
+
+	fname, err := ioutil.TempFile("", "tdaq-")
+	if err != nil {
+		t.Fatalf("could not create a temporary log file for run-ctl log server: %+v", err)
+	}
+	fname.Close()
+	defer func() {
+		if err != nil {
+			raw, err := ioutil.ReadFile(fname.Name())
+			if err == nil {
+				t.Logf("log-file:\n%v\n", string(raw))
+			}
+		}
+		os.Remove(fname.Name())
+	}()
+
+	cfg := config.RunCtl{
+		Name:      "run-ctl",
+		Level:     rclvl,
+		Trans:     "tcp",
+		RunCtl:    rcAddr,
+		LogFile:   fname.Name(),
+		HBeatFreq: 50 * time.Millisecond,
+		Timeouts: map[string]time.Duration{
+			"/config": 200 * time.Millisecond,
+		},
+	}
+
+	rc, err := tdaq.NewRunControl(cfg, stdout)
+	if err != nil {
+		t.Fatalf("could not create run-ctl: %+v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	grp, ctx := errgroup.WithContext(ctx)
+
+	errc := make(chan error)
+	go func() {
+		errc <- rc.Run(ctx)
+	}()
+
+	for _, name := range []string{"fast", "slow"} {
+		name := name
+		grp.Go(func() error {
+			dev := xdaq.I64Gen{}
+			cfg := config.Process{
+				Name:   name,
+				Level:  proclvl,
+				Trans:  "tcp",
+				RunCtl: rcAddr,
+			}
+			srv := tdaq.New(cfg, stdout)
+			srv.OutputHandle("/"+name, dev.Output)
+			if name == "slow" {
+				srv.CmdHandle("/config", func(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+					time.Sleep(time.Second)
+					return nil
+				})
+			}
+			return srv.Run(ctx)
+		})
+	}
+
+	timeout := time.NewTimer(5 * time.Second)
+	defer timeout.Stop()
+loop:
+	for {
+		select {
+		case <-timeout.C:
+			t.Fatalf("devices did not connect")
+		default:
+			n := rc.NumClients()
+			if n == 2 {
+				break loop
+			}
+		}
+	}
+
+	err = rc.Do(ctx, tdaq.CmdConfig)
+	switch {
+	case err == nil:
+		t.Fatalf("expected a transition timeout")
+	case !errors.Is(err, tdaq.ErrTransitionTimeout):
+		t.Fatalf("invalid error: %+v", err)
+	}
+	if got, want := err.Error(), `laggards: ["slow"]`; !strings.Contains(got, want) {
+		t.Fatalf("invalid laggards report:\ngot = %q\nwant= %q", got, want)
+	}
+
+	// let the slow process complete its transition.
+	time.Sleep(time.Second)
+
+	err = rc.Do(ctx, tdaq.CmdQuit)
+	if err != nil {
+		t.Fatalf("could not send /quit: %+v", err)
+	}
+
+	err = grp.Wait()
+	if err != nil {
+		t.Fatalf("could not run device run-group: %+v", err)
+	}
+
+	err = <-errc
+	if err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatalf("error shutting down run-ctl: %+v", err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"runtime"
+	"time"
+)
+
+// RuntimeStats holds statistics of the Go runtime of a TDAQ process.
+type RuntimeStats struct {
+	Goroutines int           `json:"goroutines"`  // number of goroutines
+	HeapInuse  uint64        `json:"heap-inuse"`  // number of bytes in in-use heap spans
+	NumGC      uint32        `json:"num-gc"`      // number of completed GC cycles
+	PauseTotal time.Duration `json:"pause-total"` // cumulative GC stop-the-world pause time
+}
+
+func readRuntimeStats() RuntimeStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return RuntimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		HeapInuse:  ms.HeapInuse,
+		NumGC:      ms.NumGC,
+		PauseTotal: time.Duration(ms.PauseTotalNs),
+	}
+}
+
+const (
+	leakSamples = 20  // number of runtime statistics samples kept per process
+	leakGrowth  = 1.5 // growth over the samples window flagging a leak
+)
+
+// leakHist is the history of the runtime statistics of a process, sampled
+// by run-ctl at each heartbeat.
+//
+// A process is deemed leaking when its number of goroutines or its heap in
+// use keeps growing: when the lowest value of the most recent half of the
+// samples exceeds the highest value of the oldest half, and the lowest value
+// of the oldest half by at least a factor leakGrowth.
+// Comparing halves of the window smooths out the saw-tooth of the garbage
+// collector.
+type leakHist struct {
+	gs   []float64 // goroutines samples, oldest first
+	heap []float64 // heap in use samples, oldest first
+	leak bool
+}
+
+// add adds a sample of the runtime statistics of the process and returns
+// whether the leaking status of the process changed.
+func (h *leakHist) add(rt RuntimeStats) bool {
+	h.gs = appendSample(h.gs, float64(rt.Goroutines))
+	h.heap = appendSample(h.heap, float64(rt.HeapInuse))
+
+	leak := growing(h.gs) || growing(h.heap)
+	changed := leak != h.leak
+	h.leak = leak
+	return changed
+}
+
+func appendSample(vs []float64, v float64) []float64 {
+	vs = append(vs, v)
+	if n := len(vs); n > leakSamples {
+		vs = append(vs[:0], vs[n-leakSamples:]...)
+	}
+	return vs
+}
+
+// growing returns whether the provided full window of samples keeps growing.
+func growing(vs []float64) bool {
+	if len(vs) < leakSamples {
+		return false
+	}
+	var (
+		half       = len(vs) / 2
+		min1, max1 = vs[0], vs[0]
+		min2       = vs[half]
+	)
+	for _, v := range vs[:half] {
+		if v < min1 {
+			min1 = v
+		}
+		if v > max1 {
+			max1 = v
+		}
+	}
+	for _, v := range vs[half:] {
+		if v < min2 {
+			min2 = v
+		}
+	}
+	return min2 > max1 && min2 >= leakGrowth*min1
+}
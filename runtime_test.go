@@ -0,0 +1,45 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"testing"
+)
+
+func TestLeakHist(t *testing.T) {
+	var h leakHist
+
+	// steady process, with a saw-tooth heap.
+	for i := 0; i < 2*leakSamples; i++ {
+		heap := uint64(100 << 20)
+		if i%3 == 0 {
+			heap *= 2
+		}
+		if h.add(RuntimeStats{Goroutines: 42, HeapInuse: heap}) || h.leak {
+			t.Fatalf("sample %d: steady process flagged as leaking", i)
+		}
+	}
+
+	// leaking goroutines.
+	changed := false
+	for i := 0; i < leakSamples; i++ {
+		changed = h.add(RuntimeStats{Goroutines: 42 + 10*i, HeapInuse: 100 << 20}) || changed
+	}
+	if !changed || !h.leak {
+		t.Fatalf("leaking process not flagged: leak=%v, changed=%v", h.leak, changed)
+	}
+	if got, want := len(h.gs), leakSamples; got != want {
+		t.Fatalf("invalid history length: got=%d, want=%d", got, want)
+	}
+
+	// recovered.
+	changed = false
+	for i := 0; i < leakSamples; i++ {
+		changed = h.add(RuntimeStats{Goroutines: 42, HeapInuse: 100 << 20}) || changed
+	}
+	if !changed || h.leak {
+		t.Fatalf("recovered process still flagged: leak=%v, changed=%v", h.leak, changed)
+	}
+}
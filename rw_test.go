@@ -9,6 +9,7 @@ import (
 	"io"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/go-daq/tdaq"
 )
@@ -202,6 +203,120 @@ func TestTranscoder(t *testing.T) {
 			},
 			want: "hello-tdaq",
 		},
+		{
+			name: "time",
+			wfct: func(w io.Writer, v interface{}) error {
+				enc := tdaq.NewEncoder(w)
+				enc.WriteTime(v.(time.Time))
+				return enc.Err()
+			},
+			rfct: func(r io.Reader) (interface{}, error) {
+				dec := tdaq.NewDecoder(r)
+				v := dec.ReadTime()
+				return v, dec.Err()
+			},
+			want: time.Unix(1234, 5678).UTC(),
+		},
+		{
+			name: "time-zero",
+			wfct: func(w io.Writer, v interface{}) error {
+				enc := tdaq.NewEncoder(w)
+				enc.WriteTime(v.(time.Time))
+				return enc.Err()
+			},
+			rfct: func(r io.Reader) (interface{}, error) {
+				dec := tdaq.NewDecoder(r)
+				v := dec.ReadTime()
+				return v, dec.Err()
+			},
+			want: time.Time{},
+		},
+		{
+			name: "bytes",
+			wfct: func(w io.Writer, v interface{}) error {
+				enc := tdaq.NewEncoder(w)
+				enc.WriteBytes(v.([]byte))
+				return enc.Err()
+			},
+			rfct: func(r io.Reader) (interface{}, error) {
+				dec := tdaq.NewDecoder(r)
+				v := dec.ReadBytes()
+				return v, dec.Err()
+			},
+			want: []byte("hello-tdaq"),
+		},
+		{
+			name: "strings",
+			wfct: func(w io.Writer, v interface{}) error {
+				enc := tdaq.NewEncoder(w)
+				enc.WriteStrs(v.([]string))
+				return enc.Err()
+			},
+			rfct: func(r io.Reader) (interface{}, error) {
+				dec := tdaq.NewDecoder(r)
+				v := dec.ReadStrs()
+				return v, dec.Err()
+			},
+			want: []string{"hello", "", "tdaq"},
+		},
+		{
+			name: "str-map",
+			wfct: func(w io.Writer, v interface{}) error {
+				enc := tdaq.NewEncoder(w)
+				enc.WriteStrMap(v.(map[string]string))
+				return enc.Err()
+			},
+			rfct: func(r io.Reader) (interface{}, error) {
+				dec := tdaq.NewDecoder(r)
+				v := dec.ReadStrMap()
+				return v, dec.Err()
+			},
+			want: map[string]string{"k1": "v1", "k2": "", "": "v3"},
+		},
+		{
+			name: "uvarint",
+			wfct: func(w io.Writer, v interface{}) error {
+				enc := tdaq.NewEncoder(w)
+				enc.WriteUvarint(v.(uint64))
+				return enc.Err()
+			},
+			rfct: func(r io.Reader) (interface{}, error) {
+				dec := tdaq.NewDecoder(r)
+				v := dec.ReadUvarint()
+				return v, dec.Err()
+			},
+			want: uint64(1<<40 + 42),
+		},
+		{
+			name: "varint",
+			wfct: func(w io.Writer, v interface{}) error {
+				enc := tdaq.NewEncoder(w)
+				enc.WriteVarint(v.(int64))
+				return enc.Err()
+			},
+			rfct: func(r io.Reader) (interface{}, error) {
+				dec := tdaq.NewDecoder(r)
+				v := dec.ReadVarint()
+				return v, dec.Err()
+			},
+			want: int64(-1<<40 - 42),
+		},
+		{
+			name: "string-compact",
+			wfct: func(w io.Writer, v interface{}) error {
+				enc := tdaq.NewEncoder(w)
+				enc.SetCompact(true)
+				enc.WriteStr(v.(string))
+				return enc.Err()
+			},
+			rfct: func(r io.Reader) (interface{}, error) {
+				dec := tdaq.NewDecoder(r)
+				dec.SetCompact(true)
+				v := dec.ReadStr()
+				return v, dec.Err()
+			},
+			want: "hello-tdaq",
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			buf := new(bytes.Buffer)
@@ -305,6 +420,40 @@ func TestMarshaler(t *testing.T) {
 	}
 }
 
+func TestMarshalerCompact(t *testing.T) {
+	want := testDataType{n: "hello", v: -42}
+
+	fixed := new(bytes.Buffer)
+	err := tdaq.NewEncoder(fixed).Encode(want)
+	if err != nil {
+		t.Fatalf("could not encode value %v: %+v", want, err)
+	}
+
+	buf := new(bytes.Buffer)
+	enc := tdaq.NewEncoder(buf)
+	enc.SetCompact(true)
+	err = enc.Encode(want)
+	if err != nil {
+		t.Fatalf("could not encode value %v: %+v", want, err)
+	}
+
+	if got, max := buf.Len(), fixed.Len()-7; got > max {
+		t.Fatalf("invalid compact size: got=%d, want<=%d", got, max)
+	}
+
+	var got testDataType
+	dec := tdaq.NewDecoder(buf)
+	dec.SetCompact(true)
+	err = dec.Decode(&got)
+	if err != nil {
+		t.Fatalf("could not decode value %v: %+v", want, err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid r/w round-trip:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+}
+
 type testDataType struct {
 	n string
 	v int64
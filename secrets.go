@@ -0,0 +1,171 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-daq/tdaq/internal/secrets"
+)
+
+// ErrNoSecret is returned when a secret reference can not be resolved.
+var ErrNoSecret = errors.New("tdaq: no such secret")
+
+// Secret references are the only form under which credentials (database
+// passwords, S3 keys, hardware PINs, ...) appear in the configuration of
+// devices, e.g. in topology parameters or in the key-value configuration
+// store.
+// They are resolved by the TDAQ processes themselves, with Context.Secret,
+// so secret values never transit through run-ctl and never end up in its
+// logs, summaries or history.
+//
+// References take one of the following forms:
+//  - secret:env:NAME, the value of the NAME environment variable,
+//  - secret:file:PATH, the content of the file at PATH, trailing spaces removed,
+//  - secret:store:NAME, the NAME entry of the encrypted secrets store of the
+//    process (see config.Process.Secrets), managed with tdaq-secrets.
+
+// secretPrefix is the prefix of secret references.
+const secretPrefix = "secret:"
+
+// IsSecretRef returns whether the provided value is a secret reference.
+func IsSecretRef(v string) bool {
+	return strings.HasPrefix(v, secretPrefix)
+}
+
+// redacted is the representation of secrets in logs and encoded documents.
+const redacted = "***"
+
+// Secret holds a credential resolved from a secret reference.
+//
+// Secrets are redacted when formatted or encoded: their value is only
+// available through Reveal.
+type Secret struct {
+	v string
+}
+
+// Reveal returns the value of the secret.
+func (s Secret) Reveal() string { return s.v }
+
+// String implements fmt.Stringer.
+func (s Secret) String() string { return redacted }
+
+// GoString implements fmt.GoStringer.
+func (s Secret) GoString() string { return "tdaq.Secret{" + redacted + "}" }
+
+// Format implements fmt.Formatter.
+func (s Secret) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		_, _ = io.WriteString(f, s.GoString())
+	default:
+		_, _ = io.WriteString(f, redacted)
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Secret) MarshalText() ([]byte, error) { return []byte(redacted), nil }
+
+// Secret resolves the provided secret reference.
+//
+// Secrets should be resolved by the /config handlers of devices, once the
+// process reloaded its secrets store.
+func (ctx Context) Secret(ref string) (Secret, error) {
+	if !IsSecretRef(ref) {
+		return Secret{}, fmt.Errorf("invalid secret reference %q", ref)
+	}
+
+	var (
+		kind string
+		name = strings.TrimPrefix(ref, secretPrefix)
+	)
+	if i := strings.Index(name, ":"); i >= 0 {
+		kind, name = name[:i], name[i+1:]
+	}
+	if name == "" {
+		return Secret{}, fmt.Errorf("invalid secret reference %q", ref)
+	}
+
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return Secret{}, fmt.Errorf("could not resolve %q: %w", ref, ErrNoSecret)
+		}
+		return Secret{v}, nil
+
+	case "file":
+		raw, err := ioutil.ReadFile(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return Secret{}, fmt.Errorf("could not resolve %q: %w", ref, ErrNoSecret)
+			}
+			return Secret{}, fmt.Errorf("could not read secret file of %q: %w", ref, err)
+		}
+		return Secret{strings.TrimRight(string(raw), " \t\r\n")}, nil
+
+	case "store":
+		if ctx.secrets == nil {
+			return Secret{}, fmt.Errorf("could not resolve %q: %w", ref, ErrNoSecret)
+		}
+		v, ok := ctx.secrets.get(name)
+		if !ok {
+			return Secret{}, fmt.Errorf("could not resolve %q: %w", ref, ErrNoSecret)
+		}
+		return Secret{v}, nil
+
+	default:
+		return Secret{}, fmt.Errorf("invalid secret reference %q: unknown kind %q", ref, kind)
+	}
+}
+
+// secretmgr holds the encrypted secrets store of a TDAQ process.
+type secretmgr struct {
+	mu    sync.RWMutex
+	fname string // path to the secrets store (empty: no store)
+	store *secrets.Store
+}
+
+func newSecretMgr(fname string) *secretmgr {
+	return &secretmgr{fname: fname}
+}
+
+// load (re)loads the secrets store, with the key of the secrets.KeyEnv
+// environment variable.
+func (mgr *secretmgr) load() error {
+	if mgr.fname == "" {
+		return nil
+	}
+
+	key, err := secrets.KeyFromEnv()
+	if err != nil {
+		return fmt.Errorf("could not load secrets store: %w", err)
+	}
+
+	store, err := secrets.Open(mgr.fname, key)
+	if err != nil {
+		return err
+	}
+
+	mgr.mu.Lock()
+	mgr.store = store
+	mgr.mu.Unlock()
+	return nil
+}
+
+func (mgr *secretmgr) get(name string) (string, bool) {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	if mgr.store == nil {
+		return "", false
+	}
+	return mgr.store.Get(name)
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/tdaq/internal/secrets"
+)
+
+func TestSecrets(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "tdaq-secrets-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	hkey, err := secrets.NewKey()
+	if err != nil {
+		t.Fatalf("could not generate key: %+v", err)
+	}
+	key, err := secrets.ParseKey(hkey)
+	if err != nil {
+		t.Fatalf("could not parse key: %+v", err)
+	}
+
+	store := secrets.New()
+	store.Set("db-password", "from-store")
+	fstore := filepath.Join(tmp, "secrets.db")
+	err = store.Save(fstore, key)
+	if err != nil {
+		t.Fatalf("could not save store: %+v", err)
+	}
+
+	fsecret := filepath.Join(tmp, "pin.txt")
+	err = ioutil.WriteFile(fsecret, []byte("from-file\n"), 0600)
+	if err != nil {
+		t.Fatalf("could not write secret file: %+v", err)
+	}
+
+	defer os.Unsetenv(secrets.KeyEnv)
+	os.Setenv(secrets.KeyEnv, hkey)
+	defer os.Unsetenv("TDAQ_TEST_SECRET")
+	os.Setenv("TDAQ_TEST_SECRET", "from-env")
+
+	mgr := newSecretMgr(fstore)
+	err = mgr.load()
+	if err != nil {
+		t.Fatalf("could not load secrets store: %+v", err)
+	}
+	ctx := Context{Ctx: context.Background(), secrets: mgr}
+
+	for _, tc := range []struct {
+		ref  string
+		want string
+		err  error
+	}{
+		{ref: "secret:env:TDAQ_TEST_SECRET", want: "from-env"},
+		{ref: "secret:file:" + fsecret, want: "from-file"},
+		{ref: "secret:store:db-password", want: "from-store"},
+		{ref: "secret:env:TDAQ_TEST_NO_SECRET", err: ErrNoSecret},
+		{ref: "secret:file:" + filepath.Join(tmp, "missing"), err: ErrNoSecret},
+		{ref: "secret:store:missing", err: ErrNoSecret},
+		{ref: "from-env", err: fmt.Errorf(`invalid secret reference "from-env"`)},
+		{ref: "secret:vault:x", err: fmt.Errorf(`invalid secret reference "secret:vault:x": unknown kind "vault"`)},
+	} {
+		t.Run(tc.ref, func(t *testing.T) {
+			got, err := ctx.Secret(tc.ref)
+			switch {
+			case err != nil && tc.err != nil:
+				if !errors.Is(err, tc.err) && err.Error() != tc.err.Error() {
+					t.Fatalf("invalid error:\ngot = %+v\nwant= %+v", err, tc.err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("could not resolve secret: %+v", err)
+			case tc.err != nil:
+				t.Fatalf("expected an error (%v)", tc.err)
+			}
+
+			if got.Reveal() != tc.want {
+				t.Fatalf("invalid secret value: got=%q, want=%q", got.Reveal(), tc.want)
+			}
+
+			for _, str := range []string{
+				fmt.Sprintf("%v|%s|%q|%+v|%#v|%x", got, got, got, got, got, got),
+				fmt.Sprintf("%v", struct{ Password Secret }{got}),
+				fmt.Sprintf("%+v", []Secret{got}),
+			} {
+				if strings.Contains(str, tc.want) {
+					t.Fatalf("secret leaked by fmt: %q", str)
+				}
+			}
+
+			raw, err := json.Marshal(struct{ Password Secret }{got})
+			if err != nil {
+				t.Fatalf("could not marshal secret: %+v", err)
+			}
+			if got, want := string(raw), `{"Password":"***"}`; got != want {
+				t.Fatalf("invalid JSON:\ngot = %s\nwant= %s", got, want)
+			}
+		})
+	}
+
+	err = os.Unsetenv(secrets.KeyEnv)
+	if err != nil {
+		t.Fatalf("could not unset key: %+v", err)
+	}
+	err = mgr.load()
+	if err == nil {
+		t.Fatalf("expected an error loading store without key")
+	}
+}
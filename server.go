@@ -6,6 +6,7 @@ package tdaq // import "github.com/go-daq/tdaq"
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/go-daq/tdaq/conditions"
 	"github.com/go-daq/tdaq/config"
 	"github.com/go-daq/tdaq/fsm"
 	"github.com/go-daq/tdaq/log"
@@ -42,25 +44,60 @@ type Server struct {
 		lis mangos.Listener
 	}
 
-	mu   sync.RWMutex
-	msg  *msgstream
-	imgr *imgr
-	omgr *omgr
-	cmgr *cmdmgr
+	mu     sync.RWMutex
+	msg    *msgstream
+	imgr   *imgr
+	omgr   *omgr
+	cmgr   *cmdmgr
+	svcs   *svcmgr    // services provided to the other processes
+	tmgr   *topicmgr  // topics published and subscribed to by the process
+	kv     *kvcache   // values of the key-value configuration store, received at /config
+	ext    *extcache  // extension fields of the /config command
+	calibs *calibmgr  // calibration constants consumed and published by the process
+	peers  *svcpeers  // services provided by the other processes
+	orders byteOrders // byte orders declared by the end-points
+
+	secrets *secretmgr    // secrets store, reloaded at /config
+	compact *compactcache // end-points with compact data links, received at /config
+	conds   conditions.DB // conditions database
+	pprof   pprofSrv      // net/http/pprof server, toggled with /debug
+	health  healthSrv     // /healthz and /readyz probes server
 
 	state struct {
 		cur  fsm.Status
 		next fsm.Status
 	}
+	joined bool // whether the process joined run-ctl
+	ckpt   struct {
+		save func() ([]byte, error) // saves the device state
+		load func([]byte) error     // restores the device state
+	}
+	reconf struct {
+		ok      bool // whether the process supports /reconfig
+		running bool // whether the process supports /reconfig while running
+	}
 
-	runctx  context.Context
-	rundone context.CancelFunc
-	rungrp  *errgroup.Group
-	runfcts []func(Context) error
+	runctx   context.Context
+	rundone  context.CancelFunc
+	rungrp   *errgroup.Group
+	runfcts  []func(Context) error
+	prepfcts []PrepareHandler // handlers voting on prepared FSM transitions
+	abrtfcts []AbortHandler   // handlers releasing vetoed FSM transitions
+	armed    bool             // whether the process is armed, waiting for /go to produce data
+	paused   time.Time        // start of the current pause of the run (zero: not paused)
+
+	proto    uint8      // version of the TDAQ wire protocol negotiated with run-ctl
+	maxFrame int        // maximum frame size negotiated with run-ctl
+	clock    Clock      // clock offset with respect to run-ctl
+	ts       TimeSource // source of the time, tickers and timers of the process (may be nil)
+	stats    *runStats  // counters of the current run
+	alarms   *Alarms    // alarms raised by the process
 
 	rpark chan int      // rctl parking signal
 	hpark chan int      // hbeat parking signal
 	done  chan struct{} // signal to prepare exiting
+	quit  sync.Once     // closes done
+	cmdmu sync.Mutex    // serializes the execution of commands
 }
 
 func New(cfg config.Process, stdout io.Writer) *Server {
@@ -80,14 +117,32 @@ func New(cfg config.Process, stdout io.Writer) *Server {
 			"/config", "/init", "/reset", "/start", "/stop",
 			"/quit",
 			"/status",
+			"/go",
+			"/pause", "/resume",
+			"/prepare", "/abort",
+			"/debug",
+			"/reconfig",
+			"/calib",
+			"/snapshot", "/restore",
 		),
 
 		rpark: make(chan int),
 		hpark: make(chan int),
 		done:  make(chan struct{}),
+		stats: newRunStats(),
+		svcs:  newSvcMgr(),
+		peers: newSvcPeers(),
+		tmgr:  newTopicMgr(),
+		kv:    new(kvcache),
+		ext:   new(extcache),
+
+		secrets: newSecretMgr(cfg.Secrets),
+		compact: new(compactcache),
 	}
 	srv.imgr = newIMgr(srv)
 	srv.omgr = newOMgr(srv)
+	srv.alarms = newAlarms(srv.msg)
+	srv.calibs = newCalibMgr(srv)
 
 	return srv
 }
@@ -100,6 +155,22 @@ func (srv *Server) InputHandle(name string, h InputHandler) {
 	srv.imgr.Handle(name, h)
 }
 
+// InputHandleN registers the handler of the named input end-point, run by
+// n concurrent worker goroutines, so CPU-heavy handlers may use more than
+// one core per stream.
+//
+// Data frames are processed as soon as a worker is available, possibly out
+// of order, unless they are sharded with the ShardBy option: data frames
+// with the same key are then processed in order.
+//
+// The Order option declares the ordering required by the handler. With
+// n<=0, the number of workers is then chosen accordingly: one for strict
+// ordering or per-producer ordering of data links, one per CPU otherwise.
+// InputHandleN panics if the workers can not honor the declared ordering.
+func (srv *Server) InputHandleN(name string, n int, h InputHandler, opts ...InputOption) {
+	srv.imgr.HandleN(name, n, h, opts...)
+}
+
 func (srv *Server) OutputHandle(name string, h OutputHandler) {
 	srv.omgr.Handle(name, h)
 }
@@ -110,6 +181,23 @@ func (srv *Server) RunHandle(f RunHandler) {
 	srv.runfcts = append(srv.runfcts, f)
 }
 
+// Monitor publishes the current value of the named monitoring variable
+// to the run-ctl.
+func (srv *Server) Monitor(name string, value float64) {
+	srv.msg.mon(name, value)
+}
+
+// Alarms returns the alarms of the process.
+func (srv *Server) Alarms() *Alarms {
+	return srv.alarms
+}
+
+// ClockOffset returns the offset of the clock of the process with respect to
+// the clock of run-ctl, as last estimated by run-ctl.
+func (srv *Server) ClockOffset() ClockOffset {
+	return srv.clock.Offset()
+}
+
 func (srv *Server) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -136,8 +224,15 @@ func (srv *Server) Run(ctx context.Context) error {
 	srv.log.lis = llis
 	srv.msg.setLog(log)
 
+	srv.maxFrame = negotiateMaxFrameSize(srv.cfg.MaxFrameSize, 0)
+	err = srv.setMaxFrameSize(srv.maxFrame)
+	if err != nil {
+		return fmt.Errorf("could not set maximum frame size: %w", err)
+	}
+
 	go srv.hbeatLoop(ctx)
 	go srv.cmdsLoop(ctx)
+	go srv.sigsLoop(ctx)
 
 	err = srv.omgr.init(srv)
 	if err != nil {
@@ -146,6 +241,58 @@ func (srv *Server) Run(ctx context.Context) error {
 
 	srv.cmgr.init()
 
+	err = srv.svcs.init(srv)
+	if err != nil {
+		return fmt.Errorf("could not setup services: %w", err)
+	}
+	go srv.svcs.loop(ctx, srv)
+
+	err = srv.tmgr.init(srv)
+	if err != nil {
+		return fmt.Errorf("could not setup topics: %w", err)
+	}
+
+	if srv.cfg.PProf != "" {
+		addr, err := srv.pprof.start(srv.cfg.PProf)
+		if err != nil {
+			return err
+		}
+		srv.msg.Infof("pprof server listening on %q", addr)
+	}
+
+	if srv.cfg.Conditions != "" && srv.getConditions() == nil {
+		db, err := conditions.Open(srv.cfg.Conditions)
+		if err != nil {
+			return fmt.Errorf("could not open conditions database: %w", err)
+		}
+		srv.UseConditions(db)
+	}
+
+	err = srv.restore()
+	if err != nil {
+		return err
+	}
+	if srv.cfg.Checkpoint != "" {
+		go srv.ckptLoop(ctx)
+	}
+
+	if srv.cfg.Health != "" {
+		addr, err := srv.health.start(srv.cfg.Health, srv)
+		if err != nil {
+			return err
+		}
+		srv.msg.Infof("health server listening on %q", addr)
+	}
+
+	if name, ok := autoRunCtl(srv.cfg.RunCtl); ok {
+		addr, err := discoverRunCtl(ctx, name)
+		if err != nil {
+			return err
+		}
+		srv.msg.Infof("discovered run-ctl at %q", addr)
+		srv.rc = srv.cfg.Trans + "://" + addr
+	}
+
 	err = srv.join(ctx)
 	if err != nil {
 		return fmt.Errorf("could not join run-ctl: %w", err)
@@ -158,12 +305,25 @@ func (srv *Server) Run(ctx context.Context) error {
 		srv.close()
 		return nil
 	case <-ctx.Done():
-		close(srv.done)
+		srv.exit()
 		srv.close()
 		return ctx.Err()
 	}
 }
 
+// setMaxFrameSize sets the maximum frame size of the sockets connected
+// to run-ctl and of the output ports.
+func (srv *Server) setMaxFrameSize(n int) error {
+	for _, sck := range []mangos.Socket{srv.rctl.sck, srv.hbeat.sck, srv.log.sck} {
+		err := setMaxFrameSize(sck, n)
+		if err != nil {
+			return err
+		}
+	}
+	srv.omgr.setMaxFrameSize(n)
+	return nil
+}
+
 func (srv *Server) setCurState(state fsm.Status) {
 	srv.mu.Lock()
 	srv.state.cur = state
@@ -195,7 +355,7 @@ func (srv *Server) join(ctx context.Context) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	ctx, cancel := withTimeout(ctx, srv.timeSource(), 10*time.Second)
 	defer cancel()
 
 	sck, err := req.NewSocket()
@@ -204,6 +364,11 @@ func (srv *Server) join(ctx context.Context) error {
 	}
 	defer sck.Close()
 
+	err = setMaxFrameSize(sck, srv.maxFrame)
+	if err != nil {
+		return fmt.Errorf("could not set maximum frame size of /join socket: %w", err)
+	}
+
 	err = sck.Dial(srv.rc)
 	if err != nil {
 		return fmt.Errorf(
@@ -227,6 +392,25 @@ func (srv *Server) join(ctx context.Context) error {
 		Log:          srv.log.lis.Address(),
 		InEndPoints:  srv.imgr.endpoints(),
 		OutEndPoints: srv.omgr.endpoints(),
+		Proto:        ProtoVersion,
+		MaxFrameSize: uint32(srv.maxFrame),
+		Acks:         srv.omgr.ackAddrs(),
+		Credits:      srv.omgr.creditAddrs(),
+		Barrier:      true,
+		TwoPhase:     true,
+		Services:     srv.svcs.addr(),
+		Topics:       srv.tmgr.addr(),
+		Pubs:         srv.tmgr.topics(),
+		Subs:         srv.tmgr.patterns(),
+
+		Reconfig:        srv.reconf.ok,
+		ReconfigRunning: srv.reconf.running,
+
+		Calibs: srv.calibs.names(),
+		Orders: srv.orders.names(),
+
+		Encodings: srv.omgr.encodings(),
+		Codecs:    payloadCodecs(),
 	}
 
 	err = SendCmd(ctx, sck, &join)
@@ -240,8 +424,17 @@ func (srv *Server) join(ctx context.Context) error {
 	}
 	switch frame.Type {
 	case FrameOK:
-		// OK
-		return nil
+		// run-ctl processes predating protocol negotiation send an empty ack.
+		srv.proto = ProtoV1
+		if len(frame.Body) > 0 {
+			srv.proto = negotiateProto(frame.Body[0])
+		}
+		if len(frame.Body) >= 5 {
+			max := int(binary.LittleEndian.Uint32(frame.Body[1:5]))
+			srv.maxFrame = negotiateMaxFrameSize(srv.maxFrame, max)
+		}
+		srv.joined = true
+		return srv.setMaxFrameSize(srv.maxFrame)
 	case FrameErr:
 		return fmt.Errorf("received error /join-ack from run-ctl: %s", frame.Body)
 	default:
@@ -287,11 +480,34 @@ func (srv *Server) cmdsLoop(ctx context.Context) {
 }
 
 func (srv *Server) handleCmd(ctx context.Context, req Frame) {
+	resp, ok := srv.runCmd(ctx, req)
+	if !ok {
+		return
+	}
+
+	switch req.Path {
+	case "/status":
+		// ok. reply already sent.
+	default:
+		err := SendFrame(ctx, srv.rctl.sck, resp)
+		if err != nil {
+			srv.msg.Warnf("could not send ack cmd: %+v", err)
+		}
+	}
+}
+
+// runCmd runs the provided command and returns the reply to send back to
+// run-ctl, if any.
+// Commands are run one at a time, whether they were sent by run-ctl or
+// triggered by a signal received by the process.
+func (srv *Server) runCmd(ctx context.Context, req Frame) (Frame, bool) {
+	srv.cmdmu.Lock()
+	defer srv.cmdmu.Unlock()
+
 	var (
-		sck  = srv.rctl.sck
 		resp = Frame{Type: FrameOK}
 		next fsm.Status
-		err  error
+		vote bool // whether the command is a vote, leaving the state of the process unchanged
 	)
 
 	name := req.Path
@@ -301,12 +517,7 @@ func (srv *Server) handleCmd(ctx context.Context, req Frame) {
 		srv.msg.Warnf("invalid request path %q", name)
 		resp.Type = FrameErr
 		resp.Body = []byte(fmt.Errorf("invalid request path %q", name).Error())
-
-		err = SendFrame(ctx, sck, resp)
-		if err != nil {
-			srv.msg.Warnf("could not send ack cmd: %+v", err)
-		}
-		return
+		return resp, true
 	}
 
 	var onCmd func(ctx Context, req Frame) error
@@ -331,32 +542,76 @@ func (srv *Server) handleCmd(ctx context.Context, req Frame) {
 		srv.rungrp = rungrp
 
 		ctx = runctx
+	case "/go":
+		onCmd = srv.onGo
+		next = fsm.Running
+		ctx = srv.runctx
 	case "/stop":
 		onCmd = srv.onStop
 		next = fsm.Stopped
+	case "/pause":
+		onCmd = srv.onPause
+		next = fsm.Paused
+	case "/resume":
+		onCmd = srv.onResume
+		next = fsm.Running
 	case "/quit":
 		onCmd = srv.onQuit
 		next = fsm.Exiting
-		defer close(srv.done)
+		defer srv.exit()
 
 	case "/status":
 		onCmd = srv.onStatus
 		next = srv.getCurState()
+	case "/prepare":
+		onCmd = srv.onPrepare
+		next = srv.getCurState()
+		vote = true
+	case "/abort":
+		onCmd = srv.onAbort
+		next = srv.getCurState()
+		vote = true
+	case "/debug":
+		onCmd = func(ctx Context, req Frame) error {
+			return srv.onDebug(ctx, &resp, req)
+		}
+		next = srv.getCurState()
+		vote = true
+	case "/reconfig":
+		onCmd = srv.onReconfig
+		next = srv.getCurState()
+		vote = true
+	case "/calib":
+		onCmd = srv.onCalib
+		next = srv.getCurState()
+		vote = true
+	case "/snapshot":
+		onCmd = func(ctx Context, req Frame) error {
+			return srv.onSnapshot(ctx, &resp, req)
+		}
+		next = srv.getCurState()
+		vote = true
+	case "/restore":
+		onCmd = srv.onRestore
+		next = srv.getCurState()
+		vote = true
 
 	default:
 		srv.msg.Errorf("invalid cmd %q", name)
-		return
+		return resp, false
 	}
 
 	srv.setNextState(next)
 
-	tctx := Context{Ctx: ctx, Msg: srv.msg}
+	tctx := srv.context(ctx)
 	errPre := onCmd(tctx, req)
 	if errPre != nil {
 		srv.msg.Warnf("could not run %v pre-handler: %+v", name, errPre)
 		resp.Type = FrameErr
 		resp.Body = []byte(errPre.Error())
-		next = fsm.Error
+		if !vote {
+			next = fsm.Error
+		}
 	}
 
 	errH := h(tctx, &resp, req)
@@ -364,19 +619,34 @@ func (srv *Server) handleCmd(ctx context.Context, req Frame) {
 		srv.msg.Warnf("could not run %v handler: %+v", name, errH)
 		resp.Type = FrameErr
 		resp.Body = []byte(errH.Error())
-		next = fsm.Error
+		if !vote {
+			next = fsm.Error
+		}
 	}
 
 	srv.setCurState(next)
 
-	switch name {
-	case "/status":
-		// ok. reply already sent.
-	default:
-		err = SendFrame(ctx, sck, resp)
-		if err != nil {
-			srv.msg.Warnf("could not send ack cmd: %+v", err)
-		}
+	return resp, true
+}
+
+// context returns the TDAQ context of the process, wrapping ctx.
+func (srv *Server) context(ctx context.Context) Context {
+	return Context{
+		Ctx:    ctx,
+		Msg:    srv.msg,
+		Proto:  srv.proto,
+		Clock:  &srv.clock,
+		Alarms: srv.alarms,
+		Time:   srv.timeSource(),
+		peers:  srv.peers,
+		topics: srv.tmgr,
+		kv:     srv.kv,
+		ext:    srv.ext,
+		calibs: srv.calibs,
+		conds:  srv.getConditions(),
+
+		secrets: srv.secrets,
+		compact: srv.compact,
 	}
 }
 
@@ -384,11 +654,9 @@ func (srv *Server) onConfig(ctx Context, req Frame) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	switch srv.state.cur {
-	case fsm.UnConf, fsm.Conf, fsm.Error:
-		// ok
-	default:
-		return fmt.Errorf("%s: invalid state transition %v -> configured", srv.name, srv.state.cur)
+	err := srv.checkTransition(CmdConfig)
+	if err != nil {
+		return err
 	}
 
 	ierr := srv.imgr.onConfig(ctx, req)
@@ -396,6 +664,32 @@ func (srv *Server) onConfig(ctx Context, req Frame) error {
 		return fmt.Errorf("could not /config input-ports: %w", ierr)
 	}
 
+	srv.omgr.setMaxFrameSize(negotiateMaxFrameSize(
+		srv.maxFrame, int(srv.imgr.cfg.MaxFrameSize),
+	))
+
+	err = srv.omgr.setCodecs(srv.imgr.cfg.Encodings)
+	if err != nil {
+		return fmt.Errorf("could not /config output-ports: %w", err)
+	}
+
+	srv.peers.update(srv.imgr.cfg.Services, srv.maxFrame)
+	srv.kv.update(srv.imgr.cfg.KVVersion, srv.imgr.cfg.KV)
+	srv.ext.update(srv.imgr.cfg.Ext)
+	srv.compact.update(srv.imgr.cfg.Compact)
+
+	err = srv.secrets.load()
+	if err != nil {
+		return fmt.Errorf("could not /config secrets: %w", err)
+	}
+
+	err = srv.tmgr.onConfig(srv, srv.imgr.cfg.Topics)
+	if err != nil {
+		return fmt.Errorf("could not /config topics: %w", err)
+	}
+
+	srv.alarms.resend()
+
 	return nil
 }
 
@@ -403,11 +697,9 @@ func (srv *Server) onInit(ctx Context, req Frame) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	switch srv.state.cur {
-	case fsm.Conf:
-		// ok
-	default:
-		return fmt.Errorf("%s: invalid state transition %v -> initialized", srv.name, srv.state.cur)
+	err := srv.checkTransition(CmdInit)
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -417,11 +709,9 @@ func (srv *Server) onReset(ctx Context, req Frame) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	switch srv.state.cur {
-	case fsm.UnConf, fsm.Conf, fsm.Init, fsm.Stopped, fsm.Error:
-		// ok
-	default:
-		return fmt.Errorf("%s: invalid state transition %v -> reset", srv.name, srv.state.cur)
+	err := srv.checkTransition(CmdReset)
+	if err != nil {
+		return err
 	}
 
 	ierr := srv.imgr.onReset(ctx)
@@ -441,11 +731,17 @@ func (srv *Server) onStart(runctx Context, req Frame) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	switch srv.state.cur {
-	case fsm.Init, fsm.Stopped:
-		// ok
-	default:
-		return fmt.Errorf("%s: invalid state transition %v -> started", srv.name, srv.state.cur)
+	err := srv.checkTransition(CmdStart)
+	if err != nil {
+		return err
+	}
+
+	srv.stats.reset()
+
+	// armed processes only consume data until run-ctl broadcasts /go.
+	srv.armed = len(req.Body) > 1 && req.Body[1] == startArm
+	if srv.armed {
+		return srv.imgr.onStart(runctx)
 	}
 
 	for i := range srv.runfcts {
@@ -468,23 +764,49 @@ func (srv *Server) onStart(runctx Context, req Frame) error {
 	return nil
 }
 
+// onGo starts producing data, once all processes are armed.
+func (srv *Server) onGo(runctx Context, req Frame) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.state.cur != fsm.Running || !srv.armed {
+		return fmt.Errorf("%s: invalid /go command (state=%v, armed=%v)", srv.name, srv.state.cur, srv.armed)
+	}
+	srv.armed = false
+
+	for i := range srv.runfcts {
+		f := srv.runfcts[i]
+		srv.rungrp.Go(func() error {
+			return f(runctx)
+		})
+	}
+
+	return srv.omgr.onStart(runctx)
+}
+
 func (srv *Server) onStop(ctx Context, req Frame) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 
-	switch srv.state.cur {
-	case fsm.Running:
-		// ok
-	default:
-		return fmt.Errorf("%s: invalid state transition %v -> stopped", srv.name, srv.state.cur)
+	err := srv.checkTransition(CmdStop)
+	if err != nil {
+		return err
 	}
 
+	srv.unpause()
 	srv.rundone()
 	<-srv.runctx.Done()
 	werr := srv.rungrp.Wait()
 
 	ierr := srv.imgr.onStop(ctx)
-	oerr := srv.omgr.onStop(ctx)
+	var oerr error
+	switch {
+	case srv.armed:
+		// output ports were never started.
+		srv.armed = false
+	default:
+		oerr = srv.omgr.onStop(ctx)
+	}
 
 	switch {
 	case werr != nil:
@@ -513,9 +835,13 @@ func (srv *Server) onStatus(ctx Context, req Frame) error {
 	cmd := StatusCmd{
 		Name:   srv.name,
 		Status: state,
+		Links:  srv.imgr.links(),
+		Stats:  srv.stats.snapshot(),
+
+		Runtime: readRuntimeStats(),
 	}
 
-	err := SendCmd(ctx.Ctx, srv.rctl.sck, &cmd)
+	err := sendCmdProto(ctx.Ctx, srv.rctl.sck, &cmd, srv.proto)
 	if err != nil {
 		return fmt.Errorf("%s: could not send /status reply: %w", srv.name, err)
 	}
@@ -564,6 +890,16 @@ func (srv *Server) hbeatLoop(ctx context.Context) {
 }
 
 func (srv *Server) handleHBeat(ctx context.Context, frame Frame) error {
+	recv := srv.now()
+
+	req, err := newStatusCmd(frame)
+	if err != nil {
+		srv.msg.Warnf("could not decode /hbeat request: %+v", err)
+	}
+	if !req.Clock.Time.IsZero() {
+		srv.clock.set(req.Clock)
+	}
+
 	srv.mu.RLock()
 	defer srv.mu.RUnlock()
 
@@ -571,9 +907,17 @@ func (srv *Server) handleHBeat(ctx context.Context, frame Frame) error {
 	cmd := StatusCmd{
 		Name:   srv.name,
 		Status: state,
+		Links:  srv.imgr.links(),
+		Stats:  srv.stats.snapshot(),
+
+		Runtime: readRuntimeStats(),
+	}
+	if !req.Sent.IsZero() {
+		cmd.Recv = recv
+		cmd.Sent = srv.now()
 	}
 
-	err := SendCmd(ctx, srv.hbeat.sck, &cmd)
+	err = sendCmdProto(ctx, srv.hbeat.sck, &cmd, srv.proto)
 	if err != nil {
 		return fmt.Errorf("%s: could not send /hbeat reply: %w", srv.name, err)
 	}
@@ -592,15 +936,33 @@ func (srv *Server) close() {
 
 	srv.msg.Debugf("server shutting down...")
 
+	if err := srv.checkpoint(); err != nil {
+		srv.msg.Warnf("%+v", err)
+	}
+
 	srv.park(srv.hpark)
 	srv.park(srv.rpark)
 
 	srv.imgr.close()
 	srv.omgr.close()
 	srv.cmgr.close()
+	srv.svcs.close()
+	srv.peers.close()
+	srv.tmgr.close()
+	if err := srv.pprof.stop(); err != nil {
+		srv.msg.Warnf("%+v", err)
+	}
+	if err := srv.health.stop(); err != nil {
+		srv.msg.Warnf("%+v", err)
+	}
 	srv.msg.Debugf("server shutting down... [done]")
 }
 
+// exit signals the process is exiting.
+func (srv *Server) exit() {
+	srv.quit.Do(func() { close(srv.done) })
+}
+
 func (srv *Server) park(ch chan int) {
 	select {
 	case ch <- 1:
@@ -673,6 +1035,22 @@ func (msg *msgstream) Msg(lvl log.Level, format string, a ...interface{}) {
 	_, _ = msg.w.Write(str)
 }
 
+func (msg *msgstream) mon(name string, v float64) {
+	msg.mu.Lock()
+	sck := msg.sck
+	msg.mu.Unlock()
+
+	if sck == nil {
+		return
+	}
+
+	_ = sendEncoded(context.Background(), sck, FrameMsg, pathMon, nil, MonFrame{
+		Name:  strings.TrimSpace(msg.n),
+		Var:   name,
+		Value: v,
+	})
+}
+
 func (msg *msgstream) setLog(sck mangos.Socket) {
 	msg.mu.Lock()
 	msg.sck = sck
@@ -0,0 +1,286 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.nanomsg.org/mangos/v3"
+	"go.nanomsg.org/mangos/v3/protocol/rep"
+	"go.nanomsg.org/mangos/v3/protocol/req"
+)
+
+// ErrNoService is returned when calling a service no TDAQ process provides.
+var ErrNoService = errors.New("tdaq: no such service")
+
+// callTimeout is the maximum duration of calls to services, when the
+// context of the call has no deadline.
+const callTimeout = 10 * time.Second
+
+// ServiceHandler handles the requests sent to a service of a TDAQ process
+// by other TDAQ processes, and returns the reply.
+type ServiceHandler func(ctx Context, req []byte) ([]byte, error)
+
+// Servicer is implemented by devices providing services to other devices.
+type Servicer interface {
+	// Services returns the handlers of the services, indexed by service
+	// name (e.g. "/lookup").
+	Services() map[string]ServiceHandler
+}
+
+// Serve registers the handler of the named service, e.g. "/lookup".
+// Other TDAQ processes call the service with Context.Call.
+//
+// Services must be registered before the process is run.
+func (srv *Server) Serve(name string, h ServiceHandler) {
+	srv.svcs.Handle(name, h)
+}
+
+// Call sends the request to the named service of the provided TDAQ
+// process and returns its reply.
+//
+// Addresses of the services are distributed by run-ctl at /config: services
+// can be called once the process is configured.
+func (ctx Context) Call(proc, name string, req []byte) ([]byte, error) {
+	if ctx.peers == nil {
+		return nil, fmt.Errorf("could not call %s%s: %w", proc, name, ErrNoService)
+	}
+	return ctx.peers.call(ctx.Ctx, proc, name, req)
+}
+
+// svcmgr serves the services of a TDAQ process.
+type svcmgr struct {
+	mu  sync.RWMutex
+	hs  map[string]ServiceHandler
+	sck mangos.Socket
+	lis mangos.Listener
+}
+
+func newSvcMgr() *svcmgr {
+	return &svcmgr{hs: make(map[string]ServiceHandler)}
+}
+
+func (mgr *svcmgr) Handle(name string, h ServiceHandler) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.hs[name] = h
+}
+
+// init creates the socket serving the requests, if the process provides
+// any service.
+func (mgr *svcmgr) init(srv *Server) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	if len(mgr.hs) == 0 {
+		return nil
+	}
+
+	sck, lis, err := makeListener(rep.NewSocket, makeAddr(srv.cfg))
+	if err != nil {
+		return fmt.Errorf("could not create services socket: %w", err)
+	}
+	err = setMaxFrameSize(sck, srv.maxFrame)
+	if err != nil {
+		_ = lis.Close()
+		_ = sck.Close()
+		return fmt.Errorf("could not set maximum frame size of services socket: %w", err)
+	}
+	mgr.sck = sck
+	mgr.lis = lis
+	return nil
+}
+
+// addr returns the address of the socket serving the requests, if any.
+func (mgr *svcmgr) addr() string {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	if mgr.lis == nil {
+		return ""
+	}
+	return mgr.lis.Address()
+}
+
+// loop serves the requests, one at a time, until the socket is closed.
+func (mgr *svcmgr) loop(ctx context.Context, srv *Server) {
+	mgr.mu.RLock()
+	sck := mgr.sck
+	mgr.mu.RUnlock()
+	if sck == nil {
+		return
+	}
+
+	for {
+		req, err := RecvFrame(ctx, sck)
+		if err != nil {
+			if errors.Is(err, mangos.ErrClosed) {
+				return
+			}
+			srv.msg.Warnf("could not receive service request: %+v", err)
+			continue
+		}
+
+		resp := mgr.handle(srv.context(ctx), req)
+		err = SendFrame(ctx, sck, resp)
+		if err != nil {
+			srv.msg.Warnf("could not send %s reply: %+v", req.Path, err)
+		}
+	}
+}
+
+func (mgr *svcmgr) handle(ctx Context, req Frame) Frame {
+	mgr.mu.RLock()
+	h, ok := mgr.hs[req.Path]
+	mgr.mu.RUnlock()
+	if !ok {
+		return Frame{Type: FrameErr, Body: []byte(fmt.Sprintf("invalid service %q", req.Path))}
+	}
+
+	body, err := h(ctx, req.Body)
+	if err != nil {
+		return Frame{Type: FrameErr, Body: []byte(err.Error())}
+	}
+	return Frame{Type: FrameOK, Body: body}
+}
+
+func (mgr *svcmgr) close() {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if mgr.lis != nil {
+		_ = mgr.lis.Close()
+	}
+	if mgr.sck != nil {
+		_ = mgr.sck.Close()
+	}
+}
+
+// svcpeers holds the connections to the services of the other TDAQ processes.
+type svcpeers struct {
+	mu    sync.Mutex
+	max   int                 // maximum frame size
+	addrs map[string]string   // addresses of the services, indexed by process name
+	scks  map[string]*svcconn // connections to the services, indexed by process name
+}
+
+// svcconn is a connection to the services of a TDAQ process.
+// Calls over a connection are serialized.
+type svcconn struct {
+	mu  sync.Mutex
+	sck mangos.Socket
+}
+
+func newSvcPeers() *svcpeers {
+	return &svcpeers{
+		addrs: make(map[string]string),
+		scks:  make(map[string]*svcconn),
+	}
+}
+
+// update sets the addresses of the services of the TDAQ processes, closing
+// the connections to the processes that moved or left.
+func (ps *svcpeers) update(addrs map[string]string, max int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for proc, conn := range ps.scks {
+		if addr, ok := addrs[proc]; !ok || addr != ps.addrs[proc] || max != ps.max {
+			_ = conn.sck.Close()
+			delete(ps.scks, proc)
+		}
+	}
+	ps.addrs = make(map[string]string, len(addrs))
+	for proc, addr := range addrs {
+		ps.addrs[proc] = addr
+	}
+	ps.max = max
+}
+
+func (ps *svcpeers) conn(proc string) (*svcconn, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if conn, ok := ps.scks[proc]; ok {
+		return conn, nil
+	}
+
+	addr, ok := ps.addrs[proc]
+	if !ok {
+		return nil, ErrNoService
+	}
+
+	sck, err := req.NewSocket()
+	if err != nil {
+		return nil, fmt.Errorf("could not create socket: %w", err)
+	}
+	err = setMaxFrameSize(sck, ps.max)
+	if err != nil {
+		_ = sck.Close()
+		return nil, fmt.Errorf("could not set maximum frame size: %w", err)
+	}
+	err = sck.Dial(addr)
+	if err != nil {
+		_ = sck.Close()
+		return nil, fmt.Errorf("could not dial %q: %w", addr, err)
+	}
+	conn := &svcconn{sck: sck}
+	ps.scks[proc] = conn
+	return conn, nil
+}
+
+// call sends the request to the named service of the provided process and
+// returns its reply.
+// Concurrent calls to the same process are serialized over a single
+// connection.
+func (ps *svcpeers) call(ctx context.Context, proc, name string, body []byte) ([]byte, error) {
+	conn, err := ps.conn(proc)
+	if err != nil {
+		return nil, fmt.Errorf("could not call %s%s: %w", proc, name, err)
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	c := conn.sck
+
+	timeout := callTimeout
+	if dl, ok := ctx.Deadline(); ok {
+		timeout = time.Until(dl)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("could not call %s%s: %w", proc, name, context.DeadlineExceeded)
+	}
+	_ = c.SetOption(mangos.OptionSendDeadline, timeout)
+	_ = c.SetOption(mangos.OptionRecvDeadline, timeout)
+
+	err = SendFrame(ctx, c, Frame{Type: FrameData, Path: name, Body: body})
+	if err != nil {
+		return nil, fmt.Errorf("could not send request to %s%s: %w", proc, name, err)
+	}
+
+	resp, err := RecvFrame(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("could not receive reply from %s%s: %w", proc, name, err)
+	}
+	switch resp.Type {
+	case FrameOK:
+		return resp.Body, nil
+	case FrameErr:
+		return nil, fmt.Errorf("%s%s: %s", proc, name, resp.Body)
+	default:
+		return nil, fmt.Errorf("received invalid frame type %v from %s%s", resp.Type, proc, name)
+	}
+}
+
+func (ps *svcpeers) close() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for proc, conn := range ps.scks {
+		_ = conn.sck.Close()
+		delete(ps.scks, proc)
+	}
+}
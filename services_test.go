@@ -0,0 +1,68 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+func TestServices(t *testing.T) {
+	srv := New(config.Process{Name: "calib", Trans: "tcp"}, ioutil.Discard)
+	srv.Serve("/lookup", func(ctx Context, req []byte) ([]byte, error) {
+		switch string(req) {
+		case "adc":
+			return []byte("0.42"), nil
+		default:
+			return nil, fmt.Errorf("no constants for %q", req)
+		}
+	})
+
+	err := srv.svcs.init(srv)
+	if err != nil {
+		t.Fatalf("could not setup services: %+v", err)
+	}
+	defer srv.svcs.close()
+	go srv.svcs.loop(context.Background(), srv)
+
+	ps := newSvcPeers()
+	defer ps.close()
+	ps.update(map[string]string{"calib": srv.svcs.addr()}, 0)
+
+	ctx := Context{Ctx: context.Background(), peers: ps}
+	got, err := ctx.Call("calib", "/lookup", []byte("adc"))
+	if err != nil {
+		t.Fatalf("could not call service: %+v", err)
+	}
+	if got, want := string(got), "0.42"; got != want {
+		t.Fatalf("invalid reply: got=%q, want=%q", got, want)
+	}
+
+	_, err = ctx.Call("calib", "/lookup", []byte("tdc"))
+	if err == nil || !strings.Contains(err.Error(), `no constants for "tdc"`) {
+		t.Fatalf("expected a service error, got: %+v", err)
+	}
+
+	_, err = ctx.Call("calib", "/nope", nil)
+	if err == nil || !strings.Contains(err.Error(), `invalid service "/nope"`) {
+		t.Fatalf("expected an invalid service error, got: %+v", err)
+	}
+
+	_, err = ctx.Call("other", "/lookup", nil)
+	if !errors.Is(err, ErrNoService) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrNoService)
+	}
+
+	_, err = Context{Ctx: context.Background()}.Call("calib", "/lookup", nil)
+	if !errors.Is(err, ErrNoService) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrNoService)
+	}
+}
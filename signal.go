@@ -0,0 +1,145 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+	"go.nanomsg.org/mangos/v3/protocol/req"
+)
+
+// sigsLoop translates the signals received by the process into FSM
+// transitions, until the process exits.
+func (srv *Server) sigsLoop(ctx context.Context) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-srv.done:
+			return
+		case sig := <-sigs:
+			if !srv.onSignal(ctx, sig) {
+				return
+			}
+		}
+	}
+}
+
+// onSignal handles the provided signal and returns whether the process
+// keeps running:
+//   - SIGINT, SIGTERM: the process stops its run, if any, leaves run-ctl and
+//     exits, instead of dying in the middle of a frame,
+//   - SIGHUP: the process reloads its configuration.
+func (srv *Server) onSignal(ctx context.Context, sig os.Signal) bool {
+	switch sig {
+	case syscall.SIGHUP:
+		srv.msg.Infof("received %v: reloading configuration...", sig)
+		err := srv.reload()
+		if err != nil {
+			srv.msg.Errorf("could not reload configuration: %+v", err)
+		}
+		return true
+
+	default:
+		srv.msg.Infof("received %v: terminating...", sig)
+		srv.terminate(ctx)
+		return false
+	}
+}
+
+// reload reloads the configuration of the process held locally: its
+// secrets store.
+func (srv *Server) reload() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	err := srv.secrets.load()
+	if err != nil {
+		return fmt.Errorf("could not reload secrets: %w", err)
+	}
+	return nil
+}
+
+// terminate cleanly exits the process: the current run, if any, is stopped
+// (draining the data links), the process leaves run-ctl and quits.
+func (srv *Server) terminate(ctx context.Context) {
+	if st := srv.getCurState(); st == fsm.Running || st == fsm.Paused {
+		resp, _ := srv.runCmd(ctx, localCmd(CmdStop))
+		if resp.Type == FrameErr {
+			srv.msg.Warnf("could not stop run: %s", resp.Body)
+		}
+	}
+
+	err := srv.leave(ctx)
+	if err != nil {
+		srv.msg.Warnf("could not leave run-ctl: %+v", err)
+	}
+
+	srv.runCmd(ctx, localCmd(CmdQuit))
+}
+
+// localCmd returns the command frame of the provided command type, as if it
+// were sent by run-ctl.
+func localCmd(ctype CmdType) Frame {
+	return Frame{
+		Type: FrameCmd,
+		Path: string(cmdTypeToPath(ctype)),
+		Body: []byte{byte(ctype)},
+	}
+}
+
+// leave deregisters the process from run-ctl, so run-ctl stops driving
+// and monitoring it.
+func (srv *Server) leave(ctx context.Context) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if !srv.joined {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	sck, err := req.NewSocket()
+	if err != nil {
+		return fmt.Errorf("could not create /leave socket: %w", err)
+	}
+	defer sck.Close()
+
+	err = sck.Dial(srv.rc)
+	if err != nil {
+		return fmt.Errorf("could not dial /leave socket %q: %w", srv.rc, err)
+	}
+
+	err = sendCmd(ctx, sck, CmdLeave, []byte(srv.name))
+	if err != nil {
+		return fmt.Errorf("could not send /leave cmd to run-ctl: %w", err)
+	}
+
+	frame, err := RecvFrame(ctx, sck)
+	if err != nil {
+		return fmt.Errorf("could not recv /leave-ack from run-ctl: %w", err)
+	}
+	switch frame.Type {
+	case FrameOK:
+		srv.joined = false
+		return nil
+	case FrameErr:
+		return fmt.Errorf("received error /leave-ack from run-ctl: %s", frame.Body)
+	default:
+		return fmt.Errorf("received invalid /leave-ack frame from run-ctl (frame=%#v)", frame)
+	}
+}
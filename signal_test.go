@@ -0,0 +1,45 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"io/ioutil"
+	"syscall"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+)
+
+func TestServerSignal(t *testing.T) {
+	srv := New(config.Process{Name: "dev"}, ioutil.Discard)
+	srv.cmgr.init()
+
+	ctx := context.Background()
+	if !srv.onSignal(ctx, syscall.SIGHUP) {
+		t.Fatalf("process should keep running after SIGHUP")
+	}
+	select {
+	case <-srv.done:
+		t.Fatalf("process should not exit after SIGHUP")
+	default:
+	}
+
+	if srv.onSignal(ctx, syscall.SIGTERM) {
+		t.Fatalf("process should exit after SIGTERM")
+	}
+	select {
+	case <-srv.done:
+	default:
+		t.Fatalf("process did not exit after SIGTERM")
+	}
+	if got, want := srv.getCurState(), fsm.Exiting; got != want {
+		t.Fatalf("invalid state:\ngot = %v\nwant= %v", got, want)
+	}
+
+	// a /quit from run-ctl after a signal must not panic.
+	srv.runCmd(ctx, localCmd(CmdQuit))
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sim // import "github.com/go-daq/tdaq/sim"
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Clock is a virtual clock: its time only moves forward when advanced.
+//
+// Unlike the tickers of the time package, the tickers of a virtual clock
+// never drop ticks: advancing the clock delivers every tick due in the
+// elapsed period, in chronological order, and waits for each of them to be
+// received (or for its ticker to be stopped).
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*ticker
+}
+
+// NewClock returns a virtual clock, starting at t0.
+func NewClock(t0 time.Time) *Clock {
+	return &Clock{now: t0}
+}
+
+// Now returns the current time of the virtual clock.
+func (clk *Clock) Now() time.Time {
+	clk.mu.Lock()
+	defer clk.mu.Unlock()
+	return clk.now
+}
+
+// NewTicker returns a ticker delivering ticks with the provided period of
+// virtual time.
+func (clk *Clock) NewTicker(d time.Duration) tdaq.Ticker {
+	if d <= 0 {
+		panic("sim: non-positive interval for NewTicker")
+	}
+
+	clk.mu.Lock()
+	defer clk.mu.Unlock()
+
+	t := &ticker{
+		clk:    clk,
+		c:      make(chan time.Time),
+		done:   make(chan struct{}),
+		period: d,
+		next:   clk.now.Add(d),
+	}
+	clk.tickers = append(clk.tickers, t)
+	return t
+}
+
+// NewTimer returns a timer firing once, after the provided duration of
+// virtual time.
+//
+// Unlike tickers, advancing the clock does not wait for the tick of a timer
+// to be received.
+func (clk *Clock) NewTimer(d time.Duration) tdaq.Timer {
+	clk.mu.Lock()
+	defer clk.mu.Unlock()
+
+	t := &ticker{
+		clk:  clk,
+		c:    make(chan time.Time, 1),
+		done: make(chan struct{}),
+		next: clk.now.Add(d),
+	}
+	if d <= 0 {
+		t.c <- clk.now
+		return timer{t}
+	}
+	clk.tickers = append(clk.tickers, t)
+	return timer{t}
+}
+
+// Advance moves the virtual clock forward by d, delivering the ticks due in
+// that period.
+func (clk *Clock) Advance(d time.Duration) {
+	clk.mu.Lock()
+	end := clk.now.Add(d)
+	clk.mu.Unlock()
+
+	for {
+		clk.mu.Lock()
+		t := clk.due(end)
+		if t == nil {
+			clk.now = end
+			clk.mu.Unlock()
+			return
+		}
+		now := t.next
+		clk.now = now
+		if t.period == 0 {
+			// timers fire once, in their buffered channel.
+			clk.drop(t)
+			clk.mu.Unlock()
+			t.c <- now
+			continue
+		}
+		t.next = t.next.Add(t.period)
+		clk.mu.Unlock()
+
+		select {
+		case t.c <- now:
+		case <-t.done:
+		}
+	}
+}
+
+// due returns the ticker with the earliest tick due before end, or nil.
+// Tickers created first win ties, so ticks are delivered deterministically.
+// due must be called with clk.mu held.
+func (clk *Clock) due(end time.Time) *ticker {
+	var next *ticker
+	for _, t := range clk.tickers {
+		if t.next.After(end) {
+			continue
+		}
+		if next == nil || t.next.Before(next.next) {
+			next = t
+		}
+	}
+	return next
+}
+
+func (clk *Clock) remove(t *ticker) bool {
+	clk.mu.Lock()
+	defer clk.mu.Unlock()
+	return clk.drop(t)
+}
+
+// drop removes the provided ticker from the clock, and returns whether it
+// was still registered.
+// drop must be called with clk.mu held.
+func (clk *Clock) drop(t *ticker) bool {
+	for i, v := range clk.tickers {
+		if v == t {
+			clk.tickers = append(clk.tickers[:i], clk.tickers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ticker is a ticker of a virtual clock, or a timer if its period is zero.
+type ticker struct {
+	clk    *Clock
+	c      chan time.Time
+	done   chan struct{}
+	once   sync.Once
+	period time.Duration
+	next   time.Time // time of the next tick
+}
+
+func (t *ticker) C() <-chan time.Time { return t.c }
+
+func (t *ticker) Stop() {
+	t.once.Do(func() {
+		close(t.done)
+		t.clk.remove(t)
+	})
+}
+
+type timer struct {
+	t *ticker
+}
+
+func (t timer) C() <-chan time.Time { return t.t.c }
+func (t timer) Stop() bool          { return t.t.clk.remove(t.t) }
+
+var (
+	_ tdaq.TimeSource = (*Clock)(nil)
+	_ tdaq.Ticker     = (*ticker)(nil)
+	_ tdaq.Timer      = timer{}
+)
@@ -0,0 +1,202 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sim runs whole TDAQ partitions (a run-ctl and its TDAQ processes)
+// within a single process, over the in-memory transport and with a virtual
+// clock, so full-system scenarios run deterministically in unit tests.
+package sim // import "github.com/go-daq/tdaq/sim"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// Epoch is the default start time of the virtual clocks of partitions.
+var Epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// partitions is the number of partitions created by this process.
+var partitions uint64
+
+// Partition is a simulated TDAQ partition.
+type Partition struct {
+	Cfg     config.RunCtl // configuration of the run-ctl
+	Clock   *Clock        // virtual clock of the run-ctl
+	Timeout time.Duration // timeout for the processes to join the run-ctl
+
+	stdout io.Writer
+	tmps   []string // temporary files and directories of the partition
+
+	rc    *tdaq.RunControl
+	srvs  []*tdaq.Server
+	names map[string]struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	grp    errgroup.Group
+	errc   chan error
+}
+
+// New creates a new simulated partition, logging to stdout (or discarding
+// all logs if nil).
+//
+// No process is started yet and the partition configuration can be further
+// customized.
+func New(stdout io.Writer) *Partition {
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	n := atomic.AddUint64(&partitions, 1)
+	return &Partition{
+		Cfg: config.RunCtl{
+			Name:      "run-ctl",
+			Level:     log.LvlInfo,
+			Trans:     "inproc",
+			RunCtl:    fmt.Sprintf("tdaq-sim-%d", n),
+			HBeatFreq: 1 * time.Second,
+		},
+		Clock:   NewClock(Epoch),
+		Timeout: 5 * time.Second,
+		stdout:  stdout,
+		names:   make(map[string]struct{}),
+		errc:    make(chan error, 1),
+	}
+}
+
+// Add adds a TDAQ process to the partition, serving the provided device
+// (if not nil), and returns its server so further handlers may be
+// registered before the partition is started.
+//
+// Add panics if duplicate processes (identified by name) are added.
+func (p *Partition) Add(name string, dev tdaq.Device) *tdaq.Server {
+	if _, dup := p.names[name]; dup {
+		panic(fmt.Errorf("sim: duplicate process w/ name %q", name))
+	}
+	p.names[name] = struct{}{}
+
+	srv := tdaq.New(config.Process{
+		Name:   name,
+		Level:  p.Cfg.Level,
+		Trans:  p.Cfg.Trans,
+		RunCtl: p.Cfg.RunCtl,
+	}, p.stdout)
+	if dev != nil {
+		srv.Register(dev)
+	}
+	p.srvs = append(p.srvs, srv)
+	return srv
+}
+
+// RunControl returns the run-ctl of the started partition.
+func (p *Partition) RunControl() *tdaq.RunControl {
+	return p.rc
+}
+
+// Start starts the run-ctl and then all the TDAQ processes of the
+// partition, and waits for all of them to join the run-ctl.
+func (p *Partition) Start() error {
+	if p.Cfg.LogFile == "" {
+		f, err := ioutil.TempFile("", "tdaq-sim-")
+		if err != nil {
+			return fmt.Errorf("sim: could not create log file: %w", err)
+		}
+		_ = f.Close()
+		p.Cfg.LogFile = f.Name()
+		p.tmps = append(p.tmps, f.Name())
+	}
+	if p.Cfg.SummaryDir == "" {
+		dir, err := ioutil.TempDir("", "tdaq-sim-")
+		if err != nil {
+			return fmt.Errorf("sim: could not create summary dir: %w", err)
+		}
+		p.Cfg.SummaryDir = dir
+		p.tmps = append(p.tmps, dir)
+	}
+
+	rc, err := tdaq.NewRunControl(p.Cfg, p.stdout)
+	if err != nil {
+		return fmt.Errorf("sim: could not create run-ctl: %w", err)
+	}
+	rc.UseTimeSource(p.Clock)
+	p.rc = rc
+
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	go func() {
+		p.errc <- p.rc.Run(p.ctx)
+	}()
+
+	for i := range p.srvs {
+		srv := p.srvs[i]
+		p.grp.Go(func() error {
+			return srv.Run(p.ctx)
+		})
+	}
+
+	timeout := time.NewTimer(p.Timeout)
+	defer timeout.Stop()
+
+	for p.rc.NumClients() != len(p.srvs) {
+		select {
+		case <-timeout.C:
+			return fmt.Errorf("sim: processes did not join before timeout (%v)", p.Timeout)
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	return nil
+}
+
+// Do sends the provided command to all the TDAQ processes of the partition.
+func (p *Partition) Do(ctx context.Context, cmd tdaq.CmdType) error {
+	return p.rc.Do(ctx, cmd)
+}
+
+// Advance advances the virtual clock of the partition by d, delivering the
+// heartbeats and other periodic tasks due in that period.
+func (p *Partition) Advance(d time.Duration) {
+	p.Clock.Advance(d)
+}
+
+// Close terminates the TDAQ processes and the run-ctl of the partition and
+// waits for them to shut down.
+func (p *Partition) Close() error {
+	if p.rc == nil {
+		return nil
+	}
+	defer func() {
+		for _, name := range p.tmps {
+			_ = os.RemoveAll(name)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	err := p.rc.Do(ctx, tdaq.CmdQuit)
+	if err != nil {
+		p.cancel()
+	}
+
+	err1 := p.grp.Wait()
+	p.cancel()
+	err2 := <-p.errc
+
+	if err1 != nil {
+		return fmt.Errorf("sim: error while running processes: %w", err1)
+	}
+	if err2 != nil && !errors.Is(err2, context.Canceled) {
+		return fmt.Errorf("sim: error shutting down run-ctl: %w", err2)
+	}
+	return nil
+}
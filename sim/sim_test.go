@@ -0,0 +1,273 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sim_test // import "github.com/go-daq/tdaq/sim"
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/sim"
+)
+
+type nop struct{}
+
+func (nop) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error { return nil }
+func (nop) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error   { return nil }
+func (nop) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error  { return nil }
+func (nop) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error  { return nil }
+func (nop) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error   { return nil }
+func (nop) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error   { return nil }
+
+// gen generates one value per tick of the virtual clock.
+type gen struct {
+	nop
+	clk  *sim.Clock
+	freq time.Duration
+
+	tck tdaq.Ticker
+	n   int64
+}
+
+func (dev *gen) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	dev.tck = dev.clk.NewTicker(dev.freq)
+	return nil
+}
+
+func (dev *gen) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	dev.tck.Stop()
+	return nil
+}
+
+func (dev *gen) Outputs() map[string]tdaq.OutputHandler {
+	return map[string]tdaq.OutputHandler{"/i64": dev.output}
+}
+
+func (dev *gen) output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+	case <-dev.tck.C():
+		dst.Body = make([]byte, 8)
+		binary.LittleEndian.PutUint64(dst.Body, uint64(dev.n))
+		dev.n++
+	}
+	return nil
+}
+
+// sink records the received values.
+type sink struct {
+	nop
+
+	mu   sync.Mutex
+	vals []int64
+}
+
+func (dev *sink) Inputs() map[string]tdaq.InputHandler {
+	return map[string]tdaq.InputHandler{"/i64": dev.input}
+}
+
+func (dev *sink) input(ctx tdaq.Context, src tdaq.Frame) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.vals = append(dev.vals, int64(binary.LittleEndian.Uint64(src.Body)))
+	return nil
+}
+
+func (dev *sink) wait(n int) ([]int64, error) {
+	timeout := time.After(5 * time.Second)
+	for {
+		dev.mu.Lock()
+		vals := append([]int64(nil), dev.vals...)
+		dev.mu.Unlock()
+		if len(vals) >= n {
+			return vals, nil
+		}
+		select {
+		case <-timeout:
+			return vals, fmt.Errorf("timeout waiting for %d values (got=%d)", n, len(vals))
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	p := sim.New(nil)
+	var (
+		src = &gen{clk: p.Clock, freq: 100 * time.Millisecond}
+		dst = new(sink)
+	)
+	p.Add("gen", src)
+	p.Add("sink", dst)
+
+	err := p.Start()
+	if err != nil {
+		t.Fatalf("could not start partition: %+v", err)
+	}
+	defer func() {
+		err := p.Close()
+		if err != nil {
+			t.Fatalf("could not close partition: %+v", err)
+		}
+	}()
+
+	ctx := context.Background()
+	for _, cmd := range []tdaq.CmdType{tdaq.CmdConfig, tdaq.CmdInit} {
+		err := p.Do(ctx, cmd)
+		if err != nil {
+			t.Fatalf("could not run %v: %+v", cmd, err)
+		}
+	}
+
+	want := []int64{}
+	for i, n := range []int{5, 3} {
+		err := p.Do(ctx, tdaq.CmdStart)
+		if err != nil {
+			t.Fatalf("could not start run #%d: %+v", i, err)
+		}
+		for j := 0; j < n; j++ {
+			p.Advance(src.freq)
+			want = append(want, int64(len(want)))
+		}
+		got, err := dst.wait(len(want))
+		if err != nil {
+			t.Fatalf("run #%d: %+v", i, err)
+		}
+		err = p.Do(ctx, tdaq.CmdStop)
+		if err != nil {
+			t.Fatalf("could not stop run #%d: %+v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("run #%d: invalid values:\ngot = %v\nwant= %v", i, got, want)
+		}
+	}
+
+	if got, want := p.Clock.Now(), sim.Epoch.Add(8*src.freq); !got.Equal(want) {
+		t.Fatalf("invalid virtual time:\ngot = %v\nwant= %v", got, want)
+	}
+}
+
+type faulty struct {
+	nop
+}
+
+func (faulty) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	return fmt.Errorf("boom")
+}
+
+func TestPartitionFailure(t *testing.T) {
+	p := sim.New(nil)
+	p.Add("ok", nop{})
+	p.Add("faulty", faulty{})
+
+	err := p.Start()
+	if err != nil {
+		t.Fatalf("could not start partition: %+v", err)
+	}
+	defer p.Close()
+
+	ctx := context.Background()
+	for _, cmd := range []tdaq.CmdType{tdaq.CmdConfig, tdaq.CmdInit} {
+		err := p.Do(ctx, cmd)
+		if err != nil {
+			t.Fatalf("could not run %v: %+v", cmd, err)
+		}
+	}
+
+	// heartbeats are only exchanged as the virtual clock advances.
+	p.Advance(10 * p.Cfg.HBeatFreq)
+
+	err = p.Do(ctx, tdaq.CmdStart)
+	if err == nil {
+		t.Fatalf("expected an error starting the faulty process")
+	}
+}
+
+func TestClock(t *testing.T) {
+	clk := sim.NewClock(sim.Epoch)
+	var (
+		t1 = clk.NewTicker(2 * time.Second)
+		t2 = clk.NewTicker(3 * time.Second)
+
+		got  []string
+		done = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t1.C():
+				got = append(got, fmt.Sprintf("t1@%v", now.Sub(sim.Epoch)))
+			case now := <-t2.C():
+				got = append(got, fmt.Sprintf("t2@%v", now.Sub(sim.Epoch)))
+			}
+		}
+	}()
+
+	clk.Advance(6 * time.Second)
+	t1.Stop()
+	clk.Advance(3 * time.Second)
+	t2.Stop()
+	close(done)
+	wg.Wait()
+
+	want := []string{"t1@2s", "t2@3s", "t1@4s", "t1@6s", "t2@6s", "t2@9s"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid ticks:\ngot = %q\nwant= %q", got, want)
+	}
+	if got, want := clk.Now(), sim.Epoch.Add(9*time.Second); !got.Equal(want) {
+		t.Fatalf("invalid time:\ngot = %v\nwant= %v", got, want)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	clk := sim.NewClock(sim.Epoch)
+	var (
+		t1 = clk.NewTimer(2 * time.Second)
+		t2 = clk.NewTimer(5 * time.Second)
+		t3 = clk.NewTimer(0)
+	)
+
+	select {
+	case now := <-t3.C():
+		if !now.Equal(sim.Epoch) {
+			t.Fatalf("invalid immediate tick: got=%v, want=%v", now, sim.Epoch)
+		}
+	default:
+		t.Fatalf("immediate timer did not fire")
+	}
+
+	clk.Advance(3 * time.Second)
+	select {
+	case now := <-t1.C():
+		if got, want := now, sim.Epoch.Add(2*time.Second); !got.Equal(want) {
+			t.Fatalf("invalid tick: got=%v, want=%v", got, want)
+		}
+	default:
+		t.Fatalf("timer did not fire")
+	}
+	if t1.Stop() {
+		t.Fatalf("fired timer could be stopped")
+	}
+
+	if !t2.Stop() {
+		t.Fatalf("pending timer could not be stopped")
+	}
+	clk.Advance(3 * time.Second)
+	select {
+	case now := <-t2.C():
+		t.Fatalf("stopped timer fired at %v", now)
+	default:
+	}
+}
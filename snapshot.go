@@ -0,0 +1,426 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+)
+
+// Snapshots capture the state of a whole TDAQ partition into a single
+// gzip-compressed tar archive, holding:
+//
+//	snapshot.json      -- run metadata (SnapshotMeta)
+//	kv.json            -- key-value configuration store, with its history
+//	ext.bin            -- extension fields of the /config commands
+//	procs/<name>.state -- state of the device of each process
+//
+// The state of the devices is provided by the functions registered with
+// Server.Checkpoint, and restored by the functions registered with
+// Server.Restore.
+
+// SnapshotVersion is the version of the format of snapshot archives.
+const SnapshotVersion = 1
+
+// maxSnapshotEntry is the maximum size of the entries of snapshot archives.
+const maxSnapshotEntry = 256 << 20
+
+// SnapshotMeta describes a snapshot of a TDAQ partition.
+type SnapshotMeta struct {
+	Version int       `json:"version"` // version of the format of the archive
+	Time    time.Time `json:"time"`    // time of the snapshot
+	RunCtl  string    `json:"run-ctl"` // name of the run-ctl which took the snapshot
+	Status  string    `json:"status"`  // status of the partition at the time of the snapshot
+	RunNbr  uint64    `json:"run"`     // current run number at the time of the snapshot
+	Procs   []string  `json:"procs"`   // processes whose device state was saved
+}
+
+// snapshot is the content of a snapshot archive.
+type snapshot struct {
+	meta  SnapshotMeta
+	kv    kvState
+	ext   map[string]ConfigField
+	procs map[string][]byte // device states, indexed by process name
+}
+
+func (snap *snapshot) write(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	add := func(name string, raw []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(raw)),
+			ModTime: snap.meta.Time,
+		})
+		if err != nil {
+			return fmt.Errorf("could not write header of %q: %w", name, err)
+		}
+		_, err = tw.Write(raw)
+		if err != nil {
+			return fmt.Errorf("could not write %q: %w", name, err)
+		}
+		return nil
+	}
+
+	meta, err := json.MarshalIndent(snap.meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode snapshot metadata: %w", err)
+	}
+	err = add("snapshot.json", meta)
+	if err != nil {
+		return err
+	}
+
+	kv, err := json.MarshalIndent(snap.kv, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode key-value store: %w", err)
+	}
+	err = add("kv.json", kv)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	writeConfigFields(enc, snap.ext)
+	if enc.err != nil {
+		return fmt.Errorf("could not encode config extension fields: %w", enc.err)
+	}
+	err = add("ext.bin", buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for _, name := range snap.meta.Procs {
+		err = add("procs/"+name+".state", snap.procs[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("could not close snapshot archive: %w", err)
+	}
+	err = zw.Close()
+	if err != nil {
+		return fmt.Errorf("could not close snapshot compressor: %w", err)
+	}
+	return nil
+}
+
+func (snap *snapshot) read(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("could not open snapshot decompressor: %w", err)
+	}
+	defer zr.Close()
+
+	var (
+		tr   = tar.NewReader(zr)
+		meta bool
+	)
+	snap.procs = make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read snapshot archive: %w", err)
+		}
+		if hdr.Size < 0 || hdr.Size > maxSnapshotEntry {
+			return fmt.Errorf("invalid snapshot archive entry %q size (size=%d, max=%d)", hdr.Name, hdr.Size, maxSnapshotEntry)
+		}
+		raw, err := ioutil.ReadAll(io.LimitReader(tr, hdr.Size))
+		if err != nil {
+			return fmt.Errorf("could not read %q: %w", hdr.Name, err)
+		}
+		if int64(len(raw)) != hdr.Size {
+			return fmt.Errorf("could not read %q: %w", hdr.Name, io.ErrUnexpectedEOF)
+		}
+
+		switch name := hdr.Name; {
+		case name == "snapshot.json":
+			err = json.Unmarshal(raw, &snap.meta)
+			if err != nil {
+				return fmt.Errorf("could not decode snapshot metadata: %w", err)
+			}
+			meta = true
+		case name == "kv.json":
+			err = json.Unmarshal(raw, &snap.kv)
+			if err != nil {
+				return fmt.Errorf("could not decode key-value store: %w", err)
+			}
+		case name == "ext.bin":
+			dec := NewDecoder(bytes.NewReader(raw))
+			snap.ext = readConfigFields(dec)
+			if dec.err != nil {
+				return fmt.Errorf("could not decode config extension fields: %w", dec.err)
+			}
+		case strings.HasPrefix(name, "procs/") && strings.HasSuffix(name, ".state"):
+			proc := strings.TrimSuffix(strings.TrimPrefix(name, "procs/"), ".state")
+			snap.procs[proc] = raw
+		default:
+			return fmt.Errorf("invalid snapshot archive entry %q", name)
+		}
+	}
+
+	switch {
+	case !meta:
+		return fmt.Errorf("invalid snapshot archive: no metadata")
+	case snap.meta.Version != SnapshotVersion:
+		return fmt.Errorf("invalid snapshot archive version %d (want=%d)", snap.meta.Version, SnapshotVersion)
+	}
+	return nil
+}
+
+// onSnapshot replies with the state of the device, saved by the function
+// registered with Checkpoint.
+// Processes without such a function reply with an empty state.
+func (srv *Server) onSnapshot(ctx Context, resp *Frame, req Frame) error {
+	srv.mu.RLock()
+	save := srv.ckpt.save
+	srv.mu.RUnlock()
+
+	if save == nil {
+		return nil
+	}
+
+	state, err := save()
+	if err != nil {
+		return fmt.Errorf("%s: could not snapshot device state: %w", srv.name, err)
+	}
+	resp.Body = state
+	return nil
+}
+
+// onRestore restores the state of the device, saved by a snapshot of the
+// partition, with the function registered with Restore.
+func (srv *Server) onRestore(ctx Context, req Frame) error {
+	srv.mu.RLock()
+	var (
+		load = srv.ckpt.load
+		cur  = srv.state.cur
+	)
+	srv.mu.RUnlock()
+
+	switch cur {
+	case fsm.UnConf, fsm.Conf, fsm.Init, fsm.Stopped:
+		// ok.
+	default:
+		return fmt.Errorf("%s: invalid /restore command (state=%v)", srv.name, cur)
+	}
+
+	cmd, err := newRestoreCmd(req)
+	if err != nil {
+		return fmt.Errorf("%s: could not decode /restore cmd: %w", srv.name, err)
+	}
+
+	if load == nil {
+		if len(cmd.State) > 0 {
+			return fmt.Errorf("%s: /restore not supported", srv.name)
+		}
+		return nil
+	}
+
+	err = load(cmd.State)
+	if err != nil {
+		return fmt.Errorf("%s: could not restore device state: %w", srv.name, err)
+	}
+
+	// make sure a restart of the process resumes from the restored state.
+	err = srv.checkpoint()
+	if err != nil {
+		srv.msg.Warnf("%+v", err)
+	}
+	return nil
+}
+
+// Snapshot writes to w a snapshot of the state of the TDAQ partition: the
+// run metadata, the key-value configuration store, the extension fields of
+// the /config commands and the state of the devices of all the connected
+// TDAQ processes.
+func (rc *RunControl) Snapshot(ctx context.Context, w io.Writer) (SnapshotMeta, error) {
+	rc.snapmu.Lock()
+	defer rc.snapmu.Unlock()
+
+	// the state of run-ctl is copied under rc.mu, the devices are then
+	// queried without holding it, so they can not block run-ctl.
+	rc.mu.RLock()
+	snap := snapshot{
+		meta: SnapshotMeta{
+			Version: SnapshotVersion,
+			Time:    time.Now().UTC(),
+			RunCtl:  rc.cfg.Name,
+			Status:  rc.status.String(),
+			RunNbr:  rc.runNbr,
+			Procs:   []string{},
+		},
+		kv:    rc.kv.snapshot(),
+		ext:   make(map[string]ConfigField, len(rc.ext)),
+		procs: make(map[string][]byte, rc.clients.len()),
+	}
+	for name, f := range rc.ext {
+		snap.ext[name] = f
+	}
+	clis := make([]*client, 0, len(rc.deps))
+	for _, name := range rc.deps {
+		cli := rc.clients.get(name)
+		if cli == nil {
+			continue
+		}
+		clis = append(clis, cli)
+	}
+	rc.mu.RUnlock()
+
+	rc.replies.reset(CmdSnapshot)
+	for _, cli := range clis {
+		err := rc.command(ctx, cli, CmdSnapshot)
+		if err != nil {
+			return snap.meta, fmt.Errorf("could not snapshot %q: %w", cli.name, err)
+		}
+		snap.meta.Procs = append(snap.meta.Procs, cli.name)
+		snap.procs[cli.name] = rc.replies.get(CmdSnapshot).Procs[cli.name]
+	}
+
+	err := snap.write(w)
+	if err != nil {
+		return snap.meta, fmt.Errorf("could not write snapshot: %w", err)
+	}
+	rc.msg.Infof("snapshot of run %d with %d processes", snap.meta.RunNbr, len(snap.meta.Procs))
+
+	return snap.meta, nil
+}
+
+// Restore restores the state of the TDAQ partition from the snapshot read
+// from r: the run metadata, the key-value configuration store, the
+// extension fields of the /config commands and the state of the devices of
+// the connected TDAQ processes.
+//
+// The partition must not be running.
+// Connected processes missing from the snapshot, and processes of the
+// snapshot which are not connected, are reported but do not fail the
+// restoration, so snapshots may be used to clone a test setup.
+func (rc *RunControl) Restore(ctx context.Context, r io.Reader) (_ SnapshotMeta, err error) {
+	err = rc.control(ctx, CmdRestore.String(), rc.targets(nil), nil)
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+
+	defer func(beg time.Time, targets []string) {
+		rc.audit(Operator(ctx), CmdRestore.String(), targets, nil, beg, err)
+	}(time.Now(), rc.targets(nil))
+
+	var snap snapshot
+	err = snap.read(r)
+	if err != nil {
+		return snap.meta, fmt.Errorf("could not read snapshot: %w", err)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	switch rc.status {
+	case fsm.UnConf, fsm.Conf, fsm.Init, fsm.Stopped:
+		// ok.
+	default:
+		return snap.meta, fmt.Errorf("could not restore snapshot (state=%v)", rc.status)
+	}
+
+	err = rc.kv.load(snap.kv)
+	if err != nil {
+		return snap.meta, fmt.Errorf("could not restore key-value store: %w", err)
+	}
+	rc.ext = snap.ext
+	rc.runNbr = snap.meta.RunNbr
+
+	names := make([]string, 0, len(snap.procs))
+	for name := range snap.procs {
+		if rc.clients.get(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rc.msg.Warnf("snapshot process %q not connected: state not restored", name)
+	}
+
+	var errs []error
+	for _, name := range rc.deps {
+		cli := rc.clients.get(name)
+		if cli == nil {
+			continue
+		}
+		state, ok := snap.procs[name]
+		if !ok {
+			rc.msg.Warnf("process %q not in snapshot: state not restored", name)
+			continue
+		}
+		err := rc.requestCmd(ctx, cli, &RestoreCmd{State: state})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not restore %q: %w", name, err))
+			continue
+		}
+	}
+
+	if len(errs) > 0 {
+		return snap.meta, errs[0]
+	}
+	rc.msg.Infof("restored snapshot of run %d (%s)", snap.meta.RunNbr, snap.meta.Time.Format(time.RFC3339))
+	return snap.meta, nil
+}
+
+// webAPISnapshot serves snapshots of the TDAQ partition.
+// GET replies with a snapshot archive; POST restores the snapshot archive
+// of the request body.
+func (rc *RunControl) webAPISnapshot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		buf := new(bytes.Buffer)
+		meta, err := rc.Snapshot(r.Context(), buf)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(
+			"attachment; filename=%q", fmt.Sprintf("tdaq-snapshot-%d.tar.gz", meta.RunNbr),
+		))
+		_, err = w.Write(buf.Bytes())
+		if err != nil {
+			rc.msg.Errorf("could not send snapshot: %+v", err)
+		}
+	case http.MethodPost:
+		ctx := webControl(r)
+		meta, err := rc.Restore(ctx, r.Body)
+		setWebControl(ctx, w, r)
+		if err != nil {
+			http.Error(w, err.Error(), webControlStatus(err, http.StatusBadRequest))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(meta)
+		if err != nil {
+			rc.msg.Errorf("could not encode snapshot metadata: %+v", err)
+		}
+	default:
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+	}
+}
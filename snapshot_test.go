@@ -0,0 +1,155 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+)
+
+func TestSnapshot(t *testing.T) {
+	srv := New(config.Process{Name: "dev"}, ioutil.Discard)
+	srv.cmgr.init()
+
+	var state = "evts=42"
+	srv.Checkpoint(func() ([]byte, error) {
+		return []byte(state), nil
+	})
+	srv.Restore(func(p []byte) error {
+		state = string(p)
+		return nil
+	})
+
+	resp, _ := srv.runCmd(context.Background(), localCmd(CmdSnapshot))
+	if resp.Type != FrameOK {
+		t.Fatalf("could not run /snapshot: %s", resp.Body)
+	}
+	if got, want := string(resp.Body), "evts=42"; got != want {
+		t.Fatalf("invalid snapshot state:\ngot = %q\nwant= %q", got, want)
+	}
+
+	kv, err := newKVDB("", nil)
+	if err != nil {
+		t.Fatalf("could not create key-value store: %+v", err)
+	}
+	_, err = kv.set("dev/threshold", "12", "bob")
+	if err != nil {
+		t.Fatalf("could not set key: %+v", err)
+	}
+
+	want := snapshot{
+		meta: SnapshotMeta{
+			Version: SnapshotVersion,
+			Time:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			RunCtl:  "run-ctl",
+			Status:  fsm.Stopped.String(),
+			RunNbr:  42,
+			Procs:   []string{"dev"},
+		},
+		kv:    kv.snapshot(),
+		ext:   map[string]ConfigField{"gain": F64Field(2.5)},
+		procs: map[string][]byte{"dev": resp.Body},
+	}
+
+	buf := new(bytes.Buffer)
+	err = want.write(buf)
+	if err != nil {
+		t.Fatalf("could not write snapshot: %+v", err)
+	}
+
+	var got snapshot
+	err = got.read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("could not read snapshot: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid snapshot round-trip:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	raw, err := RestoreCmd{State: []byte("evts=7")}.MarshalTDAQ()
+	if err != nil {
+		t.Fatalf("could not marshal /restore: %+v", err)
+	}
+	req := localCmd(CmdRestore)
+	req.Body = append(req.Body, raw...)
+	resp, _ = srv.runCmd(context.Background(), req)
+	if resp.Type != FrameOK {
+		t.Fatalf("could not run /restore: %s", resp.Body)
+	}
+	if got, want := state, "evts=7"; got != want {
+		t.Fatalf("invalid restored state:\ngot = %q\nwant= %q", got, want)
+	}
+
+	srv.setCurState(fsm.Running)
+	resp, _ = srv.runCmd(context.Background(), req)
+	if resp.Type != FrameErr {
+		t.Fatalf("expected an error restoring a running process")
+	}
+	if got, want := srv.getCurState(), fsm.Running; got != want {
+		t.Fatalf("invalid state:\ngot = %v\nwant= %v", got, want)
+	}
+}
+
+func TestSnapshotArchiveLimits(t *testing.T) {
+	archive := func(size int64, data []byte) []byte {
+		buf := new(bytes.Buffer)
+		zw := gzip.NewWriter(buf)
+		tw := tar.NewWriter(zw)
+		err := tw.WriteHeader(&tar.Header{Name: "procs/dev.state", Mode: 0644, Size: size})
+		if err != nil {
+			t.Fatalf("could not write header: %+v", err)
+		}
+		_, err = tw.Write(data)
+		if err != nil {
+			t.Fatalf("could not write entry: %+v", err)
+		}
+		// the archive is not closed: the entry is truncated.
+		err = zw.Close()
+		if err != nil {
+			t.Fatalf("could not close compressor: %+v", err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, tc := range []struct {
+		name string
+		size int64
+		data []byte
+		want string
+	}{
+		{
+			name: "too-large",
+			size: maxSnapshotEntry + 1,
+			want: `invalid snapshot archive entry "procs/dev.state" size`,
+		},
+		{
+			name: "truncated",
+			size: 1024,
+			data: []byte("evts=42"),
+			want: `could not read "procs/dev.state"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var snap snapshot
+			err := snap.read(bytes.NewReader(archive(tc.size, tc.data)))
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if got := err.Error(); !strings.Contains(got, tc.want) {
+				t.Fatalf("invalid error:\ngot = %q\nwant= %q", got, tc.want)
+			}
+		})
+	}
+}
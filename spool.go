@@ -0,0 +1,284 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrSpoolFull is returned when a data frame could not be spooled because
+// the spool file reached its maximum size.
+var ErrSpoolFull = errors.New("tdaq: spool file is full")
+
+// spool spools the data frames of an output end-point to local disk while
+// no consumer is connected.
+// Spooled frames are replayed in order, before any new frame, once a
+// consumer is connected again.
+//
+// A spool file is a sequence of records, each made of the size (u32) of
+// an encoded frame followed by that encoded frame.
+// Frames left over by a previous process are replayed as well.
+type spool struct {
+	mu   sync.Mutex
+	f    *os.File
+	max  int64  // maximum size of the spool file (0: unbounded)
+	beg  int64  // offset of the next frame to replay
+	end  int64  // offset of the end of the last spooled frame
+	n    int    // number of spooled frames
+	drop uint64 // number of frames that could not be spooled
+}
+
+// spoolName returns the name of the spool file of the named end-point.
+func spoolName(dir, ep string) string {
+	return epFileName(dir, ep, ".spool")
+}
+
+// epFileName returns the name of a file of the named end-point, with the
+// provided extension.
+func epFileName(dir, ep, ext string) string {
+	name := strings.Replace(strings.Trim(ep, "/"), "/", "_", -1)
+	if name == "" {
+		name = "_"
+	}
+	return filepath.Join(dir, name+ext)
+}
+
+func newSpool(dir, ep string, max int64) (*spool, error) {
+	return openSpool(dir, spoolName(dir, ep), ep, max)
+}
+
+// openSpool opens the named spool file of an end-point, creating dir if needed.
+func openSpool(dir, fname, ep string, max int64) (*spool, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create spool directory: %w", err)
+	}
+
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open spool file for %q: %w", ep, err)
+	}
+
+	sp := &spool{f: f, max: max}
+	err = sp.scan()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("could not scan spool file for %q: %w", ep, err)
+	}
+
+	return sp, nil
+}
+
+// scan counts the frames of the spool file, discarding a trailing
+// incomplete record.
+func (sp *spool) scan() error {
+	fi, err := sp.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var (
+		size = fi.Size()
+		hdr  [4]byte
+	)
+	for sp.end+4 <= size {
+		_, err = sp.f.ReadAt(hdr[:], sp.end)
+		if err != nil {
+			return err
+		}
+		n := int64(binary.LittleEndian.Uint32(hdr[:]))
+		if sp.end+4+n > size {
+			break
+		}
+		sp.end += 4 + n
+		sp.n++
+	}
+
+	if sp.end != size {
+		return sp.f.Truncate(sp.end)
+	}
+	return nil
+}
+
+// pending returns the number of spooled frames.
+func (sp *spool) pending() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.n
+}
+
+// push appends the provided frame to the spool file.
+func (sp *spool) push(frame Frame) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	msg := frame.encode()
+	if sp.max > 0 && sp.end+4+int64(len(msg)) > sp.max {
+		sp.drop++
+		return ErrSpoolFull
+	}
+
+	buf := make([]byte, 4+len(msg))
+	binary.LittleEndian.PutUint32(buf[:4], uint32(len(msg)))
+	copy(buf[4:], msg)
+
+	_, err := sp.f.WriteAt(buf, sp.end)
+	if err != nil {
+		return fmt.Errorf("could not spool data frame: %w", err)
+	}
+	sp.end += int64(len(buf))
+	sp.n++
+	return nil
+}
+
+// replay sends the spooled frames, in order.
+// replay stops at the first frame that could not be sent: that frame and
+// the following ones are kept for a later replay.
+func (sp *spool) replay(send func(frame Frame) error) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	for sp.n > 0 {
+		frame, size, err := sp.read(sp.beg)
+		if err != nil {
+			return err
+		}
+
+		err = send(frame)
+		if err != nil {
+			return err
+		}
+		sp.beg += size
+		sp.n--
+	}
+
+	return sp.reclaim()
+}
+
+// each calls fn with each spooled frame, in order, without removing them
+// from the spool file.
+// each stops at the first error returned by fn.
+func (sp *spool) each(fn func(frame Frame) error) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	off := sp.beg
+	for i := 0; i < sp.n; i++ {
+		frame, size, err := sp.read(off)
+		if err != nil {
+			return err
+		}
+		err = fn(frame)
+		if err != nil {
+			return err
+		}
+		off += size
+	}
+	return nil
+}
+
+// skip removes the n oldest frames from the spool file.
+func (sp *spool) skip(n int) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	var hdr [4]byte
+	for ; n > 0 && sp.n > 0; n-- {
+		_, err := sp.f.ReadAt(hdr[:], sp.beg)
+		if err != nil {
+			return fmt.Errorf("could not read spooled frame header: %w", err)
+		}
+		sp.beg += 4 + int64(binary.LittleEndian.Uint32(hdr[:]))
+		sp.n--
+	}
+
+	if sp.n > 0 {
+		return nil
+	}
+	return sp.reclaim()
+}
+
+// read reads the spooled frame at the provided offset and returns it with
+// the size of its record.
+func (sp *spool) read(off int64) (Frame, int64, error) {
+	var (
+		hdr [4]byte
+		r   = io.NewSectionReader(sp.f, off, sp.end-off)
+	)
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return Frame{}, 0, fmt.Errorf("could not read spooled frame header: %w", err)
+	}
+	msg := make([]byte, binary.LittleEndian.Uint32(hdr[:]))
+	_, err = io.ReadFull(r, msg)
+	if err != nil {
+		return Frame{}, 0, fmt.Errorf("could not read spooled frame: %w", err)
+	}
+
+	frame, err := RecvFrame(context.Background(), rawMsg(msg))
+	if err != nil {
+		return Frame{}, 0, fmt.Errorf("could not decode spooled frame: %w", err)
+	}
+	return frame, 4 + int64(len(msg)), nil
+}
+
+// reclaim reclaims the disk space of a fully drained spool file.
+func (sp *spool) reclaim() error {
+	sp.beg = 0
+	sp.end = 0
+	return sp.f.Truncate(0)
+}
+
+// compact removes the already replayed frames from the spool file.
+func (sp *spool) compact() error {
+	if sp.beg == 0 {
+		return nil
+	}
+
+	var (
+		buf = make([]byte, 32*1024)
+		src = sp.beg
+		dst = int64(0)
+	)
+	for src < sp.end {
+		n := int64(len(buf))
+		if sp.end-src < n {
+			n = sp.end - src
+		}
+		_, err := sp.f.ReadAt(buf[:n], src)
+		if err != nil {
+			return err
+		}
+		_, err = sp.f.WriteAt(buf[:n], dst)
+		if err != nil {
+			return err
+		}
+		src += n
+		dst += n
+	}
+
+	sp.beg = 0
+	sp.end = dst
+	return sp.f.Truncate(sp.end)
+}
+
+func (sp *spool) close() error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	err := sp.compact()
+	if e := sp.f.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
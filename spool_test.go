@@ -0,0 +1,96 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdaq-spool-")
+	if err != nil {
+		t.Fatalf("could not create spool dir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	frames := []Frame{
+		{Type: FrameData, Path: "/adc", Body: []byte("frame-1")},
+		{Type: FrameData, Path: "/adc", Body: []byte("frame-2")},
+		{Type: FrameData, Path: "/adc", Body: []byte("frame-3")},
+	}
+
+	sp, err := newSpool(dir, "/adc", 64)
+	if err != nil {
+		t.Fatalf("could not create spool: %+v", err)
+	}
+	for i, frame := range frames {
+		err = sp.push(frame)
+		if err != nil {
+			t.Fatalf("could not spool frame %d: %+v", i, err)
+		}
+	}
+	err = sp.push(frames[0])
+	if !errors.Is(err, ErrSpoolFull) {
+		t.Fatalf("expected a full spool, got: %+v", err)
+	}
+
+	// consumer drops after the first replayed frame.
+	var got []Frame
+	errSend := errors.New("send error")
+	err = sp.replay(func(frame Frame) error {
+		if len(got) == 1 {
+			return errSend
+		}
+		got = append(got, frame)
+		return nil
+	})
+	if !errors.Is(err, errSend) {
+		t.Fatalf("expected a send error, got: %+v", err)
+	}
+	if got, want := sp.pending(), 2; got != want {
+		t.Fatalf("invalid number of pending frames: got=%d, want=%d", got, want)
+	}
+
+	// pending frames survive a restart.
+	err = sp.close()
+	if err != nil {
+		t.Fatalf("could not close spool: %+v", err)
+	}
+	sp, err = newSpool(dir, "/adc", 64)
+	if err != nil {
+		t.Fatalf("could not re-open spool: %+v", err)
+	}
+	defer sp.close()
+
+	if got, want := sp.pending(), 2; got != want {
+		t.Fatalf("invalid number of pending frames after restart: got=%d, want=%d", got, want)
+	}
+
+	err = sp.replay(func(frame Frame) error {
+		got = append(got, frame)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not replay spool: %+v", err)
+	}
+	if !reflect.DeepEqual(got, frames) {
+		t.Fatalf("invalid replayed frames:\ngot = %#v\nwant= %#v\n", got, frames)
+	}
+	if got, want := sp.pending(), 0; got != want {
+		t.Fatalf("invalid number of pending frames: got=%d, want=%d", got, want)
+	}
+
+	fi, err := os.Stat(spoolName(dir, "/adc"))
+	if err != nil {
+		t.Fatalf("could not stat spool file: %+v", err)
+	}
+	if fi.Size() != 0 {
+		t.Fatalf("spool file should be empty (size=%d)", fi.Size())
+	}
+}
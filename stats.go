@@ -0,0 +1,186 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RunStats holds the counters of a TDAQ process since the start of
+// the current (or last) run.
+type RunStats struct {
+	Outputs []EndPointStats `json:"outputs,omitempty"` // data frames produced on each output end-point
+	Inputs  []EndPointStats `json:"inputs,omitempty"`  // data frames consumed from each input end-point
+	Dropped uint64          `json:"dropped"`           // number of data frames that could not be delivered
+	Errors  uint64          `json:"errors"`            // number of data frames that could not be processed or produced
+
+	// DeadTime is the time spent by output end-points waiting to deliver
+	// their data frames, i.e. blocked by the back-pressure of consumers,
+	// and the time the run was paused.
+	DeadTime time.Duration `json:"dead-time"`
+}
+
+// EndPointStats holds the counters of a data end-point.
+type EndPointStats struct {
+	Name    string   `json:"name"`              // name of the end-point
+	Frames  uint64   `json:"frames"`            // number of data frames
+	Bytes   uint64   `json:"bytes"`             // number of payload bytes
+	Dups    uint64   `json:"dups,omitempty"`    // number of dropped duplicate data frames (input end-points)
+	Latency *Latency `json:"latency,omitempty"` // latency of the received data frames (input end-points with latency measurement)
+}
+
+// runStats collects the counters of a TDAQ process.
+// A nil runStats collects nothing.
+type runStats struct {
+	dropped uint64 // atomic
+	errors  uint64 // atomic
+	dead    int64  // atomic
+
+	mu   sync.Mutex
+	outs map[string]*epCounter
+	ins  map[string]*epCounter
+}
+
+type epCounter struct {
+	frames uint64 // atomic
+	bytes  uint64 // atomic
+	dups   uint64 // atomic
+	lat    latCounter
+}
+
+func (c *epCounter) add(frame Frame) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.frames, 1)
+	atomic.AddUint64(&c.bytes, uint64(len(frame.Body)))
+}
+
+// dup records a dropped duplicate data frame.
+func (c *epCounter) dup() {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.dups, 1)
+}
+
+// latency records the latency of a received data frame.
+func (c *epCounter) latency(dt time.Duration) {
+	if c == nil {
+		return
+	}
+	c.lat.add(dt)
+}
+
+func newRunStats() *runStats {
+	return &runStats{
+		outs: make(map[string]*epCounter),
+		ins:  make(map[string]*epCounter),
+	}
+}
+
+// output returns the counter of the named output end-point.
+func (st *runStats) output(ep string) *epCounter {
+	if st == nil {
+		return nil
+	}
+	return st.counter(st.outs, ep)
+}
+
+// input returns the counter of the named input end-point.
+func (st *runStats) input(ep string) *epCounter {
+	if st == nil {
+		return nil
+	}
+	return st.counter(st.ins, ep)
+}
+
+func (st *runStats) counter(db map[string]*epCounter, ep string) *epCounter {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	c, ok := db[ep]
+	if !ok {
+		c = new(epCounter)
+		db[ep] = c
+	}
+	return c
+}
+
+// drop records an undeliverable data frame.
+func (st *runStats) drop() {
+	if st == nil {
+		return
+	}
+	atomic.AddUint64(&st.dropped, 1)
+}
+
+// fail records a data frame that could not be processed or produced.
+func (st *runStats) fail() {
+	if st == nil {
+		return
+	}
+	atomic.AddUint64(&st.errors, 1)
+}
+
+// block records the time spent delivering a data frame.
+func (st *runStats) block(dt time.Duration) {
+	if st == nil {
+		return
+	}
+	atomic.AddInt64(&st.dead, int64(dt))
+}
+
+// reset resets all the counters, at the start of a run.
+func (st *runStats) reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	atomic.StoreUint64(&st.dropped, 0)
+	atomic.StoreUint64(&st.errors, 0)
+	atomic.StoreInt64(&st.dead, 0)
+	for _, db := range []map[string]*epCounter{st.outs, st.ins} {
+		for _, c := range db {
+			atomic.StoreUint64(&c.frames, 0)
+			atomic.StoreUint64(&c.bytes, 0)
+			atomic.StoreUint64(&c.dups, 0)
+			c.lat.reset()
+		}
+	}
+}
+
+func (st *runStats) snapshot() RunStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return RunStats{
+		Outputs: epStats(st.outs),
+		Inputs:  epStats(st.ins),
+		Dropped: atomic.LoadUint64(&st.dropped),
+		Errors:  atomic.LoadUint64(&st.errors),
+
+		DeadTime: time.Duration(atomic.LoadInt64(&st.dead)),
+	}
+}
+
+func epStats(db map[string]*epCounter) []EndPointStats {
+	if len(db) == 0 {
+		return nil
+	}
+	eps := make([]EndPointStats, 0, len(db))
+	for name, c := range db {
+		eps = append(eps, EndPointStats{
+			Name:    name,
+			Frames:  atomic.LoadUint64(&c.frames),
+			Bytes:   atomic.LoadUint64(&c.bytes),
+			Dups:    atomic.LoadUint64(&c.dups),
+			Latency: c.lat.snapshot(),
+		})
+	}
+	sort.Slice(eps, func(i, j int) bool { return eps[i].Name < eps[j].Name })
+	return eps
+}
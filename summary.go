@@ -0,0 +1,255 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// RunSummary summarizes a run, as gathered by run-ctl at /stop.
+type RunSummary struct {
+	Run   uint64        `json:"run"`   // run number
+	Start time.Time     `json:"start"` // start time of the run
+	Stop  time.Time     `json:"stop"`  // stop time of the run
+	Procs []ProcSummary `json:"procs"` // final counters of the TDAQ processes, in dependency order
+
+	Totals RunTotals `json:"totals"` // run-wide statistics
+}
+
+// RunTotals holds the run-wide statistics of a run, aggregated from the
+// counters reported by the TDAQ processes.
+type RunTotals struct {
+	Streams []StreamTotals `json:"streams,omitempty"` // counters of each data stream
+	Bytes   uint64         `json:"bytes"`             // cumulative number of payload bytes produced on all data streams
+	Dropped uint64         `json:"dropped"`           // number of data frames that could not be delivered
+	Errors  uint64         `json:"errors"`            // number of data frames that could not be processed or produced
+
+	// DeadTime is the overall dead time of the run: the largest dead time
+	// of the TDAQ processes, as the slowest stage throttles the others.
+	DeadTime time.Duration `json:"dead-time"`
+	Dead     float64       `json:"dead"` // fraction of the duration of the run spent dead
+}
+
+// StreamTotals holds the run-wide counters of a data stream, identified by
+// the name of its end-point.
+type StreamTotals struct {
+	Name     string `json:"name"`
+	Produced uint64 `json:"produced"` // number of data frames published by the producers of the stream
+	Consumed uint64 `json:"consumed"` // number of data frames processed by the consumers of the stream
+	Bytes    uint64 `json:"bytes"`    // number of payload bytes published by the producers of the stream
+}
+
+// aggregateStats aggregates the provided counters of TDAQ processes over
+// a run of the provided duration.
+func aggregateStats(stats []RunStats, dur time.Duration) RunTotals {
+	var (
+		tot     RunTotals
+		streams = make(map[string]*StreamTotals)
+		stream  = func(name string) *StreamTotals {
+			s, ok := streams[name]
+			if !ok {
+				s = &StreamTotals{Name: name}
+				streams[name] = s
+			}
+			return s
+		}
+	)
+
+	for _, st := range stats {
+		for _, ep := range st.Outputs {
+			s := stream(ep.Name)
+			s.Produced += ep.Frames
+			s.Bytes += ep.Bytes
+			tot.Bytes += ep.Bytes
+		}
+		for _, ep := range st.Inputs {
+			stream(ep.Name).Consumed += ep.Frames
+		}
+		tot.Dropped += st.Dropped
+		tot.Errors += st.Errors
+		if st.DeadTime > tot.DeadTime {
+			tot.DeadTime = st.DeadTime
+		}
+	}
+
+	for _, s := range streams {
+		tot.Streams = append(tot.Streams, *s)
+	}
+	sort.Slice(tot.Streams, func(i, j int) bool { return tot.Streams[i].Name < tot.Streams[j].Name })
+
+	if dur > 0 {
+		tot.Dead = math.Min(1, float64(tot.DeadTime)/float64(dur))
+	}
+	return tot
+}
+
+// runTotals returns the run-wide statistics: aggregated from the last
+// counters reported by the TDAQ processes during a run, or frozen at the
+// last /stop otherwise.
+// runTotals returns nil if no run was started.
+// runTotals must be called with rc.mu held.
+func (rc *RunControl) runTotals() *RunTotals {
+	if rc.status != fsm.Running && rc.status != fsm.Paused {
+		return rc.totals
+	}
+
+	stats := make([]RunStats, 0, rc.clients.len())
+	for _, cli := range rc.clients.list() {
+		stats = append(stats, cli.getStats())
+	}
+	tot := aggregateStats(stats, rc.now().Sub(rc.runStart))
+	return &tot
+}
+
+// ProcSummary holds the final counters of a TDAQ process for a run.
+type ProcSummary struct {
+	Name   string   `json:"name"`            // name of the TDAQ process
+	Status string   `json:"status"`          // final status of the TDAQ process
+	Stats  RunStats `json:"stats"`           // final counters of the TDAQ process
+	Error  string   `json:"error,omitempty"` // error retrieving the counters, if any
+}
+
+// summarize gathers the final counters of all the connected TDAQ processes.
+// summarize must be called with rc.mu held.
+func (rc *RunControl) summarize(ctx context.Context) RunSummary {
+	sum := RunSummary{
+		Run:   rc.runNbr,
+		Start: rc.runStart,
+		Stop:  rc.now().UTC(),
+		Procs: make([]ProcSummary, len(rc.deps)),
+	}
+
+	var grp errgroup.Group
+	for i, name := range rc.deps {
+		cli := rc.clients.get(name)
+		proc := &sum.Procs[i]
+		proc.Name = name
+		grp.Go(func() error {
+			cmd, err := rc.queryStatus(ctx, cli)
+			if err != nil {
+				proc.Status = cli.getStatus().String()
+				proc.Error = err.Error()
+				return nil
+			}
+			proc.Status = cmd.Status.String()
+			proc.Stats = cmd.Stats
+			return nil
+		})
+	}
+	_ = grp.Wait()
+
+	stats := make([]RunStats, 0, len(sum.Procs))
+	for _, proc := range sum.Procs {
+		if proc.Error != "" {
+			continue
+		}
+		rc.clients.get(proc.Name).setStats(proc.Stats)
+		stats = append(stats, proc.Stats)
+	}
+	sum.Totals = aggregateStats(stats, sum.Stop.Sub(sum.Start))
+
+	return sum
+}
+
+// writeSummary writes the provided run summary as a JSON document and as
+// a text report, under the summary directory of run-ctl.
+func (rc *RunControl) writeSummary(sum RunSummary) error {
+	base := filepath.Join(rc.cfg.SummaryDir, fmt.Sprintf("run-%d-summary", sum.Run))
+
+	raw, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal run summary: %w", err)
+	}
+	err = ioutil.WriteFile(base+".json", append(raw, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("could not write run summary: %w", err)
+	}
+
+	f, err := os.Create(base + ".txt")
+	if err != nil {
+		return fmt.Errorf("could not create run summary report: %w", err)
+	}
+	defer f.Close()
+
+	err = sum.WriteText(f)
+	if err != nil {
+		return fmt.Errorf("could not write run summary report: %w", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close run summary report: %w", err)
+	}
+
+	rc.msg.Infof("run summary written to %q", base+".{json,txt}")
+	return nil
+}
+
+// WriteText writes a human-readable report of the run summary to w.
+func (sum RunSummary) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "run:\t%d\t\n", sum.Run)
+	fmt.Fprintf(tw, "start:\t%s\t\n", sum.Start.Format(time.RFC3339))
+	fmt.Fprintf(tw, "stop:\t%s\t\n", sum.Stop.Format(time.RFC3339))
+	fmt.Fprintf(tw, "duration:\t%v\t\n", sum.Stop.Sub(sum.Start).Round(time.Millisecond))
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "process\t\tend-point\tframes\tbytes\t\n")
+	for _, proc := range sum.Procs {
+		if proc.Error != "" {
+			fmt.Fprintf(tw, "%s\t%s\t(%s)\t\t\t\n", proc.Name, proc.Status, proc.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t\t\t\t\n", proc.Name, proc.Status)
+		for _, ep := range proc.Stats.Outputs {
+			fmt.Fprintf(tw, "\tproduced\t%s\t%d\t%d\t\n", ep.Name, ep.Frames, ep.Bytes)
+		}
+		for _, ep := range proc.Stats.Inputs {
+			fmt.Fprintf(tw, "\tconsumed\t%s\t%d\t%d\t\n", ep.Name, ep.Frames, ep.Bytes)
+			if ep.Latency != nil {
+				fmt.Fprintf(tw, "\tlatency\t%s\t%v\t\t\n", ep.Name, ep.Latency)
+			}
+		}
+		fmt.Fprintf(tw, "\tdropped\t\t%d\t\t\n", proc.Stats.Dropped)
+		fmt.Fprintf(tw, "\terrors\t\t%d\t\t\n", proc.Stats.Errors)
+		fmt.Fprintf(tw, "\tdead\t\t%v\t\t\n", proc.Stats.DeadTime.Round(time.Millisecond))
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	tot := sum.Totals
+	fmt.Fprintf(w, "\n")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "stream\tproduced\tconsumed\tbytes\t\n")
+	for _, s := range tot.Streams {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t\n", s.Name, s.Produced, s.Consumed, s.Bytes)
+	}
+	fmt.Fprintf(tw, "total\t\t\t%d\t\n", tot.Bytes)
+	fmt.Fprintf(tw, "dropped\t%d\t\t\t\n", tot.Dropped)
+	fmt.Fprintf(tw, "errors\t%d\t\t\t\n", tot.Errors)
+	fmt.Fprintf(tw, "dead-time\t%v\t(%.2f%%)\t\t\n", tot.DeadTime.Round(time.Millisecond), 100*tot.Dead)
+	return tw.Flush()
+}
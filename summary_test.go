@@ -0,0 +1,114 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestRunSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tdaq-summary-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+	want := RunSummary{
+		Run:   42,
+		Start: start,
+		Stop:  start.Add(90 * time.Second),
+		Procs: []ProcSummary{
+			{
+				Name:   "gen",
+				Status: fsm.Stopped.String(),
+				Stats: RunStats{
+					Outputs:  []EndPointStats{{Name: "/adc", Frames: 10, Bytes: 80}},
+					Errors:   1,
+					DeadTime: 9 * time.Second,
+				},
+			},
+			{
+				Name:   "dump",
+				Status: fsm.Stopped.String(),
+				Stats: RunStats{
+					Inputs:  []EndPointStats{{Name: "/adc", Frames: 9, Bytes: 72}},
+					Dropped: 1,
+				},
+			},
+			{
+				Name:   "slow",
+				Status: fsm.Running.String(),
+				Error:  "timeout",
+			},
+		},
+	}
+
+	want.Totals = aggregateStats(
+		[]RunStats{want.Procs[0].Stats, want.Procs[1].Stats},
+		want.Stop.Sub(want.Start),
+	)
+	if got, want := want.Totals, (RunTotals{
+		Streams:  []StreamTotals{{Name: "/adc", Produced: 10, Consumed: 9, Bytes: 80}},
+		Bytes:    80,
+		Dropped:  1,
+		Errors:   1,
+		DeadTime: 9 * time.Second,
+		Dead:     0.1,
+	}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid run totals:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	rc := &RunControl{
+		cfg: config.RunCtl{SummaryDir: dir},
+		msg: log.NewMsgStream("run-ctl", log.LvlError, ioutil.Discard),
+	}
+	err = rc.writeSummary(want)
+	if err != nil {
+		t.Fatalf("could not write summary: %+v", err)
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "run-42-summary.json"))
+	if err != nil {
+		t.Fatalf("could not read JSON summary: %+v", err)
+	}
+	var got RunSummary
+	err = json.Unmarshal(raw, &got)
+	if err != nil {
+		t.Fatalf("could not decode JSON summary: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid JSON summary:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	txt, err := ioutil.ReadFile(filepath.Join(dir, "run-42-summary.txt"))
+	if err != nil {
+		t.Fatalf("could not read text summary: %+v", err)
+	}
+	for _, line := range []string{
+		"duration:  1m30s",
+		"gen      stopped",
+		"         produced  /adc       10      80",
+		"         consumed  /adc       9       72",
+		"slow     running   (timeout)",
+		"/adc       10        9         80",
+		"dead-time  9s        (10.00%)",
+	} {
+		if !strings.Contains(string(txt), line) {
+			t.Errorf("missing line %q in text summary:\n%s", line, txt)
+		}
+	}
+}
@@ -8,14 +8,56 @@ package tdaq // import "github.com/go-daq/tdaq"
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/go-daq/tdaq/conditions"
 	"github.com/go-daq/tdaq/log"
+	"go.nanomsg.org/mangos/v3"
 )
 
 type Context struct {
-	Ctx context.Context
-	Msg log.MsgStream
+	Ctx    context.Context
+	Msg    log.MsgStream
+	Proto  uint8      // version of the TDAQ wire protocol negotiated with run-ctl
+	Clock  *Clock     // clock offset of the process with respect to run-ctl (may be nil)
+	Alarms *Alarms    // alarms of the process (may be nil)
+	Time   TimeSource // source of the time, tickers and timers of the process (may be nil)
+
+	peers  *svcpeers     // services of the other processes (may be nil)
+	topics *topicmgr     // topics published by the process (may be nil)
+	kv     *kvcache      // values of the key-value configuration store (may be nil)
+	ext    *extcache     // extension fields of the /config command (may be nil)
+	conds  conditions.DB // conditions database of the process (may be nil)
+	calibs *calibmgr     // calibration constants of the process (may be nil)
+
+	secrets *secretmgr    // secrets store of the process (may be nil)
+	compact *compactcache // end-points with compact data links (may be nil)
+}
+
+// Versions of the TDAQ wire protocol.
+const (
+	ProtoV1 uint8 = 1 // fixed-width encoding of lengths
+	ProtoV2 uint8 = 2 // varint encoding of lengths (see Encoder.SetCompact)
+	ProtoV3 uint8 = 3 // versioned /config commands, with an extension section (see ConfigVersion)
+	ProtoV4 uint8 = 4 // data links carrying compact payloads (see Context.Compact)
+
+	// ProtoVersion is the latest version of the TDAQ wire protocol
+	// supported by this package.
+	ProtoVersion = ProtoV4
+)
+
+// negotiateProto returns the version of the TDAQ wire protocol to use
+// with a peer supporting up to the provided version.
+func negotiateProto(peer uint8) uint8 {
+	switch {
+	case peer < ProtoV1:
+		return ProtoV1
+	case peer > ProtoVersion:
+		return ProtoVersion
+	default:
+		return peer
+	}
 }
 
 type Marshaler interface {
@@ -39,6 +81,12 @@ type Frame struct {
 	Type FrameType // type of frame (cmd,data,err,ok)
 	Path string    // end-point path
 	Body []byte    // frame payload
+
+	// EventID is the logical event ID of a data frame (0: none).
+	// Event IDs are carried from producers to consumers, along with
+	// the Trace flag, and propagated by the helper stages of package xdaq.
+	EventID uint64
+	Trace   bool // whether the data frame is traced through the pipeline
 }
 
 func (f Frame) encode() []byte {
@@ -49,7 +97,7 @@ func (f Frame) encode() []byte {
 	msg := make([]byte, 1+1+psz+bsz)
 	msg[0] = byte(f.Type)
 	msg[1] = byte(psz)
-	copy(msg[beg:end], []byte(f.Path))
+	copy(msg[beg:end], f.Path)
 	copy(msg[end:], f.Body)
 
 	return msg
@@ -66,6 +114,11 @@ const (
 	FrameOK
 	FrameEOF
 	FrameErr
+	FrameChunk
+	FrameAcked
+	FrameStamped
+	FrameEvent
+	FrameHold
 )
 
 func (ft FrameType) String() string {
@@ -84,53 +137,182 @@ func (ft FrameType) String() string {
 		return "eof-frame"
 	case FrameErr:
 		return "err-frame"
+	case FrameChunk:
+		return "chunk-frame"
+	case FrameAcked:
+		return "acked-frame"
+	case FrameStamped:
+		return "stamped-frame"
+	case FrameEvent:
+		return "event-frame"
+	case FrameHold:
+		return "hold-frame"
 	default:
 		panic(fmt.Errorf("invalid frame-type %d", byte(ft)))
 	}
 }
 
+func SendMsg(ctx context.Context, sck Sender, msg MsgFrame) error {
+	return sendEncoded(ctx, sck, FrameMsg, pathLog, nil, msg)
+}
+
 var (
-	eofFrame = []byte{byte(FrameEOF), 0}
+	pathLog   = []byte("/log")
+	pathMon   = []byte("/mon")
+	pathAlarm = []byte("/alarm")
 )
 
-func SendMsg(ctx context.Context, sck Sender, msg MsgFrame) error {
-	raw, err := msg.MarshalTDAQ()
+// DefaultMaxFrameSize is the default maximum size of a TDAQ frame.
+const DefaultMaxFrameSize = 16 << 20
+
+// ErrFrameTooLarge is returned when a frame exceeds the maximum frame size
+// of a connection.
+var ErrFrameTooLarge = errors.New("tdaq: frame too large")
+
+// negotiateMaxFrameSize returns the maximum frame size to use between
+// two peers with the provided maximum frame sizes (0: default).
+func negotiateMaxFrameSize(local, peer int) int {
+	if local <= 0 {
+		local = DefaultMaxFrameSize
+	}
+	if peer <= 0 || peer > local {
+		return local
+	}
+	return peer
+}
+
+type optioner interface {
+	GetOption(name string) (interface{}, error)
+}
+
+// maxFrameSize returns the maximum frame size of the provided socket,
+// or 0 if the socket has no such limit.
+func maxFrameSize(sck interface{}) int {
+	o, ok := sck.(optioner)
+	if !ok {
+		return 0
+	}
+	v, err := o.GetOption(mangos.OptionMaxRecvSize)
 	if err != nil {
-		return err
+		return 0
 	}
-	return sendFrame(ctx, sck, FrameMsg, []byte("/log"), raw)
+	n, _ := v.(int)
+	return n
+}
+
+// setMaxFrameSize sets the maximum frame size of the provided socket.
+// Frames larger than this limit are rejected when sent and dropped by
+// the transport when received, before any allocation of their payload.
+func setMaxFrameSize(sck mangos.Socket, n int) error {
+	if n <= 0 {
+		n = DefaultMaxFrameSize
+	}
+	return sck.SetOption(mangos.OptionMaxRecvSize, n)
 }
 
 func SendFrame(ctx context.Context, sck Sender, frame Frame) error {
 	return sendFrame(ctx, sck, frame.Type, []byte(frame.Path), frame.Body)
 }
 
-func sendFrame(ctx context.Context, sck Sender, ftype FrameType, path, body []byte) error {
+// msgSender is a Sender that can send mangos messages.
+type msgSender interface {
+	SendMsg(msg *mangos.Message) error
+}
+
+// sendFrame sends a frame whose body is the concatenation of the provided
+// parts, so callers need not assemble the body themselves.
+// sendFrame does not retain the parts of the body.
+func sendFrame(ctx context.Context, sck Sender, ftype FrameType, path []byte, body ...[]byte) error {
 
 	psz := len(path)
-	bsz := len(body)
+	bsz := 0
+	for _, p := range body {
+		bsz += len(p)
+	}
+	if psz > 255 {
+		return fmt.Errorf("invalid TDAQ frame path length (len=%d > 255)", psz)
+	}
+	if max := maxFrameSize(sck); max > 0 && 2+psz+bsz > max {
+		return fmt.Errorf("could not send TDAQ frame (size=%d, max=%d): %w", 2+psz+bsz, max, ErrFrameTooLarge)
+	}
+
+	if ms, ok := sck.(msgSender); ok {
+		// the frame is encoded directly into a (pooled) mangos message,
+		// which the transport writes out, along with its length prefix,
+		// with a single vectored write.
+		// this saves the copy of the frame made by Send.
+		msg := mangos.NewMessage(2 + psz + bsz)
+		msg.Body = append(msg.Body, byte(ftype), byte(psz))
+		msg.Body = append(msg.Body, path...)
+		for _, p := range body {
+			msg.Body = append(msg.Body, p...)
+		}
+		err := ms.SendMsg(msg)
+		if err != nil {
+			// the message was not accepted: we still own it.
+			msg.Free()
+		}
+		return err
+	}
+
 	beg := 2
 	end := beg + psz
 	msg := make([]byte, 1+1+psz+bsz)
 	msg[0] = byte(ftype)
 	msg[1] = byte(psz)
-	copy(msg[beg:end], []byte(path))
-	copy(msg[end:], body)
+	copy(msg[beg:end], path)
+	for _, p := range body {
+		end += copy(msg[end:], p)
+	}
 	return sck.Send(msg)
 }
 
+// sendEncoded sends a frame whose body is the provided prefix (may be nil),
+// followed by the encoding of v.
+// v is encoded into a pooled buffer, which is released once sent.
+func sendEncoded(ctx context.Context, sck Sender, ftype FrameType, path, prefix []byte, v tdaqEncoder) error {
+	eb := getEncBuffer()
+	defer eb.release()
+
+	v.encodeTDAQ(&eb.enc)
+	if err := eb.enc.err; err != nil {
+		return err
+	}
+	return sendFrame(ctx, sck, ftype, path, prefix, eb.buf.Bytes())
+}
+
 func RecvFrame(ctx context.Context, sck Recver) (frame Frame, err error) {
+	return recvFrame(sck, maxFrameSize(sck), nil)
+}
+
+// recvFrame receives a frame of at most max bytes (0: no limit).
+// Paths found in the provided set of paths (may be nil) reuse the strings
+// of the set, instead of allocating new ones.
+func recvFrame(sck Recver, max int, paths map[string]string) (frame Frame, err error) {
 
 	msg, err := sck.Recv()
 	if err != nil {
 		return frame, fmt.Errorf("could not receive TDAQ frame: %w", err)
 	}
+	if max > 0 && len(msg) > max {
+		return frame, fmt.Errorf("could not receive TDAQ frame (size=%d, max=%d): %w", len(msg), max, ErrFrameTooLarge)
+	}
+	if len(msg) < 2 {
+		return frame, fmt.Errorf("invalid TDAQ frame (size=%d)", len(msg))
+	}
 	frame.Type = FrameType(msg[0])
 
 	psz := int(msg[1])
 	beg := 2
 	end := beg + psz
-	frame.Path = string(msg[beg:end])
+	if end > len(msg) {
+		return frame, fmt.Errorf("invalid TDAQ frame path length (len=%d, size=%d)", psz, len(msg))
+	}
+	if path, ok := paths[string(msg[beg:end])]; ok {
+		frame.Path = path
+	} else {
+		frame.Path = string(msg[beg:end])
+	}
 	if len(msg[end:]) > 0 {
 		frame.Body = msg[end:]
 	}
@@ -145,13 +327,13 @@ type MsgFrame struct {
 }
 
 func (frame MsgFrame) MarshalTDAQ() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	enc := NewEncoder(buf)
+	return marshalTDAQ(frame)
+}
+
+func (frame MsgFrame) encodeTDAQ(enc *Encoder) {
 	enc.WriteStr(frame.Name)
 	enc.WriteI8(int8(frame.Level))
 	enc.WriteStr(frame.Msg)
-	err := enc.Err()
-	return buf.Bytes(), err
 }
 
 func (frame *MsgFrame) UnmarshalTDAQ(p []byte) error {
@@ -162,6 +344,31 @@ func (frame *MsgFrame) UnmarshalTDAQ(p []byte) error {
 	return dec.Err()
 }
 
+// MonFrame carries the value of a monitoring variable of a tdaq process.
+type MonFrame struct {
+	Name  string  // name of the tdaq process
+	Var   string  // name of the monitoring variable
+	Value float64 // value of the monitoring variable
+}
+
+func (frame MonFrame) MarshalTDAQ() ([]byte, error) {
+	return marshalTDAQ(frame)
+}
+
+func (frame MonFrame) encodeTDAQ(enc *Encoder) {
+	enc.WriteStr(frame.Name)
+	enc.WriteStr(frame.Var)
+	enc.WriteF64(frame.Value)
+}
+
+func (frame *MonFrame) UnmarshalTDAQ(p []byte) error {
+	dec := NewDecoder(bytes.NewReader(p))
+	frame.Name = dec.ReadStr()
+	frame.Var = dec.ReadStr()
+	frame.Value = dec.ReadF64()
+	return dec.Err()
+}
+
 type EndPoint struct {
 	Name string
 	Addr string
@@ -169,13 +376,13 @@ type EndPoint struct {
 }
 
 func (ep EndPoint) MarshalTDAQ() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	enc := NewEncoder(buf)
+	return marshalTDAQ(ep)
+}
+
+func (ep EndPoint) encodeTDAQ(enc *Encoder) {
 	enc.WriteStr(ep.Name)
 	enc.WriteStr(ep.Addr)
 	enc.WriteStr(ep.Type)
-
-	return buf.Bytes(), enc.err
 }
 
 func (ep *EndPoint) UnmarshalTDAQ(b []byte) error {
@@ -189,4 +396,7 @@ func (ep *EndPoint) UnmarshalTDAQ(b []byte) error {
 var (
 	_ Marshaler   = (*MsgFrame)(nil)
 	_ Unmarshaler = (*MsgFrame)(nil)
+
+	_ Marshaler   = (*MonFrame)(nil)
+	_ Unmarshaler = (*MonFrame)(nil)
 )
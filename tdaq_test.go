@@ -6,12 +6,14 @@ package tdaq // import "github.com/go-daq/tdaq"
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
 
-	"github.com/go-daq/tdaq/internal/iomux"
+	"github.com/go-daq/tdaq/iomux"
 	"github.com/go-daq/tdaq/log"
+	"go.nanomsg.org/mangos/v3"
 )
 
 func (rc *RunControl) SetWebSrv(srv websrv) {
@@ -22,6 +24,14 @@ func (rc *RunControl) Web() websrv {
 	return rc.web
 }
 
+func (rc *RunControl) SetObserverSrv(srv websrv) {
+	rc.obs = srv
+}
+
+func (rc *RunControl) Observer() websrv {
+	return rc.obs
+}
+
 func TestMsgFrame(t *testing.T) {
 	ctx := context.Background()
 	for _, tt := range []MsgFrame{
@@ -53,6 +63,40 @@ func TestMsgFrame(t *testing.T) {
 	}
 }
 
+func TestMonFrame(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []MonFrame{
+		{Name: "n1", Var: "rate", Value: 42},
+		{Name: "n2", Var: "", Value: -1.5},
+		{Name: "n3", Var: "temperature", Value: 273.15},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			buf := new(iomux.Socket)
+			raw, err := tt.MarshalTDAQ()
+			if err != nil {
+				t.Fatalf("could not marshal mon-frame: %+v", err)
+			}
+			err = SendFrame(ctx, buf, Frame{Type: FrameMsg, Path: "/mon", Body: raw})
+			if err != nil {
+				t.Fatalf("could not send mon-frame: %+v", err)
+			}
+			frame, err := RecvFrame(ctx, buf)
+			if err != nil {
+				t.Fatalf("could not recv mon-frame: %+v", err)
+			}
+			var got MonFrame
+			err = got.UnmarshalTDAQ(frame.Body)
+			if err != nil {
+				t.Fatalf("could not unmarshal mon-frame: %+v", err)
+			}
+
+			if got, want := got, tt; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid r/w round-trip for mon-frame:\ngot = %#v\nwant= %#v\n", got, want)
+			}
+		})
+	}
+}
+
 func TestFrame(t *testing.T) {
 	ctx := context.Background()
 	for _, tt := range []struct {
@@ -112,10 +156,79 @@ func TestFrame(t *testing.T) {
 			if got, want := got.Type.String(), tt.frame.Type.String(); got != want {
 				t.Fatalf("invalid frame type: got=%q, want=%q", got, want)
 			}
+
+			msg := new(msgSocket)
+			err = SendFrame(ctx, msg, tt.frame)
+			if err != nil {
+				t.Fatalf("could not send frame as message: %+v", err)
+			}
+			got, err = RecvFrame(ctx, msg)
+			if err != nil {
+				t.Fatalf("could not recv frame sent as message: %+v", err)
+			}
+			if got, want := got, tt.frame; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid r/w round-trip for message frame %q:\ngot = %#v\nwant= %#v\n", tt.name, got, want)
+			}
 		})
 	}
 }
 
+// msgSocket is a socket sending mangos messages.
+type msgSocket struct {
+	iomux.Socket
+}
+
+func (sck *msgSocket) SendMsg(msg *mangos.Message) error {
+	return sck.Send(msg.Body)
+}
+
+// discardSocket discards the sent frames.
+type discardSocket struct{}
+
+func (discardSocket) Send(p []byte) error { return nil }
+
+// discardMsgSocket discards the sent messages, like a transport recycling
+// the messages it wrote.
+type discardMsgSocket struct{}
+
+func (discardMsgSocket) Send(p []byte) error {
+	// mangos copies the sent bytes into a message.
+	msg := mangos.NewMessage(len(p))
+	msg.Body = append(msg.Body, p...)
+	return discardMsgSocket{}.SendMsg(msg)
+}
+
+func (discardMsgSocket) SendMsg(msg *mangos.Message) error {
+	msg.Free()
+	return nil
+}
+
+func BenchmarkSendFrame(b *testing.B) {
+	ctx := context.Background()
+	for _, size := range []int{64, 1 << 10, 64 << 10} {
+		body := make([]byte, size)
+		for _, bc := range []struct {
+			name string
+			sck  Sender
+		}{
+			{"bytes", discardSocket{}},
+			{"copy", struct{ Sender }{discardMsgSocket{}}}, // hide SendMsg: frame copied by mangos
+			{"msg", discardMsgSocket{}},
+		} {
+			b.Run(fmt.Sprintf("%s-%d", bc.name, size), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					err := sendFrame(ctx, bc.sck, FrameData, []byte("/adc"), body)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
 func TestFrameType(t *testing.T) {
 	for _, tt := range []struct {
 		frame  FrameType
@@ -129,6 +242,11 @@ func TestFrameType(t *testing.T) {
 		{frame: FrameOK, want: "ok-frame"},
 		{frame: FrameEOF, want: "eof-frame"},
 		{frame: FrameErr, want: "err-frame"},
+		{frame: FrameChunk, want: "chunk-frame"},
+		{frame: FrameAcked, want: "acked-frame"},
+		{frame: FrameStamped, want: "stamped-frame"},
+		{frame: FrameEvent, want: "event-frame"},
+		{frame: FrameHold, want: "hold-frame"},
 		{frame: FrameType(255), panics: true},
 	} {
 		t.Run("", func(t *testing.T) {
@@ -151,3 +269,7 @@ func TestFrameType(t *testing.T) {
 		})
 	}
 }
+
+type rawRecver []byte
+
+func (r rawRecver) Recv() ([]byte, error) { return r, nil }
@@ -0,0 +1,55 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package times // import "github.com/go-daq/tdaq/times"
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type ptp struct {
+	f  *os.File
+	id uintptr // dynamic POSIX clock id of the PTP device
+}
+
+// NewPTP returns the PTP hardware clock of the named device (e.g. "/dev/ptp0").
+// The returned clock should be closed after use.
+func NewPTP(dev string) (HWClock, error) {
+	f, err := os.Open(dev)
+	if err != nil {
+		return nil, fmt.Errorf("times: could not open PTP device: %w", err)
+	}
+
+	// see FD_TO_CLOCKID in linux/posix-timers.h
+	clk := &ptp{f: f, id: uintptr((^int(f.Fd()))<<3 | 3)}
+	_, err = clk.now()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("times: could not read PTP clock %q: %w", dev, err)
+	}
+	return clk, nil
+}
+
+func (*ptp) Source() Source { return PTP }
+
+func (clk *ptp) Now() Stamp {
+	ns, _ := clk.now()
+	return Stamp{Source: PTP, Nanos: ns}
+}
+
+func (clk *ptp) now() (int64, error) {
+	var ts syscall.Timespec
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clk.id, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return ts.Nano(), nil
+}
+
+func (clk *ptp) Close() error {
+	return clk.f.Close()
+}
@@ -0,0 +1,19 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package times // import "github.com/go-daq/tdaq/times"
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// NewPTP returns the PTP hardware clock of the named device (e.g. "/dev/ptp0").
+// PTP hardware clocks are only supported on Linux.
+func NewPTP(dev string) (HWClock, error) {
+	return nil, fmt.Errorf("times: PTP hardware clocks not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package times provides helpers to timestamp data frames with various
+// clock sources, and to convert timestamps between clock sources.
+//
+// A timestamp records the clock source it was taken from, so timestamps of
+// different devices can be compared once converted to a common source.
+// Timestamps have a fixed-size binary encoding, suitable for frame headers.
+package times // import "github.com/go-daq/tdaq/times"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Source identifies a clock source.
+type Source uint8
+
+const (
+	Unknown   Source = iota
+	Realtime         // wall clock (UTC), as returned by time.Now
+	Monotonic        // monotonic clock, counted from the start of the process
+	PTP              // PTP hardware clock (TAI)
+)
+
+func (src Source) String() string {
+	switch src {
+	case Unknown:
+		return "unknown"
+	case Realtime:
+		return "realtime"
+	case Monotonic:
+		return "monotonic"
+	case PTP:
+		return "ptp"
+	default:
+		return fmt.Sprintf("Source(%d)", uint8(src))
+	}
+}
+
+// TAIOffset is the current offset between the TAI and UTC time scales,
+// ie: the number of leap seconds since 1972 plus the initial 10s offset.
+const TAIOffset = 37 * time.Second
+
+// Size is the size in bytes of an encoded timestamp.
+const Size = 1 + 8
+
+// Stamp is a timestamp, in nanoseconds, taken from a clock source.
+// Realtime timestamps count from the Unix epoch (UTC), PTP timestamps from
+// the PTP epoch (TAI) and Monotonic timestamps from the start of the process.
+type Stamp struct {
+	Source Source
+	Nanos  int64
+}
+
+// Time returns the timestamp as a time.Time.
+// Only Realtime timestamps are meaningful as wall clock times: other
+// timestamps should be converted first.
+func (ts Stamp) Time() time.Time {
+	return time.Unix(0, ts.Nanos).UTC()
+}
+
+func (ts Stamp) String() string {
+	return fmt.Sprintf("%s:%d", ts.Source, ts.Nanos)
+}
+
+// Put encodes the timestamp into buf, which must be at least Size bytes long.
+func (ts Stamp) Put(buf []byte) {
+	buf[0] = byte(ts.Source)
+	binary.LittleEndian.PutUint64(buf[1:Size], uint64(ts.Nanos))
+}
+
+// Append appends the encoded timestamp to buf.
+func (ts Stamp) Append(buf []byte) []byte {
+	var raw [Size]byte
+	ts.Put(raw[:])
+	return append(buf, raw[:]...)
+}
+
+// Decode decodes a timestamp from buf.
+func Decode(buf []byte) (Stamp, error) {
+	if len(buf) < Size {
+		return Stamp{}, fmt.Errorf("times: invalid timestamp size (got=%d, want>=%d)", len(buf), Size)
+	}
+	return Stamp{
+		Source: Source(buf[0]),
+		Nanos:  int64(binary.LittleEndian.Uint64(buf[1:Size])),
+	}, nil
+}
+
+// Clock is a clock source.
+type Clock interface {
+	Source() Source
+	Now() Stamp
+}
+
+// HWClock is a hardware clock source.
+// Hardware clocks should be closed after use.
+type HWClock interface {
+	Clock
+	Close() error
+}
+
+type realtime struct{}
+
+// NewRealtime returns the wall clock.
+func NewRealtime() Clock { return realtime{} }
+
+func (realtime) Source() Source { return Realtime }
+func (realtime) Now() Stamp     { return Stamp{Source: Realtime, Nanos: time.Now().UnixNano()} }
+
+// epoch is the start of the monotonic clock of the process.
+var epoch = time.Now()
+
+type monotonic struct{}
+
+// NewMonotonic returns the monotonic clock of the process.
+// The monotonic clock is not affected by changes of the wall clock.
+func NewMonotonic() Clock { return monotonic{} }
+
+func (monotonic) Source() Source { return Monotonic }
+func (monotonic) Now() Stamp     { return Stamp{Source: Monotonic, Nanos: int64(time.Since(epoch))} }
+
+// Converter converts timestamps between clock sources.
+//
+// Conversions rely on the offsets between the clock sources, measured by
+// reading the clocks back to back: offsets should be measured again
+// regularly, to follow the drift of the clocks.
+type Converter struct {
+	mu   sync.RWMutex
+	offs map[Source]int64 // offsets of the clock sources with respect to Realtime, in ns
+}
+
+// NewConverter returns a converter between the provided clocks and the
+// Realtime and Monotonic clocks, with freshly measured offsets.
+func NewConverter(clocks ...Clock) *Converter {
+	conv := &Converter{offs: make(map[Source]int64)}
+	conv.Measure(append([]Clock{NewMonotonic()}, clocks...)...)
+	return conv
+}
+
+// Measure measures the offsets of the provided clocks with respect to
+// the Realtime clock.
+func (conv *Converter) Measure(clocks ...Clock) {
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+
+	for _, clk := range clocks {
+		if clk.Source() == Realtime {
+			continue
+		}
+		// bracket the reading of the clock with two readings of the wall
+		// clock, and use their mid-point.
+		beg := time.Now().UnixNano()
+		ts := clk.Now()
+		end := time.Now().UnixNano()
+		conv.offs[clk.Source()] = ts.Nanos - (beg + (end-beg)/2)
+	}
+}
+
+// SetOffset sets the offset of the provided clock source with respect to
+// the Realtime clock (source minus realtime).
+func (conv *Converter) SetOffset(src Source, off time.Duration) {
+	conv.mu.Lock()
+	defer conv.mu.Unlock()
+	conv.offs[src] = int64(off)
+}
+
+// Convert converts the provided timestamp to the provided clock source.
+func (conv *Converter) Convert(ts Stamp, dst Source) (Stamp, error) {
+	if ts.Source == dst {
+		return ts, nil
+	}
+
+	conv.mu.RLock()
+	defer conv.mu.RUnlock()
+
+	from, ok := conv.offset(ts.Source)
+	if !ok {
+		return Stamp{}, fmt.Errorf("times: no offset for clock source %v", ts.Source)
+	}
+	to, ok := conv.offset(dst)
+	if !ok {
+		return Stamp{}, fmt.Errorf("times: no offset for clock source %v", dst)
+	}
+	return Stamp{Source: dst, Nanos: ts.Nanos - from + to}, nil
+}
+
+func (conv *Converter) offset(src Source) (int64, bool) {
+	if src == Realtime {
+		return 0, true
+	}
+	off, ok := conv.offs[src]
+	return off, ok
+}
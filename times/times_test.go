@@ -0,0 +1,90 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package times
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStamp(t *testing.T) {
+	want := Stamp{Source: PTP, Nanos: 1577934245000000006}
+	buf := want.Append([]byte{0xff})
+	if got, want := len(buf), 1+Size; got != want {
+		t.Fatalf("invalid encoded size: got=%d, want=%d", got, want)
+	}
+
+	got, err := Decode(buf[1:])
+	if err != nil {
+		t.Fatalf("could not decode timestamp: %+v", err)
+	}
+	if got != want {
+		t.Fatalf("invalid timestamp:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+
+	_, err = Decode(buf[:Size-1])
+	if err == nil {
+		t.Fatalf("expected an error decoding a short timestamp")
+	}
+}
+
+func TestSource(t *testing.T) {
+	for _, tt := range []struct {
+		src  Source
+		want string
+	}{
+		{Unknown, "unknown"},
+		{Realtime, "realtime"},
+		{Monotonic, "monotonic"},
+		{PTP, "ptp"},
+		{Source(42), "Source(42)"},
+	} {
+		if got := tt.src.String(); got != tt.want {
+			t.Fatalf("invalid source name: got=%q, want=%q", got, tt.want)
+		}
+	}
+}
+
+func TestConverter(t *testing.T) {
+	conv := NewConverter()
+
+	beg := time.Now()
+	mono := NewMonotonic().Now()
+	end := time.Now()
+
+	rt, err := conv.Convert(mono, Realtime)
+	if err != nil {
+		t.Fatalf("could not convert monotonic timestamp: %+v", err)
+	}
+	if rt.Source != Realtime {
+		t.Fatalf("invalid converted source: %v", rt.Source)
+	}
+	const eps = 10 * time.Millisecond
+	if rt.Time().Before(beg.Add(-eps)) || rt.Time().After(end.Add(eps)) {
+		t.Fatalf("invalid converted timestamp: got=%v, want in [%v, %v]", rt.Time(), beg, end)
+	}
+
+	back, err := conv.Convert(rt, Monotonic)
+	if err != nil {
+		t.Fatalf("could not convert realtime timestamp: %+v", err)
+	}
+	if back != mono {
+		t.Fatalf("invalid round-trip:\ngot = %#v\nwant= %#v\n", back, mono)
+	}
+
+	_, err = conv.Convert(rt, PTP)
+	if err == nil {
+		t.Fatalf("expected an error converting to an unmeasured source")
+	}
+
+	conv.SetOffset(PTP, TAIOffset)
+	tai, err := conv.Convert(Stamp{Source: Realtime, Nanos: 0}, PTP)
+	if err != nil {
+		t.Fatalf("could not convert to PTP: %+v", err)
+	}
+	if got, want := tai.Nanos, int64(TAIOffset); got != want {
+		t.Fatalf("invalid PTP timestamp: got=%d, want=%d", got, want)
+	}
+}
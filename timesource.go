@@ -0,0 +1,190 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// TimeSource provides the current time, and the tickers and timers driving
+// the periodic tasks and the timeouts of run-ctl (heartbeats, watchdog, ...)
+// and of TDAQ processes (handshake with run-ctl, retransmission of
+// unacknowledged data frames, stalled links, checkpoints, ...).
+//
+// The default time source is the wall clock. Simulations substitute a
+// virtual clock, to run whole partitions deterministically, and tests of
+// devices to exercise timeouts without real sleeps.
+type TimeSource interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTicker returns a ticker delivering ticks with the provided period.
+	NewTicker(d time.Duration) Ticker
+
+	// NewTimer returns a timer delivering a single tick, once the provided
+	// duration elapsed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Ticker delivers ticks at intervals.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// Timer delivers a single tick, after a delay.
+type Timer interface {
+	// C returns the channel on which the tick is delivered.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing.
+	// Stop returns false if the timer already fired or was stopped.
+	Stop() bool
+}
+
+// wallClock is the TimeSource of the wall clock.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time { return time.Now() }
+
+func (wallClock) NewTicker(d time.Duration) Ticker {
+	return wallTicker{time.NewTicker(d)}
+}
+
+func (wallClock) NewTimer(d time.Duration) Timer {
+	return wallTimer{time.NewTimer(d)}
+}
+
+type wallTicker struct {
+	t *time.Ticker
+}
+
+func (t wallTicker) C() <-chan time.Time { return t.t.C }
+func (t wallTicker) Stop()               { t.t.Stop() }
+
+type wallTimer struct {
+	t *time.Timer
+}
+
+func (t wallTimer) C() <-chan time.Time { return t.t.C }
+func (t wallTimer) Stop() bool          { return t.t.Stop() }
+
+// UseTimeSource sets the source of the time and of the tickers of run-ctl.
+// The run number is reset from the time of the time source.
+//
+// UseTimeSource must be called before the run-ctl is run.
+func (rc *RunControl) UseTimeSource(ts TimeSource) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.clock = ts
+	rc.runNbr = uint64(ts.Now().UTC().Unix())
+	rc.kv.now = ts.Now
+	rc.calibs.now = ts.Now
+	rc.alarms.now = ts.Now
+	rc.alerts.now = ts.Now
+}
+
+// now returns the current time of the time source of run-ctl.
+func (rc *RunControl) now() time.Time {
+	return rc.timeSource().Now()
+}
+
+// newTicker returns a ticker of the time source of run-ctl.
+func (rc *RunControl) newTicker(d time.Duration) Ticker {
+	return rc.timeSource().NewTicker(d)
+}
+
+func (rc *RunControl) timeSource() TimeSource {
+	if rc.clock == nil {
+		return wallClock{}
+	}
+	return rc.clock
+}
+
+// UseTimeSource sets the source of the time, tickers and timers of the
+// process, and of the contexts passed to its handlers.
+// Timeouts of the process may then be exercised with a virtual clock,
+// without real sleeps.
+//
+// Sockets keep redialing dropped connections with the wall clock.
+//
+// UseTimeSource must be called before the process is run.
+func (srv *Server) UseTimeSource(ts TimeSource) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.ts = ts
+}
+
+// now returns the current time of the time source of the process.
+func (srv *Server) now() time.Time {
+	return srv.timeSource().Now()
+}
+
+func (srv *Server) timeSource() TimeSource {
+	if srv.ts == nil {
+		return wallClock{}
+	}
+	return srv.ts
+}
+
+// timeSource returns the time source of the process, or the wall clock if
+// none.
+func (ctx Context) timeSource() TimeSource {
+	if ctx.Time == nil {
+		return wallClock{}
+	}
+	return ctx.Time
+}
+
+// withTimeout returns a copy of ctx, canceled once the provided duration
+// elapsed on the time source ts.
+func withTimeout(ctx context.Context, ts TimeSource, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ts.(wallClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	tctx := &timeoutCtx{Context: ctx}
+	tmr := ts.NewTimer(d)
+	go func() {
+		defer tmr.Stop()
+		select {
+		case <-tmr.C():
+			atomic.StoreInt32(&tctx.expired, 1)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return tctx, cancel
+}
+
+// timeoutCtx is a context canceled by a timer of a time source.
+type timeoutCtx struct {
+	context.Context
+	expired int32 // whether the timer fired (atomic)
+}
+
+func (ctx *timeoutCtx) Err() error {
+	if atomic.LoadInt32(&ctx.expired) == 1 {
+		return context.DeadlineExceeded
+	}
+	return ctx.Context.Err()
+}
+
+// now returns the current time of the time source of the client, or of the
+// wall clock if none.
+func (cli *client) now() time.Time {
+	if cli.ts == nil {
+		return time.Now()
+	}
+	return cli.ts.Now()
+}
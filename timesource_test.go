@@ -0,0 +1,56 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stubClock is a time source whose timers only fire when told to.
+type stubClock struct {
+	wallClock
+	timers chan stubTimer
+}
+
+type stubTimer chan time.Time
+
+func (clk stubClock) NewTimer(d time.Duration) Timer {
+	t := make(stubTimer, 1)
+	clk.timers <- t
+	return t
+}
+
+func (t stubTimer) C() <-chan time.Time { return t }
+func (t stubTimer) Stop() bool          { return false }
+
+func TestWithTimeout(t *testing.T) {
+	clk := stubClock{timers: make(chan stubTimer, 1)}
+	ctx, cancel := withTimeout(context.Background(), clk, time.Hour)
+	defer cancel()
+
+	tmr := <-clk.timers
+	select {
+	case <-ctx.Done():
+		t.Fatalf("context canceled before its timer fired: %+v", ctx.Err())
+	default:
+	}
+
+	tmr <- time.Now()
+	<-ctx.Done()
+	if err := ctx.Err(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, context.DeadlineExceeded)
+	}
+
+	ctx, cancel = withTimeout(context.Background(), clk, time.Hour)
+	<-clk.timers
+	cancel()
+	<-ctx.Done()
+	if err := ctx.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, context.Canceled)
+	}
+}
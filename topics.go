@@ -0,0 +1,322 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.nanomsg.org/mangos/v3"
+	"go.nanomsg.org/mangos/v3/protocol/pub"
+	"go.nanomsg.org/mangos/v3/protocol/xsub"
+)
+
+// ErrTopic is returned when publishing on a topic the process did not
+// declare with Server.Advertise.
+var ErrTopic = errors.New("tdaq: topic not advertised")
+
+// Topics are hierarchical names, with levels separated by '/' (e.g.
+// "/tracker/layer1/hits").
+// Subscription patterns may use the '+' wildcard, matching exactly one level
+// (e.g. "/tracker/+/hits"), and the '#' wildcard as their last level,
+// matching any number of levels (e.g. "/tracker/#").
+//
+// Publishers are connected to the subscribers of matching patterns at
+// /config, by run-ctl, from the topics and patterns declared by the
+// processes when they /join.
+
+// validTopic checks the provided topic is a concrete topic.
+func validTopic(topic string) error {
+	if !strings.HasPrefix(topic, "/") || len(topic) > 255 {
+		return fmt.Errorf("invalid topic %q", topic)
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("invalid topic %q: wildcards are only allowed in subscriptions", topic)
+	}
+	return nil
+}
+
+// validPattern checks the provided subscription pattern.
+func validPattern(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("invalid topic pattern %q", pattern)
+	}
+	levels := strings.Split(pattern[1:], "/")
+	for i, lvl := range levels {
+		switch {
+		case lvl == "#" && i != len(levels)-1:
+			return fmt.Errorf("invalid topic pattern %q: '#' must be the last level", pattern)
+		case lvl != "#" && lvl != "+" && strings.ContainsAny(lvl, "+#"):
+			return fmt.Errorf("invalid topic pattern %q: wildcards must occupy a whole level", pattern)
+		}
+	}
+	return nil
+}
+
+// matchTopic returns whether the topic matches the subscription pattern.
+func matchTopic(pattern, topic string) bool {
+	var (
+		ps = strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+		ts = strings.Split(strings.TrimPrefix(topic, "/"), "/")
+	)
+	for i, p := range ps {
+		switch {
+		case p == "#":
+			return true
+		case i >= len(ts):
+			return false
+		case p != "+" && p != ts[i]:
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
+
+// matchTopics returns whether any of the topics matches any of the
+// subscription patterns.
+func matchTopics(patterns, topics []string) bool {
+	for _, p := range patterns {
+		for _, t := range topics {
+			if matchTopic(p, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Advertise declares a topic the process publishes on, with
+// Context.Publish.
+//
+// Topics must be advertised before the process is run.
+func (srv *Server) Advertise(topic string) error {
+	return srv.tmgr.advertise(topic)
+}
+
+// Subscribe registers the handler of the data frames published on the
+// topics matching the provided pattern.
+// The path of the frames passed to the handler is their concrete topic.
+//
+// Subscriptions must be registered before the process is run.
+func (srv *Server) Subscribe(pattern string, h InputHandler) error {
+	return srv.tmgr.subscribe(pattern, h)
+}
+
+// Publish publishes the data on the provided topic, to all the processes
+// subscribed to a matching pattern.
+func (ctx Context) Publish(topic string, data []byte) error {
+	if ctx.topics == nil {
+		return fmt.Errorf("could not publish on %q: %w", topic, ErrTopic)
+	}
+	return ctx.topics.publish(ctx.Ctx, topic, data)
+}
+
+// topicmgr handles the topics published and subscribed to by a process.
+type topicmgr struct {
+	mu   sync.RWMutex
+	pubs map[string]bool         // advertised topics
+	subs map[string]InputHandler // handlers of the subscriptions, indexed by pattern
+
+	sck  mangos.Socket            // topics-PUB socket (nil if no topic is advertised)
+	lis  mangos.Listener          // listener of the topics-PUB socket
+	scks map[string]mangos.Socket // connections to the publishers, indexed by address
+}
+
+func newTopicMgr() *topicmgr {
+	return &topicmgr{
+		pubs: make(map[string]bool),
+		subs: make(map[string]InputHandler),
+		scks: make(map[string]mangos.Socket),
+	}
+}
+
+func (mgr *topicmgr) advertise(topic string) error {
+	err := validTopic(topic)
+	if err != nil {
+		return err
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.pubs[topic] = true
+	return nil
+}
+
+func (mgr *topicmgr) subscribe(pattern string, h InputHandler) error {
+	err := validPattern(pattern)
+	if err != nil {
+		return err
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.subs[pattern] = h
+	return nil
+}
+
+// topics returns the advertised topics.
+func (mgr *topicmgr) topics() []string {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	ts := make([]string, 0, len(mgr.pubs))
+	for t := range mgr.pubs {
+		ts = append(ts, t)
+	}
+	sort.Strings(ts)
+	return ts
+}
+
+// patterns returns the subscription patterns.
+func (mgr *topicmgr) patterns() []string {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	ps := make([]string, 0, len(mgr.subs))
+	for p := range mgr.subs {
+		ps = append(ps, p)
+	}
+	sort.Strings(ps)
+	return ps
+}
+
+// init creates the socket publishing the topics, if the process advertised
+// any topic.
+func (mgr *topicmgr) init(srv *Server) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	if len(mgr.pubs) == 0 {
+		return nil
+	}
+
+	sck, lis, err := makeListener(pub.NewSocket, makeAddr(srv.cfg))
+	if err != nil {
+		return fmt.Errorf("could not create topics socket: %w", err)
+	}
+	err = setMaxFrameSize(sck, srv.maxFrame)
+	if err != nil {
+		_ = lis.Close()
+		_ = sck.Close()
+		return fmt.Errorf("could not set maximum frame size of topics socket: %w", err)
+	}
+	mgr.sck = sck
+	mgr.lis = lis
+	return nil
+}
+
+// addr returns the address of the socket publishing the topics, if any.
+func (mgr *topicmgr) addr() string {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	if mgr.lis == nil {
+		return ""
+	}
+	return mgr.lis.Address()
+}
+
+func (mgr *topicmgr) publish(ctx context.Context, topic string, data []byte) error {
+	mgr.mu.RLock()
+	ok := mgr.pubs[topic]
+	sck := mgr.sck
+	mgr.mu.RUnlock()
+
+	if !ok || sck == nil {
+		return fmt.Errorf("could not publish on %q: %w", topic, ErrTopic)
+	}
+	return SendFrame(ctx, sck, Frame{Type: FrameData, Path: topic, Body: data})
+}
+
+// onConfig connects the process to the publishers of the topics matching its
+// subscriptions, as provided by run-ctl, and dispatches the received frames
+// to the handlers of the matching subscriptions.
+func (mgr *topicmgr) onConfig(srv *Server, addrs map[string]string) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	want := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		want[addr] = true
+	}
+	for addr, sck := range mgr.scks {
+		if !want[addr] {
+			_ = sck.Close()
+			delete(mgr.scks, addr)
+		}
+	}
+
+	for proc, addr := range addrs {
+		if _, ok := mgr.scks[addr]; ok {
+			continue
+		}
+		sck, err := xsub.NewSocket()
+		if err != nil {
+			return fmt.Errorf("could not create topics socket for %q: %w", proc, err)
+		}
+		err = setMaxFrameSize(sck, srv.maxFrame)
+		if err != nil {
+			_ = sck.Close()
+			return fmt.Errorf("could not set maximum frame size of topics socket for %q: %w", proc, err)
+		}
+		err = sck.Dial(addr)
+		if err != nil {
+			_ = sck.Close()
+			return fmt.Errorf("could not dial topics socket of %q: %w", proc, err)
+		}
+		mgr.scks[addr] = sck
+		go mgr.recv(srv, proc, sck)
+	}
+	return nil
+}
+
+// recv dispatches the frames received from a publisher, until the
+// connection is closed.
+func (mgr *topicmgr) recv(srv *Server, proc string, sck mangos.Socket) {
+	ctx := srv.context(context.Background())
+	for {
+		frame, err := RecvFrame(ctx.Ctx, sck)
+		if err != nil {
+			if errors.Is(err, mangos.ErrClosed) {
+				return
+			}
+			srv.msg.Warnf("could not receive topic frame from %q: %+v", proc, err)
+			continue
+		}
+		if frame.Type != FrameData {
+			continue
+		}
+
+		mgr.mu.RLock()
+		var hs []InputHandler
+		for pattern, h := range mgr.subs {
+			if matchTopic(pattern, frame.Path) {
+				hs = append(hs, h)
+			}
+		}
+		mgr.mu.RUnlock()
+
+		for _, h := range hs {
+			err := h(ctx, frame)
+			if err != nil {
+				srv.msg.Warnf("could not handle topic %q from %q: %+v", frame.Path, proc, err)
+			}
+		}
+	}
+}
+
+func (mgr *topicmgr) close() {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	for addr, sck := range mgr.scks {
+		_ = sck.Close()
+		delete(mgr.scks, addr)
+	}
+	if mgr.lis != nil {
+		_ = mgr.lis.Close()
+	}
+	if mgr.sck != nil {
+		_ = mgr.sck.Close()
+	}
+}
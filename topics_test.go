@@ -0,0 +1,118 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+func TestMatchTopic(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"/tracker/layer1", "/tracker/layer1", true},
+		{"/tracker/layer1", "/tracker/layer2", false},
+		{"/tracker/layer1", "/tracker/layer1/hits", false},
+		{"/tracker/+", "/tracker/layer1", true},
+		{"/tracker/+", "/tracker/layer1/hits", false},
+		{"/tracker/+/hits", "/tracker/layer1/hits", true},
+		{"/tracker/+/hits", "/tracker/layer1/tracks", false},
+		{"/tracker/#", "/tracker", true},
+		{"/tracker/#", "/tracker/layer1", true},
+		{"/tracker/#", "/tracker/layer1/hits", true},
+		{"/tracker/#", "/calo/layer1", false},
+		{"/#", "/calo/layer1", true},
+	} {
+		t.Run(tc.pattern+"@"+tc.topic, func(t *testing.T) {
+			if got := matchTopic(tc.pattern, tc.topic); got != tc.want {
+				t.Fatalf("invalid match: got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+
+	for _, pattern := range []string{"tracker/#", "/tracker/#/hits", "/tracker/layer+"} {
+		if err := validPattern(pattern); err == nil {
+			t.Fatalf("expected an error for pattern %q", pattern)
+		}
+	}
+	for _, topic := range []string{"tracker", "/tracker/+", "/tracker/#"} {
+		if err := validTopic(topic); err == nil {
+			t.Fatalf("expected an error for topic %q", topic)
+		}
+	}
+}
+
+func TestTopics(t *testing.T) {
+	pub := New(config.Process{Name: "tracker", Trans: "tcp"}, ioutil.Discard)
+	for _, topic := range []string{"/tracker/layer1/hits", "/tracker/layer2/hits", "/tracker/tracks"} {
+		err := pub.Advertise(topic)
+		if err != nil {
+			t.Fatalf("could not advertise %q: %+v", topic, err)
+		}
+	}
+	err := pub.tmgr.init(pub)
+	if err != nil {
+		t.Fatalf("could not setup topics: %+v", err)
+	}
+	defer pub.tmgr.close()
+
+	sub := New(config.Process{Name: "monitor", Trans: "tcp"}, ioutil.Discard)
+	hits := make(chan string, 10)
+	err = sub.Subscribe("/tracker/+/hits", func(ctx Context, src Frame) error {
+		hits <- src.Path + ":" + string(src.Body)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not subscribe: %+v", err)
+	}
+	defer sub.tmgr.close()
+
+	rc := &RunControl{clients: newClientDB(
+		&client{name: "tracker", topics: pub.tmgr.addr(), pubs: pub.tmgr.topics()},
+		&client{name: "calo", topics: "tcp://127.0.0.1:1", pubs: []string{"/calo/towers"}},
+		&client{name: "monitor", subs: sub.tmgr.patterns()},
+	)}
+	addrs := rc.publishers(rc.clients.get("monitor"))
+	if got, want := addrs, map[string]string{"tracker": pub.tmgr.addr()}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid publishers:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	err = sub.tmgr.onConfig(sub, addrs)
+	if err != nil {
+		t.Fatalf("could not connect to publishers: %+v", err)
+	}
+
+	ctx := pub.context(context.Background())
+	err = ctx.Publish("/calo/towers", nil)
+	if !errors.Is(err, ErrTopic) {
+		t.Fatalf("invalid error: got=%+v, want=%+v", err, ErrTopic)
+	}
+
+	// wait for the subscription to be connected.
+	timeout := time.After(5 * time.Second)
+	for {
+		_ = ctx.Publish("/tracker/tracks", []byte("t"))
+		_ = ctx.Publish("/tracker/layer1/hits", []byte("h1"))
+		select {
+		case got := <-hits:
+			if want := "/tracker/layer1/hits:h1"; got != want {
+				t.Fatalf("invalid frame: got=%q, want=%q", got, want)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("no frame received")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/go-daq/tdaq/log"
+)
+
+// eventHdrSize is the size of the header of an event frame payload:
+//   - logical event ID of the frame (u64)
+//   - flags of the event (u8)
+//   - type of the tagged frame (u8)
+const eventHdrSize = 8 + 1 + 1
+
+const eventTraced = 1 << 0 // the event is traced through the pipeline
+
+// tagFrame wraps the provided frame into an event frame, carrying its
+// event ID and trace flag.
+func tagFrame(frame Frame) Frame {
+	body := make([]byte, eventHdrSize+len(frame.Body))
+	binary.LittleEndian.PutUint64(body[0:8], frame.EventID)
+	if frame.Trace {
+		body[8] |= eventTraced
+	}
+	body[9] = byte(frame.Type)
+	copy(body[eventHdrSize:], frame.Body)
+	return Frame{Type: FrameEvent, Path: frame.Path, Body: body}
+}
+
+// decodeEvent decodes the frame carried by the provided event frame.
+func decodeEvent(frame Frame) (Frame, error) {
+	if len(frame.Body) < eventHdrSize {
+		return Frame{}, fmt.Errorf("invalid event frame size (got=%d, want>=%d)", len(frame.Body), eventHdrSize)
+	}
+	return Frame{
+		Type:    FrameType(frame.Body[9]),
+		Path:    frame.Path,
+		Body:    frame.Body[eventHdrSize:],
+		EventID: binary.LittleEndian.Uint64(frame.Body[0:8]),
+		Trace:   frame.Body[8]&eventTraced != 0,
+	}, nil
+}
+
+// tagged returns whether the provided frame carries event tags.
+func tagged(frame Frame) bool {
+	return frame.EventID != 0 || frame.Trace
+}
+
+// sampleEvent marks the provided data frame as traced when its event is
+// sampled, i.e. when its event ID is a multiple of rate.
+// Sampling on the event ID lets all the processes of the pipeline agree on
+// the sampled events.
+func sampleEvent(frame *Frame, rate int) {
+	if rate <= 0 || frame.EventID == 0 || frame.Trace {
+		return
+	}
+	frame.Trace = frame.EventID%uint64(rate) == 0
+}
+
+// traceEvent logs the passage of a traced data frame through an end-point
+// of the process.
+func traceEvent(msg log.MsgStream, what, ep string, frame Frame, beg time.Time) {
+	msg.Infof(
+		"trace event=%d: %s %q at %s (%v)",
+		frame.EventID, what, ep,
+		beg.UTC().Format(time.RFC3339Nano), time.Since(beg),
+	)
+}
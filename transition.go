@@ -0,0 +1,224 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+	"go.nanomsg.org/mangos/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrTransitionTimeout is returned when some TDAQ processes did not complete
+// an FSM transition within its configured maximum duration.
+var ErrTransitionTimeout = errors.New("tdaq: transition timed out")
+
+// transition tracks the progress of an FSM transition across the TDAQ
+// processes, within the maximum duration configured for that transition.
+type transition struct {
+	cmd      CmdType
+	timeout  time.Duration // maximum duration of the transition (0: none)
+	deadline time.Time
+
+	mu       sync.Mutex
+	done     []string // processes that completed the transition
+	laggards []string // processes that did not complete the transition in time
+}
+
+// newTransition starts tracking the provided FSM transition.
+// newTransition discards the replies of the processes to the previous
+// execution of the command.
+func (rc *RunControl) newTransition(cmd CmdType) *transition {
+	rc.replies.reset(cmd)
+	tr := &transition{cmd: cmd, timeout: rc.cfg.Timeouts[cmd.String()]}
+	if tr.timeout > 0 {
+		tr.deadline = time.Now().Add(tr.timeout)
+	}
+	return tr
+}
+
+// do runs f, the exchange of the transition command with the process cli,
+// within the remaining time of the transition.
+func (tr *transition) do(cli *client, f func() error) error {
+	if tr.timeout > 0 {
+		left := time.Until(tr.deadline)
+		if left <= 0 {
+			tr.lag(cli.name)
+			return fmt.Errorf("could not send %v to %q: %w", tr.cmd, cli.name, mangos.ErrSendTimeout)
+		}
+		err := cli.setDeadline(left)
+		if err != nil {
+			return fmt.Errorf("could not set %v deadline for %q: %w", tr.cmd, cli.name, err)
+		}
+		defer func() {
+			_ = cli.setDeadline(0)
+		}()
+	}
+
+	err := f()
+	switch {
+	case err == nil:
+		tr.mu.Lock()
+		tr.done = append(tr.done, cli.name)
+		tr.mu.Unlock()
+	case errors.Is(err, mangos.ErrRecvTimeout), errors.Is(err, mangos.ErrSendTimeout):
+		tr.lag(cli.name)
+	}
+	return err
+}
+
+func (tr *transition) lag(name string) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.laggards = append(tr.laggards, name)
+}
+
+// timedOut returns whether some processes did not complete the transition
+// in time.
+func (tr *transition) timedOut() bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return len(tr.laggards) > 0
+}
+
+// setDeadline sets the maximum duration of the exchanges of commands with
+// the process (0: none).
+func (cli *client) setDeadline(d time.Duration) error {
+	err := cli.cmd.SetOption(mangos.OptionSendDeadline, d)
+	if err != nil {
+		return err
+	}
+	return cli.cmd.SetOption(mangos.OptionRecvDeadline, d)
+}
+
+// rollbacks describes, for each FSM transition that can be aborted, the
+// command rolling back the processes that completed it, and the resulting
+// state.
+var rollbacks = map[CmdType]struct {
+	cmd    CmdType
+	status fsm.Status
+}{
+	CmdConfig: {CmdReset, fsm.UnConf},
+	CmdInit:   {CmdReset, fsm.UnConf},
+	CmdStart:  {CmdStop, fsm.Stopped},
+	CmdGo:     {CmdStop, fsm.Stopped},
+}
+
+// abort aborts a timed out transition: the laggards are reported and all
+// processes are rolled back to a consistent state, when the transition
+// can be rolled back.
+// abort must be called with rc.mu held.
+func (rc *RunControl) abort(ctx context.Context, tr *transition) error {
+	err := fmt.Errorf(
+		"could not complete %v within %v (laggards: %q): %w",
+		tr.cmd, tr.timeout, tr.laggards, ErrTransitionTimeout,
+	)
+	rc.msg.Errorf("%v transition timed out after %v: laggards=%q, done=%q", tr.cmd, tr.timeout, tr.laggards, tr.done)
+	rc.alerts.raise(AlertError, rc.cfg.Name, "%v transition timed out (laggards: %q)", tr.cmd, tr.laggards)
+
+	rb, ok := rollbacks[tr.cmd]
+	if !ok {
+		rc.setStatus(fsm.Error)
+		return err
+	}
+
+	rc.msg.Warnf("rolling back %v with %v...", tr.cmd, rb.cmd)
+	var (
+		undo   = rc.newTransition(rb.cmd)
+		rolled = true
+		lagged = make(map[string]bool, len(tr.laggards))
+		todo   = append(append([]string(nil), tr.done...), tr.laggards...)
+	)
+	for _, name := range tr.laggards {
+		lagged[name] = true
+	}
+
+	// roll back in reverse dependency order.
+	// laggards may or may not have completed the transition: failing to
+	// roll them back leaves them in error, without failing the roll back.
+	for i := len(todo) - 1; i >= 0; i-- {
+		cli := rc.clients.get(todo[i])
+		e := undo.do(cli, func() error {
+			return rc.command(ctx, cli, rb.cmd)
+		})
+		switch {
+		case e == nil:
+			cli.setStatus(rb.status)
+		case lagged[cli.name]:
+			rc.msg.Warnf("could not roll back laggard %q with %v: %+v", cli.name, rb.cmd, e)
+			cli.setStatus(fsm.Error)
+		default:
+			rc.msg.Errorf("could not roll back %q with %v: %+v", cli.name, rb.cmd, e)
+			cli.setStatus(fsm.Error)
+			rolled = false
+		}
+	}
+
+	if !rolled {
+		rc.setStatus(fsm.Error)
+		return err
+	}
+	rc.msg.Warnf("rolling back %v with %v... [ok]", tr.cmd, rb.cmd)
+	rc.setStatus(rb.status)
+	return err
+}
+
+// failed records the failure of an FSM transition.
+// Timed out transitions already set the status of run-ctl when aborted.
+func (rc *RunControl) failed(err error) {
+	if errors.Is(err, ErrTransitionTimeout) {
+		return
+	}
+	rc.setStatus(fsm.Error)
+}
+
+// release broadcasts /go, concurrently, to all the processes armed by
+// /start, so they begin producing data together.
+// release must be called with rc.mu held.
+func (rc *RunControl) release(ctx context.Context) error {
+	var (
+		grp   errgroup.Group
+		tr    = rc.newTransition(CmdGo)
+		armed []*client
+	)
+	// processes are sorted out before any /go is sent, as tr.done is
+	// then only updated under tr.mu.
+	for _, name := range rc.deps {
+		cli := rc.clients.get(name)
+		switch {
+		case cli == nil:
+			continue
+		case !cli.barrier:
+			// processes without barrier support started producing
+			// data with /start.
+			tr.done = append(tr.done, name)
+		default:
+			armed = append(armed, cli)
+		}
+	}
+
+	for _, cli := range armed {
+		cli := cli
+		grp.Go(func() error {
+			return tr.do(cli, func() error {
+				return rc.command(ctx, cli, CmdGo)
+			})
+		})
+	}
+
+	err := grp.Wait()
+	if tr.timedOut() {
+		return rc.abort(ctx, tr)
+	}
+	if err != nil {
+		return fmt.Errorf("could not broadcast /go: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trigger // import "github.com/go-daq/tdaq/trigger"
+
+import (
+	"fmt"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Broadcaster publishes the triggers of a trigger source on an output
+// end-point, so a common trigger can be fanned out to many readout devices.
+//
+// The trigger source is armed on /start and disarmed on /stop.
+type Broadcaster struct {
+	Src Source // trigger source
+	N   uint64 // number of triggers published since /init
+}
+
+func (dev *Broadcaster) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	return nil
+}
+
+func (dev *Broadcaster) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.N = 0
+	return nil
+}
+
+func (dev *Broadcaster) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.N = 0
+	return dev.Src.Disarm()
+}
+
+func (dev *Broadcaster) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	err := dev.Src.Arm()
+	if err != nil {
+		return fmt.Errorf("could not arm trigger source: %w", err)
+	}
+	return nil
+}
+
+func (dev *Broadcaster) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> n=%d", dev.N)
+	err := dev.Src.Disarm()
+	if err != nil {
+		return fmt.Errorf("could not disarm trigger source: %w", err)
+	}
+	return nil
+}
+
+func (dev *Broadcaster) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return dev.Src.Close()
+}
+
+// Output publishes the next trigger fired by the trigger source.
+func (dev *Broadcaster) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	trg, err := dev.Src.Next(ctx.Ctx)
+	if err != nil {
+		dst.Body = nil
+		if ctx.Ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("could not wait for trigger: %w", err)
+	}
+
+	dst.Body, err = trg.MarshalTDAQ()
+	if err != nil {
+		return fmt.Errorf("could not encode trigger: %w", err)
+	}
+	dev.N++
+	return nil
+}
@@ -0,0 +1,17 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trigger // import "github.com/go-daq/tdaq/trigger"
+
+// Edge describes the GPIO signal edges firing triggers.
+type Edge string
+
+const (
+	Rising  Edge = "rising"
+	Falling Edge = "falling"
+	Both    Edge = "both"
+)
+
+// gpioRoot is the sysfs directory of GPIO lines.
+const gpioRoot = "/sys/class/gpio"
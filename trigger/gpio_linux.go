@@ -0,0 +1,133 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trigger // import "github.com/go-daq/tdaq/trigger"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// gpioPoll is the timeout, in ms, of a wait for a GPIO edge, after which
+// a GPIO trigger source checks whether it was closed.
+const gpioPoll = 100
+
+// GPIO is a hardware trigger source, firing upon the edges of the signal of
+// a GPIO line, through the sysfs GPIO interface.
+// The GPIO line must be exported and configured as an input.
+type GPIO struct {
+	*base
+
+	f    *os.File
+	epfd int
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewGPIO returns a trigger source firing upon the provided edges of
+// the signal of the numbered GPIO line.
+func NewGPIO(pin int, edge Edge) (*GPIO, error) {
+	switch edge {
+	case Rising, Falling, Both:
+	default:
+		return nil, fmt.Errorf("trigger: invalid GPIO edge %q", edge)
+	}
+
+	dir := filepath.Join(gpioRoot, "gpio"+strconv.Itoa(pin))
+	err := ioutil.WriteFile(filepath.Join(dir, "edge"), []byte(edge), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure edge of GPIO %d: %w", pin, err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "value"))
+	if err != nil {
+		return nil, fmt.Errorf("could not open GPIO %d: %w", pin, err)
+	}
+
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("could not create epoll for GPIO %d: %w", pin, err)
+	}
+
+	fd := int(f.Fd())
+	err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+		Events: syscall.EPOLLPRI | syscall.EPOLLERR,
+		Fd:     int32(fd),
+	})
+	if err != nil {
+		_ = syscall.Close(epfd)
+		_ = f.Close()
+		return nil, fmt.Errorf("could not poll GPIO %d: %w", pin, err)
+	}
+
+	g := &GPIO{
+		base: newBase("gpio"),
+		f:    f,
+		epfd: epfd,
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go g.loop()
+	return g, nil
+}
+
+func (g *GPIO) loop() {
+	defer close(g.done)
+
+	var (
+		evts = make([]syscall.EpollEvent, 1)
+		buf  = make([]byte, 8)
+	)
+
+	// the first wait always reports the current value.
+	first := true
+	for {
+		select {
+		case <-g.quit:
+			return
+		default:
+		}
+
+		n, err := syscall.EpollWait(g.epfd, evts, gpioPoll)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		// consume the value to re-arm the edge detection.
+		_, _ = g.f.ReadAt(buf, 0)
+		if first {
+			first = false
+			continue
+		}
+		g.fire()
+	}
+}
+
+func (g *GPIO) Close() error {
+	select {
+	case <-g.quit:
+		return nil
+	default:
+	}
+	close(g.quit)
+	<-g.done
+
+	_ = g.Disarm()
+	err := syscall.Close(g.epfd)
+	if e := g.f.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
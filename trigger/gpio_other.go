@@ -0,0 +1,30 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package trigger // import "github.com/go-daq/tdaq/trigger"
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// GPIO is a hardware trigger source, firing upon the edges of the signal of
+// a GPIO line.
+// GPIO trigger sources are only supported on linux.
+type GPIO struct {
+	*base
+}
+
+// NewGPIO returns a trigger source firing upon the provided edges of
+// the signal of the numbered GPIO line.
+func NewGPIO(pin int, edge Edge) (*GPIO, error) {
+	return nil, fmt.Errorf("trigger: GPIO trigger sources not supported on %s", runtime.GOOS)
+}
+
+func (g *GPIO) Close() error {
+	return g.Disarm()
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trigger // import "github.com/go-daq/tdaq/trigger"
+
+import (
+	"fmt"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Net is a trigger source firing upon the trigger messages received on
+// an input end-point, typically the trigger-broadcast end-point of
+// a Broadcaster.
+//
+// Triggers keep the sequence number, time and origin they were fired with
+// by the upstream source.
+type Net struct {
+	*base
+}
+
+// NewNet returns a trigger source firing upon trigger messages.
+// The Recv method should be registered as the handler of the input
+// end-point carrying the trigger messages:
+//
+//	srv.InputHandle(trigger.EndPoint, src.Recv)
+func NewNet() *Net {
+	return &Net{base: newBase("net")}
+}
+
+// Recv handles a trigger message.
+func (n *Net) Recv(ctx tdaq.Context, src tdaq.Frame) error {
+	var trg Trigger
+	err := trg.UnmarshalTDAQ(src.Body)
+	if err != nil {
+		return fmt.Errorf("could not decode trigger message: %w", err)
+	}
+	n.forward(trg)
+	return nil
+}
+
+func (n *Net) Close() error {
+	return n.Disarm()
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trigger // import "github.com/go-daq/tdaq/trigger"
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timer is a software trigger source, firing at a fixed period.
+type Timer struct {
+	*base
+	period time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTimer returns a trigger source firing every period, once armed.
+func NewTimer(period time.Duration) (*Timer, error) {
+	if period <= 0 {
+		return nil, fmt.Errorf("trigger: invalid timer period %v", period)
+	}
+	return &Timer{base: newBase("timer"), period: period}, nil
+}
+
+func (t *Timer) Arm() error {
+	t.halt()
+	err := t.base.Arm()
+	if err != nil {
+		return err
+	}
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	go t.loop(t.stop, t.done)
+	return nil
+}
+
+func (t *Timer) Disarm() error {
+	t.halt()
+	return t.base.Disarm()
+}
+
+func (t *Timer) Close() error {
+	return t.Disarm()
+}
+
+func (t *Timer) loop(stop, done chan struct{}) {
+	defer close(done)
+	tick := time.NewTicker(t.period)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			t.fire()
+		}
+	}
+}
+
+func (t *Timer) halt() {
+	if t.stop == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+	t.stop = nil
+	t.done = nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package trigger provides a uniform interface to trigger sources, so
+// producers can be armed and fired the same way whether triggers come from
+// a software timer, a GPIO edge or a network trigger message.
+//
+// A common trigger can be fanned out to many readout devices with a
+// Broadcaster publishing triggers on the standard trigger end-point, and
+// Net trigger sources consuming that end-point.
+package trigger // import "github.com/go-daq/tdaq/trigger"
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/times"
+)
+
+// EndPoint is the name of the standard trigger-broadcast end-point.
+const EndPoint = "/trigger"
+
+// queueSize is the number of fired triggers a source buffers until they are
+// consumed. Triggers fired while the queue is full are missed.
+const queueSize = 64
+
+// Trigger describes a fired trigger.
+type Trigger struct {
+	Seq    uint64      // sequence number of the trigger, counted from 1 since the source was armed
+	Time   times.Stamp // time at which the trigger fired
+	Origin string      // kind of trigger source that fired the trigger
+}
+
+func (trg Trigger) MarshalTDAQ() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := tdaq.NewEncoder(buf)
+	enc.WriteU64(trg.Seq)
+	enc.WriteU8(uint8(trg.Time.Source))
+	enc.WriteI64(trg.Time.Nanos)
+	enc.WriteStr(trg.Origin)
+	err := enc.Err()
+	return buf.Bytes(), err
+}
+
+func (trg *Trigger) UnmarshalTDAQ(p []byte) error {
+	dec := tdaq.NewDecoder(bytes.NewReader(p))
+	trg.Seq = dec.ReadU64()
+	trg.Time.Source = times.Source(dec.ReadU8())
+	trg.Time.Nanos = dec.ReadI64()
+	trg.Origin = dec.ReadStr()
+	return dec.Err()
+}
+
+// Source is a source of triggers.
+//
+// A source only fires triggers while it is armed: Next blocks until
+// the source is armed and fires a trigger.
+type Source interface {
+	// Arm starts accepting triggers.
+	// The sequence number of triggers is reset.
+	Arm() error
+	// Disarm stops accepting triggers.
+	// Triggers fired but not yet consumed are discarded.
+	Disarm() error
+	// Next waits for the next trigger fired by the source.
+	Next(ctx context.Context) (Trigger, error)
+	// Close releases the resources held by the source.
+	Close() error
+}
+
+// base implements the arming and queueing of triggers shared by
+// all trigger sources.
+type base struct {
+	origin string
+
+	mu    sync.Mutex
+	clock times.Clock
+	armed bool
+	seq   uint64
+	ch    chan Trigger
+
+	missed uint64 // number of triggers missed since the source was armed (atomic)
+}
+
+func newBase(origin string) *base {
+	return &base{
+		origin: origin,
+		clock:  times.NewRealtime(),
+		ch:     make(chan Trigger, queueSize),
+	}
+}
+
+// SetClock sets the clock used to timestamp triggers.
+// Triggers are timestamped with the realtime clock by default.
+func (b *base) SetClock(clk times.Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clock = clk
+}
+
+func (b *base) Arm() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drain()
+	b.armed = true
+	b.seq = 0
+	atomic.StoreUint64(&b.missed, 0)
+	return nil
+}
+
+func (b *base) Disarm() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.armed = false
+	b.drain()
+	return nil
+}
+
+func (b *base) drain() {
+	for {
+		select {
+		case <-b.ch:
+		default:
+			return
+		}
+	}
+}
+
+func (b *base) Next(ctx context.Context) (Trigger, error) {
+	select {
+	case <-ctx.Done():
+		return Trigger{}, ctx.Err()
+	case trg := <-b.ch:
+		return trg, nil
+	}
+}
+
+// Missed returns the number of triggers missed since the source was armed,
+// because previous triggers were not consumed in time.
+func (b *base) Missed() uint64 {
+	return atomic.LoadUint64(&b.missed)
+}
+
+// fire fires a new trigger, if the source is armed.
+func (b *base) fire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.armed {
+		return
+	}
+	b.seq++
+	b.push(Trigger{Seq: b.seq, Time: b.clock.Now(), Origin: b.origin})
+}
+
+// forward fires the provided trigger, fired by another source, if
+// the source is armed.
+func (b *base) forward(trg Trigger) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.armed {
+		return
+	}
+	b.push(trg)
+}
+
+func (b *base) push(trg Trigger) {
+	select {
+	case b.ch <- trg:
+	default:
+		atomic.AddUint64(&b.missed, 1)
+	}
+}
+
+var (
+	_ tdaq.Marshaler   = (*Trigger)(nil)
+	_ tdaq.Unmarshaler = (*Trigger)(nil)
+
+	_ Source = (*Timer)(nil)
+	_ Source = (*GPIO)(nil)
+	_ Source = (*Net)(nil)
+)
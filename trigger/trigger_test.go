@@ -0,0 +1,120 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trigger
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/times"
+)
+
+func TestTriggerCodec(t *testing.T) {
+	want := Trigger{
+		Seq:    42,
+		Time:   times.Stamp{Source: times.PTP, Nanos: 1234567890},
+		Origin: "gpio",
+	}
+	raw, err := want.MarshalTDAQ()
+	if err != nil {
+		t.Fatalf("could not marshal trigger: %+v", err)
+	}
+
+	var got Trigger
+	err = got.UnmarshalTDAQ(raw)
+	if err != nil {
+		t.Fatalf("could not unmarshal trigger: %+v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid r/w round-trip:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	src, err := NewTimer(time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not create timer: %+v", err)
+	}
+	defer src.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, err = src.Next(ctx)
+	cancel()
+	if err == nil {
+		t.Fatalf("expected no trigger from a disarmed source")
+	}
+
+	for i := 0; i < 2; i++ {
+		err = src.Arm()
+		if err != nil {
+			t.Fatalf("could not arm timer: %+v", err)
+		}
+
+		for want := uint64(1); want <= 3; want++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			trg, err := src.Next(ctx)
+			cancel()
+			if err != nil {
+				t.Fatalf("could not wait for trigger: %+v", err)
+			}
+			if trg.Seq != want || trg.Origin != "timer" || trg.Time.Source != times.Realtime {
+				t.Fatalf("invalid trigger: %#v", trg)
+			}
+		}
+
+		err = src.Disarm()
+		if err != nil {
+			t.Fatalf("could not disarm timer: %+v", err)
+		}
+	}
+}
+
+func TestNet(t *testing.T) {
+	src := NewNet()
+	defer src.Close()
+
+	want := Trigger{Seq: 7, Time: times.Stamp{Source: times.Realtime, Nanos: 42}, Origin: "timer"}
+	raw, err := want.MarshalTDAQ()
+	if err != nil {
+		t.Fatalf("could not marshal trigger: %+v", err)
+	}
+	frame := tdaq.Frame{Type: tdaq.FrameData, Path: EndPoint, Body: raw}
+
+	// triggers received while disarmed are discarded.
+	err = src.Recv(tdaq.Context{}, frame)
+	if err != nil {
+		t.Fatalf("could not receive trigger: %+v", err)
+	}
+
+	err = src.Arm()
+	if err != nil {
+		t.Fatalf("could not arm source: %+v", err)
+	}
+	err = src.Recv(tdaq.Context{}, frame)
+	if err != nil {
+		t.Fatalf("could not receive trigger: %+v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got, err := src.Next(ctx)
+	if err != nil {
+		t.Fatalf("could not wait for trigger: %+v", err)
+	}
+	if got != want {
+		t.Fatalf("invalid trigger:\ngot = %#v\nwant= %#v\n", got, want)
+	}
+
+	for i := 0; i < queueSize+3; i++ {
+		_ = src.Recv(tdaq.Context{}, frame)
+	}
+	if got, want := src.Missed(), uint64(3); got != want {
+		t.Fatalf("invalid number of missed triggers: got=%d, want=%d", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-daq/tdaq/fsm"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrTransitionVetoed is returned when some TDAQ processes voted against
+// an FSM transition prepared by run-ctl.
+var ErrTransitionVetoed = errors.New("tdaq: transition vetoed")
+
+// PrepareHandler votes on an FSM transition prepared by run-ctl.
+// A non-nil error vetoes the transition.
+type PrepareHandler func(ctx Context, cmd CmdType) error
+
+// AbortHandler releases what was acquired while preparing an FSM
+// transition that was eventually vetoed.
+type AbortHandler func(ctx Context, cmd CmdType)
+
+// Preparer is implemented by devices voting on the FSM transitions
+// prepared by run-ctl.
+type Preparer interface {
+	// Prepare votes on the provided FSM transition: a non-nil error
+	// vetoes it.
+	Prepare(ctx Context, cmd CmdType) error
+
+	// Abort releases what Prepare acquired for a transition that was
+	// eventually vetoed.
+	Abort(ctx Context, cmd CmdType)
+}
+
+// fsmTransitions lists, for each FSM command, the states a process may
+// execute it from and the name of the resulting transition.
+var fsmTransitions = map[CmdType]struct {
+	from []fsm.Status
+	name string
+}{
+	CmdConfig: {[]fsm.Status{fsm.UnConf, fsm.Conf, fsm.Error}, "configured"},
+	CmdInit:   {[]fsm.Status{fsm.Conf}, "initialized"},
+	CmdReset:  {[]fsm.Status{fsm.UnConf, fsm.Conf, fsm.Init, fsm.Stopped, fsm.Error}, "reset"},
+	CmdStart:  {[]fsm.Status{fsm.Init, fsm.Stopped}, "started"},
+	CmdStop:   {[]fsm.Status{fsm.Running, fsm.Paused}, "stopped"},
+	CmdPause:  {[]fsm.Status{fsm.Running}, "paused"},
+	CmdResume: {[]fsm.Status{fsm.Paused}, "resumed"},
+}
+
+// checkTransition checks the process may execute the provided FSM command
+// from its current state.
+// checkTransition must be called with srv.mu held.
+func (srv *Server) checkTransition(cmd CmdType) error {
+	tr, ok := fsmTransitions[cmd]
+	if !ok {
+		return fmt.Errorf("%s: invalid FSM command %v", srv.name, cmd)
+	}
+	for _, st := range tr.from {
+		if srv.state.cur == st {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: invalid state transition %v -> %s", srv.name, srv.state.cur, tr.name)
+}
+
+// PrepareHandle registers a handler voting on the FSM transitions prepared
+// by run-ctl.
+func (srv *Server) PrepareHandle(f PrepareHandler) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.prepfcts = append(srv.prepfcts, f)
+}
+
+// AbortHandle registers a handler releasing what was acquired while
+// preparing FSM transitions that were eventually vetoed.
+func (srv *Server) AbortHandle(f AbortHandler) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.abrtfcts = append(srv.abrtfcts, f)
+}
+
+func prepareTarget(req Frame) (CmdType, error) {
+	if len(req.Body) < 2 {
+		return CmdUnknown, fmt.Errorf("missing FSM command to prepare")
+	}
+	return CmdType(req.Body[1]), nil
+}
+
+// onPrepare votes on the FSM transition prepared by run-ctl, without
+// changing the state of the process.
+func (srv *Server) onPrepare(ctx Context, req Frame) error {
+	cmd, err := prepareTarget(req)
+	if err != nil {
+		return err
+	}
+
+	srv.mu.RLock()
+	err = srv.checkTransition(cmd)
+	fcts := srv.prepfcts
+	srv.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fcts {
+		err := f(ctx, cmd)
+		if err != nil {
+			return fmt.Errorf("%s: %v vetoed: %w", srv.name, cmd, err)
+		}
+	}
+	return nil
+}
+
+// onAbort releases what was acquired while preparing a vetoed transition.
+func (srv *Server) onAbort(ctx Context, req Frame) error {
+	cmd, err := prepareTarget(req)
+	if err != nil {
+		return err
+	}
+
+	srv.mu.RLock()
+	fcts := srv.abrtfcts
+	srv.mu.RUnlock()
+
+	for _, f := range fcts {
+		f(ctx, cmd)
+	}
+	return nil
+}
+
+// prepare runs the prepare phase of the provided FSM transition: all the
+// processes supporting it vote on the transition, which is aborted on all
+// of them as soon as one process votes against it (or does not vote in
+// time).
+// Processes are then left in their current state, and run-ctl as well.
+// prepare must be called with rc.mu held.
+func (rc *RunControl) prepare(ctx context.Context, cmd CmdType) error {
+	var (
+		grp errgroup.Group
+		tr  = rc.newTransition(CmdPrepare)
+		mu  sync.Mutex
+		yes []*client
+		nos []string
+	)
+	for _, cli := range rc.clients.list() {
+		cli := cli
+		if !cli.twoPhase {
+			continue
+		}
+		grp.Go(func() error {
+			err := tr.do(cli, func() error {
+				return rc.request(ctx, cli, CmdPrepare, []byte{byte(cmd)})
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				yes = append(yes, cli)
+			default:
+				nos = append(nos, fmt.Sprintf("%s (%v)", cli.name, err))
+			}
+			return nil
+		})
+	}
+	_ = grp.Wait()
+
+	if len(nos) == 0 {
+		return nil
+	}
+	sort.Strings(nos)
+
+	rc.msg.Warnf("%v vetoed by %d processes: %q", cmd, len(nos), nos)
+	rc.replies.reset(CmdAbort)
+	var abrt errgroup.Group
+	for i := range yes {
+		cli := yes[i]
+		abrt.Go(func() error {
+			err := rc.request(ctx, cli, CmdAbort, []byte{byte(cmd)})
+			if err != nil {
+				rc.msg.Warnf("could not abort %v on %q: %+v", cmd, cli.name, err)
+			}
+			return nil
+		})
+	}
+	_ = abrt.Wait()
+
+	return fmt.Errorf("could not %v processes: %w: %q", cmd, ErrTransitionVetoed, nos)
+}
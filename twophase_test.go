@@ -0,0 +1,263 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/internal/tcputil"
+	"github.com/go-daq/tdaq/iomux"
+	"github.com/go-daq/tdaq/log"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestServerArmGo(t *testing.T) {
+	srv := New(config.Process{Name: "dev"}, ioutil.Discard)
+
+	started := make(chan struct{}, 1)
+	srv.RunHandle(func(ctx Context) error {
+		started <- struct{}{}
+		<-ctx.Ctx.Done()
+		return nil
+	})
+
+	runctx, cancel := context.WithCancel(context.Background())
+	rungrp, runctx := errgroup.WithContext(runctx)
+	srv.runctx = runctx
+	srv.rundone = cancel
+	srv.rungrp = rungrp
+	srv.setCurState(fsm.Init)
+
+	ctx := Context{Ctx: runctx, Msg: srv.msg}
+	err := srv.onStart(ctx, Frame{Type: FrameCmd, Body: []byte{byte(CmdStart), startArm}})
+	if err != nil {
+		t.Fatalf("could not arm process: %+v", err)
+	}
+	srv.setCurState(fsm.Running)
+
+	select {
+	case <-started:
+		t.Fatalf("armed process started producing before /go")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	err = srv.onGo(ctx, Frame{Type: FrameCmd, Body: []byte{byte(CmdGo)}})
+	if err != nil {
+		t.Fatalf("could not release process: %+v", err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("process did not start producing after /go")
+	}
+
+	err = srv.onGo(ctx, Frame{Type: FrameCmd, Body: []byte{byte(CmdGo)}})
+	if err == nil {
+		t.Fatalf("expected an error on a second /go")
+	}
+
+	err = srv.onStop(Context{Ctx: context.Background(), Msg: srv.msg}, Frame{})
+	if err != nil {
+		t.Fatalf("could not stop process: %+v", err)
+	}
+}
+
+func TestServerPrepare(t *testing.T) {
+	srv := New(config.Process{Name: "dev"}, ioutil.Discard)
+
+	var (
+		veto    error
+		aborted []CmdType
+	)
+	srv.PrepareHandle(func(ctx Context, cmd CmdType) error {
+		return veto
+	})
+	srv.AbortHandle(func(ctx Context, cmd CmdType) {
+		aborted = append(aborted, cmd)
+	})
+
+	ctx := Context{Ctx: context.Background(), Msg: srv.msg}
+	prepare := func(cmd CmdType) error {
+		return srv.onPrepare(ctx, Frame{Type: FrameCmd, Body: []byte{byte(CmdPrepare), byte(cmd)}})
+	}
+
+	srv.setCurState(fsm.Conf)
+	err := prepare(CmdInit)
+	if err != nil {
+		t.Fatalf("could not prepare /init: %+v", err)
+	}
+
+	err = prepare(CmdStart)
+	if err == nil {
+		t.Fatalf("expected an error preparing /start from %v", fsm.Conf)
+	}
+
+	veto = errors.New("hardware not ready")
+	err = prepare(CmdInit)
+	if err == nil || !strings.Contains(err.Error(), "hardware not ready") {
+		t.Fatalf("expected a veto on /init, got: %+v", err)
+	}
+	if got, want := srv.getCurState(), fsm.Conf; got != want {
+		t.Fatalf("invalid state after veto: got=%v, want=%v", got, want)
+	}
+
+	err = srv.onAbort(ctx, Frame{Type: FrameCmd, Body: []byte{byte(CmdAbort), byte(CmdInit)}})
+	if err != nil {
+		t.Fatalf("could not abort /init: %+v", err)
+	}
+	if got, want := aborted, []CmdType{CmdInit}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid aborted transitions:\ngot = %#v\nwant= %#v", got, want)
+	}
+}
+
+func TestRunControlMixedBarrier(t *testing.T) {
+	t.Parallel()
+
+	port, err := tcputil.GetTCPPort()
+	if err != nil {
+		t.Fatalf("could not find a tcp port for run-ctl: %+v", err)
+	}
+
+	rcAddr := ":" + port
+
+	stdout := iomux.NewWriter(new(bytes.Buffer))
+
+	fname, err := ioutil.TempFile("", "tdaq-")
+	if err != nil {
+		t.Fatalf("could not create a temporary log file for run-ctl log server: %+v", err)
+	}
+	fname.Close()
+	defer func() {
+		if t.Failed() {
+			raw, err := ioutil.ReadFile(fname.Name())
+			if err == nil {
+				t.Logf("log-file:\n%v\n", string(raw))
+			}
+		}
+		os.Remove(fname.Name())
+	}()
+
+	cfg := config.RunCtl{
+		Name:      "run-ctl",
+		Level:     log.LvlError,
+		Trans:     "tcp",
+		RunCtl:    rcAddr,
+		LogFile:   fname.Name(),
+		HBeatFreq: 50 * time.Millisecond,
+	}
+
+	rc, err := NewRunControl(cfg, stdout)
+	if err != nil {
+		t.Fatalf("could not create run-ctl: %+v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	grp, ctx := errgroup.WithContext(ctx)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- rc.Run(ctx)
+	}()
+
+	names := []string{"armed-1", "legacy-1", "armed-2", "legacy-2"}
+	started := make(chan string, len(names))
+	for _, name := range names {
+		name := name
+		grp.Go(func() error {
+			srv := New(config.Process{
+				Name:   name,
+				Level:  log.LvlError,
+				Trans:  "tcp",
+				RunCtl: rcAddr,
+			}, stdout)
+			srv.RunHandle(func(ctx Context) error {
+				started <- name
+				<-ctx.Ctx.Done()
+				return nil
+			})
+			return srv.Run(ctx)
+		})
+	}
+
+	timeout := time.NewTimer(5 * time.Second)
+	defer timeout.Stop()
+loop:
+	for {
+		select {
+		case <-timeout.C:
+			t.Fatalf("devices did not connect")
+		default:
+			n := rc.NumClients()
+			if n == len(names) {
+				break loop
+			}
+		}
+	}
+
+	// legacy processes do not support barrier-synchronized starts: they
+	// are sent a plain /start, and no /go.
+	rc.mu.Lock()
+	for _, name := range []string{"legacy-1", "legacy-2"} {
+		rc.clients.get(name).barrier = false
+	}
+	rc.mu.Unlock()
+
+	for _, cmd := range []CmdType{CmdConfig, CmdInit, CmdStart} {
+		err = rc.Do(ctx, cmd)
+		if err != nil {
+			t.Fatalf("could not run %v: %+v", cmd, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for range names {
+		select {
+		case name := <-started:
+			seen[name] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("processes did not all start producing: %v", seen)
+		}
+	}
+
+	got := rc.Replies(CmdGo).Procs
+	if len(got) != 2 {
+		t.Fatalf("invalid /go replies: %v", got)
+	}
+	for _, name := range []string{"armed-1", "armed-2"} {
+		if _, ok := got[name]; !ok {
+			t.Fatalf("no /go reply from %q: %v", name, got)
+		}
+	}
+
+	for _, cmd := range []CmdType{CmdStop, CmdQuit} {
+		err = rc.Do(ctx, cmd)
+		if err != nil {
+			t.Fatalf("could not run %v: %+v", cmd, err)
+		}
+	}
+
+	err = grp.Wait()
+	if err != nil {
+		t.Fatalf("could not run processes: %+v", err)
+	}
+
+	err = <-errc
+	if err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatalf("error shutting down run-ctl: %+v", err)
+	}
+}
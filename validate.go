@@ -0,0 +1,201 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+// TopologyError describes the problems found in a topology.
+type TopologyError struct {
+	Problems []string
+}
+
+func (err *TopologyError) Error() string {
+	switch len(err.Problems) {
+	case 1:
+		return "invalid topology: " + err.Problems[0]
+	default:
+		return fmt.Sprintf("invalid topology (%d problems):\n- %s", len(err.Problems), strings.Join(err.Problems, "\n- "))
+	}
+}
+
+// ValidateTopology checks the wiring of the end-points of the processes of
+// the provided topology, without connecting to any process.
+//
+// ValidateTopology reports duplicate processes and end-points, outputs
+// produced by more than one process, inputs without a producer, end-points
+// connected with incompatible types, cycles, and processes that cannot
+// receive data from any source process.
+// The returned error, if any, is a *TopologyError.
+func ValidateTopology(topo config.Topology) error {
+	var (
+		probs []string
+		procs = make(map[string]*config.ProcTopology, len(topo.Procs))
+		prods = make(map[string]string)              // output end-point -> producer
+		types = make(map[string]string)              // output end-point -> type
+		deps  = make(map[string]map[string]struct{}) // process -> processes it consumes from
+		names = make([]string, 0, len(topo.Procs))   // process names, in topology order
+		errf  = func(format string, args ...interface{}) { probs = append(probs, fmt.Sprintf(format, args...)) }
+	)
+
+	for i := range topo.Procs {
+		p := &topo.Procs[i]
+		switch {
+		case p.Name == "":
+			errf("process #%d has no name", i)
+			continue
+		case procs[p.Name] != nil:
+			errf("duplicate process %q", p.Name)
+			continue
+		}
+		procs[p.Name] = p
+		names = append(names, p.Name)
+
+		for _, eps := range [][]config.EndPointTopology{p.Inputs, p.Outputs} {
+			seen := make(map[string]struct{}, len(eps))
+			for _, ep := range eps {
+				if _, dup := seen[ep.Name]; dup {
+					errf("process %q declares end-point %q more than once", p.Name, ep.Name)
+				}
+				seen[ep.Name] = struct{}{}
+			}
+		}
+
+		for _, ep := range p.Outputs {
+			if prod, dup := prods[ep.Name]; dup && prod != p.Name {
+				errf("output %q produced by both %q and %q", ep.Name, prod, p.Name)
+				continue
+			}
+			prods[ep.Name] = p.Name
+			types[ep.Name] = ep.Type
+		}
+	}
+
+	for _, name := range names {
+		p := procs[name]
+		deps[name] = make(map[string]struct{})
+		for _, ep := range p.Inputs {
+			prod, ok := prods[ep.Name]
+			if !ok {
+				errf("input %q of %q has no producer", ep.Name, name)
+				continue
+			}
+			if typ := types[ep.Name]; typ != "" && ep.Type != "" && typ != ep.Type {
+				errf("input %q of %q has type %q, but %q produces type %q", ep.Name, name, ep.Type, prod, typ)
+			}
+			deps[name][prod] = struct{}{}
+		}
+	}
+
+	for _, cycle := range cycles(names, deps) {
+		errf("cycle detected: %s", strings.Join(cycle, " -> "))
+	}
+
+	// processes are reachable if they are sources, or if all their inputs
+	// are produced by reachable processes.
+	reachable := make(map[string]bool, len(names))
+	for changed := true; changed; {
+		changed = false
+		for _, name := range names {
+			if reachable[name] {
+				continue
+			}
+			ok := true
+			for _, ep := range procs[name].Inputs {
+				prod, found := prods[ep.Name]
+				if !found || !reachable[prod] {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				reachable[name] = true
+				changed = true
+			}
+		}
+	}
+	for _, name := range names {
+		if !reachable[name] {
+			errf("process %q is unreachable from any data source", name)
+		}
+	}
+
+	if len(probs) > 0 {
+		return &TopologyError{Problems: probs}
+	}
+	return nil
+}
+
+// cycles returns the cycles of the dependency graph of the processes.
+func cycles(names []string, deps map[string]map[string]struct{}) [][]string {
+	const (
+		unseen = iota
+		active
+		done
+	)
+	var (
+		cycles [][]string
+		state  = make(map[string]int, len(names))
+		stack  []string
+		visit  func(name string)
+	)
+	visit = func(name string) {
+		state[name] = active
+		stack = append(stack, name)
+
+		next := make([]string, 0, len(deps[name]))
+		for dep := range deps[name] {
+			next = append(next, dep)
+		}
+		sort.Strings(next)
+
+		for _, dep := range next {
+			switch state[dep] {
+			case unseen:
+				visit(dep)
+			case active:
+				i := len(stack) - 1
+				for stack[i] != dep {
+					i--
+				}
+				cycle := make([]string, 0, len(stack)-i+1)
+				// report cycles in data-flow order.
+				for j := len(stack) - 1; j >= i; j-- {
+					cycle = append(cycle, stack[j])
+				}
+				cycle = append(cycle, name)
+				cycles = append(cycles, cycle)
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+
+	for _, name := range names {
+		if state[name] == unseen {
+			visit(name)
+		}
+	}
+	return cycles
+}
+
+// Validate loads the topology file of run-ctl and checks the wiring of its
+// processes, without connecting to any process.
+func (rc *RunControl) Validate() error {
+	if rc.cfg.Topology == "" {
+		return fmt.Errorf("no topology file to validate")
+	}
+	topo, err := config.LoadTopology(rc.cfg.Topology)
+	if err != nil {
+		return err
+	}
+	return ValidateTopology(topo)
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-daq/tdaq/config"
+)
+
+func TestValidateTopology(t *testing.T) {
+	type (
+		P  = config.ProcTopology
+		EP = config.EndPointTopology
+	)
+
+	for _, tc := range []struct {
+		name  string
+		procs []P
+		want  []string
+	}{
+		{
+			name: "valid",
+			procs: []P{
+				{Name: "gen", Outputs: []EP{{Name: "/adc", Type: "adc"}}},
+				{Name: "proc", Inputs: []EP{{Name: "/adc", Type: "adc"}}, Outputs: []EP{{Name: "/hits"}}},
+				{Name: "dump", Inputs: []EP{{Name: "/adc"}, {Name: "/hits"}}},
+			},
+		},
+		{
+			name: "duplicates",
+			procs: []P{
+				{Name: "gen", Outputs: []EP{{Name: "/adc"}, {Name: "/adc"}}},
+				{Name: "gen"},
+				{Name: "gen2", Outputs: []EP{{Name: "/adc"}}},
+			},
+			want: []string{
+				`process "gen" declares end-point "/adc" more than once`,
+				`duplicate process "gen"`,
+				`output "/adc" produced by both "gen" and "gen2"`,
+			},
+		},
+		{
+			name: "missing-producer",
+			procs: []P{
+				{Name: "dump", Inputs: []EP{{Name: "/adc"}}},
+			},
+			want: []string{
+				`input "/adc" of "dump" has no producer`,
+				`process "dump" is unreachable from any data source`,
+			},
+		},
+		{
+			name: "type-mismatch",
+			procs: []P{
+				{Name: "gen", Outputs: []EP{{Name: "/adc", Type: "adc"}}},
+				{Name: "dump", Inputs: []EP{{Name: "/adc", Type: "tdc"}}},
+			},
+			want: []string{
+				`input "/adc" of "dump" has type "tdc", but "gen" produces type "adc"`,
+			},
+		},
+		{
+			name: "cycle",
+			procs: []P{
+				{Name: "gen", Outputs: []EP{{Name: "/adc"}}},
+				{Name: "p1", Inputs: []EP{{Name: "/adc"}, {Name: "/p2"}}, Outputs: []EP{{Name: "/p1"}}},
+				{Name: "p2", Inputs: []EP{{Name: "/p1"}}, Outputs: []EP{{Name: "/p2"}}},
+			},
+			want: []string{
+				`cycle detected: p2 -> p1 -> p2`,
+				`process "p1" is unreachable from any data source`,
+				`process "p2" is unreachable from any data source`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateTopology(config.Topology{Procs: tc.procs})
+			if tc.want == nil {
+				if err != nil {
+					t.Fatalf("could not validate topology: %+v", err)
+				}
+				return
+			}
+
+			var terr *TopologyError
+			if !errors.As(err, &terr) {
+				t.Fatalf("invalid error: %+v", err)
+			}
+			if got, want := terr.Problems, tc.want; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid problems:\ngot = %#v\nwant= %#v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-daq/tdaq/fsm"
+)
+
+// watchdogAlarm is the prefix of the identifiers of the alarms raised by
+// the run-ctl watchdog.
+const watchdogAlarm = "watchdog/"
+
+// lostHBeats is the number of heartbeat periods processes reported lost
+// are given to reply to a command, without watchdog.
+const lostHBeats = 4
+
+// watch records the liveness of a running client, as seen by the run-ctl
+// watchdog.
+type watch struct {
+	beat    time.Time            // time of the last heartbeat reply
+	stalled map[string]time.Time // time at which each stalled data link was first seen stalled, by address
+}
+
+// reset resets the liveness of the client, at the start of a run.
+func (w *watch) reset(now time.Time) {
+	w.beat = now
+	w.stalled = make(map[string]time.Time)
+}
+
+// links records the stalled data links of the client.
+func (w *watch) links(now time.Time, links []LinkStatus) {
+	if w.stalled == nil {
+		w.stalled = make(map[string]time.Time)
+	}
+	seen := make(map[string]struct{}, len(links))
+	for _, link := range links {
+		if !link.Stalled {
+			continue
+		}
+		seen[link.Addr] = struct{}{}
+		if _, ok := w.stalled[link.Addr]; !ok {
+			w.stalled[link.Addr] = now
+		}
+	}
+	for addr := range w.stalled {
+		if _, ok := seen[addr]; !ok {
+			delete(w.stalled, addr)
+		}
+	}
+}
+
+// check returns why the client is deemed unresponsive, if it missed
+// heartbeats or had a stalled data link for longer than timeout.
+func (w *watch) check(now time.Time, timeout time.Duration) (string, bool) {
+	if dt := now.Sub(w.beat); dt > timeout {
+		return fmt.Sprintf("no heartbeat reply for %v", dt.Round(time.Millisecond)), true
+	}
+	for addr, beg := range w.stalled {
+		if dt := now.Sub(beg); dt > timeout {
+			return fmt.Sprintf("no data from %q for %v", addr, dt.Round(time.Millisecond)), true
+		}
+	}
+	return "", false
+}
+
+func (cli *client) resetWatch(now time.Time) {
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	cli.watch.reset(now)
+}
+
+func (cli *client) checkWatch(now time.Time, timeout time.Duration) (string, bool) {
+	cli.mu.RLock()
+	defer cli.mu.RUnlock()
+	return cli.watch.check(now, timeout)
+}
+
+// lostTimeout returns the maximum duration of the exchanges of commands
+// with processes reported lost: the watchdog timeout or, without watchdog,
+// a few heartbeat periods.
+func (rc *RunControl) lostTimeout() time.Duration {
+	if rc.cfg.Watchdog > 0 {
+		return rc.cfg.Watchdog
+	}
+	return lostHBeats * rc.cfg.HBeatFreq
+}
+
+// watchdog stops the current run whenever a running process misses
+// heartbeats or stops receiving data for longer than the configured
+// watchdog timeout, raising a critical alarm for that process.
+func (rc *RunControl) watchdog(ctx context.Context) {
+	if rc.cfg.Watchdog <= 0 {
+		return
+	}
+
+	tck := rc.newTicker(rc.cfg.HBeatFreq)
+	defer tck.Stop()
+
+	for {
+		select {
+		case <-rc.quit:
+			return
+		case <-ctx.Done():
+			return
+		case <-tck.C():
+			if !rc.checkWatchdog(rc.now()) {
+				continue
+			}
+			// the stop is issued by run-ctl itself: it is not subject to
+			// the control token, but it is audited and recorded as any
+			// other /stop.
+			err := rc.Do(ctx, CmdStop)
+			if err != nil {
+				rc.msg.Errorf("watchdog could not stop run: %+v", err)
+			}
+		}
+	}
+}
+
+// checkWatchdog returns whether some running process is unresponsive,
+// raising alarms for all unresponsive processes.
+func (rc *RunControl) checkWatchdog(now time.Time) bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if rc.status != fsm.Running {
+		return false
+	}
+
+	dead := false
+	for _, name := range rc.deps {
+		cli := rc.clients.get(name)
+		if cli == nil {
+			continue
+		}
+		why, bad := cli.checkWatch(now, rc.cfg.Watchdog)
+		if !bad {
+			continue
+		}
+		dead = true
+		cli.setLost("unresponsive: " + why)
+		rc.msg.Errorf("watchdog: process %q unresponsive (%s): stopping run %d", name, why, rc.runNbr)
+		rc.alerts.raise(AlertError, rc.cfg.Name, "process %q unresponsive: run %d stopped by watchdog", name, rc.runNbr)
+		err := rc.alarms.update(AlarmFrame{
+			Name:     rc.cfg.Name,
+			Alarm:    watchdogAlarm + name,
+			Severity: SevCritical,
+			Active:   true,
+			Text:     fmt.Sprintf("process %q unresponsive during run %d: %s", name, rc.runNbr, why),
+		})
+		if err != nil {
+			rc.msg.Errorf("could not raise watchdog alarm for %q: %+v", name, err)
+		}
+	}
+	return dead
+}
+
+// resetWatchdog resets the liveness of all processes and clears the alarms
+// of the watchdog, at the start of a run.
+// resetWatchdog must be called with rc.mu held.
+func (rc *RunControl) resetWatchdog(now time.Time) {
+	for _, name := range rc.deps {
+		if cli := rc.clients.get(name); cli != nil {
+			cli.resetWatch(now)
+		}
+		err := rc.alarms.update(AlarmFrame{Name: rc.cfg.Name, Alarm: watchdogAlarm + name})
+		if err != nil {
+			rc.msg.Errorf("could not clear watchdog alarm for %q: %+v", name, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tdaq // import "github.com/go-daq/tdaq"
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-daq/tdaq/config"
+	"github.com/go-daq/tdaq/fsm"
+	"github.com/go-daq/tdaq/internal/tcputil"
+	"github.com/go-daq/tdaq/iomux"
+	"github.com/go-daq/tdaq/log"
+)
+
+func TestWatchdog(t *testing.T) {
+	var (
+		t0  = time.Date(2020, 1, 2, 3, 4, 0, 0, time.UTC)
+		msg = log.NewMsgStream("run-ctl", log.LvlError+1, ioutil.Discard)
+	)
+
+	alarms, err := newAlarmDB("", nil)
+	if err != nil {
+		t.Fatalf("could not create alarm db: %+v", err)
+	}
+
+	rc := &RunControl{
+		cfg:    config.RunCtl{Name: "run-ctl", Watchdog: 10 * time.Second},
+		msg:    msg,
+		status: fsm.Running,
+		clients: newClientDB(
+			&client{name: "gen"},
+			&client{name: "dump"},
+		),
+		deps:   []string{"gen", "dump"},
+		alarms: alarms,
+		runNbr: 42,
+	}
+	rc.resetWatchdog(t0)
+
+	if rc.checkWatchdog(t0.Add(5 * time.Second)) {
+		t.Fatalf("watchdog fired too early")
+	}
+
+	// gen keeps beating, dump has a stalled link.
+	rc.clients.get("gen").watch.beat = t0.Add(8 * time.Second)
+	rc.clients.get("dump").watch.beat = t0.Add(8 * time.Second)
+	rc.clients.get("dump").watch.links(t0.Add(2*time.Second), []LinkStatus{
+		{Addr: "tcp://gen:1234", Stalled: true},
+		{Addr: "tcp://gen:1235"},
+	})
+
+	if rc.checkWatchdog(t0.Add(11 * time.Second)) {
+		t.Fatalf("watchdog fired too early")
+	}
+
+	rc.clients.get("gen").watch.beat = t0.Add(12 * time.Second)
+	if !rc.checkWatchdog(t0.Add(13 * time.Second)) {
+		t.Fatalf("watchdog did not fire on stalled link")
+	}
+
+	got := alarms.list()
+	if len(got) != 1 || got[0].ID != "watchdog/dump" || got[0].Severity != SevCritical || !got[0].Active {
+		t.Fatalf("invalid watchdog alarms: %#v", got)
+	}
+
+	rc.status = fsm.Stopped
+	if rc.checkWatchdog(t0.Add(time.Hour)) {
+		t.Fatalf("watchdog fired outside of a run")
+	}
+
+	// new run clears the watchdog alarms (which remain until acknowledged)
+	// and resets the liveness of processes.
+	rc.status = fsm.Running
+	rc.resetWatchdog(t0.Add(time.Hour))
+	if got := alarms.list(); len(got) != 1 || got[0].Active {
+		t.Fatalf("invalid watchdog alarms after reset: %#v", got)
+	}
+	if rc.checkWatchdog(t0.Add(time.Hour + time.Second)) {
+		t.Fatalf("watchdog fired after reset")
+	}
+	if !rc.checkWatchdog(t0.Add(time.Hour + 11*time.Second)) {
+		t.Fatalf("watchdog did not fire on missed heartbeats")
+	}
+}
+
+func TestWatchdogUnresponsive(t *testing.T) {
+	t.Parallel()
+
+	port, err := tcputil.GetTCPPort()
+	if err != nil {
+		t.Fatalf("could not find a tcp port for run-ctl: %+v", err)
+	}
+
+	rcAddr := ":" + port
+
+	stdout := iomux.NewWriter(new(bytes.Buffer))
+
+	fname, err := ioutil.TempFile("", "tdaq-")
+	if err != nil {
+		t.Fatalf("could not create a temporary log file for run-ctl log server: %+v", err)
+	}
+	fname.Close()
+	defer func() {
+		if t.Failed() {
+			raw, err := ioutil.ReadFile(fname.Name())
+			if err == nil {
+				t.Logf("log-file:\n%v\n", string(raw))
+			}
+		}
+		os.Remove(fname.Name())
+	}()
+
+	cfg := config.RunCtl{
+		Name:      "run-ctl",
+		Level:     log.LvlError,
+		Trans:     "tcp",
+		RunCtl:    rcAddr,
+		LogFile:   fname.Name(),
+		HBeatFreq: 50 * time.Millisecond,
+		Watchdog:  500 * time.Millisecond,
+	}
+
+	rc, err := NewRunControl(cfg, stdout)
+	if err != nil {
+		t.Fatalf("could not create run-ctl: %+v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- rc.Run(ctx)
+	}()
+
+	srvs := make(map[string]*Server)
+	for _, name := range []string{"alive", "dead"} {
+		srv := New(config.Process{
+			Name:   name,
+			Level:  log.LvlError,
+			Trans:  "tcp",
+			RunCtl: rcAddr,
+		}, stdout)
+		srvs[name] = srv
+		go func() { _ = srv.Run(ctx) }()
+	}
+
+	timeout := time.NewTimer(5 * time.Second)
+	defer timeout.Stop()
+loop:
+	for {
+		select {
+		case <-timeout.C:
+			t.Fatalf("devices did not connect")
+		default:
+			n := rc.NumClients()
+			if n == 2 {
+				break loop
+			}
+		}
+	}
+
+	for _, cmd := range []CmdType{CmdConfig, CmdInit, CmdStart} {
+		err = rc.Do(ctx, cmd)
+		if err != nil {
+			t.Fatalf("could not run %v: %+v", cmd, err)
+		}
+	}
+
+	// the dead process stops answering heartbeats and commands.
+	dead := srvs["dead"]
+	dead.mu.Lock()
+
+	var stop []AuditEntry
+	timeout.Reset(10 * time.Second)
+wait:
+	for {
+		select {
+		case <-timeout.C:
+			dead.mu.Unlock()
+			t.Fatalf("watchdog did not stop the run")
+		case <-time.After(50 * time.Millisecond):
+			stop = rc.Audit(AuditQuery{Cmd: "/stop"})
+			if len(stop) != 0 {
+				break wait
+			}
+		}
+	}
+	dead.mu.Unlock()
+
+	if got, want := stop[0].Operator, "run-ctl"; got != want {
+		t.Fatalf("invalid /stop operator: got=%q, want=%q", got, want)
+	}
+	if stop[0].Err == "" {
+		t.Fatalf("expected /stop to fail on the unresponsive process")
+	}
+
+	rc.mu.RLock()
+	status := rc.status
+	rc.mu.RUnlock()
+	if status == fsm.Running {
+		t.Fatalf("run not stopped by watchdog")
+	}
+
+	alarms := rc.alarms.list()
+	if len(alarms) != 1 || alarms[0].ID != watchdogAlarm+"dead" || !alarms[0].Active {
+		t.Fatalf("invalid watchdog alarms: %#v", alarms)
+	}
+
+	cancel()
+	select {
+	case <-errc:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run-ctl did not exit")
+	}
+}
@@ -6,12 +6,12 @@ package tdaq // import "github.com/go-daq/tdaq"
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"net"
 	"net/http"
-	"sort"
 	"time"
 
 	"golang.org/x/net/websocket"
@@ -22,16 +22,99 @@ type websrv interface {
 	Shutdown(context.Context) error
 }
 
+// webMux returns the handler of the run-ctl web server.
+// The handler of an observer web server only serves the read-only
+// monitoring pages, status and log streams: run-ctl can not be controlled
+// from it.
+func (rc *RunControl) webMux(observer bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", rc.webHome)
+	mux.HandleFunc("/cmd", rc.webCmd)
+	mux.Handle("/status", websocket.Handler(rc.webStatus))
+	mux.Handle("/msg", websocket.Handler(rc.webMsg))
+	mux.Handle("/feed", websocket.Handler(rc.webFeed))
+	mux.Handle("/rpc", websocket.Handler(rc.webRPC))
+	mux.HandleFunc("/api/status", rc.webAPIStatus)
+	mux.HandleFunc("/api/comment", rc.webAPIComment)
+	mux.HandleFunc("/api/alarms", rc.webAPIAlarms)
+	mux.HandleFunc("/api/alarms/ack", rc.webAPIAckAlarm)
+	mux.HandleFunc("/api/graph", rc.webAPIGraph)
+	mux.HandleFunc("/api/replies", rc.webAPIReplies)
+	mux.HandleFunc("/api/kv", rc.webAPIKV)
+	mux.HandleFunc("/api/kv/history", rc.webAPIKVHistory)
+	mux.HandleFunc("/api/snapshot", rc.webAPISnapshot)
+	mux.HandleFunc("/api/log", rc.webAPILog)
+	mux.HandleFunc("/api/audit", rc.webAPIAudit)
+	mux.HandleFunc("/api/control", rc.webAPIControl)
+	mux.HandleFunc("/api/graphql", rc.webAPIGraphQL)
+	if !observer {
+		return mux
+	}
+
+	obs := http.NewServeMux()
+	obs.HandleFunc("/", rc.webObserverHome)
+	obs.HandleFunc("/cmd", webReadOnly)
+	obs.HandleFunc("/api/", webReadOnly)
+	for _, path := range observerAPI {
+		obs.Handle(path, readOnly(mux))
+	}
+	obs.Handle("/api/graphql", mux) // queries are read-only.
+	obs.Handle("/status", mux)
+	obs.Handle("/msg", mux)
+	obs.Handle("/feed", mux)
+	return obs
+}
+
+// observerAPI lists the end-points of the web API served by observer web
+// servers, for GET and HEAD requests only.
+// End-points acting on the TDAQ processes, even for GET requests (e.g.
+// /api/snapshot), are left out.
+var observerAPI = []string{
+	"/api/status",
+	"/api/alarms",
+	"/api/graph",
+	"/api/replies",
+	"/api/kv",
+	"/api/kv/history",
+	"/api/log",
+	"/api/audit",
+	"/api/control",
+}
+
+// readOnly forbids the requests of h other than GET and HEAD.
+func readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.ServeHTTP(w, r)
+		default:
+			webReadOnly(w, r)
+		}
+	})
+}
+
+func webReadOnly(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "read-only observer: run-ctl can not be controlled", http.StatusForbidden)
+}
+
 func (rc *RunControl) serveWeb(ctx context.Context) {
-	if rc.web == nil {
+	rc.serveHTTP(ctx, rc.web, "web run-ctl server", rc.cfg.Web)
+}
+
+func (rc *RunControl) serveObserver(ctx context.Context) {
+	rc.serveHTTP(ctx, rc.obs, "observer web run-ctl server", rc.cfg.Observer)
+}
+
+func (rc *RunControl) serveHTTP(ctx context.Context, srv websrv, name, addr string) {
+	if srv == nil {
 		return
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	rc.msg.Infof("starting web run-ctl server on %q...", rc.cfg.Web)
+	rc.msg.Infof("starting %s on %q...", name, addr)
 
-	err := rc.web.ListenAndServe()
+	err := srv.ListenAndServe()
 	if err != nil {
 		if errors.Is(err, http.ErrServerClosed) {
 			select {
@@ -44,11 +127,21 @@ func (rc *RunControl) serveWeb(ctx context.Context) {
 			default:
 			}
 		}
-		rc.msg.Errorf("error running run-ctl web server: %+v", err)
+		rc.msg.Errorf("error running %s: %+v", name, err)
 	}
 }
 
 func (rc *RunControl) webHome(w http.ResponseWriter, r *http.Request) {
+	rc.serveHome(w, false)
+}
+
+// webObserverHome serves the home page of the observer web server, without
+// the command buttons.
+func (rc *RunControl) webObserverHome(w http.ResponseWriter, r *http.Request) {
+	rc.serveHome(w, true)
+}
+
+func (rc *RunControl) serveHome(w http.ResponseWriter, observer bool) {
 	t, err := template.New("tdaq-home").Parse(webHomePage)
 	if err != nil {
 		rc.msg.Errorf("error parsing web home-page: %+v", err)
@@ -56,7 +149,7 @@ func (rc *RunControl) webHome(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = t.Execute(w, nil)
+	err = t.Execute(w, struct{ Observer bool }{observer})
 	if err != nil {
 		rc.msg.Errorf("error executing web home-page template: %+v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -72,24 +165,28 @@ func (rc *RunControl) webCmd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
+	ctx := webControl(r)
 
 	cmd := r.PostFormValue("cmd")
 	switch cmd {
 	case "/config":
-		err = rc.doConfig(ctx)
+		err = rc.Do(ctx, CmdConfig)
 	case "/init":
-		err = rc.doInit(ctx)
+		err = rc.Do(ctx, CmdInit)
 	case "/start":
-		err = rc.doStart(ctx)
+		err = rc.Do(ctx, CmdStart)
 	case "/stop":
-		err = rc.doStop(ctx)
+		err = rc.Do(ctx, CmdStop)
+	case "/pause":
+		err = rc.Do(ctx, CmdPause)
+	case "/resume":
+		err = rc.Do(ctx, CmdResume)
 	case "/reset":
-		err = rc.doReset(ctx)
+		err = rc.Do(ctx, CmdReset)
 	case "/quit":
-		err = rc.doQuit(ctx)
+		err = rc.Do(ctx, CmdQuit)
 	case "/status":
-		err = rc.doStatus(ctx)
+		err = rc.Do(ctx, CmdStatus)
 	default:
 		rc.msg.Errorf("received invalid cmd %q over web-gui", cmd)
 		err = fmt.Errorf("received invalid cmd %q", cmd)
@@ -97,15 +194,127 @@ func (rc *RunControl) webCmd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setWebControl(ctx, w, r)
 	if err != nil {
 		rc.msg.Errorf("could not run cmd %q: %+v", cmd, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), webControlStatus(err, http.StatusInternalServerError))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// webAPIStatus replies with a JSON report of the current status of the
+// run-ctl and of its processes.
+func (rc *RunControl) webAPIStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rc.mu.RLock()
+	report := rc.statusReport()
+	rc.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		rc.msg.Errorf("could not encode status report: %+v", err)
+		return
+	}
+}
+
+// webAPIComment posts the operator comment of the "text" form value,
+// signed by the "author" form value, to the electronic logbooks.
+// Comments do not act on the TDAQ partition: like in a paper logbook, any
+// operator may post them, whether it holds the control token or not.
+func (rc *RunControl) webAPIComment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := rc.Comment(r.FormValue("author"), r.FormValue("text"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// webAPIAlarms replies with a JSON report of the alarms tracked by run-ctl.
+func (rc *RunControl) webAPIAlarms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(rc.Alarms())
+	if err != nil {
+		rc.msg.Errorf("could not encode alarms: %+v", err)
+		return
+	}
+}
+
+// webAPIAckAlarm acknowledges the alarm of the "id" form value raised by
+// the process of the "proc" form value, on behalf of the "user" form value.
+// Like control commands, acknowledgements are only allowed to the control
+// session holding the control token (see ControlToken).
+func (rc *RunControl) webAPIAckAlarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var (
+		ctx  = webControl(r)
+		proc = r.FormValue("proc")
+		id   = r.FormValue("id")
+	)
+	err := rc.control(ctx, auditAck, []string{proc}, []string{id})
+	if err == nil {
+		err = rc.AckAlarm(proc, id, r.FormValue("user"))
+	}
+	setWebControl(ctx, w, r)
+	if err != nil {
+		http.Error(w, err.Error(), webControlStatus(err, http.StatusNotFound))
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// webAPIGraph replies with the dataflow graph of the processes, as JSON
+// or, with the "format=dot" query value, in the Graphviz DOT format.
+func (rc *RunControl) webAPIGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var (
+		graph = rc.Graph()
+		err   error
+	)
+	switch format := r.FormValue("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(graph)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		err = graph.WriteDOT(w)
+	default:
+		http.Error(w, fmt.Sprintf("invalid graph format %q", format), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		rc.msg.Errorf("could not encode dataflow graph: %+v", err)
+		return
+	}
+}
+
 func (rc *RunControl) webStatus(ws *websocket.Conn) {
 	defer ws.Close()
 
@@ -125,27 +334,9 @@ func (rc *RunControl) webStatus(ws *websocket.Conn) {
 		case <-rc.quit:
 			return
 		case <-tick.C:
-			var data struct {
-				Status string `json:"status"`
-				Procs  []struct {
-					Name   string `json:"name"`
-					Status string `json:"status"`
-				} `json:"procs"`
-				Timestamp string `json:"timestamp"`
-			}
 			rc.mu.RLock()
-			data.Status = rc.status.String()
-			data.Timestamp = time.Now().UTC().Format("2006-01-02 15:04:05") + " (UTC)"
-			for _, proc := range rc.clients {
-				data.Procs = append(data.Procs, struct {
-					Name   string `json:"name"`
-					Status string `json:"status"`
-				}{proc.name, proc.getStatus().String()})
-			}
+			data := rc.statusReport()
 			rc.mu.RUnlock()
-			sort.Slice(data.Procs, func(i, j int) bool {
-				return data.Procs[i].Name < data.Procs[j].Name
-			})
 			err := websocket.JSON.Send(ws, data)
 			if err != nil {
 				rc.msg.Errorf("could not send /status report to websocket client: %+v", err)
@@ -161,22 +352,18 @@ func (rc *RunControl) webStatus(ws *websocket.Conn) {
 func (rc *RunControl) webMsg(ws *websocket.Conn) {
 	defer ws.Close()
 
+	evts := rc.feed.sub()
+	defer rc.feed.unsub(evts)
+
 	for {
 		select {
 		case <-rc.quit:
 			return
-		case msg := <-rc.msgch:
-			var data struct {
-				Name      string `json:"name"`
-				Level     string `json:"level"`
-				Msg       string `json:"msg"`
-				Timestamp string `json:"timestamp"`
+		case evt := <-evts:
+			if evt.Type != "log" {
+				continue
 			}
-			data.Name = msg.Name
-			data.Level = msg.Level.String()
-			data.Msg = msg.Msg
-			data.Timestamp = time.Now().UTC().Format("2006-01-02 15:04:05") + " (UTC)"
-			err := websocket.JSON.Send(ws, data)
+			err := websocket.JSON.Send(ws, evt.Data)
 			if err != nil {
 				rc.msg.Errorf("could not send /msg report to websocket client: %+v", err)
 				var nerr net.Error
@@ -188,6 +375,45 @@ func (rc *RunControl) webMsg(ws *websocket.Conn) {
 	}
 }
 
+// webFeed pushes status changes, log messages and monitoring variables
+// updates to the websocket client, as they happen.
+func (rc *RunControl) webFeed(ws *websocket.Conn) {
+	defer ws.Close()
+
+	evts := rc.feed.sub()
+	defer rc.feed.unsub(evts)
+
+	rc.mu.RLock()
+	report := rc.statusReport()
+	rc.mu.RUnlock()
+
+	err := websocket.JSON.Send(ws, feedEvent{
+		Type:      "status",
+		Timestamp: report.Timestamp,
+		Data:      report,
+	})
+	if err != nil {
+		rc.msg.Errorf("could not send /feed snapshot to websocket client: %+v", err)
+		return
+	}
+
+	for {
+		select {
+		case <-rc.quit:
+			return
+		case evt := <-evts:
+			err := websocket.JSON.Send(ws, evt)
+			if err != nil {
+				rc.msg.Errorf("could not send /feed event to websocket client: %+v", err)
+				var nerr net.Error
+				if errors.As(err, &nerr); nerr != nil && !nerr.Temporary() {
+					return
+				}
+			}
+		}
+	}
+}
+
 const webHomePage = `<html>
 <head>
     <title>TDAQ RunControl</title>
@@ -252,24 +478,35 @@ const webHomePage = `<html>
 <script type="text/javascript">
 	"use strict"
 	
-	var statusChan = null;
-	var msgChan    = null;
+	var feedChan = null;
+	var procs    = {};
+	var mons     = {};
+	var devices  = null;
 
 	window.onload = function() {
-		statusChan = new WebSocket("ws://"+location.host+"/status");
-		
-		statusChan.onmessage = function(event) {
-			var data = JSON.parse(event.data);
-			//console.log("data: "+JSON.stringify(data));
-			updateStatus(data);
-		};
-
-		msgChan = new WebSocket("ws://"+location.host+"/msg");
-		
-		msgChan.onmessage = function(event) {
-			var data = JSON.parse(event.data);
-			//console.log("data: "+JSON.stringify(data));
-			updateMsg(data);
+		feedChan = new WebSocket("ws://"+location.host+"/feed");
+
+		feedChan.onmessage = function(event) {
+			var evt = JSON.parse(event.data);
+			//console.log("data: "+JSON.stringify(evt));
+			switch (evt.type) {
+			case "status":
+				updateStatus(evt.data);
+				break;
+			case "proc":
+				updateProc(evt.data, evt.timestamp);
+				break;
+			case "devices":
+				devices = evt.data;
+				renderProcs();
+				break;
+			case "log":
+				updateMsg(evt.data);
+				break;
+			case "mon":
+				updateMon(evt.data);
+				break;
+			}
 		};
 	};
 
@@ -277,16 +514,61 @@ const webHomePage = `<html>
 		document.getElementById("rc-status").innerHTML = data.status;
 		document.getElementById("rc-status-update").innerHTML = data.timestamp;
 
-		var procs = document.getElementById("rc-procs-status");
-		procs.innerHTML = "";
+		procs = {};
 		if (data.procs != null) {
 			data.procs.forEach(function(value) {
-				var node = document.createElement("tr");
-				node.innerHTML = "<th class=\"msg-log\">" + value.name +":</th>" +
-					"<th class=\"msg-log\">"+value.status+"</th>";
-				procs.appendChild(node);
+				procs[value.name] = value;
 			});
 		}
+		devices = data.devices || null;
+		renderProcs();
+	};
+
+	function updateProc(data, timestamp) {
+		procs[data.name] = data;
+		document.getElementById("rc-status-update").innerHTML = timestamp;
+		renderProcs();
+	};
+
+	function renderProcs() {
+		var table = document.getElementById("rc-procs-status");
+		table.innerHTML = "";
+		Object.keys(procs).sort().forEach(function(name) {
+			var proc = procs[name];
+			var status = proc.status;
+			if (proc.slow) {
+				status += " <span style=\"color:#F44336\">(slow consumer)</span>";
+			}
+			if (devices != null && devices.unexpected.indexOf(name) >= 0) {
+				status += " <span style=\"color:#FF9800\">(unexpected)</span>";
+			}
+			var node = document.createElement("tr");
+			node.innerHTML = "<th class=\"msg-log\">" + name +":</th>" +
+				"<th class=\"msg-log\">"+status+"</th>";
+			table.appendChild(node);
+		});
+		if (devices == null) {
+			return;
+		}
+		devices.missing.forEach(function(name) {
+			var node = document.createElement("tr");
+			node.innerHTML = "<th class=\"msg-log\">" + name +":</th>" +
+				"<th class=\"msg-log\"><span style=\"color:#F44336\">missing</span></th>";
+			table.appendChild(node);
+		});
+	};
+
+	function updateMon(data) {
+		mons[data.name+":"+data.var] = data;
+		var table = document.getElementById("rc-procs-mon");
+		table.innerHTML = "";
+		Object.keys(mons).sort().forEach(function(key) {
+			var mon = mons[key];
+			var node = document.createElement("tr");
+			node.innerHTML = "<th class=\"msg-log\">" + mon.name + ":" + mon.var + "</th>" +
+				"<th class=\"msg-log\">"+mon.value+"</th>";
+			table.appendChild(node);
+		});
 	};
 
 	function updateMsg(data) {
@@ -298,6 +580,8 @@ const webHomePage = `<html>
 	function cmdInit()   { sendCmd("/init"); };
 	function cmdStart()  { sendCmd("/start"); };
 	function cmdStop()   { sendCmd("/stop"); };
+	function cmdPause()  { sendCmd("/pause"); };
+	function cmdResume() { sendCmd("/resume"); };
 	function cmdReset()  { sendCmd("/reset"); };
 
 	function cmdQuit()   { sendCmd("/quit"); }; // FIXME(sbinet): add confirmation dialog
@@ -343,11 +627,17 @@ const webHomePage = `<html>
 		</div>
 		<br>
 
+		{{if .Observer -}}
+		<div class="msg-log">read-only observer</div>
+		{{- else -}}
 		<input type="button" onclick="cmdConfig()" value="Config">
 		<input type="button" onclick="cmdInit()"   value="Init">
 		<input type="button" onclick="cmdStart()"  value="Start">
 		<input type="button" onclick="cmdStop()"   value="Stop">
+		<input type="button" onclick="cmdPause()"  value="Pause">
+		<input type="button" onclick="cmdResume()" value="Resume">
 		<input type="button" onclick="cmdReset()"  value="Reset">
+		{{- end}}
 
 		<br>
 		<br>
@@ -361,8 +651,19 @@ const webHomePage = `<html>
 		</div>
 		<br>
 
+		<div>
+			<h4> Monitoring:</h4>
+			<table>
+				<tbody id="rc-procs-mon">
+				</tbody>
+			</table>
+		</div>
+		<br>
+
+		{{if not .Observer -}}
 		<input type="button" onclick="cmdQuit()"  value="Quit">
 		<br>
+		{{- end}}
 
 		<span>---</span>
 		Last status update:<br><span id="rc-status-update" class="msg-log">N/A</span><br>
@@ -14,8 +14,11 @@ import (
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -23,8 +26,9 @@ import (
 	"github.com/go-daq/tdaq"
 	"github.com/go-daq/tdaq/config"
 	"github.com/go-daq/tdaq/fsm"
-	"github.com/go-daq/tdaq/internal/iomux"
+	"github.com/go-daq/tdaq/graphql"
 	"github.com/go-daq/tdaq/internal/tcputil"
+	"github.com/go-daq/tdaq/iomux"
 	"github.com/go-daq/tdaq/log"
 	"github.com/go-daq/tdaq/xdaq"
 	"golang.org/x/net/websocket"
@@ -90,6 +94,11 @@ func TestRunControlWebAPI(t *testing.T) {
 	defer tsrv.Close()
 
 	cli := tsrv.Client()
+	// the web client keeps the control session of its commands in a cookie.
+	cli.Jar, err = cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("could not create cookie jar: %+v", err)
+	}
 	rc.SetWebSrv(newWebSrvTest(tsrv))
 	tcli := &testCli{tsrv}
 
@@ -192,6 +201,59 @@ loop:
 		t.Fatalf("invalid status: got=%q, want=%q", got, want)
 	}
 
+	func() {
+		resp, err := cli.Get(tsrv.URL + "/api/status")
+		if err != nil {
+			t.Fatalf("could not get /api/status: %+v", err)
+		}
+		defer resp.Body.Close()
+
+		var report struct {
+			Status string `json:"status"`
+			Procs  []struct {
+				Name   string `json:"name"`
+				Status string `json:"status"`
+			} `json:"procs"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		if err != nil {
+			t.Fatalf("could not decode /api/status: %+v", err)
+		}
+		if got, want := report.Status, fsm.UnConf.String(); got != want {
+			t.Fatalf("invalid /api/status status: got=%q, want=%q", got, want)
+		}
+		if got, want := len(report.Procs), 4; got != want {
+			t.Fatalf("invalid /api/status number of procs: got=%d, want=%d", got, want)
+		}
+	}()
+
+	func() {
+		origin := tsrv.URL + "/"
+		urlFeed := "ws://" + strings.Replace(tsrv.URL, "http://", "", 1) + "/feed"
+		feed, err := websocket.Dial(urlFeed, "", origin)
+		if err != nil {
+			t.Fatalf("could not dial /feed websocket: %+v", err)
+		}
+		defer feed.Close()
+
+		var evt struct {
+			Type string `json:"type"`
+			Data struct {
+				Status string `json:"status"`
+			} `json:"data"`
+		}
+		err = websocket.JSON.Receive(feed, &evt)
+		if err != nil {
+			t.Fatalf("could not read /feed snapshot: %+v", err)
+		}
+		if got, want := evt.Type, "status"; got != want {
+			t.Fatalf("invalid /feed event type: got=%q, want=%q", got, want)
+		}
+		if got, want := evt.Data.Status, fsm.UnConf.String(); got != want {
+			t.Fatalf("invalid /feed status: got=%q, want=%q", got, want)
+		}
+	}()
+
 	func() {
 		// test invalid command
 		cmd := "invalid-command"
@@ -252,6 +314,132 @@ loop:
 		}()
 	}
 
+	func() {
+		resp, err := cli.Get(tsrv.URL + "/api/audit?cmd=/start")
+		if err != nil {
+			t.Fatalf("could not get /api/audit: %+v", err)
+		}
+		defer resp.Body.Close()
+
+		var entries []tdaq.AuditEntry
+		err = json.NewDecoder(resp.Body).Decode(&entries)
+		if err != nil {
+			t.Fatalf("could not decode /api/audit: %+v", err)
+		}
+		if got, want := len(entries), 2; got != want {
+			t.Fatalf("invalid number of audited /start: got=%d, want=%d", got, want)
+		}
+		for _, e := range entries {
+			if got, want := e.Operator, "web@127.0.0.1"; got != want {
+				t.Fatalf("invalid operator: got=%q, want=%q", got, want)
+			}
+			if got, want := e.Targets, []string{"data-sink-1", "data-sink-2", "data-sink-3", "data-src"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid targets: got=%q, want=%q", got, want)
+			}
+			if e.Err != "" {
+				t.Fatalf("invalid outcome: %s", e.Err)
+			}
+		}
+	}()
+
+	func() {
+		body := strings.NewReader(`{
+			"query": "query Runs($n: Int) { state last: runs(last: $n) { run procs { name } totals { dropped } } }",
+			"variables": {"n": 1}
+		}`)
+		resp, err := cli.Post(tsrv.URL+"/api/graphql", "application/json", body)
+		if err != nil {
+			t.Fatalf("could not post /api/graphql: %+v", err)
+		}
+		defer resp.Body.Close()
+
+		var reply struct {
+			Data struct {
+				State string `json:"state"`
+				Last  []struct {
+					Run   uint64 `json:"run"`
+					Procs []struct {
+						Name string `json:"name"`
+					} `json:"procs"`
+				} `json:"last"`
+			} `json:"data"`
+			Errors []graphql.Error `json:"errors"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&reply)
+		if err != nil {
+			t.Fatalf("could not decode /api/graphql: %+v", err)
+		}
+		if len(reply.Errors) != 0 {
+			t.Fatalf("invalid GraphQL response: %+v", reply.Errors)
+		}
+		if got, want := reply.Data.State, fsm.Exiting.String(); got != want {
+			t.Fatalf("invalid state: got=%q, want=%q", got, want)
+		}
+		if got, want := len(reply.Data.Last), 1; got != want {
+			t.Fatalf("invalid number of runs: got=%d, want=%d", got, want)
+		}
+		if got, want := len(reply.Data.Last[0].Procs), 4; got != want {
+			t.Fatalf("invalid number of processes: got=%d, want=%d", got, want)
+		}
+
+		resp, err = cli.Get(tsrv.URL + "/api/graphql?query=" + url.QueryEscape("{ runs { nope } }"))
+		if err != nil {
+			t.Fatalf("could not get /api/graphql: %+v", err)
+		}
+		defer resp.Body.Close()
+
+		var bad graphql.Response
+		err = json.NewDecoder(resp.Body).Decode(&bad)
+		if err != nil {
+			t.Fatalf("could not decode /api/graphql: %+v", err)
+		}
+		if len(bad.Errors) != 1 {
+			t.Fatalf("invalid GraphQL errors: %+v", bad.Errors)
+		}
+	}()
+
+	func() {
+		// another web client, without the control session of the holder.
+		other := &http.Client{Timeout: 5 * time.Second}
+		for _, user := range []string{"bob", ""} {
+			resp, err := other.PostForm(tsrv.URL+"/api/control", url.Values{"user": {user}})
+			if err != nil {
+				t.Fatalf("could not post /api/control: %+v", err)
+			}
+			defer resp.Body.Close()
+			if got, want := resp.StatusCode, http.StatusConflict; got != want {
+				t.Fatalf("invalid status code for take of held control token by %q: got=%d, want=%d", user, got, want)
+			}
+		}
+
+		resp, err := cli.Get(tsrv.URL + "/api/control")
+		if err != nil {
+			t.Fatalf("could not get /api/control: %+v", err)
+		}
+		defer resp.Body.Close()
+
+		var tok tdaq.ControlToken
+		err = json.NewDecoder(resp.Body).Decode(&tok)
+		if err != nil {
+			t.Fatalf("could not decode /api/control: %+v", err)
+		}
+		if got, want := tok.Operator, "web@127.0.0.1"; got != want {
+			t.Fatalf("invalid control token holder: got=%q, want=%q", got, want)
+		}
+
+		resp, err = cli.PostForm(tsrv.URL+"/api/control", url.Values{"release": {"1"}})
+		if err != nil {
+			t.Fatalf("could not post /api/control: %+v", err)
+		}
+		defer resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("invalid status code for release of control token: got=%d, want=%d", got, want)
+		}
+		if got := rc.Control().Operator; got != "" {
+			t.Fatalf("control token should be free, held by %q", got)
+		}
+	}()
+
 	err = grp.Wait()
 	if err != nil {
 		t.Fatalf("could not run device run-group: %+v", err)
@@ -263,6 +451,123 @@ loop:
 	}
 }
 
+func TestRunControlObserver(t *testing.T) {
+	t.Parallel()
+
+	port, err := tcputil.GetTCPPort()
+	if err != nil {
+		t.Fatalf("could not find a tcp port for run-ctl: %+v", err)
+	}
+	rcAddr := ":" + port
+
+	port, err = tcputil.GetTCPPort()
+	if err != nil {
+		t.Fatalf("could not find a tcp port for run-ctl observer web server: %+v", err)
+	}
+	obsAddr := ":" + port
+
+	fname, err := ioutil.TempFile("", "tdaq-")
+	if err != nil {
+		t.Fatalf("could not create a temporary log file for run-ctl log server: %+v", err)
+	}
+	fname.Close()
+	defer os.Remove(fname.Name())
+
+	cfg := config.RunCtl{
+		Name:      "run-ctl",
+		Level:     log.LvlError,
+		Trans:     "tcp",
+		RunCtl:    rcAddr,
+		Observer:  obsAddr,
+		LogFile:   fname.Name(),
+		HBeatFreq: 50 * time.Millisecond,
+	}
+
+	rc, err := tdaq.NewRunControl(cfg, iomux.NewWriter(new(bytes.Buffer)))
+	if err != nil {
+		t.Fatalf("could not create run-ctl: %+v", err)
+	}
+	if rc.Web() != nil {
+		t.Fatalf("unexpected run-ctl web server")
+	}
+
+	tsrv := httptest.NewServer(rc.Observer().(*http.Server).Handler)
+	defer tsrv.Close()
+	rc.SetObserverSrv(newWebSrvTest(tsrv))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	errc := make(chan error)
+	go func() {
+		errc <- rc.Run(ctx)
+	}()
+
+	cli := tsrv.Client()
+	for _, tt := range []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/", http.StatusOK},
+		{http.MethodGet, "/api/status", http.StatusOK},
+		{http.MethodGet, "/api/alarms", http.StatusOK},
+		{http.MethodGet, "/api/kv", http.StatusOK},
+		{http.MethodGet, "/cmd", http.StatusForbidden},
+		{http.MethodPost, "/cmd", http.StatusForbidden},
+		{http.MethodPost, "/api/comment", http.StatusForbidden},
+		{http.MethodPost, "/api/alarms/ack", http.StatusForbidden},
+		{http.MethodPost, "/api/kv", http.StatusForbidden},
+		{http.MethodDelete, "/api/kv", http.StatusForbidden},
+		{http.MethodPost, "/api/snapshot", http.StatusForbidden},
+		{http.MethodGet, "/api/snapshot", http.StatusForbidden},
+		{http.MethodGet, "/api/unknown", http.StatusForbidden},
+		{http.MethodGet, "/api/audit", http.StatusOK},
+		{http.MethodGet, "/api/control", http.StatusOK},
+		{http.MethodPost, "/api/control", http.StatusForbidden},
+	} {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tsrv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatalf("could not create request: %+v", err)
+			}
+			resp, err := cli.Do(req)
+			if err != nil {
+				t.Fatalf("could not send request: %+v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if got, want := resp.StatusCode, tt.want; got != want {
+				t.Fatalf("invalid status code: got=%d, want=%d\n%s", got, want, body)
+			}
+			if tt.path == "/" && strings.Contains(string(body), "cmdStart()\"") {
+				t.Fatalf("observer home page exposes run-ctl commands")
+			}
+		})
+	}
+
+	ws, err := newTestWS(tsrv)
+	if err != nil {
+		t.Fatalf("could not dial observer websockets: %+v", err)
+	}
+	defer ws.Close()
+
+	status, err := ws.readStatus()
+	if err != nil {
+		t.Fatalf("could not read observer /status: %+v", err)
+	}
+	if got, want := status, fsm.UnConf.String(); got != want {
+		t.Fatalf("invalid status: got=%q, want=%q", got, want)
+	}
+
+	cancel()
+	err = <-errc
+	if err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatalf("error shutting down run-ctl: %+v", err)
+	}
+}
+
 type websrvTest struct {
 	srv  *httptest.Server
 	quit chan error
@@ -363,8 +668,8 @@ func (ws *testWS) Close() error {
 }
 
 func (ws *testWS) readStatus() (string, error) {
-	buf := make([]byte, 1024)
-	n, err := ws.status.Read(buf)
+	var buf []byte
+	err := websocket.Message.Receive(ws.status, &buf)
 	if err != nil {
 		return "", fmt.Errorf("could not read /status response: %w", err)
 	}
@@ -372,7 +677,7 @@ func (ws *testWS) readStatus() (string, error) {
 	var data struct {
 		Status string `json:"status"`
 	}
-	err = json.NewDecoder(bytes.NewReader(buf[:n])).Decode(&data)
+	err = json.NewDecoder(bytes.NewReader(buf)).Decode(&data)
 	if err != nil {
 		return "", fmt.Errorf("could not decode /status JSON response: %w", err)
 	}
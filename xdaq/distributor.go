@@ -0,0 +1,143 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Distributor consumes data from an input end-point and distributes it over
+// N output end-points.
+//
+// The output end-point of each input frame is selected by the Fct function,
+// if any, or by the hash of the key returned by the Key function, if any,
+// or in round-robin otherwise.
+// Frames with the same key are always distributed to the same output
+// end-point.
+type Distributor struct {
+	N   int                     // number of output end-points
+	Fct func(tdaq.Frame) int    // selects the output end-point of an input frame (optional)
+	Key func(tdaq.Frame) []byte // key of an input frame, for hash distribution (optional)
+
+	outs []chan tdaq.Frame
+	next int     // next output end-point, for round-robin distribution
+	cnts []int64 // number of frames distributed to each output end-point
+}
+
+// KeyRange returns a Key function selecting the [beg, end) range of bytes
+// of the frames payloads.
+// The range is truncated for shorter payloads.
+func KeyRange(beg, end int) func(tdaq.Frame) []byte {
+	return func(frame tdaq.Frame) []byte {
+		var (
+			n = len(frame.Body)
+			i = beg
+			j = end
+		)
+		if i > n {
+			i = n
+		}
+		if j > n {
+			j = n
+		}
+		return frame.Body[i:j]
+	}
+}
+
+func (dev *Distributor) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	if dev.N <= 0 {
+		return fmt.Errorf("invalid number of output end-points (n=%d)", dev.N)
+	}
+	return nil
+}
+
+func (dev *Distributor) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *Distributor) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *Distributor) reset() {
+	dev.outs = make([]chan tdaq.Frame, dev.N)
+	for i := range dev.outs {
+		dev.outs[i] = make(chan tdaq.Frame)
+	}
+	dev.next = 0
+	dev.cnts = make([]int64, dev.N)
+}
+
+func (dev *Distributor) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *Distributor) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> n=%v", dev.cnts)
+	return nil
+}
+
+func (dev *Distributor) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+// index returns the output end-point of the input frame.
+func (dev *Distributor) index(src tdaq.Frame) (int, error) {
+	switch {
+	case dev.Fct != nil:
+		i := dev.Fct(src)
+		if i < 0 || i >= dev.N {
+			return 0, fmt.Errorf("invalid output end-point index %d (n=%d)", i, dev.N)
+		}
+		return i, nil
+	case dev.Key != nil:
+		h := fnv.New32a()
+		_, _ = h.Write(dev.Key(src))
+		return int(h.Sum32() % uint32(dev.N)), nil
+	default:
+		i := dev.next
+		dev.next = (dev.next + 1) % dev.N
+		return i, nil
+	}
+}
+
+func (dev *Distributor) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	i, err := dev.index(src)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Ctx.Done():
+		return nil
+	case dev.outs[i] <- src:
+		dev.cnts[i]++
+	}
+	return nil
+}
+
+// Output returns the handler of the i-th output end-point.
+func (dev *Distributor) Output(i int) tdaq.OutputHandler {
+	return func(ctx tdaq.Context, dst *tdaq.Frame) error {
+		select {
+		case <-ctx.Ctx.Done():
+			dst.Body = nil
+			return nil
+		case data := <-dev.outs[i]:
+			forward(dst, data)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Downsampler consumes data from an input end-point and publishes on an
+// output end-point a fraction of it, e.g. to feed a monitoring consumer.
+//
+// Downsampler keeps one input frame every Every frames, and at most Rate
+// frames per second. Discarded frames are dropped.
+//
+// Every and Rate may be modified at /config, from the "every" and "rate"
+// values of the key-value configuration store of run-ctl under the name of
+// the process (e.g. "mon/every").
+type Downsampler struct {
+	Every int     // keep one input frame every Every frames (0 or 1: keep all frames)
+	Rate  float64 // maximum rate of kept frames, in Hz (0: unlimited)
+
+	Kept      int64 // number of kept input frames
+	Discarded int64 // number of discarded input frames
+
+	every  int
+	period time.Duration
+	n      int       // number of input frames since the last kept one
+	last   time.Time // time of the last kept frame
+	ch     chan tdaq.Frame
+}
+
+func (dev *Downsampler) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+
+	every := dev.Every
+	if v, ok := ctx.KV("every"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("could not parse downsampling factor %q: %w", v, err)
+		}
+		every = n
+	}
+	if every < 0 {
+		return fmt.Errorf("invalid downsampling factor (every=%d)", every)
+	}
+
+	rate := dev.Rate
+	if v, ok := ctx.KV("rate"); ok {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("could not parse downsampling rate %q: %w", v, err)
+		}
+		rate = r
+	}
+	if rate < 0 {
+		return fmt.Errorf("invalid downsampling rate (rate=%v)", rate)
+	}
+
+	dev.every = every
+	dev.period = 0
+	if rate > 0 {
+		dev.period = time.Duration(float64(time.Second) / rate)
+	}
+	ctx.Msg.Infof("keeping 1 frame every %d, at most every %v", every, dev.period)
+	return nil
+}
+
+func (dev *Downsampler) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *Downsampler) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *Downsampler) reset() {
+	dev.ch = make(chan tdaq.Frame)
+	dev.Kept = 0
+	dev.Discarded = 0
+	dev.n = 0
+	dev.last = time.Time{}
+}
+
+func (dev *Downsampler) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *Downsampler) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> kept=%d, discarded=%d", dev.Kept, dev.Discarded)
+	return nil
+}
+
+func (dev *Downsampler) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+// keep returns whether the input frame received at the provided time
+// should be kept.
+func (dev *Downsampler) keep(now time.Time) bool {
+	dev.n++
+	if dev.every > 1 && dev.n < dev.every {
+		return false
+	}
+	if dev.period > 0 && !dev.last.IsZero() && now.Sub(dev.last) < dev.period {
+		return false
+	}
+	dev.n = 0
+	dev.last = now
+	return true
+}
+
+func (dev *Downsampler) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	if !dev.keep(time.Now()) {
+		dev.Discarded++
+		return nil
+	}
+
+	select {
+	case <-ctx.Ctx.Done():
+		return nil
+	case dev.ch <- src:
+		dev.Kept++
+	}
+	return nil
+}
+
+func (dev *Downsampler) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+		return nil
+	case data := <-dev.ch:
+		forward(dst, data)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/payload"
+)
+
+// Dump formats.
+const (
+	DumpText  = "text"  // one line of name=value fields per frame
+	DumpJSONL = "jsonl" // one JSON object per frame
+	DumpCSV   = "csv"   // one CSV row per frame, after a header row
+)
+
+// Dumper dumps the data frames of an input end-point, decoded with Schema.
+// Frames are dumped as raw payloads when no schema is provided, or when
+// they could not be decoded.
+//
+// When Rate is set, at most Rate frames per second are dumped and the
+// others are skipped.
+type Dumper struct {
+	Schema payload.Schema // schema of the input payloads (optional)
+	Format string         // dump format (text, jsonl or csv; default: text)
+	Rate   float64        // maximum rate of dumped frames, in Hz (0: unlimited)
+	W      io.Writer      // destination of the dump (default: os.Stdout)
+
+	N   int64 // number of dumped frames
+	Tot int64 // total number of input frames
+
+	w    *bufio.Writer
+	csv  *csv.Writer
+	hdr  bool // whether the CSV header was written
+	last time.Time
+}
+
+func (dev *Dumper) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	switch dev.Format {
+	case "":
+		dev.Format = DumpText
+	case DumpText, DumpJSONL, DumpCSV:
+	default:
+		return fmt.Errorf("invalid dump format %q", dev.Format)
+	}
+	if dev.Rate < 0 {
+		return fmt.Errorf("invalid dump rate (rate=%v)", dev.Rate)
+	}
+
+	w := dev.W
+	if w == nil {
+		w = os.Stdout
+	}
+	dev.w = bufio.NewWriter(w)
+	dev.csv = csv.NewWriter(dev.w)
+	dev.hdr = false
+	return nil
+}
+
+func (dev *Dumper) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.N = 0
+	dev.Tot = 0
+	dev.last = time.Time{}
+	return nil
+}
+
+func (dev *Dumper) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.N = 0
+	dev.Tot = 0
+	dev.last = time.Time{}
+	return nil
+}
+
+func (dev *Dumper) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *Dumper) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> n=%d/%d", dev.N, dev.Tot)
+	return dev.flush()
+}
+
+func (dev *Dumper) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return dev.flush()
+}
+
+func (dev *Dumper) flush() error {
+	if dev.w == nil {
+		return nil
+	}
+	dev.csv.Flush()
+	if err := dev.csv.Error(); err != nil {
+		return fmt.Errorf("could not flush dump: %w", err)
+	}
+	if err := dev.w.Flush(); err != nil {
+		return fmt.Errorf("could not flush dump: %w", err)
+	}
+	return nil
+}
+
+func (dev *Dumper) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	dev.Tot++
+	if dev.Rate > 0 {
+		now := time.Now()
+		if !dev.last.IsZero() && now.Sub(dev.last) < time.Duration(float64(time.Second)/dev.Rate) {
+			return nil
+		}
+		dev.last = now
+	}
+	dev.N++
+
+	var err error
+	switch dev.Format {
+	case DumpJSONL:
+		err = dev.dumpJSON(ctx, src)
+	case DumpCSV:
+		err = dev.dumpCSV(ctx, src)
+	default:
+		err = dev.dumpText(ctx, src)
+	}
+	if err != nil {
+		return fmt.Errorf("could not dump frame: %w", err)
+	}
+	return dev.flush()
+}
+
+func (dev *Dumper) dumpText(ctx tdaq.Context, src tdaq.Frame) error {
+	fmt.Fprintf(dev.w, "n=%d", dev.N)
+	row := dev.row(ctx, src)
+	if row == nil {
+		fmt.Fprintf(dev.w, " body=%x\n", src.Body)
+		return nil
+	}
+	for i, f := range dev.Schema {
+		v := row[i]
+		if f.Type == "str" && (v == "" || strings.ContainsAny(v, " \t\n\"=")) {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(dev.w, " %s=%s", f.Name, v)
+	}
+	_, err := dev.w.WriteString("\n")
+	return err
+}
+
+func (dev *Dumper) dumpJSON(ctx tdaq.Context, src tdaq.Frame) error {
+	raw := []byte(fmt.Sprintf(`{"body":"%x"}`, src.Body))
+	if len(dev.Schema) > 0 {
+		v, err := dev.Schema.JSON(src.Body)
+		switch err {
+		case nil:
+			raw = v
+		default:
+			ctx.Msg.Debugf("could not decode input frame: %+v", err)
+		}
+	}
+	dev.w.Write(raw)
+	_, err := dev.w.WriteString("\n")
+	return err
+}
+
+func (dev *Dumper) dumpCSV(ctx tdaq.Context, src tdaq.Frame) error {
+	if !dev.hdr {
+		hdr := []string{"body"}
+		if len(dev.Schema) > 0 {
+			hdr = dev.Schema.Header()
+		}
+		err := dev.csv.Write(hdr)
+		if err != nil {
+			return err
+		}
+		dev.hdr = true
+	}
+	row := dev.row(ctx, src)
+	if row == nil {
+		row = []string{hex.EncodeToString(src.Body)}
+	}
+	return dev.csv.Write(row)
+}
+
+// row returns the fields of the decoded payload, or nil if it could not
+// be decoded.
+func (dev *Dumper) row(ctx tdaq.Context, src tdaq.Frame) []string {
+	if len(dev.Schema) == 0 {
+		return nil
+	}
+	row, err := dev.Schema.CSV(src.Body)
+	if err != nil {
+		ctx.Msg.Debugf("could not decode input frame: %+v", err)
+		return nil
+	}
+	return row
+}
@@ -0,0 +1,372 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+// EventBuilder assembles the data frames of several input streams into
+// events, published on an output end-point.
+//
+// Without a Time function, fragments are matched by event ID: an event is
+// built once all the streams sent their fragment, or once all the streams
+// moved past its ID.
+// Otherwise, fragments are matched by timestamp coincidence, as for
+// free-running front-ends: an event holds all the fragments within a window
+// of Window timestamp units, starting at the earliest pending fragment.
+//
+// Events are only built once the watermark (the lowest of the highest keys
+// seen on each stream, minus Lateness) passed the end of their window, or
+// once their earliest fragment was held for MaxDelay.
+// Fragments arriving after their window was built are late: they are
+// dropped with DropLate, and published as single-fragment events otherwise.
+type EventBuilder struct {
+	Streams  []string                // names of the input end-points of the streams
+	Time     func(tdaq.Frame) uint64 // timestamp of the input frames (optional: build by event ID otherwise)
+	Window   uint64                  // width of the coincidence windows, in timestamp units (default: 1)
+	Lateness uint64                  // maximum out-of-orderness of the keys of a stream
+	MaxDelay time.Duration           // maximum time a fragment is held (0: unbounded)
+	DropLate bool                    // whether late fragments are dropped
+
+	N          int64 // number of built events
+	Late       int64 // number of late fragments
+	Incomplete int64 // number of events built without fragments from all the streams
+
+	mu     sync.Mutex
+	win    mergeWindow
+	seq    uint64            // arrival counter
+	marks  map[string]uint64 // highest key seen on each stream
+	closed uint64            // end of the window of the last built event
+	built  bool              // whether an event was built since /init
+	ch     chan tdaq.Frame
+}
+
+// Event is an event assembled by an EventBuilder.
+type Event struct {
+	ID    uint64     // event ID (event ID mode), or event number from /init (timestamp mode)
+	Time  uint64     // start of the coincidence window (timestamp mode)
+	Frags []Fragment // fragments of the event, in key order
+}
+
+// Fragment is the data frame of a stream belonging to an event.
+type Fragment struct {
+	Stream  string // name of the input end-point of the stream
+	EventID uint64 // event ID of the data frame
+	Time    uint64 // timestamp of the data frame (timestamp mode)
+	Body    []byte // payload of the data frame
+}
+
+func (evt Event) MarshalTDAQ() ([]byte, error) {
+	var (
+		buf = new(bytes.Buffer)
+		enc = tdaq.NewEncoder(buf)
+	)
+	enc.WriteU64(evt.ID)
+	enc.WriteU64(evt.Time)
+	enc.WriteI32(int32(len(evt.Frags)))
+	for _, frag := range evt.Frags {
+		enc.WriteStr(frag.Stream)
+		enc.WriteU64(frag.EventID)
+		enc.WriteU64(frag.Time)
+		enc.WriteBytes(frag.Body)
+	}
+	return buf.Bytes(), enc.Err()
+}
+
+func (evt *Event) UnmarshalTDAQ(p []byte) error {
+	dec := tdaq.NewDecoder(bytes.NewReader(p))
+	evt.ID = dec.ReadU64()
+	evt.Time = dec.ReadU64()
+	n := int(dec.ReadI32())
+	if n < 0 || n > len(p) {
+		return fmt.Errorf("invalid number of event fragments %d", n)
+	}
+	evt.Frags = nil
+	if n > 0 {
+		evt.Frags = make([]Fragment, n)
+	}
+	for i := range evt.Frags {
+		frag := &evt.Frags[i]
+		frag.Stream = dec.ReadStr()
+		frag.EventID = dec.ReadU64()
+		frag.Time = dec.ReadU64()
+		frag.Body = dec.ReadBytes()
+	}
+	return dec.Err()
+}
+
+func (dev *EventBuilder) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	if len(dev.Streams) == 0 {
+		return fmt.Errorf("missing input streams of event builder")
+	}
+	return nil
+}
+
+func (dev *EventBuilder) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *EventBuilder) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *EventBuilder) reset() {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.N = 0
+	dev.Late = 0
+	dev.Incomplete = 0
+	dev.win = dev.win[:0]
+	dev.seq = 0
+	dev.marks = make(map[string]uint64, len(dev.Streams))
+	dev.closed = 0
+	dev.built = false
+	dev.ch = make(chan tdaq.Frame)
+}
+
+func (dev *EventBuilder) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *EventBuilder) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	dev.mu.Lock()
+	held := len(dev.win)
+	dev.mu.Unlock()
+	var (
+		n    = atomic.LoadInt64(&dev.N)
+		late = atomic.LoadInt64(&dev.Late)
+		inc  = atomic.LoadInt64(&dev.Incomplete)
+	)
+	ctx.Msg.Infof("received /stop command... -> n=%d, incomplete=%d, late=%d, held=%d", n, inc, late, held)
+	return nil
+}
+
+func (dev *EventBuilder) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+// key returns the building key of the provided data frame.
+func (dev *EventBuilder) key(frame tdaq.Frame) uint64 {
+	if dev.Time == nil {
+		return frame.EventID
+	}
+	return dev.Time(frame)
+}
+
+// width returns the width of the coincidence windows.
+func (dev *EventBuilder) width() uint64 {
+	if dev.Time == nil || dev.Window == 0 {
+		return 1
+	}
+	return dev.Window
+}
+
+// Input is the handler of all the input end-points of the event builder.
+func (dev *EventBuilder) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	if !dev.stream(src.Path) {
+		return fmt.Errorf("unknown event builder stream %q", src.Path)
+	}
+
+	key := dev.key(src)
+	if dev.built && key < dev.closed {
+		atomic.AddInt64(&dev.Late, 1)
+		if dev.DropLate {
+			ctx.Msg.Debugf("dropped late fragment %d of %q", key, src.Path)
+			return nil
+		}
+		return dev.publish(ctx, []mergeItem{{key: key, frame: src}})
+	}
+
+	if mark, ok := dev.marks[src.Path]; !ok || key > mark {
+		dev.marks[src.Path] = key
+	}
+	dev.seq++
+	heap.Push(&dev.win, mergeItem{key: key, seq: dev.seq, time: time.Now(), frame: src})
+	return dev.build(ctx, time.Time{})
+}
+
+func (dev *EventBuilder) stream(name string) bool {
+	for _, s := range dev.Streams {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// watermark returns the key below which no more fragments are expected,
+// and whether all the streams sent a fragment.
+func (dev *EventBuilder) watermark() (uint64, bool) {
+	var wm uint64
+	for i, s := range dev.Streams {
+		mark, ok := dev.marks[s]
+		if !ok {
+			return 0, false
+		}
+		if i == 0 || mark < wm {
+			wm = mark
+		}
+	}
+	if wm < dev.Lateness {
+		return 0, true
+	}
+	return wm - dev.Lateness, true
+}
+
+// build publishes, in order, the events whose window is closed, or whose
+// earliest fragment was received before now-MaxDelay (if now is not zero).
+// build must be called with dev.mu held.
+func (dev *EventBuilder) build(ctx tdaq.Context, now time.Time) error {
+	width := dev.width()
+	for len(dev.win) > 0 {
+		var (
+			beg     = dev.win[0].key
+			end     = beg + width
+			wm, ok  = dev.watermark()
+			expired = !now.IsZero() && now.Sub(dev.win[0].time) >= dev.MaxDelay
+		)
+		if !(ok && end <= wm) && !expired && !dev.complete(beg) {
+			return nil
+		}
+
+		var frags []mergeItem
+		for len(dev.win) > 0 && dev.win[0].key < end {
+			frags = append(frags, heap.Pop(&dev.win).(mergeItem))
+		}
+		dev.closed = end
+		dev.built = true
+		err := dev.publish(ctx, frags)
+		if err != nil {
+			return err
+		}
+		if ctx.Ctx.Err() != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// complete reports whether all the streams sent their fragment of the
+// event with the provided ID, in event ID mode.
+func (dev *EventBuilder) complete(id uint64) bool {
+	if dev.Time != nil {
+		return false
+	}
+	n := 0
+	for _, s := range dev.Streams {
+		for _, item := range dev.win {
+			if item.key == id && item.frame.Path == s {
+				n++
+				break
+			}
+		}
+	}
+	return n == len(dev.Streams)
+}
+
+// publish publishes the event made of the provided fragments.
+func (dev *EventBuilder) publish(ctx tdaq.Context, frags []mergeItem) error {
+	n := atomic.AddInt64(&dev.N, 1)
+	evt := Event{ID: uint64(n), Frags: make([]Fragment, len(frags))}
+	if dev.Time == nil {
+		evt.ID = frags[0].key
+	} else {
+		evt.Time = frags[0].key
+	}
+
+	var (
+		trace   = false
+		streams = make(map[string]struct{}, len(dev.Streams))
+	)
+	for i, item := range frags {
+		frag := Fragment{
+			Stream:  item.frame.Path,
+			EventID: item.frame.EventID,
+			Body:    item.frame.Body,
+		}
+		if dev.Time != nil {
+			frag.Time = item.key
+		}
+		evt.Frags[i] = frag
+		trace = trace || item.frame.Trace
+		streams[frag.Stream] = struct{}{}
+	}
+	if len(streams) < len(dev.Streams) {
+		atomic.AddInt64(&dev.Incomplete, 1)
+	}
+
+	body, err := evt.MarshalTDAQ()
+	if err != nil {
+		return fmt.Errorf("could not encode event %d: %w", evt.ID, err)
+	}
+
+	select {
+	case <-ctx.Ctx.Done():
+	case dev.ch <- tdaq.Frame{Body: body, EventID: evt.ID, Trace: trace}:
+	}
+	return nil
+}
+
+func (dev *EventBuilder) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+		return nil
+	case evt := <-dev.ch:
+		dst.Body = evt.Body
+		dst.EventID = evt.EventID
+		dst.Trace = evt.Trace
+	}
+	return nil
+}
+
+// Loop builds the events whose earliest fragment was held for longer than
+// MaxDelay, until the run is stopped.
+func (dev *EventBuilder) Loop(ctx tdaq.Context) error {
+	if dev.MaxDelay <= 0 {
+		<-ctx.Ctx.Done()
+		return nil
+	}
+
+	tick := time.NewTicker(dev.MaxDelay / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Ctx.Done():
+			return nil
+		case now := <-tick.C:
+			dev.mu.Lock()
+			err := dev.build(ctx, now)
+			dev.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+var (
+	_ tdaq.Marshaler   = (*Event)(nil)
+	_ tdaq.Unmarshaler = (*Event)(nil)
+)
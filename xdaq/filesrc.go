@@ -0,0 +1,196 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+// FileSrc publishes payloads read from a file or from a directory of files
+// on an output end-point.
+//
+// Files of a directory are read in lexical order, each file being one
+// payload (or a sequence of records, see Size).
+type FileSrc struct {
+	Path   string  // path to a file or to a directory of files
+	Size   int     // size of the records files are split into (0: one payload per file)
+	Repeat bool    // whether to start over once all payloads were published
+	Rate   float64 // rate of the published payloads, in Hz (0: as fast as consumed)
+
+	N int64 // number of payloads published since /init
+
+	files []string
+	ch    chan []byte
+}
+
+func (dev *FileSrc) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	if dev.Size < 0 || dev.Rate < 0 {
+		return fmt.Errorf("invalid file source parameters (size=%d, rate=%g)", dev.Size, dev.Rate)
+	}
+
+	fi, err := os.Stat(dev.Path)
+	if err != nil {
+		return fmt.Errorf("could not stat file source: %w", err)
+	}
+
+	dev.files = dev.files[:0]
+	if !fi.IsDir() {
+		dev.files = append(dev.files, dev.Path)
+		ctx.Msg.Infof("publishing payloads from %q", dev.Path)
+		return nil
+	}
+
+	fis, err := ioutil.ReadDir(dev.Path)
+	if err != nil {
+		return fmt.Errorf("could not read file source directory: %w", err)
+	}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		dev.files = append(dev.files, filepath.Join(dev.Path, fi.Name()))
+	}
+	if len(dev.files) == 0 {
+		return fmt.Errorf("no files in file source directory %q", dev.Path)
+	}
+	sort.Strings(dev.files)
+	ctx.Msg.Infof("publishing payloads from %d files of %q", len(dev.files), dev.Path)
+	return nil
+}
+
+func (dev *FileSrc) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.N = 0
+	dev.ch = make(chan []byte)
+	return nil
+}
+
+func (dev *FileSrc) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.N = 0
+	dev.ch = make(chan []byte)
+	return nil
+}
+
+func (dev *FileSrc) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *FileSrc) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> n=%d", dev.N)
+	return nil
+}
+
+func (dev *FileSrc) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+func (dev *FileSrc) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+		return nil
+	case data := <-dev.ch:
+		dst.Body = data
+	}
+	return nil
+}
+
+// errFileSrcDone is used internally to stop publishing when the run is
+// stopped.
+var errFileSrcDone = errors.New("xdaq: file source done")
+
+// Loop publishes the payloads of the files until the run is stopped.
+// Without Repeat, Loop stops publishing once all payloads were published.
+func (dev *FileSrc) Loop(ctx tdaq.Context) error {
+	var tick <-chan time.Time
+	if dev.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / dev.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	send := func(data []byte) error {
+		if tick != nil {
+			select {
+			case <-ctx.Ctx.Done():
+				return errFileSrcDone
+			case <-tick:
+			}
+		}
+		select {
+		case <-ctx.Ctx.Done():
+			return errFileSrcDone
+		case dev.ch <- data:
+			dev.N++
+			return nil
+		}
+	}
+
+	for {
+		for _, fname := range dev.files {
+			err := dev.stream(fname, send)
+			switch {
+			case err == nil:
+			case errors.Is(err, errFileSrcDone):
+				return nil
+			default:
+				return err
+			}
+		}
+		if !dev.Repeat {
+			ctx.Msg.Infof("all payloads published (n=%d)", dev.N)
+			<-ctx.Ctx.Done()
+			return nil
+		}
+	}
+}
+
+// stream sends the payloads of the named file.
+func (dev *FileSrc) stream(fname string, send func([]byte) error) error {
+	if dev.Size == 0 {
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return fmt.Errorf("could not read payload file: %w", err)
+		}
+		return send(data)
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("could not open payload file: %w", err)
+	}
+	defer f.Close()
+
+	for {
+		data := make([]byte, dev.Size)
+		n, err := io.ReadFull(f, data)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == io.ErrUnexpectedEOF:
+			// trailing short record.
+			return send(data[:n])
+		case err != nil:
+			return fmt.Errorf("could not read payload record from %q: %w", fname, err)
+		}
+		err = send(data)
+		if err != nil {
+			return err
+		}
+	}
+}
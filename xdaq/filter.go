@@ -0,0 +1,144 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/expr"
+	"github.com/go-daq/tdaq/payload"
+)
+
+// Filter consumes typed data from an input end-point and publishes on an
+// output end-point the frames whose decoded fields match a selection
+// expression (see package expr).
+//
+// The selection expression may be modified at /config, from the "expr"
+// value of the key-value configuration store of run-ctl under the name of
+// the process (e.g. "filter/expr").
+// Frames that could not be decoded with the schema are rejected.
+type Filter struct {
+	Schema payload.Schema // schema of the input payloads
+	Expr   string         // selection expression
+
+	Acc     int64 // number of accepted input frames
+	Tot     int64 // total number of input frames
+	Invalid int64 // number of input frames that could not be decoded
+
+	sel *expr.Expr
+	ch  chan tdaq.Frame
+}
+
+func (dev *Filter) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	if len(dev.Schema) == 0 {
+		return fmt.Errorf("missing filter schema")
+	}
+
+	src := dev.Expr
+	if v, ok := ctx.KV("expr"); ok {
+		src = v
+	}
+	sel, err := expr.Compile(src)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]string, len(dev.Schema))
+	for _, f := range dev.Schema {
+		fields[f.Name] = f.Type
+	}
+	for _, name := range sel.Vars() {
+		typ, ok := fields[name]
+		switch {
+		case !ok:
+			return fmt.Errorf("invalid filter expression %q: no field %q in schema %q", sel, name, dev.Schema)
+		case strings.HasPrefix(typ, "[]"):
+			return fmt.Errorf("invalid filter expression %q: slice field %q can not be selected on", sel, name)
+		}
+	}
+
+	dev.sel = sel
+	ctx.Msg.Infof("selecting frames with %q", sel)
+	return nil
+}
+
+func (dev *Filter) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *Filter) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *Filter) reset() {
+	dev.ch = make(chan tdaq.Frame)
+	dev.Acc = 0
+	dev.Tot = 0
+	dev.Invalid = 0
+}
+
+func (dev *Filter) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *Filter) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	n := dev.Tot
+	v := dev.Acc
+	ctx.Msg.Infof("received /stop command... v=%d/%d (invalid=%d)", v, n, dev.Invalid)
+	return nil
+}
+
+func (dev *Filter) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+func (dev *Filter) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	dev.Tot++
+
+	vars, err := dev.Schema.Decode(src.Body)
+	if err != nil {
+		dev.Invalid++
+		ctx.Msg.Debugf("could not decode input frame: %+v", err)
+		return nil
+	}
+
+	ok, err := dev.sel.Match(vars)
+	if err != nil {
+		dev.Invalid++
+		ctx.Msg.Debugf("could not evaluate filter: %+v", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-ctx.Ctx.Done():
+		return nil
+	case dev.ch <- src:
+		dev.Acc++
+	}
+	return nil
+}
+
+func (dev *Filter) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+		return nil
+	case data := <-dev.ch:
+		forward(dst, data)
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+// H1D is a one-dimensional histogram with fixed-width bins, following the
+// conventions of go-hep.org/x/hep/hbook.H1D.
+type H1D struct {
+	Name  string
+	Min   float64   // lower edge of the first bin
+	Max   float64   // upper edge of the last bin
+	Bins  []float64 // sum of weights of each bin
+	Under float64   // sum of weights below Min
+	Over  float64   // sum of weights above Max
+
+	Entries int64   // number of fills
+	SumW    float64 // sum of weights
+	SumW2   float64 // sum of squared weights
+	SumWX   float64 // sum of weights times x
+	SumWX2  float64 // sum of weights times x squared
+}
+
+// NewH1D returns a histogram with n bins from min to max.
+func NewH1D(name string, n int, min, max float64) *H1D {
+	return &H1D{Name: name, Min: min, Max: max, Bins: make([]float64, n)}
+}
+
+// Fill fills the histogram with x and weight w.
+func (h *H1D) Fill(x, w float64) {
+	h.Entries++
+	h.SumW += w
+	h.SumW2 += w * w
+	h.SumWX += w * x
+	h.SumWX2 += w * x * x
+
+	switch i := binIndex(x, h.Min, h.Max, len(h.Bins)); i {
+	case underflow:
+		h.Under += w
+	case overflow:
+		h.Over += w
+	default:
+		h.Bins[i] += w
+	}
+}
+
+// Reset clears the contents of the histogram.
+func (h *H1D) Reset() {
+	for i := range h.Bins {
+		h.Bins[i] = 0
+	}
+	h.Under = 0
+	h.Over = 0
+	h.Entries = 0
+	h.SumW = 0
+	h.SumW2 = 0
+	h.SumWX = 0
+	h.SumWX2 = 0
+}
+
+// Mean returns the weighted mean of the filled values.
+func (h *H1D) Mean() float64 {
+	return h.SumWX / h.SumW
+}
+
+// StdDev returns the weighted standard deviation of the filled values.
+func (h *H1D) StdDev() float64 {
+	mean := h.Mean()
+	return math.Sqrt(h.SumWX2/h.SumW - mean*mean)
+}
+
+func (h *H1D) clone() H1D {
+	o := *h
+	o.Bins = append([]float64(nil), h.Bins...)
+	return o
+}
+
+func (h *H1D) encode(enc *tdaq.Encoder) {
+	enc.WriteStr(h.Name)
+	enc.WriteF64(h.Min)
+	enc.WriteF64(h.Max)
+	writeF64s(enc, h.Bins)
+	enc.WriteF64(h.Under)
+	enc.WriteF64(h.Over)
+	enc.WriteI64(h.Entries)
+	enc.WriteF64(h.SumW)
+	enc.WriteF64(h.SumW2)
+	enc.WriteF64(h.SumWX)
+	enc.WriteF64(h.SumWX2)
+}
+
+func (h *H1D) decode(dec *tdaq.Decoder) {
+	h.Name = dec.ReadStr()
+	h.Min = dec.ReadF64()
+	h.Max = dec.ReadF64()
+	h.Bins = readF64s(dec)
+	h.Under = dec.ReadF64()
+	h.Over = dec.ReadF64()
+	h.Entries = dec.ReadI64()
+	h.SumW = dec.ReadF64()
+	h.SumW2 = dec.ReadF64()
+	h.SumWX = dec.ReadF64()
+	h.SumWX2 = dec.ReadF64()
+}
+
+// H2D is a two-dimensional histogram with fixed-width bins, following the
+// conventions of go-hep.org/x/hep/hbook.H2D.
+type H2D struct {
+	Name    string
+	XBins   int
+	XMin    float64
+	XMax    float64
+	YBins   int
+	YMin    float64
+	YMax    float64
+	Bins    []float64 // sum of weights of each bin, with bin (ix, iy) at iy*XBins+ix
+	Outside float64   // sum of weights outside of the bins
+
+	Entries int64   // number of fills
+	SumW    float64 // sum of weights
+	SumW2   float64 // sum of squared weights
+	SumWX   float64 // sum of weights times x
+	SumWY   float64 // sum of weights times y
+}
+
+// NewH2D returns a histogram with nx bins from xmin to xmax along x,
+// and ny bins from ymin to ymax along y.
+func NewH2D(name string, nx int, xmin, xmax float64, ny int, ymin, ymax float64) *H2D {
+	return &H2D{
+		Name:  name,
+		XBins: nx, XMin: xmin, XMax: xmax,
+		YBins: ny, YMin: ymin, YMax: ymax,
+		Bins: make([]float64, nx*ny),
+	}
+}
+
+// Fill fills the histogram with (x, y) and weight w.
+func (h *H2D) Fill(x, y, w float64) {
+	h.Entries++
+	h.SumW += w
+	h.SumW2 += w * w
+	h.SumWX += w * x
+	h.SumWY += w * y
+
+	ix := binIndex(x, h.XMin, h.XMax, h.XBins)
+	iy := binIndex(y, h.YMin, h.YMax, h.YBins)
+	if ix < 0 || iy < 0 {
+		h.Outside += w
+		return
+	}
+	h.Bins[iy*h.XBins+ix] += w
+}
+
+// Reset clears the contents of the histogram.
+func (h *H2D) Reset() {
+	for i := range h.Bins {
+		h.Bins[i] = 0
+	}
+	h.Outside = 0
+	h.Entries = 0
+	h.SumW = 0
+	h.SumW2 = 0
+	h.SumWX = 0
+	h.SumWY = 0
+}
+
+func (h *H2D) clone() H2D {
+	o := *h
+	o.Bins = append([]float64(nil), h.Bins...)
+	return o
+}
+
+func (h *H2D) encode(enc *tdaq.Encoder) {
+	enc.WriteStr(h.Name)
+	enc.WriteI64(int64(h.XBins))
+	enc.WriteF64(h.XMin)
+	enc.WriteF64(h.XMax)
+	enc.WriteI64(int64(h.YBins))
+	enc.WriteF64(h.YMin)
+	enc.WriteF64(h.YMax)
+	writeF64s(enc, h.Bins)
+	enc.WriteF64(h.Outside)
+	enc.WriteI64(h.Entries)
+	enc.WriteF64(h.SumW)
+	enc.WriteF64(h.SumW2)
+	enc.WriteF64(h.SumWX)
+	enc.WriteF64(h.SumWY)
+}
+
+func (h *H2D) decode(dec *tdaq.Decoder) {
+	h.Name = dec.ReadStr()
+	h.XBins = int(dec.ReadI64())
+	h.XMin = dec.ReadF64()
+	h.XMax = dec.ReadF64()
+	h.YBins = int(dec.ReadI64())
+	h.YMin = dec.ReadF64()
+	h.YMax = dec.ReadF64()
+	h.Bins = readF64s(dec)
+	h.Outside = dec.ReadF64()
+	h.Entries = dec.ReadI64()
+	h.SumW = dec.ReadF64()
+	h.SumW2 = dec.ReadF64()
+	h.SumWX = dec.ReadF64()
+	h.SumWY = dec.ReadF64()
+}
+
+const (
+	underflow = -1
+	overflow  = -2
+)
+
+// binIndex returns the index of the bin of x, or underflow or overflow.
+func binIndex(x, min, max float64, n int) int {
+	switch {
+	case x < min || math.IsNaN(x):
+		return underflow
+	case x >= max:
+		return overflow
+	}
+	i := int(float64(n) * (x - min) / (max - min))
+	if i >= n {
+		i = n - 1
+	}
+	return i
+}
+
+func writeF64s(enc *tdaq.Encoder, vs []float64) {
+	enc.WriteU64(uint64(len(vs)))
+	for _, v := range vs {
+		enc.WriteF64(v)
+	}
+}
+
+func readF64s(dec *tdaq.Decoder) []float64 {
+	n := dec.ReadU64()
+	if dec.Err() != nil {
+		return nil
+	}
+	vs := make([]float64, 0, n)
+	for i := uint64(0); i < n && dec.Err() == nil; i++ {
+		vs = append(vs, dec.ReadF64())
+	}
+	return vs
+}
+
+// HistSnapshot is a snapshot of the histograms filled by a Histogrammer,
+// as published on its output end-point.
+type HistSnapshot struct {
+	Time time.Time // time of the snapshot
+	H1Ds []H1D
+	H2Ds []H2D
+}
+
+func (snap HistSnapshot) MarshalTDAQ() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := tdaq.NewEncoder(buf)
+	enc.WriteTime(snap.Time)
+	enc.WriteU64(uint64(len(snap.H1Ds)))
+	for i := range snap.H1Ds {
+		snap.H1Ds[i].encode(enc)
+	}
+	enc.WriteU64(uint64(len(snap.H2Ds)))
+	for i := range snap.H2Ds {
+		snap.H2Ds[i].encode(enc)
+	}
+	err := enc.Err()
+	return buf.Bytes(), err
+}
+
+func (snap *HistSnapshot) UnmarshalTDAQ(p []byte) error {
+	dec := tdaq.NewDecoder(bytes.NewReader(p))
+	snap.Time = dec.ReadTime()
+	n := dec.ReadU64()
+	snap.H1Ds = nil
+	for i := uint64(0); i < n && dec.Err() == nil; i++ {
+		var h H1D
+		h.decode(dec)
+		snap.H1Ds = append(snap.H1Ds, h)
+	}
+	n = dec.ReadU64()
+	snap.H2Ds = nil
+	for i := uint64(0); i < n && dec.Err() == nil; i++ {
+		var h H2D
+		h.decode(dec)
+		snap.H2Ds = append(snap.H2Ds, h)
+	}
+	return dec.Err()
+}
+
+// Axis describes the binning of a histogram along one of its dimensions.
+type Axis struct {
+	Field string  // name of the histogrammed payload field
+	Bins  int     // number of bins
+	Min   float64 // lower edge of the first bin
+	Max   float64 // upper edge of the last bin
+}
+
+// HistDef describes a histogram filled by a Histogrammer.
+// One-dimensional histograms have no Y axis.
+type HistDef struct {
+	X Axis
+	Y *Axis // (optional)
+}
+
+// Name returns the name of the histogram, made of the names of its fields.
+func (def HistDef) Name() string {
+	if def.Y == nil {
+		return def.X.Field
+	}
+	return def.X.Field + ":" + def.Y.Field
+}
+
+func (def HistDef) String() string {
+	str := func(a Axis) string {
+		return fmt.Sprintf("%s:%d:%v:%v", a.Field, a.Bins, a.Min, a.Max)
+	}
+	if def.Y == nil {
+		return str(def.X)
+	}
+	return str(def.X) + ":" + str(*def.Y)
+}
+
+// ParseHistDefs parses a list of histogram definitions, separated by commas
+// or semicolons.
+// One-dimensional histograms are defined as field:bins:min:max
+// (e.g. "energy:100:0:50"), and two-dimensional histograms as
+// xfield:xbins:xmin:xmax:yfield:ybins:ymin:ymax (e.g. "x:10:0:1:y:20:-1:1").
+func ParseHistDefs(s string) ([]HistDef, error) {
+	var (
+		defs []HistDef
+		sep  = func(r rune) bool { return r == ',' || r == ';' }
+	)
+	for _, v := range strings.FieldsFunc(s, sep) {
+		v = strings.TrimSpace(v)
+		toks := strings.Split(v, ":")
+		var def HistDef
+		switch len(toks) {
+		case 4:
+			x, err := parseAxis(toks)
+			if err != nil {
+				return nil, fmt.Errorf("invalid histogram definition %q: %w", v, err)
+			}
+			def.X = x
+		case 8:
+			x, err := parseAxis(toks[:4])
+			if err != nil {
+				return nil, fmt.Errorf("invalid histogram definition %q: %w", v, err)
+			}
+			y, err := parseAxis(toks[4:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid histogram definition %q: %w", v, err)
+			}
+			def.X = x
+			def.Y = &y
+		default:
+			return nil, fmt.Errorf("invalid histogram definition %q", v)
+		}
+		defs = append(defs, def)
+	}
+	if len(defs) == 0 {
+		return nil, fmt.Errorf("no histogram definition")
+	}
+	return defs, nil
+}
+
+func parseAxis(toks []string) (Axis, error) {
+	var (
+		a   = Axis{Field: toks[0]}
+		err error
+	)
+	if a.Field == "" {
+		return a, fmt.Errorf("missing field name")
+	}
+	a.Bins, err = strconv.Atoi(toks[1])
+	if err != nil {
+		return a, fmt.Errorf("could not parse number of bins: %w", err)
+	}
+	a.Min, err = strconv.ParseFloat(toks[2], 64)
+	if err != nil {
+		return a, fmt.Errorf("could not parse lower edge: %w", err)
+	}
+	a.Max, err = strconv.ParseFloat(toks[3], 64)
+	if err != nil {
+		return a, fmt.Errorf("could not parse upper edge: %w", err)
+	}
+	return a, a.validate()
+}
+
+// validate checks the axis has a valid binning.
+func (a Axis) validate() error {
+	if a.Bins <= 0 || !(a.Min < a.Max) {
+		return fmt.Errorf("invalid binning (bins=%d, min=%v, max=%v)", a.Bins, a.Min, a.Max)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/payload"
+)
+
+// Histogrammer consumes typed data from an input end-point and fills
+// histograms of the decoded payload fields during the run.
+// Snapshots of the histograms are published on an output end-point every
+// Period while running, as HistSnapshot values.
+// Histograms are cleared at /start.
+//
+// The histogram definitions may be modified at /config, from the "hists"
+// value of the key-value configuration store of run-ctl under the name of
+// the process (e.g. "histos/hists"), with the syntax of ParseHistDefs.
+type Histogrammer struct {
+	Schema payload.Schema // schema of the input payloads
+	Hists  []HistDef      // definitions of the histograms
+	Period time.Duration  // publication period of the snapshots (0: never)
+
+	N       int64 // number of input frames filled in the histograms
+	Invalid int64 // number of input frames that could not be decoded
+
+	mu  sync.Mutex
+	h1s []*H1D
+	h2s []*H2D
+	ch  chan HistSnapshot
+}
+
+func (dev *Histogrammer) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	if len(dev.Schema) == 0 {
+		return fmt.Errorf("missing histogrammer schema")
+	}
+
+	defs := dev.Hists
+	if v, ok := ctx.KV("hists"); ok {
+		var err error
+		defs, err = ParseHistDefs(v)
+		if err != nil {
+			return fmt.Errorf("could not parse histogram definitions: %w", err)
+		}
+	}
+	if len(defs) == 0 {
+		return fmt.Errorf("missing histogram definitions")
+	}
+
+	fields := make(map[string]string, len(dev.Schema))
+	for _, f := range dev.Schema {
+		fields[f.Name] = f.Type
+	}
+	check := func(a Axis) error {
+		err := a.validate()
+		if err != nil {
+			return fmt.Errorf("invalid axis of histogrammed field %q: %w", a.Field, err)
+		}
+		typ, ok := fields[a.Field]
+		if !ok {
+			return fmt.Errorf("no field %q in schema %q", a.Field, dev.Schema)
+		}
+		switch {
+		case typ == "str", typ == "bytes", strings.HasPrefix(typ, "[]"):
+			return fmt.Errorf("invalid type %q of histogrammed field %q", typ, a.Field)
+		}
+		return nil
+	}
+
+	var (
+		h1s []*H1D
+		h2s []*H2D
+	)
+	for _, def := range defs {
+		err := check(def.X)
+		if err != nil {
+			return err
+		}
+		if def.Y == nil {
+			h1s = append(h1s, NewH1D(def.Name(), def.X.Bins, def.X.Min, def.X.Max))
+			continue
+		}
+		err = check(*def.Y)
+		if err != nil {
+			return err
+		}
+		h2s = append(h2s, NewH2D(
+			def.Name(),
+			def.X.Bins, def.X.Min, def.X.Max,
+			def.Y.Bins, def.Y.Min, def.Y.Max,
+		))
+	}
+
+	dev.mu.Lock()
+	dev.Hists = defs
+	dev.h1s = h1s
+	dev.h2s = h2s
+	dev.mu.Unlock()
+
+	ctx.Msg.Infof("histogramming %v", defs)
+	return nil
+}
+
+func (dev *Histogrammer) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.ch = make(chan HistSnapshot)
+	dev.reset()
+	return nil
+}
+
+func (dev *Histogrammer) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.ch = make(chan HistSnapshot)
+	dev.reset()
+	return nil
+}
+
+func (dev *Histogrammer) reset() {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.N = 0
+	dev.Invalid = 0
+	for _, h := range dev.h1s {
+		h.Reset()
+	}
+	for _, h := range dev.h2s {
+		h.Reset()
+	}
+}
+
+func (dev *Histogrammer) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *Histogrammer) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	dev.mu.Lock()
+	n := dev.N
+	invalid := dev.Invalid
+	dev.mu.Unlock()
+	ctx.Msg.Infof("received /stop command... -> n=%d (invalid=%d)", n, invalid)
+	return nil
+}
+
+func (dev *Histogrammer) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+// Snapshot returns a copy of the current state of the histograms.
+func (dev *Histogrammer) Snapshot() HistSnapshot {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	snap := HistSnapshot{
+		Time: time.Now().UTC(),
+		H1Ds: make([]H1D, len(dev.h1s)),
+		H2Ds: make([]H2D, len(dev.h2s)),
+	}
+	for i, h := range dev.h1s {
+		snap.H1Ds[i] = h.clone()
+	}
+	for i, h := range dev.h2s {
+		snap.H2Ds[i] = h.clone()
+	}
+	return snap
+}
+
+func (dev *Histogrammer) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	vars, err := dev.Schema.Decode(src.Body)
+
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	if err != nil {
+		dev.Invalid++
+		ctx.Msg.Debugf("could not decode input frame: %+v", err)
+		return nil
+	}
+
+	dev.N++
+	var (
+		i1 = 0
+		i2 = 0
+	)
+	for _, def := range dev.Hists {
+		x := toFloat(vars[def.X.Field])
+		if def.Y == nil {
+			dev.h1s[i1].Fill(x, 1)
+			i1++
+			continue
+		}
+		y := toFloat(vars[def.Y.Field])
+		dev.h2s[i2].Fill(x, y, 1)
+		i2++
+	}
+	return nil
+}
+
+// Output publishes the snapshots of the histograms.
+func (dev *Histogrammer) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+		return nil
+	case snap := <-dev.ch:
+		raw, err := snap.MarshalTDAQ()
+		if err != nil {
+			return fmt.Errorf("could not marshal histograms snapshot: %w", err)
+		}
+		dst.Body = raw
+	}
+	return nil
+}
+
+// Loop publishes snapshots of the histograms every Period, until the run
+// is stopped.
+func (dev *Histogrammer) Loop(ctx tdaq.Context) error {
+	if dev.Period <= 0 {
+		<-ctx.Ctx.Done()
+		return nil
+	}
+
+	tick := time.NewTicker(dev.Period)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Ctx.Done():
+			return nil
+		case <-tick.C:
+			select {
+			case <-ctx.Ctx.Done():
+				return nil
+			case dev.ch <- dev.Snapshot():
+			}
+		}
+	}
+}
+
+// toFloat converts a decoded payload field to a float64.
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
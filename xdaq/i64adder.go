@@ -12,12 +12,16 @@ import (
 
 // I64Adder consumes int64 data from 2 input end-points (left and right) and publishes the
 // sum int64 data on an output end-point.
+//
+// I64Adder builds its output data from pairs of left and right input data:
+// the output data carries the event ID of the left input data, and is traced
+// if any of the input data is.
 type I64Adder struct {
 	N int64 // counter of values seen since /init
 	V int64 // last value seen
 
-	left  chan int64
-	right chan int64
+	left  chan tdaq.Frame
+	right chan tdaq.Frame
 }
 
 func (dev *I64Adder) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
@@ -29,8 +33,8 @@ func (dev *I64Adder) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame)
 	ctx.Msg.Debugf("received /init command...")
 	dev.N = 0
 	dev.V = 0
-	dev.left = make(chan int64)
-	dev.right = make(chan int64)
+	dev.left = make(chan tdaq.Frame)
+	dev.right = make(chan tdaq.Frame)
 	return nil
 }
 
@@ -38,8 +42,8 @@ func (dev *I64Adder) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame)
 	ctx.Msg.Debugf("received /reset command...")
 	dev.N = 0
 	dev.V = 0
-	dev.left = make(chan int64)
-	dev.right = make(chan int64)
+	dev.left = make(chan tdaq.Frame)
+	dev.right = make(chan tdaq.Frame)
 	return nil
 }
 
@@ -66,7 +70,7 @@ func (dev *I64Adder) Left(ctx tdaq.Context, src tdaq.Frame) error {
 	select {
 	case <-ctx.Ctx.Done():
 		return nil
-	case dev.left <- v:
+	case dev.left <- src:
 		return nil
 	}
 }
@@ -77,16 +81,13 @@ func (dev *I64Adder) Right(ctx tdaq.Context, src tdaq.Frame) error {
 	select {
 	case <-ctx.Ctx.Done():
 		return nil
-	case dev.right <- v:
+	case dev.right <- src:
 		return nil
 	}
 }
 
 func (dev *I64Adder) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
-	var (
-		a int64 = 0
-		b int64 = 0
-	)
+	var a, b tdaq.Frame
 
 	select {
 	case <-ctx.Ctx.Done():
@@ -102,12 +103,18 @@ func (dev *I64Adder) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
 	case b = <-dev.right:
 	}
 
-	sum := a + b
+	sum := int64(binary.LittleEndian.Uint64(a.Body)) + int64(binary.LittleEndian.Uint64(b.Body))
 	dev.N++
 	dev.V = sum
 
 	dst.Body = make([]byte, 8)
 	binary.LittleEndian.PutUint64(dst.Body, uint64(sum))
 
+	if a.EventID != b.EventID {
+		ctx.Msg.Warnf("mismatched event IDs (left=%d, right=%d)", a.EventID, b.EventID)
+	}
+	dst.EventID = a.EventID
+	dst.Trace = a.Trace || b.Trace
+
 	return nil
 }
@@ -0,0 +1,241 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-daq/tdaq"
+)
+
+// Merger consumes data from any number of input end-points and publishes
+// it as a single stream on an output end-point.
+//
+// Without an Order function, frames are published in arrival order.
+// Otherwise, frames are held in a reordering window of at most Window
+// frames and published in increasing order of their key: the frame with
+// the lowest key is published whenever the window is full, or once it has
+// been held for MaxDelay.
+// Frames arriving after a frame with a higher key was published are
+// published right away and counted as late.
+type Merger struct {
+	Order    func(tdaq.Frame) uint64 // ordering key of the input frames, e.g. a sequence number or a timestamp (optional)
+	Window   int                     // maximum number of frames held for reordering
+	MaxDelay time.Duration           // maximum time a frame is held for reordering (0: unbounded)
+
+	N    int64 // number of published frames
+	Late int64 // number of frames published out of order
+
+	mu   sync.Mutex
+	win  mergeWindow
+	seq  uint64 // arrival counter
+	last uint64 // key of the last published frame
+	sent bool   // whether a frame was published since /init
+	ch   chan tdaq.Frame
+}
+
+// U64Key returns an Order function reading the little-endian uint64 at the
+// provided offset of the frames payloads.
+// Frames with shorter payloads have a zero key.
+func U64Key(off int) func(tdaq.Frame) uint64 {
+	return func(frame tdaq.Frame) uint64 {
+		if len(frame.Body) < off+8 {
+			return 0
+		}
+		return binary.LittleEndian.Uint64(frame.Body[off:])
+	}
+}
+
+func (dev *Merger) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	return nil
+}
+
+func (dev *Merger) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *Merger) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.reset()
+	return nil
+}
+
+func (dev *Merger) reset() {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	dev.N = 0
+	dev.Late = 0
+	dev.win = dev.win[:0]
+	dev.seq = 0
+	dev.last = 0
+	dev.sent = false
+	dev.ch = make(chan tdaq.Frame)
+}
+
+func (dev *Merger) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return nil
+}
+
+func (dev *Merger) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	dev.mu.Lock()
+	held := len(dev.win)
+	dev.mu.Unlock()
+	n := atomic.LoadInt64(&dev.N)
+	late := atomic.LoadInt64(&dev.Late)
+	ctx.Msg.Infof("received /stop command... -> n=%d, late=%d, held=%d", n, late, held)
+	return nil
+}
+
+func (dev *Merger) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+// Input is the handler of all the input end-points of the merger.
+func (dev *Merger) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	if dev.Order == nil {
+		return dev.publish(ctx, src)
+	}
+
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	key := dev.Order(src)
+	if dev.sent && key < dev.last {
+		atomic.AddInt64(&dev.Late, 1)
+		return dev.publish(ctx, src)
+	}
+
+	dev.seq++
+	heap.Push(&dev.win, mergeItem{key: key, seq: dev.seq, time: time.Now(), frame: src})
+	for len(dev.win) > dev.Window {
+		err := dev.pop(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pop publishes the frame of the reordering window with the lowest key.
+// pop must be called with dev.mu held, so frames are published in order.
+func (dev *Merger) pop(ctx tdaq.Context) error {
+	item := heap.Pop(&dev.win).(mergeItem)
+	dev.last = item.key
+	dev.sent = true
+	return dev.publish(ctx, item.frame)
+}
+
+func (dev *Merger) publish(ctx tdaq.Context, frame tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		return nil
+	case dev.ch <- frame:
+		atomic.AddInt64(&dev.N, 1)
+	}
+	return nil
+}
+
+func (dev *Merger) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
+	select {
+	case <-ctx.Ctx.Done():
+		dst.Body = nil
+		return nil
+	case data := <-dev.ch:
+		forward(dst, data)
+	}
+	return nil
+}
+
+// Loop publishes the frames held in the reordering window for longer than
+// MaxDelay, until the run is stopped.
+func (dev *Merger) Loop(ctx tdaq.Context) error {
+	if dev.Order == nil || dev.MaxDelay <= 0 {
+		<-ctx.Ctx.Done()
+		return nil
+	}
+
+	tick := time.NewTicker(dev.MaxDelay / 2)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Ctx.Done():
+			return nil
+		case now := <-tick.C:
+			err := dev.flush(ctx, now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// flush publishes, in order, the frames held for longer than MaxDelay and
+// the frames with a lower key.
+func (dev *Merger) flush(ctx tdaq.Context, now time.Time) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	var (
+		max     uint64
+		expired = false
+	)
+	for _, item := range dev.win {
+		if now.Sub(item.time) >= dev.MaxDelay && (!expired || item.key > max) {
+			max = item.key
+			expired = true
+		}
+	}
+	if !expired {
+		return nil
+	}
+
+	for len(dev.win) > 0 && dev.win[0].key <= max {
+		err := dev.pop(ctx)
+		if err != nil {
+			return err
+		}
+		if ctx.Ctx.Err() != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+type mergeItem struct {
+	key   uint64
+	seq   uint64 // arrival order, for frames with equal keys
+	time  time.Time
+	frame tdaq.Frame
+}
+
+// mergeWindow is a min-heap of frames, ordered by key.
+type mergeWindow []mergeItem
+
+func (w mergeWindow) Len() int { return len(w) }
+func (w mergeWindow) Less(i, j int) bool {
+	if w[i].key != w[j].key {
+		return w[i].key < w[j].key
+	}
+	return w[i].seq < w[j].seq
+}
+func (w mergeWindow) Swap(i, j int)       { w[i], w[j] = w[j], w[i] }
+func (w *mergeWindow) Push(x interface{}) { *w = append(*w, x.(mergeItem)) }
+func (w *mergeWindow) Pop() interface{} {
+	old := *w
+	n := len(old)
+	item := old[n-1]
+	*w = old[:n-1]
+	return item
+}
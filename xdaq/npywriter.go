@@ -0,0 +1,327 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/payload"
+)
+
+// NumPy file formats.
+const (
+	NpyFormat = "npy" // one structured array per run, with one record per frame
+	NpzFormat = "npz" // one archive per run, with one array per field
+)
+
+// NpyWriter accumulates the numeric payloads of an input end-point, decoded
+// with Schema, and writes them as NumPy files at the end of each run, in
+// Dir/<Prefix>-<run>.npy or .npz.
+// Runs are numbered from 1, at each /start after /init.
+//
+// Only bool, integer and floating point fields are supported.
+// Frames which could not be decoded are skipped.
+type NpyWriter struct {
+	Schema payload.Schema // schema of the input payloads
+	Format string         // file format (npy or npz; default: npz)
+	Dir    string         // directory of the files (default: current directory)
+	Prefix string         // prefix of the file names (default: run)
+
+	N    int64 // number of frames of the current run
+	Run  int   // number of the current run
+	Skip int64 // number of skipped frames of the current run
+
+	dtypes []string       // NumPy types of the fields
+	cols   []bytes.Buffer // little-endian values of the fields of the current run
+}
+
+// npyTypes are the NumPy types of the supported payload fields.
+var npyTypes = map[string]string{
+	"bool":    "|b1",
+	"i8":      "|i1",
+	"i16":     "<i2",
+	"i32":     "<i4",
+	"i64":     "<i8",
+	"u8":      "|u1",
+	"u16":     "<u2",
+	"u32":     "<u4",
+	"u64":     "<u8",
+	"f32":     "<f4",
+	"f64":     "<f8",
+	"varint":  "<i8",
+	"uvarint": "<u8",
+}
+
+func (dev *NpyWriter) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	switch dev.Format {
+	case "":
+		dev.Format = NpzFormat
+	case NpyFormat, NpzFormat:
+	default:
+		return fmt.Errorf("invalid NumPy format %q", dev.Format)
+	}
+	if len(dev.Schema) == 0 {
+		return fmt.Errorf("missing schema of NumPy payloads")
+	}
+	if dev.Prefix == "" {
+		dev.Prefix = "run"
+	}
+
+	dev.dtypes = make([]string, len(dev.Schema))
+	for i, f := range dev.Schema {
+		dtype, ok := npyTypes[f.Type]
+		if !ok {
+			return fmt.Errorf("invalid type %q of field %q for NumPy arrays", f.Type, f.Name)
+		}
+		dev.dtypes[i] = dtype
+	}
+	dev.cols = make([]bytes.Buffer, len(dev.Schema))
+	return nil
+}
+
+func (dev *NpyWriter) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.Run = 0
+	dev.reset()
+	return nil
+}
+
+func (dev *NpyWriter) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.Run = 0
+	dev.reset()
+	return nil
+}
+
+func (dev *NpyWriter) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	dev.Run++
+	dev.reset()
+	return nil
+}
+
+func (dev *NpyWriter) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> run=%d, n=%d (skipped=%d)", dev.Run, dev.N, dev.Skip)
+	fname, err := dev.write()
+	if err != nil {
+		return fmt.Errorf("could not write NumPy file of run %d: %w", dev.Run, err)
+	}
+	ctx.Msg.Infof("wrote %d records to %q", dev.N, fname)
+	return nil
+}
+
+func (dev *NpyWriter) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+func (dev *NpyWriter) reset() {
+	dev.N = 0
+	dev.Skip = 0
+	for i := range dev.cols {
+		dev.cols[i].Reset()
+	}
+}
+
+func (dev *NpyWriter) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	vars, err := dev.Schema.Decode(src.Body)
+	if err != nil {
+		ctx.Msg.Debugf("could not decode input frame: %+v", err)
+		dev.Skip++
+		return nil
+	}
+
+	var buf [8]byte
+	for i, f := range dev.Schema {
+		var b []byte
+		switch v := vars[f.Name].(type) {
+		case bool:
+			b = buf[:1]
+			b[0] = 0
+			if v {
+				b[0] = 1
+			}
+		case int8:
+			b = append(buf[:0], byte(v))
+		case uint8:
+			b = append(buf[:0], v)
+		case int16:
+			b = buf[:2]
+			binary.LittleEndian.PutUint16(b, uint16(v))
+		case uint16:
+			b = buf[:2]
+			binary.LittleEndian.PutUint16(b, v)
+		case int32:
+			b = buf[:4]
+			binary.LittleEndian.PutUint32(b, uint32(v))
+		case uint32:
+			b = buf[:4]
+			binary.LittleEndian.PutUint32(b, v)
+		case int64:
+			b = buf[:8]
+			binary.LittleEndian.PutUint64(b, uint64(v))
+		case uint64:
+			b = buf[:8]
+			binary.LittleEndian.PutUint64(b, v)
+		case float32:
+			b = buf[:4]
+			binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+		case float64:
+			b = buf[:8]
+			binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+		default:
+			return fmt.Errorf("invalid value %T of field %q", v, f.Name)
+		}
+		dev.cols[i].Write(b)
+	}
+	dev.N++
+	return nil
+}
+
+// write writes the payloads of the current run and returns the name of the
+// written file.
+func (dev *NpyWriter) write() (string, error) {
+	fname := filepath.Join(dev.Dir, fmt.Sprintf("%s-%04d.%s", dev.Prefix, dev.Run, dev.Format))
+	f, err := os.Create(fname)
+	if err != nil {
+		return "", fmt.Errorf("could not create NumPy file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	switch dev.Format {
+	case NpyFormat:
+		err = dev.writeNpy(w)
+	default:
+		err = dev.writeNpz(w)
+	}
+	if err != nil {
+		return "", err
+	}
+	err = w.Flush()
+	if err != nil {
+		return "", fmt.Errorf("could not flush NumPy file: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return "", fmt.Errorf("could not close NumPy file: %w", err)
+	}
+	return fname, nil
+}
+
+// writeNpy writes the payloads as a structured array, with one record per
+// payload.
+func (dev *NpyWriter) writeNpy(w io.Writer) error {
+	descr := make([]string, len(dev.Schema))
+	for i, f := range dev.Schema {
+		descr[i] = fmt.Sprintf("(%s, '%s')", npyQuote(f.Name), dev.dtypes[i])
+	}
+	err := writeNpyHeader(w, "["+strings.Join(descr, ", ")+"]", dev.N)
+	if err != nil {
+		return err
+	}
+
+	var (
+		cols = make([][]byte, len(dev.cols))
+		szs  = make([]int, len(dev.cols)) // sizes of the values of the fields
+	)
+	for i := range dev.cols {
+		cols[i] = dev.cols[i].Bytes()
+		if dev.N > 0 {
+			szs[i] = len(cols[i]) / int(dev.N)
+		}
+	}
+	for n := int64(0); n < dev.N; n++ {
+		for i, col := range cols {
+			_, err = w.Write(col[:szs[i]])
+			if err != nil {
+				return fmt.Errorf("could not write NumPy record: %w", err)
+			}
+			cols[i] = col[szs[i]:]
+		}
+	}
+	return nil
+}
+
+// writeNpz writes the payloads as an archive of arrays, one per field,
+// as numpy.savez.
+func (dev *NpyWriter) writeNpz(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for i, f := range dev.Schema {
+		o, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name + ".npy", Method: zip.Store})
+		if err != nil {
+			return fmt.Errorf("could not create NumPy array %q: %w", f.Name, err)
+		}
+		err = writeNpyHeader(o, "'"+dev.dtypes[i]+"'", dev.N)
+		if err != nil {
+			return err
+		}
+		_, err = o.Write(dev.cols[i].Bytes())
+		if err != nil {
+			return fmt.Errorf("could not write NumPy array %q: %w", f.Name, err)
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		return fmt.Errorf("could not close NumPy archive: %w", err)
+	}
+	return nil
+}
+
+// writeNpyHeader writes the header of a one-dimensional NumPy array of n
+// elements of the provided type, padded so the data is 64-byte aligned.
+func writeNpyHeader(w io.Writer, descr string, n int64) error {
+	hdr := fmt.Sprintf("{'descr': %s, 'fortran_order': False, 'shape': (%d,), }", descr, n)
+
+	const (
+		magic = "\x93NUMPY"
+		align = 64
+	)
+	var (
+		major = byte(1)
+		pre   = len(magic) + 2 + 2 // magic, version and header length
+	)
+	if len(hdr)+1+pre+align > math.MaxUint16 {
+		major = 2
+		pre += 2
+	}
+	pad := align - (pre+len(hdr)+1)%align
+	if pad == align {
+		pad = 0
+	}
+	hdr += strings.Repeat(" ", pad) + "\n"
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(magic)
+	buf.Write([]byte{major, 0})
+	switch major {
+	case 1:
+		binary.Write(buf, binary.LittleEndian, uint16(len(hdr)))
+	default:
+		binary.Write(buf, binary.LittleEndian, uint32(len(hdr)))
+	}
+	buf.WriteString(hdr)
+	_, err := w.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("could not write NumPy header: %w", err)
+	}
+	return nil
+}
+
+// npyQuote quotes the provided name as a Python string literal.
+func npyQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -84,8 +84,7 @@ func (dev *Scaler) Output(ctx tdaq.Context, dst *tdaq.Frame) error {
 		dst.Body = nil
 		return nil
 	case data := <-dev.ch:
-		dst.Body = make([]byte, len(data.Body))
-		copy(dst.Body, data.Body)
+		forward(dst, data)
 	}
 	return nil
 }
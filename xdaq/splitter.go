@@ -95,8 +95,7 @@ func (dev *Splitter) Left(ctx tdaq.Context, dst *tdaq.Frame) error {
 		dst.Body = nil
 		return nil
 	case data := <-dev.left:
-		dst.Body = make([]byte, len(data.Body))
-		copy(dst.Body, data.Body)
+		forward(dst, data)
 	}
 	return nil
 }
@@ -107,8 +106,7 @@ func (dev *Splitter) Right(ctx tdaq.Context, dst *tdaq.Frame) error {
 		dst.Body = nil
 		return nil
 	case data := <-dev.right:
-		dst.Body = make([]byte, len(data.Body))
-		copy(dst.Body, data.Body)
+		forward(dst, data)
 	}
 	return nil
 }
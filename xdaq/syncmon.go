@@ -0,0 +1,195 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-daq/tdaq"
+)
+
+// SyncAlarm is the identifier of the alarm raised by a SyncMonitor.
+const SyncAlarm = "sync"
+
+// SyncMonitor cross-checks the trigger numbers of the data frames of
+// several input streams, such as the fragments read out by several crates
+// for each trigger.
+//
+// The n-th data frames of all the streams must carry the same trigger
+// number.
+// When they differ, or when a stream lags more than MaxLag frames behind
+// the others, the streams are out of sync: the monitor raises the
+// SyncAlarm alarm and, with Halt, requests run-ctl to stop the run.
+// The frames with the lowest trigger number are then discarded, so the
+// streams are re-aligned after a lost frame, and the alarm is cleared at
+// the next matching trigger.
+type SyncMonitor struct {
+	Streams []string                // names of the input end-points of the streams
+	Trigger func(tdaq.Frame) uint64 // trigger number of the input frames (default: event ID)
+	MaxLag  int                     // maximum number of frames a stream may be ahead of the others (default: 1024)
+	Halt    bool                    // whether the run is stopped when the streams are out of sync
+
+	N      int64 // number of matched triggers
+	Errors int64 // number of mismatched triggers
+	Lags   int64 // number of frames discarded from streams ahead of the others
+
+	mu  sync.Mutex
+	qs  map[string][]uint64 // pending trigger numbers, by stream
+	bad bool                // whether the alarm is raised
+}
+
+func (dev *SyncMonitor) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	if len(dev.Streams) < 2 {
+		return fmt.Errorf("sync monitor needs at least 2 streams (got=%d)", len(dev.Streams))
+	}
+	if dev.MaxLag < 0 {
+		return fmt.Errorf("invalid sync monitor lag %d", dev.MaxLag)
+	}
+	if dev.MaxLag == 0 {
+		dev.MaxLag = 1024
+	}
+	return nil
+}
+
+func (dev *SyncMonitor) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.reset(ctx)
+	return nil
+}
+
+func (dev *SyncMonitor) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.reset(ctx)
+	return nil
+}
+
+func (dev *SyncMonitor) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	dev.reset(ctx)
+	return nil
+}
+
+func (dev *SyncMonitor) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	var (
+		n    = atomic.LoadInt64(&dev.N)
+		errs = atomic.LoadInt64(&dev.Errors)
+		lags = atomic.LoadInt64(&dev.Lags)
+	)
+	ctx.Msg.Infof("received /stop command... -> n=%d, errors=%d, lags=%d", n, errs, lags)
+	return nil
+}
+
+func (dev *SyncMonitor) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return nil
+}
+
+func (dev *SyncMonitor) reset(ctx tdaq.Context) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	atomic.StoreInt64(&dev.N, 0)
+	atomic.StoreInt64(&dev.Errors, 0)
+	atomic.StoreInt64(&dev.Lags, 0)
+	dev.qs = make(map[string][]uint64, len(dev.Streams))
+	if dev.bad {
+		ctx.Alarms.Clear(SyncAlarm)
+		dev.bad = false
+	}
+}
+
+// Input is the handler of all the input end-points of the monitor.
+func (dev *SyncMonitor) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	trig := src.EventID
+	if dev.Trigger != nil {
+		trig = dev.Trigger(src)
+	}
+
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+
+	q, ok := dev.qs[src.Path]
+	if !ok && !dev.stream(src.Path) {
+		return fmt.Errorf("unknown sync monitor stream %q", src.Path)
+	}
+	q = append(q, trig)
+	if len(q) > dev.MaxLag {
+		atomic.AddInt64(&dev.Lags, 1)
+		dev.alarm(ctx, "stream %q is %d frames ahead of the others (trigger %d)", src.Path, len(q), trig)
+		q = q[1:]
+	}
+	dev.qs[src.Path] = q
+
+	dev.check(ctx)
+	return nil
+}
+
+func (dev *SyncMonitor) stream(name string) bool {
+	for _, s := range dev.Streams {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// check compares the pending trigger numbers of the streams.
+// check must be called with dev.mu held.
+func (dev *SyncMonitor) check(ctx tdaq.Context) {
+	for {
+		var (
+			min, max uint64
+			heads    = make([]string, len(dev.Streams))
+		)
+		for i, s := range dev.Streams {
+			q := dev.qs[s]
+			if len(q) == 0 {
+				return
+			}
+			if i == 0 || q[0] < min {
+				min = q[0]
+			}
+			if i == 0 || q[0] > max {
+				max = q[0]
+			}
+			heads[i] = fmt.Sprintf("%s=%d", s, q[0])
+		}
+
+		if min == max {
+			atomic.AddInt64(&dev.N, 1)
+			if dev.bad {
+				ctx.Alarms.Clear(SyncAlarm)
+				dev.bad = false
+			}
+		} else {
+			atomic.AddInt64(&dev.Errors, 1)
+			dev.alarm(ctx, "streams out of sync: %s", strings.Join(heads, ", "))
+		}
+
+		// discard the frames of the lowest trigger number.
+		for _, s := range dev.Streams {
+			if q := dev.qs[s]; q[0] == min {
+				dev.qs[s] = q[1:]
+			}
+		}
+	}
+}
+
+// alarm raises the alarm of the monitor, if not already raised.
+func (dev *SyncMonitor) alarm(ctx tdaq.Context, format string, args ...interface{}) {
+	if dev.bad {
+		return
+	}
+	dev.bad = true
+	ctx.Msg.Errorf(format, args...)
+	if dev.Halt {
+		ctx.Alarms.Halt(SyncAlarm, format, args...)
+		return
+	}
+	ctx.Alarms.Raise(SyncAlarm, tdaq.SevMajor, format, args...)
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 The go-daq Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-daq/tdaq"
+	"github.com/go-daq/tdaq/payload"
+)
+
+// TextSink writes the payloads of an input end-point, decoded with Schema,
+// as CSV rows or JSON lines, one record per frame, into the files
+// Dir/<Prefix>-<seq>.csv or .jsonl.
+//
+// A new file is opened at each /start, and when the current file exceeds
+// MaxSize bytes or is older than MaxAge.
+// Files are flushed after each record, for low-rate streams.
+type TextSink struct {
+	Schema  payload.Schema // schema of the input payloads (optional)
+	Format  string         // record format (csv or jsonl; default: csv)
+	Dir     string         // directory of the files (default: current directory)
+	Prefix  string         // prefix of the file names (default: sink)
+	MaxSize int64          // maximum size of a file, in bytes (0: unlimited)
+	MaxAge  time.Duration  // maximum age of a file (0: unlimited)
+
+	N     int64    // number of written records
+	Files []string // names of the written files
+
+	dump Dumper
+	f    *countWriter // current file (may be nil)
+	seq  int          // sequence number of the current file
+	t0   time.Time    // opening time of the current file
+}
+
+type countWriter struct {
+	f *os.File
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (dev *TextSink) OnConfig(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /config command...")
+	switch dev.Format {
+	case "":
+		dev.Format = DumpCSV
+	case DumpCSV, DumpJSONL:
+	default:
+		return fmt.Errorf("invalid text sink format %q", dev.Format)
+	}
+	if dev.MaxSize < 0 || dev.MaxAge < 0 {
+		return fmt.Errorf("invalid text sink rotation (size=%d, age=%v)", dev.MaxSize, dev.MaxAge)
+	}
+	if dev.Prefix == "" {
+		dev.Prefix = "sink"
+	}
+	return nil
+}
+
+func (dev *TextSink) OnInit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /init command...")
+	dev.N = 0
+	return nil
+}
+
+func (dev *TextSink) OnReset(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /reset command...")
+	dev.N = 0
+	return dev.close()
+}
+
+func (dev *TextSink) OnStart(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /start command...")
+	return dev.rotate(ctx)
+}
+
+func (dev *TextSink) OnStop(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Infof("received /stop command... -> n=%d", dev.N)
+	return dev.close()
+}
+
+func (dev *TextSink) OnQuit(ctx tdaq.Context, resp *tdaq.Frame, req tdaq.Frame) error {
+	ctx.Msg.Debugf("received /quit command...")
+	return dev.close()
+}
+
+func (dev *TextSink) Input(ctx tdaq.Context, src tdaq.Frame) error {
+	switch {
+	case dev.f == nil:
+		return fmt.Errorf("text sink is not open")
+	case dev.MaxSize > 0 && dev.f.n >= dev.MaxSize,
+		dev.MaxAge > 0 && time.Since(dev.t0) >= dev.MaxAge:
+		err := dev.rotate(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := dev.dump.Input(ctx, src)
+	if err != nil {
+		return err
+	}
+	dev.N++
+	return nil
+}
+
+// rotate closes the current file, if any, and opens the next one.
+func (dev *TextSink) rotate(ctx tdaq.Context) error {
+	err := dev.close()
+	if err != nil {
+		return err
+	}
+
+	dev.seq++
+	fname := filepath.Join(dev.Dir, fmt.Sprintf("%s-%04d.%s", dev.Prefix, dev.seq, dev.Format))
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("could not create text sink file: %w", err)
+	}
+	dev.f = &countWriter{f: f}
+	dev.t0 = time.Now()
+	dev.Files = append(dev.Files, fname)
+
+	dev.dump = Dumper{Schema: dev.Schema, Format: dev.Format, W: dev.f}
+	err = dev.dump.OnConfig(ctx, nil, tdaq.Frame{})
+	if err != nil {
+		_ = dev.close()
+		return fmt.Errorf("could not configure text sink: %w", err)
+	}
+	ctx.Msg.Debugf("opened text sink file %q", fname)
+	return nil
+}
+
+// close flushes and closes the current file, if any.
+func (dev *TextSink) close() error {
+	if dev.f == nil {
+		return nil
+	}
+	f := dev.f
+	dev.f = nil
+
+	err := dev.dump.flush()
+	if err != nil {
+		_ = f.f.Close()
+		return err
+	}
+	err = f.f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close text sink file: %w", err)
+	}
+	return nil
+}
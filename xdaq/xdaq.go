@@ -4,3 +4,34 @@
 
 // Package xdaq provides components for filtering and exercizing TDAQ sequences.
 package xdaq // import "github.com/go-daq/tdaq/xdaq"
+
+import "github.com/go-daq/tdaq"
+
+var (
+	_ tdaq.Device = (*Distributor)(nil)
+	_ tdaq.Device = (*Downsampler)(nil)
+	_ tdaq.Device = (*EventBuilder)(nil)
+	_ tdaq.Device = (*Dumper)(nil)
+	_ tdaq.Device = (*FileSrc)(nil)
+	_ tdaq.Device = (*Filter)(nil)
+	_ tdaq.Device = (*Histogrammer)(nil)
+	_ tdaq.Device = (*I64Adder)(nil)
+	_ tdaq.Device = (*I64Dumper)(nil)
+	_ tdaq.Device = (*I64Gen)(nil)
+	_ tdaq.Device = (*I64Processor)(nil)
+	_ tdaq.Device = (*Merger)(nil)
+	_ tdaq.Device = (*NpyWriter)(nil)
+	_ tdaq.Device = (*Scaler)(nil)
+	_ tdaq.Device = (*Splitter)(nil)
+	_ tdaq.Device = (*SyncMonitor)(nil)
+	_ tdaq.Device = (*TextSink)(nil)
+)
+
+// forward copies the payload and the event tags of the src data frame
+// into dst, so event IDs and traces are propagated through the stage.
+func forward(dst *tdaq.Frame, src tdaq.Frame) {
+	dst.Body = make([]byte, len(src.Body))
+	copy(dst.Body, src.Body)
+	dst.EventID = src.EventID
+	dst.Trace = src.Trace
+}
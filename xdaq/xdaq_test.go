@@ -5,17 +5,28 @@
 package xdaq_test // import "github.com/go-daq/tdaq/xdaq"
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-daq/tdaq"
-	"github.com/go-daq/tdaq/internal/iomux"
 	"github.com/go-daq/tdaq/internal/tcputil"
+	"github.com/go-daq/tdaq/iomux"
 	"github.com/go-daq/tdaq/job"
 	"github.com/go-daq/tdaq/log"
+	"github.com/go-daq/tdaq/payload"
 	"github.com/go-daq/tdaq/xdaq"
 )
 
@@ -1073,3 +1084,1265 @@ func BenchmarkTOF(b *testing.B) {
 		b.Fatalf("error shutting down tdaq app: %+v", err)
 	}
 }
+
+func TestFileSrc(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "tdaq-xdaq-")
+	if err != nil {
+		t.Fatalf("could not create tmp dir: %+v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for name, data := range map[string]string{
+		"evt-1.raw": "hello",
+		"evt-2.raw": "world!!",
+	} {
+		err := ioutil.WriteFile(filepath.Join(tmp, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatalf("could not create payload file: %+v", err)
+		}
+	}
+	err = os.Mkdir(filepath.Join(tmp, "subdir"), 0755)
+	if err != nil {
+		t.Fatalf("could not create subdir: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		size   int
+		repeat bool
+		n      int
+		want   []string
+	}{
+		{
+			name: "dir",
+			path: tmp,
+			n:    2,
+			want: []string{"hello", "world!!"},
+		},
+		{
+			name: "dir-records",
+			path: tmp,
+			size: 4,
+			n:    4,
+			want: []string{"hell", "o", "worl", "d!!"},
+		},
+		{
+			name: "file-records",
+			path: filepath.Join(tmp, "evt-2.raw"),
+			size: 3,
+			n:    3,
+			want: []string{"wor", "ld!", "!"},
+		},
+		{
+			name:   "dir-repeat",
+			path:   tmp,
+			repeat: true,
+			n:      5,
+			want:   []string{"hello", "world!!", "hello", "world!!", "hello"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tctx := tdaq.Context{
+				Ctx: ctx,
+				Msg: log.NewMsgStream(tc.name, log.LvlError, ioutil.Discard),
+			}
+
+			dev := xdaq.FileSrc{Path: tc.path, Size: tc.size, Repeat: tc.repeat}
+			err := dev.OnConfig(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /config: %+v", err)
+			}
+			err = dev.OnInit(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /init: %+v", err)
+			}
+
+			done := make(chan error)
+			go func() {
+				done <- dev.Loop(tctx)
+			}()
+
+			var got []string
+			for i := 0; i < tc.n; i++ {
+				var dst tdaq.Frame
+				err := dev.Output(tctx, &dst)
+				if err != nil {
+					t.Fatalf("could not read payload %d: %+v", i, err)
+				}
+				got = append(got, string(dst.Body))
+			}
+			cancel()
+
+			err = <-done
+			if err != nil {
+				t.Fatalf("could not run file source: %+v", err)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid payloads:\ngot = %q\nwant= %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDistributor(t *testing.T) {
+	frames := make([]tdaq.Frame, 8)
+	for i := range frames {
+		frames[i].Body = []byte{byte(i % 2), byte(i)}
+	}
+
+	for _, tc := range []struct {
+		name string
+		dev  xdaq.Distributor
+		want [][]byte // indices of the input frames, per output end-point
+	}{
+		{
+			name: "round-robin",
+			dev:  xdaq.Distributor{N: 3},
+			want: [][]byte{{0, 3, 6}, {1, 4, 7}, {2, 5}},
+		},
+		{
+			name: "fct",
+			dev: xdaq.Distributor{N: 2, Fct: func(frame tdaq.Frame) int {
+				if frame.Body[1] < 5 {
+					return 0
+				}
+				return 1
+			}},
+			want: [][]byte{{0, 1, 2, 3, 4}, {5, 6, 7}},
+		},
+		{
+			name: "key",
+			dev:  xdaq.Distributor{N: 4, Key: xdaq.KeyRange(0, 1)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tctx := tdaq.Context{
+				Ctx: ctx,
+				Msg: log.NewMsgStream(tc.name, log.LvlError, ioutil.Discard),
+			}
+
+			dev := new(xdaq.Distributor)
+			*dev = tc.dev
+			err := dev.OnConfig(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /config: %+v", err)
+			}
+			err = dev.OnInit(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /init: %+v", err)
+			}
+
+			var (
+				got  = make([][]byte, dev.N)
+				outs = make(chan [2]int)
+				grp  sync.WaitGroup
+			)
+			defer grp.Wait()
+			defer cancel()
+
+			grp.Add(dev.N + 1)
+			for i := 0; i < dev.N; i++ {
+				go func(i int) {
+					defer grp.Done()
+					h := dev.Output(i)
+					for {
+						var dst tdaq.Frame
+						_ = h(tctx, &dst)
+						if dst.Body == nil {
+							return
+						}
+						outs <- [2]int{i, int(dst.Body[1])}
+					}
+				}(i)
+			}
+
+			go func() {
+				defer grp.Done()
+				for _, frame := range frames {
+					_ = dev.Input(tctx, frame)
+				}
+			}()
+
+			for range frames {
+				out := <-outs
+				got[out[0]] = append(got[out[0]], byte(out[1]))
+			}
+
+			if tc.want == nil {
+				// frames with the same key end up on the same output end-point.
+				for i, vs := range got {
+					for _, v := range vs {
+						if v%2 != vs[0]%2 {
+							t.Fatalf("output %d: frames with different keys: %v", i, vs)
+						}
+					}
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid distribution:\ngot = %v\nwant= %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMerger(t *testing.T) {
+	frame := func(key uint64) tdaq.Frame {
+		body := make([]byte, 8)
+		binary.LittleEndian.PutUint64(body, key)
+		return tdaq.Frame{Body: body}
+	}
+
+	for _, tc := range []struct {
+		name string
+		dev  func() *xdaq.Merger
+		keys []uint64
+		want []uint64
+		late int64
+	}{
+		{
+			name: "arrival",
+			dev:  func() *xdaq.Merger { return &xdaq.Merger{} },
+			keys: []uint64{3, 1, 2, 5, 4},
+			want: []uint64{3, 1, 2, 5, 4},
+		},
+		{
+			name: "window",
+			dev:  func() *xdaq.Merger { return &xdaq.Merger{Order: xdaq.U64Key(0), Window: 2} },
+			keys: []uint64{3, 1, 2, 6, 5, 4, 7},
+			want: []uint64{1, 2, 3, 4, 5},
+		},
+		{
+			name: "late",
+			dev:  func() *xdaq.Merger { return &xdaq.Merger{Order: xdaq.U64Key(0), Window: 1} },
+			keys: []uint64{3, 4, 1, 5},
+			want: []uint64{3, 1, 4},
+			late: 1,
+		},
+		{
+			name: "delay",
+			dev: func() *xdaq.Merger {
+				return &xdaq.Merger{Order: xdaq.U64Key(0), Window: 10, MaxDelay: 10 * time.Millisecond}
+			},
+			keys: []uint64{3, 1, 2},
+			want: []uint64{1, 2, 3},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tctx := tdaq.Context{
+				Ctx: ctx,
+				Msg: log.NewMsgStream(tc.name, log.LvlError, ioutil.Discard),
+			}
+
+			dev := tc.dev()
+			err := dev.OnInit(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /init: %+v", err)
+			}
+
+			var grp sync.WaitGroup
+			defer grp.Wait()
+			defer cancel()
+
+			grp.Add(2)
+			go func() {
+				defer grp.Done()
+				_ = dev.Loop(tctx)
+			}()
+			go func() {
+				defer grp.Done()
+				for _, key := range tc.keys {
+					_ = dev.Input(tctx, frame(key))
+				}
+			}()
+
+			var got []uint64
+			for range tc.want {
+				var dst tdaq.Frame
+				err := dev.Output(tctx, &dst)
+				if err != nil {
+					t.Fatalf("could not read output: %+v", err)
+				}
+				got = append(got, binary.LittleEndian.Uint64(dst.Body))
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid merged stream:\ngot = %v\nwant= %v", got, tc.want)
+			}
+			if got, want := atomic.LoadInt64(&dev.Late), tc.late; got != want {
+				t.Fatalf("invalid number of late frames: got=%d, want=%d", got, want)
+			}
+		})
+	}
+}
+
+func TestEventBuilder(t *testing.T) {
+	type input struct {
+		path string
+		key  uint64
+	}
+	type event struct {
+		id    uint64
+		time  uint64
+		frags int
+	}
+	frame := func(in input) tdaq.Frame {
+		body := make([]byte, 8)
+		binary.LittleEndian.PutUint64(body, in.key)
+		return tdaq.Frame{Path: in.path, Body: body, EventID: in.key}
+	}
+
+	for _, tc := range []struct {
+		name string
+		dev  func() *xdaq.EventBuilder
+		ins  []input
+		want []event
+		late int64
+		inc  int64
+	}{
+		{
+			name: "event-id",
+			dev: func() *xdaq.EventBuilder {
+				return &xdaq.EventBuilder{Streams: []string{"/a", "/b"}}
+			},
+			ins:  []input{{"/a", 1}, {"/b", 1}, {"/a", 2}, {"/a", 3}, {"/b", 3}},
+			want: []event{{1, 0, 2}, {2, 0, 1}, {3, 0, 2}},
+			inc:  1,
+		},
+		{
+			name: "event-id-delay",
+			dev: func() *xdaq.EventBuilder {
+				return &xdaq.EventBuilder{Streams: []string{"/a", "/b"}, MaxDelay: 10 * time.Millisecond}
+			},
+			ins:  []input{{"/a", 1}},
+			want: []event{{1, 0, 1}},
+			inc:  1,
+		},
+		{
+			name: "time-window",
+			dev: func() *xdaq.EventBuilder {
+				return &xdaq.EventBuilder{Streams: []string{"/a", "/b"}, Time: xdaq.U64Key(0), Window: 10}
+			},
+			ins:  []input{{"/a", 100}, {"/b", 105}, {"/a", 112}, {"/b", 130}, {"/a", 131}, {"/b", 108}},
+			want: []event{{1, 100, 2}, {2, 112, 1}, {3, 108, 1}},
+			late: 1,
+			inc:  2,
+		},
+		{
+			name: "time-window-drop-late",
+			dev: func() *xdaq.EventBuilder {
+				return &xdaq.EventBuilder{Streams: []string{"/a", "/b"}, Time: xdaq.U64Key(0), Window: 10, DropLate: true}
+			},
+			ins:  []input{{"/a", 100}, {"/b", 105}, {"/a", 112}, {"/b", 130}, {"/a", 131}, {"/b", 108}},
+			want: []event{{1, 100, 2}, {2, 112, 1}},
+			late: 1,
+			inc:  1,
+		},
+		{
+			name: "time-window-lateness",
+			dev: func() *xdaq.EventBuilder {
+				return &xdaq.EventBuilder{Streams: []string{"/a", "/b"}, Time: xdaq.U64Key(0), Window: 10, Lateness: 20}
+			},
+			ins:  []input{{"/a", 100}, {"/b", 105}, {"/a", 112}, {"/b", 108}, {"/b", 130}, {"/a", 131}},
+			want: []event{{1, 100, 3}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tctx := tdaq.Context{
+				Ctx: ctx,
+				Msg: log.NewMsgStream(tc.name, log.LvlError, ioutil.Discard),
+			}
+
+			dev := tc.dev()
+			err := dev.OnConfig(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /config: %+v", err)
+			}
+			err = dev.OnInit(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /init: %+v", err)
+			}
+
+			var grp sync.WaitGroup
+			defer grp.Wait()
+			defer cancel()
+
+			grp.Add(2)
+			go func() {
+				defer grp.Done()
+				_ = dev.Loop(tctx)
+			}()
+			go func() {
+				defer grp.Done()
+				for _, in := range tc.ins {
+					err := dev.Input(tctx, frame(in))
+					if err != nil {
+						t.Errorf("could not process input %v: %+v", in, err)
+					}
+				}
+			}()
+
+			var got []event
+			for range tc.want {
+				var dst tdaq.Frame
+				err := dev.Output(tctx, &dst)
+				if err != nil {
+					t.Fatalf("could not read output: %+v", err)
+				}
+				var evt xdaq.Event
+				err = evt.UnmarshalTDAQ(dst.Body)
+				if err != nil {
+					t.Fatalf("could not decode event: %+v", err)
+				}
+				if dst.EventID != evt.ID {
+					t.Fatalf("invalid event ID: got=%d, want=%d", dst.EventID, evt.ID)
+				}
+				got = append(got, event{evt.ID, evt.Time, len(evt.Frags)})
+			}
+			cancel()
+			grp.Wait()
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid events:\ngot = %v\nwant= %v", got, tc.want)
+			}
+			if got, want := atomic.LoadInt64(&dev.Late), tc.late; got != want {
+				t.Fatalf("invalid number of late fragments: got=%d, want=%d", got, want)
+			}
+			if got, want := atomic.LoadInt64(&dev.Incomplete), tc.inc; got != want {
+				t.Fatalf("invalid number of incomplete events: got=%d, want=%d", got, want)
+			}
+		})
+	}
+
+	dev := &xdaq.EventBuilder{}
+	err := dev.OnConfig(tdaq.Context{Msg: log.NewMsgStream("eb", log.LvlError, ioutil.Discard)}, nil, tdaq.Frame{})
+	if err == nil {
+		t.Fatalf("expected an error for an event builder without streams")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	sch := payload.Schema{{Name: "evt", Type: "u64"}, {Name: "energy", Type: "f64"}}
+	frame := func(evt uint64, energy float64) tdaq.Frame {
+		buf := new(bytes.Buffer)
+		enc := tdaq.NewEncoder(buf)
+		enc.WriteU64(evt)
+		enc.WriteF64(energy)
+		return tdaq.Frame{Body: buf.Bytes()}
+	}
+
+	for _, tc := range []struct {
+		name string
+		expr string
+		want []uint64
+		err  bool
+	}{
+		{
+			name: "all",
+			expr: "true",
+			want: []uint64{0, 1, 2, 3, 4},
+		},
+		{
+			name: "energy",
+			expr: "energy > 2.5",
+			want: []uint64{3, 4},
+		},
+		{
+			name: "odd-evts",
+			expr: "evt % 2 == 1 || energy < 0",
+			want: []uint64{1, 3, 5},
+		},
+		{
+			name: "invalid-syntax",
+			expr: "energy >",
+			err:  true,
+		},
+		{
+			name: "invalid-field",
+			expr: "adc > 2",
+			err:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tctx := tdaq.Context{
+				Ctx: ctx,
+				Msg: log.NewMsgStream("filter-"+tc.name, log.LvlError, ioutil.Discard),
+			}
+
+			dev := xdaq.Filter{Schema: sch, Expr: tc.expr}
+			err := dev.OnConfig(tctx, nil, tdaq.Frame{})
+			switch {
+			case err != nil && tc.err:
+				return
+			case err != nil:
+				t.Fatalf("could not /config: %+v", err)
+			case tc.err:
+				t.Fatalf("expected an error at /config")
+			}
+
+			err = dev.OnInit(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /init: %+v", err)
+			}
+
+			var grp sync.WaitGroup
+			defer grp.Wait()
+			defer cancel()
+
+			grp.Add(1)
+			go func() {
+				defer grp.Done()
+				for i := 0; i < 5; i++ {
+					_ = dev.Input(tctx, frame(uint64(i), float64(i)))
+				}
+				_ = dev.Input(tctx, tdaq.Frame{Body: []byte{1, 2, 3}})
+				_ = dev.Input(tctx, frame(5, -1))
+			}()
+
+			var got []uint64
+			for range tc.want {
+				var dst tdaq.Frame
+				err := dev.Output(tctx, &dst)
+				if err != nil {
+					t.Fatalf("could not read output: %+v", err)
+				}
+				got = append(got, binary.LittleEndian.Uint64(dst.Body))
+			}
+			cancel()
+			grp.Wait()
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid filtered stream:\ngot = %v\nwant= %v", got, tc.want)
+			}
+			if got, want := dev.Tot, int64(7); got != want {
+				t.Fatalf("invalid number of input frames: got=%d, want=%d", got, want)
+			}
+			if got, want := dev.Invalid, int64(1); got != want {
+				t.Fatalf("invalid number of invalid frames: got=%d, want=%d", got, want)
+			}
+		})
+	}
+}
+
+func TestDownsampler(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		every int
+		rate  float64
+		n     int
+		want  []uint64
+	}{
+		{
+			name: "all",
+			n:    5,
+			want: []uint64{0, 1, 2, 3, 4},
+		},
+		{
+			name:  "every-3",
+			every: 3,
+			n:     10,
+			want:  []uint64{2, 5, 8},
+		},
+		{
+			name: "rate",
+			rate: 0.1,
+			n:    10,
+			want: []uint64{0},
+		},
+		{
+			name:  "every-2-rate",
+			every: 2,
+			rate:  0.1,
+			n:     10,
+			want:  []uint64{1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tctx := tdaq.Context{
+				Ctx: ctx,
+				Msg: log.NewMsgStream("downsampler-"+tc.name, log.LvlError, ioutil.Discard),
+			}
+
+			dev := xdaq.Downsampler{Every: tc.every, Rate: tc.rate}
+			err := dev.OnConfig(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /config: %+v", err)
+			}
+			err = dev.OnInit(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /init: %+v", err)
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < tc.n; i++ {
+					body := make([]byte, 8)
+					binary.LittleEndian.PutUint64(body, uint64(i))
+					_ = dev.Input(tctx, tdaq.Frame{Body: body})
+				}
+			}()
+
+			var got []uint64
+			for range tc.want {
+				var dst tdaq.Frame
+				err := dev.Output(tctx, &dst)
+				if err != nil {
+					t.Fatalf("could not read output: %+v", err)
+				}
+				got = append(got, binary.LittleEndian.Uint64(dst.Body))
+			}
+			<-done
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("invalid downsampled stream:\ngot = %v\nwant= %v", got, tc.want)
+			}
+			if got, want := dev.Kept, int64(len(tc.want)); got != want {
+				t.Fatalf("invalid number of kept frames: got=%d, want=%d", got, want)
+			}
+			if got, want := dev.Discarded, int64(tc.n-len(tc.want)); got != want {
+				t.Fatalf("invalid number of discarded frames: got=%d, want=%d", got, want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		tctx := tdaq.Context{
+			Ctx: context.Background(),
+			Msg: log.NewMsgStream("downsampler", log.LvlError, ioutil.Discard),
+		}
+		for _, dev := range []xdaq.Downsampler{{Every: -1}, {Rate: -1}} {
+			err := dev.OnConfig(tctx, nil, tdaq.Frame{})
+			if err == nil {
+				t.Fatalf("expected an error for %+v", dev)
+			}
+		}
+	})
+}
+
+func TestHistogrammer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tctx := tdaq.Context{
+		Ctx: ctx,
+		Msg: log.NewMsgStream("histogrammer", log.LvlError, ioutil.Discard),
+	}
+
+	defs, err := xdaq.ParseHistDefs("energy:10:0:10;ch:4:0:4:energy:5:0:10")
+	if err != nil {
+		t.Fatalf("could not parse histogram definitions: %+v", err)
+	}
+
+	dev := &xdaq.Histogrammer{
+		Schema: payload.Schema{{Name: "ch", Type: "u8"}, {Name: "energy", Type: "f64"}, {Name: "name", Type: "str"}},
+		Hists:  defs,
+		Period: 10 * time.Millisecond,
+	}
+	for _, cmd := range []func(tdaq.Context, *tdaq.Frame, tdaq.Frame) error{
+		dev.OnConfig, dev.OnInit, dev.OnStart,
+	} {
+		err := cmd(tctx, nil, tdaq.Frame{})
+		if err != nil {
+			t.Fatalf("could not run command: %+v", err)
+		}
+	}
+
+	frame := func(ch uint8, energy float64) tdaq.Frame {
+		buf := new(bytes.Buffer)
+		enc := tdaq.NewEncoder(buf)
+		enc.WriteU8(ch)
+		enc.WriteF64(energy)
+		enc.WriteStr("adc")
+		return tdaq.Frame{Body: buf.Bytes()}
+	}
+	for _, src := range []tdaq.Frame{
+		frame(0, 0.5), frame(1, 2.5), frame(1, 2.7), frame(3, 9.9),
+		frame(5, -1), frame(2, 12),
+		{Body: []byte{1}},
+	} {
+		err := dev.Input(tctx, src)
+		if err != nil {
+			t.Fatalf("could not fill histograms: %+v", err)
+		}
+	}
+
+	var grp sync.WaitGroup
+	defer grp.Wait()
+	defer cancel()
+
+	grp.Add(1)
+	go func() {
+		defer grp.Done()
+		_ = dev.Loop(tctx)
+	}()
+
+	var dst tdaq.Frame
+	err = dev.Output(tctx, &dst)
+	if err != nil {
+		t.Fatalf("could not read output: %+v", err)
+	}
+	var snap xdaq.HistSnapshot
+	err = snap.UnmarshalTDAQ(dst.Body)
+	if err != nil {
+		t.Fatalf("could not unmarshal snapshot: %+v", err)
+	}
+
+	if got, want := len(snap.H1Ds), 1; got != want {
+		t.Fatalf("invalid number of 1D histograms: got=%d, want=%d", got, want)
+	}
+	h1 := snap.H1Ds[0]
+	if got, want := h1.Bins, []float64{1, 0, 2, 0, 0, 0, 0, 0, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid 1D bins:\ngot = %v\nwant= %v", got, want)
+	}
+	if h1.Name != "energy" || h1.Under != 1 || h1.Over != 1 || h1.Entries != 6 {
+		t.Fatalf("invalid 1D histogram: %+v", h1)
+	}
+	if got, want := h1.Mean(), (0.5+2.5+2.7+9.9-1+12)/6; math.Abs(got-want) > 1e-12 {
+		t.Fatalf("invalid mean: got=%v, want=%v", got, want)
+	}
+
+	if got, want := len(snap.H2Ds), 1; got != want {
+		t.Fatalf("invalid number of 2D histograms: got=%d, want=%d", got, want)
+	}
+	h2 := snap.H2Ds[0]
+	want2 := make([]float64, 4*5)
+	want2[0*4+0] = 1 // (0, 0.5)
+	want2[1*4+1] = 2 // (1, 2.5), (1, 2.7)
+	want2[4*4+3] = 1 // (3, 9.9)
+	if !reflect.DeepEqual(h2.Bins, want2) {
+		t.Fatalf("invalid 2D bins:\ngot = %v\nwant= %v", h2.Bins, want2)
+	}
+	if h2.Name != "ch:energy" || h2.Outside != 2 || h2.Entries != 6 {
+		t.Fatalf("invalid 2D histogram: %+v", h2)
+	}
+
+	if got, want := dev.Invalid, int64(1); got != want {
+		t.Fatalf("invalid number of invalid frames: got=%d, want=%d", got, want)
+	}
+
+	err = dev.OnStart(tctx, nil, tdaq.Frame{})
+	if err != nil {
+		t.Fatalf("could not /start: %+v", err)
+	}
+	snap = dev.Snapshot()
+	if snap.H1Ds[0].Entries != 0 || snap.H2Ds[0].Entries != 0 {
+		t.Fatalf("histograms not reset at /start")
+	}
+
+	for _, hists := range []string{"adc:10:0:1", "name:10:0:1", "energy:0:0:1", "energy:10:1:0", "energy:10"} {
+		defs, err := xdaq.ParseHistDefs(hists)
+		if err != nil {
+			continue
+		}
+		dev := &xdaq.Histogrammer{Schema: dev.Schema, Hists: defs}
+		err = dev.OnConfig(tctx, nil, tdaq.Frame{})
+		if err == nil {
+			t.Fatalf("expected an error for hists=%q", hists)
+		}
+	}
+
+	// definitions provided through the Go API are validated too.
+	for _, def := range []xdaq.HistDef{
+		{X: xdaq.Axis{Field: "energy", Bins: 0, Min: 0, Max: 1}},
+		{X: xdaq.Axis{Field: "energy", Bins: -1, Min: 0, Max: 1}},
+		{X: xdaq.Axis{Field: "energy", Bins: 10, Min: 1, Max: 1}},
+		{X: xdaq.Axis{Field: "energy", Bins: 10, Min: 0, Max: math.NaN()}},
+		{
+			X: xdaq.Axis{Field: "ch", Bins: 4, Min: 0, Max: 4},
+			Y: &xdaq.Axis{Field: "energy", Bins: 0, Min: 0, Max: 10},
+		},
+	} {
+		dev := &xdaq.Histogrammer{Schema: dev.Schema, Hists: []xdaq.HistDef{def}}
+		err = dev.OnConfig(tctx, nil, tdaq.Frame{})
+		if err == nil {
+			t.Fatalf("expected an error for hist=%v", def)
+		}
+	}
+}
+
+func TestDumper(t *testing.T) {
+	sch := payload.Schema{{Name: "evt", Type: "u64"}, {Name: "energy", Type: "f64"}, {Name: "name", Type: "str"}}
+	frame := func(evt uint64, energy float64, name string) tdaq.Frame {
+		buf := new(bytes.Buffer)
+		enc := tdaq.NewEncoder(buf)
+		enc.WriteU64(evt)
+		enc.WriteF64(energy)
+		enc.WriteStr(name)
+		return tdaq.Frame{Body: buf.Bytes()}
+	}
+	frames := []tdaq.Frame{
+		frame(1, 2.5, "adc"),
+		frame(2, -1, "a b"),
+		{Body: []byte{0xca, 0xfe}},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		schema payload.Schema
+		format string
+		rate   float64
+		want   string
+	}{
+		{
+			name:   "text",
+			schema: sch,
+			want: `n=1 evt=1 energy=2.5 name=adc
+n=2 evt=2 energy=-1 name="a b"
+n=3 body=cafe
+`,
+		},
+		{
+			name:   "jsonl",
+			schema: sch,
+			format: xdaq.DumpJSONL,
+			want: `{"evt":1,"energy":2.5,"name":"adc"}
+{"evt":2,"energy":-1,"name":"a b"}
+{"body":"cafe"}
+`,
+		},
+		{
+			name:   "csv",
+			schema: sch,
+			format: xdaq.DumpCSV,
+			want: `evt,energy,name
+1,2.5,adc
+2,-1,a b
+cafe
+`,
+		},
+		{
+			name: "raw",
+			want: fmt.Sprintf("n=1 body=%x\nn=2 body=%x\nn=3 body=cafe\n", frames[0].Body, frames[1].Body),
+		},
+		{
+			name:   "sampled",
+			schema: sch,
+			rate:   0.1,
+			want:   "n=1 evt=1 energy=2.5 name=adc\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tctx := tdaq.Context{
+				Ctx: context.Background(),
+				Msg: log.NewMsgStream("dumper-"+tc.name, log.LvlError, ioutil.Discard),
+			}
+
+			out := new(bytes.Buffer)
+			dev := xdaq.Dumper{Schema: tc.schema, Format: tc.format, Rate: tc.rate, W: out}
+			for _, cmd := range []func(tdaq.Context, *tdaq.Frame, tdaq.Frame) error{
+				dev.OnConfig, dev.OnInit, dev.OnStart,
+			} {
+				err := cmd(tctx, nil, tdaq.Frame{})
+				if err != nil {
+					t.Fatalf("could not run command: %+v", err)
+				}
+			}
+
+			for _, src := range frames {
+				err := dev.Input(tctx, src)
+				if err != nil {
+					t.Fatalf("could not dump frame: %+v", err)
+				}
+			}
+
+			err := dev.OnStop(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /stop: %+v", err)
+			}
+
+			if got, want := out.String(), tc.want; got != want {
+				t.Fatalf("invalid dump:\ngot = %q\nwant= %q", got, want)
+			}
+			if got, want := dev.Tot, int64(len(frames)); got != want {
+				t.Fatalf("invalid number of input frames: got=%d, want=%d", got, want)
+			}
+		})
+	}
+}
+
+func TestNpyWriter(t *testing.T) {
+	sch := payload.Schema{{Name: "evt", Type: "u32"}, {Name: "energy", Type: "f64"}, {Name: "ok", Type: "bool"}}
+	frame := func(evt uint32, energy float64, ok bool) tdaq.Frame {
+		buf := new(bytes.Buffer)
+		enc := tdaq.NewEncoder(buf)
+		enc.WriteU32(evt)
+		enc.WriteF64(energy)
+		enc.WriteBool(ok)
+		return tdaq.Frame{Body: buf.Bytes()}
+	}
+	frames := []tdaq.Frame{
+		frame(1, 2.5, true),
+		{Body: []byte{0xca, 0xfe}},
+		frame(2, -1, false),
+	}
+
+	// readNpy returns the header and the data of a NumPy array.
+	readNpy := func(t *testing.T, raw []byte) (string, []byte) {
+		t.Helper()
+		if !bytes.HasPrefix(raw, []byte("\x93NUMPY\x01\x00")) {
+			t.Fatalf("invalid NumPy magic: %q", raw[:8])
+		}
+		n := 10 + int(binary.LittleEndian.Uint16(raw[8:10]))
+		if n%64 != 0 {
+			t.Fatalf("invalid NumPy header alignment: %d", n)
+		}
+		return string(raw[10:n]), raw[n:]
+	}
+
+	for _, tc := range []struct {
+		format string
+		check  func(t *testing.T, raw []byte)
+	}{
+		{
+			format: xdaq.NpyFormat,
+			check: func(t *testing.T, raw []byte) {
+				hdr, data := readNpy(t, raw)
+				want := "{'descr': [('evt', '<u4'), ('energy', '<f8'), ('ok', '|b1')], 'fortran_order': False, 'shape': (2,), }"
+				if got := strings.TrimRight(hdr, " \n"); got != want {
+					t.Fatalf("invalid header:\ngot = %q\nwant= %q", got, want)
+				}
+				rec := make([]byte, 13)
+				binary.LittleEndian.PutUint32(rec[0:], 2)
+				binary.LittleEndian.PutUint64(rec[4:], math.Float64bits(-1))
+				if got, want := len(data), 2*len(rec); got != want {
+					t.Fatalf("invalid data size: got=%d, want=%d", got, want)
+				}
+				if got, want := data[13:], rec; !bytes.Equal(got, want) {
+					t.Fatalf("invalid record:\ngot = %v\nwant= %v", got, want)
+				}
+			},
+		},
+		{
+			format: xdaq.NpzFormat,
+			check: func(t *testing.T, raw []byte) {
+				zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+				if err != nil {
+					t.Fatalf("could not open NumPy archive: %+v", err)
+				}
+				want := map[string]string{
+					"evt.npy":    "'<u4'",
+					"energy.npy": "'<f8'",
+					"ok.npy":     "'|b1'",
+				}
+				if got, want := len(zr.File), len(want); got != want {
+					t.Fatalf("invalid number of arrays: got=%d, want=%d", got, want)
+				}
+				for _, f := range zr.File {
+					r, err := f.Open()
+					if err != nil {
+						t.Fatalf("could not open array %q: %+v", f.Name, err)
+					}
+					raw, err := ioutil.ReadAll(r)
+					r.Close()
+					if err != nil {
+						t.Fatalf("could not read array %q: %+v", f.Name, err)
+					}
+					hdr, data := readNpy(t, raw)
+					if !strings.Contains(hdr, "'descr': "+want[f.Name]+",") || !strings.Contains(hdr, "'shape': (2,)") {
+						t.Fatalf("invalid header of array %q: %q", f.Name, hdr)
+					}
+					if f.Name == "ok.npy" && !bytes.Equal(data, []byte{1, 0}) {
+						t.Fatalf("invalid data of array %q: %v", f.Name, data)
+					}
+				}
+			},
+		},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			tctx := tdaq.Context{
+				Ctx: context.Background(),
+				Msg: log.NewMsgStream("npy-"+tc.format, log.LvlError, ioutil.Discard),
+			}
+
+			dir, err := ioutil.TempDir("", "tdaq-npy-")
+			if err != nil {
+				t.Fatalf("could not create tmp dir: %+v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			dev := xdaq.NpyWriter{Schema: sch, Format: tc.format, Dir: dir}
+			for _, cmd := range []func(tdaq.Context, *tdaq.Frame, tdaq.Frame) error{
+				dev.OnConfig, dev.OnInit, dev.OnStart,
+			} {
+				err := cmd(tctx, nil, tdaq.Frame{})
+				if err != nil {
+					t.Fatalf("could not run command: %+v", err)
+				}
+			}
+			for _, src := range frames {
+				err := dev.Input(tctx, src)
+				if err != nil {
+					t.Fatalf("could not process frame: %+v", err)
+				}
+			}
+			err = dev.OnStop(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /stop: %+v", err)
+			}
+			if got, want := dev.Skip, int64(1); got != want {
+				t.Fatalf("invalid number of skipped frames: got=%d, want=%d", got, want)
+			}
+
+			raw, err := ioutil.ReadFile(filepath.Join(dir, "run-0001."+tc.format))
+			if err != nil {
+				t.Fatalf("could not read NumPy file: %+v", err)
+			}
+			tc.check(t, raw)
+		})
+	}
+
+	dev := xdaq.NpyWriter{Schema: payload.Schema{{Name: "name", Type: "str"}}}
+	err := dev.OnConfig(tdaq.Context{Msg: log.NewMsgStream("npy", log.LvlError, ioutil.Discard)}, nil, tdaq.Frame{})
+	if err == nil {
+		t.Fatalf("expected an error configuring a NumPy writer of strings")
+	}
+}
+
+func TestTextSink(t *testing.T) {
+	sch := payload.Schema{{Name: "sensor", Type: "str"}, {Name: "temp", Type: "f32"}}
+	frame := func(sensor string, temp float32) tdaq.Frame {
+		buf := new(bytes.Buffer)
+		enc := tdaq.NewEncoder(buf)
+		enc.WriteStr(sensor)
+		enc.WriteF32(temp)
+		return tdaq.Frame{Body: buf.Bytes()}
+	}
+
+	for _, tc := range []struct {
+		format string
+		size   int64
+		want   []string
+	}{
+		{
+			format: xdaq.DumpCSV,
+			want:   []string{"sensor,temp\nt1,21.5\nt2,-3\nt1,22\n"},
+		},
+		{
+			format: xdaq.DumpCSV,
+			size:   18,
+			want:   []string{"sensor,temp\nt1,21.5\n", "sensor,temp\nt2,-3\n", "sensor,temp\nt1,22\n"},
+		},
+		{
+			format: xdaq.DumpJSONL,
+			size:   40,
+			want: []string{
+				`{"sensor":"t1","temp":21.5}` + "\n" + `{"sensor":"t2","temp":-3}` + "\n",
+				`{"sensor":"t1","temp":22}` + "\n",
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("%s-%d", tc.format, tc.size), func(t *testing.T) {
+			tctx := tdaq.Context{
+				Ctx: context.Background(),
+				Msg: log.NewMsgStream("text-sink", log.LvlError, ioutil.Discard),
+			}
+
+			dir, err := ioutil.TempDir("", "tdaq-text-sink-")
+			if err != nil {
+				t.Fatalf("could not create tmp dir: %+v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			dev := xdaq.TextSink{Schema: sch, Format: tc.format, Dir: dir, MaxSize: tc.size}
+			for _, cmd := range []func(tdaq.Context, *tdaq.Frame, tdaq.Frame) error{
+				dev.OnConfig, dev.OnInit, dev.OnStart,
+			} {
+				err := cmd(tctx, nil, tdaq.Frame{})
+				if err != nil {
+					t.Fatalf("could not run command: %+v", err)
+				}
+			}
+			for _, src := range []tdaq.Frame{frame("t1", 21.5), frame("t2", -3), frame("t1", 22)} {
+				err := dev.Input(tctx, src)
+				if err != nil {
+					t.Fatalf("could not write frame: %+v", err)
+				}
+			}
+			err = dev.OnStop(tctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /stop: %+v", err)
+			}
+
+			if got, want := len(dev.Files), len(tc.want); got != want {
+				t.Fatalf("invalid number of files: got=%d, want=%d", got, want)
+			}
+			for i, fname := range dev.Files {
+				raw, err := ioutil.ReadFile(fname)
+				if err != nil {
+					t.Fatalf("could not read file: %+v", err)
+				}
+				if got, want := string(raw), tc.want[i]; got != want {
+					t.Fatalf("invalid file %d:\ngot = %q\nwant= %q", i, got, want)
+				}
+			}
+			if got, want := dev.N, int64(3); got != want {
+				t.Fatalf("invalid number of records: got=%d, want=%d", got, want)
+			}
+
+			err = dev.Input(tctx, frame("t3", 0))
+			if err == nil {
+				t.Fatalf("expected an error writing to a closed text sink")
+			}
+		})
+	}
+}
+
+func TestEventPropagation(t *testing.T) {
+	ctx := tdaq.Context{
+		Ctx: context.Background(),
+		Msg: log.NewMsgStream("events", log.LvlError, ioutil.Discard),
+	}
+
+	i64 := func(v int64, id uint64, trace bool) tdaq.Frame {
+		body := make([]byte, 8)
+		binary.LittleEndian.PutUint64(body, uint64(v))
+		return tdaq.Frame{Type: tdaq.FrameData, Body: body, EventID: id, Trace: trace}
+	}
+
+	var (
+		wg  sync.WaitGroup
+		spl xdaq.Splitter
+		add xdaq.I64Adder
+	)
+	for _, dev := range []tdaq.Device{&spl, &add} {
+		err := dev.OnInit(ctx, nil, tdaq.Frame{})
+		if err != nil {
+			t.Fatalf("could not init device: %+v", err)
+		}
+	}
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		_ = spl.Input(ctx, i64(1, 42, true))
+	}()
+	go func() {
+		defer wg.Done()
+		_ = add.Left(ctx, i64(1, 7, false))
+	}()
+	go func() {
+		defer wg.Done()
+		_ = add.Right(ctx, i64(2, 7, true))
+	}()
+
+	dst := tdaq.Frame{Type: tdaq.FrameData}
+	err := spl.Left(ctx, &dst)
+	if err != nil {
+		t.Fatalf("could not split frame: %+v", err)
+	}
+	if got, want := dst, i64(1, 42, true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid split frame:\ngot = %#v\nwant= %#v", got, want)
+	}
+
+	dst = tdaq.Frame{Type: tdaq.FrameData}
+	err = add.Output(ctx, &dst)
+	if err != nil {
+		t.Fatalf("could not build frame: %+v", err)
+	}
+	if got, want := dst, i64(3, 7, true); !reflect.DeepEqual(got, want) {
+		t.Fatalf("invalid built frame:\ngot = %#v\nwant= %#v", got, want)
+	}
+	wg.Wait()
+}
+
+func TestSyncMonitor(t *testing.T) {
+	type input struct {
+		path string
+		trig uint64
+	}
+
+	for _, tc := range []struct {
+		name string
+		lag  int
+		ins  []input
+		n    int64
+		errs int64
+		lags int64
+	}{
+		{
+			name: "in-sync",
+			ins:  []input{{"/a", 1}, {"/b", 1}, {"/b", 2}, {"/a", 2}, {"/a", 3}},
+			n:    2,
+		},
+		{
+			name: "lost-frame",
+			ins:  []input{{"/a", 1}, {"/b", 1}, {"/a", 2}, {"/b", 3}, {"/a", 3}, {"/a", 4}, {"/b", 4}},
+			n:    3,
+			errs: 1,
+		},
+		{
+			name: "lag",
+			lag:  2,
+			ins:  []input{{"/a", 1}, {"/a", 2}, {"/a", 3}, {"/b", 2}, {"/b", 3}},
+			n:    2,
+			lags: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := tdaq.Context{
+				Ctx: context.Background(),
+				Msg: log.NewMsgStream(tc.name, log.LvlError+1, ioutil.Discard),
+			}
+			dev := &xdaq.SyncMonitor{Streams: []string{"/a", "/b"}, MaxLag: tc.lag}
+			err := dev.OnConfig(ctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /config: %+v", err)
+			}
+			err = dev.OnStart(ctx, nil, tdaq.Frame{})
+			if err != nil {
+				t.Fatalf("could not /start: %+v", err)
+			}
+
+			for _, in := range tc.ins {
+				err := dev.Input(ctx, tdaq.Frame{Path: in.path, EventID: in.trig})
+				if err != nil {
+					t.Fatalf("could not process input %v: %+v", in, err)
+				}
+			}
+
+			if got, want := dev.N, tc.n; got != want {
+				t.Fatalf("invalid number of matched triggers: got=%d, want=%d", got, want)
+			}
+			if got, want := dev.Errors, tc.errs; got != want {
+				t.Fatalf("invalid number of mismatched triggers: got=%d, want=%d", got, want)
+			}
+			if got, want := dev.Lags, tc.lags; got != want {
+				t.Fatalf("invalid number of lags: got=%d, want=%d", got, want)
+			}
+		})
+	}
+
+	ctx := tdaq.Context{Msg: log.NewMsgStream("sync", log.LvlError+1, ioutil.Discard)}
+	dev := &xdaq.SyncMonitor{Streams: []string{"/a"}}
+	if err := dev.OnConfig(ctx, nil, tdaq.Frame{}); err == nil {
+		t.Fatalf("expected an error for a single stream")
+	}
+	dev = &xdaq.SyncMonitor{Streams: []string{"/a", "/b"}}
+	_ = dev.OnConfig(ctx, nil, tdaq.Frame{})
+	_ = dev.OnStart(ctx, nil, tdaq.Frame{})
+	if err := dev.Input(ctx, tdaq.Frame{Path: "/c"}); err == nil {
+		t.Fatalf("expected an error for an unknown stream")
+	}
+}
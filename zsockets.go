@@ -7,10 +7,13 @@ package tdaq // import "github.com/go-daq/tdaq"
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-daq/tdaq/config"
 	"go.nanomsg.org/mangos/v3"
 
+	_ "go.nanomsg.org/mangos/v3/transport/inproc"
 	_ "go.nanomsg.org/mangos/v3/transport/ipc"
 	_ "go.nanomsg.org/mangos/v3/transport/tcp"
 )
@@ -19,6 +22,9 @@ type addrer interface {
 	Addr() string
 }
 
+// inprocs is the number of in-memory end-points created by this process.
+var inprocs uint64
+
 func makeAddr(cfg addrer) string {
 	switch cfg := cfg.(type) {
 	case config.RunCtl:
@@ -28,6 +34,10 @@ func makeAddr(cfg addrer) string {
 		switch {
 		case strings.HasPrefix(addr, "tcp://"):
 			return "tcp://:0"
+		case strings.HasPrefix(addr, "inproc://"):
+			// in-memory end-points are only reachable from within this
+			// process: make them unique.
+			return fmt.Sprintf("inproc://%s.%d", cfg.Name, atomic.AddUint64(&inprocs, 1))
 		case strings.HasPrefix(addr, "unix://"), strings.HasPrefix(addr, "ipc://"):
 			panic("unix:// not implemented")
 		default:
@@ -39,12 +49,22 @@ func makeAddr(cfg addrer) string {
 }
 
 func makeListener(fun func() (mangos.Socket, error), ep string) (mangos.Socket, mangos.Listener, error) {
+	return makeListenerOptions(fun, ep, config.SockOpts{})
+}
+
+func makeListenerOptions(fun func() (mangos.Socket, error), ep string, opts config.SockOpts) (mangos.Socket, mangos.Listener, error) {
 	sck, err := fun()
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not create socket %q: %w", ep, err)
 	}
 
-	lis, err := sck.NewListener(ep, nil)
+	err = setSockOptions(sck, opts)
+	if err != nil {
+		_ = sck.Close()
+		return nil, nil, fmt.Errorf("could not set socket options %q: %w", ep, err)
+	}
+
+	lis, err := sck.NewListener(ep, transportOptions(ep, opts))
 	if err != nil {
 		_ = sck.Close()
 		return nil, nil, fmt.Errorf("could not create listener %q: %w", ep, err)
@@ -59,3 +79,69 @@ func makeListener(fun func() (mangos.Socket, error), ep string) (mangos.Socket,
 
 	return sck, lis, nil
 }
+
+// setSockOptions sets the socket-level tuning options on the provided socket.
+func setSockOptions(sck mangos.Socket, opts config.SockOpts) error {
+	if opts.WriteQLen > 0 {
+		err := sck.SetOption(mangos.OptionWriteQLen, opts.WriteQLen)
+		if err != nil {
+			return fmt.Errorf("could not set write queue length: %w", err)
+		}
+	}
+	if opts.ReadQLen > 0 {
+		err := sck.SetOption(mangos.OptionReadQLen, opts.ReadQLen)
+		if err != nil {
+			return fmt.Errorf("could not set read queue length: %w", err)
+		}
+	}
+	return nil
+}
+
+// transportOptions returns the transport-level tuning options for
+// listeners and dialers of the provided address.
+// TCP options are ignored for non-TCP addresses.
+func transportOptions(addr string, opts config.SockOpts) map[string]interface{} {
+	if !strings.HasPrefix(addr, "tcp://") {
+		return nil
+	}
+
+	o := make(map[string]interface{})
+	if opts.NoDelay != nil {
+		o[mangos.OptionNoDelay] = *opts.NoDelay
+	}
+	if opts.KeepAlive != nil {
+		o[mangos.OptionKeepAlive] = *opts.KeepAlive
+	}
+	if opts.KeepAliveTime > 0 {
+		o[mangos.OptionKeepAliveTime] = time.Duration(opts.KeepAliveTime)
+	}
+	if len(o) == 0 {
+		return nil
+	}
+	return o
+}
+
+// setReconnect sets the initial and maximum delays between attempts at
+// redialing a dropped connection of the provided socket.
+// Zero values select the defaults.
+func setReconnect(sck mangos.Socket, min, max time.Duration) error {
+	if min <= 0 {
+		min = DefaultReconnectTime
+	}
+	if max <= 0 {
+		max = DefaultMaxReconnectTime
+	}
+	if max < min {
+		max = min
+	}
+
+	err := sck.SetOption(mangos.OptionReconnectTime, min)
+	if err != nil {
+		return fmt.Errorf("could not set reconnect time: %w", err)
+	}
+	err = sck.SetOption(mangos.OptionMaxReconnectTime, max)
+	if err != nil {
+		return fmt.Errorf("could not set maximum reconnect time: %w", err)
+	}
+	return nil
+}